@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"bluetooth/esp32"
+)
+
+// pairAgent answers pairing prompts when --pair is given; nil means
+// boards are used without pairing.
+var pairAgent esp32.PairingAgent
+
+// terminalAgent asks for passkeys on the terminal, or answers with a fixed
+// passkey from --passkey for unattended use.
+type terminalAgent struct {
+	// passkey is the fixed answer, or empty to prompt.
+	passkey string
+
+	// mu makes prompts from boards pairing concurrently take turns.
+	mu sync.Mutex
+}
+
+func (a *terminalAgent) Passkey(address string) (uint32, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	answer := a.passkey
+	if answer == "" {
+		msg.Printf("🔑 Enter the passkey %s displays: ", address)
+		line, err := readStdinLine()
+		if err != nil {
+			return 0, err
+		}
+		answer = line
+	}
+	return parsePasskey(answer)
+}
+
+func (a *terminalAgent) Confirm(address string, passkey uint32) (bool, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.passkey != "" {
+		want, err := parsePasskey(a.passkey)
+		return want == passkey, err
+	}
+	msg.Printf("🔢 Does %s display %06d? [y/N] ", address, passkey)
+	line, err := readStdinLine()
+	if err != nil {
+		return false, err
+	}
+	switch strings.ToLower(line) {
+	case "y", "yes":
+		return true, nil
+	}
+	return false, nil
+}
+
+// parsePasskey parses a 6-digit passkey.
+func parsePasskey(s string) (uint32, error) {
+	n, err := strconv.ParseUint(s, 10, 32)
+	if err != nil || n > 999999 {
+		return 0, fmt.Errorf("invalid passkey %q (want up to 6 digits)", s)
+	}
+	return uint32(n), nil
+}
+
+// readStdinLine reads a line from stdin a byte at a time, so nothing is
+// buffered away from the REPL that may read stdin afterwards.
+func readStdinLine() (string, error) {
+	var line []byte
+	buf := make([]byte, 1)
+	for {
+		n, err := os.Stdin.Read(buf)
+		if n == 1 && buf[0] == '\n' {
+			break
+		}
+		line = append(line, buf[:n]...)
+		if errors.Is(err, io.EOF) && len(line) > 0 {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+	return strings.TrimSpace(string(line)), nil
+}
+
+// pairClient pairs with a freshly connected board if --pair was given.
+// Bonded boards are encrypted by the stack on reconnect, so this only
+// prompts the first time.
+func pairClient(ctx context.Context, prefix string, client *esp32.Client) error {
+	if pairAgent == nil {
+		return nil
+	}
+	if paired, err := client.Paired(); err == nil && paired {
+		return nil
+	}
+	msg.Printf("🔐 %sPairing with %s...\n", prefix, client.Name)
+	if err := client.Pair(ctx, pairAgent); err != nil {
+		return fmt.Errorf("pairing with %s failed: %w", client.Name, err)
+	}
+	msg.Printf("✅ %sPaired and bonded with %s\n", prefix, client.Name)
+	return nil
+}
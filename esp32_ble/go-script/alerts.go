@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"slices"
+	"strconv"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+
+	"bluetooth/esp32"
+	"bluetooth/pinmodel"
+	"bluetooth/rules"
+)
+
+// alerts, if set by --alert or the profile, evaluates threshold rules
+// against polled readings and runs their actions.
+var alerts *rules.Engine
+
+// alertMQTT publishes the alerts of rules with mqtt actions; it is
+// connected only if a rule has one.
+var alertMQTT mqtt.Client
+
+// parseAlerts parses alert rules, exiting on error.
+func parseAlerts(exprs []string) []rules.Rule {
+	var ruleSet []rules.Rule
+	for _, expr := range exprs {
+		rule, err := rules.ParseWith(expr, profile.Channels)
+		if err != nil {
+			msg.Printf("❌ --alert: %v\n", err)
+			os.Exit(1)
+		}
+		for _, a := range rule.Actions {
+			if a.Kind != rules.Write {
+				continue
+			}
+			for _, problem := range pinModel.Check(a.Write.PinNum, pinmodel.Output) {
+				msg.Printf("⚠️  Alert %q: %s\n", rule.Expr, problem)
+			}
+		}
+		ruleSet = append(ruleSet, rule)
+	}
+	return ruleSet
+}
+
+// startAlerts sets up the engine for ruleSet, connecting to broker if a
+// rule publishes to MQTT, exiting if it can't.
+func startAlerts(ruleSet []rules.Rule, broker string) {
+	alerts = rules.NewEngine(ruleSet)
+	msg.Printf("🚨 Watching %d alert rule(s)\n", len(ruleSet))
+	publishes := slices.ContainsFunc(ruleSet, func(r rules.Rule) bool {
+		return slices.ContainsFunc(r.Actions, func(a rules.Action) bool { return a.Kind == rules.MQTT })
+	})
+	if !publishes {
+		return
+	}
+	msg.Printf("📡 Connecting to MQTT broker %s for alerts...\n", broker)
+	opts := mqtt.NewClientOptions().
+		AddBroker(broker).
+		SetClientID(fmt.Sprintf("esp32-alerts-%d", os.Getpid())).
+		SetAutoReconnect(true)
+	alertMQTT = mqtt.NewClient(opts)
+	if token := alertMQTT.Connect(); !token.WaitTimeout(10*time.Second) || token.Error() != nil {
+		msg.Printf("❌ Failed to connect to MQTT broker: %v\n", token.Error())
+		os.Exit(1)
+	}
+}
+
+// evaluateAlerts feeds readings through the alert rules, reporting each
+// that fires prefixed with prefix and running its actions. Pin writes go
+// to client; a failed action is reported without stopping the others.
+func evaluateAlerts(ctx context.Context, prefix string, client *esp32.Client, readings []esp32.Reading) {
+	for _, reading := range readings {
+		for _, alert := range alerts.Evaluate(reading) {
+			if reading.Kind == esp32.KindAnomaly {
+				msg.Printf("🚨 %s%s (pin %d scored %dσ)\n", prefix, alert.Rule.Expr, reading.Pin, reading.Value)
+			} else {
+				msg.Printf("🚨 %s%s (pin %d = %d)\n", prefix, alert.Rule.Expr, reading.Pin, reading.Value)
+			}
+			for _, action := range alert.Rule.Actions {
+				if err := runAction(ctx, client, alert, action); err != nil {
+					msg.Printf("⚠️  %s%s: %s failed: %v\n", prefix, alert.Rule.Expr, action.Kind, err)
+				}
+			}
+		}
+	}
+}
+
+// alertMessage is the JSON published by mqtt actions. Kind is
+// esp32.KindAnomaly for rules on anomaly scores, with the score in Value.
+type alertMessage struct {
+	Rule    string    `json:"rule"`
+	Device  string    `json:"device"`
+	Address string    `json:"address"`
+	Kind    string    `json:"kind,omitempty"`
+	Pin     uint8     `json:"pin"`
+	Value   int       `json:"value"`
+	Time    time.Time `json:"time"`
+}
+
+// runAction runs one action of a fired alert. Exec commands run to
+// completion before polling continues, with the reading in ESP32_RULE,
+// ESP32_DEVICE, ESP32_PIN and ESP32_VALUE, the score for rules on anomaly
+// scores.
+func runAction(ctx context.Context, client *esp32.Client, alert rules.Alert, action rules.Action) error {
+	r := alert.Reading
+	switch action.Kind {
+	case rules.Exec:
+		shell, flag := "sh", "-c"
+		if runtime.GOOS == "windows" {
+			shell, flag = "cmd", "/C"
+		}
+		cmd := exec.CommandContext(ctx, shell, flag, action.Command)
+		cmd.Env = append(os.Environ(),
+			"ESP32_RULE="+alert.Rule.Expr,
+			"ESP32_DEVICE="+client.Name,
+			"ESP32_PIN="+strconv.Itoa(int(r.Pin)),
+			"ESP32_VALUE="+strconv.Itoa(r.Value),
+		)
+		cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+		return cmd.Run()
+	case rules.Write:
+		msg.Printf("✍️  Writing pin %d = %d\n", action.Write.PinNum, action.Write.State)
+		return client.WritePins(ctx, []esp32.PinWrite{action.Write})
+	case rules.MQTT:
+		payload, err := json.Marshal(alertMessage{
+			Rule: alert.Rule.Expr, Device: client.Name, Address: client.Address,
+			Kind: r.Kind, Pin: r.Pin, Value: r.Value, Time: r.Time,
+		})
+		if err != nil {
+			return err
+		}
+		token := alertMQTT.Publish(action.Topic, 1, false, payload)
+		if !token.WaitTimeout(10 * time.Second) {
+			return fmt.Errorf("publishing to %s timed out", action.Topic)
+		}
+		return token.Error()
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"os"
+	"time"
+
+	"bluetooth/anomaly"
+	"bluetooth/esp32"
+)
+
+// anomalies, if the profile configures anomaly detection, scores polled
+// readings against their channels' baselines, feeding the scores to the
+// alert rules.
+var anomalies *anomaly.Tracker
+
+// baselineFile, if set, is where anomalies' baselines are kept between
+// runs; baselinesSaved is when they were last written to it.
+var (
+	baselineFile   string
+	baselinesSaved time.Time
+)
+
+// baselineSaveInterval is how often learned baselines are saved.
+const baselineSaveInterval = time.Minute
+
+// startAnomalies sets up anomaly detection with d, carrying over the
+// baselines in file if it is set and exists, exiting if it can't be read.
+func startAnomalies(d anomaly.Detector, file string) {
+	anomalies = anomaly.NewTracker(d)
+	baselineFile = file
+	msg.Printf("📈 Learning the baselines of %d channel(s)\n", len(d.Pins))
+	if file == "" {
+		return
+	}
+	data, err := os.ReadFile(file)
+	if errors.Is(err, fs.ErrNotExist) {
+		return
+	}
+	if err == nil {
+		err = anomalies.Load(bytes.NewReader(data))
+	}
+	if err != nil {
+		msg.Printf("❌ Failed to read baseline file: %v\n", err)
+		os.Exit(1)
+	}
+	msg.Printf("📈 Loaded %d baseline(s) from %s\n", anomalies.Len(), file)
+}
+
+// scoreAnomalies feeds readings through the anomaly tracker, reporting
+// channels becoming anomalous or normal prefixed with prefix, and returns
+// the readings' scores. Baselines are saved every baselineSaveInterval.
+func scoreAnomalies(prefix string, readings []esp32.Reading) []esp32.Reading {
+	var scores []esp32.Reading
+	for _, reading := range readings {
+		scored, events := anomalies.Update(reading)
+		scores = append(scores, scored...)
+		for _, e := range events {
+			printAnomalyEvent(prefix, e)
+		}
+	}
+	if baselineFile != "" && time.Since(baselinesSaved) >= baselineSaveInterval {
+		if err := saveBaselines(); err != nil {
+			msg.Printf("⚠️  Failed to save baselines: %v\n", err)
+		}
+		baselinesSaved = time.Now()
+	}
+	return scores
+}
+
+// saveBaselines replaces the baseline file with the baselines learned so
+// far, by renaming, so a crash mid-write leaves the old file.
+func saveBaselines() error {
+	var buf bytes.Buffer
+	if err := anomalies.Save(&buf); err != nil {
+		return err
+	}
+	tmp := baselineFile + ".tmp"
+	if err := os.WriteFile(tmp, buf.Bytes(), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, baselineFile)
+}
+
+// printAnomalyEvent reports a channel becoming anomalous or going back to
+// normal.
+func printAnomalyEvent(prefix string, e anomaly.Event) {
+	switch e.Kind {
+	case anomaly.Anomalous:
+		msg.Printf("📈 %sPin %s anomalous: %d is %.1fσ from its usual %.0f ± %.0f\n", prefix, pinLabel(e.Reading), e.Reading.Value, e.Score, e.Baseline.Mean, e.Baseline.StdDev())
+	case anomaly.Normal:
+		msg.Printf("📈 %sPin %s back to normal after %v\n", prefix, pinLabel(e.Reading), e.Duration.Round(time.Second))
+	}
+}
@@ -0,0 +1,241 @@
+// Package anomaly learns the usual readings of each channel, separately
+// for each time of day, and scores how far new readings are from them,
+// so failing sensors and unusual conditions show up without a fixed
+// threshold for every channel.
+package anomaly
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"strings"
+	"time"
+
+	"bluetooth/esp32"
+)
+
+// Detector is the channels to learn and how.
+//
+// Each channel's day is cut into Slots equal slots, each with its own
+// baseline: a moving mean and variance of the readings falling in it.
+// The first Window readings of a slot are averaged evenly; after that,
+// each reading moves the baseline by 1/Window of the way, so a lasting
+// change is learned as the new normal over a few Window readings. A
+// reading's score is its distance from the slot's mean in standard
+// deviations, but only once the slot has seen Warmup readings.
+type Detector struct {
+	Pins      []uint8
+	Slots     int
+	Window    int
+	Warmup    int
+	Threshold float64
+}
+
+// Validate reports a detector that can't work.
+func (d Detector) Validate() error {
+	switch {
+	case len(d.Pins) == 0:
+		return errors.New("no pins to learn")
+	case d.Slots < 1 || d.Slots > 24*60:
+		return fmt.Errorf("slots must be 1 to %d, not %d", 24*60, d.Slots)
+	case d.Window < 1 || d.Warmup < 1:
+		return errors.New("window and warmup must be at least 1")
+	case d.Threshold <= 0:
+		return errors.New("threshold must be positive")
+	}
+	return nil
+}
+
+// slot returns the slot of the day t falls in, in local time.
+func (d Detector) slot(t time.Time) int {
+	t = t.Local()
+	minute := t.Hour()*60 + t.Minute()
+	return minute * d.Slots / (24 * 60)
+}
+
+// minSpread is the smallest standard deviation scores are taken against,
+// one step of the ADC, so a channel that has never moved doesn't score
+// its first step as infinitely unusual.
+const minSpread = 1
+
+// Baseline is what a slot has learned of a channel.
+type Baseline struct {
+	Count    int     `json:"count"`
+	Mean     float64 `json:"mean"`
+	Variance float64 `json:"variance"`
+}
+
+// StdDev is the baseline's standard deviation, at least minSpread.
+func (b Baseline) StdDev() float64 {
+	return math.Max(math.Sqrt(b.Variance), minSpread)
+}
+
+// learn moves the baseline toward v.
+func (b *Baseline) learn(v float64, window int) {
+	b.Count++
+	alpha := 1 / float64(min(b.Count, window))
+	diff := v - b.Mean
+	b.Mean += alpha * diff
+	b.Variance = (1 - alpha) * (b.Variance + alpha*diff*diff)
+}
+
+// Kind is the kind of an Event.
+type Kind int
+
+const (
+	// Anomalous is a channel's score reaching the threshold.
+	Anomalous Kind = iota + 1
+	// Normal is its score falling back below it.
+	Normal
+)
+
+func (k Kind) String() string {
+	switch k {
+	case Anomalous:
+		return "anomalous"
+	case Normal:
+		return "normal"
+	}
+	return fmt.Sprintf("Kind(%d)", int(k))
+}
+
+// Event is a channel becoming anomalous or going back to normal. Baseline
+// is what the reading was scored against and, for Normal, Duration how
+// long the channel was anomalous.
+type Event struct {
+	Kind     Kind
+	Reading  esp32.Reading
+	Score    float64
+	Baseline Baseline
+	Duration time.Duration
+}
+
+// key identifies one slot of one channel of one board, by the board's
+// ID so its baselines carry on across a change of address.
+type key struct {
+	Device string `json:"device"`
+	ID     string `json:"id"`
+	Pin    uint8  `json:"pin"`
+	Slot   int    `json:"slot"`
+}
+
+// channel identifies one channel of one board.
+type channel struct {
+	device, id string
+	pin        uint8
+}
+
+// Tracker runs a detector against readings. Time is taken from the
+// readings, so recorded data replays with its original timing. Boards
+// are tracked separately.
+type Tracker struct {
+	detector  Detector
+	baselines map[key]*Baseline
+	// since is when each anomalous channel became so.
+	since map[channel]time.Time
+}
+
+// NewTracker returns a tracker for d.
+func NewTracker(d Detector) *Tracker {
+	return &Tracker{detector: d, baselines: map[key]*Baseline{}, since: map[channel]time.Time{}}
+}
+
+// Update scores a reading against its slot's baseline, learns it and
+// returns the score, as a reading of kind esp32.KindAnomaly in whole
+// standard deviations for alert rules, and the events it causes. There
+// is no score for readings of other pins or kinds, or before the slot
+// has warmed up.
+func (t *Tracker) Update(r esp32.Reading) ([]esp32.Reading, []Event) {
+	if r.Kind != "" || !slices.Contains(t.detector.Pins, r.Pin) {
+		return nil, nil
+	}
+	k := key{r.Device, r.ID, r.Pin, t.detector.slot(r.Time)}
+	b, ok := t.baselines[k]
+	if !ok {
+		b = &Baseline{}
+		t.baselines[k] = b
+	}
+	before := *b
+	b.learn(float64(r.Value), t.detector.Window)
+	if before.Count < t.detector.Warmup {
+		return nil, nil
+	}
+
+	score := math.Abs(float64(r.Value)-before.Mean) / before.StdDev()
+	scored := r
+	scored.Kind, scored.Value = esp32.KindAnomaly, int(score)
+
+	c := channel{r.Device, r.ID, r.Pin}
+	since, anomalous := t.since[c]
+	e := Event{Reading: r, Score: score, Baseline: before}
+	switch {
+	case !anomalous && score >= t.detector.Threshold:
+		t.since[c] = r.Time
+		e.Kind = Anomalous
+	case anomalous && score < t.detector.Threshold:
+		delete(t.since, c)
+		e.Kind, e.Duration = Normal, r.Time.Sub(since)
+	default:
+		return []esp32.Reading{scored}, nil
+	}
+	return []esp32.Reading{scored}, []Event{e}
+}
+
+// Len returns how many slots of channels have a baseline.
+func (t *Tracker) Len() int {
+	return len(t.baselines)
+}
+
+// saved is a slot's baseline as saved.
+type saved struct {
+	key
+	// Address is the board's ID in baselines saved before boards had
+	// IDs, which were their addresses.
+	Address string `json:"address,omitempty"`
+	Baseline
+}
+
+// Save writes the baselines learned so far as JSON, for Load to carry
+// them over to the next run: learning a day's slots takes days.
+func (t *Tracker) Save(w io.Writer) error {
+	list := make([]saved, 0, len(t.baselines))
+	for k, b := range t.baselines {
+		list = append(list, saved{key: k, Baseline: *b})
+	}
+	slices.SortFunc(list, func(a, b saved) int {
+		return cmp.Or(
+			strings.Compare(a.Device, b.Device),
+			strings.Compare(a.ID, b.ID),
+			cmp.Compare(a.Pin, b.Pin),
+			cmp.Compare(a.Slot, b.Slot),
+		)
+	})
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(list)
+}
+
+// Load reads baselines written by Save, replacing those of the same
+// slots. Slots the detector doesn't have, as after changing Slots, are
+// dropped.
+func (t *Tracker) Load(r io.Reader) error {
+	var list []saved
+	if err := json.NewDecoder(r).Decode(&list); err != nil {
+		return err
+	}
+	for _, s := range list {
+		if s.Slot < 0 || s.Slot >= t.detector.Slots {
+			continue
+		}
+		if s.ID == "" {
+			s.ID = s.Address
+		}
+		b := s.Baseline
+		t.baselines[s.key] = &b
+	}
+	return nil
+}
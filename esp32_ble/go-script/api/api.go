@@ -0,0 +1,518 @@
+// Package api serves a board over HTTP, so several local processes can
+// share the one BLE connection a host can hold to it.
+//
+// Endpoints:
+//
+//	GET  /pins    read the regular pins
+//	GET  /adc     read the ADC channels
+//	POST /pins    write pins; body is the firmware's pin_writes JSON, as
+//	              application/json
+//	GET  /stream  notifications as server-sent events; ?kind=pins,
+//	              ?kind=adc or ?kind=unknown picks one kind
+//	GET  /ws      the same notifications over a WebSocket, as
+//	              {"kind", "data"} messages; pin_writes JSON sent on it
+//	              is written to the board
+//
+// Readings are JSON arrays of {"time", "device", "address", "pin",
+// "value"}, with "channel" too for a pin the profile names. Errors are
+// {"error": message}, with 503 while the board is not connected;
+// requests for a board suspended for being idle wait for it to be
+// reconnected instead. A frame the profile's decoder doesn't recognize
+// fails a read with 502 and an UnknownPayload body, and is streamed as
+// an "unknown" event carrying one; so is one a lenient profile salvaged,
+// whose readings are served as usual.
+//
+// A virtual device, from NewVirtual, serves the same endpoints for
+// channels of several boards. View serves a read-only subset of them,
+// for some pins, to share.
+//
+// Concurrent reads of the same characteristic share one BLE read, and a
+// read may be answered from a recent one: as recent as the request's
+// Cache-Control max-age in seconds, else the profile's ReadMaxAge for
+// the characteristic, else SetReadMaxAge's. Cache-Control: no-cache
+// always reads the board.
+package api
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"bluetooth/esp32"
+)
+
+// Event kinds sent on /stream.
+const (
+	KindPins    = "pins"
+	KindADC     = "adc"
+	KindUnknown = "unknown"
+)
+
+// requestTimeout bounds the board operation behind one request.
+const requestTimeout = 10 * time.Second
+
+// wakeTimeout bounds how long a request waits for a suspended board to
+// be reconnected.
+const wakeTimeout = 45 * time.Second
+
+// errNotConnected is returned while no board is attached.
+var errNotConnected = errors.New("board not connected")
+
+// errNoChannel is returned for a write to a pin a virtual device
+// doesn't have.
+var errNoChannel = errors.New("no such channel")
+
+// Reading is the JSON form of an esp32.Reading.
+type Reading struct {
+	Time    time.Time `json:"time"`
+	Device  string    `json:"device"`
+	Address string    `json:"address"`
+	ID      string    `json:"id,omitempty"`
+	Pin     uint8     `json:"pin"`
+	Channel string    `json:"channel,omitempty"`
+	Value   int       `json:"value"`
+}
+
+// UnknownPayload is the JSON form of an esp32.UnknownPayloadError, the
+// payload in hex.
+type UnknownPayload struct {
+	Time    time.Time `json:"time"`
+	Device  string    `json:"device"`
+	Address string    `json:"address"`
+	UUID    string    `json:"uuid"`
+	Payload string    `json:"payload"`
+	Error   string    `json:"error"`
+	// Salvaged is how many readings a lenient profile kept.
+	Salvaged int `json:"salvaged"`
+}
+
+func unknownPayload(client *esp32.Client, err *esp32.UnknownPayloadError) UnknownPayload {
+	return UnknownPayload{
+		Time:     time.Now(),
+		Device:   client.Name,
+		Address:  client.Address,
+		UUID:     err.UUID,
+		Payload:  hex.EncodeToString(err.Payload),
+		Error:    err.Error(),
+		Salvaged: err.Salvaged,
+	}
+}
+
+// event is one notification sent to streams: readings, or an
+// UnknownPayload.
+type event struct {
+	kind string
+	data any
+}
+
+// stream is one /stream client. Events are dropped rather than queued
+// when it falls behind, so a slow client can't hold up the others.
+type stream struct {
+	kind   string
+	events chan event
+}
+
+// Server is an http.Handler for one board. The board is attached once
+// connected and detached when its link drops, so the server outlives
+// reconnects. A board suspended for being idle is woken by the next
+// request, which waits for it to be attached again.
+type Server struct {
+	mux  *http.ServeMux
+	wake chan struct{}
+
+	mu        sync.Mutex
+	client    *esp32.Client
+	streams   map[*stream]struct{}
+	lastUsed  time.Time
+	suspended bool
+	attach    chan struct{} // closed by the next Attach
+	maxAge    time.Duration
+	observers []func(kind string, readings []esp32.Reading)
+
+	// name and channels are set for a virtual device; see NewVirtual.
+	name     string
+	channels []Channel
+}
+
+// New returns a server with no board attached.
+func New() *Server {
+	s := &Server{
+		mux:     http.NewServeMux(),
+		wake:    make(chan struct{}, 1),
+		streams: map[*stream]struct{}{},
+		attach:  make(chan struct{}),
+	}
+	s.mux.HandleFunc("GET /pins", s.handleRead(KindPins))
+	s.mux.HandleFunc("GET /adc", s.handleRead(KindADC))
+	s.mux.HandleFunc("POST /pins", s.handleWrite)
+	s.mux.HandleFunc("GET /stream", s.handleStream)
+	s.mux.HandleFunc("GET /ws", s.handleWebSocket)
+	return s
+}
+
+// Observe calls fn with every pin (KindPins) and ADC (KindADC)
+// notification of the attached board, so other front-ends, such as an
+// MQTT bridge or metrics, can share the server's subscriptions: a board's
+// characteristic only takes one. Call it before the first Attach. fn runs
+// on the board's notification goroutines and mustn't modify readings.
+func (s *Server) Observe(fn func(kind string, readings []esp32.Reading)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.observers = append(s.observers, fn)
+}
+
+// Attach serves client and subscribes to its pin and ADC notifications
+// for /stream.
+func (s *Server) Attach(client *esp32.Client) error {
+	if err := client.SubscribePins(s.broadcast(KindPins)); err != nil {
+		return fmt.Errorf("subscribing to pin data: %w", err)
+	}
+	if err := client.SubscribeADC(s.broadcast(KindADC)); err != nil {
+		return fmt.Errorf("subscribing to ADC data: %w", err)
+	}
+	s.mu.Lock()
+	s.client = client
+	s.suspended = false
+	s.lastUsed = time.Now()
+	close(s.attach)
+	s.attach = make(chan struct{})
+	s.mu.Unlock()
+	return nil
+}
+
+// Detach stops serving the attached board; requests fail with 503 until
+// the next Attach. Open streams stay open.
+func (s *Server) Detach() {
+	s.mu.Lock()
+	s.client = nil
+	s.mu.Unlock()
+}
+
+// Suspend detaches the board for being idle. Until the next Attach,
+// requests and new streams signal Wake, and requests wait for the board
+// instead of failing.
+func (s *Server) Suspend() {
+	s.mu.Lock()
+	s.client = nil
+	s.suspended = true
+	s.mu.Unlock()
+}
+
+// Wake receives when a suspended board is wanted again.
+func (s *Server) Wake() <-chan struct{} {
+	return s.wake
+}
+
+// IdleFor returns how long since the board was last read or written, or
+// zero while a stream is open.
+func (s *Server) IdleFor() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.streams) > 0 {
+		return 0
+	}
+	return time.Since(s.lastUsed)
+}
+
+// Status returns "connected" while a board is attached, "idle" while it
+// is suspended and "disconnected" otherwise, as while it reconnects.
+func (s *Server) Status() string {
+	if s.channels != nil {
+		return s.virtualStatus()
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case s.client != nil:
+		return "connected"
+	case s.suspended:
+		return "idle"
+	}
+	return "disconnected"
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// attached returns the board to serve a request with, waking a suspended
+// one and waiting up to wakeTimeout, or until ctx is done, for it.
+func (s *Server) attached(ctx context.Context) (*esp32.Client, error) {
+	s.mu.Lock()
+	if s.client != nil || !s.suspended {
+		defer s.mu.Unlock()
+		if s.client == nil {
+			return nil, errNotConnected
+		}
+		s.lastUsed = time.Now()
+		return s.client, nil
+	}
+	attach := s.attach
+	s.mu.Unlock()
+
+	s.signalWake()
+	timer := time.NewTimer(wakeTimeout)
+	defer timer.Stop()
+	select {
+	case <-attach:
+		return s.attached(ctx)
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+	return nil, errNotConnected
+}
+
+// signalWake signals Wake without blocking; one pending signal is enough.
+func (s *Server) signalWake() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// SetReadMaxAge lets a read be answered with readings the board gave
+// another read within maxAge, instead of reading it again. Concurrent
+// reads of a characteristic share one BLE read whatever maxAge is.
+func (s *Server) SetReadMaxAge(maxAge time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.maxAge = maxAge
+}
+
+// handleRead serves reads of kind, KindPins or KindADC.
+func (s *Server) handleRead(kind string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if readings, ok := s.read(w, r, kind); ok {
+			writeJSON(w, http.StatusOK, convert(readings))
+		}
+	}
+}
+
+// read reads the characteristic of kind for r, answering r with the
+// error and returning false if that fails.
+func (s *Server) read(w http.ResponseWriter, r *http.Request, kind string) ([]esp32.Reading, bool) {
+	if s.channels != nil {
+		return s.readVirtual(w, r, kind)
+	}
+	client, err := s.attached(r.Context())
+	if err != nil {
+		writeError(w, http.StatusServiceUnavailable, err)
+		return nil, false
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), requestTimeout)
+	defer cancel()
+	char := client.Profile().PinOutputUUID
+	if kind == KindADC {
+		char = client.Profile().ADCOutputUUID
+	}
+	s.mu.Lock()
+	maxAge := s.maxAge
+	s.mu.Unlock()
+	if d, ok := client.Profile().ReadMaxAge[char]; ok {
+		maxAge = d
+	}
+	readings, err := client.ReadShared(ctx, char, requestMaxAge(r, maxAge))
+	var unknown *esp32.UnknownPayloadError
+	if errors.As(err, &unknown) {
+		writeJSON(w, http.StatusBadGateway, unknownPayload(client, unknown))
+		return nil, false
+	}
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return nil, false
+	}
+	return readings, true
+}
+
+// requestMaxAge returns how old a reading r will accept: none with
+// Cache-Control: no-cache, the seconds of max-age, or else maxAge.
+func requestMaxAge(r *http.Request, maxAge time.Duration) time.Duration {
+	for _, directive := range strings.Split(r.Header.Get("Cache-Control"), ",") {
+		directive = strings.ToLower(strings.TrimSpace(directive))
+		if directive == "no-cache" || directive == "no-store" {
+			return 0
+		}
+		if v, ok := strings.CutPrefix(directive, "max-age="); ok {
+			if seconds, err := strconv.Atoi(v); err == nil && seconds >= 0 {
+				maxAge = time.Duration(seconds) * time.Second
+			}
+		}
+	}
+	return maxAge
+}
+
+func (s *Server) handleWrite(w http.ResponseWriter, r *http.Request) {
+	// Requiring JSON keeps other sites' pages from writing pins with a
+	// form, as in the profile editor.
+	if mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mt != "application/json" {
+		writeError(w, http.StatusUnsupportedMediaType, errors.New("want application/json"))
+		return
+	}
+	var req esp32.PinRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid pin_writes JSON: %w", err))
+		return
+	}
+	if len(req.PinWrites) == 0 {
+		writeError(w, http.StatusBadRequest, errors.New("no pin_writes given"))
+		return
+	}
+	if err := s.write(r.Context(), req.PinWrites); err != nil {
+		writeError(w, writeStatus(err), err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// streamKind returns the kind of events r asks to stream, or an error if
+// it isn't one.
+func streamKind(r *http.Request) (string, error) {
+	kind := r.URL.Query().Get("kind")
+	if kind != "" && kind != KindPins && kind != KindADC && kind != KindUnknown {
+		return "", fmt.Errorf("unknown kind %q (want %s, %s or %s)", kind, KindPins, KindADC, KindUnknown)
+	}
+	return kind, nil
+}
+
+// openStream registers a stream of events of kind, all if empty, waking
+// a suspended board. The returned function unregisters it.
+func (s *Server) openStream(kind string) (*stream, func()) {
+	st := &stream{kind: kind, events: make(chan event, 16)}
+	s.mu.Lock()
+	s.streams[st] = struct{}{}
+	if s.suspended {
+		s.signalWake()
+	}
+	s.mu.Unlock()
+	// A virtual device's stream holds one open on each board, so they
+	// are woken and kept from going idle too.
+	var closeSources []func()
+	for _, src := range s.sources("") {
+		_, closeSource := src.openStream(kind)
+		closeSources = append(closeSources, closeSource)
+	}
+	return st, func() {
+		s.mu.Lock()
+		delete(s.streams, st)
+		s.lastUsed = time.Now()
+		s.mu.Unlock()
+		for _, closeSource := range closeSources {
+			closeSource()
+		}
+	}
+}
+
+func (s *Server) handleStream(w http.ResponseWriter, r *http.Request) {
+	s.serveStream(w, r, nil)
+}
+
+// serveStream streams events for r as server-sent events, passing each
+// through keep, if set, which may change it and drops it by returning
+// false.
+func (s *Server) serveStream(w http.ResponseWriter, r *http.Request, keep func(*event) bool) {
+	kind, err := streamKind(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, errors.New("streaming unsupported"))
+		return
+	}
+
+	st, closeStream := s.openStream(kind)
+	defer closeStream()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case ev := <-st.events:
+			if keep != nil && !keep(&ev) {
+				continue
+			}
+			data, _ := json.Marshal(ev.data)
+			if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", ev.kind, data); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// broadcast returns a notification callback sending readings of kind to
+// every stream that wants them.
+func (s *Server) broadcast(kind string) func([]esp32.Reading) {
+	return func(readings []esp32.Reading) {
+		s.mu.Lock()
+		observers := s.observers
+		s.mu.Unlock()
+		for _, fn := range observers {
+			fn(kind, readings)
+		}
+		s.send(event{kind: kind, data: convert(readings)})
+	}
+}
+
+// ReportUnknownPayload streams a notification of client's that its
+// decoder rejected, as reported to a session or the client's unknown
+// payload handler.
+func (s *Server) ReportUnknownPayload(client *esp32.Client, err *esp32.UnknownPayloadError) {
+	s.send(event{kind: KindUnknown, data: unknownPayload(client, err)})
+}
+
+func (s *Server) send(ev event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for st := range s.streams {
+		if st.kind != "" && st.kind != ev.kind {
+			continue
+		}
+		select {
+		case st.events <- ev:
+		default:
+		}
+	}
+}
+
+func convert(readings []esp32.Reading) []Reading {
+	out := make([]Reading, 0, len(readings))
+	for _, r := range readings {
+		out = append(out, Reading{Time: r.Time, Device: r.Device, Address: r.Address, ID: r.ID, Pin: r.Pin, Channel: r.Channel, Value: r.Value})
+	}
+	return out
+}
+
+// writeStatus returns the status to reply to a failed write with.
+func writeStatus(err error) int {
+	switch {
+	case errors.Is(err, errNotConnected):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, errNoChannel):
+		return http.StatusBadRequest
+	}
+	return http.StatusBadGateway
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,55 @@
+package api
+
+import (
+	"net/http"
+	"slices"
+)
+
+// View returns a read-only handler for s's board, to share a live view
+// of it without control of it: GET /pins, /adc and /stream as s serves
+// them, with only the readings of pins, all of them if empty. It serves
+// nothing that writes, nor the unknown payloads, which may carry what
+// the pins leave out.
+func (s *Server) View(pins []uint8) http.Handler {
+	v := &view{s: s, pins: slices.Clone(pins)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /pins", v.handleRead(KindPins))
+	mux.HandleFunc("GET /adc", v.handleRead(KindADC))
+	mux.HandleFunc("GET /stream", func(w http.ResponseWriter, r *http.Request) {
+		s.serveStream(w, r, v.keep)
+	})
+	return mux
+}
+
+type view struct {
+	s    *Server
+	pins []uint8
+}
+
+func (v *view) handleRead(kind string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if readings, ok := v.s.read(w, r, kind); ok {
+			writeJSON(w, http.StatusOK, v.filter(convert(readings)))
+		}
+	}
+}
+
+// filter returns the readings of the view's pins.
+func (v *view) filter(readings []Reading) []Reading {
+	if len(v.pins) == 0 {
+		return readings
+	}
+	return slices.DeleteFunc(readings, func(r Reading) bool { return !slices.Contains(v.pins, r.Pin) })
+}
+
+// keep passes on the readings events with readings of the view's pins,
+// cut down to those.
+func (v *view) keep(ev *event) bool {
+	readings, ok := ev.data.([]Reading)
+	if !ok {
+		return false
+	}
+	// Other streams share the event's readings.
+	ev.data = v.filter(slices.Clone(readings))
+	return len(ev.data.([]Reading)) > 0
+}
@@ -0,0 +1,125 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"slices"
+
+	"bluetooth/esp32"
+)
+
+// Channel is one channel of a virtual device: pin Pin of the board
+// Source serves, of kind KindPins or KindADC, presented as the virtual
+// device's pin As.
+type Channel struct {
+	Source *Server
+	Kind   string
+	Pin    uint8
+	As     uint8
+}
+
+// NewVirtual returns a server for the virtual device name, made of
+// channels of the boards other servers serve, such as a weather station
+// combining an outdoor and an indoor board. It serves the endpoints a
+// board's server does: readings of the channels are renumbered and
+// given the virtual device's name, with no address, and pin writes go
+// to the boards of the pins channels they name, failing with 400 for a
+// pin it doesn't have. Its Observe functions see its notifications. Call
+// it before the sources' first Attach.
+func NewVirtual(name string, channels []Channel) *Server {
+	s := New()
+	s.name, s.channels = name, slices.Clone(channels)
+	for _, src := range s.sources("") {
+		src.Observe(func(kind string, readings []esp32.Reading) {
+			if mapped := s.mapReadings(src, kind, readings); len(mapped) > 0 {
+				s.broadcast(kind)(mapped)
+			}
+		})
+	}
+	return s
+}
+
+// sources returns the servers with channels of kind, or of any kind if
+// it is empty, each once.
+func (s *Server) sources(kind string) []*Server {
+	var sources []*Server
+	for _, c := range s.channels {
+		if (kind == "" || c.Kind == kind) && !slices.Contains(sources, c.Source) {
+			sources = append(sources, c.Source)
+		}
+	}
+	return sources
+}
+
+// mapReadings returns the readings of kind from src that are channels of
+// the virtual device, as its own, ordered by pin.
+func (s *Server) mapReadings(src *Server, kind string, readings []esp32.Reading) []esp32.Reading {
+	var mapped []esp32.Reading
+	for _, r := range readings {
+		if r.Kind != "" {
+			continue
+		}
+		for _, c := range s.channels {
+			if c.Source == src && c.Kind == kind && c.Pin == r.Pin {
+				mapped = append(mapped, esp32.Reading{Time: r.Time, Device: s.name, Pin: c.As, Value: r.Value})
+			}
+		}
+	}
+	slices.SortStableFunc(mapped, func(a, b esp32.Reading) int { return int(a.Pin) - int(b.Pin) })
+	return mapped
+}
+
+// readVirtual reads the channels of kind from their boards in turn,
+// failing as the first board to fail does.
+func (s *Server) readVirtual(w http.ResponseWriter, r *http.Request, kind string) ([]esp32.Reading, bool) {
+	readings := []esp32.Reading{}
+	for _, src := range s.sources(kind) {
+		got, ok := src.read(w, r, kind)
+		if !ok {
+			return nil, false
+		}
+		readings = append(readings, s.mapReadings(src, kind, got)...)
+	}
+	slices.SortStableFunc(readings, func(a, b esp32.Reading) int { return int(a.Pin) - int(b.Pin) })
+	return readings, true
+}
+
+// writeVirtual writes each pin on the board of its channel, a board's
+// pins together.
+func (s *Server) writeVirtual(ctx context.Context, writes []esp32.PinWrite) error {
+	var sources []*Server
+	bySource := map[*Server][]esp32.PinWrite{}
+	for _, w := range writes {
+		i := slices.IndexFunc(s.channels, func(c Channel) bool { return c.Kind == KindPins && c.As == w.PinNum })
+		if i < 0 {
+			return fmt.Errorf("%w: %s has no pin %d", errNoChannel, s.name, w.PinNum)
+		}
+		c := s.channels[i]
+		if _, ok := bySource[c.Source]; !ok {
+			sources = append(sources, c.Source)
+		}
+		bySource[c.Source] = append(bySource[c.Source], esp32.PinWrite{PinNum: c.Pin, State: w.State})
+	}
+	for _, src := range sources {
+		if err := src.write(ctx, bySource[src]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// virtualStatus is the status of the least connected of the virtual
+// device's boards.
+func (s *Server) virtualStatus() string {
+	status := "connected"
+	for _, src := range s.sources("") {
+		switch src.Status() {
+		case "disconnected":
+			return "disconnected"
+		case "idle":
+			status = "idle"
+		}
+	}
+	return status
+}
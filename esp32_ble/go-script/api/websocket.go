@@ -0,0 +1,111 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"bluetooth/esp32"
+)
+
+// upgrader accepts WebSocket connections from clients that send no
+// Origin and from pages this server served. Unlike /stream, /ws takes pin
+// writes, and browsers let any site open a WebSocket: a page elsewhere
+// mustn't drive the board through a visitor's browser.
+var upgrader = websocket.Upgrader{}
+
+// message is a notification as sent over /ws.
+type message struct {
+	Kind string `json:"kind"`
+	Data any    `json:"data"`
+}
+
+// KindError messages on /ws report a pin write sent on it that failed.
+const KindError = "error"
+
+// handleWebSocket is /stream over a WebSocket, which also takes pin
+// writes, for browsers and tools that would rather hold one connection.
+func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	kind, err := streamKind(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return // the upgrader has replied
+	}
+	defer conn.Close()
+
+	st, closeStream := s.openStream(kind)
+	defer closeStream()
+
+	// Only this goroutine writes to conn; the reader hands it errors.
+	errs := make(chan error, 1)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.readWrites(r.Context(), conn, errs)
+	}()
+	for {
+		var m message
+		select {
+		case <-done:
+			return
+		case ev := <-st.events:
+			m = message{Kind: ev.kind, Data: ev.data}
+		case err := <-errs:
+			m = message{Kind: KindError, Data: map[string]string{"error": err.Error()}}
+		}
+		conn.SetWriteDeadline(time.Now().Add(requestTimeout))
+		if err := conn.WriteJSON(m); err != nil {
+			return
+		}
+	}
+}
+
+// readWrites writes each pin_writes document received on conn to the
+// board until conn closes, passing failures to errs for the client.
+func (s *Server) readWrites(ctx context.Context, conn *websocket.Conn, errs chan<- error) {
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		var req esp32.PinRequest
+		switch err = json.Unmarshal(data, &req); {
+		case err != nil:
+			err = fmt.Errorf("invalid pin_writes JSON: %w", err)
+		case len(req.PinWrites) == 0:
+			err = errors.New("no pin_writes given")
+		default:
+			err = s.write(ctx, req.PinWrites)
+		}
+		if err != nil {
+			select {
+			case errs <- err:
+			default:
+			}
+		}
+	}
+}
+
+// write writes pins on the attached board, waiting for a suspended one,
+// or on the boards of a virtual device's channels.
+func (s *Server) write(ctx context.Context, writes []esp32.PinWrite) error {
+	if s.channels != nil {
+		return s.writeVirtual(ctx, writes)
+	}
+	client, err := s.attached(ctx)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+	return client.WritePins(ctx, writes)
+}
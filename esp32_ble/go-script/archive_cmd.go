@@ -0,0 +1,473 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// archiveVersion is the layout of the archives export writes; import
+// refuses others.
+const archiveVersion = 1
+
+// archiveManifestName is an archive's first member, listing the rest.
+const archiveManifestName = "manifest.json"
+
+// archiveManifest describes an archive: where it was made and the files
+// in it.
+type archiveManifest struct {
+	Version int           `json:"version"`
+	Created time.Time     `json:"created"`
+	Host    string        `json:"host"`
+	Files   []archiveFile `json:"files"`
+}
+
+// archiveFile is one file of an archive. Its Name says where it goes:
+// under home/ relative to the home directory, under config/ relative to
+// the config file's directory, so the config's relative paths still find
+// it, or under root/ at its absolute path on the old host.
+type archiveFile struct {
+	Name string `json:"name"`
+	// Kind is what the file is: config, curve, baselines, journal,
+	// scan-cache, catalog or included.
+	Kind string      `json:"kind"`
+	Size int64       `json:"size"`
+	Mode fs.FileMode `json:"mode"`
+}
+
+// localFile is a file export looks for, and its name in the archive.
+type localFile struct {
+	kind, path, name string
+}
+
+// runExport writes the config file and the files it refers to, and with
+// --all everything else the tool keeps on this host, into one archive
+// for import to unpack on another. Commands writing to the files, such
+// as serve, should be stopped first.
+func runExport(_ context.Context, args []string) {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	allPtr := fs.Bool("all", false, "Also export the journal, scan cache and message catalogs in the home directory")
+	configPtr := fs.String("config", "", "Config file to export (default ~/"+defaultConfigName+")")
+	outPtr := fs.String("out", "esp32_interfaces-"+time.Now().Format("20060102-150405")+".tar.gz", "Archive to write")
+	var include stringList
+	fs.Var(&include, "include", "Another file to export, such as a --log-file log (repeatable)")
+	fs.Parse(args)
+
+	files, err := localFiles(configPath(*configPtr), *allPtr, include)
+	if err != nil {
+		msg.Printf("❌ %v\n", err)
+		os.Exit(1)
+	}
+	var found []localFile
+	for _, f := range files {
+		if _, err := os.Stat(f.path); errors.Is(err, os.ErrNotExist) {
+			msg.Printf("   – no %s at %s\n", f.kind, f.path)
+			continue
+		}
+		found = append(found, f)
+	}
+	if len(found) == 0 {
+		msg.Println("❌ No local data found to export")
+		os.Exit(1)
+	}
+
+	out, err := os.OpenFile(*outPtr, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		msg.Printf("❌ Failed to create archive: %v\n", err)
+		os.Exit(1)
+	}
+	manifest, err := writeArchive(out, found)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(*outPtr)
+		msg.Printf("❌ Failed to write archive: %v\n", err)
+		os.Exit(1)
+	}
+	for i, f := range manifest.Files {
+		msg.Printf("   %-10s %s\n", f.Kind, found[i].path)
+	}
+	msg.Printf("📦 Exported %d file(s) to %s\n", len(manifest.Files), *outPtr)
+	msg.Println("ℹ️  Bonds aren't included: the operating system's Bluetooth stack keeps them, so boards that need pairing are paired again on the new host")
+}
+
+// localFiles returns the files export looks for: the config file at
+// configFile, the calibration curves, anomaly baselines and space
+// journals it refers to and, if all is set, the files in the home
+// directory. Some may not exist.
+func localFiles(configFile string, all bool, include []string) ([]localFile, error) {
+	configDir := filepath.Dir(configFile)
+	// byConfig names a file the config refers to under config/ if it is
+	// in the config file's directory, and otherwise by its absolute path.
+	byConfig := func(kind, file string) localFile {
+		if !filepath.IsAbs(file) {
+			file = filepath.Join(configDir, file)
+		}
+		if rel, err := filepath.Rel(configDir, file); err == nil && filepath.IsLocal(rel) {
+			return localFile{kind, file, "config/" + filepath.ToSlash(rel)}
+		}
+		return localFile{kind, file, rootName(file)}
+	}
+
+	files := []localFile{{"config", configFile, "config/" + filepath.Base(configFile)}}
+	c, err := readConfig(configFile)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	if c != nil {
+		for _, name := range slices.Sorted(maps.Keys(c.Profiles)) {
+			p := c.Profiles[name]
+			for _, pin := range slices.Sorted(maps.Keys(p.Calibrations)) {
+				if curve := p.Calibrations[pin].Curve; curve != "" {
+					files = append(files, byConfig("curve", curve))
+				}
+			}
+			for _, cc := range p.Climate {
+				for _, curve := range []string{cc.Temperature.CurveFile, cc.humidityCurve()} {
+					if curve != "" {
+						files = append(files, byConfig("curve", curve))
+					}
+				}
+			}
+			if p.Anomaly != nil && p.Anomaly.BaselineFile != "" {
+				files = append(files, byConfig("baselines", p.Anomaly.BaselineFile))
+			}
+		}
+		for _, name := range slices.Sorted(maps.Keys(c.Spaces)) {
+			if journal := c.Spaces[name].Journal; journal != "" {
+				abs, err := filepath.Abs(journal)
+				if err != nil {
+					return nil, err
+				}
+				files = append(files, localFile{"journal", abs, rootName(abs)})
+			}
+		}
+	}
+
+	if all {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, err
+		}
+		files = append(files,
+			localFile{"journal", filepath.Join(home, defaultJournalName), "home/" + defaultJournalName},
+			localFile{"scan-cache", filepath.Join(home, scanCacheName), "home/" + scanCacheName})
+		entries, err := os.ReadDir(filepath.Join(home, localeDirName))
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+		for _, e := range entries {
+			if e.Type().IsRegular() {
+				files = append(files, localFile{"catalog", filepath.Join(home, localeDirName, e.Name()), "home/" + localeDirName + "/" + e.Name()})
+			}
+		}
+	}
+
+	for _, file := range include {
+		abs, err := filepath.Abs(file)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, localFile{"included", abs, rootName(abs)})
+	}
+
+	// A curve can be shared by several pins.
+	seen := map[string]bool{}
+	return slices.DeleteFunc(files, func(f localFile) bool {
+		dup := seen[f.name]
+		seen[f.name] = true
+		return dup
+	}), nil
+}
+
+// humidityCurve returns the curve file of the climate entry's humidity
+// channel, if it has one.
+func (c climateConfig) humidityCurve() string {
+	if c.Humidity == nil {
+		return ""
+	}
+	return c.Humidity.CurveFile
+}
+
+// rootName is an absolute path's name in an archive.
+func rootName(file string) string {
+	return "root/" + strings.TrimPrefix(filepath.ToSlash(file), "/")
+}
+
+// writeArchive writes files to w as a gzipped tar archive, its manifest
+// first, returning the manifest.
+func writeArchive(w io.Writer, files []localFile) (archiveManifest, error) {
+	host, _ := os.Hostname()
+	manifest := archiveManifest{Version: archiveVersion, Created: time.Now().UTC().Truncate(time.Second), Host: host}
+	for _, f := range files {
+		info, err := os.Stat(f.path)
+		if err != nil {
+			return manifest, err
+		}
+		if !info.Mode().IsRegular() {
+			return manifest, fmt.Errorf("%s isn't a regular file", f.path)
+		}
+		manifest.Files = append(manifest.Files, archiveFile{Name: f.name, Kind: f.kind, Size: info.Size(), Mode: info.Mode().Perm()})
+	}
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return manifest, err
+	}
+
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	if err := tw.WriteHeader(&tar.Header{Name: archiveManifestName, Mode: 0o644, Size: int64(len(data)), ModTime: manifest.Created}); err != nil {
+		return manifest, err
+	}
+	if _, err := tw.Write(data); err != nil {
+		return manifest, err
+	}
+	for i, f := range manifest.Files {
+		if err := addArchiveFile(tw, f, files[i].path); err != nil {
+			return manifest, fmt.Errorf("%s: %w", files[i].path, err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return manifest, err
+	}
+	return manifest, gz.Close()
+}
+
+// addArchiveFile writes the file at file to tw as f. A file still being
+// appended to is cut at the size the manifest has for it.
+func addArchiveFile(tw *tar.Writer, f archiveFile, file string) error {
+	in, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	if err := tw.WriteHeader(&tar.Header{Name: f.Name, Mode: int64(f.Mode), Size: f.Size, ModTime: time.Now()}); err != nil {
+		return err
+	}
+	_, err = io.CopyN(tw, in, f.Size)
+	return err
+}
+
+// runImport unpacks an archive written by export: the config file goes
+// where --config says, the files it refers to next to it, the home
+// directory's files into this one's and files from elsewhere on the old
+// host under --root, which they need. It refuses to replace files unless
+// --force is given, and then checks the config loads.
+func runImport(_ context.Context, args []string) {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		msg.Println("Usage: import ARCHIVE [flags]")
+		os.Exit(1)
+	}
+	source := args[0]
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	configPtr := fs.String("config", "", "Where to put the archive's config file (default ~/"+defaultConfigName+")")
+	forcePtr := fs.Bool("force", false, "Replace files that already exist")
+	rootPtr := fs.String("root", "", "Directory to put files from outside the old host's home and config directories under, at their old absolute path (/ to put them back where they were)")
+	dryRunPtr := fs.Bool("dry-run", false, "List where the archive's files would go without writing them")
+	fs.Parse(args[1:])
+
+	f, err := os.Open(source)
+	if err != nil {
+		msg.Printf("❌ Failed to open archive: %v\n", err)
+		os.Exit(1)
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		msg.Printf("❌ %s isn't an export archive: %v\n", source, err)
+		os.Exit(1)
+	}
+	tr := tar.NewReader(gz)
+	manifest, err := readManifest(tr)
+	if err != nil {
+		msg.Printf("❌ %s: %v\n", source, err)
+		os.Exit(1)
+	}
+
+	if n := len(slices.DeleteFunc(slices.Clone(manifest.Files), func(af archiveFile) bool { return af.Kind != "config" })); n > 1 {
+		msg.Printf("❌ %s: %d config files, want one\n", source, n)
+		os.Exit(1)
+	}
+	configFile := configPath(*configPtr)
+	home, _ := os.UserHomeDir()
+	targets := map[string]string{}
+	var exist, outside []string
+	for _, af := range manifest.Files {
+		target, err := importTarget(af, configFile, home, *rootPtr)
+		if errors.Is(err, errOutsideHome) {
+			outside = append(outside, "/"+strings.TrimPrefix(af.Name, "root/"))
+			continue
+		}
+		if err != nil {
+			msg.Printf("❌ %s: %v\n", source, err)
+			os.Exit(1)
+		}
+		targets[af.Name] = target
+		if _, err := os.Lstat(target); err == nil {
+			exist = append(exist, target)
+		}
+		msg.Printf("   %-10s %s\n", af.Kind, target)
+	}
+	msg.Printf("📦 %d file(s) exported from %s on %s\n", len(manifest.Files), manifest.Host, manifest.Created.Local().Format("2006-01-02 15:04"))
+	if len(outside) > 0 {
+		msg.Printf("❌ %d file(s) were outside the home and config directories; give --root DIR to put them under DIR, or --root / to put them back where they were:\n", len(outside))
+		for _, name := range outside {
+			msg.Printf("   %s\n", name)
+		}
+		os.Exit(1)
+	}
+	if len(exist) > 0 && !*forcePtr {
+		msg.Printf("❌ %d file(s) already exist; give --force to replace them:\n", len(exist))
+		for _, target := range exist {
+			msg.Printf("   %s\n", target)
+		}
+		os.Exit(1)
+	}
+	if *dryRunPtr {
+		return
+	}
+
+	written := 0
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			msg.Printf("❌ Failed to read archive: %v\n", err)
+			os.Exit(1)
+		}
+		target, ok := targets[hdr.Name]
+		if !ok || hdr.Typeflag != tar.TypeReg {
+			msg.Printf("❌ %s: %s isn't in the manifest\n", source, hdr.Name)
+			os.Exit(1)
+		}
+		delete(targets, hdr.Name)
+		// Nothing the tool keeps is executable.
+		if err := writeFileAtomic(target, tr, os.FileMode(hdr.Mode).Perm()&^0o111); err != nil {
+			msg.Printf("❌ Failed to import %s: %v\n", target, err)
+			os.Exit(1)
+		}
+		written++
+	}
+	if len(targets) > 0 {
+		msg.Printf("❌ %s is truncated: %d file(s) missing\n", source, len(targets))
+		os.Exit(1)
+	}
+	msg.Printf("✅ Imported %d file(s)\n", written)
+
+	if slices.ContainsFunc(manifest.Files, func(af archiveFile) bool { return af.Kind == "config" }) {
+		if _, err := readConfig(configFile); err != nil {
+			msg.Printf("❌ The imported config doesn't load: %v\n", err)
+			os.Exit(1)
+		}
+	}
+}
+
+// readManifest reads the manifest an archive starts with.
+func readManifest(tr *tar.Reader) (archiveManifest, error) {
+	var manifest archiveManifest
+	hdr, err := tr.Next()
+	if err != nil {
+		return manifest, fmt.Errorf("not an export archive: %w", err)
+	}
+	if hdr.Name != archiveManifestName {
+		return manifest, errors.New("not an export archive: no manifest")
+	}
+	if err := json.NewDecoder(tr).Decode(&manifest); err != nil {
+		return manifest, fmt.Errorf("invalid manifest: %w", err)
+	}
+	if manifest.Version != archiveVersion {
+		return manifest, fmt.Errorf("archive version %d isn't supported (want %d); import it with the version of this tool that exported it", manifest.Version, archiveVersion)
+	}
+	return manifest, nil
+}
+
+// errOutsideHome is returned by importTarget for a file from outside the
+// old host's home and config directories when no root was given.
+var errOutsideHome = errors.New("outside the home and config directories")
+
+// importTarget returns where an archive's file goes: the config file at
+// configFile, the curves and baselines under config/ next to it, the
+// journal, scan cache and catalogs under home/ in home, and the journals,
+// included files, curves and baselines under root/ beneath root, at
+// their absolute path on the old host. Each anchor only takes the kinds
+// of file export puts there, and config/ none in hidden files or
+// directories, so an archive can't write the user's shell or SSH
+// settings; names that would leave their anchor are refused too.
+func importTarget(af archiveFile, configFile, home, root string) (string, error) {
+	anchor, rel, _ := strings.Cut(af.Name, "/")
+	if rel == "" || path.Clean(rel) != rel || !filepath.IsLocal(filepath.FromSlash(rel)) {
+		return "", fmt.Errorf("invalid file name %q", af.Name)
+	}
+	switch anchor {
+	case "config":
+		switch af.Kind {
+		case "config":
+			if !strings.Contains(rel, "/") {
+				return configFile, nil
+			}
+		case "curve", "baselines":
+			if !slices.ContainsFunc(strings.Split(rel, "/"), func(e string) bool { return strings.HasPrefix(e, ".") }) {
+				return filepath.Join(filepath.Dir(configFile), filepath.FromSlash(rel)), nil
+			}
+		}
+	case "home":
+		dir, base := path.Split(rel)
+		switch {
+		case home == "":
+		case af.Kind == "journal" && rel == defaultJournalName,
+			af.Kind == "scan-cache" && rel == scanCacheName,
+			af.Kind == "catalog" && dir == localeDirName+"/" && !strings.HasPrefix(base, "."):
+			return filepath.Join(home, filepath.FromSlash(rel)), nil
+		}
+	case "root":
+		switch af.Kind {
+		case "journal", "included", "curve", "baselines":
+			if root == "" {
+				return "", errOutsideHome
+			}
+			return filepath.Join(root, filepath.FromSlash(rel)), nil
+		}
+	}
+	return "", fmt.Errorf("%q can't be imported as a %q file", af.Name, af.Kind)
+}
+
+// writeFileAtomic writes r to target through a temporary file beside
+// it, so a failed import or save doesn't leave it half written.
+func writeFileAtomic(target string, r io.Reader, mode fs.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(target), "."+filepath.Base(target)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(mode); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), target)
+}
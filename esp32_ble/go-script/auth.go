@@ -0,0 +1,527 @@
+package main
+
+import (
+	"cmp"
+	"crypto/hmac"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// authProvider identifies the users of serve's requests for --auth.
+type authProvider interface {
+	// user returns the request's user or, having answered the request
+	// itself, such as with 401 or a redirect to sign in, false.
+	user(w http.ResponseWriter, r *http.Request) (string, bool)
+}
+
+// authProviders are the names --auth takes, each set up from its section
+// of the config file's auth.
+var authProviders = []string{"basic", "proxy", "oidc"}
+
+// newAuth returns the provider named by --auth, set up from c. Endpoints
+// the provider serves itself, such as OIDC's callback, are registered on
+// mux under /auth/.
+func newAuth(name string, c authConfig, mux *http.ServeMux) (authProvider, error) {
+	switch name {
+	case "basic":
+		if c.Basic == nil || len(c.Basic.Users) == 0 {
+			return nil, errors.New("basic auth needs users in the config file's auth: basic: section")
+		}
+		a := &basicAuth{realm: cmp.Or(c.Basic.Realm, "esp32"), users: map[string]passwordHash{}}
+		for user, hash := range c.Basic.Users {
+			a.users[user], _ = parsePasswordHash(hash)
+		}
+		return a, nil
+	case "proxy":
+		a := &proxyAuth{header: "Remote-User", trusted: []netip.Prefix{netip.MustParsePrefix("127.0.0.0/8"), netip.MustParsePrefix("::1/128")}}
+		if c.Proxy != nil {
+			a.header = cmp.Or(c.Proxy.Header, a.header)
+			if len(c.Proxy.TrustedProxies) > 0 {
+				a.trusted = nil
+				for _, s := range c.Proxy.TrustedProxies {
+					prefix, _ := parsePrefix(s)
+					a.trusted = append(a.trusted, prefix)
+				}
+			}
+		}
+		return a, nil
+	case "oidc":
+		if c.OIDC == nil {
+			return nil, errors.New("oidc auth needs the config file's auth: oidc: section")
+		}
+		return newOIDCAuth(*c.OIDC, mux)
+	}
+	return nil, fmt.Errorf("unknown auth provider %q (want %s)", name, strings.Join(authProviders, ", "))
+}
+
+// validate reports settings no provider could work with, whichever
+// --auth picks.
+func (c authConfig) validate() error {
+	if b := c.Basic; b != nil {
+		for _, user := range slices.Sorted(maps.Keys(b.Users)) {
+			if _, err := parsePasswordHash(b.Users[user]); err != nil {
+				return fmt.Errorf("basic: user %q: %w", user, err)
+			}
+		}
+	}
+	if p := c.Proxy; p != nil {
+		for _, s := range p.TrustedProxies {
+			if _, err := parsePrefix(s); err != nil {
+				return fmt.Errorf("proxy: invalid trusted proxy %q (want an address or CIDR)", s)
+			}
+		}
+	}
+	if o := c.OIDC; o != nil {
+		if o.Issuer == "" || o.ClientID == "" {
+			return errors.New("oidc: needs an issuer and a client_id")
+		}
+		if !secureURL(o.Issuer) {
+			return fmt.Errorf("oidc: issuer %q must be https", o.Issuer)
+		}
+		if o.Session < 0 {
+			return errors.New("oidc: session can't be negative")
+		}
+	}
+	return nil
+}
+
+// parsePrefix parses a CIDR or a single address.
+func parsePrefix(s string) (netip.Prefix, error) {
+	if addr, err := netip.ParseAddr(s); err == nil {
+		return netip.PrefixFrom(addr, addr.BitLen()), nil
+	}
+	return netip.ParsePrefix(s)
+}
+
+// requireAuth passes on requests from users p identifies, besides those
+// under the open path prefixes, such as share links, meant for anyone
+// holding them, and OIDC's endpoints for signing in.
+func requireAuth(p authProvider, h http.Handler, open ...string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if slices.ContainsFunc(open, func(prefix string) bool { return strings.HasPrefix(r.URL.Path, prefix) }) {
+			h.ServeHTTP(w, r)
+			return
+		}
+		if _, ok := p.user(w, r); ok {
+			h.ServeHTTP(w, r)
+		}
+	})
+}
+
+// basicAuth checks HTTP basic auth credentials against a salted hash of
+// each user's password.
+type basicAuth struct {
+	realm string
+	users map[string]passwordHash
+}
+
+func (a *basicAuth) user(w http.ResponseWriter, r *http.Request) (string, bool) {
+	if user, password, ok := r.BasicAuth(); ok {
+		if want, known := a.users[user]; known && want.matches(password) {
+			return user, true
+		}
+	}
+	w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Basic realm=%q, charset="UTF-8"`, a.realm))
+	http.Error(w, fmt.Sprintf(`{"error":%q}`, "missing or wrong user name or password"), http.StatusUnauthorized)
+	return "", false
+}
+
+// passwordHashScheme starts the password hashes hash-password prints:
+// PBKDF2 with HMAC-SHA256, then the iterations, salt and key, the last
+// two in unpadded base64, separated by $.
+const passwordHashScheme = "pbkdf2-sha256"
+
+// passwordIterations is how many iterations hash-password uses, as OWASP
+// recommends for PBKDF2-HMAC-SHA256. Checking a password takes a good
+// part of a second, so guessing them from a stolen config is slow.
+const passwordIterations = 600_000
+
+// passwordHash is a password's salted hash, as given in the config file.
+type passwordHash struct {
+	iterations int
+	salt, key  []byte
+}
+
+// hashPassword returns password's hash for the config file, with a new
+// random salt.
+func hashPassword(password string) string {
+	salt := make([]byte, 16)
+	rand.Read(salt)
+	key, _ := pbkdf2.Key(sha256.New, password, salt, passwordIterations, sha256.Size)
+	return fmt.Sprintf("%s$%d$%s$%s", passwordHashScheme, passwordIterations,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key))
+}
+
+func parsePasswordHash(s string) (passwordHash, error) {
+	bad := errors.New("want a password hash printed by hash-password")
+	parts := strings.Split(s, "$")
+	if len(parts) != 4 || parts[0] != passwordHashScheme {
+		return passwordHash{}, bad
+	}
+	var h passwordHash
+	var err1, err2, err3 error
+	h.iterations, err1 = strconv.Atoi(parts[1])
+	h.salt, err2 = base64.RawStdEncoding.DecodeString(parts[2])
+	h.key, err3 = base64.RawStdEncoding.DecodeString(parts[3])
+	if err1 != nil || err2 != nil || err3 != nil || h.iterations < 1 || len(h.salt) < 8 || len(h.key) < 16 {
+		return passwordHash{}, bad
+	}
+	return h, nil
+}
+
+func (h passwordHash) matches(password string) bool {
+	key, err := pbkdf2.Key(sha256.New, password, h.salt, h.iterations, len(h.key))
+	return err == nil && subtle.ConstantTimeCompare(key, h.key) == 1
+}
+
+// proxyAuth takes the user from a header set by an authenticating
+// reverse proxy, trusting it only from the proxy's addresses so clients
+// can't set it themselves.
+type proxyAuth struct {
+	header  string
+	trusted []netip.Prefix
+}
+
+func (a *proxyAuth) user(w http.ResponseWriter, r *http.Request) (string, bool) {
+	addr, err := netip.ParseAddrPort(r.RemoteAddr)
+	if err != nil || !slices.ContainsFunc(a.trusted, func(p netip.Prefix) bool { return p.Contains(addr.Addr().Unmap()) }) {
+		http.Error(w, fmt.Sprintf(`{"error":%q}`, "requests must come through the authenticating proxy"), http.StatusForbidden)
+		return "", false
+	}
+	user := r.Header.Get(a.header)
+	if user == "" {
+		http.Error(w, fmt.Sprintf(`{"error":%q}`, "no user from the authenticating proxy"), http.StatusUnauthorized)
+		return "", false
+	}
+	return user, true
+}
+
+// The cookies of oidcAuth: the session once signed in, and the state of
+// a sign-in in progress.
+const (
+	sessionCookie = "esp32_session"
+	loginCookie   = "esp32_login"
+)
+
+// oidcAuth signs users in with an OpenID Connect provider by the
+// authorization code flow, keeping them signed in with a session cookie.
+// The ID token comes straight from the provider's token endpoint, over
+// TLS to it, so as OIDC allows its signature isn't checked, only its
+// claims; the issuer and its endpoints must therefore be https, but for
+// a provider on loopback. Cookies are signed with a key made at startup,
+// so restarting serve signs everyone out.
+//
+//	GET  /auth/login     go to the provider, then back to ?next=
+//	GET  /auth/callback  the provider's redirect back
+//	POST /auth/logout    end the session
+type oidcAuth struct {
+	config            oidcAuthConfig
+	authURL, tokenURL string
+	key               []byte
+	client            *http.Client
+}
+
+func newOIDCAuth(c oidcAuthConfig, mux *http.ServeMux) (*oidcAuth, error) {
+	a := &oidcAuth{config: c, key: make([]byte, 32), client: &http.Client{Timeout: 10 * time.Second}}
+	if len(c.Scopes) == 0 {
+		a.config.Scopes = []string{"openid", "profile", "email"}
+	}
+	a.config.Session = cmp.Or(c.Session, 12*time.Hour)
+	rand.Read(a.key)
+
+	resp, err := a.client.Get(strings.TrimSuffix(c.Issuer, "/") + "/.well-known/openid-configuration")
+	if err != nil {
+		return nil, fmt.Errorf("discovering OIDC provider: %w", err)
+	}
+	defer resp.Body.Close()
+	var discovery struct {
+		Issuer                string `json:"issuer"`
+		AuthorizationEndpoint string `json:"authorization_endpoint"`
+		TokenEndpoint         string `json:"token_endpoint"`
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("discovering OIDC provider: %s", resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&discovery); err != nil {
+		return nil, fmt.Errorf("discovering OIDC provider: %w", err)
+	}
+	if discovery.Issuer != c.Issuer {
+		return nil, fmt.Errorf("OIDC provider is issuer %q, not %q", discovery.Issuer, c.Issuer)
+	}
+	for _, u := range []string{discovery.AuthorizationEndpoint, discovery.TokenEndpoint} {
+		if !secureURL(u) {
+			return nil, fmt.Errorf("OIDC provider endpoint %q isn't https", u)
+		}
+	}
+	a.authURL, a.tokenURL = discovery.AuthorizationEndpoint, discovery.TokenEndpoint
+
+	mux.HandleFunc("GET /auth/login", a.handleLogin)
+	mux.HandleFunc("GET /auth/callback", a.handleCallback)
+	mux.HandleFunc("POST /auth/logout", func(w http.ResponseWriter, r *http.Request) {
+		http.SetCookie(w, &http.Cookie{Name: sessionCookie, Path: "/", MaxAge: -1})
+		msg.Fprintln(w, "Signed out.")
+	})
+	return a, nil
+}
+
+// secureURL reports whether s is an https URL, or http to loopback, where
+// there is no network to listen in on.
+func secureURL(s string) bool {
+	u, err := url.Parse(s)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	if u.Scheme == "https" {
+		return true
+	}
+	if u.Scheme != "http" {
+		return false
+	}
+	if u.Hostname() == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(u.Hostname())
+	return ip != nil && ip.IsLoopback()
+}
+
+// session is what the session cookie holds.
+type session struct {
+	User    string    `json:"user"`
+	Expires time.Time `json:"expires"`
+}
+
+// login is what the login cookie holds while the user is at the
+// provider.
+type login struct {
+	State   string    `json:"state"`
+	Nonce   string    `json:"nonce"`
+	Next    string    `json:"next"`
+	Expires time.Time `json:"expires"`
+}
+
+func (a *oidcAuth) user(w http.ResponseWriter, r *http.Request) (string, bool) {
+	if c, err := r.Cookie(sessionCookie); err == nil {
+		var s session
+		if a.open(sessionCookie, c.Value, &s) && s.User != "" && time.Now().Before(s.Expires) {
+			return s.User, true
+		}
+	}
+	// Browsers opening a page are sent to sign in; API clients, and the
+	// pages' own requests once a session has lapsed, are told to.
+	if r.Method == http.MethodGet && strings.Contains(r.Header.Get("Accept"), "text/html") {
+		http.Redirect(w, r, "/auth/login?next="+url.QueryEscape(r.URL.RequestURI()), http.StatusFound)
+		return "", false
+	}
+	http.Error(w, fmt.Sprintf(`{"error":%q}`, "sign in at /auth/login"), http.StatusUnauthorized)
+	return "", false
+}
+
+func (a *oidcAuth) handleLogin(w http.ResponseWriter, r *http.Request) {
+	l := login{State: randomHex(), Nonce: randomHex(), Next: r.URL.Query().Get("next"), Expires: time.Now().Add(10 * time.Minute)}
+	// Only back to this server.
+	if !strings.HasPrefix(l.Next, "/") || strings.HasPrefix(l.Next, "//") || strings.HasPrefix(l.Next, "/\\") {
+		l.Next = "/"
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name: loginCookie, Value: a.seal(loginCookie, l), Path: "/auth/",
+		HttpOnly: true, Secure: r.TLS != nil, SameSite: http.SameSiteLaxMode,
+	})
+	q := url.Values{
+		"response_type": {"code"},
+		"client_id":     {a.config.ClientID},
+		"redirect_uri":  {a.redirectURL(r)},
+		"scope":         {strings.Join(a.config.Scopes, " ")},
+		"state":         {l.State},
+		"nonce":         {l.Nonce},
+	}
+	sep := "?"
+	if strings.Contains(a.authURL, "?") {
+		sep = "&"
+	}
+	http.Redirect(w, r, a.authURL+sep+q.Encode(), http.StatusFound)
+}
+
+// redirectURL is where the provider sends users back to: redirect_url
+// if set, for a server behind a proxy, otherwise /auth/callback on the
+// host the browser asked for.
+func (a *oidcAuth) redirectURL(r *http.Request) string {
+	if a.config.RedirectURL != "" {
+		return a.config.RedirectURL
+	}
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host + "/auth/callback"
+}
+
+func (a *oidcAuth) handleCallback(w http.ResponseWriter, r *http.Request) {
+	var l login
+	c, err := r.Cookie(loginCookie)
+	if err != nil || !a.open(loginCookie, c.Value, &l) || time.Now().After(l.Expires) {
+		http.Error(w, "Sign-in expired; try again.", http.StatusBadRequest)
+		return
+	}
+	q := r.URL.Query()
+	if subtle.ConstantTimeCompare([]byte(q.Get("state")), []byte(l.State)) != 1 {
+		http.Error(w, "Sign-in state mismatch; try again.", http.StatusBadRequest)
+		return
+	}
+	if e := q.Get("error"); e != "" {
+		http.Error(w, fmt.Sprintf("Sign-in failed: %s %s", e, q.Get("error_description")), http.StatusUnauthorized)
+		return
+	}
+	user, err := a.exchange(r, q.Get("code"), l.Nonce)
+	if err != nil {
+		msg.Printf("⚠️  OIDC sign-in failed: %v\n", err)
+		http.Error(w, fmt.Sprintf("Sign-in failed: %v", err), http.StatusUnauthorized)
+		return
+	}
+	if len(a.config.Users) > 0 && !slices.Contains(a.config.Users, user) {
+		msg.Printf("⚠️  Refused OIDC user %q\n", user)
+		http.Error(w, fmt.Sprintf("User %q isn't allowed here.", user), http.StatusForbidden)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: loginCookie, Path: "/auth/", MaxAge: -1})
+	http.SetCookie(w, &http.Cookie{
+		Name: sessionCookie, Value: a.seal(sessionCookie, session{User: user, Expires: time.Now().Add(a.config.Session)}), Path: "/",
+		HttpOnly: true, Secure: r.TLS != nil, SameSite: http.SameSiteLaxMode,
+	})
+	msg.Printf("🔐 %s signed in\n", user)
+	http.Redirect(w, r, l.Next, http.StatusFound)
+}
+
+// exchange redeems an authorization code at the token endpoint and
+// returns the user its ID token is for, checking the token's issuer,
+// audience, expiry and nonce. The user is their preferred_username,
+// email or, failing those, subject.
+func (a *oidcAuth) exchange(r *http.Request, code, nonce string) (string, error) {
+	form := url.Values{
+		"grant_type":   {"authorization_code"},
+		"code":         {code},
+		"redirect_uri": {a.redirectURL(r)},
+	}
+	req, err := http.NewRequestWithContext(r.Context(), http.MethodPost, a.tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(url.QueryEscape(a.config.ClientID), url.QueryEscape(a.config.ClientSecret))
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	var token struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", fmt.Errorf("token response: %w", err)
+	}
+	if token.Error != "" || resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("token endpoint: %s %s %s", resp.Status, token.Error, token.ErrorDescription)
+	}
+
+	parts := strings.Split(token.IDToken, ".")
+	if len(parts) != 3 {
+		return "", errors.New("malformed ID token")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", fmt.Errorf("malformed ID token: %w", err)
+	}
+	var claims struct {
+		Issuer            string   `json:"iss"`
+		Audience          audience `json:"aud"`
+		Expiry            int64    `json:"exp"`
+		Nonce             string   `json:"nonce"`
+		Subject           string   `json:"sub"`
+		PreferredUsername string   `json:"preferred_username"`
+		Email             string   `json:"email"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return "", fmt.Errorf("malformed ID token: %w", err)
+	}
+	switch {
+	case claims.Issuer != a.config.Issuer:
+		return "", fmt.Errorf("ID token from issuer %q", claims.Issuer)
+	case !slices.Contains(claims.Audience, a.config.ClientID):
+		return "", errors.New("ID token for another client")
+	case time.Now().After(time.Unix(claims.Expiry, 0)):
+		return "", errors.New("ID token expired")
+	case subtle.ConstantTimeCompare([]byte(claims.Nonce), []byte(nonce)) != 1:
+		return "", errors.New("ID token for another sign-in")
+	}
+	user := cmp.Or(claims.PreferredUsername, claims.Email, claims.Subject)
+	if user == "" {
+		return "", errors.New("ID token names no user")
+	}
+	return user, nil
+}
+
+// audience is an ID token's aud claim: one client or a list of them.
+type audience []string
+
+func (a *audience) UnmarshalJSON(data []byte) error {
+	var one string
+	if json.Unmarshal(data, &one) == nil {
+		*a = audience{one}
+		return nil
+	}
+	return json.Unmarshal(data, (*[]string)(a))
+}
+
+// seal encodes v for the named cookie, signed so it can't be forged or
+// passed off as another cookie: a login cookie, which anyone can get,
+// mustn't open as a session.
+func (a *oidcAuth) seal(name string, v any) string {
+	payload, _ := json.Marshal(v)
+	return base64.RawURLEncoding.EncodeToString(payload) + "." + base64.RawURLEncoding.EncodeToString(a.sign(name, payload))
+}
+
+// open decodes the named cookie, sealed by seal, into v, reporting
+// whether it was genuine.
+func (a *oidcAuth) open(name, s string, v any) bool {
+	p, sig, ok := strings.Cut(s, ".")
+	if !ok {
+		return false
+	}
+	payload, err1 := base64.RawURLEncoding.DecodeString(p)
+	got, err2 := base64.RawURLEncoding.DecodeString(sig)
+	if err1 != nil || err2 != nil {
+		return false
+	}
+	return hmac.Equal(got, a.sign(name, payload)) && json.Unmarshal(payload, v) == nil
+}
+
+func (a *oidcAuth) sign(name string, payload []byte) []byte {
+	mac := hmac.New(sha256.New, a.key)
+	mac.Write([]byte(name))
+	mac.Write([]byte{0})
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
+
+func randomHex() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
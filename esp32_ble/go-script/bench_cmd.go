@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"os"
+	"time"
+
+	"bluetooth/esp32"
+)
+
+// runBench measures notification throughput from a firmware test
+// characteristic, for checking antenna placement and stack settings. The
+// stock firmware has no such characteristic, so its UUID is a flag.
+func runBench(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("bench", flag.ExitOnError)
+	namePtr := fs.String("name", "", "Name of the Bluetooth device to benchmark (required)")
+	timeoutPtr := fs.Int("timeout", 30, "Scan timeout in seconds")
+	durationPtr := fs.Duration("duration", 30*time.Second, "How long to receive test notifications")
+	uuidPtr := fs.String("char-uuid", "", "UUID of the firmware's test characteristic (required)")
+	fs.Parse(args)
+
+	for _, required := range []struct{ name, value string }{
+		{"name", *namePtr}, {"char-uuid", *uuidPtr},
+	} {
+		if required.value == "" {
+			msg.Printf("Error: --%s flag is required\n", required.name)
+			msg.Println("\nUsage:")
+			fs.PrintDefaults()
+			os.Exit(1)
+		}
+	}
+
+	client := connectDevice(ctx, *namePtr, time.Duration(*timeoutPtr)*time.Second)
+	msg.Printf("⏱️  Receiving test notifications for %s...\n", *durationPtr)
+	result, err := client.Bench(ctx, *uuidPtr, *durationPtr)
+	client.Disconnect()
+	if err != nil {
+		msg.Printf("❌ Benchmark failed: %v\n", err)
+		os.Exit(1)
+	}
+	if ctx.Err() != nil {
+		msg.Println("\n🛑 Interrupted; results cover the time until then")
+	}
+	printBench(result)
+}
+
+func printBench(r esp32.BenchResult) {
+	msg.Printf("\n📶 MTU: %d\n", r.MTU)
+	msg.Printf("📊 Received %d frame(s), %d bytes in %s\n", r.Received, r.Bytes, r.Duration.Round(time.Millisecond))
+	msg.Printf("🚀 Throughput: %.1f kbps\n", r.Kbps())
+	msg.Printf("📉 Loss: %d frame(s) (%.2f%%)", r.Lost, r.Loss()*100)
+	if r.Late > 0 {
+		msg.Printf(", %d late", r.Late)
+	}
+	if r.Malformed > 0 {
+		msg.Printf(", %d malformed", r.Malformed)
+	}
+	msg.Println()
+	msg.Printf("〰️  Interval: %s mean, %s jitter\n", r.Interval.Round(time.Microsecond), r.Jitter.Round(time.Microsecond))
+}
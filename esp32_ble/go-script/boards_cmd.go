@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"bluetooth/pinmodel"
+)
+
+// runBoards lists the board variants a profile's board can name, or
+// shows one's pins, so a pin map can be laid out before it is wired.
+func runBoards(_ context.Context, args []string) {
+	if len(args) == 0 {
+		for _, name := range pinmodel.Names() {
+			m, err := pinmodel.Lookup(name)
+			if err != nil {
+				msg.Printf("❌ %v\n", err)
+				os.Exit(1)
+			}
+			dac := "no DAC"
+			if len(m.DAC) > 0 {
+				dac = fmt.Sprintf("%d DAC", len(m.DAC))
+			}
+			msg.Printf("%-8s %-9s %d GPIOs, %d ADC, %d touch, %s (%s)\n",
+				name, m.Title, len(m.GPIOs), len(m.ADCPins()), len(m.Touch), dac, m.Modules)
+		}
+		return
+	}
+	m, err := pinmodel.Lookup(args[0])
+	if err != nil {
+		msg.Printf("❌ %v\n", err)
+		os.Exit(1)
+	}
+	msg.Printf("📟 %s (%s)\n", m.Title, m.Modules)
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	msg.Fprintln(tw, "GPIO\tFEATURES")
+	for _, pin := range m.GPIOs {
+		msg.Fprintf(tw, "%d\t%s\n", pin, strings.Join(m.Features(pin), ", "))
+	}
+	tw.Flush()
+}
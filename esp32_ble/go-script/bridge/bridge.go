@@ -0,0 +1,338 @@
+// Package bridge republishes board readings to an MQTT broker and forwards
+// pin writes received over MQTT back to the board.
+package bridge
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+
+	"bluetooth/esp32"
+)
+
+// Options configures a Bridge.
+type Options struct {
+	// TopicPrefix is the first topic level, "esp32" by default.
+	TopicPrefix string
+	// Device is the topic level identifying the board, e.g. its name.
+	Device string
+	// QoS is used for both publishes and command subscriptions.
+	QoS byte
+	// Retain publishes pin values as retained messages, so a subscriber
+	// gets each pin's current value as soon as it subscribes.
+	Retain bool
+	// OnChange publishes a pin only when its value differs from the last
+	// one published, or Refresh after that was published if Refresh is
+	// positive, rather than on every notification.
+	OnChange bool
+	Refresh  time.Duration
+	// Shared means the board's notifications are subscribed to by
+	// something else sharing the connection, such as an api.Server,
+	// which passes them to Publish; Start then only subscribes to the
+	// command topics.
+	Shared bool
+	// OnError, if set, is called with publish and command errors.
+	OnError func(error)
+}
+
+// Bridge connects one board to an MQTT broker. Readings are published to
+// <prefix>/<device>/pin/<n>, and those of pins the profile names to
+// <prefix>/<device>/channel/<name> too. Writes are accepted on
+// <prefix>/<device>/pin/<n>/set and <prefix>/<device>/channel/<name>/set
+// (payload: state) and <prefix>/<device>/set (payload: the firmware's
+// JSON pin_writes document). The board's
+// availability is kept on AvailabilityTopic.
+type Bridge struct {
+	client *esp32.Client
+	mqtt   mqtt.Client
+	opts   Options
+	// ctx bounds writes forwarded from MQTT; set by Start.
+	ctx context.Context
+
+	// pending tracks goroutines waiting on publish tokens.
+	pending sync.WaitGroup
+
+	// published holds each pin's last published value, for OnChange.
+	mu        sync.Mutex
+	published map[uint8]publishedValue
+}
+
+type publishedValue struct {
+	value int
+	at    time.Time
+}
+
+// New returns a bridge between a connected board and an MQTT client. The
+// MQTT client must already be connected. With a nil board the bridge is
+// only for publishing the readings passed to Publish, as of a virtual
+// device, and mustn't be started.
+func New(client *esp32.Client, mqttClient mqtt.Client, opts Options) *Bridge {
+	if opts.TopicPrefix == "" {
+		opts.TopicPrefix = "esp32"
+	}
+	opts.Device = topicSafe(opts.Device)
+	return &Bridge{client: client, mqtt: mqttClient, opts: opts, published: map[uint8]publishedValue{}}
+}
+
+// Payloads of the availability topic.
+const (
+	Online  = "online"
+	Offline = "offline"
+)
+
+// AvailabilityTopic returns <prefix>/<device>/availability, which holds
+// Online or Offline, retained, as Home Assistant's availability_topic
+// expects. A bridge publishes Online once started and Offline when
+// stopped; the MQTT client's last will should publish Offline there too,
+// so the board shows as unavailable if the bridge itself drops.
+func AvailabilityTopic(prefix, device string) string {
+	if prefix == "" {
+		prefix = "esp32"
+	}
+	return prefix + "/" + topicSafe(device) + "/availability"
+}
+
+// PublishAvailability publishes Online or Offline, retained, to the
+// availability topic of the board with the given topic prefix and
+// device, e.g. on connecting to the broker before any board is bridged.
+func PublishAvailability(mqttClient mqtt.Client, prefix, device string, qos byte, online bool) error {
+	payload := Offline
+	if online {
+		payload = Online
+	}
+	if err := wait(mqttClient.Publish(AvailabilityTopic(prefix, device), qos, true, payload)); err != nil {
+		return fmt.Errorf("publishing availability: %w", err)
+	}
+	return nil
+}
+
+// topicSafe replaces characters that have meaning in MQTT topics.
+func topicSafe(s string) string {
+	return strings.NewReplacer("/", "_", "+", "_", "#", "_", " ", "_").Replace(s)
+}
+
+// base returns the topic prefix for this bridge's board.
+func (b *Bridge) base() string {
+	return b.opts.TopicPrefix + "/" + b.opts.Device
+}
+
+// PinTopic returns the topic readings for pin are published to.
+func (b *Bridge) PinTopic(pin uint8) string {
+	return fmt.Sprintf("%s/pin/%d", b.base(), pin)
+}
+
+// ChannelTopic returns the topic readings of the profile's channel name
+// are also published to, which stays put when the channel is moved to
+// another pin.
+func (b *Bridge) ChannelTopic(name string) string {
+	return b.base() + "/channel/" + topicSafe(name)
+}
+
+// Start subscribes to the board's pin and ADC notifications, unless
+// Shared, and to the MQTT command topics. Writes forwarded from MQTT are
+// abandoned once ctx is done.
+func (b *Bridge) Start(ctx context.Context) error {
+	b.ctx = ctx
+	if !b.opts.Shared {
+		if err := b.client.SubscribePins(b.Publish); err != nil {
+			return fmt.Errorf("subscribing to pin data: %w", err)
+		}
+		if err := b.client.SubscribeADC(b.Publish); err != nil {
+			return fmt.Errorf("subscribing to ADC data: %w", err)
+		}
+	}
+
+	filters := map[string]byte{
+		b.base() + "/pin/+/set":     b.opts.QoS,
+		b.base() + "/channel/+/set": b.opts.QoS,
+		b.base() + "/set":           b.opts.QoS,
+	}
+	if err := wait(b.mqtt.SubscribeMultiple(filters, b.handleCommand)); err != nil {
+		return fmt.Errorf("subscribing to command topics: %w", err)
+	}
+	return PublishAvailability(b.mqtt, b.opts.TopicPrefix, b.opts.Device, b.opts.QoS, true)
+}
+
+// PublishGap publishes to <prefix>/<device>/gap that no readings exist
+// for the gap before end, e.g. because the host was asleep. The payload is
+// JSON: {"start": RFC 3339, "end": RFC 3339, "seconds": n}.
+func (b *Bridge) PublishGap(end time.Time, gap time.Duration) {
+	payload, _ := json.Marshal(map[string]any{
+		"start":   end.Add(-gap).Format(time.RFC3339),
+		"end":     end.Format(time.RFC3339),
+		"seconds": gap.Seconds(),
+	})
+	token := b.mqtt.Publish(b.base()+"/gap", b.opts.QoS, false, payload)
+	b.pending.Add(1)
+	go func() {
+		defer b.pending.Done()
+		if err := wait(token); err != nil {
+			b.report(fmt.Errorf("publishing gap: %w", err))
+		}
+	}()
+}
+
+// PublishStats publishes the board's notification and per-characteristic
+// counters to <prefix>/<device>/stats as JSON, so throughput per board can
+// be watched from the broker.
+func (b *Bridge) PublishStats() {
+	s := b.client.NotifyStats()
+	var chars []map[string]any
+	for _, c := range b.client.CharacteristicStats() {
+		chars = append(chars, map[string]any{
+			"uuid":           c.UUID,
+			"reads":          c.Reads,
+			"read_errors":    c.ReadErrors,
+			"writes":         c.Writes,
+			"write_errors":   c.WriteErrors,
+			"notifications":  c.Notifications,
+			"bytes_read":     c.BytesRead,
+			"bytes_written":  c.BytesWritten,
+			"bytes_notified": c.BytesNotified,
+			"error_rate":     c.ErrorRate(),
+		})
+	}
+	payload, _ := json.Marshal(map[string]any{
+		"notifications":   s.Notifications,
+		"bytes":           s.Bytes,
+		"rate":            s.Rate(),
+		"delivered":       s.Delivered,
+		"dropped":         s.Dropped,
+		"throttled":       s.Throttled,
+		"undecodable":     s.Undecodable,
+		"characteristics": chars,
+	})
+	token := b.mqtt.Publish(b.base()+"/stats", b.opts.QoS, false, payload)
+	b.pending.Add(1)
+	go func() {
+		defer b.pending.Done()
+		if err := wait(token); err != nil {
+			b.report(fmt.Errorf("publishing stats: %w", err))
+		}
+	}()
+}
+
+// Stop unsubscribes from the command topics, waits for in-flight
+// publishes to complete and marks the board Offline. The board and MQTT
+// connections are left open.
+func (b *Bridge) Stop() error {
+	err := wait(b.mqtt.Unsubscribe(b.base()+"/pin/+/set", b.base()+"/channel/+/set", b.base()+"/set"))
+	b.pending.Wait()
+	return errors.Join(err, PublishAvailability(b.mqtt, b.opts.TopicPrefix, b.opts.Device, b.opts.QoS, false))
+}
+
+// Publish publishes readings to their pin topics, and those of named
+// pins to their channel topics, skipping unchanged values with OnChange.
+func (b *Bridge) Publish(readings []esp32.Reading) {
+	for _, r := range readings {
+		if !b.changed(r) {
+			continue
+		}
+		topics := []string{b.PinTopic(r.Pin)}
+		if r.Channel != "" {
+			topics = append(topics, b.ChannelTopic(r.Channel))
+		}
+		for _, topic := range topics {
+			token := b.mqtt.Publish(topic, b.opts.QoS, b.opts.Retain, strconv.Itoa(r.Value))
+			b.pending.Add(1)
+			go func() {
+				defer b.pending.Done()
+				if err := wait(token); err != nil {
+					b.report(fmt.Errorf("publishing pin %d: %w", r.Pin, err))
+				}
+			}()
+		}
+	}
+}
+
+// changed reports whether r is to be published, recording it as
+// published if so.
+func (b *Bridge) changed(r esp32.Reading) bool {
+	if !b.opts.OnChange {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	last, ok := b.published[r.Pin]
+	if ok && last.value == r.Value && (b.opts.Refresh <= 0 || time.Since(last.at) < b.opts.Refresh) {
+		return false
+	}
+	b.published[r.Pin] = publishedValue{r.Value, time.Now()}
+	return true
+}
+
+// handleCommand forwards a write received on a command topic to the board.
+func (b *Bridge) handleCommand(_ mqtt.Client, msg mqtt.Message) {
+	writes, err := b.parseCommand(msg.Topic(), msg.Payload())
+	if err != nil {
+		b.report(err)
+		return
+	}
+	ctx, cancel := context.WithTimeout(b.ctx, 10*time.Second)
+	defer cancel()
+	if err := b.client.WritePins(ctx, writes); err != nil {
+		b.report(fmt.Errorf("forwarding %s: %w", msg.Topic(), err))
+	}
+}
+
+// parseCommand converts a command topic and payload to pin writes.
+func (b *Bridge) parseCommand(topic string, payload []byte) ([]esp32.PinWrite, error) {
+	if topic == b.base()+"/set" {
+		var req esp32.PinRequest
+		if err := json.Unmarshal(payload, &req); err != nil {
+			return nil, fmt.Errorf("invalid pin_writes JSON on %s: %w", topic, err)
+		}
+		return req.PinWrites, nil
+	}
+
+	var pin uint8
+	if name, ok := strings.CutPrefix(topic, b.base()+"/channel/"); ok {
+		name = strings.TrimSuffix(name, "/set")
+		if pin, ok = b.channelPin(name); !ok {
+			return nil, fmt.Errorf("unknown channel in topic %s", topic)
+		}
+	} else {
+		rest := strings.TrimPrefix(topic, b.base()+"/pin/")
+		n, err := strconv.ParseUint(strings.TrimSuffix(rest, "/set"), 10, 8)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pin in topic %s", topic)
+		}
+		pin = uint8(n)
+	}
+	state, err := strconv.ParseUint(strings.TrimSpace(string(payload)), 10, 8)
+	if err != nil {
+		return nil, fmt.Errorf("invalid state %q on %s", payload, topic)
+	}
+	return []esp32.PinWrite{{PinNum: pin, State: uint8(state)}}, nil
+}
+
+// channelPin returns the pin of the channel whose topic level is name.
+func (b *Bridge) channelPin(name string) (uint8, bool) {
+	for channel, pin := range b.client.Profile().Channels {
+		if topicSafe(channel) == name {
+			return pin, true
+		}
+	}
+	return 0, false
+}
+
+func (b *Bridge) report(err error) {
+	if b.opts.OnError != nil {
+		b.opts.OnError(err)
+	}
+}
+
+// wait waits for an MQTT token to complete, giving up after 10 seconds.
+func wait(token mqtt.Token) error {
+	if !token.WaitTimeout(10 * time.Second) {
+		return fmt.Errorf("timed out waiting for broker")
+	}
+	return token.Error()
+}
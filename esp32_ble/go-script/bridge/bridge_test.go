@@ -0,0 +1,223 @@
+package bridge
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+
+	"bluetooth/esp32"
+)
+
+// fakeMQTT records what a bridge publishes. Methods it doesn't fake
+// panic on the nil embedded client.
+type fakeMQTT struct {
+	mqtt.Client
+
+	mu        sync.Mutex
+	published []message
+}
+
+type message struct {
+	topic    string
+	retained bool
+	payload  string
+}
+
+func (f *fakeMQTT) Publish(topic string, qos byte, retained bool, payload interface{}) mqtt.Token {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.published = append(f.published, message{topic, retained, fmt.Sprint(payload)})
+	return doneToken{}
+}
+
+func (f *fakeMQTT) SubscribeMultiple(filters map[string]byte, callback mqtt.MessageHandler) mqtt.Token {
+	return doneToken{}
+}
+
+func (f *fakeMQTT) Unsubscribe(topics ...string) mqtt.Token {
+	return doneToken{}
+}
+
+// messages returns what has been published since the last call.
+func (f *fakeMQTT) messages() []message {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	m := f.published
+	f.published = nil
+	return m
+}
+
+// doneToken is a token for an operation that has already completed.
+type doneToken struct{ err error }
+
+func (t doneToken) Wait() bool                     { return true }
+func (t doneToken) WaitTimeout(time.Duration) bool { return true }
+func (t doneToken) Done() <-chan struct{} {
+	done := make(chan struct{})
+	close(done)
+	return done
+}
+func (t doneToken) Error() error { return t.err }
+
+func reading(pin uint8, value int) []esp32.Reading {
+	return []esp32.Reading{{Pin: pin, Value: value}}
+}
+
+func TestPublishOnChange(t *testing.T) {
+	fake := &fakeMQTT{}
+	b := New(nil, fake, Options{Device: "board", OnChange: true})
+
+	b.Publish(reading(14, 1))
+	b.Publish(reading(14, 1))
+	b.Publish(reading(26, 1))
+	b.Publish(reading(14, 0))
+	want := []message{
+		{"esp32/board/pin/14", false, "1"},
+		{"esp32/board/pin/26", false, "1"},
+		{"esp32/board/pin/14", false, "0"},
+	}
+	if got := fake.messages(); !slices.Equal(got, want) {
+		t.Errorf("published %v, want %v", got, want)
+	}
+}
+
+func TestPublishEveryReading(t *testing.T) {
+	fake := &fakeMQTT{}
+	b := New(nil, fake, Options{Device: "board"})
+
+	b.Publish(reading(14, 1))
+	b.Publish(reading(14, 1))
+	if got := fake.messages(); len(got) != 2 {
+		t.Errorf("published %v, want both readings without OnChange", got)
+	}
+}
+
+func TestPublishRefresh(t *testing.T) {
+	fake := &fakeMQTT{}
+	b := New(nil, fake, Options{Device: "board", OnChange: true, Refresh: 50 * time.Millisecond})
+
+	b.Publish(reading(14, 1))
+	b.Publish(reading(14, 1))
+	if got := fake.messages(); len(got) != 1 {
+		t.Fatalf("published %v before the refresh, want the first reading only", got)
+	}
+	time.Sleep(60 * time.Millisecond)
+	b.Publish(reading(14, 1))
+	b.Publish(reading(14, 1))
+	want := []message{{"esp32/board/pin/14", false, "1"}}
+	if got := fake.messages(); !slices.Equal(got, want) {
+		t.Errorf("published %v after the refresh, want %v", got, want)
+	}
+}
+
+func TestPublishRetain(t *testing.T) {
+	for _, retain := range []bool{false, true} {
+		fake := &fakeMQTT{}
+		b := New(nil, fake, Options{Device: "board", Retain: retain})
+
+		b.Publish([]esp32.Reading{{Pin: 35, Channel: "soil", Value: 1234}})
+		want := []message{
+			{"esp32/board/pin/35", retain, "1234"},
+			{"esp32/board/channel/soil", retain, "1234"},
+		}
+		if got := fake.messages(); !slices.Equal(got, want) {
+			t.Errorf("with Retain %v, published %v, want %v", retain, got, want)
+		}
+	}
+}
+
+func TestAvailability(t *testing.T) {
+	fake := &fakeMQTT{}
+	b := New(nil, fake, Options{Device: "board", Shared: true})
+
+	if err := b.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	want := []message{{"esp32/board/availability", true, Online}}
+	if got := fake.messages(); !slices.Equal(got, want) {
+		t.Errorf("Start published %v, want %v", got, want)
+	}
+	if err := b.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	want = []message{{"esp32/board/availability", true, Offline}}
+	if got := fake.messages(); !slices.Equal(got, want) {
+		t.Errorf("Stop published %v, want %v", got, want)
+	}
+}
+
+func TestTopics(t *testing.T) {
+	for _, tc := range []struct {
+		prefix, device string
+		pin, channel   string
+		availability   string
+	}{
+		{"", "board", "esp32/board/pin/14", "esp32/board/channel/soil_moisture", "esp32/board/availability"},
+		{"home", "green house", "home/green_house/pin/14", "home/green_house/channel/soil_moisture", "home/green_house/availability"},
+		{"home", "a/b+c#d", "home/a_b_c_d/pin/14", "home/a_b_c_d/channel/soil_moisture", "home/a_b_c_d/availability"},
+	} {
+		b := New(nil, &fakeMQTT{}, Options{TopicPrefix: tc.prefix, Device: tc.device})
+		if got := b.PinTopic(14); got != tc.pin {
+			t.Errorf("PinTopic(14) for %q %q = %q, want %q", tc.prefix, tc.device, got, tc.pin)
+		}
+		if got := b.ChannelTopic("soil moisture"); got != tc.channel {
+			t.Errorf("ChannelTopic for %q %q = %q, want %q", tc.prefix, tc.device, got, tc.channel)
+		}
+		if got := AvailabilityTopic(tc.prefix, tc.device); got != tc.availability {
+			t.Errorf("AvailabilityTopic(%q, %q) = %q, want %q", tc.prefix, tc.device, got, tc.availability)
+		}
+	}
+}
+
+func TestParseCommand(t *testing.T) {
+	client := &esp32.Client{}
+	client.SetProfile(esp32.Profile{Channels: map[string]uint8{"pump relay": 26}})
+	b := New(client, &fakeMQTT{}, Options{Device: "board"})
+
+	for _, tc := range []struct {
+		topic, payload string
+		want           []esp32.PinWrite
+		err            bool
+	}{
+		{topic: "esp32/board/pin/14/set", payload: "100", want: []esp32.PinWrite{{PinNum: 14, State: 100}}},
+		{topic: "esp32/board/pin/14/set", payload: " 0\n", want: []esp32.PinWrite{{PinNum: 14, State: 0}}},
+		{topic: "esp32/board/pin/255/set", payload: "1", want: []esp32.PinWrite{{PinNum: 255, State: 1}}},
+		{topic: "esp32/board/channel/pump_relay/set", payload: "1", want: []esp32.PinWrite{{PinNum: 26, State: 1}}},
+		{topic: "esp32/board/set", payload: `{"pin_writes":[{"pin_num":14,"state":100},{"pin_num":26,"state":0}]}`,
+			want: []esp32.PinWrite{{PinNum: 14, State: 100}, {PinNum: 26, State: 0}}},
+
+		// Out-of-range pins and states.
+		{topic: "esp32/board/pin/256/set", payload: "1", err: true},
+		{topic: "esp32/board/pin/-1/set", payload: "1", err: true},
+		{topic: "esp32/board/pin/14/set", payload: "256", err: true},
+		{topic: "esp32/board/pin/14/set", payload: "-1", err: true},
+
+		// Malformed topics and payloads.
+		{topic: "esp32/board/pin/x/set", payload: "1", err: true},
+		{topic: "esp32/board/pin//set", payload: "1", err: true},
+		{topic: "esp32/board/pin/14/set", payload: "on", err: true},
+		{topic: "esp32/board/pin/14/set", payload: "", err: true},
+		{topic: "esp32/board/channel/pump relay/set", payload: "1", err: true},
+		{topic: "esp32/board/channel/fan/set", payload: "1", err: true},
+		{topic: "esp32/board/set", payload: "100", err: true},
+		{topic: "esp32/board/set", payload: `{"pin_writes":`, err: true},
+	} {
+		got, err := b.parseCommand(tc.topic, []byte(tc.payload))
+		if tc.err {
+			if err == nil {
+				t.Errorf("parseCommand(%q, %q) = %v, want an error", tc.topic, tc.payload, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseCommand(%q, %q): %v", tc.topic, tc.payload, err)
+		} else if !slices.Equal(got, tc.want) {
+			t.Errorf("parseCommand(%q, %q) = %v, want %v", tc.topic, tc.payload, got, tc.want)
+		}
+	}
+}
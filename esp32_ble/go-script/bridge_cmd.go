@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+
+	"bluetooth/bridge"
+	"bluetooth/esp32"
+)
+
+// runBridge connects to a board and republishes its readings to MQTT,
+// forwarding pin writes from MQTT back to the board, until interrupted. The
+// board is reconnected if its link or the adapter drops. With --retain and
+// --on-change the pin topics hold each pin's current state, published as
+// it changes and refreshed now and then, rather than a stream of samples.
+// The board's availability topic reads online while it is bridged and
+// offline otherwise, set by the MQTT last will if the bridge drops.
+func runBridge(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("bridge", flag.ExitOnError)
+	namePtr := fs.String("name", "", "Name of the Bluetooth device to connect to (required)")
+	timeoutPtr := fs.Int("timeout", 30, "Scan timeout in seconds")
+	brokerPtr := fs.String("broker", "tcp://localhost:1883", "MQTT broker URL")
+	usernamePtr := fs.String("username", "", "MQTT username")
+	passwordPtr := fs.String("password", "", "MQTT password")
+	clientIDPtr := fs.String("client-id", "", "MQTT client ID (default esp32-bridge-<device>)")
+	prefixPtr := fs.String("topic-prefix", "esp32", "First topic level")
+	devicePtr := fs.String("device", "", "Topic level identifying the board (default: --name)")
+	qosPtr := fs.Int("qos", 0, "MQTT QoS for publishes and command subscriptions")
+	retainPtr := fs.Bool("retain", false, "Publish pin values retained, so subscribers get each pin's state as soon as they subscribe")
+	onChangePtr := fs.Bool("on-change", false, "Only publish a pin when its value changes (and every --refresh)")
+	refreshPtr := fs.Duration("refresh", 5*time.Minute, "With --on-change, how often to republish a pin whose value hasn't changed (0 for never)")
+	heartbeatPtr := fs.Duration("heartbeat", 5*time.Second, "How often to check the board is still connected and publish its stats")
+	phyPtr := fs.String("phy", "", "PHY to request after connecting: 1m, 2m or coded")
+	reliable := reliableFlags(fs)
+	transport := transportFlags(fs)
+	notifyLimitPtr := fs.Float64("notify-limit", 0, "Most notifications per second to publish from the board (0 for no limit)")
+	fs.Parse(args)
+
+	port := transport()
+	if port != "" && *namePtr == "" {
+		*namePtr = port
+	}
+	if *namePtr == "" {
+		msg.Println("Error: --name flag is required")
+		msg.Println("\nUsage:")
+		fs.PrintDefaults()
+		os.Exit(1)
+	}
+	phy := parsePHYFlag(*phyPtr)
+	if *devicePtr == "" {
+		*devicePtr = *namePtr
+		if *namePtr == port {
+			// A port path is no good as a topic level.
+			*devicePtr = filepath.Base(port)
+		}
+	}
+	if *clientIDPtr == "" {
+		*clientIDPtr = "esp32-bridge-" + *devicePtr
+	}
+
+	var online atomic.Bool
+	availability := bridge.AvailabilityTopic(*prefixPtr, *devicePtr)
+	msg.Printf("📡 Connecting to MQTT broker %s...\n", *brokerPtr)
+	mqttClient := dialMQTT(mqttOptions{
+		broker:   *brokerPtr,
+		clientID: *clientIDPtr,
+		username: *usernamePtr,
+		password: *passwordPtr,
+		prefix:   *prefixPtr,
+		device:   *devicePtr,
+		qos:      byte(*qosPtr),
+	}, &online)
+	defer mqttClient.Disconnect(250)
+	msg.Printf("✅ Connected to MQTT broker\n\n")
+
+	// gap is the length of the last host sleep, published once the board
+	// is bridged again.
+	var (
+		gapMu sync.Mutex
+		gap   time.Duration
+	)
+
+	msg.Printf("🔍 Scanning for Bluetooth device: \"%s\"\n", *namePtr)
+	session := &esp32.Session{
+		Manager:     esp32.NewManager(adapter),
+		Name:        *namePtr,
+		ScanTimeout: time.Duration(*timeoutPtr) * time.Second,
+		Sleep:       esp32.NewSleepMonitor(ctx, sleepThreshold),
+		WritePolicy: reliable(),
+		OnEvent: func(e esp32.SessionEvent) {
+			printSessionEvent("", e)
+			if e.Kind == esp32.SessionResumed {
+				gapMu.Lock()
+				gap = e.Gap
+				gapMu.Unlock()
+			}
+		},
+	}
+	session.Run(ctx, func(client *esp32.Client) error {
+		requestPHY(ctx, "", client, phy)
+		client.LimitNotifications(*notifyLimitPtr)
+		b := bridge.New(client, mqttClient, bridge.Options{
+			TopicPrefix: *prefixPtr,
+			Device:      *devicePtr,
+			QoS:         byte(*qosPtr),
+			Retain:      *retainPtr,
+			OnChange:    *onChangePtr,
+			Refresh:     *refreshPtr,
+			OnError: func(err error) {
+				msg.Printf("⚠️  %v\n", err)
+			},
+		})
+		if err := b.Start(ctx); err != nil {
+			return err
+		}
+		online.Store(true)
+		defer func() {
+			online.Store(false)
+			if err := b.Stop(); err != nil {
+				msg.Printf("⚠️  %v\n", err)
+			}
+		}()
+		msg.Printf("✅ Bridging %s to %s/%s/pin/<n> (writes on .../pin/<n>/set and .../set, availability on %s)\n",
+			client.Name, *prefixPtr, *devicePtr, availability)
+		gapMu.Lock()
+		if gap > 0 {
+			b.PublishGap(time.Now(), gap)
+			gap = 0
+		}
+		gapMu.Unlock()
+
+		// Notifications don't report a dropped link, so read the pin
+		// characteristic periodically to notice it and reconnect. The
+		// frame isn't decoded: one the decoder rejects is no sign of a
+		// dead link.
+		ticker := time.NewTicker(*heartbeatPtr)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-ticker.C:
+				if _, err := client.ReadRaw(ctx, client.Profile().PinOutputUUID); err != nil {
+					return err
+				}
+				b.PublishStats()
+			}
+		}
+	})
+	msg.Println("\n🔌 Disconnecting...")
+}
+
+// mqttOptions is how to reach the broker for one board's bridge.
+type mqttOptions struct {
+	broker, clientID, username, password string
+	prefix, device                       string
+	qos                                  byte
+}
+
+// dialMQTT connects to the broker for a bridge, exiting if it can't.
+func dialMQTT(o mqttOptions, online *atomic.Bool) mqtt.Client {
+	mqttClient := mqtt.NewClient(mqttClientOptions(o, online))
+	if token := mqttClient.Connect(); !token.WaitTimeout(10*time.Second) || token.Error() != nil {
+		msg.Printf("❌ Failed to connect to MQTT broker: %v\n", token.Error())
+		os.Exit(1)
+	}
+	return mqttClient
+}
+
+// mqttClientOptions returns the client options for a bridge. The last
+// will marks the board offline, and whenever the connection is made the
+// board's availability is republished from online, whether it is
+// bridged, since the broker publishes the will if the connection drops.
+func mqttClientOptions(o mqttOptions, online *atomic.Bool) *mqtt.ClientOptions {
+	return mqtt.NewClientOptions().
+		AddBroker(o.broker).
+		SetClientID(o.clientID).
+		SetUsername(o.username).
+		SetPassword(o.password).
+		SetAutoReconnect(true).
+		SetWill(bridge.AvailabilityTopic(o.prefix, o.device), bridge.Offline, o.qos, true).
+		SetOnConnectHandler(func(c mqtt.Client) {
+			// Handlers mustn't block on the client's tokens.
+			go func() {
+				if err := bridge.PublishAvailability(c, o.prefix, o.device, o.qos, online.Load()); err != nil {
+					msg.Printf("⚠️  %v\n", err)
+				}
+			}()
+		})
+}
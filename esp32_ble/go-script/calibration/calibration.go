@@ -0,0 +1,112 @@
+// Package calibration converts a sensor's raw values to engineering
+// units along a curve of measured points, for sensors too far from
+// linear for a scale and offset, such as soil moisture probes, or, for
+// NTC thermistors, along their datasheet's model.
+package calibration
+
+import (
+	"cmp"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Point is a raw value and the engineering value it stands for.
+type Point struct {
+	Raw   float64 `json:"raw"`
+	Value float64 `json:"value"`
+}
+
+// Curve is at least two points in increasing order of raw value.
+// Values between points are interpolated linearly; beyond the ends they
+// are held at the end points' values, since nothing was measured there.
+type Curve []Point
+
+// New returns the curve through points, in any order. It fails with
+// fewer than two points or with two at the same raw value.
+func New(points []Point) (Curve, error) {
+	if len(points) < 2 {
+		return nil, fmt.Errorf("a curve needs at least 2 points, not %d", len(points))
+	}
+	c := slices.Clone(Curve(points))
+	slices.SortFunc(c, func(a, b Point) int {
+		switch {
+		case a.Raw < b.Raw:
+			return -1
+		case a.Raw > b.Raw:
+			return 1
+		}
+		return 0
+	})
+	for i := 1; i < len(c); i++ {
+		if c[i].Raw == c[i-1].Raw {
+			return nil, fmt.Errorf("raw value %g appears twice", c[i].Raw)
+		}
+	}
+	return c, nil
+}
+
+// ReadCSV reads a curve from rows of a raw value and the engineering
+// value it stands for, such as a datasheet's table or bench
+// measurements. A first row that isn't numbers is a header; lines
+// starting with # are skipped.
+func ReadCSV(r io.Reader) (Curve, error) {
+	cr := csv.NewReader(r)
+	cr.Comment = '#'
+	cr.FieldsPerRecord = 2
+	cr.TrimLeadingSpace = true
+	var points []Point
+	for first := true; ; first = false {
+		record, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		line, _ := cr.FieldPos(0)
+		raw, rawErr := strconv.ParseFloat(strings.TrimSpace(record[0]), 64)
+		value, valueErr := strconv.ParseFloat(strings.TrimSpace(record[1]), 64)
+		if first && rawErr != nil && valueErr != nil {
+			continue
+		}
+		if err := cmp.Or(rawErr, valueErr); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		points = append(points, Point{Raw: raw, Value: value})
+	}
+	return New(points)
+}
+
+// Load reads the curve in the CSV file at path, as ReadCSV does.
+func Load(path string) (Curve, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	c, err := ReadCSV(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return c, nil
+}
+
+// Apply returns the engineering value for raw.
+func (c Curve) Apply(raw float64) float64 {
+	i := sort.Search(len(c), func(i int) bool { return c[i].Raw >= raw })
+	switch {
+	case i == 0:
+		return c[0].Value
+	case i == len(c):
+		return c[len(c)-1].Value
+	}
+	a, b := c[i-1], c[i]
+	return a.Value + (raw-a.Raw)*(b.Value-a.Value)/(b.Raw-a.Raw)
+}
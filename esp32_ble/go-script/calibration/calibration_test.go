@@ -0,0 +1,128 @@
+package calibration_test
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"bluetooth/calibration"
+)
+
+func TestNew(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		points []calibration.Point
+		want   calibration.Curve
+		err    bool
+	}{
+		{name: "sorted", points: []calibration.Point{{0, 100}, {4095, 0}}, want: calibration.Curve{{0, 100}, {4095, 0}}},
+		{name: "unsorted", points: []calibration.Point{{3000, 10}, {1000, 90}, {2000, 50}}, want: calibration.Curve{{1000, 90}, {2000, 50}, {3000, 10}}},
+		{name: "negative raw", points: []calibration.Point{{0, 0}, {-10, -1}}, want: calibration.Curve{{-10, -1}, {0, 0}}},
+
+		{name: "no points", err: true},
+		{name: "one point", points: []calibration.Point{{1000, 50}}, err: true},
+		{name: "repeated raw", points: []calibration.Point{{1000, 50}, {2000, 40}, {1000, 60}}, err: true},
+	} {
+		got, err := calibration.New(tc.points)
+		if tc.err {
+			if err == nil {
+				t.Errorf("%s: New = %v, want an error", tc.name, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: New: %v", tc.name, err)
+		} else if !slices.Equal(got, tc.want) {
+			t.Errorf("%s: New = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestReadCSV(t *testing.T) {
+	for _, tc := range []struct {
+		name, csv string
+		want      calibration.Curve
+		err       bool
+	}{
+		{name: "header", csv: "raw,moisture\n1200,100\n2800,0\n", want: calibration.Curve{{1200, 100}, {2800, 0}}},
+		{name: "no header", csv: "2800, 0\n1200, 100\n", want: calibration.Curve{{1200, 100}, {2800, 0}}},
+		{name: "comments", csv: "# probe 3, bench 2026-01-02\nraw,value\n# wet\n1200,100\n2000,55.5\n2800,0\n",
+			want: calibration.Curve{{1200, 100}, {2000, 55.5}, {2800, 0}}},
+
+		{name: "empty", csv: "", err: true},
+		{name: "header only", csv: "raw,value\n", err: true},
+		{name: "one point", csv: "raw,value\n1200,100\n", err: true},
+		{name: "bad raw", csv: "raw,value\n1200,100\ndry,0\n", err: true},
+		{name: "bad value", csv: "1200,100\n2800,none\n", err: true},
+		{name: "second header", csv: "raw,value\n1200,100\nraw,value\n2800,0\n", err: true},
+		{name: "three columns", csv: "raw,value\n1200,100,1\n2800,0,1\n", err: true},
+		{name: "repeated raw", csv: "1200,100\n1200,90\n", err: true},
+	} {
+		got, err := calibration.ReadCSV(strings.NewReader(tc.csv))
+		if tc.err {
+			if err == nil {
+				t.Errorf("%s: ReadCSV = %v, want an error", tc.name, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: ReadCSV: %v", tc.name, err)
+		} else if !slices.Equal(got, tc.want) {
+			t.Errorf("%s: ReadCSV = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "soil.csv")
+	if err := os.WriteFile(path, []byte("raw,value\n1200,100\n2800,0\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if c, err := calibration.Load(path); err != nil || len(c) != 2 {
+		t.Errorf("Load = %v, %v, want the two points", c, err)
+	}
+
+	bad := filepath.Join(dir, "bad.csv")
+	if err := os.WriteFile(bad, []byte("raw,value\n1200,100\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := calibration.Load(bad); err == nil || !strings.Contains(err.Error(), bad) {
+		t.Errorf("Load of a one-point curve: %v, want an error naming the file", err)
+	}
+	if _, err := calibration.Load(filepath.Join(dir, "missing.csv")); err == nil {
+		t.Error("Load of a missing file succeeded")
+	}
+}
+
+func TestApply(t *testing.T) {
+	// A soil moisture probe reads lower the wetter the soil.
+	c, err := calibration.New([]calibration.Point{{1200, 100}, {2000, 60}, {2800, 0}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		raw, want float64
+	}{
+		{1200, 100},
+		{2000, 60},
+		{2800, 0},
+		{1600, 80},
+		{2400, 30},
+		{2001, 59.925},
+
+		// Out-of-range points hold the end points' values.
+		{1199, 100},
+		{0, 100},
+		{-50, 100},
+		{2801, 0},
+		{4095, 0},
+		{1e9, 0},
+	} {
+		if got := c.Apply(tc.raw); got != tc.want {
+			t.Errorf("Apply(%g) = %g, want %g", tc.raw, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package calibration
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+// Model is an equation relating a thermistor's resistance to its
+// temperature.
+type Model string
+
+const (
+	// Beta is the beta equation, 1/T = 1/T0 + ln(R/R0)/β: the β, R0 and
+	// T0 printed on most datasheets, accurate to about a degree over a
+	// few tens of degrees around T0.
+	Beta Model = "beta"
+	// SteinhartHart is 1/T = A + B ln R + C (ln R)³, with coefficients
+	// fitted to three measured points, accurate over the sensor's range.
+	SteinhartHart Model = "steinhart-hart"
+)
+
+// absoluteZero is 0 K in °C.
+const absoluteZero = -273.15
+
+// Thermistor converts ADC readings of an NTC thermistor in a voltage
+// divider with a fixed resistor to °C. The thermistor is between the ADC
+// pin and ground, the resistor between the supply and the pin, unless
+// HighSide swaps them.
+type Thermistor struct {
+	Model Model `yaml:"model" json:"model"`
+	// Beta, R0 in ohms and T0 in °C, 25 if zero, are the beta
+	// equation's.
+	Beta float64 `yaml:"beta,omitempty" json:"beta,omitempty"`
+	R0   float64 `yaml:"r0,omitempty" json:"r0,omitempty"`
+	T0   float64 `yaml:"t0,omitempty" json:"t0,omitempty"`
+	// A, B and C are the Steinhart–Hart coefficients.
+	A float64 `yaml:"a,omitempty" json:"a,omitempty"`
+	B float64 `yaml:"b,omitempty" json:"b,omitempty"`
+	C float64 `yaml:"c,omitempty" json:"c,omitempty"`
+
+	// SeriesResistor is the divider's fixed resistor in ohms.
+	SeriesResistor float64 `yaml:"series_resistor" json:"series_resistor"`
+	HighSide       bool    `yaml:"high_side,omitempty" json:"high_side,omitempty"`
+	// ADCMax is the reading at the supply voltage, 4095 (the ESP32's 12
+	// bits) if zero.
+	ADCMax float64 `yaml:"adc_max,omitempty" json:"adc_max,omitempty"`
+}
+
+// Validate reports a thermistor missing what its model needs.
+func (t Thermistor) Validate() error {
+	switch t.Model {
+	case Beta:
+		if t.Beta <= 0 || t.R0 <= 0 {
+			return errors.New("the beta model needs beta and r0")
+		}
+	case SteinhartHart:
+		if t.A == 0 && t.B == 0 && t.C == 0 {
+			return errors.New("the steinhart-hart model needs a, b and c")
+		}
+	default:
+		return fmt.Errorf("unknown thermistor model %q (want %s or %s)", t.Model, Beta, SteinhartHart)
+	}
+	if t.SeriesResistor <= 0 {
+		return errors.New("a thermistor needs its divider's series_resistor")
+	}
+	if t.ADCMax < 0 {
+		return errors.New("adc_max can't be negative")
+	}
+	return nil
+}
+
+func (t Thermistor) adcMax() float64 {
+	if t.ADCMax == 0 {
+		return 4095
+	}
+	return t.ADCMax
+}
+
+// Resistance returns the thermistor's resistance in ohms at an ADC
+// reading, which is held a step inside the ADC's range, where the
+// divider gives no answer.
+func (t Thermistor) Resistance(raw float64) float64 {
+	max := t.adcMax()
+	raw = math.Min(math.Max(raw, 1), max-1)
+	if t.HighSide {
+		return t.SeriesResistor * (max - raw) / raw
+	}
+	return t.SeriesResistor * raw / (max - raw)
+}
+
+// Celsius returns the temperature in °C at an ADC reading.
+func (t Thermistor) Celsius(raw float64) float64 {
+	ln := math.Log(t.Resistance(raw))
+	var inverse float64
+	if t.Model == SteinhartHart {
+		inverse = t.A + t.B*ln + t.C*ln*ln*ln
+	} else {
+		t0 := t.T0
+		if t0 == 0 {
+			t0 = 25
+		}
+		inverse = 1/(t0-absoluteZero) + (ln-math.Log(t.R0))/t.Beta
+	}
+	return 1/inverse + absoluteZero
+}
+
+// Curve returns the thermistor's conversion as a curve of points every
+// step of the ADC's range, for what can only apply curves, such as
+// serve's editor.
+func (t Thermistor) Curve(step float64) Curve {
+	var c Curve
+	max := t.adcMax()
+	for raw := 1.0; raw < max-1; raw += step {
+		c = append(c, Point{Raw: raw, Value: t.Celsius(raw)})
+	}
+	return append(c, Point{Raw: max - 1, Value: t.Celsius(max - 1)})
+}
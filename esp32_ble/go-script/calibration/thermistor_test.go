@@ -0,0 +1,147 @@
+package calibration_test
+
+import (
+	"math"
+	"testing"
+
+	"bluetooth/calibration"
+)
+
+// ntc is a common 10 kΩ NTC thermistor, β 3950, below a 10 kΩ resistor.
+var ntc = calibration.Thermistor{Model: calibration.Beta, Beta: 3950, R0: 10000, SeriesResistor: 10000}
+
+// rawAt returns the ADC reading of t at resistance r.
+func rawAt(t calibration.Thermistor, r float64) float64 {
+	max := t.ADCMax
+	if max == 0 {
+		max = 4095
+	}
+	if t.HighSide {
+		return max * t.SeriesResistor / (t.SeriesResistor + r)
+	}
+	return max * r / (t.SeriesResistor + r)
+}
+
+func TestThermistorValidate(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		t    calibration.Thermistor
+		err  bool
+	}{
+		{name: "beta", t: ntc},
+		{name: "steinhart-hart", t: calibration.Thermistor{Model: calibration.SteinhartHart, A: 1.1e-3, B: 2.3e-4, C: 8.6e-8, SeriesResistor: 10000}},
+
+		{name: "no model", t: calibration.Thermistor{Beta: 3950, R0: 10000, SeriesResistor: 10000}, err: true},
+		{name: "unknown model", t: calibration.Thermistor{Model: "ptc", Beta: 3950, R0: 10000, SeriesResistor: 10000}, err: true},
+		{name: "no beta", t: calibration.Thermistor{Model: calibration.Beta, R0: 10000, SeriesResistor: 10000}, err: true},
+		{name: "negative beta", t: calibration.Thermistor{Model: calibration.Beta, Beta: -3950, R0: 10000, SeriesResistor: 10000}, err: true},
+		{name: "no r0", t: calibration.Thermistor{Model: calibration.Beta, Beta: 3950, SeriesResistor: 10000}, err: true},
+		{name: "no coefficients", t: calibration.Thermistor{Model: calibration.SteinhartHart, SeriesResistor: 10000}, err: true},
+		{name: "no series resistor", t: calibration.Thermistor{Model: calibration.Beta, Beta: 3950, R0: 10000}, err: true},
+		{name: "negative series resistor", t: calibration.Thermistor{Model: calibration.Beta, Beta: 3950, R0: 10000, SeriesResistor: -1}, err: true},
+		{name: "negative adc_max", t: calibration.Thermistor{Model: calibration.Beta, Beta: 3950, R0: 10000, SeriesResistor: 10000, ADCMax: -1}, err: true},
+	} {
+		err := tc.t.Validate()
+		if tc.err && err == nil {
+			t.Errorf("%s: Validate accepted %+v", tc.name, tc.t)
+		} else if !tc.err && err != nil {
+			t.Errorf("%s: Validate: %v", tc.name, err)
+		}
+	}
+}
+
+func TestThermistorCelsius(t *testing.T) {
+	steinhartHart := calibration.Thermistor{
+		Model:          calibration.SteinhartHart,
+		A:              1.125308852e-3,
+		B:              2.34711863e-4,
+		C:              8.5663516e-8,
+		SeriesResistor: 10000,
+	}
+	highSide := ntc
+	highSide.HighSide = true
+	// The same thermistor, described by its resistance at 50 °C.
+	r50 := 10000 * math.Exp(3950*(1/323.15-1/298.15))
+	t0 := ntc
+	t0.T0, t0.R0 = 50, r50
+	bits10 := ntc
+	bits10.ADCMax = 1023
+
+	for _, tc := range []struct {
+		name       string
+		t          calibration.Thermistor
+		resistance float64
+		want       float64
+	}{
+		{"beta at R0", ntc, 10000, 25},
+		{"beta cold", ntc, 33620.6, 0},
+		{"beta hot", ntc, 10000 * math.Exp(3950*(1/373.15-1/298.15)), 100},
+		{"high side", highSide, 33620.6, 0},
+		{"T0", t0, r50, 50},
+		{"T0 cold", t0, 33620.6, 0},
+		{"10-bit ADC", bits10, 10000, 25},
+		{"steinhart-hart at 25", steinhartHart, 10000, 25},
+		{"steinhart-hart at 0", steinhartHart, 32650, 0},
+		{"steinhart-hart at 50", steinhartHart, 3603, 50},
+	} {
+		raw := rawAt(tc.t, tc.resistance)
+		if got := tc.t.Resistance(raw); math.Abs(got-tc.resistance) > 1e-6*tc.resistance {
+			t.Errorf("%s: Resistance(%g) = %g, want %g", tc.name, raw, got, tc.resistance)
+		}
+		if got := tc.t.Celsius(raw); math.Abs(got-tc.want) > 0.01 {
+			t.Errorf("%s: Celsius(%g) = %g, want %g", tc.name, raw, got, tc.want)
+		}
+	}
+}
+
+func TestThermistorRailReadings(t *testing.T) {
+	// The divider gives no answer at the rails, where the resistance
+	// would be zero or infinite, so readings there and beyond are held a
+	// step inside.
+	highSide := ntc
+	highSide.HighSide = true
+	for _, th := range []calibration.Thermistor{ntc, highSide} {
+		for _, tc := range []struct {
+			raw, heldAt float64
+		}{
+			{0, 1},
+			{-100, 1},
+			{4095, 4094},
+			{70000, 4094},
+		} {
+			got, want := th.Celsius(tc.raw), th.Celsius(tc.heldAt)
+			if math.IsNaN(got) || math.IsInf(got, 0) || got != want {
+				t.Errorf("high side %v: Celsius(%g) = %g, want %g as at %g", th.HighSide, tc.raw, got, want, tc.heldAt)
+			}
+		}
+	}
+
+	// Below the resistor, a higher reading is a higher resistance, so a
+	// colder thermistor; above it, the other way round.
+	if hot, cold := ntc.Celsius(1), ntc.Celsius(4094); hot <= cold {
+		t.Errorf("low side reads %g at 1 and %g at 4094, want it hotter at 1", hot, cold)
+	}
+	if hot, cold := highSide.Celsius(4094), highSide.Celsius(1); hot <= cold {
+		t.Errorf("high side reads %g at 4094 and %g at 1, want it hotter at 4094", hot, cold)
+	}
+}
+
+func TestThermistorCurve(t *testing.T) {
+	c := ntc.Curve(512)
+	if first, last := c[0], c[len(c)-1]; first.Raw != 1 || last.Raw != 4094 {
+		t.Errorf("curve runs from %g to %g, want 1 to 4094", first.Raw, last.Raw)
+	}
+	for i := 1; i < len(c); i++ {
+		if c[i].Raw <= c[i-1].Raw || c[i].Value >= c[i-1].Value {
+			t.Errorf("points %d and %d, %v and %v, aren't a falling curve", i-1, i, c[i-1], c[i])
+		}
+	}
+	if _, err := calibration.New(c); err != nil {
+		t.Errorf("the curve doesn't make a calibration curve: %v", err)
+	}
+	for _, raw := range []float64{1, 1000, 2047.5, 4094} {
+		if got, want := c.Apply(raw), ntc.Celsius(raw); math.Abs(got-want) > 5 {
+			t.Errorf("curve gives %g at %g, the model %g", got, raw, want)
+		}
+	}
+}
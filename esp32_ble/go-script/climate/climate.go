@@ -0,0 +1,255 @@
+// Package climate packages the two usual greenhouse protections, frost
+// (a heater) and condensation (a fan), as presets switching an output
+// pin from temperature and humidity channels with hysteresis and a
+// minimum run time, so they need a few lines of config rather than
+// hand-written rules.
+package climate
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"bluetooth/calibration"
+	"bluetooth/esp32"
+)
+
+// Preset is the protection a Protector provides.
+type Preset int
+
+const (
+	// Frost runs a heater while the temperature is at or below the
+	// threshold, in °C.
+	Frost Preset = iota + 1
+	// Condensation runs a fan while the temperature is within the
+	// threshold, in °C, of the dew point. It needs a humidity channel.
+	Condensation
+)
+
+func (p Preset) String() string {
+	switch p {
+	case Frost:
+		return "frost"
+	case Condensation:
+		return "condensation"
+	}
+	return fmt.Sprintf("Preset(%d)", int(p))
+}
+
+// Output is what the preset's output pin is expected to drive.
+func (p Preset) Output() string {
+	if p == Condensation {
+		return "fan"
+	}
+	return "heater"
+}
+
+// UnmarshalText parses "frost" or "condensation".
+func (p *Preset) UnmarshalText(text []byte) error {
+	switch string(text) {
+	case "frost":
+		*p = Frost
+	case "condensation":
+		*p = Condensation
+	default:
+		return fmt.Errorf("unknown preset %q (want frost or condensation)", text)
+	}
+	return nil
+}
+
+// Channel is an ADC pin whose value v measures Scale*v + Offset, or
+// Curve's value for v if it has one, for a nonlinear sensor, or the °C
+// Thermistor's model gives if it has one. A zero Scale means 1, so a pin
+// already reporting the measurement (e.g. with the float32 decoder)
+// needs only its pin.
+type Channel struct {
+	Pin    uint8   `yaml:"pin"`
+	Scale  float64 `yaml:"scale"`
+	Offset float64 `yaml:"offset"`
+	// CurveFile is the CSV file of the curve, for the config file to
+	// load into Curve.
+	CurveFile  string                  `yaml:"curve"`
+	Curve      calibration.Curve       `yaml:"-"`
+	Thermistor *calibration.Thermistor `yaml:"thermistor"`
+}
+
+// Convert returns the measurement a value of the channel's pin stands for.
+func (c Channel) Convert(value int) float64 {
+	if c.Thermistor != nil {
+		return c.Thermistor.Celsius(float64(value))
+	}
+	if len(c.Curve) > 0 {
+		return c.Curve.Apply(float64(value))
+	}
+	scale := c.Scale
+	if scale == 0 {
+		scale = 1
+	}
+	return scale*float64(value) + c.Offset
+}
+
+// Protector is one preset watching its channels on a board and switching
+// Output. It turns on when the measurement (the temperature for Frost,
+// its margin over the dew point for Condensation) falls to Threshold,
+// and off once it is back above Threshold+Hysteresis, but not before it
+// has run for MinRun, to spare relays and compressors from cycling.
+type Protector struct {
+	Name        string
+	Preset      Preset
+	Temperature Channel
+	// Humidity is the relative humidity in %, needed by Condensation.
+	Humidity *Channel
+
+	Threshold  float64
+	Hysteresis float64
+	MinRun     time.Duration
+
+	// Output is the pin written OnState when the protector turns on and
+	// 0 when it turns off.
+	Output  uint8
+	OnState uint8
+}
+
+// Validate reports a protector that can't work.
+func (p Protector) Validate() error {
+	if p.Preset != Frost && p.Preset != Condensation {
+		return errors.New("no preset (want frost or condensation)")
+	}
+	if p.Preset == Condensation && p.Humidity == nil {
+		return errors.New("condensation needs a humidity channel")
+	}
+	if p.Hysteresis < 0 || p.MinRun < 0 {
+		return errors.New("hysteresis and min_run can't be negative")
+	}
+	return nil
+}
+
+// DewPoint returns the dew point in °C of air at temperature °C and
+// humidity % relative humidity, by the Magnus formula.
+func DewPoint(temperature, humidity float64) float64 {
+	const b, c = 17.62, 243.12
+	gamma := math.Log(humidity/100) + b*temperature/(c+temperature)
+	return c * gamma / (b - gamma)
+}
+
+// Kind is the kind of an Event.
+type Kind int
+
+// On and Off are the output being switched.
+const (
+	On Kind = iota + 1
+	Off
+)
+
+func (k Kind) String() string {
+	switch k {
+	case On:
+		return "on"
+	case Off:
+		return "off"
+	}
+	return fmt.Sprintf("Kind(%d)", int(k))
+}
+
+// Event is a protector's output being switched. Write is what to send the
+// board, Value the measurement that switched it and, for Off, Duration
+// how long the output ran.
+type Event struct {
+	Protector Protector
+	Kind      Kind
+	Time      time.Time
+	Device    string
+	Address   string
+	Write     esp32.PinWrite
+	Value     float64
+	Duration  time.Duration
+}
+
+// board identifies one board's readings.
+type board struct {
+	device, address string
+}
+
+// state tracks one protector on one board.
+type state struct {
+	on    bool
+	since time.Time // when the output turned on
+}
+
+// Tracker runs protectors against readings. Time is taken from the
+// readings, so recorded data replays with its original timing. Boards
+// are tracked separately.
+type Tracker struct {
+	protectors []Protector
+	states     map[board][]*state
+	values     map[board]map[uint8]int
+}
+
+// NewTracker returns a tracker for protectors.
+func NewTracker(protectors []Protector) *Tracker {
+	return &Tracker{protectors: protectors, states: map[board][]*state{}, values: map[board]map[uint8]int{}}
+}
+
+// Update feeds a reading through the tracker and returns the events it
+// causes. A protector is evaluated when one of its channels reads, once
+// all of them have. Readings other than pin values are ignored.
+func (t *Tracker) Update(r esp32.Reading) []Event {
+	if r.Kind != "" {
+		return nil
+	}
+	b := board{r.Device, r.Address}
+	states, ok := t.states[b]
+	if !ok {
+		for range t.protectors {
+			states = append(states, &state{})
+		}
+		t.states[b] = states
+		t.values[b] = map[uint8]int{}
+	}
+	values := t.values[b]
+	values[r.Pin] = r.Value
+
+	var events []Event
+	for i, p := range t.protectors {
+		if p.Temperature.Pin != r.Pin && (p.Humidity == nil || p.Humidity.Pin != r.Pin) {
+			continue
+		}
+		value, ok := p.measure(values)
+		if !ok {
+			continue
+		}
+		st := states[i]
+		e := Event{Protector: p, Time: r.Time, Device: r.Device, Address: r.Address, Value: value}
+		switch {
+		case !st.on && value <= p.Threshold:
+			st.on, st.since = true, r.Time
+			e.Kind, e.Write = On, esp32.PinWrite{PinNum: p.Output, State: p.OnState}
+		case st.on && value > p.Threshold+p.Hysteresis && r.Time.Sub(st.since) >= p.MinRun:
+			st.on = false
+			e.Kind, e.Write, e.Duration = Off, esp32.PinWrite{PinNum: p.Output, State: 0}, r.Time.Sub(st.since)
+		default:
+			continue
+		}
+		events = append(events, e)
+	}
+	return events
+}
+
+// measure returns the protector's measurement from the latest values of
+// its channels, if all have read.
+func (p Protector) measure(values map[uint8]int) (float64, bool) {
+	raw, ok := values[p.Temperature.Pin]
+	if !ok {
+		return 0, false
+	}
+	temperature := p.Temperature.Convert(raw)
+	if p.Preset == Frost {
+		return temperature, true
+	}
+	raw, ok = values[p.Humidity.Pin]
+	if !ok {
+		return 0, false
+	}
+	return temperature - DewPoint(temperature, p.Humidity.Convert(raw)), true
+}
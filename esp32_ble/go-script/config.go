@@ -0,0 +1,814 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"bluetooth/anomaly"
+	"bluetooth/api"
+	"bluetooth/calibration"
+	"bluetooth/climate"
+	"bluetooth/contact"
+	"bluetooth/esp32"
+	"bluetooth/occupancy"
+	"bluetooth/pinmodel"
+	"bluetooth/rules"
+
+	"gopkg.in/yaml.v3"
+)
+
+// defaultConfigName is the config file looked for in the home directory
+// when --config isn't given.
+const defaultConfigName = ".esp32_interfaces.yaml"
+
+// config is the YAML config file:
+//
+//	profiles:
+//	  greenhouse:
+//	    name: esp32-greenhouse
+//	    address: AA:BB:CC:DD:EE:01
+//	    service_uuid: a9c81b72-0f7a-4c59-b0a8-425e3bcf0a0e
+//	    board: esp32
+//	    characteristics:
+//	      pin_output: 13c0ef83-09bd-4767-97cb-ee46224ae6db
+//	      pin_input: c79b2ca7-f39d-4060-8168-816fa26737b7
+//	      adc_output: 01037594-1bbb-4490-aa4d-f6d333b42e16
+//	      serial_number: 00002a25-0000-1000-8000-00805f9b34fb
+//	    pin_value_bytes: 2
+//	    decoders:
+//	      01037594-1bbb-4490-aa4d-f6d333b42e16: float32
+//	    value_format: {byte_order: little, bits: 12, signed: true}
+//	    decode_mode: lenient
+//	    read_max_age:
+//	      01037594-1bbb-4490-aa4d-f6d333b42e16: 2s
+//	    write_policy:
+//	      c79b2ca7-f39d-4060-8168-816fa26737b7: {idempotent: false, attempts: 3, verify: true}
+//	    poll_interval: 500ms
+//	    contacts:
+//	      - name: greenhouse door
+//	        pin: 14
+//	        alert_after: 10m
+//	    motion:
+//	      - name: potting bench
+//	        pin: 26
+//	        retrigger: 5s
+//	        timeout: 5m
+//	        light: {sensor_pin: 35, dark_below: 800, output: 25, on_state: 1}
+//	    climate:
+//	      - name: seed trays
+//	        preset: frost
+//	        temperature:
+//	          pin: 34
+//	          thermistor: {model: beta, beta: 3950, r0: 10000, series_resistor: 10000}
+//	        output: 27
+//	      - name: glazing
+//	        preset: condensation
+//	        temperature: {pin: 34, scale: 0.1, offset: -40}
+//	        humidity: {pin: 39, scale: 0.025}
+//	        output: 26
+//	        threshold: 3
+//	        min_run: 10m
+//	    anomaly:
+//	      channels: [air-temp, 36]
+//	      baseline_file: greenhouse-baselines.json
+//	    channels:
+//	      air-temp: 34
+//	      vent-fan: 25
+//	    alerts:
+//	      - air-temp>3000 for 10s -> write vent-fan=1 -> mqtt greenhouse/alerts
+//	      - anomaly:air-temp>=6 for 5m -> mqtt greenhouse/alerts
+//	    labels:
+//	      25: vent fan
+//	      34: air temperature
+//	    calibrations:
+//	      34: {scale: 0.1, offset: -40, unit: °C}
+//	      36: {curve: soil-probe.csv, unit: "%"}
+//	      39:
+//	        thermistor: {model: steinhart-hart, a: 1.009249522e-3, b: 2.378405444e-4, c: 2.019202697e-7, series_resistor: 10000}
+//	virtual_devices:
+//	  weather-station:
+//	    channels:
+//	      - {pin: 1, kind: adc, device: esp32-greenhouse, device_pin: 34}
+//	      - {pin: 2, kind: adc, device: esp32-porch, device_pin: 35}
+//	      - {pin: 25, device: esp32-greenhouse}
+//	spaces:
+//	  lab-a:
+//	    token: 8f1c0e5d2b7a
+//	    topic_prefix: lab-a
+//	    journal: /var/lib/esp32/lab-a.csv
+//	    devices: [esp32-greenhouse, AA:BB:CC:DD:EE:02, weather-station]
+//	auth:
+//	  basic:
+//	    users: {alice: pbkdf2-sha256$600000$RxpwizCevu0V+K1+9Jh9PQ$uaSLuSeBGUcmKGyWR52tOZWsYiN1b6xDRVeHAWDfd8A}
+//	  proxy:
+//	    header: Remote-User
+//	    trusted_proxies: [10.0.0.2, 127.0.0.1]
+//	  oidc:
+//	    issuer: https://auth.example.com
+//	    client_id: esp32-gateway
+//	    client_secret: 5a1e0c7d
+//	    users: [alice, bob]
+//
+// Anything left out takes the stock firmware's value. Decoders pick the
+// frame format of characteristics by UUID: pin8, pin16, float32, batch16
+// (several samples per pin), delta16 (snapshots and then changed pins)
+// or one registered with esp32.RegisterDecoder. The value format is how
+// the values of the ADC frame and other 16-bit frames are packed: the
+// byte order, big by default, how many of the 16 bits are the value and
+// whether it is signed. The decode mode is strict, the default, rejecting
+// frames the decoders find inconsistent, or lenient, keeping the pins
+// they can salvage. Read max ages let reads of a characteristic, by
+// UUID, be answered from one that recent, in the REPL, scripts and
+// serve, unless the read asks otherwise. Contacts are door or window
+// sensors on digital pins, reported as they open and close. Motion
+// entries are PIR sensors whose optional light turns on for motion in the
+// dark and off once the area has been vacant for the timeout. Climate
+// entries run a heater below a frost threshold or a fan near the dew
+// point from ADC channels scaled to °C and % relative humidity, read off
+// calibration curves as below or from thermistors' models. Anomaly
+// detection learns the usual readings of its channels for each slot of
+// the day, an hour by default, and reports readings at least the
+// threshold, 4 by default, of standard deviations from them; alert rules
+// can act on the scores as anomaly:CHANNEL. See anomaly.Detector for the
+// slots, window and warmup. Alerts are rules as taken by --alert.
+// Channels name pins for what is wired to them: rules, the REPL and
+// serve's readings, streams and MQTT topics can use the names, so moving
+// a sensor to another pin only means changing its channel here.
+// Labels and calibrations name pins and convert their values to units for
+// people reading them, in serve's editor. A calibration is a scale and
+// offset or, for a nonlinear sensor, a curve: a CSV file, relative to the
+// config file, of raw values and the values they stand for, interpolated
+// between; see calibration.ReadCSV. For an NTC thermistor, it can
+// instead be the thermistor's model, the beta equation (beta, r0 and t0,
+// 25°C by default) or Steinhart–Hart (a, b and c), with the series
+// resistor of its divider in ohms, high_side if the thermistor is between
+// the supply and the pin, and adc_max if the ADC's full scale isn't 4095.
+// The wiring command sets the labels from a wiring file or KiCad netlist.
+// The board is the chip variant, one of those the boards command lists:
+// esp32, the default, esp32s2, esp32s3 or esp32c3. Loading a profile
+// warns of pins the board can't use as configured: outputs on input-only
+// or strapping pins, ADC2 channels that Wi-Fi blocks and the SPI flash
+// pins.
+//
+// Virtual devices are served by serve like boards, but made of channels
+// of several: each channel is a pin of the virtual device, read from a
+// pin of a board, by name or address, the same pin unless device_pin
+// says otherwise, from its pins characteristic or, with kind adc, its
+// ADC one. Writes to a pins channel go to its board.
+//
+// Spaces are for serve --spaces, which serves each space's devices, by
+// name or address, to the clients holding its token alone, bridges them
+// under its topic prefix, the space's name by default, and journals
+// their value changes to its own file. A device belongs to one space,
+// and a virtual device in a space needs its boards there too.
+//
+// Auth configures the providers serve --auth can require of clients of
+// the web pages and API. Basic auth's users are given by a salted hash
+// of their password, as printed by hash-password. Proxy auth takes the
+// user from the header, Remote-User by default, set by an authenticating
+// reverse proxy such as Authelia, from the trusted proxies alone,
+// loopback by default. OIDC signs users in with the issuer, such as
+// Keycloak, as the client, only the listed users if there are any, for
+// sessions of 12 hours unless session says otherwise; the issuer must be
+// https unless it is on loopback, and the redirect URL is /auth/callback
+// on the host the browser used, unless redirect_url says otherwise.
+type config struct {
+	Profiles       map[string]deviceProfile `yaml:"profiles"`
+	VirtualDevices map[string]virtualConfig `yaml:"virtual_devices"`
+	Spaces         map[string]spaceConfig   `yaml:"spaces"`
+	Auth           authConfig               `yaml:"auth"`
+}
+
+// authConfig is the settings of serve's --auth providers.
+type authConfig struct {
+	Basic *basicAuthConfig `yaml:"basic"`
+	Proxy *proxyAuthConfig `yaml:"proxy"`
+	OIDC  *oidcAuthConfig  `yaml:"oidc"`
+}
+
+type basicAuthConfig struct {
+	Realm string `yaml:"realm"`
+	// Users are the hash of each user's password from hash-password.
+	Users map[string]string `yaml:"users"`
+}
+
+type proxyAuthConfig struct {
+	Header         string   `yaml:"header"`
+	TrustedProxies []string `yaml:"trusted_proxies"`
+}
+
+type oidcAuthConfig struct {
+	Issuer       string        `yaml:"issuer"`
+	ClientID     string        `yaml:"client_id"`
+	ClientSecret string        `yaml:"client_secret"`
+	RedirectURL  string        `yaml:"redirect_url"`
+	Scopes       []string      `yaml:"scopes"`
+	Users        []string      `yaml:"users"`
+	Session      time.Duration `yaml:"session"`
+}
+
+// virtualConfig is a virtual device made of channels of several boards.
+type virtualConfig struct {
+	Channels []virtualChannelConfig `yaml:"channels"`
+}
+
+// virtualChannelConfig is pin Pin of a virtual device, pin DevicePin,
+// Pin if unset, of the board Device. Kind is pins, the default, or adc.
+type virtualChannelConfig struct {
+	Pin       uint8  `yaml:"pin"`
+	Kind      string `yaml:"kind"`
+	Device    string `yaml:"device"`
+	DevicePin *uint8 `yaml:"device_pin"`
+}
+
+// devices returns the boards of the virtual device's channels, each once.
+func (v virtualConfig) devices() []string {
+	var devices []string
+	for _, c := range v.Channels {
+		if !slices.Contains(devices, c.Device) {
+			devices = append(devices, c.Device)
+		}
+	}
+	return devices
+}
+
+// channels returns the virtual device's channels for api.NewVirtual,
+// their boards served by servers.
+func (v virtualConfig) channels(servers map[string]*api.Server) []api.Channel {
+	var channels []api.Channel
+	for _, c := range v.Channels {
+		pin := c.Pin
+		if c.DevicePin != nil {
+			pin = *c.DevicePin
+		}
+		channels = append(channels, api.Channel{Source: servers[c.Device], Kind: cmp.Or(c.Kind, api.KindPins), Pin: pin, As: c.Pin})
+	}
+	return channels
+}
+
+// spaceConfig is one of serve's spaces: boards kept apart from those of
+// the others sharing the gateway.
+type spaceConfig struct {
+	Token       string   `yaml:"token"`
+	TopicPrefix string   `yaml:"topic_prefix"`
+	Journal     string   `yaml:"journal"`
+	Devices     []string `yaml:"devices"`
+}
+
+type deviceProfile struct {
+	Name            string `yaml:"name"`
+	Address         string `yaml:"address"`
+	ServiceUUID     string `yaml:"service_uuid"`
+	Board           string `yaml:"board"`
+	Characteristics struct {
+		PinOutput string `yaml:"pin_output"`
+		PinInput  string `yaml:"pin_input"`
+		ADCOutput string `yaml:"adc_output"`
+		// SerialNumber, if set, is a characteristic the stock firmware
+		// doesn't have, holding a serial number or UUID. The board is
+		// identified by it in logs, journals, anomaly baselines and
+		// metrics instead of by its address, so its history survives a
+		// new radio module or a rotating address.
+		SerialNumber string `yaml:"serial_number"`
+	} `yaml:"characteristics"`
+	PinValueBytes int                          `yaml:"pin_value_bytes"`
+	Decoders      map[string]string            `yaml:"decoders"`
+	ValueFormat   valueFormatConfig            `yaml:"value_format"`
+	DecodeMode    esp32.DecodeMode             `yaml:"decode_mode"`
+	ReadMaxAge    map[string]time.Duration     `yaml:"read_max_age"`
+	WritePolicy   map[string]writePolicyConfig `yaml:"write_policy"`
+	PollInterval  time.Duration                `yaml:"poll_interval"`
+	Contacts      []contactConfig              `yaml:"contacts"`
+	Motion        []motionConfig               `yaml:"motion"`
+	Climate       []climateConfig              `yaml:"climate"`
+	Anomaly       *anomalyConfig               `yaml:"anomaly"`
+	Alerts        []string                     `yaml:"alerts"`
+	Channels      map[string]uint8             `yaml:"channels"`
+	Labels        map[uint8]string             `yaml:"labels"`
+	Calibrations  map[uint8]calibrationConfig  `yaml:"calibrations"`
+}
+
+// valueFormatConfig is an esp32.ValueFormat.
+type valueFormatConfig struct {
+	ByteOrder esp32.ByteOrder `yaml:"byte_order"`
+	Bits      int             `yaml:"bits"`
+	Signed    bool            `yaml:"signed"`
+}
+
+// writePolicyConfig is an esp32.WritePolicy for writes to a
+// characteristic, by UUID, used whether or not --reliable is given.
+// Attempts, timeout, backoff and verify are as the --reliable flags.
+// Writes are idempotent unless Idempotent is set false, for a
+// characteristic whose writes act each time they land, like relay
+// toggles or stepper moves, which is then only retried once a read-back
+// (verify) shows a write didn't land.
+type writePolicyConfig struct {
+	Attempts   int           `yaml:"attempts"`
+	Timeout    time.Duration `yaml:"timeout"`
+	Backoff    time.Duration `yaml:"backoff"`
+	Verify     bool          `yaml:"verify"`
+	Idempotent *bool         `yaml:"idempotent"`
+}
+
+// calibrationConfig converts a pin's value v to Scale*v + Offset in Unit,
+// along the curve in the CSV file Curve or, in °C unless Unit says
+// otherwise, by a thermistor's model. A zero Scale means 1, as for
+// climate channels.
+type calibrationConfig struct {
+	Scale      float64                 `yaml:"scale" json:"scale"`
+	Offset     float64                 `yaml:"offset" json:"offset"`
+	Unit       string                  `yaml:"unit" json:"unit"`
+	Curve      string                  `yaml:"curve,omitempty" json:"curve,omitempty"`
+	Thermistor *calibration.Thermistor `yaml:"thermistor,omitempty" json:"thermistor,omitempty"`
+	// Points are the curve's, or the thermistor model's sampled every
+	// thermistorStep, once the config file is parsed, for the editor to
+	// apply.
+	Points calibration.Curve `yaml:"-" json:"points,omitempty"`
+}
+
+// thermistorStep is how far apart in raw value the points the editor
+// interpolates a thermistor's model between are: within a few tenths of
+// a degree of it across a 12-bit ADC's useful range.
+const thermistorStep = 32
+
+// contactConfig is a contact sensor on one of the profile's pins. A
+// nonzero value means open unless open_low is set.
+type contactConfig struct {
+	Name       string        `yaml:"name"`
+	Pin        uint8         `yaml:"pin"`
+	OpenLow    bool          `yaml:"open_low"`
+	AlertAfter time.Duration `yaml:"alert_after"`
+}
+
+// target is what to scan for: the address if set, since it is unique,
+// otherwise the name.
+func (p deviceProfile) target() string {
+	if p.Address != "" {
+		return p.Address
+	}
+	return p.Name
+}
+
+// anomalyConfig learns the usual readings of some of the profile's
+// channels, by pin number or channel name, to score new ones against.
+// BaselineFile, relative to the config file, keeps what is learned from
+// one run to the next.
+type anomalyConfig struct {
+	Channels     []string `yaml:"channels"`
+	Slots        int      `yaml:"slots"`
+	Window       int      `yaml:"window"`
+	Warmup       int      `yaml:"warmup"`
+	Threshold    float64  `yaml:"threshold"`
+	BaselineFile string   `yaml:"baseline_file"`
+}
+
+// detector returns the profile's anomaly detector. Slots default to 24,
+// an hour each, the window to 200 readings, the warmup to 30 readings
+// and the threshold to 4 standard deviations.
+func (p deviceProfile) detector() (anomaly.Detector, error) {
+	a := p.Anomaly
+	d := anomaly.Detector{
+		Slots:     cmp.Or(a.Slots, 24),
+		Window:    cmp.Or(a.Window, 200),
+		Warmup:    cmp.Or(a.Warmup, 30),
+		Threshold: cmp.Or(a.Threshold, 4),
+	}
+	for _, c := range a.Channels {
+		pin, ok := p.Channels[c]
+		if !ok {
+			n, err := strconv.ParseUint(c, 10, 8)
+			if err != nil {
+				return anomaly.Detector{}, fmt.Errorf("unknown channel %q", c)
+			}
+			pin = uint8(n)
+		}
+		d.Pins = append(d.Pins, pin)
+	}
+	return d, d.Validate()
+}
+
+// motionConfig is a PIR motion sensor on one of the profile's pins.
+type motionConfig struct {
+	Name      string        `yaml:"name"`
+	Pin       uint8         `yaml:"pin"`
+	ActiveLow bool          `yaml:"active_low"`
+	Retrigger time.Duration `yaml:"retrigger"`
+	Timeout   time.Duration `yaml:"timeout"`
+	Light     *struct {
+		SensorPin uint8 `yaml:"sensor_pin"`
+		DarkBelow int   `yaml:"dark_below"`
+		Output    uint8 `yaml:"output"`
+		OnState   uint8 `yaml:"on_state"`
+	} `yaml:"light"`
+}
+
+// climateConfig is a frost or condensation preset on the profile's
+// pins.
+type climateConfig struct {
+	Name        string           `yaml:"name"`
+	Preset      climate.Preset   `yaml:"preset"`
+	Temperature climate.Channel  `yaml:"temperature"`
+	Humidity    *climate.Channel `yaml:"humidity"`
+	Output      uint8            `yaml:"output"`
+	OnState     uint8            `yaml:"on_state"`
+	Threshold   *float64         `yaml:"threshold"`
+	Hysteresis  *float64         `yaml:"hysteresis"`
+	MinRun      time.Duration    `yaml:"min_run"`
+}
+
+// protectors returns the profile's climate presets, named after their
+// output pins if no name is given. The threshold defaults to 3°C for
+// frost and a 2°C dew point margin for condensation, the hysteresis to
+// 1°C, the minimum run time to 2 minutes and the on state to 1.
+func (p deviceProfile) protectors() []climate.Protector {
+	var protectors []climate.Protector
+	for _, c := range p.Climate {
+		threshold := 3.0
+		if c.Preset == climate.Condensation {
+			threshold = 2
+		}
+		if c.Threshold != nil {
+			threshold = *c.Threshold
+		}
+		hysteresis := 1.0
+		if c.Hysteresis != nil {
+			hysteresis = *c.Hysteresis
+		}
+		pr := climate.Protector{
+			Name:        cmp.Or(c.Name, fmt.Sprintf("pin %d", c.Output)),
+			Preset:      c.Preset,
+			Temperature: c.Temperature,
+			Humidity:    c.Humidity,
+			Threshold:   threshold,
+			Hysteresis:  hysteresis,
+			MinRun:      cmp.Or(c.MinRun, 2*time.Minute),
+			Output:      c.Output,
+			OnState:     cmp.Or(c.OnState, 1),
+		}
+		protectors = append(protectors, pr)
+	}
+	return protectors
+}
+
+// motionSensors returns the profile's motion sensors, named after their
+// pins if no name is given. The timeout defaults to 5 minutes and a
+// light's on state to 1.
+func (p deviceProfile) motionSensors() []occupancy.Sensor {
+	var sensors []occupancy.Sensor
+	for _, m := range p.Motion {
+		s := occupancy.Sensor{
+			Name:      cmp.Or(m.Name, fmt.Sprintf("pin %d", m.Pin)),
+			Pin:       m.Pin,
+			ActiveLow: m.ActiveLow,
+			Retrigger: m.Retrigger,
+			Timeout:   cmp.Or(m.Timeout, 5*time.Minute),
+		}
+		if m.Light != nil {
+			s.Light = &occupancy.Light{
+				SensorPin: m.Light.SensorPin,
+				DarkBelow: m.Light.DarkBelow,
+				Output:    m.Light.Output,
+				OnState:   cmp.Or(m.Light.OnState, 1),
+			}
+		}
+		sensors = append(sensors, s)
+	}
+	return sensors
+}
+
+// sensors returns the profile's contact sensors, named after their pins
+// if no name is given.
+func (p deviceProfile) sensors() []contact.Sensor {
+	var sensors []contact.Sensor
+	for _, c := range p.Contacts {
+		sensors = append(sensors, contact.Sensor{
+			Name:       cmp.Or(c.Name, fmt.Sprintf("pin %d", c.Pin)),
+			Pin:        c.Pin,
+			OpenLow:    c.OpenLow,
+			AlertAfter: c.AlertAfter,
+		})
+	}
+	return sensors
+}
+
+// pinConflicts returns what is wrong with the pins the profile's
+// contacts, motion sensors and climate entries use, checked against m.
+// Alerts' writes are checked with the --alert rules by parseAlerts.
+func (p deviceProfile) pinConflicts(m *pinmodel.Model) []string {
+	var problems []string
+	check := func(what string, pin uint8, use pinmodel.Use) {
+		for _, problem := range m.Check(pin, use) {
+			problems = append(problems, fmt.Sprintf("%s: %s", what, problem))
+		}
+	}
+	for _, s := range p.sensors() {
+		check(fmt.Sprintf("contact %q", s.Name), s.Pin, pinmodel.DigitalInput)
+	}
+	for _, s := range p.motionSensors() {
+		check(fmt.Sprintf("motion %q", s.Name), s.Pin, pinmodel.DigitalInput)
+		if s.Light != nil {
+			check(fmt.Sprintf("motion %q light sensor", s.Name), s.Light.SensorPin, pinmodel.AnalogInput)
+			check(fmt.Sprintf("motion %q light", s.Name), s.Light.Output, pinmodel.Output)
+		}
+	}
+	for _, pr := range p.protectors() {
+		check(fmt.Sprintf("climate %q temperature", pr.Name), pr.Temperature.Pin, pinmodel.AnalogInput)
+		if pr.Humidity != nil {
+			check(fmt.Sprintf("climate %q humidity", pr.Name), pr.Humidity.Pin, pinmodel.AnalogInput)
+		}
+		check(fmt.Sprintf("climate %q output", pr.Name), pr.Output, pinmodel.Output)
+	}
+	return problems
+}
+
+func (p deviceProfile) esp32Profile() esp32.Profile {
+	return esp32.Profile{
+		ServiceUUID:      p.ServiceUUID,
+		PinOutputUUID:    p.Characteristics.PinOutput,
+		PinInputUUID:     p.Characteristics.PinInput,
+		ADCOutputUUID:    p.Characteristics.ADCOutput,
+		SerialNumberUUID: p.Characteristics.SerialNumber,
+		PinValueBytes:    p.PinValueBytes,
+		Decoders:         p.Decoders,
+		ValueFormat:      esp32.ValueFormat(p.ValueFormat),
+		DecodeMode:       p.DecodeMode,
+		ReadMaxAge:       p.ReadMaxAge,
+		WritePolicies:    p.writePolicies(),
+		Channels:         p.Channels,
+	}
+}
+
+// writePolicies returns the profile's write policies, nil if it has none.
+func (p deviceProfile) writePolicies() map[string]esp32.WritePolicy {
+	if len(p.WritePolicy) == 0 {
+		return nil
+	}
+	policies := map[string]esp32.WritePolicy{}
+	for uuid, c := range p.WritePolicy {
+		policies[uuid] = esp32.WritePolicy{
+			Attempts:      c.Attempts,
+			Timeout:       c.Timeout,
+			Backoff:       c.Backoff,
+			Verify:        c.Verify,
+			NonIdempotent: c.Idempotent != nil && !*c.Idempotent,
+		}
+	}
+	return policies
+}
+
+// loadCurve loads the calibration curve in file, relative to the config
+// file at path, for a channel or calibration that mustn't also have a
+// scale or offset.
+func loadCurve(path, file string, scale, offset float64) (calibration.Curve, error) {
+	if scale != 0 || offset != 0 {
+		return nil, errors.New("a calibration has a curve or a scale and offset, not both")
+	}
+	if !filepath.IsAbs(file) {
+		file = filepath.Join(filepath.Dir(path), file)
+	}
+	curve, err := calibration.Load(file)
+	if errors.Is(err, fs.ErrNotExist) {
+		// Not to be taken for the config file missing.
+		return nil, fmt.Errorf("curve file %s not found", file)
+	}
+	return curve, err
+}
+
+// checkThermistor validates a channel or calibration's thermistor model,
+// which mustn't come with a curve, scale or offset.
+func checkThermistor(th *calibration.Thermistor, curve string, scale, offset float64) error {
+	if curve != "" || scale != 0 || offset != 0 {
+		return errors.New("a calibration has one of a thermistor, a curve or a scale and offset")
+	}
+	return th.Validate()
+}
+
+// readConfig parses a config file, rejecting unknown keys so a typo
+// doesn't silently fall back to a default.
+func readConfig(path string) (*config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseConfig(path, data)
+}
+
+// parseConfig is readConfig for a file already read.
+func parseConfig(path string, data []byte) (*config, error) {
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	var c config
+	if err := dec.Decode(&c); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	for name, p := range c.Profiles {
+		if p.Name == "" && p.Address == "" {
+			return nil, fmt.Errorf("%s: profile %q has neither a name nor an address", path, name)
+		}
+		if err := p.esp32Profile().Validate(); err != nil {
+			return nil, fmt.Errorf("%s: profile %q: %w", path, name, err)
+		}
+		if p.Board != "" {
+			if _, err := pinmodel.Lookup(p.Board); err != nil {
+				return nil, fmt.Errorf("%s: profile %q: %w", path, name, err)
+			}
+		}
+		pins := map[uint8]bool{}
+		for _, c := range p.Contacts {
+			if pins[c.Pin] {
+				return nil, fmt.Errorf("%s: profile %q: pin %d has more than one contact", path, name, c.Pin)
+			}
+			pins[c.Pin] = true
+		}
+		for _, expr := range p.Alerts {
+			if _, err := rules.ParseWith(expr, p.Channels); err != nil {
+				return nil, fmt.Errorf("%s: profile %q: %w", path, name, err)
+			}
+		}
+		for pin, label := range p.Labels {
+			if strings.TrimSpace(label) == "" {
+				return nil, fmt.Errorf("%s: profile %q: pin %d has an empty label", path, name, pin)
+			}
+		}
+		for _, pin := range slices.Sorted(maps.Keys(p.Calibrations)) {
+			cal := p.Calibrations[pin]
+			if cal.Thermistor != nil {
+				if err := checkThermistor(cal.Thermistor, cal.Curve, cal.Scale, cal.Offset); err != nil {
+					return nil, fmt.Errorf("%s: profile %q: pin %d: %w", path, name, pin, err)
+				}
+				cal.Points = cal.Thermistor.Curve(thermistorStep)
+				cal.Unit = cmp.Or(cal.Unit, "°C")
+				p.Calibrations[pin] = cal
+				continue
+			}
+			if cal.Curve == "" {
+				continue
+			}
+			points, err := loadCurve(path, cal.Curve, cal.Scale, cal.Offset)
+			if err != nil {
+				return nil, fmt.Errorf("%s: profile %q: pin %d: %w", path, name, pin, err)
+			}
+			cal.Points = points
+			p.Calibrations[pin] = cal
+		}
+		for i, cc := range p.Climate {
+			for _, ch := range []*climate.Channel{&p.Climate[i].Temperature, p.Climate[i].Humidity} {
+				if ch == nil {
+					continue
+				}
+				if ch.Thermistor != nil {
+					if err := checkThermistor(ch.Thermistor, ch.CurveFile, ch.Scale, ch.Offset); err != nil {
+						return nil, fmt.Errorf("%s: profile %q: climate %q: pin %d: %w", path, name, cmp.Or(cc.Name, fmt.Sprintf("pin %d", cc.Output)), ch.Pin, err)
+					}
+					continue
+				}
+				if ch.CurveFile == "" {
+					continue
+				}
+				points, err := loadCurve(path, ch.CurveFile, ch.Scale, ch.Offset)
+				if err != nil {
+					return nil, fmt.Errorf("%s: profile %q: climate %q: pin %d: %w", path, name, cmp.Or(cc.Name, fmt.Sprintf("pin %d", cc.Output)), ch.Pin, err)
+				}
+				ch.Curve = points
+			}
+		}
+		for _, pr := range p.protectors() {
+			if err := pr.Validate(); err != nil {
+				return nil, fmt.Errorf("%s: profile %q: climate %q: %w", path, name, pr.Name, err)
+			}
+		}
+		if p.Anomaly != nil {
+			if _, err := p.detector(); err != nil {
+				return nil, fmt.Errorf("%s: profile %q: anomaly: %w", path, name, err)
+			}
+			if f := p.Anomaly.BaselineFile; f != "" && !filepath.IsAbs(f) {
+				p.Anomaly.BaselineFile = filepath.Join(filepath.Dir(path), f)
+			}
+		}
+	}
+	for _, name := range slices.Sorted(maps.Keys(c.VirtualDevices)) {
+		if _, ok := c.Profiles[name]; ok || name == "" || strings.Contains(name, "/") {
+			return nil, fmt.Errorf("%s: virtual device %q: a virtual device's name can't be empty, contain / or be a profile's", path, name)
+		}
+		v := c.VirtualDevices[name]
+		if len(v.Channels) == 0 {
+			return nil, fmt.Errorf("%s: virtual device %q has no channels", path, name)
+		}
+		type channel struct {
+			kind string
+			pin  uint8
+		}
+		seen := map[channel]bool{}
+		for _, ch := range v.Channels {
+			kind := cmp.Or(ch.Kind, api.KindPins)
+			if kind != api.KindPins && kind != api.KindADC {
+				return nil, fmt.Errorf("%s: virtual device %q: pin %d: unknown kind %q (want pins or adc)", path, name, ch.Pin, ch.Kind)
+			}
+			if ch.Device == "" {
+				return nil, fmt.Errorf("%s: virtual device %q: pin %d has no device", path, name, ch.Pin)
+			}
+			if _, ok := c.VirtualDevices[ch.Device]; ok {
+				return nil, fmt.Errorf("%s: virtual device %q: pin %d is of virtual device %q", path, name, ch.Pin, ch.Device)
+			}
+			if seen[channel{kind, ch.Pin}] {
+				return nil, fmt.Errorf("%s: virtual device %q: %s pin %d has more than one channel", path, name, kind, ch.Pin)
+			}
+			seen[channel{kind, ch.Pin}] = true
+		}
+	}
+	spaceOf := map[string]string{}
+	for _, name := range slices.Sorted(maps.Keys(c.Spaces)) {
+		sp := c.Spaces[name]
+		if name == "" || strings.Contains(name, "/") {
+			return nil, fmt.Errorf("%s: space %q: a space name can't be empty or contain /", path, name)
+		}
+		if sp.Token == "" {
+			return nil, fmt.Errorf("%s: space %q has no token", path, name)
+		}
+		if len(sp.Devices) == 0 {
+			return nil, fmt.Errorf("%s: space %q has no devices", path, name)
+		}
+		for _, device := range sp.Devices {
+			if other, ok := spaceOf[device]; ok {
+				return nil, fmt.Errorf("%s: device %q is in both space %q and space %q", path, device, other, name)
+			}
+			spaceOf[device] = name
+		}
+		for _, device := range sp.Devices {
+			v, ok := c.VirtualDevices[device]
+			if !ok {
+				continue
+			}
+			for _, board := range v.devices() {
+				if !slices.Contains(sp.Devices, board) {
+					return nil, fmt.Errorf("%s: space %q: virtual device %q needs device %q in the space", path, name, device, board)
+				}
+			}
+		}
+	}
+	if err := c.Auth.validate(); err != nil {
+		return nil, fmt.Errorf("%s: auth: %w", path, err)
+	}
+	return &c, nil
+}
+
+// configPath returns path, or the default config file if it is empty,
+// exiting if there is no home directory to find it in.
+func configPath(path string) string {
+	if path != "" {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		msg.Printf("❌ Failed to find config file: %v\n", err)
+		os.Exit(1)
+	}
+	return filepath.Join(home, defaultConfigName)
+}
+
+// loadConfig reads the config file at path, or the default one if path
+// is empty, exiting on error. It returns the file's path too.
+func loadConfig(path string) (*config, string) {
+	path = configPath(path)
+	c, err := readConfig(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		msg.Printf("❌ Config file %s not found\n", path)
+		os.Exit(1)
+	}
+	if err != nil {
+		msg.Printf("❌ Failed to read config file: %v\n", err)
+		os.Exit(1)
+	}
+	return c, path
+}
+
+// loadProfile reads the named profile from path, or from the default
+// config file if path is empty, exiting on error. Its board, if set,
+// becomes the pin model.
+func loadProfile(path, name string) deviceProfile {
+	c, path := loadConfig(path)
+	p, ok := c.Profiles[name]
+	if !ok {
+		msg.Printf("❌ Profile %q not found in %s\n", name, path)
+		os.Exit(1)
+	}
+	msg.Printf("📋 Using profile %q from %s\n", name, path)
+	if p.Board != "" {
+		pinModel, _ = pinmodel.Lookup(p.Board)
+	}
+	for _, problem := range p.pinConflicts(pinModel) {
+		msg.Printf("⚠️  Profile %q: %s\n", name, problem)
+	}
+	return p
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"bluetooth/esp32"
+)
+
+// runConformance checks a board's firmware against the pin service
+// protocol with Client.Conformance, printing each check as it finishes,
+// as a contract test for firmware developers. Pins are only driven when
+// listed in --write-pins. It exits non-zero if any check failed.
+func runConformance(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("conformance", flag.ExitOnError)
+	namePtr := fs.String("name", "", "Name of the Bluetooth device to check (required)")
+	timeoutPtr := fs.Int("timeout", 30, "Scan timeout in seconds")
+	writePinsPtr := fs.String("write-pins", "", "Comma-separated output pins the write check may drive and put back (default: skip it)")
+	selfTestPtr := fs.String("selftest-uuid", "", "UUID of the self-test characteristic, to check its protocol too")
+	waitPtr := fs.Duration("wait", 5*time.Second, "How long to wait for a notification or a written pin to report")
+	jsonPtr := fs.Bool("json", false, "Print the report as JSON on stdout (progress goes to stderr)")
+	fs.Parse(args)
+
+	if *namePtr == "" {
+		msg.Println("Error: --name flag is required")
+		msg.Println("\nUsage:")
+		fs.PrintDefaults()
+		os.Exit(1)
+	}
+	pins, err := parsePinList(*writePinsPtr)
+	if err != nil {
+		msg.Printf("❌ --write-pins: %v\n", err)
+		os.Exit(1)
+	}
+
+	stdout := os.Stdout
+	if *jsonPtr {
+		os.Stdout = os.Stderr
+	}
+	client := connectDevice(ctx, *namePtr, time.Duration(*timeoutPtr)*time.Second)
+	msg.Println("📋 Checking protocol conformance")
+	start := time.Now()
+	report, err := client.Conformance(ctx, esp32.ConformanceOptions{
+		WritePins:    pins,
+		Timeout:      *waitPtr,
+		SelfTestUUID: *selfTestPtr,
+		Check: func(c esp32.ConformanceCheck) {
+			icon := map[esp32.ConformanceStatus]string{esp32.ConformancePassed: "✅", esp32.ConformanceFailed: "❌", esp32.ConformanceSkipped: "⏭️ "}[c.Status]
+			msg.Printf("   %s %-22s %s", icon, c.Name, c.Status)
+			if c.Detail != "" {
+				msg.Printf(": %s", c.Detail)
+			}
+			msg.Println()
+		},
+	})
+	client.Disconnect()
+	if ctx.Err() != nil {
+		msg.Println("🛑 Interrupted")
+		os.Exit(1)
+	}
+	if err != nil {
+		msg.Printf("❌ Conformance check failed: %v\n", err)
+		os.Exit(1)
+	}
+
+	if *jsonPtr {
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(report)
+	}
+	counts := map[esp32.ConformanceStatus]int{}
+	for _, c := range report.Checks {
+		counts[c.Status]++
+	}
+	summary := fmt.Sprintf("%d passed, %d failed, %d skipped in %s", counts[esp32.ConformancePassed],
+		counts[esp32.ConformanceFailed], counts[esp32.ConformanceSkipped], time.Since(start).Round(time.Millisecond))
+	if !report.Passed() {
+		msg.Printf("❌ Conformance FAILED (%s)\n", summary)
+		os.Exit(1)
+	}
+	msg.Printf("✅ Conformance passed (%s)\n", summary)
+}
@@ -0,0 +1,121 @@
+// Package contact turns digital pin readings from door and window contact
+// sensors into open and closed events, tracking how long each stays open.
+package contact
+
+import (
+	"fmt"
+	"time"
+
+	"bluetooth/esp32"
+)
+
+// Sensor is a contact sensor wired to a digital pin. By default a nonzero
+// value means open, as with a reed switch pulling the pin up when the
+// magnet moves away; OpenLow inverts that.
+type Sensor struct {
+	Name    string
+	Pin     uint8
+	OpenLow bool
+	// AlertAfter, if positive, raises a LeftOpen event once the sensor
+	// has been open this long.
+	AlertAfter time.Duration
+}
+
+// Open reports whether value means the sensor is open.
+func (s Sensor) Open(value int) bool {
+	return (value != 0) != s.OpenLow
+}
+
+// Kind is the kind of an Event.
+type Kind int
+
+const (
+	Opened Kind = iota + 1
+	Closed
+	LeftOpen
+)
+
+func (k Kind) String() string {
+	switch k {
+	case Opened:
+		return "opened"
+	case Closed:
+		return "closed"
+	case LeftOpen:
+		return "left open"
+	}
+	return fmt.Sprintf("Kind(%d)", int(k))
+}
+
+// Event is a change of a sensor's state. For Closed and LeftOpen,
+// Duration is how long it had been open.
+type Event struct {
+	Sensor   Sensor
+	Kind     Kind
+	Reading  esp32.Reading
+	Duration time.Duration
+}
+
+// key identifies one sensor on one board.
+type key struct {
+	device, address string
+	pin             uint8
+}
+
+// state tracks a sensor between readings.
+type state struct {
+	open    bool
+	since   time.Time // when it last opened
+	alerted bool      // LeftOpen already raised for this opening
+}
+
+// Tracker follows a set of sensors through pin readings. Time is taken
+// from the readings, so recorded data replays with its original timing.
+// Boards are tracked separately, so one tracker can serve several.
+type Tracker struct {
+	sensors map[uint8]Sensor
+	states  map[key]*state
+}
+
+// NewTracker returns a tracker for sensors, which must be on distinct
+// pins.
+func NewTracker(sensors []Sensor) *Tracker {
+	t := &Tracker{sensors: map[uint8]Sensor{}, states: map[key]*state{}}
+	for _, s := range sensors {
+		t.sensors[s.Pin] = s
+	}
+	return t
+}
+
+// Update feeds a reading through the tracker and returns the events it
+// causes. The first reading of a sensor only sets its state, unless it is
+// open, which raises Opened so open-duration alerts still apply. Readings
+// other than pin values, and of pins without a sensor, are ignored.
+func (t *Tracker) Update(r esp32.Reading) []Event {
+	sensor, ok := t.sensors[r.Pin]
+	if r.Kind != "" || !ok {
+		return nil
+	}
+	open := sensor.Open(r.Value)
+	k := key{r.Device, r.Address, r.Pin}
+	s, seen := t.states[k]
+	if !seen {
+		s = &state{}
+		t.states[k] = s
+	}
+
+	var events []Event
+	switch {
+	case open && (!seen || !s.open):
+		*s = state{open: true, since: r.Time}
+		events = append(events, Event{Sensor: sensor, Kind: Opened, Reading: r})
+	case !open && s.open:
+		events = append(events, Event{Sensor: sensor, Kind: Closed, Reading: r, Duration: r.Time.Sub(s.since)})
+		*s = state{}
+	}
+	if s.open && !s.alerted && sensor.AlertAfter > 0 && r.Time.Sub(s.since) >= sensor.AlertAfter {
+		s.alerted = true
+		events = append(events, Event{Sensor: sensor, Kind: LeftOpen, Reading: r, Duration: r.Time.Sub(s.since)})
+	}
+	return events
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"slices"
+	"strings"
+	"time"
+
+	"bluetooth/esp32"
+	"bluetooth/esp32/mock"
+)
+
+// The demo board and the UUIDs of the optional firmware protocols it has,
+// which the stock firmware doesn't.
+const (
+	demoName            = "esp32-demo"
+	demoAddress         = "DE:AD:BE:EF:00:01"
+	demoSelfTestUUID    = "de300000-0000-4000-8000-000000000001"
+	demoOTADataUUID     = "de300000-0000-4000-8000-000000000002"
+	demoOTAControlUUID  = "de300000-0000-4000-8000-000000000003"
+	demoDownloadUUID    = "de300000-0000-4000-8000-000000000004"
+	demoDownloadControl = "de300000-0000-4000-8000-000000000005"
+)
+
+// demoInterval is how often the demo board notifies, faster than the
+// stock firmware's 2 seconds so the waveforms move.
+const demoInterval = 500 * time.Millisecond
+
+// demoNamed are the commands demo gives --name to when it isn't given.
+var demoNamed = []string{
+	"bench", "bridge", "conformance", "download", "explore", "monitor-rssi",
+	"ota", "preset", "selftest", "serve", "snapshot", "walk-test", "write",
+}
+
+// startDemo swaps the Bluetooth adapter for one that only sees a
+// simulated board, so `demo [command] [flags]` runs any command, the REPL
+// or the web UI with no ESP32 flashed. It returns os.Args with "demo"
+// taken out and the board's --name added where the command needs one
+// and none was given. The board runs until ctx is done.
+func startDemo(ctx context.Context, args []string) []string {
+	board := mock.NewBoard(demoName, demoAddress)
+	board.EnableSelfTest(demoSelfTestUUID)
+	board.EnableOTA(demoOTADataUUID, demoOTAControlUUID)
+	board.EnableDownload(demoDownloadUUID, demoDownloadControl)
+	sensors := newDemoSensors(time.Now().UnixNano())
+	board.SetDownload(sensors.history(time.Now(), 24*time.Hour, time.Minute), esp32.CompressionNone)
+	sensors.update(board, time.Now())
+	adapter = mock.NewAdapter(board)
+	go sensors.run(ctx, board)
+
+	msg.Printf("🎭 Demo mode: simulating %s (%s); nothing is sent over Bluetooth\n", demoName, demoAddress)
+	msg.Println("   ADC 35 is a thermistor, ADC 32 a light sensor and pin 14 a motion sensor;")
+	msg.Println("   pins 26, 25 and 33 take writes. It also has the optional protocols:")
+	msg.Printf("   selftest --uuid %s\n", demoSelfTestUUID)
+	msg.Printf("   ota --data-uuid %s --control-uuid %s\n", demoOTADataUUID, demoOTAControlUUID)
+	msg.Printf("   download --data-uuid %s --control-uuid %s\n\n", demoDownloadUUID, demoDownloadControl)
+
+	rest := args[2:]
+	if slices.ContainsFunc(rest, isNameFlag) {
+		return append(args[:1], rest...)
+	}
+	switch {
+	case len(rest) == 0:
+		// Bare demo watches the waveforms.
+		rest = []string{"--name", demoName, "--poll", "1s"}
+	case strings.HasPrefix(rest[0], "-"):
+		rest = append([]string{"--name", demoName}, rest...)
+	case slices.Contains(demoNamed, rest[0]):
+		rest = append([]string{rest[0], "--name", demoName}, rest[1:]...)
+	}
+	return append(args[:1], rest...)
+}
+
+// isNameFlag reports whether arg is a --name flag, in any of the forms
+// the flag package accepts.
+func isNameFlag(arg string) bool {
+	name, _, _ := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+	return strings.HasPrefix(arg, "-") && name == "name"
+}
+
+// demoSensors generates the demo board's readings.
+type demoSensors struct {
+	rng   *rand.Rand
+	start time.Time
+	// cloud is how much of the light passing clouds block, 0 to 1,
+	// drifting at random.
+	cloud float64
+	// motion is how many more updates pin 14 stays high for.
+	motion int
+}
+
+func newDemoSensors(seed int64) *demoSensors {
+	return &demoSensors{rng: rand.New(rand.NewSource(seed)), start: time.Now()}
+}
+
+// run updates and notifies the board every demoInterval until ctx is
+// done.
+func (s *demoSensors) run(ctx context.Context, board *mock.Board) {
+	ticker := time.NewTicker(demoInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			s.update(board, now)
+			board.Notify()
+		}
+	}
+}
+
+// update sets the board's sensors to their readings at now.
+func (s *demoSensors) update(board *mock.Board, now time.Time) {
+	temperature, light := s.adc(now)
+	board.SetADC(35, temperature)
+	board.SetADC(32, light)
+	if s.motion > 0 {
+		s.motion--
+	} else if s.rng.Float64() < 0.03 {
+		s.motion = 4 + s.rng.Intn(8)
+	}
+	state := uint8(0)
+	if s.motion > 0 {
+		state = 1
+	}
+	board.SetPin(14, state)
+}
+
+// adc returns the 12-bit thermistor and light sensor readings at now: a
+// room warming and cooling over a few minutes, and a bright sky with
+// clouds drifting across, each with a little noise.
+func (s *demoSensors) adc(now time.Time) (temperature, light uint16) {
+	elapsed := now.Sub(s.start).Seconds()
+	t := 2000 + 300*math.Sin(2*math.Pi*elapsed/180) + s.rng.NormFloat64()*8
+	s.cloud = min(max(s.cloud+s.rng.NormFloat64()*0.05, 0), 0.8)
+	l := 3200*(1-s.cloud) + 200*math.Sin(2*math.Pi*elapsed/600) + s.rng.NormFloat64()*15
+	return demoADC(t), demoADC(l)
+}
+
+// history returns a CSV log of the sensors over span up to now, one row
+// of timestamp,pin,value per ADC pin every step, for the board's bulk
+// download.
+func (s *demoSensors) history(now time.Time, span, step time.Duration) []byte {
+	var b strings.Builder
+	past := &demoSensors{rng: rand.New(rand.NewSource(s.rng.Int63())), start: s.start}
+	for at := now.Add(-span); at.Before(now); at = at.Add(step) {
+		temperature, light := past.adc(at)
+		fmt.Fprintf(&b, "%d,35,%d\n%d,32,%d\n", at.Unix(), temperature, at.Unix(), light)
+	}
+	return []byte(b.String())
+}
+
+// demoADC clamps v to the ADC's 12-bit range.
+func demoADC(v float64) uint16 {
+	return uint16(min(max(math.Round(v), 0), 4095))
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"bluetooth/esp32"
+)
+
+// downloadProgress is what download saves to <out>.progress as frames
+// arrive, with their payload in <out>.part, so an interrupted download
+// resumes rather than starting over.
+type downloadProgress struct {
+	// ID is the board's, so a download resumes once its address has
+	// changed.
+	ID          string `json:"id"`
+	DataUUID    string `json:"data_uuid"`
+	ControlUUID string `json:"control_uuid"`
+	Header      []byte `json:"header"`
+	Next        int    `json:"next"`
+	Bytes       int    `json:"bytes"`
+}
+
+// runDownload fetches a board's buffered backfill or burst capture over
+// its bulk transfer characteristics, which the stock firmware doesn't
+// have, so their UUIDs are given on the command line like ota's.
+func runDownload(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("download", flag.ExitOnError)
+	namePtr := fs.String("name", "", "Name of the Bluetooth device to download from (required)")
+	timeoutPtr := fs.Int("timeout", 30, "Scan timeout in seconds")
+	outPtr := fs.String("out", "", "File to write the downloaded data to (required)")
+	dataPtr := fs.String("data-uuid", "", "UUID of the bulk transfer data characteristic (required)")
+	controlPtr := fs.String("control-uuid", "", "UUID of the bulk transfer control characteristic (required)")
+	windowPtr := fs.Int("window", 16, "Frames the board sends before waiting for an acknowledgment (1-255)")
+	acceptPtr := fs.String("compression", "zlib,heatshrink", "Comma-separated compressions the board may use (none to only accept uncompressed transfers)")
+	progressFormat := progressFlags(fs)
+	fs.Parse(args)
+
+	for _, required := range []struct{ name, value string }{
+		{"name", *namePtr}, {"out", *outPtr}, {"data-uuid", *dataPtr}, {"control-uuid", *controlPtr},
+	} {
+		if required.value == "" {
+			msg.Printf("Error: --%s flag is required\n", required.name)
+			msg.Println("\nUsage:")
+			fs.PrintDefaults()
+			os.Exit(1)
+		}
+	}
+	var accept []esp32.Compression
+	for _, name := range strings.Split(*acceptPtr, ",") {
+		c, err := esp32.ParseCompression(strings.TrimSpace(name))
+		if err != nil {
+			msg.Printf("❌ Invalid --compression: %v\n", err)
+			os.Exit(1)
+		}
+		accept = append(accept, c)
+	}
+
+	client := connectDevice(ctx, *namePtr, time.Duration(*timeoutPtr)*time.Second)
+	progressPath, partPath := *outPtr+".progress", *outPtr+".part"
+	saved := loadDownloadProgress(progressPath, partPath)
+	part, err := os.OpenFile(partPath, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		msg.Printf("❌ Failed to open %s: %v\n", partPath, err)
+		os.Exit(1)
+	}
+	opts := esp32.DownloadOptions{
+		DataUUID:    *dataPtr,
+		ControlUUID: *controlPtr,
+		Accept:      accept,
+		Window:      *windowPtr,
+	}
+	if saved != nil && saved.ID == client.ID && saved.DataUUID == *dataPtr && saved.ControlUUID == *controlPtr {
+		payload := make([]byte, saved.Bytes)
+		if _, err := part.ReadAt(payload, 0); err == nil {
+			opts.Resume = &esp32.DownloadCheckpoint{Header: saved.Header, Next: saved.Next, Payload: payload}
+			msg.Printf("⏯️  Resuming download after %d bytes\n", saved.Bytes)
+		}
+	}
+	written := 0
+	if opts.Resume != nil {
+		written = len(opts.Resume.Payload)
+	}
+	part.Truncate(int64(written))
+
+	msg.Println("📥 Downloading")
+	start := time.Now()
+	bar := progressFormat("download")
+	opts.Progress = bar.update
+	opts.Checkpoint = func(c esp32.DownloadCheckpoint) {
+		// The payload only grows, unless the board's data changed and
+		// the download started over.
+		if len(c.Payload) < written {
+			part.Truncate(0)
+			written = 0
+		}
+		if _, err := part.WriteAt(c.Payload[written:], int64(written)); err != nil {
+			return
+		}
+		written = len(c.Payload)
+		saveJSON(progressPath, downloadProgress{
+			ID: client.ID, DataUUID: *dataPtr, ControlUUID: *controlPtr,
+			Header: c.Header, Next: c.Next, Bytes: written,
+		})
+	}
+	result, err := client.Download(ctx, opts)
+	bar.end(err)
+	client.Disconnect()
+	part.Close()
+	if ctx.Err() != nil {
+		msg.Println("🛑 Interrupted; run again to resume")
+		os.Exit(1)
+	}
+	if err != nil {
+		msg.Printf("❌ Download failed: %v; run again to resume\n", err)
+		os.Exit(1)
+	}
+	if err := os.WriteFile(*outPtr, result.Data, 0o644); err != nil {
+		msg.Printf("❌ Failed to write %s: %v\n", *outPtr, err)
+		os.Exit(1)
+	}
+	os.Remove(progressPath)
+	os.Remove(partPath)
+	how := "uncompressed"
+	if result.Compression != esp32.CompressionNone {
+		how = fmt.Sprintf("%d bytes %s-compressed", result.Transferred, result.Compression)
+	}
+	if result.Resumed > 0 {
+		how += fmt.Sprintf(", %d bytes resumed", result.Resumed)
+	}
+	if result.Resent > 0 {
+		how += fmt.Sprintf(", %d lost frame(s) resent", result.Resent)
+	}
+	msg.Printf("✅ Downloaded %d bytes (%s) in %s to %s; length and CRC32 verified\n",
+		len(result.Data), how, time.Since(start).Round(time.Millisecond), *outPtr)
+}
+
+// loadDownloadProgress returns the progress saved by an interrupted
+// download, or nil if there is none or its payload was lost.
+func loadDownloadProgress(progressPath, partPath string) *downloadProgress {
+	var saved downloadProgress
+	if !loadJSON(progressPath, &saved) {
+		return nil
+	}
+	if info, err := os.Stat(partPath); err != nil || info.Size() < int64(saved.Bytes) {
+		return nil
+	}
+	return &saved
+}
+
+// saveJSON writes v to path as JSON, replacing it atomically so an
+// interruption never leaves it half written. Failures are ignored: a
+// transfer carries on without its progress saved.
+func saveJSON(path string, v any) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return
+	}
+	if err := os.WriteFile(path+".tmp", b, 0o644); err == nil {
+		os.Rename(path+".tmp", path)
+	}
+}
+
+// loadJSON reads JSON written by saveJSON into v, reporting whether there
+// was any.
+func loadJSON(path string, v any) bool {
+	b, err := os.ReadFile(path)
+	return err == nil && json.Unmarshal(b, v) == nil
+}
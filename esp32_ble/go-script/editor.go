@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"mime"
+	"net/http"
+	"os"
+	"sync"
+
+	"bluetooth/rules"
+
+	"gopkg.in/yaml.v3"
+)
+
+//go:embed web/editor.html
+var editorPage []byte
+
+// profileEdit is the part of a profile the editor changes; the rest,
+// like the board and its UUIDs, is left to the config file.
+type profileEdit struct {
+	Labels       map[uint8]string            `json:"labels" yaml:"labels"`
+	Calibrations map[uint8]calibrationConfig `json:"calibrations" yaml:"calibrations"`
+	Alerts       []string                    `json:"alerts" yaml:"alerts"`
+}
+
+// editor serves a web page editing one profile's labels, calibrations and
+// alert rules, writing changes back to the config file once they pass
+// the same checks as loading it. Comments and the rest of the file are
+// kept.
+//
+//	GET /editor          the page
+//	GET /editor/profile  the profile's profileEdit as JSON
+//	PUT /editor/profile  replace it; errors are {"error": message} with 400
+type editor struct {
+	path    string
+	profile string
+
+	mu sync.Mutex // one save at a time
+}
+
+func (e *editor) register(mux *http.ServeMux) {
+	mux.HandleFunc("GET /editor", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(msg.Page(editorPage))
+	})
+	mux.HandleFunc("GET /editor/profile", e.handleGet)
+	mux.HandleFunc("PUT /editor/profile", e.handlePut)
+}
+
+func (e *editor) handleGet(w http.ResponseWriter, r *http.Request) {
+	c, err := readConfig(e.path)
+	if err != nil {
+		writeEditorJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+	p, ok := c.Profiles[e.profile]
+	if !ok {
+		writeEditorJSON(w, http.StatusNotFound, map[string]string{"error": fmt.Sprintf("profile %q not found in %s", e.profile, e.path)})
+		return
+	}
+	writeEditorJSON(w, http.StatusOK, map[string]any{
+		"profile": e.profile,
+		"name":    p.Name,
+		"edit":    profileEdit{Labels: p.Labels, Calibrations: p.Calibrations, Alerts: p.Alerts},
+	})
+}
+
+func (e *editor) handlePut(w http.ResponseWriter, r *http.Request) {
+	// Requiring JSON keeps other sites' pages from submitting forms here.
+	if mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mt != "application/json" {
+		writeEditorJSON(w, http.StatusUnsupportedMediaType, map[string]string{"error": "want application/json"})
+		return
+	}
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	var edit profileEdit
+	if err := dec.Decode(&edit); err != nil {
+		writeEditorJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("invalid profile JSON: %v", err)})
+		return
+	}
+	for i, expr := range edit.Alerts {
+		if _, err := rules.ParseWith(expr, profile.Channels); err != nil {
+			writeEditorJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("rule %d: %v", i+1, err)})
+			return
+		}
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	data, err := applyProfileEdit(e.path, e.profile, edit)
+	if err != nil {
+		writeEditorJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	// Keep the config file's permissions, which may hide secrets.
+	mode := fs.FileMode(0o644)
+	if fi, err := os.Stat(e.path); err == nil {
+		mode = fi.Mode().Perm()
+	}
+	if err := writeFileAtomic(e.path, bytes.NewReader(data), mode); err != nil {
+		writeEditorJSON(w, http.StatusInternalServerError, map[string]string{"error": fmt.Sprintf("failed to write config file: %v", err)})
+		return
+	}
+	msg.Printf("📝 Saved profile %q to %s from the editor\n", e.profile, e.path)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// applyProfileEdit returns the config file at path with the named
+// profile's edited keys replaced by edit's, dropping those left empty.
+// The result is checked like any config file before being returned.
+func applyProfileEdit(path, name string, edit profileEdit) ([]byte, error) {
+	return replaceProfileKeys(path, name, edit)
+}
+
+// replaceProfileKeys is applyProfileEdit for the keys of any struct
+// encoding to a YAML mapping.
+func replaceProfileKeys(path, name string, keys any) ([]byte, error) {
+	doc, err := readConfigNode(path)
+	if err != nil {
+		return nil, err
+	}
+	profiles := mappingValue(doc.Content[0], "profiles")
+	var profile *yaml.Node
+	if profiles != nil {
+		profile = mappingValue(profiles, name)
+	}
+	if profile == nil || profile.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("profile %q not found in %s", name, path)
+	}
+
+	var edited yaml.Node
+	if err := edited.Encode(keys); err != nil {
+		return nil, err
+	}
+	for i := 0; i+1 < len(edited.Content); i += 2 {
+		key, value := edited.Content[i], edited.Content[i+1]
+		empty := len(value.Content) == 0
+		j := mappingIndex(profile, key.Value)
+		switch {
+		case j >= 0 && empty:
+			profile.Content = append(profile.Content[:j], profile.Content[j+2:]...)
+		case j >= 0:
+			// Keep the old key node, and with it any comment above it.
+			profile.Content[j+1] = value
+		case !empty:
+			profile.Content = append(profile.Content, key, value)
+		}
+	}
+
+	data, err := encodeYAML(doc)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := parseConfig(path, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func writeEditorJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
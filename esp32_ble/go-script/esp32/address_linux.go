@@ -0,0 +1,14 @@
+package esp32
+
+import "tinygo.org/x/bluetooth"
+
+// parseAddress converts an address that hasn't been seen in a scan, e.g.
+// one cached from an earlier run. BlueZ connects to it if it still knows
+// the device, as it does for bonded boards and ones seen recently.
+func parseAddress(address string) (bluetooth.Address, bool) {
+	mac, err := bluetooth.ParseMAC(address)
+	if err != nil {
+		return bluetooth.Address{}, false
+	}
+	return bluetooth.Address{MACAddress: bluetooth.MACAddress{MAC: mac}}, true
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+
+package esp32
+
+import "tinygo.org/x/bluetooth"
+
+// parseAddress reports that unscanned addresses can't be connected to;
+// other stacks need the device from a scan.
+func parseAddress(address string) (bluetooth.Address, bool) {
+	return bluetooth.Address{}, false
+}
@@ -0,0 +1,137 @@
+package esp32
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"time"
+)
+
+// BenchResult is what Bench measured. A bench characteristic notifies as
+// fast as the link allows, each frame starting with a 32-bit
+// little-endian sequence number and padded to fill the MTU, so gaps in
+// the sequence are frames lost over the air or in the stack.
+type BenchResult struct {
+	// MTU is the ATT MTU negotiated for the connection.
+	MTU      int
+	Duration time.Duration
+	// Received and Bytes count the frames that arrived.
+	Received uint64
+	Bytes    uint64
+	// Lost counts sequence numbers skipped between received frames.
+	Lost uint64
+	// Late counts frames numbered behind ones already received:
+	// duplicates, or reordered frames that were counted as lost.
+	Late uint64
+	// Malformed counts frames too short to carry a sequence number.
+	Malformed uint64
+	// Interval and Jitter are the mean and standard deviation of the time
+	// between frames.
+	Interval time.Duration
+	Jitter   time.Duration
+}
+
+// Kbps returns the effective throughput in kilobits per second.
+func (r BenchResult) Kbps() float64 {
+	if r.Duration <= 0 {
+		return 0
+	}
+	return float64(r.Bytes) * 8 / 1000 / r.Duration.Seconds()
+}
+
+// Loss returns the fraction of frames sent that never arrived.
+func (r BenchResult) Loss() float64 {
+	if sent := r.Received - r.Late - r.Malformed + r.Lost; sent > 0 {
+		return float64(r.Lost) / float64(sent)
+	}
+	return 0
+}
+
+// benchStats accumulates a BenchResult from notifications.
+type benchStats struct {
+	result  BenchResult
+	started bool
+	next    uint32
+	last    time.Time
+	// Welford's running mean and sum of squared deviations of the
+	// intervals, in seconds.
+	intervals uint64
+	mean, m2  float64
+}
+
+func (s *benchStats) add(buf []byte, at time.Time) {
+	s.result.Received++
+	s.result.Bytes += uint64(len(buf))
+	if !s.last.IsZero() {
+		d := at.Sub(s.last).Seconds()
+		s.intervals++
+		delta := d - s.mean
+		s.mean += delta / float64(s.intervals)
+		s.m2 += delta * (d - s.mean)
+	}
+	s.last = at
+
+	if len(buf) < 4 {
+		s.result.Malformed++
+		return
+	}
+	seq := binary.LittleEndian.Uint32(buf)
+	switch {
+	case !s.started:
+		s.started = true
+	case seq-s.next >= math.MaxUint32/2:
+		s.result.Late++
+		return
+	default:
+		s.result.Lost += uint64(seq - s.next)
+	}
+	s.next = seq + 1
+}
+
+func (s *benchStats) finish(d time.Duration) BenchResult {
+	r := s.result
+	r.Duration = d
+	if s.intervals > 0 {
+		r.Interval = time.Duration(s.mean * float64(time.Second))
+		r.Jitter = time.Duration(math.Sqrt(s.m2/float64(s.intervals)) * float64(time.Second))
+	}
+	return r
+}
+
+// Bench subscribes to a bench characteristic for d, or until ctx is done,
+// and reports the throughput it saw. BlueZ negotiates the largest MTU both
+// sides support when connecting, so Bench only reports it; the board is
+// expected to size its frames to it.
+func (c *Client) Bench(ctx context.Context, uuid string, d time.Duration) (BenchResult, error) {
+	char, err := c.Characteristic(uuid)
+	if err != nil {
+		return BenchResult{}, err
+	}
+	mtu, err := await(ctx, func() (uint16, error) {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return char.MTU()
+	}, nil)
+	if err != nil {
+		return BenchResult{}, fmt.Errorf("reading MTU: %w", err)
+	}
+
+	var stats benchStats
+	start := time.Now()
+	if err := c.SubscribeRaw(uuid, stats.add); err != nil {
+		return BenchResult{}, err
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+	case <-timer.C:
+	}
+	// Unsubscribing waits for queued frames, so stats is no longer
+	// written to afterwards.
+	err = c.Unsubscribe(uuid)
+	result := stats.finish(time.Since(start))
+	result.MTU = int(mtu)
+	return result, err
+}
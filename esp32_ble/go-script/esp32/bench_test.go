@@ -0,0 +1,53 @@
+package esp32_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"bluetooth/esp32"
+	"bluetooth/esp32/mock"
+)
+
+const benchUUID = "5a1d0000-0000-4000-8000-000000000003"
+
+func TestBench(t *testing.T) {
+	defer verifyNoLeaks(t)
+
+	board := mock.NewBoard("esp32-test", "AA:BB:CC:DD:EE:01")
+	board.EnableBench(benchUUID)
+	client := connectBoard(t, board)
+	defer client.Disconnect()
+
+	type outcome struct {
+		result esp32.BenchResult
+		err    error
+	}
+	done := make(chan outcome)
+	go func() {
+		result, err := client.Bench(context.Background(), benchUUID, 200*time.Millisecond)
+		done <- outcome{result, err}
+	}()
+	for board.Subscribers() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	// 3 and 7 are lost and 5 arrives twice.
+	for _, seq := range []uint32{0, 1, 2, 4, 5, 5, 6, 8, 9} {
+		board.SendBench(seq)
+	}
+
+	got := <-done
+	if got.err != nil {
+		t.Fatal(got.err)
+	}
+	r := got.result
+	if r.MTU != 247 || r.Received != 9 || r.Bytes != 9*244 || r.Lost != 2 || r.Late != 1 {
+		t.Errorf("result = %+v, want MTU 247, 9 frames of 244 bytes, 2 lost and 1 late", r)
+	}
+	if r.Loss() != 0.2 || r.Kbps() <= 0 {
+		t.Errorf("loss = %v, kbps = %v, want 0.2 and positive", r.Loss(), r.Kbps())
+	}
+	if board.Subscribers() != 0 {
+		t.Error("bench left its subscription enabled")
+	}
+}
@@ -0,0 +1,139 @@
+package esp32
+
+import (
+	"fmt"
+	"sync"
+
+	"tinygo.org/x/bluetooth"
+)
+
+// bleAdapter implements Adapter on top of a tinygo bluetooth adapter.
+type bleAdapter struct {
+	adapter *bluetooth.Adapter
+	// id is the BlueZ adapter name, used for power state on Linux.
+	id string
+
+	mu   sync.Mutex
+	seen map[string]bluetooth.Address
+}
+
+// NewBLEAdapter returns an Adapter backed by a tinygo bluetooth adapter,
+// e.g. bluetooth.DefaultAdapter.
+func NewBLEAdapter(adapter *bluetooth.Adapter) Adapter {
+	return &bleAdapter{adapter: adapter, id: "hci0", seen: map[string]bluetooth.Address{}}
+}
+
+func (a *bleAdapter) Enable() error {
+	return a.adapter.Enable()
+}
+
+func (a *bleAdapter) Scan(callback func(ScanResult)) error {
+	return a.adapter.Scan(func(_ *bluetooth.Adapter, result bluetooth.ScanResult) {
+		address := result.Address.String()
+		a.mu.Lock()
+		a.seen[address] = result.Address
+		a.mu.Unlock()
+		var services []string
+		for _, uuid := range result.ServiceUUIDs() {
+			services = append(services, uuid.String())
+		}
+		callback(ScanResult{Name: result.LocalName(), Address: address, RSSI: result.RSSI, Services: services})
+	})
+}
+
+func (a *bleAdapter) StopScan() error {
+	return a.adapter.StopScan()
+}
+
+func (a *bleAdapter) Connect(address string) (Device, error) {
+	a.mu.Lock()
+	addr, ok := a.seen[address]
+	a.mu.Unlock()
+	if !ok {
+		if addr, ok = parseAddress(address); !ok {
+			return nil, fmt.Errorf("address %s has not been seen in a scan", address)
+		}
+	}
+	device, err := a.adapter.Connect(addr, bluetooth.ConnectionParams{})
+	if err != nil {
+		return nil, err
+	}
+	return bleDevice{device: device, address: address, adapterID: a.id}, nil
+}
+
+type bleDevice struct {
+	device    bluetooth.Device
+	address   string
+	adapterID string
+}
+
+func (d bleDevice) DiscoverServices() ([]Service, error) {
+	services, err := d.device.DiscoverServices(nil)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]Service, len(services))
+	for i, s := range services {
+		out[i] = bleService{s, d}
+	}
+	return out, nil
+}
+
+func (d bleDevice) Disconnect() error {
+	return d.device.Disconnect()
+}
+
+type bleService struct {
+	service bluetooth.DeviceService
+	device  bleDevice
+}
+
+func (s bleService) UUID() string {
+	return s.service.UUID().String()
+}
+
+// DiscoverCharacteristics discovers ALL characteristics (nil = no filter);
+// some stacks don't return all characteristics when filtering by UUID, so
+// callers should discover all and find by UUID.
+func (s bleService) DiscoverCharacteristics() ([]Characteristic, error) {
+	chars, err := s.service.DiscoverCharacteristics(nil)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]Characteristic, len(chars))
+	for i := range chars {
+		out[i] = &bleCharacteristic{char: chars[i], device: s.device}
+	}
+	return out, nil
+}
+
+type bleCharacteristic struct {
+	char   bluetooth.DeviceCharacteristic
+	device bleDevice
+
+	// path is the characteristic's BlueZ object on Linux, found on the
+	// first write with response.
+	pathOnce sync.Once
+	path     string
+	pathErr  error
+}
+
+func (c *bleCharacteristic) UUID() string {
+	return c.char.UUID().String()
+}
+
+func (c *bleCharacteristic) Read(buf []byte) (int, error) {
+	return c.char.Read(buf)
+}
+
+func (c *bleCharacteristic) Write(p []byte) (int, error) {
+	return writeCharacteristic(c.char, p)
+}
+
+func (c *bleCharacteristic) EnableNotifications(callback func(buf []byte)) error {
+	return c.char.EnableNotifications(callback)
+}
+
+func (c *bleCharacteristic) MTU() (uint16, error) {
+	return c.char.GetMTU()
+}
@@ -0,0 +1,286 @@
+package esp32
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// btsnoop file format (RFC 1761 style, as written by Android and read by
+// Wireshark) with the HCI UART (H4) datalink, so each record is an H4
+// packet: a type byte, then an HCI event or ACL data packet.
+const (
+	btsnoopDatalinkH4 = 1002
+	// btsnoopEpochDelta is microseconds from year 0 to the Unix epoch.
+	btsnoopEpochDelta = 0x00dcddb30f2f8000
+
+	btsnoopReceived = 1 << 0
+	btsnoopEvent    = 1 << 1
+
+	h4ACL   = 0x02
+	h4Event = 0x04
+
+	l2capATT = 0x0004
+)
+
+// ATT opcodes written to captures.
+const (
+	attErrorResponse       = 0x01
+	attReadByTypeReq       = 0x08
+	attReadByTypeRsp       = 0x09
+	attReadReq             = 0x0a
+	attReadRsp             = 0x0b
+	attReadByGroupTypeReq  = 0x10
+	attReadByGroupTypeRsp  = 0x11
+	attWriteReq            = 0x12
+	attWriteRsp            = 0x13
+	attHandleValueNotify   = 0x1b
+	attErrUnlikely         = 0x0e
+	gattPrimaryServiceUUID = 0x2800
+	gattCharacteristicUUID = 0x2803
+)
+
+// Capture writes a client's GATT operations to a btsnoop file that
+// Wireshark opens directly. The stack doesn't expose the ATT traffic it
+// exchanges, so the capture reconstructs it from what each operation
+// did: connections and disconnections become HCI events, discovery
+// becomes service and characteristic discovery responses, and reads,
+// writes and notifications become ATT PDUs. Handles and connection
+// handles are assigned by the capture rather than taken from the board,
+// so they are consistent within a file but won't match an air capture.
+// A Capture may be shared by several clients.
+type Capture struct {
+	mu       sync.Mutex
+	w        io.Writer
+	nextConn uint16
+	err      error
+}
+
+// NewCapture writes a btsnoop header to w and returns a Capture writing
+// records after it.
+func NewCapture(w io.Writer) (*Capture, error) {
+	header := append([]byte("btsnoop\x00"), 0, 0, 0, 1)
+	header = binary.BigEndian.AppendUint32(header, btsnoopDatalinkH4)
+	if _, err := w.Write(header); err != nil {
+		return nil, err
+	}
+	return &Capture{w: w, nextConn: 1}, nil
+}
+
+// Err returns the first error writing a record, after which the capture
+// stops writing.
+func (c *Capture) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+// record writes one H4 packet.
+func (c *Capture) record(at time.Time, flags uint32, packet []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return
+	}
+	rec := binary.BigEndian.AppendUint32(nil, uint32(len(packet)))
+	rec = binary.BigEndian.AppendUint32(rec, uint32(len(packet)))
+	rec = binary.BigEndian.AppendUint32(rec, flags)
+	rec = binary.BigEndian.AppendUint32(rec, 0) // cumulative drops
+	rec = binary.BigEndian.AppendUint64(rec, uint64(at.UnixMicro()+btsnoopEpochDelta))
+	_, c.err = c.w.Write(append(rec, packet...))
+}
+
+// att records an ATT PDU on conn, sent by the host unless received.
+func (c *Capture) att(conn uint16, received bool, pdu []byte) {
+	// ACL header: handle with packet boundary "first, flushable", length;
+	// then the L2CAP header: length, channel.
+	packet := []byte{h4ACL}
+	packet = binary.LittleEndian.AppendUint16(packet, conn|0x2<<12)
+	packet = binary.LittleEndian.AppendUint16(packet, uint16(len(pdu)+4))
+	packet = binary.LittleEndian.AppendUint16(packet, uint16(len(pdu)))
+	packet = binary.LittleEndian.AppendUint16(packet, l2capATT)
+	var flags uint32
+	if received {
+		flags = btsnoopReceived
+	}
+	c.record(time.Now(), flags, append(packet, pdu...))
+}
+
+// event records an HCI event from the controller.
+func (c *Capture) event(code byte, params []byte) {
+	packet := append([]byte{h4Event, code, byte(len(params))}, params...)
+	c.record(time.Now(), btsnoopReceived|btsnoopEvent, packet)
+}
+
+// connect records an LE Connection Complete event for address and
+// returns the connection handle assigned to it.
+func (c *Capture) connect(address string) uint16 {
+	c.mu.Lock()
+	conn := c.nextConn
+	c.nextConn++
+	c.mu.Unlock()
+
+	params := []byte{0x01, 0x00} // subevent, status
+	params = binary.LittleEndian.AppendUint16(params, conn)
+	params = append(params, 0x00, 0x00) // central, public address
+	params = append(params, bdaddr(address)...)
+	params = binary.LittleEndian.AppendUint16(params, 24)  // interval, 30ms
+	params = binary.LittleEndian.AppendUint16(params, 0)   // latency
+	params = binary.LittleEndian.AppendUint16(params, 400) // timeout, 4s
+	params = append(params, 0x00)                          // clock accuracy
+	c.event(0x3e, params)
+	return conn
+}
+
+// disconnect records a Disconnection Complete event for conn.
+func (c *Capture) disconnect(conn uint16) {
+	params := binary.LittleEndian.AppendUint16([]byte{0x00}, conn)
+	c.event(0x05, append(params, 0x16)) // terminated by local host
+}
+
+// bdaddr encodes "AA:BB:CC:DD:EE:FF" in HCI's little-endian byte order.
+func bdaddr(address string) []byte {
+	b, _ := hex.DecodeString(strings.ReplaceAll(address, ":", ""))
+	out := make([]byte, 6)
+	for i := 0; i < len(b) && i < 6; i++ {
+		out[i] = b[len(b)-1-i]
+	}
+	return out
+}
+
+// attUUID encodes a UUID string in ATT's little-endian byte order.
+func attUUID(uuid string) []byte {
+	b, _ := hex.DecodeString(strings.ReplaceAll(uuid, "-", ""))
+	for i, j := 0, len(b)-1; i < j; i, j = i+1, j-1 {
+		b[i], b[j] = b[j], b[i]
+	}
+	return b
+}
+
+// Capture records the client's connection and discovered services to
+// capture, and every later read, write and notification. It must be
+// called before the client is used; operations already under way, such
+// as subscriptions, aren't captured.
+func (c *Client) Capture(capture *Capture) {
+	conn := capture.connect(c.Address)
+	c.capture, c.captureConn = capture, conn
+
+	// Lay handles out like a GATT server would: each service declaration,
+	// then per characteristic its declaration, value and CCCD.
+	handle := uint16(1)
+	for _, service := range c.Services {
+		start := handle
+		handle++
+		type layout struct {
+			uuid  string
+			value uint16
+		}
+		var chars []layout
+		for _, uuid := range service.Characteristics {
+			chars = append(chars, layout{uuid, handle + 1})
+			handle += 3
+		}
+		end := handle - 1
+
+		req := []byte{attReadByGroupTypeReq}
+		req = binary.LittleEndian.AppendUint16(req, start)
+		req = binary.LittleEndian.AppendUint16(req, 0xffff)
+		capture.att(conn, false, binary.LittleEndian.AppendUint16(req, gattPrimaryServiceUUID))
+		rsp := []byte{attReadByGroupTypeRsp, 4 + 16}
+		rsp = binary.LittleEndian.AppendUint16(rsp, start)
+		rsp = binary.LittleEndian.AppendUint16(rsp, end)
+		capture.att(conn, true, append(rsp, attUUID(service.UUID)...))
+
+		for _, char := range chars {
+			req := []byte{attReadByTypeReq}
+			req = binary.LittleEndian.AppendUint16(req, char.value-1)
+			req = binary.LittleEndian.AppendUint16(req, end)
+			capture.att(conn, false, binary.LittleEndian.AppendUint16(req, gattCharacteristicUUID))
+			rsp := []byte{attReadByTypeRsp, 5 + 16}
+			rsp = binary.LittleEndian.AppendUint16(rsp, char.value-1)
+			rsp = append(rsp, 0) // properties aren't known here
+			rsp = binary.LittleEndian.AppendUint16(rsp, char.value)
+			capture.att(conn, true, append(rsp, attUUID(char.uuid)...))
+
+			if inner, ok := c.chars[char.uuid]; ok {
+				c.chars[char.uuid] = &capturedCharacteristic{
+					Characteristic: inner,
+					capture:        capture,
+					conn:           conn,
+					value:          char.value,
+				}
+			}
+		}
+	}
+}
+
+// capturedCharacteristic records a characteristic's operations.
+type capturedCharacteristic struct {
+	Characteristic
+	capture *Capture
+	conn    uint16
+	value   uint16
+}
+
+// attPDU encodes an ATT PDU addressed to a handle.
+func attPDU(opcode byte, handle uint16, data []byte) []byte {
+	return append(binary.LittleEndian.AppendUint16([]byte{opcode}, handle), data...)
+}
+
+// failed records an Error Response to a request for handle.
+func (c *capturedCharacteristic) failed(request byte, handle uint16) {
+	rsp := []byte{attErrorResponse, request}
+	rsp = binary.LittleEndian.AppendUint16(rsp, handle)
+	c.capture.att(c.conn, true, append(rsp, attErrUnlikely))
+}
+
+func (c *capturedCharacteristic) Read(buf []byte) (int, error) {
+	c.capture.att(c.conn, false, attPDU(attReadReq, c.value, nil))
+	n, err := c.Characteristic.Read(buf)
+	if err != nil {
+		c.failed(attReadReq, c.value)
+		return n, err
+	}
+	c.capture.att(c.conn, true, append([]byte{attReadRsp}, buf[:n]...))
+	return n, nil
+}
+
+func (c *capturedCharacteristic) Write(p []byte) (int, error) {
+	return c.write(c.value, p, func() (int, error) { return c.Characteristic.Write(p) })
+}
+
+func (c *capturedCharacteristic) WriteWithResponse(p []byte) (int, error) {
+	return c.write(c.value, p, func() (int, error) { return writeWithResponse(c.Characteristic, p) })
+}
+
+func (c *capturedCharacteristic) write(handle uint16, p []byte, op func() (int, error)) (int, error) {
+	c.capture.att(c.conn, false, attPDU(attWriteReq, handle, p))
+	n, err := op()
+	if err != nil {
+		c.failed(attWriteReq, handle)
+		return n, err
+	}
+	c.capture.att(c.conn, true, []byte{attWriteRsp})
+	return n, nil
+}
+
+// EnableNotifications records the CCCD write that enabling or disabling
+// notifications makes, and each notification received.
+func (c *capturedCharacteristic) EnableNotifications(callback func(buf []byte)) error {
+	cccd := []byte{0x00, 0x00}
+	wrapped := callback
+	if callback != nil {
+		cccd[0] = 0x01
+		wrapped = func(buf []byte) {
+			c.capture.att(c.conn, true, attPDU(attHandleValueNotify, c.value, buf))
+			callback(buf)
+		}
+	}
+	_, err := c.write(c.value+1, cccd, func() (int, error) {
+		return 0, c.Characteristic.EnableNotifications(wrapped)
+	})
+	return err
+}
@@ -0,0 +1,125 @@
+package esp32_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"testing"
+	"time"
+
+	"bluetooth/esp32"
+	"bluetooth/esp32/mock"
+)
+
+// btsnoopRecord is a parsed capture record.
+type btsnoopRecord struct {
+	flags  uint32
+	packet []byte
+}
+
+func parseBtsnoop(t *testing.T, data []byte) []btsnoopRecord {
+	t.Helper()
+	if len(data) < 16 || string(data[:8]) != "btsnoop\x00" ||
+		binary.BigEndian.Uint32(data[8:]) != 1 || binary.BigEndian.Uint32(data[12:]) != 1002 {
+		t.Fatalf("bad btsnoop header % x", data[:min(len(data), 16)])
+	}
+	var records []btsnoopRecord
+	for data = data[16:]; len(data) > 0; {
+		if len(data) < 24 {
+			t.Fatalf("truncated record header")
+		}
+		n := binary.BigEndian.Uint32(data[4:])
+		records = append(records, btsnoopRecord{binary.BigEndian.Uint32(data[8:]), data[24 : 24+n]})
+		data = data[24+n:]
+	}
+	return records
+}
+
+// summary describes a record as "event 0x3e" or "att 0x0a" with a
+// direction, for comparing sequences.
+func (r btsnoopRecord) summary() string {
+	dir := ">"
+	if r.flags&1 != 0 {
+		dir = "<"
+	}
+	switch r.packet[0] {
+	case 0x04:
+		return fmt.Sprintf("%s event 0x%02x", dir, r.packet[1])
+	case 0x02:
+		return fmt.Sprintf("%s att 0x%02x", dir, r.packet[9])
+	}
+	return dir + " ?"
+}
+
+func TestCapture(t *testing.T) {
+	defer verifyNoLeaks(t)
+
+	var buf bytes.Buffer
+	capture, err := esp32.NewCapture(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	board := mock.NewBoard("esp32-test", "AA:BB:CC:DD:EE:01")
+	board.SetADC(35, 1234)
+	client := connectBoard(t, board)
+	client.Capture(capture)
+
+	if _, err := client.ReadADC(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.WritePins(context.Background(), []esp32.PinWrite{{PinNum: 14, State: 1}}); err != nil {
+		t.Fatal(err)
+	}
+	got := make(chan struct{}, 1)
+	if err := client.SubscribeADC(func([]esp32.Reading) { got <- struct{}{} }); err != nil {
+		t.Fatal(err)
+	}
+	board.Notify()
+	select {
+	case <-got:
+	case <-time.After(time.Second):
+		t.Fatal("notification not delivered")
+	}
+	client.Disconnect()
+	if err := capture.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	records := parseBtsnoop(t, buf.Bytes())
+	var summaries []string
+	for _, r := range records {
+		summaries = append(summaries, r.summary())
+	}
+	want := []string{
+		"< event 0x3e",             // LE Connection Complete
+		"> att 0x10", "< att 0x11", // the service
+		"> att 0x08", "< att 0x09", // and its three characteristics
+		"> att 0x08", "< att 0x09",
+		"> att 0x08", "< att 0x09",
+		"> att 0x0a", "< att 0x0b", // ReadADC
+		"> att 0x12", "< att 0x13", // WritePins
+		"> att 0x12", "< att 0x13", // enabling notifications
+		"< att 0x1b",               // the notification
+		"> att 0x12", "< att 0x13", // disabling them on disconnect
+		"< event 0x05", // Disconnection Complete
+	}
+	if len(summaries) != len(want) {
+		t.Fatalf("records = %v\nwant %v", summaries, want)
+	}
+	for i := range want {
+		if summaries[i] != want[i] {
+			t.Fatalf("record %d = %q, want %q\nall: %v", i, summaries[i], want[i], summaries)
+		}
+	}
+
+	// The read response carries the ADC frame: 2 pins, 35 = 1234 first.
+	read := records[10].packet[10:]
+	if read[0] != 2 || read[1] != 35 || int(read[2])<<8|int(read[3]) != 1234 {
+		t.Errorf("read response value % x", read)
+	}
+	// Connection Complete carries the board's address, little-endian.
+	if addr := records[0].packet[9:15]; !bytes.Equal(addr, []byte{0x01, 0xee, 0xdd, 0xcc, 0xbb, 0xaa}) {
+		t.Errorf("connection address % x", addr)
+	}
+}
@@ -0,0 +1,124 @@
+package esp32
+
+import "sort"
+
+// CharacteristicStats counts the operations on one characteristic since
+// the client connected, to show which parts of the protocol are busy or
+// failing.
+type CharacteristicStats struct {
+	UUID string
+	// Reads and Writes count attempts, including the failed ones counted
+	// in ReadErrors and WriteErrors.
+	Reads, ReadErrors   uint64
+	Writes, WriteErrors uint64
+	Notifications       uint64
+	// Bytes count the payloads of successful operations.
+	BytesRead, BytesWritten, BytesNotified uint64
+}
+
+// ErrorRate returns the fraction of reads and writes that failed.
+func (s CharacteristicStats) ErrorRate() float64 {
+	return ratio(s.ReadErrors+s.WriteErrors, s.Reads+s.Writes)
+}
+
+// AvgRead returns the mean size of a successful read, in bytes.
+func (s CharacteristicStats) AvgRead() float64 {
+	return ratio(s.BytesRead, s.Reads-s.ReadErrors)
+}
+
+// AvgWrite returns the mean size of a successful write, in bytes.
+func (s CharacteristicStats) AvgWrite() float64 {
+	return ratio(s.BytesWritten, s.Writes-s.WriteErrors)
+}
+
+// AvgNotification returns the mean size of a notification, in bytes.
+func (s CharacteristicStats) AvgNotification() float64 {
+	return ratio(s.BytesNotified, s.Notifications)
+}
+
+func ratio(n, d uint64) float64 {
+	if d == 0 {
+		return 0
+	}
+	return float64(n) / float64(d)
+}
+
+// CharacteristicStats returns the counters of every characteristic the
+// client has used, sorted by UUID.
+func (c *Client) CharacteristicStats() []CharacteristicStats {
+	c.statsMu.Lock()
+	defer c.statsMu.Unlock()
+	out := make([]CharacteristicStats, 0, len(c.charStats))
+	for _, s := range c.charStats {
+		out = append(out, *s)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].UUID < out[j].UUID })
+	return out
+}
+
+// count updates uuid's counters under the stats lock.
+func (c *Client) count(uuid string, update func(*CharacteristicStats)) {
+	c.statsMu.Lock()
+	defer c.statsMu.Unlock()
+	s, ok := c.charStats[uuid]
+	if !ok {
+		s = &CharacteristicStats{UUID: uuid}
+		c.charStats[uuid] = s
+	}
+	update(s)
+}
+
+// countedCharacteristic counts a characteristic's operations into its
+// client's stats. Every discovered characteristic is wrapped in one, so
+// all callers are counted, including those using Characteristic directly.
+type countedCharacteristic struct {
+	Characteristic
+	client *Client
+}
+
+func (c *countedCharacteristic) Read(buf []byte) (int, error) {
+	n, err := c.Characteristic.Read(buf)
+	c.client.count(c.UUID(), func(s *CharacteristicStats) {
+		s.Reads++
+		if err != nil {
+			s.ReadErrors++
+			return
+		}
+		s.BytesRead += uint64(n)
+	})
+	return n, err
+}
+
+func (c *countedCharacteristic) Write(p []byte) (int, error) {
+	return c.countWrite(p, c.Characteristic.Write)
+}
+
+func (c *countedCharacteristic) WriteWithResponse(p []byte) (int, error) {
+	return c.countWrite(p, func(p []byte) (int, error) { return writeWithResponse(c.Characteristic, p) })
+}
+
+func (c *countedCharacteristic) countWrite(p []byte, write func([]byte) (int, error)) (int, error) {
+	n, err := write(p)
+	c.client.count(c.UUID(), func(s *CharacteristicStats) {
+		s.Writes++
+		if err != nil {
+			s.WriteErrors++
+			return
+		}
+		s.BytesWritten += uint64(len(p))
+	})
+	return n, err
+}
+
+func (c *countedCharacteristic) EnableNotifications(callback func(buf []byte)) error {
+	if callback == nil {
+		return c.Characteristic.EnableNotifications(nil)
+	}
+	return c.Characteristic.EnableNotifications(func(buf []byte) {
+		c.client.count(c.UUID(), func(s *CharacteristicStats) {
+			s.Notifications++
+			s.BytesNotified += uint64(len(buf))
+		})
+		callback(buf)
+	})
+}
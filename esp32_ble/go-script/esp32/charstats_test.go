@@ -0,0 +1,66 @@
+package esp32_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"bluetooth/esp32"
+	"bluetooth/esp32/mock"
+)
+
+func TestCharacteristicStats(t *testing.T) {
+	defer verifyNoLeaks(t)
+
+	board := mock.NewBoard("esp32-secure", "AA:BB:CC:DD:EE:09")
+	board.RequirePairing(mock.Security{Passkey: 123456})
+	client := connectBoard(t, board)
+	defer client.Disconnect()
+	ctx := context.Background()
+
+	if _, err := client.ReadADC(ctx); err == nil {
+		t.Fatal("ReadADC before pairing succeeded")
+	}
+	if err := client.Pair(ctx, &fixedAgent{passkey: 123456}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.ReadADC(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.WritePins(ctx, []esp32.PinWrite{{PinNum: 2, State: 1}}); err != nil {
+		t.Fatal(err)
+	}
+	got := make(chan struct{}, 2)
+	if err := client.SubscribeADC(func([]esp32.Reading) { got <- struct{}{} }); err != nil {
+		t.Fatal(err)
+	}
+	board.Notify()
+	board.Notify()
+	for range 2 {
+		select {
+		case <-got:
+		case <-time.After(time.Second):
+			t.Fatal("notification was not delivered")
+		}
+	}
+
+	stats := map[string]esp32.CharacteristicStats{}
+	for _, s := range client.CharacteristicStats() {
+		stats[s.UUID] = s
+	}
+	adc := stats[esp32.ADCDataOutputUUID]
+	if adc.Reads != 2 || adc.ReadErrors != 1 || adc.BytesRead != 32 || adc.Notifications != 2 || adc.BytesNotified != 64 {
+		t.Errorf("ADC stats = %+v, want 2 reads with 1 error, 32 bytes read and 2 notifications of 32 bytes", adc)
+	}
+	if adc.ErrorRate() != 0.5 || adc.AvgRead() != 32 || adc.AvgNotification() != 32 {
+		t.Errorf("ADC error rate = %v, average read = %v, average notification = %v, want 0.5, 32 and 32",
+			adc.ErrorRate(), adc.AvgRead(), adc.AvgNotification())
+	}
+	input := stats[esp32.PinDataInputUUID]
+	if input.Writes != 1 || input.WriteErrors != 0 || input.AvgWrite() == 0 {
+		t.Errorf("pin input stats = %+v, want 1 successful write", input)
+	}
+	if _, ok := stats[esp32.PinDataOutputUUID]; ok {
+		t.Error("unused pin output characteristic has stats")
+	}
+}
@@ -0,0 +1,390 @@
+package esp32
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ServiceInfo describes a discovered service.
+type ServiceInfo struct {
+	UUID            string
+	Characteristics []string
+	// Err is set if discovering the service's characteristics failed.
+	Err error
+}
+
+// Client is a connection to a board running the pin service firmware.
+type Client struct {
+	Name    string
+	Address string
+	// ID is the board's identity, which its readings carry: its address
+	// unless Identify sets another.
+	ID string
+	// Services lists what discovery found, in discovery order.
+	Services []ServiceInfo
+
+	// mu serializes GATT operations so a client can be shared between
+	// goroutines.
+	mu      sync.Mutex
+	device  Device
+	chars   map[string]Characteristic
+	profile Profile
+
+	subMu      sync.Mutex
+	subscribed []*subscription
+
+	statsMu   sync.Mutex
+	stats     NotifyStats
+	limit     limiter
+	charStats map[string]*CharacteristicStats
+	onUnknown func(*UnknownPayloadError)
+	// unknown holds the characteristics whose last frame didn't decode,
+	// so onUnknown is only told once.
+	unknown map[string]bool
+
+	// decoders are set by SetDecoder, overriding the profile's.
+	decoders map[string]Decoder
+	// stateful holds each characteristic's copy of a StatefulDecoder.
+	stateMu  sync.Mutex
+	stateful map[string]Decoder
+
+	writePolicy *WritePolicy
+
+	capture     *Capture
+	captureConn uint16
+
+	// reads holds each characteristic's latest ReadShared read.
+	readMu sync.Mutex
+	reads  map[string]*sharedRead
+}
+
+// Connect connects to a scanned device and discovers all of its services
+// and characteristics. If ctx is done first Connect returns ctx.Err(), and
+// a connection that completes afterwards is closed.
+func Connect(ctx context.Context, a Adapter, result ScanResult) (*Client, error) {
+	return await(ctx, func() (*Client, error) {
+		return connect(a, result)
+	}, func(c *Client) {
+		c.Disconnect()
+	})
+}
+
+func connect(a Adapter, result ScanResult) (*Client, error) {
+	device, err := a.Connect(result.Address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect: %w", err)
+	}
+
+	services, err := device.DiscoverServices()
+	if err != nil {
+		device.Disconnect()
+		return nil, fmt.Errorf("failed to discover services: %w", err)
+	}
+
+	c := &Client{
+		Name:      result.Name,
+		Address:   result.Address,
+		ID:        result.Address,
+		device:    device,
+		chars:     map[string]Characteristic{},
+		profile:   DefaultProfile(),
+		stats:     NotifyStats{Since: time.Now()},
+		charStats: map[string]*CharacteristicStats{},
+		unknown:   map[string]bool{},
+	}
+	for _, service := range services {
+		info := ServiceInfo{UUID: service.UUID()}
+		chars, err := service.DiscoverCharacteristics()
+		if err != nil {
+			info.Err = err
+		}
+		for _, char := range chars {
+			info.Characteristics = append(info.Characteristics, char.UUID())
+			c.chars[char.UUID()] = &countedCharacteristic{Characteristic: char, client: c}
+		}
+		c.Services = append(c.Services, info)
+	}
+	return c, nil
+}
+
+// SetProfile makes the client use p's UUIDs and frame format for its pin
+// and ADC operations. It must be called before they are used.
+func (c *Client) SetProfile(p Profile) {
+	c.profile = p.WithDefaults()
+	c.stateful = nil
+}
+
+// Profile returns the profile the client is using.
+func (c *Client) Profile() Profile {
+	return c.profile
+}
+
+// Characteristic returns a discovered characteristic by UUID.
+func (c *Client) Characteristic(uuid string) (Characteristic, error) {
+	char, ok := c.chars[uuid]
+	if !ok {
+		return nil, fmt.Errorf("characteristic %s not found", uuid)
+	}
+	return char, nil
+}
+
+// Disconnect disables any notifications the client enabled, so the
+// underlying stack releases their watchers, and closes the connection. It
+// doesn't wait for an operation in progress, so it also unblocks one that
+// has stalled.
+func (c *Client) Disconnect() error {
+	c.subMu.Lock()
+	defer c.subMu.Unlock()
+	for _, sub := range c.subscribed {
+		sub.stop()
+	}
+	c.subscribed = nil
+	if c.capture != nil {
+		c.capture.disconnect(c.captureConn)
+		c.capture = nil
+	}
+	return c.device.Disconnect()
+}
+
+// ErrDescribeUnsupported is returned by Describe when the platform can't
+// report characteristic properties.
+var ErrDescribeUnsupported = errors.New("characteristic properties are not available on this platform")
+
+// Describe reports the properties and descriptors of the board's
+// characteristics, if the platform supports it.
+func (c *Client) Describe(ctx context.Context) ([]CharacteristicInfo, error) {
+	d, ok := c.device.(Describer)
+	if !ok {
+		return nil, ErrDescribeUnsupported
+	}
+	return await(ctx, func() ([]CharacteristicInfo, error) {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return d.Describe()
+	}, nil)
+}
+
+// ErrRSSIUnsupported is returned by ReadRSSI when the platform can't
+// report the signal strength of a connection.
+var ErrRSSIUnsupported = errors.New("connection RSSI is not available on this platform")
+
+// ReadRSSI reads the connection's signal strength as a KindRSSI reading.
+func (c *Client) ReadRSSI(ctx context.Context) (Reading, error) {
+	r, ok := c.device.(RSSIReporter)
+	if !ok {
+		return Reading{}, ErrRSSIUnsupported
+	}
+	rssi, err := await(ctx, func() (int16, error) {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return r.RSSI()
+	}, nil)
+	if err != nil {
+		return Reading{}, fmt.Errorf("failed to read RSSI: %w", err)
+	}
+	return c.Tag([]Reading{{Time: time.Now(), Kind: KindRSSI, Value: int(rssi)}})[0], nil
+}
+
+// MTU returns the negotiated ATT MTU, as reported by the pin data input
+// characteristic.
+func (c *Client) MTU(ctx context.Context) (int, error) {
+	char, err := c.Characteristic(c.profile.PinInputUUID)
+	if err != nil {
+		return 0, err
+	}
+	return await(ctx, func() (int, error) {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		mtu, err := char.MTU()
+		return int(mtu), err
+	}, nil)
+}
+
+// Unsubscribe disables notifications the client enabled for uuid. It
+// must not be called from a subscriber.
+func (c *Client) Unsubscribe(uuid string) error {
+	c.subMu.Lock()
+	defer c.subMu.Unlock()
+	for i, sub := range c.subscribed {
+		if sub.char.UUID() == uuid {
+			c.subscribed = append(c.subscribed[:i], c.subscribed[i+1:]...)
+			return sub.stop()
+		}
+	}
+	return fmt.Errorf("not subscribed to %s", uuid)
+}
+
+// Tag marks readings as coming from this client's board, naming the
+// pins of the profile's channels.
+func (c *Client) Tag(readings []Reading) []Reading {
+	for i := range readings {
+		readings[i].Device = c.Name
+		readings[i].Address = c.Address
+		readings[i].ID = c.ID
+		if readings[i].Kind == "" {
+			readings[i].Channel = c.profile.ChannelName(readings[i].Pin)
+		}
+	}
+	return readings
+}
+
+// ReadRaw reads a characteristic's undecoded value. If ctx is done first
+// it returns ctx.Err() without waiting for the read.
+func (c *Client) ReadRaw(ctx context.Context, uuid string) ([]byte, error) {
+	char, err := c.Characteristic(uuid)
+	if err != nil {
+		return nil, err
+	}
+	return await(ctx, func() ([]byte, error) {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		buffer := make([]byte, 1024)
+		n, err := char.Read(buffer)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", uuid, err)
+		}
+		return buffer[:n], nil
+	}, nil)
+}
+
+// ReadADC reads and decodes the ADC data output characteristic.
+func (c *Client) ReadADC(ctx context.Context) ([]Reading, error) {
+	frame, err := c.ReadRaw(ctx, c.profile.ADCOutputUUID)
+	if err != nil {
+		return nil, err
+	}
+	return c.Decode(c.profile.ADCOutputUUID, frame, time.Now())
+}
+
+// ReadPins reads and decodes the regular pin data output characteristic.
+func (c *Client) ReadPins(ctx context.Context) ([]Reading, error) {
+	frame, err := c.ReadRaw(ctx, c.profile.PinOutputUUID)
+	if err != nil {
+		return nil, err
+	}
+	return c.Decode(c.profile.PinOutputUUID, frame, time.Now())
+}
+
+// WritePins sends pin writes to the pin data input characteristic. If ctx
+// is done first it returns ctx.Err(); the write may still reach the board.
+// With a WritePolicy set, for the characteristic by the profile or for
+// the client, writes that still fail after its attempts are reported in a
+// *PinWriteError.
+func (c *Client) WritePins(ctx context.Context, writes []PinWrite) error {
+	char, err := c.Characteristic(c.profile.PinInputUUID)
+	if err != nil {
+		return err
+	}
+	defer c.forgetReads()
+	if p := c.policy(c.profile.PinInputUUID); p != nil {
+		return c.writePinsReliably(ctx, char, writes, *p)
+	}
+	message, err := EncodePinWrites(writes)
+	if err != nil {
+		return err
+	}
+	_, err = await(ctx, func() (struct{}, error) {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if _, err := char.Write(message); err != nil {
+			return struct{}{}, fmt.Errorf("failed to write: %w", err)
+		}
+		return struct{}{}, nil
+	}, nil)
+	return err
+}
+
+// Subscribe calls fn with the decoded readings of every notification of
+// uuid, handled as opts says. Frames are always decoded in the order they
+// arrived, so decoders that keep state between frames stay correct even
+// when the readings are handled in parallel. Frames that fail to decode
+// are counted in NotifyStats and skipped.
+func (c *Client) Subscribe(uuid string, opts SubscribeOptions, fn func([]Reading)) error {
+	if _, err := c.decoder(uuid); err != nil {
+		return err
+	}
+	return c.subscribeRaw(uuid, opts, func(n notification) func() {
+		readings, err := c.Decode(uuid, n.buf, n.at)
+		if err != nil {
+			c.statsMu.Lock()
+			c.stats.Undecodable++
+			c.statsMu.Unlock()
+			return nil
+		}
+		for i := range readings {
+			readings[i].Seq = n.seq
+		}
+		return func() { fn(readings) }
+	})
+}
+
+// subscribe delivers uuid's notifications decoded with its decoder, in
+// order.
+func (c *Client) subscribe(uuid string, fn func([]Reading)) error {
+	return c.Subscribe(uuid, SubscribeOptions{}, fn)
+}
+
+// SubscribeRaw calls fn with the undecoded value of every notification of
+// a characteristic and the time it arrived, in order, on a goroutine per
+// subscription like SubscribeADC.
+func (c *Client) SubscribeRaw(uuid string, fn func(buf []byte, at time.Time)) error {
+	return c.subscribeRaw(uuid, SubscribeOptions{}, func(n notification) func() {
+		return func() { fn(n.buf, n.at) }
+	})
+}
+
+// subscribeRaw enables uuid's notifications, passing each to deliver in
+// order and running the handler it returns as opts says.
+func (c *Client) subscribeRaw(uuid string, opts SubscribeOptions, deliver func(notification) func()) error {
+	char, err := c.Characteristic(uuid)
+	if err != nil {
+		return err
+	}
+	workers := 0
+	if opts.Ordering == Parallel {
+		workers = cmp.Or(max(opts.Workers, 0), parallelWorkers)
+	}
+	sub := newSubscription(char, workers, func(n notification) func() {
+		handle := deliver(n)
+		if handle == nil {
+			return nil
+		}
+		return func() {
+			handle()
+			c.statsMu.Lock()
+			c.stats.Delivered++
+			c.statsMu.Unlock()
+		}
+	})
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	err = char.EnableNotifications(func(buf []byte) {
+		c.received(sub, buf)
+	})
+	if err != nil {
+		sub.stop()
+		return err
+	}
+	c.subMu.Lock()
+	c.subscribed = append(c.subscribed, sub)
+	c.subMu.Unlock()
+	return nil
+}
+
+// SubscribeADC calls fn with the decoded readings of every ADC data
+// notification. fn runs on a goroutine per subscription, so it may block
+// without holding up other subscriptions or boards; if it falls too far
+// behind, the oldest notifications are dropped.
+func (c *Client) SubscribeADC(fn func([]Reading)) error {
+	return c.subscribe(c.profile.ADCOutputUUID, fn)
+}
+
+// SubscribePins calls fn with the decoded readings of every pin data
+// notification.
+func (c *Client) SubscribePins(fn func([]Reading)) error {
+	return c.subscribe(c.profile.PinOutputUUID, fn)
+}
@@ -0,0 +1,159 @@
+package esp32_test
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"slices"
+	"testing"
+	"time"
+
+	"go.uber.org/goleak"
+
+	"bluetooth/esp32"
+	"bluetooth/esp32/mock"
+)
+
+// verifyNoLeaks checks that neither the package's own accounting nor the
+// runtime shows goroutines left behind.
+func verifyNoLeaks(t *testing.T) {
+	t.Helper()
+	goleak.VerifyNone(t)
+	if n := esp32.Goroutines(); n != 0 {
+		t.Errorf("esp32.Goroutines() = %d, want 0", n)
+	}
+}
+
+func TestFindDeviceFound(t *testing.T) {
+	defer verifyNoLeaks(t)
+
+	board := mock.NewBoard("esp32-test", "AA:BB:CC:DD:EE:01")
+	result, err := esp32.FindDevice(context.Background(), mock.NewAdapter(board), "ESP32-Test", time.Second, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Address != board.Address {
+		t.Errorf("Address = %s, want %s", result.Address, board.Address)
+	}
+}
+
+func TestFindDeviceTimeout(t *testing.T) {
+	defer verifyNoLeaks(t)
+
+	board := mock.NewBoard("someone-else", "AA:BB:CC:DD:EE:02")
+	seen := 0
+	_, err := esp32.FindDevice(context.Background(), mock.NewAdapter(board), "esp32-test", 50*time.Millisecond, func(esp32.ScanResult) {
+		seen++
+	})
+	if !errors.Is(err, esp32.ErrDeviceNotFound) {
+		t.Fatalf("err = %v, want ErrDeviceNotFound", err)
+	}
+	if seen == 0 {
+		t.Error("seen callback was never called")
+	}
+}
+
+func TestFindDeviceCancelled(t *testing.T) {
+	defer verifyNoLeaks(t)
+
+	board := mock.NewBoard("someone-else", "AA:BB:CC:DD:EE:02")
+	adapter := mock.NewAdapter(board)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err := esp32.FindDevice(ctx, adapter, "esp32-test", time.Minute, nil)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("err = %v, want context.DeadlineExceeded", err)
+	}
+	// The scan must have been stopped, so another can start.
+	if _, err := esp32.FindDevice(context.Background(), adapter, board.Name, time.Second, nil); err != nil {
+		t.Fatalf("scan after cancel: %v", err)
+	}
+}
+
+func TestReadCancelledDuringStall(t *testing.T) {
+	defer verifyNoLeaks(t)
+
+	board := mock.NewBoard("esp32-test", "AA:BB:CC:DD:EE:01")
+	adapter := mock.NewAdapter(board)
+	result, err := esp32.FindDevice(context.Background(), adapter, board.Name, time.Second, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := esp32.Connect(context.Background(), adapter, result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	board.InjectFaults(mock.Faults{Stall: 1, StallFor: 200 * time.Millisecond}, rand.New(rand.NewSource(1)))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := client.ReadADC(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("err = %v, want context.DeadlineExceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > 150*time.Millisecond {
+		t.Errorf("ReadADC took %v, want it to return when ctx is done", elapsed)
+	}
+	if err := client.Disconnect(); err != nil {
+		t.Fatal(err)
+	}
+	// Let the abandoned read finish before checking for leaks.
+	for deadline := time.Now().Add(time.Second); esp32.Goroutines() != 0 && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// failingAdapter fails every scan immediately.
+type failingAdapter struct{ esp32.Adapter }
+
+func (failingAdapter) Scan(func(esp32.ScanResult)) error { return errors.New("adapter powered off") }
+
+func TestFindDeviceScanError(t *testing.T) {
+	defer verifyNoLeaks(t)
+
+	_, err := esp32.FindDevice(context.Background(), failingAdapter{}, "esp32-test", time.Second, nil)
+	if err == nil || errors.Is(err, esp32.ErrDeviceNotFound) {
+		t.Fatalf("err = %v, want scan error", err)
+	}
+}
+
+func TestSubscribeDisconnect(t *testing.T) {
+	defer verifyNoLeaks(t)
+
+	board := mock.NewBoard("esp32-test", "AA:BB:CC:DD:EE:01")
+	board.SetADC(35, 1234)
+	adapter := mock.NewAdapter(board)
+	result, err := esp32.FindDevice(context.Background(), adapter, board.Name, time.Second, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := esp32.Connect(context.Background(), adapter, result)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := make(chan []esp32.Reading, 1)
+	if err := client.SubscribeADC(func(r []esp32.Reading) { got <- r }); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.SubscribePins(func([]esp32.Reading) {}); err != nil {
+		t.Fatal(err)
+	}
+	board.Notify()
+	if readings := <-got; len(readings) != 2 || readings[0].Value != 1234 {
+		t.Errorf("readings = %+v", readings)
+	}
+
+	if err := client.Disconnect(); err != nil {
+		t.Fatal(err)
+	}
+	if n := board.Subscribers(); n != 0 {
+		t.Errorf("board has %d subscriber(s) after disconnect", n)
+	}
+	// Dropping the link would have cleared them anyway; the client must
+	// have disabled each itself.
+	want := []string{esp32.ADCDataOutputUUID, esp32.PinDataOutputUUID}
+	if got := board.DisabledNotifications(); !slices.Equal(got, want) {
+		t.Errorf("notifications disabled on disconnect: %v, want %v", got, want)
+	}
+}
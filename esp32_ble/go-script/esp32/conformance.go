@@ -0,0 +1,347 @@
+package esp32
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// conformanceTimeout is the default ConformanceOptions.Timeout: the stock
+// firmware notifies every 2 seconds.
+const conformanceTimeout = 5 * time.Second
+
+// malformedPinRequest is valid UTF-8 but not a pin_writes document, which
+// the firmware must ignore.
+const malformedPinRequest = `{"pin_writes":[{"pin_num":`
+
+// ConformanceStatus is how a conformance check went.
+type ConformanceStatus uint8
+
+const (
+	ConformancePassed ConformanceStatus = iota
+	ConformanceFailed
+	// ConformanceSkipped is a feature the board doesn't claim or the
+	// run wasn't allowed to exercise.
+	ConformanceSkipped
+)
+
+func (s ConformanceStatus) String() string {
+	switch s {
+	case ConformancePassed:
+		return "passed"
+	case ConformanceFailed:
+		return "failed"
+	case ConformanceSkipped:
+		return "skipped"
+	}
+	return fmt.Sprintf("ConformanceStatus(%d)", uint8(s))
+}
+
+func (s ConformanceStatus) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// UnmarshalText parses "passed", "failed" or "skipped".
+func (s *ConformanceStatus) UnmarshalText(text []byte) error {
+	for _, status := range []ConformanceStatus{ConformancePassed, ConformanceFailed, ConformanceSkipped} {
+		if status.String() == string(text) {
+			*s = status
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown conformance status %q", text)
+}
+
+// ConformanceCheck is the result of one check, named for the feature and
+// what was checked of it, e.g. "adc-output notify".
+type ConformanceCheck struct {
+	Name   string            `json:"name"`
+	Status ConformanceStatus `json:"status"`
+	Detail string            `json:"detail,omitempty"`
+}
+
+// ConformanceReport is every check of a conformance run.
+type ConformanceReport struct {
+	Checks []ConformanceCheck `json:"checks"`
+}
+
+// Passed reports whether no check failed.
+func (r ConformanceReport) Passed() bool {
+	return !slices.ContainsFunc(r.Checks, func(c ConformanceCheck) bool {
+		return c.Status == ConformanceFailed
+	})
+}
+
+// ConformanceOptions configures Conformance.
+type ConformanceOptions struct {
+	// WritePins are output pins the write round trip may drive; each is
+	// put back to its state afterwards. With none, it is skipped.
+	WritePins []uint8
+	// Timeout is how long to wait for a notification or for a written
+	// pin to report its state, 5 seconds by default.
+	Timeout time.Duration
+	// SelfTestUUID, if set, is the self-test characteristic, whose
+	// protocol is checked too.
+	SelfTestUUID string
+	// Check, if set, is called with each check as it finishes.
+	Check func(ConformanceCheck)
+}
+
+// Conformance checks the board against the pin service protocol, as a
+// contract test for firmware: the features it claims by its
+// characteristics' properties are exercised and their frames checked with
+// the profile's decoders in strict mode. Boards are only written to
+// harmlessly unless opts.WritePins allows driving pins; OTA is never
+// exercised, as it would reflash the board. A failed check is in the
+// report rather than an error; the error is ctx's if it is done first.
+func (c *Client) Conformance(ctx context.Context, opts ConformanceOptions) (ConformanceReport, error) {
+	if opts.Timeout <= 0 {
+		opts.Timeout = conformanceTimeout
+	}
+	var report ConformanceReport
+	record := func(name string, status ConformanceStatus, format string, args ...any) {
+		check := ConformanceCheck{Name: name, Status: status, Detail: fmt.Sprintf(format, args...)}
+		report.Checks = append(report.Checks, check)
+		if opts.Check != nil {
+			opts.Check(check)
+		}
+	}
+	result := func(name string, err error, format string, args ...any) {
+		if err != nil {
+			record(name, ConformanceFailed, "%v", err)
+		} else {
+			record(name, ConformancePassed, format, args...)
+		}
+	}
+
+	claims, err := c.claims(ctx)
+	switch {
+	case errors.Is(err, ErrDescribeUnsupported):
+		record("discovery", ConformanceSkipped, "properties unavailable on this platform; assuming the stock firmware's")
+	case err != nil:
+		record("discovery", ConformanceFailed, "%v", err)
+	default:
+		record("discovery", ConformancePassed, "%d characteristic(s)", len(claims))
+	}
+	if ctx.Err() != nil {
+		return report, ctx.Err()
+	}
+	if slices.ContainsFunc(c.Services, func(s ServiceInfo) bool { return strings.EqualFold(s.UUID, c.profile.ServiceUUID) }) {
+		record("service", ConformancePassed, "%s", c.profile.ServiceUUID)
+	} else {
+		record("service", ConformanceFailed, "service %s not found", c.profile.ServiceUUID)
+	}
+
+	for _, output := range []struct{ name, uuid string }{
+		{"pin-output", c.profile.PinOutputUUID},
+		{"adc-output", c.profile.ADCOutputUUID},
+	} {
+		claim, ok := claims[strings.ToLower(output.uuid)]
+		if !ok {
+			record(output.name, ConformanceFailed, "characteristic %s not found", output.uuid)
+			continue
+		}
+		if !slices.Contains(claim.Properties, "read") {
+			record(output.name+" read", ConformanceFailed, "not readable")
+		} else {
+			n, err := c.checkRead(ctx, output.uuid)
+			result(output.name+" read", err, "%d pin(s)", n)
+		}
+		if !slices.Contains(claim.Properties, "notify") {
+			record(output.name+" notify", ConformanceSkipped, "not claimed")
+		} else {
+			n, err := c.checkNotify(ctx, output.uuid, claim, opts.Timeout)
+			result(output.name+" notify", err, "%d pin(s)", n)
+		}
+		if ctx.Err() != nil {
+			return report, ctx.Err()
+		}
+	}
+
+	input, ok := claims[strings.ToLower(c.profile.PinInputUUID)]
+	switch {
+	case !ok:
+		record("pin-input", ConformanceFailed, "characteristic %s not found", c.profile.PinInputUUID)
+	case !slices.Contains(input.Properties, "write") && !slices.Contains(input.Properties, "write-without-response"):
+		record("pin-input", ConformanceFailed, "not writable")
+	default:
+		result("pin-input malformed", c.checkMalformedWrite(ctx), "ignored")
+		if len(opts.WritePins) == 0 {
+			record("pin-input write", ConformanceSkipped, "no pins allowed to be driven")
+		}
+		for _, pin := range opts.WritePins {
+			detail, err := c.checkWrite(ctx, pin, opts.Timeout)
+			result(fmt.Sprintf("pin-input write %d", pin), err, "%s", detail)
+		}
+	}
+	if ctx.Err() != nil {
+		return report, ctx.Err()
+	}
+
+	if opts.SelfTestUUID != "" {
+		selfTest, err := c.SelfTest(ctx, SelfTestOptions{UUID: opts.SelfTestUUID, Timeout: opts.Timeout})
+		// The diagnostics' own results are the board's health, not its
+		// conformance: only the protocol is checked.
+		result("self-test", err, "%d result(s)", len(selfTest.Results))
+	}
+	return report, ctx.Err()
+}
+
+// claims returns the board's characteristics by lowercase UUID, with the
+// properties it claims for them: the stock firmware's, as far as it has
+// them, if the platform can't report properties, along with
+// ErrDescribeUnsupported.
+func (c *Client) claims(ctx context.Context) (map[string]CharacteristicInfo, error) {
+	claims := map[string]CharacteristicInfo{}
+	infos, err := c.Describe(ctx)
+	if errors.Is(err, ErrDescribeUnsupported) {
+		stock := map[string][]string{
+			strings.ToLower(c.profile.PinOutputUUID): {"read", "notify"},
+			strings.ToLower(c.profile.ADCOutputUUID): {"read", "notify"},
+			strings.ToLower(c.profile.PinInputUUID):  {"read", "write"},
+		}
+		for uuid := range c.chars {
+			uuid = strings.ToLower(uuid)
+			claims[uuid] = CharacteristicInfo{UUID: uuid, Properties: stock[uuid]}
+		}
+		return claims, err
+	}
+	for _, info := range infos {
+		claims[strings.ToLower(info.UUID)] = info
+	}
+	return claims, err
+}
+
+// checkFrame checks a frame of uuid decodes strictly with no pin twice,
+// returning how many pins it has.
+func (c *Client) checkFrame(uuid string, frame []byte) (int, error) {
+	decoder, err := c.decoder(uuid)
+	if err != nil {
+		return 0, err
+	}
+	readings, err := decoder.Decode(frame)
+	if err != nil {
+		return 0, fmt.Errorf("frame % x: %w", frame, err)
+	}
+	seen := map[uint8]bool{}
+	for _, r := range readings {
+		if seen[r.Pin] {
+			return 0, fmt.Errorf("frame % x: pin %d appears twice", frame, r.Pin)
+		}
+		seen[r.Pin] = true
+	}
+	return len(readings), nil
+}
+
+// checkRead reads uuid and checks its frame.
+func (c *Client) checkRead(ctx context.Context, uuid string) (int, error) {
+	frame, err := c.ReadRaw(ctx, uuid)
+	if err != nil {
+		return 0, err
+	}
+	return c.checkFrame(uuid, frame)
+}
+
+// checkNotify subscribes to uuid, claimed as claim, and checks the first
+// frame it notifies within timeout.
+func (c *Client) checkNotify(ctx context.Context, uuid string, claim CharacteristicInfo, timeout time.Duration) (int, error) {
+	if claim.Descriptors != nil && !slices.Contains(claim.Descriptors, CCCDUUID) {
+		return 0, errors.New("notifies but has no CCCD")
+	}
+	frames := make(chan []byte, 1)
+	err := c.SubscribeRaw(uuid, func(buf []byte, at time.Time) {
+		select {
+		case frames <- append([]byte(nil), buf...):
+		default:
+		}
+	})
+	if err != nil {
+		return 0, err
+	}
+	defer c.Unsubscribe(uuid)
+	select {
+	case frame := <-frames:
+		return c.checkFrame(uuid, frame)
+	case <-time.After(timeout):
+		return 0, fmt.Errorf("no notification within %v", timeout)
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+}
+
+// checkMalformedWrite writes a malformed document to the pin input and
+// checks the board ignores it, its pins reading as before.
+func (c *Client) checkMalformedWrite(ctx context.Context) error {
+	before, err := c.ReadPins(ctx)
+	if err != nil {
+		return err
+	}
+	char, err := c.Characteristic(c.profile.PinInputUUID)
+	if err != nil {
+		return err
+	}
+	if err := c.write(ctx, char, []byte(malformedPinRequest)); err != nil {
+		return fmt.Errorf("failed to write: %w", err)
+	}
+	after, err := c.ReadPins(ctx)
+	if err != nil {
+		return fmt.Errorf("board unresponsive after a malformed write: %w", err)
+	}
+	for _, r := range before {
+		i := slices.IndexFunc(after, func(a Reading) bool { return a.Pin == r.Pin })
+		if i < 0 || after[i].Value != r.Value {
+			return fmt.Errorf("pin %d changed after a malformed write", r.Pin)
+		}
+	}
+	return nil
+}
+
+// checkWrite drives pin to the other digital state, checks the pin output
+// reports it within timeout and puts it back.
+func (c *Client) checkWrite(ctx context.Context, pin uint8, timeout time.Duration) (string, error) {
+	state := func() (int, error) {
+		readings, err := c.ReadPins(ctx)
+		if err != nil {
+			return 0, err
+		}
+		i := slices.IndexFunc(readings, func(r Reading) bool { return r.Pin == pin })
+		if i < 0 {
+			return 0, fmt.Errorf("pin %d not reported", pin)
+		}
+		return readings[i].Value, nil
+	}
+	original, err := state()
+	if err != nil {
+		return "", err
+	}
+	target := uint8(100)
+	if original == 100 {
+		target = 0
+	}
+	if err := c.WritePins(ctx, []PinWrite{{PinNum: pin, State: target}}); err != nil {
+		return "", err
+	}
+	deadline := time.Now().Add(timeout)
+	got, err := state()
+	for err == nil && got != int(target) && time.Now().Before(deadline) {
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-time.After(50 * time.Millisecond):
+		}
+		got, err = state()
+	}
+	restore := c.WritePins(ctx, []PinWrite{{PinNum: pin, State: uint8(original)}})
+	switch {
+	case err != nil:
+		return "", err
+	case got != int(target):
+		return "", fmt.Errorf("wrote %d, pin reads %d", target, got)
+	case restore != nil:
+		return "", fmt.Errorf("failed to restore state %d: %w", original, restore)
+	}
+	return fmt.Sprintf("wrote %d, restored %d", target, original), nil
+}
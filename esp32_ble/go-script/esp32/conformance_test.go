@@ -0,0 +1,76 @@
+package esp32_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"bluetooth/esp32"
+	"bluetooth/esp32/mock"
+)
+
+func TestConformance(t *testing.T) {
+	defer verifyNoLeaks(t)
+
+	board := mock.NewBoard("esp32-test", "AA:BB:CC:DD:EE:01")
+	board.SetPin(25, 100)
+	board.EnableSelfTest(selfTestUUID)
+	board.SetSelfTestResult(esp32.SelfTestMemory, esp32.SelfTestFailed, "bad block")
+	client := connectBoard(t, board)
+	defer client.Disconnect()
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		for {
+			select {
+			case <-stop:
+				return
+			case <-time.After(10 * time.Millisecond):
+				board.Notify()
+			}
+		}
+	}()
+
+	var streamed int
+	report, err := client.Conformance(context.Background(), esp32.ConformanceOptions{
+		WritePins:    []uint8{14, 25, 99},
+		Timeout:      200 * time.Millisecond,
+		SelfTestUUID: selfTestUUID,
+		Check:        func(esp32.ConformanceCheck) { streamed++ },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if streamed != len(report.Checks) {
+		t.Errorf("Check called %d times for %d checks", streamed, len(report.Checks))
+	}
+	statuses := map[string]esp32.ConformanceStatus{}
+	for _, c := range report.Checks {
+		statuses[c.Name] = c.Status
+	}
+	for name, want := range map[string]esp32.ConformanceStatus{
+		"discovery":           esp32.ConformancePassed,
+		"service":             esp32.ConformancePassed,
+		"pin-output read":     esp32.ConformancePassed,
+		"adc-output notify":   esp32.ConformancePassed,
+		"pin-input malformed": esp32.ConformancePassed,
+		"pin-input write 14":  esp32.ConformancePassed,
+		"pin-input write 25":  esp32.ConformancePassed,
+		// The firmware doesn't drive pin 99, so it never reports it.
+		"pin-input write 99": esp32.ConformanceFailed,
+		// A failed diagnostic is the board's health, not a protocol
+		// violation.
+		"self-test": esp32.ConformancePassed,
+	} {
+		if got, ok := statuses[name]; !ok || got != want {
+			t.Errorf("check %q = %v (ran: %v), want %v", name, got, ok, want)
+		}
+	}
+	if report.Passed() {
+		t.Error("Passed() = true with a failed check")
+	}
+	if board.Pin(14) != 0 || board.Pin(25) != 100 {
+		t.Errorf("pins 14 and 25 = %d and %d, want them restored to 0 and 100", board.Pin(14), board.Pin(25))
+	}
+}
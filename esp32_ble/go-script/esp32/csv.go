@@ -0,0 +1,182 @@
+package esp32
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CSVColumns is the column layout written for recorded readings.
+var CSVColumns = []string{"timestamp", "device", "address", "id", "pin", "value"}
+
+// CSVKindColumns is CSVColumns with the reading kind, for logs holding
+// more than pin values.
+var CSVKindColumns = []string{"timestamp", "device", "address", "id", "kind", "pin", "value"}
+
+// csvRequired are the columns ReadCSV needs; device, address and id are
+// optional so single-board captures can omit them.
+var csvRequired = []string{"timestamp", "pin", "value"}
+
+// ReadCSV parses readings recorded as CSV with a header row naming the
+// timestamp, pin and value columns, and optionally device, address, id
+// and kind, in any order. Timestamps are RFC 3339. Gap markers (rows with
+// empty pin and value) are skipped. Logs written before boards had IDs
+// have no id column, and their readings take their address as their ID.
+// The result is sorted by time.
+func ReadCSV(r io.Reader) ([]Reading, error) {
+	cr := csv.NewReader(r)
+	cr.TrimLeadingSpace = true
+
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("reading header: %w", err)
+	}
+	cols := map[string]int{}
+	for i, name := range header {
+		cols[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, name := range csvRequired {
+		if _, ok := cols[name]; !ok {
+			return nil, fmt.Errorf("missing %q column", name)
+		}
+	}
+
+	var readings []Reading
+	for line := 2; ; line++ {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if record[cols["pin"]] == "" && record[cols["value"]] == "" {
+			// Gap marker written by WriteGap.
+			continue
+		}
+		at, err := time.Parse(time.RFC3339Nano, record[cols["timestamp"]])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: bad timestamp: %w", line, err)
+		}
+		pin, err := strconv.ParseUint(record[cols["pin"]], 10, 8)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: bad pin: %w", line, err)
+		}
+		value, err := strconv.Atoi(record[cols["value"]])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: bad value: %w", line, err)
+		}
+		reading := Reading{Time: at, Pin: uint8(pin), Value: value}
+		if i, ok := cols["device"]; ok {
+			reading.Device = record[i]
+		}
+		if i, ok := cols["address"]; ok {
+			reading.Address = record[i]
+		}
+		reading.ID = reading.Address
+		if i, ok := cols["id"]; ok && record[i] != "" {
+			reading.ID = record[i]
+		}
+		if i, ok := cols["kind"]; ok {
+			reading.Kind = record[i]
+		}
+		readings = append(readings, reading)
+	}
+
+	sort.SliceStable(readings, func(i, j int) bool {
+		return readings[i].Time.Before(readings[j].Time)
+	})
+	return readings, nil
+}
+
+// CSVWriter appends readings as CSV rows in the layout ReadCSV expects. It
+// is safe for concurrent use.
+type CSVWriter struct {
+	mu      sync.Mutex
+	w       *csv.Writer
+	columns []string
+}
+
+// NewCSVWriter returns a writer emitting readings to w. With nil columns it
+// writes a CSVColumns header first; otherwise it writes rows in the given
+// column order, e.g. the header of a file being appended to.
+func NewCSVWriter(w io.Writer, columns []string) (*CSVWriter, error) {
+	cw := &CSVWriter{w: csv.NewWriter(w), columns: columns}
+	if columns == nil {
+		cw.columns = CSVColumns
+		if err := cw.w.Write(CSVColumns); err != nil {
+			return nil, err
+		}
+		cw.w.Flush()
+	}
+	for _, name := range csvRequired {
+		if !slices.Contains(cw.columns, name) {
+			return nil, fmt.Errorf("missing %q column", name)
+		}
+	}
+	return cw, cw.w.Error()
+}
+
+// Write appends readings and flushes them to the underlying writer.
+func (cw *CSVWriter) Write(readings []Reading) error {
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+	for _, r := range readings {
+		record := make([]string, len(cw.columns))
+		for i, name := range cw.columns {
+			switch name {
+			case "timestamp":
+				record[i] = r.Time.Format(time.RFC3339Nano)
+			case "device":
+				record[i] = r.Device
+			case "address":
+				record[i] = r.Address
+			case "id":
+				record[i] = r.ID
+			case "kind":
+				record[i] = r.Kind
+			case "pin":
+				record[i] = strconv.Itoa(int(r.Pin))
+			case "value":
+				record[i] = strconv.Itoa(r.Value)
+			}
+		}
+		if err := cw.w.Write(record); err != nil {
+			return err
+		}
+	}
+	cw.w.Flush()
+	return cw.w.Error()
+}
+
+// WriteGap appends a marker row, with empty pin and value, recording that
+// no data exists for a board from start until its next reading (e.g. the
+// host was asleep).
+func (cw *CSVWriter) WriteGap(start time.Time, device, address, id string) error {
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+	record := make([]string, len(cw.columns))
+	for i, name := range cw.columns {
+		switch name {
+		case "timestamp":
+			record[i] = start.Format(time.RFC3339Nano)
+		case "device":
+			record[i] = device
+		case "address":
+			record[i] = address
+		case "id":
+			record[i] = id
+		}
+	}
+	if err := cw.w.Write(record); err != nil {
+		return err
+	}
+	cw.w.Flush()
+	return cw.w.Error()
+}
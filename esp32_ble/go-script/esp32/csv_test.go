@@ -0,0 +1,164 @@
+package esp32_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"bluetooth/esp32"
+)
+
+func TestReadCSV(t *testing.T) {
+	// Columns in any order, rows out of time order.
+	readings, err := esp32.ReadCSV(strings.NewReader(`value, Pin ,timestamp
+2,35,2026-01-02T03:04:06Z
+1,34,2026-01-02T03:04:05.5Z
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	want := []esp32.Reading{
+		{Time: start.Add(500 * time.Millisecond), Pin: 34, Value: 1},
+		{Time: start.Add(time.Second), Pin: 35, Value: 2},
+	}
+	if len(readings) != len(want) {
+		t.Fatalf("ReadCSV = %+v, want %+v", readings, want)
+	}
+	for i := range want {
+		if !readings[i].Time.Equal(want[i].Time) || readings[i].Pin != want[i].Pin || readings[i].Value != want[i].Value {
+			t.Errorf("reading %d = %+v, want %+v", i, readings[i], want[i])
+		}
+	}
+}
+
+func TestReadCSVErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name, csv string
+	}{
+		{"empty", ""},
+		{"missing column", "timestamp,pin\n2026-01-02T03:04:05Z,35\n"},
+		{"bad timestamp", "timestamp,pin,value\nyesterday,35,1\n"},
+		{"bad pin", "timestamp,pin,value\n2026-01-02T03:04:05Z,256,1\n"},
+		{"bad value", "timestamp,pin,value\n2026-01-02T03:04:05Z,35,high\n"},
+		{"short row", "timestamp,pin,value\n2026-01-02T03:04:05Z,35\n"},
+	} {
+		if readings, err := esp32.ReadCSV(strings.NewReader(tc.csv)); err == nil {
+			t.Errorf("%s: ReadCSV = %+v, want an error", tc.name, readings)
+		}
+	}
+}
+
+func TestReadCSVSkipsGaps(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := esp32.NewCSVWriter(&buf, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	before := esp32.Reading{Time: start, Device: "esp32-test", Pin: 35, Value: 1}
+	after := esp32.Reading{Time: start.Add(time.Hour), Device: "esp32-test", Pin: 35, Value: 2}
+	if err := w.Write([]esp32.Reading{before}); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.WriteGap(start.Add(time.Second), "esp32-test", "", ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Write([]esp32.Reading{after}); err != nil {
+		t.Fatal(err)
+	}
+
+	readings, err := esp32.ReadCSV(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(readings) != 2 || readings[0].Value != 1 || readings[1].Value != 2 {
+		t.Errorf("ReadCSV = %+v, want the two readings around the gap", readings)
+	}
+}
+
+func TestCSVWriter(t *testing.T) {
+	start := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	var buf bytes.Buffer
+	w, err := esp32.NewCSVWriter(&buf, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Write([]esp32.Reading{
+		{Time: start, Device: "esp32-test", Address: "AA:BB:CC:DD:EE:01", ID: "SN-0042", Pin: 34, Value: 1},
+		{Time: start, Device: "esp32-test", Address: "AA:BB:CC:DD:EE:01", ID: "SN-0042", Pin: 35, Value: 4095},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	// Appending to an existing log keeps its columns and doesn't repeat
+	// the header.
+	w, err = esp32.NewCSVWriter(&buf, esp32.CSVColumns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Write([]esp32.Reading{{Time: start.Add(time.Second), Device: "esp32-test", Address: "AA:BB:CC:DD:EE:01", ID: "SN-0042", Pin: 34, Value: -1}}); err != nil {
+		t.Fatal(err)
+	}
+
+	want := `timestamp,device,address,id,pin,value
+2026-01-02T03:04:05Z,esp32-test,AA:BB:CC:DD:EE:01,SN-0042,34,1
+2026-01-02T03:04:05Z,esp32-test,AA:BB:CC:DD:EE:01,SN-0042,35,4095
+2026-01-02T03:04:06Z,esp32-test,AA:BB:CC:DD:EE:01,SN-0042,34,-1
+`
+	if got := buf.String(); got != want {
+		t.Errorf("wrote\n%s\nwant\n%s", got, want)
+	}
+	readings, err := esp32.ReadCSV(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(readings) != 3 || readings[2].Value != -1 || readings[2].ID != "SN-0042" {
+		t.Errorf("ReadCSV of the written log = %+v, want the three readings", readings)
+	}
+}
+
+func TestCSVWriterColumns(t *testing.T) {
+	// A log from before readings had devices keeps its layout.
+	var buf bytes.Buffer
+	w, err := esp32.NewCSVWriter(&buf, []string{"timestamp", "pin", "value"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := w.Write([]esp32.Reading{{Time: start, Device: "esp32-test", Pin: 34, Value: 1}}); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := buf.String(), "2026-01-02T03:04:05Z,34,1\n"; got != want {
+		t.Errorf("wrote %q, want %q", got, want)
+	}
+
+	if _, err := esp32.NewCSVWriter(&buf, []string{"timestamp", "device", "value"}); err == nil {
+		t.Error("NewCSVWriter accepted columns without a pin")
+	}
+}
+
+func TestReadCSVIDs(t *testing.T) {
+	// A log from before boards had IDs is kept under their addresses.
+	readings, err := esp32.ReadCSV(strings.NewReader(`timestamp,device,address,id,pin,value
+2026-01-02T03:04:05Z,esp32-test,AA:BB:CC:DD:EE:01,SN-0042,35,1
+2026-01-02T03:04:06Z,esp32-test,AA:BB:CC:DD:EE:01,,35,2
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	old, err := esp32.ReadCSV(strings.NewReader(`timestamp,device,address,pin,value
+2026-01-02T03:04:05Z,esp32-test,AA:BB:CC:DD:EE:01,35,1
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []string{"SN-0042", "AA:BB:CC:DD:EE:01"} {
+		if readings[i].ID != want {
+			t.Errorf("reading %d has ID %q, want %q", i, readings[i].ID, want)
+		}
+	}
+	if old[0].ID != "AA:BB:CC:DD:EE:01" {
+		t.Errorf("reading without an id column has ID %q, want its address", old[0].ID)
+	}
+}
@@ -0,0 +1,475 @@
+package esp32
+
+import (
+	"cmp"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Decoder decodes a characteristic's frames into readings. The client
+// stamps them with the time and board, so decoders only fill in Pin,
+// Value and, for readings other than pin values, Kind.
+type Decoder interface {
+	Decode(raw []byte) ([]Reading, error)
+}
+
+// DecoderFunc adapts a function to a Decoder.
+type DecoderFunc func(raw []byte) ([]Reading, error)
+
+func (f DecoderFunc) Decode(raw []byte) ([]Reading, error) {
+	return f(raw)
+}
+
+// Names of the built-in decoders.
+const (
+	// DecoderPin8 is the stock pin frame: num_pins, then (pin, value).
+	DecoderPin8 = "pin8"
+	// DecoderPin16 is the stock ADC frame: num_pins, then (pin, high
+	// byte, low byte).
+	DecoderPin16 = "pin16"
+	// DecoderFloat32 is num_pins, then (pin, little-endian float32) per
+	// pin, for firmware sending calibrated values. Values are rounded to
+	// the nearest integer.
+	DecoderFloat32 = "float32"
+	// DecoderBatch16 is for firmware batching samples to save radio
+	// time: num_pins, samples per pin, the sample interval in
+	// milliseconds as a big-endian uint16, then per pin the pin and its
+	// samples as 16-bit values, oldest first. The newest samples are
+	// stamped with the frame's time and the older ones an interval apart
+	// before it.
+	DecoderBatch16 = "batch16"
+	// DecoderDelta16 is for firmware sending a full snapshot now and
+	// then and only the changed pins in between; see deltaDecoder.
+	DecoderDelta16 = "delta16"
+)
+
+var (
+	decodersMu sync.RWMutex
+	decoders   = map[string]Decoder{
+		DecoderPin8: frameDecoder{format: DecoderPin8, size: 2, value: func(entry []byte) int {
+			return int(entry[0])
+		}},
+		DecoderPin16: frameDecoder{format: DecoderPin16, size: 3, value: ValueFormat{}.value},
+		DecoderFloat32: frameDecoder{format: DecoderFloat32, size: 5, value: func(entry []byte) int {
+			return int(math.Round(float64(math.Float32frombits(binary.LittleEndian.Uint32(entry)))))
+		}},
+		DecoderBatch16: batchDecoder{value: ValueFormat{}.value},
+		DecoderDelta16: deltaDecoder{value: ValueFormat{}.value},
+	}
+)
+
+// DecodeMode is how a client treats a frame its decoder finds
+// inconsistent.
+type DecodeMode int
+
+const (
+	// DecodeStrict rejects the frame, so firmware sending a format the
+	// profile doesn't describe is noticed while it is being developed.
+	DecodeStrict DecodeMode = iota
+	// DecodeLenient keeps the readings the decoder can salvage, reporting
+	// what it dropped, so a board in production keeps reporting the pins
+	// that are intact.
+	DecodeLenient
+)
+
+func (m DecodeMode) String() string {
+	switch m {
+	case DecodeStrict:
+		return "strict"
+	case DecodeLenient:
+		return "lenient"
+	}
+	return fmt.Sprintf("DecodeMode(%d)", int(m))
+}
+
+// UnmarshalText parses "strict" or "lenient".
+func (m *DecodeMode) UnmarshalText(text []byte) error {
+	switch string(text) {
+	case "strict":
+		*m = DecodeStrict
+	case "lenient":
+		*m = DecodeLenient
+	default:
+		return fmt.Errorf("unknown decode mode %q (want strict or lenient)", text)
+	}
+	return nil
+}
+
+// ByteOrder is the order of a value's bytes in a frame.
+type ByteOrder int
+
+const (
+	// BigEndian sends the high byte first, as the stock firmware does.
+	BigEndian ByteOrder = iota
+	LittleEndian
+)
+
+func (o ByteOrder) String() string {
+	switch o {
+	case BigEndian:
+		return "big"
+	case LittleEndian:
+		return "little"
+	}
+	return fmt.Sprintf("ByteOrder(%d)", int(o))
+}
+
+// UnmarshalText parses "big" or "little".
+func (o *ByteOrder) UnmarshalText(text []byte) error {
+	switch string(text) {
+	case "big":
+		*o = BigEndian
+	case "little":
+		*o = LittleEndian
+	default:
+		return fmt.Errorf("unknown byte order %q (want big or little)", text)
+	}
+	return nil
+}
+
+// ValueFormat is how the two bytes of each value in a pin16, batch16 or
+// delta16 frame make up the value, for firmware builds that don't send
+// the stock unsigned big-endian 16 bits. The zero ValueFormat is the
+// stock one.
+type ValueFormat struct {
+	ByteOrder ByteOrder
+	// Bits is how many of the low bits hold the value, e.g. 12 for a
+	// 12-bit ADC reading packed with flags in the top nibble; 0 means 16.
+	Bits int
+	// Signed values are two's complement in Bits bits.
+	Signed bool
+}
+
+// Validate reports a format that can't be decoded.
+func (f ValueFormat) Validate() error {
+	if f.Bits < 0 || f.Bits > 16 {
+		return fmt.Errorf("values must be 1 to 16 bits, not %d", f.Bits)
+	}
+	return nil
+}
+
+// value decodes a 2-byte value in f.
+func (f ValueFormat) value(b []byte) int {
+	v := binary.BigEndian.Uint16(b)
+	if f.ByteOrder == LittleEndian {
+		v = binary.LittleEndian.Uint16(b)
+	}
+	bits := cmp.Or(f.Bits, 16)
+	v &= uint16(1<<bits - 1)
+	if f.Signed && v&(1<<(bits-1)) != 0 {
+		return int(v) - 1<<bits
+	}
+	return int(v)
+}
+
+// Salvager is implemented by decoders that can recover readings from a
+// frame Decode rejects, for DecodeLenient. The error describes what was
+// dropped.
+type Salvager interface {
+	Salvage(raw []byte) ([]Reading, error)
+}
+
+// frameDecoder is a built-in format: num_pins, then entries of size
+// bytes, the pin and then its value. Bytes past the entries are padding.
+type frameDecoder struct {
+	format string
+	size   int
+	value  func(entry []byte) int
+}
+
+// Decode rejects a frame too short for its pin count or listing a pin
+// twice, as happens when a frame in another format is read with fixed
+// offsets.
+func (d frameDecoder) Decode(raw []byte) ([]Reading, error) {
+	readings, err := d.Salvage(raw)
+	if err != nil {
+		return nil, err
+	}
+	return readings, nil
+}
+
+// Salvage decodes the entries that fit in the frame, keeping the first
+// of a repeated pin.
+func (d frameDecoder) Salvage(raw []byte) ([]Reading, error) {
+	if len(raw) == 0 {
+		return nil, nil
+	}
+	numPins := int(raw[0])
+	var problems []string
+	if fit := (len(raw) - 1) / d.size; fit < numPins {
+		problems = append(problems, fmt.Sprintf("%s frame of %d byte(s) is too short for %d pin(s)", d.format, len(raw), numPins))
+		numPins = fit
+	}
+	var seen, repeated [256]bool
+	readings := make([]Reading, 0, numPins)
+	for i := range numPins {
+		entry := raw[1+i*d.size : 1+(i+1)*d.size]
+		pin := entry[0]
+		if seen[pin] {
+			if !repeated[pin] {
+				problems = append(problems, fmt.Sprintf("%s frame lists pin %d twice", d.format, pin))
+			}
+			repeated[pin] = true
+			continue
+		}
+		seen[pin] = true
+		readings = append(readings, Reading{Pin: pin, Value: d.value(entry[1:])})
+	}
+	if len(problems) > 0 {
+		return readings, errors.New(strings.Join(problems, "; "))
+	}
+	return readings, nil
+}
+
+// TimedDecoder is implemented by decoders that stamp readings with their
+// own times, such as when a frame carries samples taken before it was
+// sent. The client calls DecodeAt with the frame's arrival time instead
+// of Decode, stamping only readings left with a zero Time.
+type TimedDecoder interface {
+	DecodeAt(raw []byte, at time.Time) ([]Reading, error)
+}
+
+// batchDecoder is DecoderBatch16. It can't salvage a frame, so
+// DecodeLenient rejects inconsistent ones like DecodeStrict.
+type batchDecoder struct {
+	value func(b []byte) int
+}
+
+// Decode is DecodeAt for a frame arriving now.
+func (d batchDecoder) Decode(raw []byte) ([]Reading, error) {
+	return d.DecodeAt(raw, time.Now())
+}
+
+// DecodeAt returns the samples oldest first, each sample's pins in frame
+// order, so the readings' times never go backwards.
+func (d batchDecoder) DecodeAt(raw []byte, at time.Time) ([]Reading, error) {
+	if len(raw) == 0 {
+		return nil, nil
+	}
+	if len(raw) < 4 {
+		return nil, fmt.Errorf("%s frame of %d byte(s) is too short for its header", DecoderBatch16, len(raw))
+	}
+	numPins, samples := int(raw[0]), int(raw[1])
+	interval := time.Duration(binary.BigEndian.Uint16(raw[2:4])) * time.Millisecond
+	size := 1 + 2*samples
+	if 4+numPins*size > len(raw) {
+		return nil, fmt.Errorf("%s frame of %d byte(s) is too short for %d pin(s) of %d sample(s)", DecoderBatch16, len(raw), numPins, samples)
+	}
+	var seen [256]bool
+	for i := range numPins {
+		pin := raw[4+i*size]
+		if seen[pin] {
+			return nil, fmt.Errorf("%s frame lists pin %d twice", DecoderBatch16, pin)
+		}
+		seen[pin] = true
+	}
+	readings := make([]Reading, 0, numPins*samples)
+	for k := range samples {
+		t := at.Add(-time.Duration(samples-1-k) * interval)
+		for i := range numPins {
+			entry := raw[4+i*size:]
+			readings = append(readings, Reading{Time: t, Pin: entry[0], Value: d.value(entry[1+2*k:])})
+		}
+	}
+	return readings, nil
+}
+
+// RegisterDecoder makes d selectable by name, e.g. from a profile. It
+// panics if the name is taken, like registering a database driver twice.
+func RegisterDecoder(name string, d Decoder) {
+	decodersMu.Lock()
+	defer decodersMu.Unlock()
+	if _, ok := decoders[name]; ok {
+		panic(fmt.Sprintf("esp32: decoder %q registered twice", name))
+	}
+	decoders[name] = d
+}
+
+// LookupDecoder returns the decoder registered under name.
+func LookupDecoder(name string) (Decoder, bool) {
+	decodersMu.RLock()
+	defer decodersMu.RUnlock()
+	d, ok := decoders[name]
+	return d, ok
+}
+
+// DecoderNames returns the registered decoder names, sorted.
+func DecoderNames() []string {
+	decodersMu.RLock()
+	defer decodersMu.RUnlock()
+	names := make([]string, 0, len(decoders))
+	for name := range decoders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// SetDecoder makes the client decode uuid's frames with d, overriding the
+// profile. Like SetProfile, it must be called before uuid is read or
+// subscribed to.
+func (c *Client) SetDecoder(uuid string, d Decoder) {
+	if c.decoders == nil {
+		c.decoders = map[string]Decoder{}
+	}
+	c.decoders[uuid] = d
+}
+
+// decoder returns the decoder for uuid: one set with SetDecoder, else
+// the profile's, with its own state for uuid if it is a StatefulDecoder.
+func (c *Client) decoder(uuid string) (Decoder, error) {
+	if d, ok := c.decoders[uuid]; ok {
+		return d, nil
+	}
+	d, err := DecoderFor(c.profile, uuid)
+	if err != nil {
+		return nil, err
+	}
+	sd, ok := d.(StatefulDecoder)
+	if !ok {
+		return d, nil
+	}
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+	if s, ok := c.stateful[uuid]; ok {
+		return s, nil
+	}
+	if c.stateful == nil {
+		c.stateful = map[string]Decoder{}
+	}
+	c.stateful[uuid] = sd.NewState()
+	return c.stateful[uuid], nil
+}
+
+// DecoderFor returns the decoder p names for uuid, else the stock format
+// of the pin and ADC characteristics, as a client with profile p decodes
+// them. pin16, batch16 and delta16 frames are read in p's ValueFormat. A
+// StatefulDecoder is returned without state; decode with its NewState.
+func DecoderFor(p Profile, uuid string) (Decoder, error) {
+	name, ok := p.Decoders[uuid]
+	if !ok {
+		switch uuid {
+		case p.PinOutputUUID:
+			name = DecoderPin8
+			if p.PinValueBytes == 2 {
+				name = DecoderPin16
+			}
+		case p.ADCOutputUUID:
+			name = DecoderPin16
+		default:
+			return nil, fmt.Errorf("no decoder for %s", uuid)
+		}
+	}
+	if f := p.ValueFormat; f != (ValueFormat{}) {
+		switch name {
+		case DecoderPin16:
+			return frameDecoder{format: DecoderPin16, size: 3, value: f.value}, nil
+		case DecoderBatch16:
+			return batchDecoder{value: f.value}, nil
+		case DecoderDelta16:
+			return deltaDecoder{value: f.value}, nil
+		}
+	}
+	d, ok := LookupDecoder(name)
+	if !ok {
+		return nil, fmt.Errorf("unknown decoder %q for %s", name, uuid)
+	}
+	return d, nil
+}
+
+// Decode decodes a frame from uuid with its decoder, stamping the
+// readings with at, unless a TimedDecoder stamped them, and the client's
+// board. A frame the decoder rejects fails with an *UnknownPayloadError
+// unless the profile is DecodeLenient and the decoder can salvage it;
+// either way it goes to the unknown payload handler.
+func (c *Client) Decode(uuid string, frame []byte, at time.Time) ([]Reading, error) {
+	d, err := c.decoder(uuid)
+	if err != nil {
+		return nil, err
+	}
+	var readings []Reading
+	if td, ok := d.(TimedDecoder); ok {
+		readings, err = td.DecodeAt(frame, at)
+	} else {
+		readings, err = d.Decode(frame)
+	}
+	if err != nil {
+		unknown := &UnknownPayloadError{UUID: uuid, Payload: append([]byte(nil), frame...), Err: err}
+		s, ok := d.(Salvager)
+		if c.profile.DecodeMode != DecodeLenient || !ok {
+			c.reportFrame(uuid, unknown)
+			return nil, unknown
+		}
+		readings, unknown.Err = s.Salvage(frame)
+		unknown.Salvaged = len(readings)
+		c.reportFrame(uuid, unknown)
+	} else {
+		c.reportFrame(uuid, nil)
+	}
+	for i := range readings {
+		if readings[i].Time.IsZero() {
+			readings[i].Time = at
+		}
+	}
+	return c.Tag(readings), nil
+}
+
+// UnknownPayloadError reports a frame its characteristic's decoder
+// doesn't recognize, typically from firmware sending a format the profile
+// doesn't name.
+type UnknownPayloadError struct {
+	UUID    string
+	Payload []byte
+	Err     error
+	// Salvaged is how many readings DecodeLenient kept from the frame.
+	Salvaged int
+}
+
+func (e *UnknownPayloadError) Error() string {
+	if e.Salvaged > 0 {
+		return fmt.Sprintf("salvaged %d reading(s) from an inconsistent payload from %s: %v", e.Salvaged, e.UUID, e.Err)
+	}
+	return fmt.Sprintf("unknown payload from %s: %v", e.UUID, e.Err)
+}
+
+func (e *UnknownPayloadError) Unwrap() error {
+	return e.Err
+}
+
+// Hexdump returns the payload in hex.Dump's format.
+func (e *UnknownPayloadError) Hexdump() string {
+	return hex.Dump(e.Payload)
+}
+
+// SetUnknownPayloadHandler makes the client call fn when a frame of a
+// characteristic, read or notified, is one its decoder rejects. To keep a
+// board sending an unexpected format from flooding fn, it is called for
+// the first such frame and then again only after a frame of the
+// characteristic has decoded cleanly; rejected notifications are all
+// counted in NotifyStats. fn runs on the goroutine decoding the frame.
+func (c *Client) SetUnknownPayloadHandler(fn func(*UnknownPayloadError)) {
+	c.statsMu.Lock()
+	defer c.statsMu.Unlock()
+	c.onUnknown = fn
+}
+
+// reportFrame records whether uuid's latest frame decoded cleanly,
+// passing err to the unknown payload handler if it starts a run of bad
+// frames.
+func (c *Client) reportFrame(uuid string, err *UnknownPayloadError) {
+	c.statsMu.Lock()
+	report := err != nil && !c.unknown[uuid]
+	c.unknown[uuid] = err != nil
+	onUnknown := c.onUnknown
+	c.statsMu.Unlock()
+	if report && onUnknown != nil {
+		onUnknown(err)
+	}
+}
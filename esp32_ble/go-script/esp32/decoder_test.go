@@ -0,0 +1,331 @@
+package esp32_test
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"bluetooth/esp32"
+	"bluetooth/esp32/mock"
+)
+
+func TestBuiltinDecoders(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		decoder string
+		frame   []byte
+		want    []esp32.Reading
+		wantErr string
+	}{
+		{"pin8", esp32.DecoderPin8, []byte{2, 14, 1, 26, 0}, []esp32.Reading{{Pin: 14, Value: 1}, {Pin: 26, Value: 0}}, ""},
+		{"pin16", esp32.DecoderPin16, []byte{1, 35, 0x04, 0xd2}, []esp32.Reading{{Pin: 35, Value: 1234}}, ""},
+		// 21.5 and -3.25 as little-endian float32.
+		{"float32", esp32.DecoderFloat32, []byte{2, 35, 0x00, 0x00, 0xac, 0x41, 32, 0x00, 0x00, 0x50, 0xc0}, []esp32.Reading{{Pin: 35, Value: 22}, {Pin: 32, Value: -3}}, ""},
+		{"float32 short", esp32.DecoderFloat32, []byte{2, 35, 0x00, 0x00, 0xac, 0x41}, nil, "too short for 2 pin(s)"},
+		// The firmware sends fixed 32-byte frames, padded after the pins.
+		{"pin16 padded", esp32.DecoderPin16, append([]byte{1, 35, 0x04, 0xd2}, make([]byte, 28)...), []esp32.Reading{{Pin: 35, Value: 1234}}, ""},
+		{"pin8 short", esp32.DecoderPin8, []byte{3, 14, 1, 26, 0}, nil, "pin8 frame of 5 byte(s) is too short for 3 pin(s)"},
+		{"pin16 repeated pin", esp32.DecoderPin16, []byte{2, 35, 0x04, 0xd2, 35, 0x00, 0x01}, nil, "pin16 frame lists pin 35 twice"},
+		{"batch16 short", esp32.DecoderBatch16, []byte{2, 3, 0x00, 0x64, 35, 0x04, 0xd2}, nil, "too short for 2 pin(s) of 3 sample(s)"},
+		{"empty", esp32.DecoderFloat32, nil, nil, ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			d, ok := esp32.LookupDecoder(tc.decoder)
+			if !ok {
+				t.Fatalf("decoder %q not registered", tc.decoder)
+			}
+			got, err := d.Decode(tc.frame)
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("err = %v, want %q", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(got, tc.want) {
+				t.Errorf("readings = %+v, want %+v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestProfileDecoders(t *testing.T) {
+	defer verifyNoLeaks(t)
+
+	esp32.RegisterDecoder("test-count", esp32.DecoderFunc(func(raw []byte) ([]esp32.Reading, error) {
+		return []esp32.Reading{{Pin: 1, Value: len(raw)}}, nil
+	}))
+	if err := (esp32.Profile{Decoders: map[string]string{esp32.ADCDataOutputUUID: "nope"}}).Validate(); err == nil {
+		t.Error("Validate accepted an unknown decoder")
+	}
+
+	board := mock.NewBoard("esp32-custom", "AA:BB:CC:DD:EE:07")
+	client := connectBoard(t, board)
+	defer client.Disconnect()
+	client.SetProfile(esp32.Profile{Decoders: map[string]string{esp32.ADCDataOutputUUID: "test-count"}})
+	at := time.Now()
+	readings, err := client.ReadADC(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(readings) != 1 || readings[0].Value != 32 || readings[0].Device != "esp32-custom" || readings[0].Time.Before(at) {
+		t.Errorf("readings = %+v, want the 32-byte frame counted, stamped with the board and time", readings)
+	}
+
+	// A decoder set on the client overrides the profile, and frames it
+	// rejects are counted rather than delivered.
+	client.SetDecoder(esp32.ADCDataOutputUUID, esp32.DecoderFunc(func([]byte) ([]esp32.Reading, error) {
+		return nil, errors.New("bad frame")
+	}))
+	reported := make(chan *esp32.UnknownPayloadError, 3)
+	client.SetUnknownPayloadHandler(func(err *esp32.UnknownPayloadError) { reported <- err })
+	_, err = client.ReadADC(context.Background())
+	var unknown *esp32.UnknownPayloadError
+	if !errors.As(err, &unknown) || unknown.UUID != esp32.ADCDataOutputUUID || len(unknown.Payload) != 32 || !strings.Contains(err.Error(), "bad frame") {
+		t.Errorf("ReadADC err = %v, want an *UnknownPayloadError with the frame and the decoder's error", err)
+	}
+
+	// The read started a run of rejected frames, which is only reported
+	// once however they arrive.
+	if err := client.SubscribeADC(func([]esp32.Reading) { t.Error("undecodable frame delivered") }); err != nil {
+		t.Fatal(err)
+	}
+	board.Notify()
+	board.Notify()
+	deadline := time.Now().Add(time.Second)
+	for client.NotifyStats().Undecodable < 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("undecodable notifications not counted")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if len(reported) != 1 {
+		t.Fatalf("%d unknown payload(s) reported, want 1", len(reported))
+	}
+	if err := <-reported; !strings.HasPrefix(err.Hexdump(), "00000000  02 23 00 00 20 00 00") {
+		t.Errorf("Hexdump() = %q, want the ADC frame", err.Hexdump())
+	}
+}
+
+func TestDecoderFor(t *testing.T) {
+	wide := esp32.DefaultProfile()
+	wide.PinValueBytes = 2
+	named := esp32.DefaultProfile()
+	named.Decoders = map[string]string{named.ADCOutputUUID: esp32.DecoderFloat32}
+	little := esp32.DefaultProfile()
+	little.ValueFormat = esp32.ValueFormat{ByteOrder: esp32.LittleEndian}
+	for _, tc := range []struct {
+		name    string
+		profile esp32.Profile
+		uuid    string
+		frame   []byte
+		want    []esp32.Reading
+		wantErr string
+	}{
+		{"stock pins", esp32.DefaultProfile(), esp32.PinDataOutputUUID, []byte{1, 14, 1}, []esp32.Reading{{Pin: 14, Value: 1}}, ""},
+		{"stock ADC", esp32.DefaultProfile(), esp32.ADCDataOutputUUID, []byte{1, 35, 0x04, 0xd2}, []esp32.Reading{{Pin: 35, Value: 1234}}, ""},
+		{"2-byte pins", wide, esp32.PinDataOutputUUID, []byte{1, 14, 0x01, 0x00}, []esp32.Reading{{Pin: 14, Value: 256}}, ""},
+		{"named", named, esp32.ADCDataOutputUUID, []byte{1, 35, 0x00, 0x00, 0xac, 0x41}, []esp32.Reading{{Pin: 35, Value: 22}}, ""},
+		{"value format", little, esp32.ADCDataOutputUUID, []byte{1, 35, 0xd2, 0x04}, []esp32.Reading{{Pin: 35, Value: 1234}}, ""},
+		{"other characteristic", esp32.DefaultProfile(), esp32.PinDataInputUUID, nil, nil, "no decoder for"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			d, err := esp32.DecoderFor(tc.profile, tc.uuid)
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("err = %v, want %q", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := d.Decode(tc.frame)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(got, tc.want) {
+				t.Errorf("readings = %+v, want %+v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestDecodeModes(t *testing.T) {
+	defer verifyNoLeaks(t)
+
+	// A profile expecting 16-bit pin values misreads the stock 8-bit pin
+	// frame, whose padding then repeats pin 0.
+	board := mock.NewBoard("esp32-test", "AA:BB:CC:DD:EE:01")
+	client := connectBoard(t, board)
+	defer client.Disconnect()
+	reported := make(chan *esp32.UnknownPayloadError, 2)
+	client.SetUnknownPayloadHandler(func(err *esp32.UnknownPayloadError) { reported <- err })
+
+	client.SetProfile(esp32.Profile{PinValueBytes: 2, DecodeMode: esp32.DecodeLenient})
+	for range 2 {
+		readings, err := client.ReadPins(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(readings) != 3 {
+			t.Errorf("lenient ReadPins() = %+v, want the 3 distinct pins salvaged", readings)
+		}
+	}
+	if len(reported) != 1 {
+		t.Fatalf("%d frame(s) reported, want the first salvaged one", len(reported))
+	}
+	if err := <-reported; err.Salvaged != 3 || !strings.Contains(err.Error(), "salvaged 3 reading(s)") || !strings.Contains(err.Error(), "lists pin 0 twice") {
+		t.Errorf("reported %v, want 3 readings salvaged from a repeated pin 0", err)
+	}
+
+	client.SetProfile(esp32.Profile{PinValueBytes: 2})
+	var unknown *esp32.UnknownPayloadError
+	if _, err := client.ReadPins(context.Background()); !errors.As(err, &unknown) || unknown.Salvaged != 0 {
+		t.Errorf("strict ReadPins() err = %v, want the frame rejected", err)
+	}
+}
+
+func TestValueFormats(t *testing.T) {
+	defer verifyNoLeaks(t)
+
+	// Stock frames are big-endian, so reading them in other formats shows
+	// which bytes and bits each one takes.
+	board := mock.NewBoard("esp32-test", "AA:BB:CC:DD:EE:01")
+	board.SetADC(35, 0x04d2)
+	board.SetADC(32, 0xf0ff)
+	client := connectBoard(t, board)
+	defer client.Disconnect()
+	for _, tc := range []struct {
+		name   string
+		format esp32.ValueFormat
+		want   []int
+	}{
+		{"stock", esp32.ValueFormat{}, []int{1234, 61695}},
+		{"little-endian", esp32.ValueFormat{ByteOrder: esp32.LittleEndian}, []int{53764, 65520}},
+		{"signed", esp32.ValueFormat{Signed: true}, []int{1234, -3841}},
+		{"12-bit", esp32.ValueFormat{Bits: 12}, []int{1234, 255}},
+		{"signed 12-bit little-endian", esp32.ValueFormat{ByteOrder: esp32.LittleEndian, Bits: 12, Signed: true}, []int{516, -16}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			client.SetProfile(esp32.Profile{ValueFormat: tc.format})
+			readings, err := client.ReadADC(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []int
+			for _, r := range readings {
+				got = append(got, r.Value)
+			}
+			if !slices.Equal(got, tc.want) {
+				t.Errorf("values = %v, want %v", got, tc.want)
+			}
+		})
+	}
+
+	if err := (esp32.Profile{ValueFormat: esp32.ValueFormat{Bits: 24}}).Validate(); err == nil {
+		t.Error("Validate accepted 24-bit values")
+	}
+}
+
+func TestBatchDecoder(t *testing.T) {
+	defer verifyNoLeaks(t)
+
+	board := mock.NewBoard("esp32-test", "AA:BB:CC:DD:EE:01")
+	client := connectBoard(t, board)
+	defer client.Disconnect()
+	client.SetProfile(esp32.Profile{Decoders: map[string]string{esp32.ADCDataOutputUUID: esp32.DecoderBatch16}})
+
+	// Pins 35 and 32, three samples each 100ms apart.
+	frame := []byte{2, 3, 0x00, 0x64, 35, 0x00, 0x01, 0x00, 0x02, 0x00, 0x03, 32, 0x0f, 0xfd, 0x0f, 0xfe, 0x0f, 0xff}
+	at := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	readings, err := client.Decode(esp32.ADCDataOutputUUID, frame, at)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []esp32.Reading{
+		{Pin: 35, Value: 1, Time: at.Add(-200 * time.Millisecond)},
+		{Pin: 32, Value: 4093, Time: at.Add(-200 * time.Millisecond)},
+		{Pin: 35, Value: 2, Time: at.Add(-100 * time.Millisecond)},
+		{Pin: 32, Value: 4094, Time: at.Add(-100 * time.Millisecond)},
+		{Pin: 35, Value: 3, Time: at},
+		{Pin: 32, Value: 4095, Time: at},
+	}
+	for i := range want {
+		want[i].Device, want[i].Address, want[i].ID = "esp32-test", "AA:BB:CC:DD:EE:01", "AA:BB:CC:DD:EE:01"
+	}
+	if !slices.Equal(readings, want) {
+		t.Errorf("readings = %+v, want %+v", readings, want)
+	}
+
+	// The profile's value format applies to batches too.
+	client.SetProfile(esp32.Profile{
+		Decoders:    map[string]string{esp32.ADCDataOutputUUID: esp32.DecoderBatch16},
+		ValueFormat: esp32.ValueFormat{ByteOrder: esp32.LittleEndian},
+	})
+	readings, err = client.Decode(esp32.ADCDataOutputUUID, []byte{1, 1, 0x00, 0x64, 35, 0xd2, 0x04}, at)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(readings) != 1 || readings[0].Value != 1234 || !readings[0].Time.Equal(at) {
+		t.Errorf("little-endian readings = %+v, want 1234 at the frame's time", readings)
+	}
+}
+
+func TestDeltaDecoder(t *testing.T) {
+	defer verifyNoLeaks(t)
+
+	board := mock.NewBoard("esp32-test", "AA:BB:CC:DD:EE:01")
+	client := connectBoard(t, board)
+	defer client.Disconnect()
+	client.SetProfile(esp32.Profile{Decoders: map[string]string{esp32.ADCDataOutputUUID: esp32.DecoderDelta16}})
+
+	values := func(readings []esp32.Reading) map[uint8]int {
+		m := map[uint8]int{}
+		for _, r := range readings {
+			m[r.Pin] = r.Value
+		}
+		return m
+	}
+	for _, step := range []struct {
+		name    string
+		frame   []byte
+		want    map[uint8]int
+		wantErr string
+	}{
+		{"change before snapshot", []byte{1, 1, 35, 0x00, 0x07}, map[uint8]int{}, ""},
+		{"snapshot", []byte{0, 2, 35, 0x04, 0xd2, 32, 0x0f, 0xff}, map[uint8]int{35: 1234, 32: 4095}, ""},
+		{"change", []byte{1, 1, 32, 0x00, 0x10}, map[uint8]int{35: 1234, 32: 16}, ""},
+		{"new pin", []byte{1, 1, 34, 0x00, 0x01}, map[uint8]int{35: 1234, 32: 16, 34: 1}, ""},
+		{"rejected", []byte{1, 2, 35, 0x00, 0x00, 35, 0x00, 0x01}, nil, "delta16 frame lists pin 35 twice"},
+		{"unknown kind", []byte{7, 0}, nil, "delta16 frame of unknown kind 7"},
+		{"after rejection", []byte{1, 0}, map[uint8]int{35: 1234, 32: 16, 34: 1}, ""},
+		{"snapshot drops pins", []byte{0, 1, 35, 0x00, 0x02}, map[uint8]int{35: 2}, ""},
+	} {
+		readings, err := client.Decode(esp32.ADCDataOutputUUID, step.frame, time.Now())
+		if step.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), step.wantErr) {
+				t.Errorf("%s: err = %v, want %q", step.name, err, step.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+		if got := values(readings); !maps.Equal(got, step.want) {
+			t.Errorf("%s: readings = %v, want %v", step.name, got, step.want)
+		}
+	}
+
+	// A new profile starts over, waiting for a snapshot.
+	client.SetProfile(esp32.Profile{Decoders: map[string]string{esp32.ADCDataOutputUUID: esp32.DecoderDelta16}})
+	if readings, err := client.Decode(esp32.ADCDataOutputUUID, []byte{1, 1, 35, 0x00, 0x03}, time.Now()); err != nil || len(readings) != 0 {
+		t.Errorf("change after SetProfile = %v, %v, want nothing until a snapshot", readings, err)
+	}
+}
@@ -0,0 +1,95 @@
+package esp32
+
+import (
+	"fmt"
+	"sync"
+)
+
+// StatefulDecoder is implemented by decoders whose frames depend on the
+// ones before them. A client decodes each characteristic with its own
+// copy from NewState, made when the characteristic is first decoded, so
+// state never leaks between characteristics, connections or profiles.
+type StatefulDecoder interface {
+	Decoder
+	NewState() Decoder
+}
+
+// Kinds of delta16 frame, its first byte.
+const (
+	deltaSnapshot = 0
+	deltaChange   = 1
+)
+
+// deltaDecoder is DecoderDelta16. Frames are a kind byte, 0 for a
+// snapshot of every pin or 1 for the pins that changed since the last
+// frame, then num_pins and (pin, 16-bit value) per pin. Either way it
+// returns the whole reconstructed state, in the order pins first
+// appeared, so the rest of the pipeline only sees snapshots. Changes
+// before the first snapshot are dropped, since the pins they leave out
+// are unknown.
+type deltaDecoder struct {
+	value func(b []byte) int
+	// state is nil in the registered decoder, which then decodes each
+	// frame on its own.
+	state *deltaState
+}
+
+type deltaState struct {
+	mu     sync.Mutex
+	synced bool
+	pins   []uint8
+	values map[uint8]int
+}
+
+func (d deltaDecoder) NewState() Decoder {
+	return deltaDecoder{value: d.value, state: &deltaState{}}
+}
+
+func (d deltaDecoder) Decode(raw []byte) ([]Reading, error) {
+	if len(raw) == 0 {
+		return nil, nil
+	}
+	if len(raw) < 2 {
+		return nil, fmt.Errorf("%s frame of %d byte(s) is too short for its header", DecoderDelta16, len(raw))
+	}
+	kind, numPins := raw[0], int(raw[1])
+	if kind != deltaSnapshot && kind != deltaChange {
+		return nil, fmt.Errorf("%s frame of unknown kind %d", DecoderDelta16, kind)
+	}
+	if 2+numPins*3 > len(raw) {
+		return nil, fmt.Errorf("%s frame of %d byte(s) is too short for %d pin(s)", DecoderDelta16, len(raw), numPins)
+	}
+	var seen [256]bool
+	entries := make([]Reading, 0, numPins)
+	for i := range numPins {
+		entry := raw[2+i*3:]
+		if seen[entry[0]] {
+			return nil, fmt.Errorf("%s frame lists pin %d twice", DecoderDelta16, entry[0])
+		}
+		seen[entry[0]] = true
+		entries = append(entries, Reading{Pin: entry[0], Value: d.value(entry[1:3])})
+	}
+
+	s := d.state
+	if s == nil {
+		s = &deltaState{}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if kind == deltaSnapshot {
+		s.synced, s.pins, s.values = true, nil, map[uint8]int{}
+	} else if !s.synced {
+		return nil, nil
+	}
+	for _, e := range entries {
+		if _, ok := s.values[e.Pin]; !ok {
+			s.pins = append(s.pins, e.Pin)
+		}
+		s.values[e.Pin] = e.Value
+	}
+	readings := make([]Reading, len(s.pins))
+	for i, pin := range s.pins {
+		readings[i] = Reading{Pin: pin, Value: s.values[pin]}
+	}
+	return readings, nil
+}
@@ -0,0 +1,72 @@
+//go:build linux
+
+package esp32
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/godbus/dbus/v5"
+)
+
+// Describe lists the device's characteristics with their BlueZ flags and
+// descriptors, read from BlueZ's object tree since tinygo doesn't expose
+// them.
+func (d bleDevice) Describe() ([]CharacteristicInfo, error) {
+	bus, err := dbus.SystemBus()
+	if err != nil {
+		return nil, err
+	}
+	var objects map[dbus.ObjectPath]map[string]map[string]dbus.Variant
+	err = bus.Object("org.bluez", "/").
+		Call("org.freedesktop.DBus.ObjectManager.GetManagedObjects", 0).
+		Store(&objects)
+	if err != nil {
+		return nil, err
+	}
+
+	devicePath := string(d.objectPath()) + "/"
+	serviceUUIDs := map[dbus.ObjectPath]string{}
+	for path, ifaces := range objects {
+		if props, ok := ifaces["org.bluez.GattService1"]; ok && strings.HasPrefix(string(path), devicePath) {
+			serviceUUIDs[path], _ = props["UUID"].Value().(string)
+		}
+	}
+
+	chars := map[dbus.ObjectPath]*CharacteristicInfo{}
+	for path, ifaces := range objects {
+		props, ok := ifaces["org.bluez.GattCharacteristic1"]
+		if !ok || !strings.HasPrefix(string(path), devicePath) {
+			continue
+		}
+		info := &CharacteristicInfo{}
+		info.UUID, _ = props["UUID"].Value().(string)
+		info.Properties, _ = props["Flags"].Value().([]string)
+		if service, ok := props["Service"].Value().(dbus.ObjectPath); ok {
+			info.ServiceUUID = serviceUUIDs[service]
+		}
+		chars[path] = info
+	}
+	for _, ifaces := range objects {
+		props, ok := ifaces["org.bluez.GattDescriptor1"]
+		if !ok {
+			continue
+		}
+		char, _ := props["Characteristic"].Value().(dbus.ObjectPath)
+		if info, ok := chars[char]; ok {
+			uuid, _ := props["UUID"].Value().(string)
+			info.Descriptors = append(info.Descriptors, uuid)
+		}
+	}
+
+	paths := make([]string, 0, len(chars))
+	for path := range chars {
+		paths = append(paths, string(path))
+	}
+	sort.Strings(paths)
+	out := make([]CharacteristicInfo, 0, len(paths))
+	for _, path := range paths {
+		out = append(out, *chars[dbus.ObjectPath(path)])
+	}
+	return out, nil
+}
@@ -0,0 +1,460 @@
+package esp32
+
+import (
+	"bytes"
+	"cmp"
+	"compress/zlib"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Bulk download wire format, for backfill and burst captures the board
+// has buffered. The client subscribes to the data characteristic and
+// writes a start request to the control characteristic:
+//
+//	downloadStart  u8
+//	accepted       u8  bit 1<<c set for each Compression c
+//	window         u8  frames to send before waiting for an ack
+//
+// The board then notifies the data characteristic with frames prefixed,
+// like OTA chunks, by a 16-bit little-endian frame number counting from
+// zero. Frame 0 holds the header:
+//
+//	compression      u8
+//	window bits      u8  heatshrink only
+//	lookahead bits   u8  heatshrink only
+//	data length      u32 little-endian, uncompressed
+//	data CRC32       u32 little-endian, IEEE, uncompressed
+//	transfer length  u32 little-endian, as sent
+//
+// and the frames after it the transfer bytes, until there are transfer
+// length of them. After each window, or when frames stop arriving, the
+// client acknowledges what it has:
+//
+//	downloadAck  u8
+//	next         u16 little-endian, every frame before it received
+//	ranges       u8
+//	ranges × (first u16, end u16), little-endian, frames received past next
+//
+// and the board sends the frames of the next window that aren't
+// acknowledged, so a lost notification is resent rather than restarting
+// the transfer. Frame numbers limit a transfer to 65536 frames.
+//
+// A transfer interrupted by a disconnect is resumed by writing
+// downloadResume with the start request's fields and then the first
+// frame still needed, u16 little-endian. The board sends the header again
+// and the frames from there on, as if the ones between were acknowledged.
+const (
+	downloadStart      = 0x01
+	downloadAck        = 0x02
+	downloadResume     = 0x03
+	downloadHeaderSize = 15
+	// downloadWindow is the default DownloadOptions.Window.
+	downloadWindow = 16
+	// downloadMaxRanges bounds an ack to fit the smallest MTU.
+	downloadMaxRanges = 4
+	// downloadAckDelay is how long the client waits for the rest of a
+	// window before acknowledging what it has.
+	downloadAckDelay = 250 * time.Millisecond
+	// downloadStall is how long a download waits for a new frame.
+	downloadStall = 5 * time.Second
+)
+
+// Compression is how a board compresses a bulk transfer.
+type Compression uint8
+
+const (
+	CompressionNone Compression = iota
+	CompressionZlib
+	// CompressionHeatshrink is the LZSS variant small firmware uses, as
+	// the heatshrink library encodes it.
+	CompressionHeatshrink
+)
+
+// Compressions lists every supported compression.
+var Compressions = []Compression{CompressionNone, CompressionZlib, CompressionHeatshrink}
+
+func (c Compression) String() string {
+	switch c {
+	case CompressionNone:
+		return "none"
+	case CompressionZlib:
+		return "zlib"
+	case CompressionHeatshrink:
+		return "heatshrink"
+	}
+	return fmt.Sprintf("Compression(%d)", uint8(c))
+}
+
+// ParseCompression parses a compression's name.
+func ParseCompression(name string) (Compression, error) {
+	for _, c := range Compressions {
+		if c.String() == name {
+			return c, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown compression %q (want none, zlib or heatshrink)", name)
+}
+
+// DownloadOptions configures Download.
+type DownloadOptions struct {
+	// DataUUID is the characteristic the board notifies frames on.
+	DataUUID string
+	// ControlUUID is the characteristic the request is written to.
+	ControlUUID string
+	// Accept lists the compressions the board may use; by default, all.
+	// Uncompressed transfers are always accepted.
+	Accept []Compression
+	// Window is how many frames the board sends before waiting for an
+	// ack, at most 255; 16 by default.
+	Window int
+	// Progress, if set, is called as frames arrive with the transfer
+	// bytes received in order.
+	Progress func(received, total int)
+	// Checkpoint, if set, is called with the download's state whenever
+	// more of it has arrived in order, to be saved for Resume.
+	Checkpoint func(DownloadCheckpoint)
+	// Resume continues the download a Checkpoint was saved from. If the
+	// board's header no longer matches, its data has changed and the
+	// download starts over.
+	Resume *DownloadCheckpoint
+}
+
+// DownloadCheckpoint is how far a download got.
+type DownloadCheckpoint struct {
+	// Header is the board's header frame, identifying the transfer.
+	Header []byte
+	// Next is the first frame not received.
+	Next int
+	// Payload is the transfer bytes of the frames before Next. Checkpoint
+	// passes the download's own buffer, which must not be modified.
+	Payload []byte
+}
+
+// DownloadResult is a completed download.
+type DownloadResult struct {
+	// Data is the uncompressed data, its length and CRC32 verified.
+	Data        []byte
+	Compression Compression
+	// Transferred is how many bytes crossed the link, after the header.
+	Transferred int
+	// Resent counts frames the board sent again after an ack reported
+	// them missing.
+	Resent int
+	// Resumed is how many transfer bytes came from opts.Resume.
+	Resumed int
+}
+
+// Download fetches the data the board has buffered from its bulk
+// transfer characteristics, decompressing and verifying it. Lost frames
+// are acknowledged as missing and resent; Download stops when the board
+// sends nothing new for downloadStall or when ctx is done.
+func (c *Client) Download(ctx context.Context, opts DownloadOptions) (DownloadResult, error) {
+	data, err := c.Characteristic(opts.DataUUID)
+	if err != nil {
+		return DownloadResult{}, err
+	}
+	control, err := c.Characteristic(opts.ControlUUID)
+	if err != nil {
+		return DownloadResult{}, err
+	}
+	accept := opts.Accept
+	if accept == nil {
+		accept = Compressions
+	}
+	mask := byte(1 << CompressionNone)
+	for _, a := range accept {
+		mask |= 1 << a
+	}
+	window := cmp.Or(opts.Window, downloadWindow)
+	if window < 1 || window > 255 {
+		return DownloadResult{}, fmt.Errorf("download window of %d frames, want 1 to 255", window)
+	}
+
+	// The board sends a window as fast as the link allows, so frames are
+	// queued here rather than in a subscription, which drops the oldest
+	// when its handler falls behind.
+	arrived := make(chan struct{}, 1)
+	var (
+		queueMu sync.Mutex
+		queue   [][]byte
+	)
+	notified := func(buf []byte) {
+		queueMu.Lock()
+		queue = append(queue, append([]byte(nil), buf...))
+		queueMu.Unlock()
+		select {
+		case arrived <- struct{}{}:
+		default:
+		}
+	}
+	c.mu.Lock()
+	err = data.EnableNotifications(notified)
+	c.mu.Unlock()
+	if err != nil {
+		return DownloadResult{}, err
+	}
+	defer func() {
+		c.mu.Lock()
+		data.EnableNotifications(nil)
+		c.mu.Unlock()
+	}()
+	r := newDownloadReceiver(opts.Resume)
+	request := []byte{downloadStart, mask, byte(window)}
+	if opts.Resume != nil {
+		request[0] = downloadResume
+		request = binary.LittleEndian.AppendUint16(request, uint16(opts.Resume.Next))
+	}
+	if err := c.write(ctx, control, request); err != nil {
+		return DownloadResult{}, fmt.Errorf("requesting download: %w", err)
+	}
+
+	acked := 0
+	ack := func(idle bool) error {
+		acked = r.next
+		if err := c.write(ctx, control, r.ack(idle)); err != nil {
+			return fmt.Errorf("acknowledging download: %w", err)
+		}
+		return nil
+	}
+	idle := time.NewTimer(downloadAckDelay)
+	defer idle.Stop()
+	lastNew := time.Now()
+	for !r.done() {
+		queueMu.Lock()
+		pending := queue
+		queue = nil
+		queueMu.Unlock()
+		if len(pending) == 0 {
+			select {
+			case <-ctx.Done():
+				return DownloadResult{}, ctx.Err()
+			case <-arrived:
+			case <-idle.C:
+				// Frames or the last ack were lost; say again what is
+				// missing.
+				switch {
+				case time.Since(lastNew) < downloadStall:
+				case r.total < 0:
+					return DownloadResult{}, errors.New("board sent no download header")
+				default:
+					return DownloadResult{}, fmt.Errorf("download stalled after %d of %d bytes", r.received, r.total)
+				}
+				if err := ack(true); err != nil {
+					return DownloadResult{}, err
+				}
+				idle.Reset(downloadAckDelay)
+			}
+			continue
+		}
+		before := r.received
+		for _, frame := range pending {
+			isNew, err := r.add(frame)
+			if errors.Is(err, errDownloadChanged) {
+				// Start over, leaving the frames already sent for the
+				// new transfer to use.
+				r = newDownloadReceiver(nil)
+				acked = 0
+				err = c.write(ctx, control, []byte{downloadStart, mask, byte(window)})
+			}
+			if err != nil {
+				return DownloadResult{}, err
+			}
+			if isNew {
+				lastNew = time.Now()
+			}
+		}
+		idle.Reset(downloadAckDelay)
+		if r.received != before || r.next == 1 {
+			if opts.Progress != nil && r.total > 0 {
+				opts.Progress(min(r.received, r.total), r.total)
+			}
+			if opts.Checkpoint != nil && r.header != nil {
+				opts.Checkpoint(DownloadCheckpoint{Header: r.header, Next: r.next, Payload: r.payload})
+			}
+		}
+		if !r.done() && r.next >= acked+window {
+			if err := ack(false); err != nil {
+				return DownloadResult{}, err
+			}
+		}
+	}
+	// The data is complete; a board that misses this ack times the
+	// transfer out on its own.
+	ack(false)
+
+	header := r.header
+	if r.received > r.total {
+		return DownloadResult{}, fmt.Errorf("download sent %d bytes, header says %d", r.received, r.total)
+	}
+	compression := Compression(header[0])
+	if mask&(1<<compression) == 0 {
+		return DownloadResult{}, fmt.Errorf("board used %v compression, which wasn't accepted", compression)
+	}
+	size := int(binary.LittleEndian.Uint32(header[3:]))
+	out, err := decompress(compression, header[1], header[2], r.payload, size)
+	if err != nil {
+		return DownloadResult{}, err
+	}
+	if len(out) != size {
+		return DownloadResult{}, fmt.Errorf("download is %d bytes, header says %d", len(out), size)
+	}
+	if crc32.ChecksumIEEE(out) != binary.LittleEndian.Uint32(header[7:]) {
+		return DownloadResult{}, errors.New("download CRC32 mismatch")
+	}
+	return DownloadResult{Data: out, Compression: compression, Transferred: r.received - r.resumed, Resent: r.resent, Resumed: r.resumed}, nil
+}
+
+// downloadReceiver reassembles a download's frames in order.
+type downloadReceiver struct {
+	// frames holds frames received past next, by number.
+	frames map[int][]byte
+	// next is the first frame not yet received.
+	next   int
+	header []byte
+	// payload is the transfer bytes of the frames before next, received
+	// of the total the header gives (-1 until it arrives).
+	payload  []byte
+	received int
+	total    int
+	// missing holds the frames acks have reported lost, to count those
+	// resent.
+	missing map[int]bool
+	resent  int
+	// resume is the checkpoint to continue from once the header matches,
+	// and resumed the bytes taken from it.
+	resume  *DownloadCheckpoint
+	resumed int
+}
+
+// errDownloadChanged is returned by add when the header doesn't match
+// the checkpoint being resumed.
+var errDownloadChanged = errors.New("board's download changed since the checkpoint")
+
+func newDownloadReceiver(resume *DownloadCheckpoint) *downloadReceiver {
+	return &downloadReceiver{frames: map[int][]byte{}, missing: map[int]bool{}, total: -1, resume: resume}
+}
+
+// add stores a frame, reporting whether it was one not seen before.
+func (r *downloadReceiver) add(frame []byte) (bool, error) {
+	if len(frame) < otaSeqSize {
+		return false, fmt.Errorf("download frame of %d bytes has no frame number", len(frame))
+	}
+	n := int(binary.LittleEndian.Uint16(frame))
+	if _, ok := r.frames[n]; ok || n < r.next {
+		return false, nil
+	}
+	if r.missing[n] {
+		delete(r.missing, n)
+		r.resent++
+	}
+	r.frames[n] = frame[otaSeqSize:]
+	for {
+		p, ok := r.frames[r.next]
+		if !ok {
+			return true, nil
+		}
+		delete(r.frames, r.next)
+		if r.next == 0 {
+			if len(p) < downloadHeaderSize {
+				return false, fmt.Errorf("download header of %d bytes, want %d", len(p), downloadHeaderSize)
+			}
+			r.header = p
+			r.total = int(binary.LittleEndian.Uint32(p[11:]))
+			if res := r.resume; res != nil && res.Next > 1 {
+				if !bytes.Equal(p, res.Header) {
+					return false, errDownloadChanged
+				}
+				r.payload = append([]byte(nil), res.Payload...)
+				r.received, r.resumed = len(res.Payload), len(res.Payload)
+				for n := range r.frames {
+					if n < res.Next {
+						delete(r.frames, n)
+					}
+				}
+				r.next = res.Next
+				continue
+			}
+		} else {
+			r.payload = append(r.payload, p...)
+			r.received += len(p)
+		}
+		r.next++
+	}
+}
+
+func (r *downloadReceiver) done() bool {
+	return r.total >= 0 && r.received >= r.total
+}
+
+// ack returns an ack of the frames received, noting those it reports
+// missing: gaps before frames received and, if frames stopped arriving,
+// the next one.
+func (r *downloadReceiver) ack(idle bool) []byte {
+	received := make([]int, 0, len(r.frames))
+	for n := range r.frames {
+		received = append(received, n)
+	}
+	slices.Sort(received)
+	var ranges [][2]int
+	for _, n := range received {
+		if len(ranges) > 0 && ranges[len(ranges)-1][1] == n {
+			ranges[len(ranges)-1][1] = n + 1
+			continue
+		}
+		if len(ranges) == downloadMaxRanges {
+			break
+		}
+		ranges = append(ranges, [2]int{n, n + 1})
+	}
+	gap := r.next
+	for _, rg := range ranges {
+		for n := gap; n < rg[0]; n++ {
+			r.missing[n] = true
+		}
+		gap = rg[1]
+	}
+	if idle && !r.done() {
+		r.missing[r.next] = true
+	}
+
+	p := binary.LittleEndian.AppendUint16([]byte{downloadAck}, uint16(r.next))
+	p = append(p, byte(len(ranges)))
+	for _, rg := range ranges {
+		p = binary.LittleEndian.AppendUint16(p, uint16(rg[0]))
+		p = binary.LittleEndian.AppendUint16(p, uint16(rg[1]))
+	}
+	return p
+}
+
+// decompress expands a transfer to at most size bytes, so a corrupt one
+// can't exhaust memory.
+func decompress(c Compression, window, lookahead uint8, payload []byte, size int) ([]byte, error) {
+	switch c {
+	case CompressionNone:
+		return payload, nil
+	case CompressionZlib:
+		r, err := zlib.NewReader(bytes.NewReader(payload))
+		if err != nil {
+			return nil, fmt.Errorf("zlib: %w", err)
+		}
+		out, err := io.ReadAll(io.LimitReader(r, int64(size)+1))
+		if err == nil {
+			err = r.Close()
+		}
+		if err != nil {
+			return nil, fmt.Errorf("zlib: %w", err)
+		}
+		return out, nil
+	case CompressionHeatshrink:
+		return heatshrinkDecode(payload, window, lookahead, size)
+	}
+	return nil, fmt.Errorf("unknown compression %d", uint8(c))
+}
@@ -0,0 +1,145 @@
+package esp32_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"bluetooth/esp32"
+	"bluetooth/esp32/mock"
+)
+
+const (
+	downloadDataUUID    = "5a1d0000-0000-4000-8000-000000000011"
+	downloadControlUUID = "5a1d0000-0000-4000-8000-000000000012"
+)
+
+func TestDownload(t *testing.T) {
+	defer verifyNoLeaks(t)
+
+	// A capture compresses well: the same columns over and over.
+	var capture bytes.Buffer
+	for i := range 500 {
+		fmt.Fprintf(&capture, "%d,35,%d\n", i, 1200+i%7)
+	}
+	data := capture.Bytes()
+
+	for _, tc := range []struct {
+		name   string
+		sent   esp32.Compression
+		accept []esp32.Compression
+		want   esp32.Compression
+		// drop are frames lost the first time they are sent.
+		drop []int
+	}{
+		{"none", esp32.CompressionNone, nil, esp32.CompressionNone, nil},
+		{"zlib", esp32.CompressionZlib, nil, esp32.CompressionZlib, nil},
+		{"heatshrink", esp32.CompressionHeatshrink, nil, esp32.CompressionHeatshrink, nil},
+		{"not accepted", esp32.CompressionZlib, []esp32.Compression{esp32.CompressionHeatshrink}, esp32.CompressionNone, nil},
+		// The header, a frame mid-window and one in the second window.
+		{"lost frames", esp32.CompressionNone, nil, esp32.CompressionNone, []int{0, 5, 17}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			board := mock.NewBoard("esp32-test", "AA:BB:CC:DD:EE:01")
+			board.EnableDownload(downloadDataUUID, downloadControlUUID)
+			board.SetDownload(data, tc.sent)
+			board.DropDownloadFrames(tc.drop...)
+			client := connectBoard(t, board)
+			defer client.Disconnect()
+
+			var last, total int
+			result, err := client.Download(context.Background(), esp32.DownloadOptions{
+				DataUUID:    downloadDataUUID,
+				ControlUUID: downloadControlUUID,
+				Accept:      tc.accept,
+				Progress:    func(received, n int) { last, total = received, n },
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(result.Data, data) {
+				t.Error("downloaded data differs from the board's")
+			}
+			if result.Compression != tc.want {
+				t.Errorf("compression = %v, want %v", result.Compression, tc.want)
+			}
+			if result.Resent != len(tc.drop) {
+				t.Errorf("%d frame(s) resent, want %d", result.Resent, len(tc.drop))
+			}
+			if last != total || total != result.Transferred {
+				t.Errorf("progress ended at %d of %d, want %d", last, total, result.Transferred)
+			}
+			if compressed := tc.want != esp32.CompressionNone; compressed && result.Transferred*2 > len(data) {
+				t.Errorf("%v transferred %d of %d bytes, want under half", tc.want, result.Transferred, len(data))
+			}
+		})
+	}
+}
+
+func TestDownloadWithoutData(t *testing.T) {
+	defer verifyNoLeaks(t)
+
+	client := connectBoard(t, mock.NewBoard("esp32-test", "AA:BB:CC:DD:EE:01"))
+	defer client.Disconnect()
+	_, err := client.Download(context.Background(), esp32.DownloadOptions{
+		DataUUID:    downloadDataUUID,
+		ControlUUID: downloadControlUUID,
+	})
+	if err == nil {
+		t.Fatal("Download succeeded on a board without bulk transfer characteristics")
+	}
+}
+
+func TestResumeDownload(t *testing.T) {
+	defer verifyNoLeaks(t)
+
+	data := make([]byte, 5000)
+	rand.New(rand.NewSource(1)).Read(data)
+	board := mock.NewBoard("esp32-test", "AA:BB:CC:DD:EE:01")
+	board.EnableDownload(downloadDataUUID, downloadControlUUID)
+	board.SetDownload(data, esp32.CompressionNone)
+	opts := esp32.DownloadOptions{DataUUID: downloadDataUUID, ControlUUID: downloadControlUUID}
+
+	// The link drops partway through the second window.
+	board.DisconnectDuringDownload(20)
+	var saved esp32.DownloadCheckpoint
+	opts.Checkpoint = func(c esp32.DownloadCheckpoint) {
+		saved = esp32.DownloadCheckpoint{Header: c.Header, Next: c.Next, Payload: append([]byte(nil), c.Payload...)}
+	}
+	client := connectBoard(t, board)
+	if _, err := client.Download(context.Background(), opts); err == nil {
+		t.Fatal("Download succeeded over a dropped link")
+	}
+	client.Disconnect()
+	if saved.Next != 20 || len(saved.Payload) != 19*242 {
+		t.Fatalf("checkpoint at frame %d with %d bytes, want frame 20 with %d", saved.Next, len(saved.Payload), 19*242)
+	}
+
+	client = connectBoard(t, board)
+	defer client.Disconnect()
+	resume, kept := saved, len(saved.Payload)
+	opts.Resume = &resume
+	result, err := client.Download(context.Background(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(result.Data, data) {
+		t.Error("resumed download differs from the board's data")
+	}
+	if result.Resumed != kept || result.Transferred != len(data)-kept {
+		t.Errorf("resumed %d and transferred %d bytes, want %d and %d", result.Resumed, result.Transferred, kept, len(data)-kept)
+	}
+
+	// Resuming after the board's data changed starts over.
+	changed := bytes.Repeat([]byte{7}, 6000)
+	board.SetDownload(changed, esp32.CompressionNone)
+	result, err = client.Download(context.Background(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(result.Data, changed) || result.Resumed != 0 {
+		t.Errorf("download after the data changed resumed %d bytes; data matches: %v", result.Resumed, bytes.Equal(result.Data, changed))
+	}
+}
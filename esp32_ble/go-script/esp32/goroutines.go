@@ -0,0 +1,59 @@
+package esp32
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+// running counts goroutines started by this package that haven't returned.
+var running atomic.Int64
+
+// Goroutines returns how many goroutines this package has started that
+// have not yet returned. Long-running callers can check it returns to zero
+// once every scan has finished and every client has disconnected.
+func Goroutines() int {
+	return int(running.Load())
+}
+
+// goTracked runs fn in a goroutine counted by Goroutines.
+func goTracked(fn func()) {
+	running.Add(1)
+	go func() {
+		defer running.Add(-1)
+		fn()
+	}()
+}
+
+// await runs op on a tracked goroutine and waits for it or for ctx. If ctx
+// is done first await returns its error straight away, and abandon, if
+// set, is called with op's result once op does return, so whatever it
+// produced (a connection, say) can still be released.
+func await[T any](ctx context.Context, op func() (T, error), abandon func(T)) (T, error) {
+	var zero T
+	if err := ctx.Err(); err != nil {
+		return zero, err
+	}
+	type result struct {
+		value T
+		err   error
+	}
+	done := make(chan result)
+	abandoned := make(chan struct{})
+	goTracked(func() {
+		value, err := op()
+		select {
+		case done <- result{value, err}:
+		case <-abandoned:
+			if abandon != nil && err == nil {
+				abandon(value)
+			}
+		}
+	})
+	select {
+	case r := <-done:
+		return r.value, r.err
+	case <-ctx.Done():
+		close(abandoned)
+		return zero, ctx.Err()
+	}
+}
@@ -0,0 +1,155 @@
+//go:build linux
+
+package esp32
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// HCI constants from the kernel's hci.h and hci_sock.h.
+const (
+	hciCommandPkt      = 0x01
+	hciEventPkt        = 0x04
+	hciEvtCmdComplete  = 0x0e
+	hciEvtCmdStatus    = 0x0f
+	hciEvtLEMeta       = 0x3e
+	hciLELink          = 0x80
+	solHCI             = 0
+	hciFilterOpt       = 2
+	hciGetConnInfo     = 0x800448d5 // _IOR('H', 213, int)
+	hciEventTimeout    = 2 * time.Second
+	hciMaxEventPayload = 258
+)
+
+// hciSocket is a raw HCI socket on one adapter, for the few link-level
+// commands BlueZ doesn't expose over D-Bus. Raw sockets need CAP_NET_RAW.
+type hciSocket struct {
+	fd int
+}
+
+// openHCI opens a raw socket on the BlueZ adapter named id (e.g. "hci0").
+func openHCI(id string) (*hciSocket, error) {
+	dev, err := strconv.Atoi(strings.TrimPrefix(id, "hci"))
+	if err != nil {
+		return nil, fmt.Errorf("adapter %q: %w", id, err)
+	}
+	fd, err := unix.Socket(unix.AF_BLUETOOTH, unix.SOCK_RAW|unix.SOCK_CLOEXEC, unix.BTPROTO_HCI)
+	if err != nil {
+		return nil, fmt.Errorf("opening HCI socket: %w", err)
+	}
+	if err := unix.Bind(fd, &unix.SockaddrHCI{Dev: uint16(dev), Channel: unix.HCI_CHANNEL_RAW}); err != nil {
+		unix.Close(fd)
+		return nil, fmt.Errorf("binding HCI socket: %w", err)
+	}
+	tv := unix.NsecToTimeval(int64(hciEventTimeout))
+	if err := unix.SetsockoptTimeval(fd, unix.SOL_SOCKET, unix.SO_RCVTIMEO, &tv); err != nil {
+		unix.Close(fd)
+		return nil, err
+	}
+	return &hciSocket{fd: fd}, nil
+}
+
+func (s *hciSocket) Close() error {
+	return unix.Close(s.fd)
+}
+
+// connHandle returns the handle of the LE connection to address.
+func (s *hciSocket) connHandle(address string) (uint16, error) {
+	addr, err := parseBDAddr(address)
+	if err != nil {
+		return 0, err
+	}
+	// struct hci_conn_info_req: bdaddr, type, then one struct
+	// hci_conn_info (handle first) at offset 8.
+	var req [24]byte
+	copy(req[:6], addr[:])
+	req[6] = hciLELink
+	if _, _, errno := unix.Syscall(unix.SYS_IOCTL, uintptr(s.fd), hciGetConnInfo, uintptr(unsafe.Pointer(&req[0]))); errno != 0 {
+		return 0, fmt.Errorf("looking up connection to %s: %w", address, errno)
+	}
+	return binary.LittleEndian.Uint16(req[8:]), nil
+}
+
+// send issues a command, letting through only the events given and, for
+// command complete and status events, only those for this opcode.
+func (s *hciSocket) send(opcode uint16, params []byte, events ...byte) error {
+	// struct hci_filter: type mask, 64-bit event mask, opcode.
+	var filter [16]byte
+	binary.LittleEndian.PutUint32(filter[0:], 1<<hciEventPkt)
+	for _, e := range events {
+		binary.LittleEndian.PutUint32(filter[4+e/32*4:], binary.LittleEndian.Uint32(filter[4+e/32*4:])|1<<(e%32))
+	}
+	binary.LittleEndian.PutUint16(filter[12:], opcode)
+	if err := unix.SetsockoptString(s.fd, solHCI, hciFilterOpt, string(filter[:])); err != nil {
+		return fmt.Errorf("setting HCI filter: %w", err)
+	}
+
+	cmd := append([]byte{hciCommandPkt, byte(opcode), byte(opcode >> 8), byte(len(params))}, params...)
+	if _, err := unix.Write(s.fd, cmd); err != nil {
+		return fmt.Errorf("sending HCI command %#04x: %w", opcode, err)
+	}
+	return nil
+}
+
+// event reads the next event, returning its code and parameters.
+func (s *hciSocket) event() (byte, []byte, error) {
+	buf := make([]byte, 1+hciMaxEventPayload)
+	for {
+		n, err := unix.Read(s.fd, buf)
+		if err != nil {
+			return 0, nil, fmt.Errorf("reading HCI event: %w", err)
+		}
+		// type, event code, parameter length, parameters
+		if n < 3 || buf[0] != hciEventPkt || int(buf[2]) > n-3 {
+			continue
+		}
+		return buf[1], buf[3 : 3+int(buf[2])], nil
+	}
+}
+
+// command issues a command that completes with a command complete event
+// and returns its return parameters after the status byte.
+func (s *hciSocket) command(opcode uint16, params []byte) ([]byte, error) {
+	if err := s.send(opcode, params, hciEvtCmdComplete); err != nil {
+		return nil, err
+	}
+	for {
+		code, p, err := s.event()
+		if err != nil {
+			return nil, err
+		}
+		// num commands, opcode, status, return parameters
+		if code != hciEvtCmdComplete || len(p) < 4 || binary.LittleEndian.Uint16(p[1:]) != opcode {
+			continue
+		}
+		if p[3] != 0 {
+			return nil, fmt.Errorf("HCI command %#04x failed with status %#x", opcode, p[3])
+		}
+		return p[4:], nil
+	}
+}
+
+// parseBDAddr converts "AA:BB:CC:DD:EE:FF" to the little-endian bdaddr_t
+// layout the kernel uses.
+func parseBDAddr(s string) ([6]byte, error) {
+	var addr [6]byte
+	b, err := hex.DecodeString(strings.ReplaceAll(s, ":", ""))
+	if err != nil || len(b) != 6 {
+		return addr, fmt.Errorf("invalid Bluetooth address %q", s)
+	}
+	for i := range addr {
+		addr[i] = b[5-i]
+	}
+	return addr, nil
+}
+
+var errShortReply = errors.New("HCI reply too short")
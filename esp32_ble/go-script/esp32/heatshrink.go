@@ -0,0 +1,57 @@
+package esp32
+
+import "fmt"
+
+// heatshrinkDecode expands heatshrink's LZSS stream: MSB-first bits where
+// a 1 is followed by an 8-bit literal and a 0 by a back-reference of
+// window bits (offset-1) and lookahead bits (length-1) into the output so
+// far. It stops after size bytes; the encoder pads the last byte with
+// zero bits.
+func heatshrinkDecode(src []byte, window, lookahead uint8, size int) ([]byte, error) {
+	if window < 4 || window > 15 || lookahead < 3 || lookahead >= window {
+		return nil, fmt.Errorf("heatshrink: unsupported window %d and lookahead %d bits", window, lookahead)
+	}
+	var bit int
+	read := func(n uint8) (int, bool) {
+		v := 0
+		for range n {
+			if bit >= len(src)*8 {
+				return 0, false
+			}
+			v = v<<1 | int(src[bit/8]>>(7-bit%8)&1)
+			bit++
+		}
+		return v, true
+	}
+	out := make([]byte, 0, size)
+	for len(out) < size {
+		tag, ok := read(1)
+		if !ok {
+			break
+		}
+		if tag == 1 {
+			b, ok := read(8)
+			if !ok {
+				break
+			}
+			out = append(out, byte(b))
+			continue
+		}
+		index, ok := read(window)
+		if !ok {
+			break
+		}
+		count, ok := read(lookahead)
+		if !ok {
+			break
+		}
+		offset := index + 1
+		if offset > len(out) {
+			return nil, fmt.Errorf("heatshrink: back-reference %d bytes before the start", offset)
+		}
+		for range min(count+1, size-len(out)) {
+			out = append(out, out[len(out)-offset])
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,76 @@
+package esp32
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"unicode"
+	"unicode/utf8"
+)
+
+// SerialNumberUUID is the Serial Number String characteristic of the
+// standard Device Information service, which firmware that reports a
+// serial number commonly uses.
+const SerialNumberUUID = "00002a25-0000-1000-8000-00805f9b34fb"
+
+// Identifier works out a connected board's identity: what its readings,
+// logs and metrics are kept under. A board's address changes when its
+// radio module is replaced or, with a random address, when it rotates;
+// an identity the firmware reports doesn't, so its history carries on.
+type Identifier interface {
+	Identify(ctx context.Context, c *Client) (string, error)
+}
+
+// IdentifierFunc adapts a function to an Identifier.
+type IdentifierFunc func(ctx context.Context, c *Client) (string, error)
+
+func (f IdentifierFunc) Identify(ctx context.Context, c *Client) (string, error) {
+	return f(ctx, c)
+}
+
+// AddressIdentifier identifies boards by their address, the default.
+var AddressIdentifier Identifier = IdentifierFunc(func(ctx context.Context, c *Client) (string, error) {
+	return c.Address, nil
+})
+
+// SerialNumberIdentifier identifies boards by the serial number or UUID
+// their firmware reports in the characteristic with the given UUID: as
+// text if it is printable, with padding NULs and spaces trimmed, and
+// otherwise in hex.
+func SerialNumberIdentifier(uuid string) Identifier {
+	return IdentifierFunc(func(ctx context.Context, c *Client) (string, error) {
+		value, err := c.ReadRaw(ctx, uuid)
+		if err != nil {
+			return "", fmt.Errorf("failed to read serial number: %w", err)
+		}
+		return serialNumber(value)
+	})
+}
+
+// errEmptySerialNumber is returned for a serial number characteristic
+// with nothing in it, as an unprovisioned board's may have.
+var errEmptySerialNumber = errors.New("the board reports an empty serial number")
+
+func serialNumber(value []byte) (string, error) {
+	text := bytes.Trim(value, "\x00 ")
+	if len(text) == 0 {
+		return "", errEmptySerialNumber
+	}
+	if utf8.Valid(text) && bytes.IndexFunc(text, func(r rune) bool { return !unicode.IsPrint(r) }) < 0 {
+		return string(text), nil
+	}
+	return hex.EncodeToString(value), nil
+}
+
+// Identify sets the client's ID with id. If that fails the ID stays the
+// board's address, as it is after connecting.
+func (c *Client) Identify(ctx context.Context, id Identifier) error {
+	identity, err := id.Identify(ctx, c)
+	if err != nil {
+		return err
+	}
+	c.ID = identity
+	return nil
+}
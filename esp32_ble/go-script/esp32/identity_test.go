@@ -0,0 +1,103 @@
+package esp32_test
+
+import (
+	"context"
+	"testing"
+
+	"bluetooth/esp32"
+	"bluetooth/esp32/mock"
+)
+
+func TestIdentify(t *testing.T) {
+	defer verifyNoLeaks(t)
+
+	for _, tc := range []struct {
+		name   string
+		serial []byte
+		want   string
+	}{
+		{"text", []byte("SN-0042\x00\x00"), "SN-0042"},
+		{"padded", []byte("  A1B2C3 "), "A1B2C3"},
+		{"binary", []byte{0x12, 0x34, 0x00, 0xff}, "123400ff"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			board := mock.NewBoard("esp32-test", "AA:BB:CC:DD:EE:01")
+			board.EnableSerialNumber(esp32.SerialNumberUUID, tc.serial)
+			client := connectBoard(t, board)
+			defer client.Disconnect()
+
+			if client.ID != board.Address {
+				t.Errorf("ID before Identify = %q, want the address", client.ID)
+			}
+			profile := esp32.Profile{SerialNumberUUID: esp32.SerialNumberUUID}
+			if err := client.Identify(context.Background(), profile.Identifier()); err != nil {
+				t.Fatal(err)
+			}
+			if client.ID != tc.want {
+				t.Errorf("ID = %q, want %q", client.ID, tc.want)
+			}
+		})
+	}
+}
+
+func TestIdentifyFailure(t *testing.T) {
+	defer verifyNoLeaks(t)
+
+	for _, tc := range []struct {
+		name   string
+		enable bool
+	}{
+		{"no characteristic", false},
+		{"empty", true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			board := mock.NewBoard("esp32-test", "AA:BB:CC:DD:EE:01")
+			if tc.enable {
+				board.EnableSerialNumber(esp32.SerialNumberUUID, make([]byte, 16))
+			}
+			client := connectBoard(t, board)
+			defer client.Disconnect()
+
+			if err := client.Identify(context.Background(), esp32.SerialNumberIdentifier(esp32.SerialNumberUUID)); err == nil {
+				t.Fatal("Identify succeeded")
+			}
+			if client.ID != board.Address {
+				t.Errorf("ID = %q, want the address it kept", client.ID)
+			}
+		})
+	}
+}
+
+func TestIdentitySurvivesNewAddress(t *testing.T) {
+	defer verifyNoLeaks(t)
+
+	board := mock.NewBoard("esp32-test", "AA:BB:CC:DD:EE:01")
+	board.EnableSerialNumber(esp32.SerialNumberUUID, []byte("SN-0042"))
+	board.SetADC(35, 1234)
+	profile := esp32.Profile{SerialNumberUUID: esp32.SerialNumberUUID}
+
+	var readings []esp32.Reading
+	for _, address := range []string{"AA:BB:CC:DD:EE:01", "AA:BB:CC:DD:EE:99"} {
+		board.SetAddress(address)
+		client := connectBoard(t, board)
+		client.SetProfile(profile)
+		if err := client.Identify(context.Background(), profile.Identifier()); err != nil {
+			t.Fatal(err)
+		}
+		adc, err := client.ReadADC(context.Background())
+		client.Disconnect()
+		if err != nil {
+			t.Fatal(err)
+		}
+		readings = append(readings, adc[0])
+	}
+
+	if readings[0].Address == readings[1].Address {
+		t.Fatalf("both readings are from %s", readings[0].Address)
+	}
+	for _, r := range readings {
+		if r.ID != "SN-0042" {
+			t.Errorf("reading from %s has ID %q, want SN-0042", r.Address, r.ID)
+		}
+	}
+}
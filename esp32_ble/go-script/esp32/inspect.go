@@ -0,0 +1,91 @@
+package esp32
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"slices"
+)
+
+// Inspection is a board's whole GATT database, as walked by Inspect.
+type Inspection struct {
+	Name     string             `json:"name"`
+	Address  string             `json:"address"`
+	Services []InspectedService `json:"services"`
+	// PropertiesErr is why properties are unknown, if they are:
+	// ErrDescribeUnsupported on platforms that can't report them.
+	PropertiesErr error `json:"-"`
+}
+
+// InspectedService is one service of an Inspection.
+type InspectedService struct {
+	UUID            string                    `json:"uuid"`
+	Characteristics []InspectedCharacteristic `json:"characteristics"`
+	// Error is why its characteristics couldn't be discovered.
+	Error string `json:"error,omitempty"`
+}
+
+// InspectedCharacteristic is one characteristic of an Inspection.
+type InspectedCharacteristic struct {
+	UUID string `json:"uuid"`
+	// Properties is nil when the platform can't report them.
+	Properties  []string `json:"properties"`
+	Descriptors []string `json:"descriptors,omitempty"`
+	CCCD        bool     `json:"cccd"`
+	// Value is what reading it returned; it is nil if it wasn't read or
+	// the read failed with ReadError. It is hex in JSON.
+	Value     []byte `json:"-"`
+	ReadError string `json:"read_error,omitempty"`
+}
+
+func (c InspectedCharacteristic) MarshalJSON() ([]byte, error) {
+	type plain InspectedCharacteristic
+	return json.Marshal(struct {
+		plain
+		Value string `json:"value_hex,omitempty"`
+	}{plain(c), hex.EncodeToString(c.Value)})
+}
+
+// Inspect walks the board's GATT database, describing each
+// characteristic's properties and descriptors where the platform can
+// and, if read is set, reading its value. Only characteristics known to
+// be readable are read, or all of them if properties are unknown: GATT
+// reads have no side effects. Failed reads are recorded rather than
+// returned; the error is ctx's if it is done first.
+func (c *Client) Inspect(ctx context.Context, read bool) (Inspection, error) {
+	result := Inspection{Name: c.Name, Address: c.Address}
+	details := map[string]CharacteristicInfo{}
+	infos, err := c.Describe(ctx)
+	if err != nil {
+		result.PropertiesErr = err
+	}
+	for _, info := range infos {
+		details[info.ServiceUUID+"/"+info.UUID] = info
+	}
+
+	for _, service := range c.Services {
+		is := InspectedService{UUID: service.UUID}
+		if service.Err != nil {
+			is.Error = service.Err.Error()
+		}
+		for _, uuid := range service.Characteristics {
+			ic := InspectedCharacteristic{UUID: uuid}
+			if info, ok := details[service.UUID+"/"+uuid]; ok {
+				ic.Properties = info.Properties
+				ic.Descriptors = info.Descriptors
+				ic.CCCD = slices.Contains(info.Descriptors, CCCDUUID)
+			}
+			if read && (ic.Properties == nil || slices.Contains(ic.Properties, "read")) {
+				value, err := c.ReadRaw(ctx, uuid)
+				if err != nil {
+					ic.ReadError = err.Error()
+				} else {
+					ic.Value = value
+				}
+			}
+			is.Characteristics = append(is.Characteristics, ic)
+		}
+		result.Services = append(result.Services, is)
+	}
+	return result, ctx.Err()
+}
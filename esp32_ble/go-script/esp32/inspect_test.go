@@ -0,0 +1,83 @@
+package esp32_test
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"bluetooth/esp32"
+	"bluetooth/esp32/mock"
+)
+
+// connectMock connects to board through the emulator, disconnecting when
+// the test ends.
+func connectMock(t *testing.T, board *mock.Board) *esp32.Client {
+	t.Helper()
+	adapter := mock.NewAdapter(board)
+	result, err := esp32.FindDevice(context.Background(), adapter, board.Name, time.Second, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := esp32.Connect(context.Background(), adapter, result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Disconnect() })
+	return client
+}
+
+func TestInspect(t *testing.T) {
+	board := mock.NewBoard("esp32-test", "AA:BB:CC:DD:EE:01")
+	board.SetADC(35, 1234)
+	client := connectMock(t, board)
+
+	inspection, err := client.Inspect(context.Background(), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if inspection.PropertiesErr != nil || len(inspection.Services) != 1 {
+		t.Fatalf("Inspect = %+v", inspection)
+	}
+	adc := inspection.Services[0].Characteristics[1]
+	if adc.UUID != esp32.DefaultProfile().ADCOutputUUID || !adc.CCCD || len(adc.Value) == 0 {
+		t.Errorf("ADC characteristic = %+v", adc)
+	}
+
+	data, err := json.Marshal(adc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"value_hex":"02`) {
+		t.Errorf("JSON = %s, want the value in hex", data)
+	}
+
+	unread, err := client.Inspect(context.Background(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := unread.Services[0].Characteristics[1].Value; v != nil {
+		t.Errorf("Inspect without reads read %x", v)
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	board := mock.NewBoard("esp32-test", "AA:BB:CC:DD:EE:01")
+	board.SetADC(35, 1234)
+	board.SetPin(14, 1)
+	client := connectMock(t, board)
+
+	snapshot, err := client.Snapshot(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if snapshot.Device != "esp32-test" || len(snapshot.ADC) != 2 || snapshot.ADC[0].Value != 1234 {
+		t.Errorf("Snapshot = %+v", snapshot)
+	}
+	for _, r := range snapshot.Pins {
+		if r.Pin == 14 && (r.Value != 1 || r.Address != board.Address) {
+			t.Errorf("pin 14 = %+v", r)
+		}
+	}
+}
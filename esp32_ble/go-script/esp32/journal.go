@@ -0,0 +1,75 @@
+package esp32
+
+import (
+	"slices"
+	"sync"
+)
+
+// JournalOptions configures which readings a Journal keeps.
+type JournalOptions struct {
+	// Pins limits the journal to these pins; empty means every pin.
+	Pins []uint8
+	// Deadband is how far a value must move from the last recorded one
+	// to count as a change, to keep ADC noise out. 0 records any change.
+	Deadband int
+}
+
+// journalKey identifies one pin of one board, by ID so the journal
+// carries on across a change of address.
+type journalKey struct {
+	device, id string
+	pin        uint8
+}
+
+// Journal records only the readings whose value changed since the last
+// one recorded for the same board and pin, so slow-changing inputs like
+// door sensors can be kept for long periods cheaply. It is safe for
+// concurrent use.
+type Journal struct {
+	w    *CSVWriter
+	opts JournalOptions
+
+	mu   sync.Mutex
+	last map[journalKey]int
+}
+
+// NewJournal returns a journal appending changes to w. history is what w
+// already holds, e.g. from ReadCSV, so restarting doesn't record the
+// current values again.
+func NewJournal(w *CSVWriter, history []Reading, opts JournalOptions) *Journal {
+	j := &Journal{w: w, opts: opts, last: map[journalKey]int{}}
+	for _, r := range history {
+		j.last[journalKey{r.Device, r.ID, r.Pin}] = r.Value
+	}
+	return j
+}
+
+// Record appends the readings that changed, returning them. Readings of
+// other kinds than pin values are ignored.
+func (j *Journal) Record(readings []Reading) ([]Reading, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	var changed []Reading
+	for _, r := range readings {
+		if r.Kind != "" || len(j.opts.Pins) > 0 && !slices.Contains(j.opts.Pins, r.Pin) {
+			continue
+		}
+		key := journalKey{r.Device, r.ID, r.Pin}
+		if last, ok := j.last[key]; ok && abs(r.Value-last) <= j.opts.Deadband {
+			continue
+		}
+		j.last[key] = r.Value
+		changed = append(changed, r)
+	}
+	if len(changed) == 0 {
+		return nil, nil
+	}
+	return changed, j.w.Write(changed)
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
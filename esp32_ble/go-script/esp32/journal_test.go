@@ -0,0 +1,84 @@
+package esp32_test
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"bluetooth/esp32"
+)
+
+func TestJournal(t *testing.T) {
+	at := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	reading := func(pin uint8, value int) esp32.Reading {
+		return esp32.Reading{Time: at, Device: "esp32-test", Pin: pin, Value: value}
+	}
+	samples := []esp32.Reading{
+		reading(14, 0), reading(35, 1000),
+		reading(14, 0), reading(35, 1003),
+		reading(14, 1), reading(35, 1020),
+		reading(14, 1), reading(35, 1000),
+	}
+
+	for _, tc := range []struct {
+		name    string
+		history []esp32.Reading
+		opts    esp32.JournalOptions
+		want    []esp32.Reading
+	}{
+		{
+			name: "every change",
+			want: []esp32.Reading{
+				reading(14, 0), reading(35, 1000), reading(35, 1003),
+				reading(14, 1), reading(35, 1020), reading(35, 1000),
+			},
+		},
+		{
+			name: "deadband",
+			opts: esp32.JournalOptions{Deadband: 5},
+			want: []esp32.Reading{
+				reading(14, 0), reading(35, 1000), reading(35, 1020), reading(35, 1000),
+			},
+		},
+		{
+			name: "pin filter",
+			opts: esp32.JournalOptions{Pins: []uint8{14}},
+			want: []esp32.Reading{reading(14, 0), reading(14, 1)},
+		},
+		{
+			name:    "seeded from history",
+			history: []esp32.Reading{reading(14, 0)},
+			opts:    esp32.JournalOptions{Pins: []uint8{14}},
+			want:    []esp32.Reading{reading(14, 1)},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			w, err := esp32.NewCSVWriter(&buf, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			j := esp32.NewJournal(w, tc.history, tc.opts)
+			var recorded []esp32.Reading
+			for i := 0; i < len(samples); i += 2 {
+				changed, err := j.Record(samples[i : i+2])
+				if err != nil {
+					t.Fatal(err)
+				}
+				recorded = append(recorded, changed...)
+			}
+			got, err := esp32.ReadCSV(&buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != len(tc.want) || len(recorded) != len(tc.want) {
+				t.Fatalf("journal = %v (returned %v), want %v", got, recorded, tc.want)
+			}
+			for i := range got {
+				if got[i] != tc.want[i] {
+					t.Errorf("row %d = %+v, want %+v", i, got[i], tc.want[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,77 @@
+package esp32
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SeenDevice is one device heard during ListDevices, merged across its
+// advertisements.
+type SeenDevice struct {
+	ScanResult
+	// LastSeen is when its latest advertisement arrived; RSSI is from
+	// that one.
+	LastSeen time.Time
+	// Adverts is how many advertisements were heard.
+	Adverts int
+}
+
+// ListDevices scans for window and returns every device heard, one entry
+// per address, sorted by name and then address. Unnamed devices sort
+// last. If ctx is done first it returns what was heard so far with
+// ctx.Err().
+func ListDevices(ctx context.Context, a Adapter, window time.Duration) ([]SeenDevice, error) {
+	var (
+		mu   sync.Mutex
+		seen = map[string]*SeenDevice{}
+	)
+	_, err := FindDevices(ctx, a, nil, window, func(result ScanResult) {
+		mu.Lock()
+		defer mu.Unlock()
+		d, ok := seen[result.Address]
+		if !ok {
+			d = &SeenDevice{ScanResult: ScanResult{Address: result.Address}}
+			seen[result.Address] = d
+		}
+		// Names and services may only be in some advertisements (e.g.
+		// scan responses), so keep the last ones reported.
+		if result.Name != "" {
+			d.Name = result.Name
+		}
+		for _, uuid := range result.Services {
+			if !slices.Contains(d.Services, uuid) {
+				d.Services = append(d.Services, uuid)
+			}
+		}
+		d.RSSI = result.RSSI
+		d.LastSeen = time.Now()
+		d.Adverts++
+	})
+
+	mu.Lock()
+	defer mu.Unlock()
+	devices := make([]SeenDevice, 0, len(seen))
+	for _, d := range seen {
+		devices = append(devices, *d)
+	}
+	sort.Slice(devices, func(i, j int) bool {
+		a, b := devices[i], devices[j]
+		if (a.Name == "") != (b.Name == "") {
+			return a.Name != ""
+		}
+		if !strings.EqualFold(a.Name, b.Name) {
+			return strings.ToLower(a.Name) < strings.ToLower(b.Name)
+		}
+		return a.Address < b.Address
+	})
+	if errors.Is(err, ErrDeviceNotFound) {
+		// With no names to find, the scan always runs out the window.
+		err = nil
+	}
+	return devices, err
+}
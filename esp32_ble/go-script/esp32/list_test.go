@@ -0,0 +1,40 @@
+package esp32_test
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"bluetooth/esp32"
+	"bluetooth/esp32/mock"
+)
+
+func TestListDevices(t *testing.T) {
+	defer verifyNoLeaks(t)
+
+	zeta := mock.NewBoard("zeta", "AA:BB:CC:DD:EE:03")
+	alpha := mock.NewBoard("Alpha", "AA:BB:CC:DD:EE:02")
+	alpha.Services = []string{esp32.PinServiceUUID}
+	alpha.RSSI = -70
+	unnamed := mock.NewBoard("", "AA:BB:CC:DD:EE:01")
+	adapter := mock.NewAdapter(zeta, unnamed, alpha)
+
+	devices, err := esp32.ListDevices(context.Background(), adapter, 50*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var order []string
+	for _, d := range devices {
+		order = append(order, d.Address)
+		if d.Adverts < 2 || d.LastSeen.IsZero() {
+			t.Errorf("%s: %d advert(s) last seen %v, want several merged into one entry", d.Address, d.Adverts, d.LastSeen)
+		}
+	}
+	if want := []string{"AA:BB:CC:DD:EE:02", "AA:BB:CC:DD:EE:03", "AA:BB:CC:DD:EE:01"}; !slices.Equal(order, want) {
+		t.Errorf("order = %v, want %v (by name, unnamed last)", order, want)
+	}
+	if d := devices[0]; d.RSSI != -70 || !slices.Equal(d.Services, []string{esp32.PinServiceUUID}) {
+		t.Errorf("Alpha = %+v, want RSSI -70 advertising the pin service", d)
+	}
+}
@@ -0,0 +1,139 @@
+package esp32
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// Manager owns an adapter and the clients connected through it, so several
+// boards can be used concurrently. Scans are serialized, since an adapter
+// can only run one at a time; connected clients may be used from separate
+// goroutines.
+type Manager struct {
+	adapter Adapter
+
+	scanMu sync.Mutex
+
+	mu      sync.Mutex
+	enabled bool
+	clients map[string]*Client // by address
+}
+
+// NewManager returns a manager for adapter.
+func NewManager(adapter Adapter) *Manager {
+	return &Manager{adapter: adapter, clients: map[string]*Client{}}
+}
+
+// Enable enables the adapter if it isn't already.
+func (m *Manager) Enable() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.enabled {
+		return nil
+	}
+	if err := m.adapter.Enable(); err != nil {
+		return err
+	}
+	m.enabled = true
+	return nil
+}
+
+// Powered reports whether the adapter is powered. Adapters that don't
+// implement PowerReporter are assumed to always be powered.
+func (m *Manager) Powered() bool {
+	pr, ok := m.adapter.(PowerReporter)
+	if !ok {
+		return true
+	}
+	powered, err := pr.Powered()
+	return err == nil && powered
+}
+
+// AdapterLost records that the adapter went away: every client's link is
+// gone, so they are disconnected and forgotten, and the adapter will be
+// enabled again on next use.
+func (m *Manager) AdapterLost() {
+	m.Close()
+	m.mu.Lock()
+	m.enabled = false
+	m.mu.Unlock()
+}
+
+// FindDevices scans for every name, as FindDevices does, waiting for any
+// other scan through this manager to finish first.
+func (m *Manager) FindDevices(ctx context.Context, names []string, timeout time.Duration, seen func(ScanResult)) ([]ScanResult, error) {
+	if err := m.Enable(); err != nil {
+		return nil, err
+	}
+	m.scanMu.Lock()
+	defer m.scanMu.Unlock()
+	return FindDevices(ctx, m.adapter, names, timeout, seen)
+}
+
+// Connect connects to a scanned device, or returns the existing client if
+// the manager is already connected to its address.
+func (m *Manager) Connect(ctx context.Context, result ScanResult) (*Client, error) {
+	m.mu.Lock()
+	if c, ok := m.clients[result.Address]; ok {
+		m.mu.Unlock()
+		return c, nil
+	}
+	m.mu.Unlock()
+
+	c, err := Connect(ctx, m.adapter, result)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if existing, ok := m.clients[result.Address]; ok {
+		// Lost a race with a concurrent Connect to the same device.
+		c.Disconnect()
+		return existing, nil
+	}
+	m.clients[result.Address] = c
+	return c, nil
+}
+
+// Client returns the connected client for address, if any.
+func (m *Manager) Client(address string) (*Client, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	c, ok := m.clients[address]
+	return c, ok
+}
+
+// Clients returns every connected client.
+func (m *Manager) Clients() []*Client {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	clients := make([]*Client, 0, len(m.clients))
+	for _, c := range m.clients {
+		clients = append(clients, c)
+	}
+	return clients
+}
+
+// Disconnect disconnects a client and forgets it.
+func (m *Manager) Disconnect(c *Client) error {
+	m.mu.Lock()
+	if m.clients[c.Address] == c {
+		delete(m.clients, c.Address)
+	}
+	m.mu.Unlock()
+	return c.Disconnect()
+}
+
+// Close disconnects every client.
+func (m *Manager) Close() error {
+	var errs []error
+	for _, c := range m.Clients() {
+		if err := m.Disconnect(c); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,110 @@
+package esp32
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Matcher builds the test for the scan results a target's pattern asks
+// for, failing if the pattern is malformed. Matchers are registered under
+// a scheme with RegisterMatcher and used for targets written
+// "<scheme>:<pattern>".
+type Matcher func(pattern string) (func(ScanResult) bool, error)
+
+// Target schemes matched by the stock matchers. A target with no scheme
+// matches a device's name or address case-insensitively.
+const (
+	// MatchExact matches the name as written, case and all.
+	MatchExact = "exact"
+	// MatchPrefix matches names starting with the pattern,
+	// case-insensitively.
+	MatchPrefix = "prefix"
+	// MatchRegexp matches names the pattern, a Go regular expression,
+	// matches anywhere; anchor it with ^ and $ to match whole names.
+	MatchRegexp = "re"
+	// MatchService matches devices advertising the service UUID.
+	MatchService = "service"
+)
+
+var (
+	matchersMu sync.RWMutex
+	matchers   = map[string]Matcher{
+		MatchExact: func(pattern string) (func(ScanResult) bool, error) {
+			return func(r ScanResult) bool { return r.Name == pattern }, nil
+		},
+		MatchPrefix: func(pattern string) (func(ScanResult) bool, error) {
+			pattern = strings.ToLower(pattern)
+			return func(r ScanResult) bool { return strings.HasPrefix(strings.ToLower(r.Name), pattern) }, nil
+		},
+		MatchRegexp: func(pattern string) (func(ScanResult) bool, error) {
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				return nil, err
+			}
+			return func(r ScanResult) bool { return re.MatchString(r.Name) }, nil
+		},
+		MatchService: func(pattern string) (func(ScanResult) bool, error) {
+			return func(r ScanResult) bool {
+				return slices.ContainsFunc(r.Services, func(s string) bool { return strings.EqualFold(s, pattern) })
+			}, nil
+		},
+	}
+)
+
+// RegisterMatcher makes m the matcher of targets written
+// "<scheme>:<pattern>". It panics if the scheme is taken, like
+// RegisterDecoder.
+func RegisterMatcher(scheme string, m Matcher) {
+	matchersMu.Lock()
+	defer matchersMu.Unlock()
+	if _, ok := matchers[scheme]; ok {
+		panic(fmt.Sprintf("esp32: matcher %q registered twice", scheme))
+	}
+	matchers[scheme] = m
+}
+
+// MatcherSchemes returns the registered target schemes, sorted.
+func MatcherSchemes() []string {
+	matchersMu.RLock()
+	defer matchersMu.RUnlock()
+	schemes := make([]string, 0, len(matchers))
+	for scheme := range matchers {
+		schemes = append(schemes, scheme)
+	}
+	sort.Strings(schemes)
+	return schemes
+}
+
+// CompileTarget returns the test for the scan results target asks for.
+// A target starting with a registered scheme and a colon, such as
+// "prefix:esp32-", is matched by that scheme's matcher; any other, such
+// as "esp32-lab" or "AA:BB:CC:DD:EE:01", matches a device's name or
+// address case-insensitively.
+func CompileTarget(target string) (func(ScanResult) bool, error) {
+	if scheme, pattern, ok := strings.Cut(target, ":"); ok {
+		matchersMu.RLock()
+		m, ok := matchers[scheme]
+		matchersMu.RUnlock()
+		if ok {
+			match, err := m(pattern)
+			if err != nil {
+				return nil, fmt.Errorf("invalid target %q: %w", target, err)
+			}
+			return match, nil
+		}
+	}
+	return func(r ScanResult) bool {
+		return strings.EqualFold(r.Name, target) || strings.EqualFold(r.Address, target)
+	}, nil
+}
+
+// MatchTarget reports whether result is a device target asks for, as
+// CompileTarget's test does; a malformed target matches nothing.
+func MatchTarget(target string, result ScanResult) bool {
+	match, err := CompileTarget(target)
+	return err == nil && match(result)
+}
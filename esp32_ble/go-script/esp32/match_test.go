@@ -0,0 +1,93 @@
+package esp32_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"bluetooth/esp32"
+	"bluetooth/esp32/mock"
+)
+
+func TestMatchTarget(t *testing.T) {
+	lab := esp32.ScanResult{Name: "ESP32-Lab", Address: "AA:BB:CC:DD:EE:01", Services: []string{"a9c81b72-0f7a-4c59-b0a8-425e3bcf0a0e"}}
+	unnamed := esp32.ScanResult{Address: "AA:BB:CC:DD:EE:02"}
+	for _, tc := range []struct {
+		target string
+		result esp32.ScanResult
+		want   bool
+	}{
+		// No scheme: name or address, case-insensitively.
+		{"ESP32-Lab", lab, true},
+		{"esp32-lab", lab, true},
+		{"esp32-la", lab, false},
+		{"esp32-lab ", lab, false},
+		{"aa:bb:cc:dd:ee:01", lab, true},
+		{"AA:BB:CC:DD:EE:01", unnamed, false},
+		{"AA:BB:CC:DD:EE:02", unnamed, true},
+		{"", unnamed, true},
+		{"", lab, false},
+		// An unregistered scheme is part of the name.
+		{"lab:ESP32-Lab", lab, false},
+		{"mystery:x", esp32.ScanResult{Name: "mystery:x"}, true},
+
+		{"exact:ESP32-Lab", lab, true},
+		{"exact:esp32-lab", lab, false},
+		{"exact:AA:BB:CC:DD:EE:01", lab, false},
+
+		{"prefix:esp32-", lab, true},
+		{"prefix:ESP32-L", lab, true},
+		{"prefix:", unnamed, true},
+		{"prefix:lab", lab, false},
+
+		{"re:Lab$", lab, true},
+		{"re:^ESP32-(Lab|Shed)$", lab, true},
+		{"re:^esp32", lab, false},
+		{"re:(?i)^esp32", lab, true},
+		{"re:.", unnamed, false},
+
+		{"service:a9c81b72-0f7a-4c59-b0a8-425e3bcf0a0e", lab, true},
+		{"service:A9C81B72-0F7A-4C59-B0A8-425E3BCF0A0E", lab, true},
+		{"service:a9c81b72-0f7a-4c59-b0a8-425e3bcf0a0e", unnamed, false},
+		{"service:", lab, false},
+
+		// A malformed pattern matches nothing.
+		{"re:(", esp32.ScanResult{Name: "("}, false},
+	} {
+		if got := esp32.MatchTarget(tc.target, tc.result); got != tc.want {
+			t.Errorf("MatchTarget(%q, %+v) = %v, want %v", tc.target, tc.result, got, tc.want)
+		}
+	}
+}
+
+func TestCompileTargetErrors(t *testing.T) {
+	if _, err := esp32.CompileTarget("re:("); err == nil || !strings.Contains(err.Error(), `invalid target "re:("`) {
+		t.Errorf("CompileTarget(re:() = %v, want an invalid target error", err)
+	}
+	adapter := mock.NewAdapter(mock.NewBoard("esp32-test", "AA:BB:CC:DD:EE:01"))
+	if _, err := esp32.FindDevice(context.Background(), adapter, "re:(", time.Second, nil); err == nil {
+		t.Error("FindDevice scanned for a malformed target")
+	}
+}
+
+func TestRegisterMatcher(t *testing.T) {
+	// A scheme matching the last two address bytes, as printed on a
+	// board's label.
+	esp32.RegisterMatcher("label", func(pattern string) (func(esp32.ScanResult) bool, error) {
+		return func(r esp32.ScanResult) bool { return strings.HasSuffix(r.Address, ":"+pattern) }, nil
+	})
+	board := mock.NewBoard("esp32-test", "AA:BB:CC:DD:EE:01")
+	adapter := mock.NewAdapter(mock.NewBoard("esp32-other", "AA:BB:CC:DD:FF:02"), board)
+	result, err := esp32.FindDevice(context.Background(), adapter, "label:EE:01", time.Second, nil)
+	if err != nil || result.Name != "esp32-test" {
+		t.Errorf("FindDevice(label:EE:01) = %+v, %v, want esp32-test", result, err)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("registering a taken scheme didn't panic")
+		}
+	}()
+	esp32.RegisterMatcher(esp32.MatchPrefix, nil)
+}
@@ -0,0 +1,35 @@
+package mock
+
+import "encoding/binary"
+
+// EnableBench adds a throughput test characteristic with the given UUID
+// to the board's service. It only notifies when SendBench is called.
+func (b *Board) EnableBench(uuid string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.bench = uuid
+}
+
+// SendBench notifies subscribers of the bench characteristic with a test
+// frame: seq as 32-bit little-endian, padded to fill the MTU.
+func (b *Board) SendBench(seq uint32) {
+	b.mu.Lock()
+	frame := make([]byte, max(int(b.MTU)-3, 4))
+	binary.LittleEndian.PutUint32(frame, seq)
+	subs := append([]func([]byte){}, b.notify[b.bench]...)
+	b.mu.Unlock()
+
+	for _, fn := range subs {
+		fn(frame)
+	}
+}
+
+// benchUUIDs returns the bench characteristic's UUID, if enabled.
+func (b *Board) benchUUIDs() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.bench == "" {
+		return nil
+	}
+	return []string{b.bench}
+}
@@ -0,0 +1,263 @@
+package mock
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"hash/crc32"
+
+	"bluetooth/esp32"
+)
+
+// Heatshrink parameters the mock encodes with, a common firmware choice.
+const (
+	heatshrinkWindow    = 8
+	heatshrinkLookahead = 4
+)
+
+// download is a board's bulk transfer sender, answering requests the way
+// esp32.Client.Download makes them.
+type download struct {
+	data, control string
+	buf           []byte
+	compression   esp32.Compression
+
+	// The transfer in progress: its frames, which have been acked, the
+	// first that hasn't and the window.
+	frames [][]byte
+	acked  []bool
+	next   int
+	window int
+	// drop holds frames whose next sending is lost.
+	drop map[int]bool
+	// disconnectAfter, if positive, is how many more frames are sent
+	// before the connection drops.
+	disconnectAfter int
+}
+
+// EnableDownload adds bulk transfer data and control characteristics
+// with the given UUIDs to the board's service. There is nothing to
+// download until SetDownload is called.
+func (b *Board) EnableDownload(dataUUID, controlUUID string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.download = &download{data: dataUUID, control: controlUUID}
+}
+
+// SetDownload sets what the board sends when a download is requested,
+// compressed with c if the client accepts it and uncompressed otherwise,
+// like firmware falling back for an older client.
+func (b *Board) SetDownload(data []byte, c esp32.Compression) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.download.buf, b.download.compression = data, c
+}
+
+// DropDownloadFrames makes the board's next sending of each of the given
+// download frames (0 is the header) get lost on the air.
+func (b *Board) DropDownloadFrames(frames ...int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.download.drop == nil {
+		b.download.drop = map[int]bool{}
+	}
+	for _, n := range frames {
+		b.download.drop[n] = true
+	}
+}
+
+// DisconnectDuringDownload makes the connection drop once the board has
+// sent n more download frames, keeping the transfer for a resume.
+func (b *Board) DisconnectDuringDownload(n int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.download.disconnectAfter = n
+}
+
+// downloadUUIDs returns the bulk transfer characteristics' UUIDs, if
+// enabled.
+func (b *Board) downloadUUIDs() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.download == nil {
+		return nil
+	}
+	return []string{b.download.data, b.download.control}
+}
+
+// downloadControl returns the bulk transfer control characteristic's
+// UUID, or "" if downloads aren't enabled.
+func (b *Board) downloadControl() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.download == nil {
+		return ""
+	}
+	return b.download.control
+}
+
+// downloadWrite handles a write to the bulk transfer control
+// characteristic, reporting whether uuid was it. Start requests and acks
+// are answered with the next window before it returns.
+func (b *Board) downloadWrite(uuid string, p []byte) bool {
+	b.mu.Lock()
+	d := b.download
+	if d == nil || uuid != d.control {
+		b.mu.Unlock()
+		return false
+	}
+	var send [][]byte
+	switch {
+	case len(p) == 3 && p[0] == 0x01, len(p) == 5 && p[0] == 0x03:
+		c := d.compression
+		if p[1]&(1<<c) == 0 {
+			c = esp32.CompressionNone
+		}
+		d.frames = downloadFrames(d.buf, c, int(b.MTU)-3)
+		d.acked = make([]bool, len(d.frames))
+		d.next, d.window = 0, max(int(p[2]), 1)
+		if p[0] == 0x03 {
+			// A resume: everything but the header is there up to the
+			// frame asked for.
+			d.ack(1, int(binary.LittleEndian.Uint16(p[3:])))
+		}
+		send = d.nextWindow()
+	case len(p) >= 4 && p[0] == 0x02 && d.frames != nil && len(p) == 4+4*int(p[3]):
+		d.ack(0, int(binary.LittleEndian.Uint16(p[1:])))
+		for r := p[4:]; len(r) >= 4; r = r[4:] {
+			d.ack(int(binary.LittleEndian.Uint16(r)), int(binary.LittleEndian.Uint16(r[2:])))
+		}
+		for d.next < len(d.acked) && d.acked[d.next] {
+			d.next++
+		}
+		send = d.nextWindow()
+	}
+	// The firmware ignores requests it doesn't understand.
+	disconnect := false
+	if d.disconnectAfter > 0 {
+		if len(send) >= d.disconnectAfter {
+			send, disconnect = send[:d.disconnectAfter], true
+		}
+		d.disconnectAfter -= len(send)
+	}
+	subs := append([]func([]byte){}, b.notify[d.data]...)
+	b.mu.Unlock()
+
+	for _, frame := range send {
+		for _, fn := range subs {
+			fn(frame)
+		}
+	}
+	if disconnect {
+		b.drop()
+	}
+	return true
+}
+
+// ack marks frames first up to end acknowledged.
+func (d *download) ack(first, end int) {
+	for n := first; n < min(end, len(d.acked)); n++ {
+		d.acked[n] = true
+	}
+}
+
+// nextWindow returns the unacknowledged frames of the window starting at
+// the first unacknowledged one, less any dropped.
+func (d *download) nextWindow() [][]byte {
+	var send [][]byte
+	for n := d.next; n < min(d.next+d.window, len(d.frames)); n++ {
+		if d.acked[n] {
+			continue
+		}
+		if d.drop[n] {
+			delete(d.drop, n)
+			continue
+		}
+		send = append(send, d.frames[n])
+	}
+	return send
+}
+
+// downloadFrames returns the notifications sending data compressed with
+// c in frames of at most size bytes.
+func downloadFrames(data []byte, c esp32.Compression, size int) [][]byte {
+	var payload []byte
+	var window, lookahead byte
+	switch c {
+	case esp32.CompressionNone:
+		payload = data
+	case esp32.CompressionZlib:
+		var buf bytes.Buffer
+		w := zlib.NewWriter(&buf)
+		w.Write(data)
+		w.Close()
+		payload = buf.Bytes()
+	case esp32.CompressionHeatshrink:
+		payload = heatshrinkEncode(data, heatshrinkWindow, heatshrinkLookahead)
+		window, lookahead = heatshrinkWindow, heatshrinkLookahead
+	}
+
+	header := []byte{byte(c), window, lookahead}
+	header = binary.LittleEndian.AppendUint32(header, uint32(len(data)))
+	header = binary.LittleEndian.AppendUint32(header, crc32.ChecksumIEEE(data))
+	header = binary.LittleEndian.AppendUint32(header, uint32(len(payload)))
+
+	var frames [][]byte
+	seq := uint16(0)
+	frame := func(p []byte) {
+		frames = append(frames, append(binary.LittleEndian.AppendUint16(nil, seq), p...))
+		seq++
+	}
+	frame(header)
+	for len(payload) > 0 {
+		n := min(len(payload), size-2)
+		frame(payload[:n])
+		payload = payload[n:]
+	}
+	return frames
+}
+
+// heatshrinkEncode compresses data as the heatshrink library does, with a
+// greedy search of the window for the longest match.
+func heatshrinkEncode(data []byte, window, lookahead uint8) []byte {
+	var out []byte
+	var cur byte
+	var bits uint8
+	emit := func(v int, n uint8) {
+		for i := int(n) - 1; i >= 0; i-- {
+			cur = cur<<1 | byte(v>>i&1)
+			if bits++; bits == 8 {
+				out, cur, bits = append(out, cur), 0, 0
+			}
+		}
+	}
+	maxOffset, maxLen := 1<<window, 1<<lookahead
+	for i := 0; i < len(data); {
+		bestLen, bestOffset := 0, 0
+		for offset := 1; offset <= min(maxOffset, i); offset++ {
+			n := 0
+			for n < maxLen && i+n < len(data) && data[i+n] == data[i+n-offset] {
+				n++
+			}
+			if n > bestLen {
+				bestLen, bestOffset = n, offset
+			}
+		}
+		// A back-reference costs 1+window+lookahead bits, so short matches
+		// are cheaper as literals.
+		if bestLen*9 <= 1+int(window)+int(lookahead) {
+			emit(1, 1)
+			emit(int(data[i]), 8)
+			i++
+			continue
+		}
+		emit(0, 1)
+		emit(bestOffset-1, window)
+		emit(bestLen-1, lookahead)
+		i += bestLen
+	}
+	if bits > 0 {
+		out = append(out, cur<<(8-bits))
+	}
+	return out
+}
@@ -0,0 +1,144 @@
+package mock
+
+import (
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// ErrConnectionLost is returned by operations on a board whose injected
+// fault dropped the connection.
+var ErrConnectionLost = errors.New("mock: connection lost")
+
+// Faults configures random fault injection on a board. Each rate is the
+// probability, in [0, 1], that a read or write hits that fault.
+type Faults struct {
+	Disconnect float64
+	Stall      float64
+	StallFor   time.Duration
+	Malformed  float64
+}
+
+// FaultCounts is how many faults of each kind a board has injected.
+type FaultCounts struct {
+	Disconnects int
+	Stalls      int
+	Malformed   int
+}
+
+type fault int
+
+const (
+	noFault fault = iota
+	disconnectFault
+	stallFault
+	malformedFault
+)
+
+// injector picks faults for a board. It has its own lock so a stalled
+// operation doesn't hold the board's.
+type injector struct {
+	mu     sync.Mutex
+	faults Faults
+	rng    *rand.Rand
+	counts FaultCounts
+}
+
+// InjectFaults enables random fault injection on the board's
+// characteristic reads and writes, drawing from rng. The board draws
+// from it under a lock of its own, so the caller mustn't use rng after.
+func (b *Board) InjectFaults(f Faults, rng *rand.Rand) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.faults = &injector{faults: f, rng: rng}
+}
+
+// FaultCounts returns how many faults have been injected so far.
+func (b *Board) FaultCounts() FaultCounts {
+	b.mu.Lock()
+	inj := b.faults
+	b.mu.Unlock()
+	if inj == nil {
+		return FaultCounts{}
+	}
+	inj.mu.Lock()
+	defer inj.mu.Unlock()
+	return inj.counts
+}
+
+// nextFault draws the fault, if any, for the next operation.
+func (b *Board) nextFault() fault {
+	b.mu.Lock()
+	inj := b.faults
+	b.mu.Unlock()
+	if inj == nil {
+		return noFault
+	}
+
+	inj.mu.Lock()
+	defer inj.mu.Unlock()
+	f := inj.faults
+	switch p := inj.rng.Float64(); {
+	case p < f.Disconnect:
+		inj.counts.Disconnects++
+		return disconnectFault
+	case p < f.Disconnect+f.Stall:
+		inj.counts.Stalls++
+		return stallFault
+	case p < f.Disconnect+f.Stall+f.Malformed:
+		inj.counts.Malformed++
+		return malformedFault
+	}
+	return noFault
+}
+
+// stallDuration returns how long a stall fault blocks.
+func (b *Board) stallDuration() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.faults.faults.StallFor
+}
+
+// malformedFrame returns random bytes of random length, up to the
+// firmware's 32-byte characteristic size.
+func (b *Board) malformedFrame() []byte {
+	b.mu.Lock()
+	inj := b.faults
+	b.mu.Unlock()
+	inj.mu.Lock()
+	defer inj.mu.Unlock()
+	frame := make([]byte, inj.rng.Intn(33))
+	inj.rng.Read(frame)
+	return frame
+}
+
+// drop simulates the link going away: the board forgets its central and
+// its notification subscriptions.
+func (b *Board) drop() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.dropLocked()
+}
+
+// dropLocked is drop with b.mu held.
+func (b *Board) dropLocked() {
+	b.connected = false
+	b.notify = map[string][]func([]byte){}
+}
+
+// applyFault runs the drawn fault for an operation. It reports the frame
+// to return instead of the real one for malformed faults, or an error if
+// the operation should fail.
+func (b *Board) applyFault() ([]byte, error) {
+	switch b.nextFault() {
+	case disconnectFault:
+		b.drop()
+		return nil, ErrConnectionLost
+	case stallFault:
+		time.Sleep(b.stallDuration())
+	case malformedFault:
+		return b.malformedFrame(), nil
+	}
+	return nil, nil
+}
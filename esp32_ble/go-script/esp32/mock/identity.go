@@ -0,0 +1,40 @@
+package mock
+
+// identity is the serial number the board's firmware reports, which
+// esp32.SerialNumberIdentifier reads.
+type identity struct {
+	uuid   string
+	serial []byte
+}
+
+// EnableSerialNumber adds a read-only characteristic with the given UUID
+// to the board's service, reporting serial. It stays with the board when
+// SetAddress changes its address, as a serial number in flash does when
+// the radio module is replaced.
+func (b *Board) EnableSerialNumber(uuid string, serial []byte) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.identity = &identity{uuid: uuid, serial: serial}
+}
+
+// serialNumberUUIDs returns the serial number characteristic's UUID, if
+// enabled.
+func (b *Board) serialNumberUUIDs() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.identity == nil {
+		return nil
+	}
+	return []string{b.identity.uuid}
+}
+
+// serialNumber returns the board's serial number if uuid is its
+// characteristic.
+func (b *Board) serialNumber(uuid string) ([]byte, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.identity == nil || uuid != b.identity.uuid {
+		return nil, false
+	}
+	return b.identity.serial, true
+}
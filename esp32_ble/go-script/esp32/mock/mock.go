@@ -0,0 +1,616 @@
+// Package mock provides a host-side emulator of the esp32_ble firmware's
+// GATT server, implementing esp32.Adapter so CLI flows can run without an
+// adapter or board present.
+package mock
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"bluetooth/esp32"
+)
+
+// Board emulates one ESP32 running the pin service firmware. Basic pins
+// and ADC pins are reported in the order they were added, like the
+// firmware's configured pin lists.
+type Board struct {
+	Name    string
+	Address string
+	RSSI    int16
+	// MTU is the ATT MTU reported for connections to the board.
+	MTU uint16
+	// PHYs are the PHYs the board accepts; the original ESP32 only has
+	// 1M, so that is the default.
+	PHYs []esp32.PHY
+	// Services are the service UUIDs the board advertises; like the
+	// firmware, none by default.
+	Services []string
+
+	mu        sync.Mutex
+	pinOrder  []uint8
+	pins      map[uint8]uint8
+	adcOrder  []uint8
+	adc       map[uint8]uint16
+	connected bool
+	phy       esp32.PHY
+	notify    map[string][]func([]byte)
+	disabled  []string
+	faults    *injector
+	ota       *ota
+	download  *download
+	selfTest  *selfTest
+	schedule  *schedule
+	identity  *identity
+	bench     string
+	security  *Security
+	bonded    bool
+	lost      int
+	away      bool
+}
+
+// NewBoard returns a board with the firmware's default pin layout: basic
+// pins 14, 26, 25, 33 and ADC pins 35, 32, all reading zero.
+func NewBoard(name, address string) *Board {
+	b := &Board{
+		Name:    name,
+		Address: address,
+		RSSI:    -50,
+		MTU:     247,
+		PHYs:    []esp32.PHY{esp32.PHY1M},
+		phy:     esp32.PHY1M,
+		pins:    map[uint8]uint8{},
+		adc:     map[uint8]uint16{},
+		notify:  map[string][]func([]byte){},
+	}
+	for _, pin := range []uint8{14, 26, 25, 33} {
+		b.SetPin(pin, 0)
+	}
+	for _, pin := range []uint8{35, 32} {
+		b.SetADC(pin, 0)
+	}
+	return b
+}
+
+// SetPin sets a basic pin's state, adding the pin if it is new.
+func (b *Board) SetPin(pin, state uint8) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.pins[pin]; !ok {
+		b.pinOrder = append(b.pinOrder, pin)
+	}
+	b.pins[pin] = state
+}
+
+// Pin returns a basic pin's state.
+func (b *Board) Pin(pin uint8) uint8 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.pins[pin]
+}
+
+// SetADC sets an ADC pin's value, adding the pin if it is new.
+func (b *Board) SetADC(pin uint8, value uint16) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.adc[pin]; !ok {
+		b.adcOrder = append(b.adcOrder, pin)
+	}
+	b.adc[pin] = value
+}
+
+// Connected reports whether a central is connected.
+func (b *Board) Connected() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.connected
+}
+
+// Subscribers returns how many notification callbacks are registered.
+func (b *Board) Subscribers() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	n := 0
+	for _, subs := range b.notify {
+		n += len(subs)
+	}
+	return n
+}
+
+// DisabledNotifications returns the UUIDs of the characteristics whose
+// notifications have been disabled, in order. Dropping the link clears
+// the board's subscribers without disabling anything, as a real link
+// does, so this tells a client that unsubscribes from one that doesn't.
+func (b *Board) DisabledNotifications() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return slices.Clone(b.disabled)
+}
+
+// SetInRange moves the board out of range of the adapter or back:
+// out of range its link drops, it isn't seen advertising and can't be
+// connected to.
+func (b *Board) SetInRange(in bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.away = !in
+	if b.away {
+		b.dropLocked()
+	}
+}
+
+// SetAddress changes the address the board advertises and is connected
+// to at, as firmware using a random address does when it restarts. Its
+// link, if any, drops.
+func (b *Board) SetAddress(address string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.Address = address
+	b.dropLocked()
+}
+
+// Drop drops the board's link, as a reset or interference would, without
+// the central being told.
+func (b *Board) Drop() {
+	b.drop()
+}
+
+// advert returns the board's advertisement, unless it is out of range.
+func (b *Board) advert() (esp32.ScanResult, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return esp32.ScanResult{Name: b.Name, Address: b.Address, RSSI: b.RSSI, Services: b.Services}, !b.away
+}
+
+// Notify pushes the current pin and ADC frames to subscribed centrals,
+// like one iteration of the firmware's notify loop.
+func (b *Board) Notify() {
+	b.mu.Lock()
+	pinFrame := b.pinFrame()
+	adcFrame := b.adcFrame()
+	pinSubs := append([]func([]byte){}, b.notify[esp32.PinDataOutputUUID]...)
+	adcSubs := append([]func([]byte){}, b.notify[esp32.ADCDataOutputUUID]...)
+	b.mu.Unlock()
+
+	for _, fn := range pinSubs {
+		fn(pinFrame)
+	}
+	for _, fn := range adcSubs {
+		fn(adcFrame)
+	}
+}
+
+// pinFrame encodes num_pins, then (pin, value) per pin. b.mu must be held.
+func (b *Board) pinFrame() []byte {
+	frame := make([]byte, 32)
+	frame[0] = byte(len(b.pinOrder))
+	for i, pin := range b.pinOrder {
+		frame[i*2+1] = pin
+		frame[i*2+2] = b.pins[pin]
+	}
+	return frame
+}
+
+// adcFrame encodes num_pins, then (pin, high, low) per pin. b.mu must be held.
+func (b *Board) adcFrame() []byte {
+	frame := make([]byte, 32)
+	frame[0] = byte(len(b.adcOrder))
+	for i, pin := range b.adcOrder {
+		value := b.adc[pin]
+		frame[i*3+1] = pin
+		frame[i*3+2] = byte(value >> 8)
+		frame[i*3+3] = byte(value)
+	}
+	return frame
+}
+
+// write handles a write to the pin data input characteristic. Like the
+// firmware, malformed JSON is ignored and unknown pins are dropped.
+func (b *Board) write(p []byte) {
+	var req esp32.PinRequest
+	if err := json.Unmarshal(p, &req); err != nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, w := range req.PinWrites {
+		if _, ok := b.pins[w.PinNum]; ok {
+			b.pins[w.PinNum] = w.State
+		}
+	}
+}
+
+// Adapter is an emulated host adapter that can see a set of boards.
+type Adapter struct {
+	// ScanInterval is how often each board re-advertises while scanning.
+	ScanInterval time.Duration
+
+	mu      sync.Mutex
+	boards  []*Board
+	stop    chan struct{}
+	powered bool
+	params  esp32.ScanParams
+}
+
+// NewAdapter returns a powered adapter that sees boards.
+func NewAdapter(boards ...*Board) *Adapter {
+	return &Adapter{ScanInterval: 10 * time.Millisecond, boards: boards, powered: true}
+}
+
+// ErrPoweredOff is returned by operations on a powered-off adapter.
+var ErrPoweredOff = errors.New("mock: adapter powered off")
+
+// SetPowered simulates the radio being switched off (rfkill, suspend) or
+// back on. Powering off drops every board's connection and fails any
+// running scan.
+func (a *Adapter) SetPowered(powered bool) {
+	a.mu.Lock()
+	a.powered = powered
+	boards := append([]*Board{}, a.boards...)
+	stop := a.stop
+	a.stop = nil
+	a.mu.Unlock()
+
+	if powered {
+		return
+	}
+	if stop != nil {
+		close(stop)
+	}
+	for _, b := range boards {
+		b.drop()
+	}
+}
+
+// Powered implements esp32.PowerReporter.
+func (a *Adapter) Powered() (bool, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.powered, nil
+}
+
+// SetScanParams implements esp32.ScanConfigurer, emulating a platform
+// where every parameter can be set. The emulated radio scans the same
+// whatever they are.
+func (a *Adapter) SetScanParams(p esp32.ScanParams) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.params = p
+	return nil
+}
+
+// ScanParams returns the parameters last set with SetScanParams.
+func (a *Adapter) ScanParams() esp32.ScanParams {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.params
+}
+
+func (a *Adapter) Enable() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if !a.powered {
+		return ErrPoweredOff
+	}
+	return nil
+}
+
+func (a *Adapter) Scan(callback func(esp32.ScanResult)) error {
+	a.mu.Lock()
+	if !a.powered {
+		a.mu.Unlock()
+		return ErrPoweredOff
+	}
+	if a.stop != nil {
+		a.mu.Unlock()
+		return errors.New("mock: already scanning")
+	}
+	stop := make(chan struct{})
+	a.stop = stop
+	boards := append([]*Board{}, a.boards...)
+	a.mu.Unlock()
+
+	ticker := time.NewTicker(a.ScanInterval)
+	defer ticker.Stop()
+	for {
+		for _, b := range boards {
+			select {
+			case <-stop:
+				return a.scanStopped()
+			default:
+			}
+			if result, ok := b.advert(); ok {
+				callback(result)
+			}
+		}
+		select {
+		case <-stop:
+			return a.scanStopped()
+		case <-ticker.C:
+		}
+	}
+}
+
+// scanStopped is what Scan returns once stopped: nil after StopScan, or
+// ErrPoweredOff if the adapter lost power.
+func (a *Adapter) scanStopped() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if !a.powered {
+		return ErrPoweredOff
+	}
+	return nil
+}
+
+func (a *Adapter) StopScan() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.stop == nil {
+		return errors.New("mock: not scanning")
+	}
+	close(a.stop)
+	a.stop = nil
+	return nil
+}
+
+func (a *Adapter) Connect(address string) (esp32.Device, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if !a.powered {
+		return nil, ErrPoweredOff
+	}
+	for _, b := range a.boards {
+		b.mu.Lock()
+		if b.Address == address && !b.away {
+			b.connected = true
+			b.phy = esp32.PHY1M
+			b.mu.Unlock()
+			return &device{board: b}, nil
+		}
+		b.mu.Unlock()
+	}
+	return nil, fmt.Errorf("mock: no board at %s", address)
+}
+
+type device struct {
+	board *Board
+}
+
+func (d *device) DiscoverServices() ([]esp32.Service, error) {
+	return []esp32.Service{service{board: d.board}}, nil
+}
+
+func (d *device) Disconnect() error {
+	d.board.drop()
+	return nil
+}
+
+// Describe implements esp32.Describer with the firmware's characteristic
+// flags.
+func (d *device) Describe() ([]esp32.CharacteristicInfo, error) {
+	notify := []string{"read", "notify"}
+	infos := []esp32.CharacteristicInfo{
+		{ServiceUUID: esp32.PinServiceUUID, UUID: esp32.PinDataOutputUUID, Properties: notify, Descriptors: []string{esp32.CCCDUUID}},
+		{ServiceUUID: esp32.PinServiceUUID, UUID: esp32.ADCDataOutputUUID, Properties: notify, Descriptors: []string{esp32.CCCDUUID}},
+		{ServiceUUID: esp32.PinServiceUUID, UUID: esp32.PinDataInputUUID, Properties: []string{"read", "write", "write-without-response"}},
+	}
+	if uuids := d.board.otaUUIDs(); uuids != nil {
+		infos = append(infos,
+			esp32.CharacteristicInfo{ServiceUUID: esp32.PinServiceUUID, UUID: uuids[0], Properties: []string{"write", "write-without-response"}},
+			esp32.CharacteristicInfo{ServiceUUID: esp32.PinServiceUUID, UUID: uuids[1], Properties: []string{"read", "write", "write-without-response"}})
+	}
+	if uuids := d.board.downloadUUIDs(); uuids != nil {
+		infos = append(infos,
+			esp32.CharacteristicInfo{ServiceUUID: esp32.PinServiceUUID, UUID: uuids[0], Properties: []string{"notify"}, Descriptors: []string{esp32.CCCDUUID}},
+			esp32.CharacteristicInfo{ServiceUUID: esp32.PinServiceUUID, UUID: uuids[1], Properties: []string{"write"}})
+	}
+	for _, uuid := range d.board.selfTestUUIDs() {
+		infos = append(infos, esp32.CharacteristicInfo{ServiceUUID: esp32.PinServiceUUID, UUID: uuid, Properties: []string{"write", "notify"}, Descriptors: []string{esp32.CCCDUUID}})
+	}
+	for _, uuid := range d.board.scheduleUUIDs() {
+		infos = append(infos, esp32.CharacteristicInfo{ServiceUUID: esp32.PinServiceUUID, UUID: uuid, Properties: []string{"read", "write"}})
+	}
+	for _, uuid := range d.board.benchUUIDs() {
+		infos = append(infos, esp32.CharacteristicInfo{ServiceUUID: esp32.PinServiceUUID, UUID: uuid, Properties: []string{"notify"}, Descriptors: []string{esp32.CCCDUUID}})
+	}
+	for _, uuid := range d.board.serialNumberUUIDs() {
+		infos = append(infos, esp32.CharacteristicInfo{ServiceUUID: esp32.PinServiceUUID, UUID: uuid, Properties: []string{"read"}})
+	}
+	return infos, nil
+}
+
+// RSSI implements esp32.RSSIReporter with the board's RSSI field.
+func (d *device) RSSI() (int16, error) {
+	d.board.mu.Lock()
+	defer d.board.mu.Unlock()
+	if !d.board.connected {
+		return 0, ErrConnectionLost
+	}
+	return d.board.RSSI, nil
+}
+
+// PHY implements esp32.PHYController.
+func (d *device) PHY() (esp32.PHY, esp32.PHY, error) {
+	d.board.mu.Lock()
+	defer d.board.mu.Unlock()
+	if !d.board.connected {
+		return 0, 0, ErrConnectionLost
+	}
+	return d.board.phy, d.board.phy, nil
+}
+
+// SetPHY implements esp32.PHYController, refusing PHYs not in the
+// board's PHYs like a remote without the feature.
+func (d *device) SetPHY(p esp32.PHY) (esp32.PHY, esp32.PHY, error) {
+	d.board.mu.Lock()
+	defer d.board.mu.Unlock()
+	if !d.board.connected {
+		return 0, 0, ErrConnectionLost
+	}
+	if !slices.Contains(d.board.PHYs, p) {
+		return 0, 0, fmt.Errorf("mock: board does not support the %s PHY", p)
+	}
+	d.board.phy = p
+	return p, p, nil
+}
+
+type service struct {
+	board *Board
+}
+
+func (s service) UUID() string {
+	return esp32.PinServiceUUID
+}
+
+func (s service) DiscoverCharacteristics() ([]esp32.Characteristic, error) {
+	chars := []esp32.Characteristic{
+		&characteristic{board: s.board, uuid: esp32.PinDataOutputUUID},
+		&characteristic{board: s.board, uuid: esp32.ADCDataOutputUUID},
+		&characteristic{board: s.board, uuid: esp32.PinDataInputUUID},
+	}
+	uuids := append(s.board.otaUUIDs(), s.board.downloadUUIDs()...)
+	uuids = append(uuids, s.board.selfTestUUIDs()...)
+	uuids = append(uuids, s.board.scheduleUUIDs()...)
+	uuids = append(uuids, s.board.serialNumberUUIDs()...)
+	for _, uuid := range append(uuids, s.board.benchUUIDs()...) {
+		chars = append(chars, &characteristic{board: s.board, uuid: uuid})
+	}
+	return chars, nil
+}
+
+type characteristic struct {
+	board *Board
+	uuid  string
+}
+
+func (c *characteristic) UUID() string {
+	return c.uuid
+}
+
+func (c *characteristic) Read(buf []byte) (int, error) {
+	b := c.board
+	if !b.Connected() {
+		return 0, errors.New("mock: not connected")
+	}
+	if err := b.authorized(); err != nil {
+		return 0, err
+	}
+	frame, err := b.applyFault()
+	if err != nil {
+		return 0, err
+	}
+	if frame != nil {
+		return copy(buf, frame), nil
+	}
+	if p, ok := b.otaProgress(c.uuid); ok {
+		return copy(buf, p), nil
+	}
+	if p, ok := b.scheduleClock(c.uuid); ok {
+		return copy(buf, p), nil
+	}
+	if p, ok := b.serialNumber(c.uuid); ok {
+		return copy(buf, p), nil
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.connected {
+		return 0, errors.New("mock: not connected")
+	}
+	switch c.uuid {
+	case esp32.PinDataOutputUUID:
+		return copy(buf, b.pinFrame()), nil
+	case esp32.ADCDataOutputUUID:
+		return copy(buf, b.adcFrame()), nil
+	}
+	return 0, nil
+}
+
+func (c *characteristic) Write(p []byte) (int, error) {
+	if !c.board.Connected() {
+		return 0, errors.New("mock: not connected")
+	}
+	writable := append(c.board.otaUUIDs(), c.board.selfTestUUIDs()...)
+	writable = append(writable, c.board.scheduleUUIDs()...)
+	if c.uuid != esp32.PinDataInputUUID && c.uuid != c.board.downloadControl() && !slices.Contains(writable, c.uuid) {
+		return 0, fmt.Errorf("mock: characteristic %s is not writable", c.uuid)
+	}
+	if err := c.board.authorized(); err != nil {
+		return 0, err
+	}
+	frame, err := c.board.applyFault()
+	if err != nil {
+		return 0, err
+	}
+	if frame != nil {
+		// A corrupted write arrives as garbage, which the firmware ignores.
+		p = frame
+	}
+	if !c.board.otaWrite(c.uuid, p) && !c.board.downloadWrite(c.uuid, p) && !c.board.selfTestWrite(c.uuid, p) && !c.board.scheduleWrite(c.uuid, p) && !c.board.lose() {
+		c.board.write(p)
+	}
+	return len(p), nil
+}
+
+// ErrWriteLost is returned by a write with response that LoseWrites lost,
+// as the board never acknowledges it.
+var ErrWriteLost = errors.New("mock: write lost, no response")
+
+// WriteWithResponse is Write, but a write lost on the air fails rather
+// than vanishing.
+func (c *characteristic) WriteWithResponse(p []byte) (int, error) {
+	if c.uuid == esp32.PinDataInputUUID && c.board.Connected() && c.board.lose() {
+		return 0, ErrWriteLost
+	}
+	return c.Write(p)
+}
+
+// LoseWrites makes the board miss the next n pin writes, like packets
+// lost to interference: writes without response still succeed.
+func (b *Board) LoseWrites(n int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.lost = n
+}
+
+// lose reports whether a pin write is lost, counting it if so.
+func (b *Board) lose() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.lost == 0 {
+		return false
+	}
+	b.lost--
+	return true
+}
+
+func (c *characteristic) EnableNotifications(callback func(buf []byte)) error {
+	b := c.board
+	ota := b.otaUUIDs()
+	control := b.downloadControl()
+	if err := b.authorized(); callback != nil && err != nil {
+		return err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if c.uuid == esp32.PinDataInputUUID || c.uuid == control || slices.Contains(ota, c.uuid) {
+		return fmt.Errorf("mock: characteristic %s does not notify", c.uuid)
+	}
+	if callback == nil {
+		delete(b.notify, c.uuid)
+		b.disabled = append(b.disabled, c.uuid)
+		return nil
+	}
+	b.notify[c.uuid] = append(b.notify[c.uuid], callback)
+	return nil
+}
+
+func (c *characteristic) MTU() (uint16, error) {
+	if !c.board.Connected() {
+		return 0, errors.New("mock: not connected")
+	}
+	return c.board.MTU, nil
+}
@@ -0,0 +1,143 @@
+package mock
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+)
+
+// ErrNoImage is returned by OTAImage before an upload has completed.
+var ErrNoImage = errors.New("mock: no firmware image received")
+
+// ota is a board's OTA receiver, checking uploads the way
+// esp32.UploadFirmware sends them.
+type ota struct {
+	data, control string
+	next          uint16
+	buf           []byte
+	image         []byte
+	err           error
+	// disconnectAfter, if positive, is how many more chunks are received
+	// before the connection drops.
+	disconnectAfter int
+}
+
+// EnableOTA adds OTA data and control characteristics with the given
+// UUIDs to the board's service.
+func (b *Board) EnableOTA(dataUUID, controlUUID string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.ota = &ota{data: dataUUID, control: controlUUID, err: ErrNoImage}
+}
+
+// OTAImage returns the last image whose upload passed the CRC32 check,
+// or why the last upload failed.
+func (b *Board) OTAImage() ([]byte, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.ota == nil {
+		return nil, ErrNoImage
+	}
+	return b.ota.image, b.ota.err
+}
+
+// DisconnectDuringOTA makes the connection drop once the board has
+// received n more OTA chunks, keeping the partial image for a resume.
+func (b *Board) DisconnectDuringOTA(n int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.ota.disconnectAfter = n
+}
+
+// otaUUIDs returns the OTA characteristics' UUIDs, if enabled.
+func (b *Board) otaUUIDs() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.ota == nil {
+		return nil
+	}
+	return []string{b.ota.data, b.ota.control}
+}
+
+// otaWrite handles a write to an OTA characteristic, reporting whether
+// uuid was one.
+func (b *Board) otaWrite(uuid string, p []byte) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	o := b.ota
+	switch {
+	case o == nil:
+		return false
+	case uuid == o.data:
+		o.chunk(p)
+		if o.disconnectAfter > 0 {
+			if o.disconnectAfter--; o.disconnectAfter == 0 {
+				b.dropLocked()
+			}
+		}
+	case uuid == o.control:
+		o.finish(p)
+	default:
+		return false
+	}
+	return true
+}
+
+func (o *ota) chunk(p []byte) {
+	if len(p) < 2 {
+		o.fail(fmt.Errorf("mock: OTA chunk of %d bytes has no sequence number", len(p)))
+		return
+	}
+	seq := binary.LittleEndian.Uint16(p)
+	if seq == 0 {
+		// A new upload discards any partial one, which is otherwise kept
+		// across disconnects for the upload to resume.
+		o.buf, o.err, o.next = nil, nil, 0
+	}
+	if o.err != nil {
+		return
+	}
+	if seq != o.next {
+		o.fail(fmt.Errorf("mock: OTA chunk %d out of order, want %d", seq, o.next))
+		return
+	}
+	o.buf = append(o.buf, p[2:]...)
+	o.next++
+}
+
+func (o *ota) finish(p []byte) {
+	defer func() { o.next, o.buf = 0, nil }()
+	if o.err != nil {
+		return
+	}
+	if len(p) != 8 {
+		o.fail(fmt.Errorf("mock: OTA control write of %d bytes, want 8", len(p)))
+		return
+	}
+	crc, size := binary.LittleEndian.Uint32(p), binary.LittleEndian.Uint32(p[4:])
+	switch {
+	case int(size) != len(o.buf):
+		o.fail(fmt.Errorf("mock: OTA image is %d bytes, want %d", len(o.buf), size))
+	case crc32.ChecksumIEEE(o.buf) != crc:
+		o.fail(fmt.Errorf("mock: OTA image CRC32 mismatch"))
+	default:
+		o.image = o.buf
+	}
+}
+
+// otaProgress returns what the OTA control characteristic reads: the
+// partial image's length and CRC32, reporting whether uuid was it.
+func (b *Board) otaProgress(uuid string) ([]byte, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.ota == nil || uuid != b.ota.control {
+		return nil, false
+	}
+	p := binary.LittleEndian.AppendUint32(nil, uint32(len(b.ota.buf)))
+	return binary.LittleEndian.AppendUint32(p, crc32.ChecksumIEEE(b.ota.buf)), true
+}
+
+func (o *ota) fail(err error) {
+	o.err, o.image, o.next = err, nil, 0
+}
@@ -0,0 +1,77 @@
+package mock
+
+import (
+	"errors"
+	"fmt"
+
+	"bluetooth/esp32"
+)
+
+// ErrInsufficientAuthentication is returned by operations on a board that
+// requires pairing before the host has paired with it.
+var ErrInsufficientAuthentication = errors.New("mock: insufficient authentication")
+
+// Security makes a board's characteristics require an authenticated link.
+type Security struct {
+	// Passkey is the 6-digit passkey the board displays.
+	Passkey uint32
+	// Compare uses numeric comparison instead of passkey entry.
+	Compare bool
+}
+
+// RequirePairing makes the board refuse GATT operations until the host
+// pairs with it. The bond survives reconnecting.
+func (b *Board) RequirePairing(s Security) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.security = &s
+}
+
+// authorized reports ErrInsufficientAuthentication if the board needs
+// pairing and isn't paired.
+func (b *Board) authorized() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.security != nil && !b.bonded {
+		return ErrInsufficientAuthentication
+	}
+	return nil
+}
+
+// Paired implements esp32.Pairer.
+func (d *device) Paired() (bool, error) {
+	d.board.mu.Lock()
+	defer d.board.mu.Unlock()
+	return d.board.bonded, nil
+}
+
+// Pair implements esp32.Pairer, asking agent the way the board's
+// security requires. A board without security pairs without asking.
+func (d *device) Pair(agent esp32.PairingAgent) error {
+	b := d.board
+	b.mu.Lock()
+	security := b.security
+	b.mu.Unlock()
+
+	if security != nil {
+		var ok bool
+		var err error
+		if security.Compare {
+			ok, err = agent.Confirm(b.Address, security.Passkey)
+		} else {
+			var passkey uint32
+			passkey, err = agent.Passkey(b.Address)
+			ok = passkey == security.Passkey
+		}
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("mock: authentication failed: %w", esp32.ErrPairingRejected)
+		}
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.bonded = true
+	return nil
+}
@@ -0,0 +1,109 @@
+package mock
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// DefaultSpeed is how many times faster than real time a Scenario runs
+// unless its Speed says otherwise.
+const DefaultSpeed = 1000
+
+// Scenario scripts what happens to an adapter's boards while a test
+// runs: a board dropping its link after 3s, going out of range for 10s,
+// coming back at another address. Scripts are written in the board's time
+// and played Speed times as fast, so a minute of a flaky board takes
+// milliseconds. A step can also wait for the code under test to react,
+// such as for a board to be connected again, so scenarios check what
+// happened in what order rather than racing the clock.
+type Scenario struct {
+	// Speed scales the steps' After durations; 0 means DefaultSpeed.
+	Speed float64
+	Steps []Step
+}
+
+// Step is one thing that happens in a Scenario: After the previous step,
+// in scenario time, Do runs, and then the step waits until Until, if set,
+// reports true.
+type Step struct {
+	// Name identifies the step when the scenario fails.
+	Name  string
+	After time.Duration
+	Do    func()
+	Until func() bool
+}
+
+// Wait returns a step waiting d, e.g. for the code under test to notice
+// nothing has changed.
+func Wait(d time.Duration) Step {
+	return Step{Name: fmt.Sprintf("wait %s", d), After: d}
+}
+
+// Connected returns a step waiting for b to be connected.
+func Connected(b *Board) Step {
+	return Step{Name: b.Name + " connected", Until: b.Connected}
+}
+
+// Drop returns a step dropping b's link after d.
+func Drop(b *Board, d time.Duration) Step {
+	return Step{Name: b.Name + " drops its link", After: d, Do: b.Drop}
+}
+
+// Leave returns a step taking b out of range after d.
+func Leave(b *Board, d time.Duration) Step {
+	return Step{Name: b.Name + " goes out of range", After: d, Do: func() { b.SetInRange(false) }}
+}
+
+// Return returns a step bringing b back in range after d.
+func Return(b *Board, d time.Duration) Step {
+	return Step{Name: b.Name + " comes back in range", After: d, Do: func() { b.SetInRange(true) }}
+}
+
+// Readdress returns a step changing b's address after d.
+func Readdress(b *Board, address string, d time.Duration) Step {
+	return Step{Name: b.Name + " moves to " + address, After: d, Do: func() { b.SetAddress(address) }}
+}
+
+// PowerCycle returns a step switching a's radio off after d and on again
+// off later.
+func PowerCycle(a *Adapter, d, off time.Duration) []Step {
+	return []Step{
+		{Name: "adapter powered off", After: d, Do: func() { a.SetPowered(false) }},
+		{Name: "adapter powered on", After: off, Do: func() { a.SetPowered(true) }},
+	}
+}
+
+// Run plays the steps in order, returning an error naming the step that
+// was running if ctx is done first.
+func (s *Scenario) Run(ctx context.Context) error {
+	speed := s.Speed
+	if speed <= 0 {
+		speed = DefaultSpeed
+	}
+	for i, step := range s.Steps {
+		if err := s.sleep(ctx, time.Duration(float64(step.After)/speed)); err != nil {
+			return fmt.Errorf("step %d (%s): %w", i+1, step.Name, err)
+		}
+		if step.Do != nil {
+			step.Do()
+		}
+		for step.Until != nil && !step.Until() {
+			if err := s.sleep(ctx, time.Millisecond); err != nil {
+				return fmt.Errorf("step %d (%s): %w", i+1, step.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+func (s *Scenario) sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
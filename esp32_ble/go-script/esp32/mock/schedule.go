@@ -0,0 +1,84 @@
+package mock
+
+import (
+	"encoding/binary"
+	"time"
+)
+
+// schedule is a board's clock and the pin writes scheduled on it,
+// answering requests the way esp32.Client.ScheduleWrites makes them.
+type schedule struct {
+	uuid    string
+	boot    time.Time
+	pending []*time.Timer
+	applied []time.Time
+}
+
+// EnableSchedule adds a schedule characteristic with the given UUID to
+// the board's service, its clock counting from uptime ago, as if the
+// board had booted then.
+func (b *Board) EnableSchedule(uuid string, uptime time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.schedule = &schedule{uuid: uuid, boot: time.Now().Add(-uptime)}
+}
+
+// Applied returns the times the board applied scheduled writes at.
+func (b *Board) Applied() []time.Time {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.schedule == nil {
+		return nil
+	}
+	return append([]time.Time{}, b.schedule.applied...)
+}
+
+// scheduleUUIDs returns the schedule characteristic's UUID, if enabled.
+func (b *Board) scheduleUUIDs() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.schedule == nil {
+		return nil
+	}
+	return []string{b.schedule.uuid}
+}
+
+// scheduleClock returns the board's clock if uuid is the schedule
+// characteristic.
+func (b *Board) scheduleClock(uuid string) ([]byte, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	s := b.schedule
+	if s == nil || uuid != s.uuid {
+		return nil, false
+	}
+	return binary.LittleEndian.AppendUint64(nil, uint64(time.Since(s.boot).Microseconds())), true
+}
+
+// scheduleWrite handles a write to the schedule characteristic, reporting
+// whether uuid was it.
+func (b *Board) scheduleWrite(uuid string, p []byte) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	s := b.schedule
+	if s == nil || uuid != s.uuid {
+		return false
+	}
+	switch {
+	case len(p) == 1 && p[0] == 0x02:
+		for _, t := range s.pending {
+			t.Stop()
+		}
+		s.pending = nil
+	case len(p) > 9 && p[0] == 0x01:
+		at := s.boot.Add(time.Duration(binary.LittleEndian.Uint64(p[1:9])) * time.Microsecond)
+		request := append([]byte{}, p[9:]...)
+		s.pending = append(s.pending, time.AfterFunc(time.Until(at), func() {
+			b.write(request)
+			b.mu.Lock()
+			s.applied = append(s.applied, time.Now())
+			b.mu.Unlock()
+		}))
+	}
+	return true
+}
@@ -0,0 +1,72 @@
+package mock
+
+import "bluetooth/esp32"
+
+// selfTest is a board's built-in diagnostics, answering requests the way
+// esp32.Client.SelfTest makes them.
+type selfTest struct {
+	uuid    string
+	results map[esp32.SelfTest]esp32.SelfTestResult
+}
+
+// EnableSelfTest adds a self-test characteristic with the given UUID to
+// the board's service. Every test passes until SetSelfTestResult says
+// otherwise.
+func (b *Board) EnableSelfTest(uuid string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.selfTest = &selfTest{uuid: uuid, results: map[esp32.SelfTest]esp32.SelfTestResult{
+		esp32.SelfTestGPIOLoopback: {Test: esp32.SelfTestGPIOLoopback, Detail: "4 pins read back"},
+		esp32.SelfTestADCReference: {Test: esp32.SelfTestADCReference, Detail: "1100 mV, calibrated 1100 mV"},
+		esp32.SelfTestMemory:       {Test: esp32.SelfTestMemory, Detail: "163840 bytes verified"},
+	}}
+}
+
+// SetSelfTestResult sets what the board reports when it runs test.
+func (b *Board) SetSelfTestResult(test esp32.SelfTest, status esp32.SelfTestStatus, detail string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.selfTest.results[test] = esp32.SelfTestResult{Test: test, Status: status, Detail: detail}
+}
+
+// selfTestUUIDs returns the self-test characteristic's UUID, if enabled.
+func (b *Board) selfTestUUIDs() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.selfTest == nil {
+		return nil
+	}
+	return []string{b.selfTest.uuid}
+}
+
+// selfTestWrite handles a write to the self-test characteristic,
+// reporting whether uuid was it. The requested tests' results are
+// notified before it returns.
+func (b *Board) selfTestWrite(uuid string, p []byte) bool {
+	b.mu.Lock()
+	s := b.selfTest
+	if s == nil || uuid != s.uuid {
+		b.mu.Unlock()
+		return false
+	}
+	var send [][]byte
+	if len(p) == 2 && p[0] == 0x01 {
+		for _, t := range esp32.SelfTests {
+			if p[1]&(1<<t) == 0 {
+				continue
+			}
+			r := s.results[t]
+			send = append(send, append([]byte{byte(t), byte(r.Status), byte(len(r.Detail))}, r.Detail...))
+		}
+		send = append(send, []byte{0, byte(len(send))})
+	}
+	subs := append([]func([]byte){}, b.notify[s.uuid]...)
+	b.mu.Unlock()
+
+	for _, frame := range send {
+		for _, fn := range subs {
+			fn(frame)
+		}
+	}
+	return true
+}
@@ -0,0 +1,113 @@
+package mock
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"bluetooth/esp32"
+)
+
+// ServeSerial runs the board's end of the esp32 serial protocol on rw,
+// as if the board were plugged in over USB, until rw is closed or the
+// host hangs up. Requests go through the same characteristics as a BLE
+// connection, so faults, pairing and lost writes apply alike.
+func (b *Board) ServeSerial(rw io.ReadWriteCloser) error {
+	defer rw.Close()
+	b.mu.Lock()
+	b.connected = true
+	b.phy = esp32.PHY1M
+	b.mu.Unlock()
+	d := &device{board: b}
+	defer d.Disconnect()
+
+	var mu sync.Mutex
+	send := func(format string, args ...any) {
+		mu.Lock()
+		defer mu.Unlock()
+		fmt.Fprintf(rw, format+"\n", args...)
+	}
+	chars := map[string]esp32.Characteristic{}
+	services, _ := d.DiscoverServices()
+	listing := make([]string, len(services))
+	for i, s := range services {
+		cs, _ := s.DiscoverCharacteristics()
+		uuids := []string{s.UUID()}
+		for _, c := range cs {
+			chars[c.UUID()] = c
+			uuids = append(uuids, c.UUID())
+		}
+		listing[i] = "C " + strings.Join(uuids, " ")
+	}
+
+	scanner := bufio.NewScanner(rw)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if fields[0] == "I" {
+			send("I %s", b.Name)
+			continue
+		}
+		if fields[0] == "L" {
+			for _, line := range listing {
+				send("%s", line)
+			}
+			send("K")
+			continue
+		}
+		if len(fields) < 2 {
+			send("E malformed request")
+			continue
+		}
+		c, ok := chars[fields[1]]
+		if !ok {
+			send("E no characteristic %s", fields[1])
+			continue
+		}
+		if err := serveRequest(c, fields, send); err != nil {
+			send("E %v", err)
+		}
+	}
+	return scanner.Err()
+}
+
+// serveRequest answers an R, W or S request for c.
+func serveRequest(c esp32.Characteristic, fields []string, send func(string, ...any)) error {
+	switch {
+	case fields[0] == "R":
+		buf := make([]byte, 512)
+		n, err := c.Read(buf)
+		if err != nil {
+			return err
+		}
+		send("D %s %s", c.UUID(), hex.EncodeToString(buf[:n]))
+	case fields[0] == "W" && len(fields) == 3:
+		p, err := hex.DecodeString(fields[2])
+		if err != nil {
+			return err
+		}
+		if _, err := c.(*characteristic).WriteWithResponse(p); err != nil {
+			return err
+		}
+		send("K")
+	case fields[0] == "S" && len(fields) == 3:
+		var callback func([]byte)
+		if fields[2] == "1" {
+			callback = func(buf []byte) {
+				send("N %s %s", c.UUID(), hex.EncodeToString(buf))
+			}
+		}
+		if err := c.EnableNotifications(callback); err != nil {
+			return err
+		}
+		send("K")
+	default:
+		return fmt.Errorf("malformed request %q", strings.Join(fields, " "))
+	}
+	return nil
+}
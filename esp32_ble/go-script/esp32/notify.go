@@ -0,0 +1,247 @@
+package esp32
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// notifyQueueSize bounds how many notifications wait for a slow
+// subscriber before the oldest are dropped.
+const notifyQueueSize = 32
+
+// parallelWorkers is the default SubscribeOptions.Workers.
+const parallelWorkers = 4
+
+// Ordering is how a subscription's notifications are handled relative to
+// one another.
+type Ordering uint8
+
+const (
+	// Ordered handles a characteristic's notifications one at a time, in
+	// the order the board sent them, so subscribers that keep state
+	// between samples, such as rates of change or running totals, see
+	// them as sent. It is the default.
+	Ordered Ordering = iota
+	// Parallel decodes notifications in order but hands the readings to
+	// several goroutines, for subscribers whose handling of each sample
+	// is slow and stands alone, such as forwarding it over a network.
+	// Readings may then be handled out of order; their Seq tells.
+	Parallel
+)
+
+func (o Ordering) String() string {
+	switch o {
+	case Ordered:
+		return "ordered"
+	case Parallel:
+		return "parallel"
+	}
+	return fmt.Sprintf("Ordering(%d)", uint8(o))
+}
+
+// SubscribeOptions configures Subscribe.
+type SubscribeOptions struct {
+	Ordering Ordering
+	// Workers is how many readings a Parallel subscription handles at
+	// once, 4 by default.
+	Workers int
+}
+
+// NotifyStats counts a client's notification traffic since it connected.
+type NotifyStats struct {
+	// Notifications and Bytes count what the board sent.
+	Notifications uint64
+	Bytes         uint64
+	// Delivered counts notifications handed to subscribers.
+	Delivered uint64
+	// Dropped counts notifications discarded because a subscriber fell
+	// more than notifyQueueSize behind.
+	Dropped uint64
+	// Throttled counts notifications discarded by the client's limit.
+	Throttled uint64
+	// Undecodable counts notifications whose frames their decoder
+	// rejected.
+	Undecodable uint64
+	// Since is when the client connected.
+	Since time.Time
+}
+
+// Rate returns the notifications per second the board has sent.
+func (s NotifyStats) Rate() float64 {
+	elapsed := time.Since(s.Since).Seconds()
+	if elapsed <= 0 {
+		return 0
+	}
+	return float64(s.Notifications) / elapsed
+}
+
+// notification is a received value waiting to be delivered.
+type notification struct {
+	buf []byte
+	at  time.Time
+	// seq numbers the subscription's notifications from 1 as they
+	// arrive; a gap is notifications dropped for a slow subscriber.
+	seq uint64
+}
+
+// subscription delivers one characteristic's notifications to its
+// subscriber on a goroutine of its own, so a slow subscriber only holds up
+// its own queue and never the Bluetooth stack's callback, which other
+// boards' notifications share.
+type subscription struct {
+	char  Characteristic
+	queue chan notification
+	done  chan struct{}
+
+	mu     sync.Mutex
+	closed bool
+	seq    uint64
+}
+
+// newSubscription starts delivering char's notifications to deliver, in
+// order. deliver returns what is left of handling the notification, if
+// anything: with workers above zero, that runs on one of as many
+// goroutines, in whatever order they get to it, and otherwise straight
+// after deliver.
+func newSubscription(char Characteristic, workers int, deliver func(notification) func()) *subscription {
+	s := &subscription{
+		char:  char,
+		queue: make(chan notification, notifyQueueSize),
+		done:  make(chan struct{}),
+	}
+	handle := func(fn func()) { fn() }
+	var handlers chan func()
+	var running sync.WaitGroup
+	if workers > 0 {
+		handlers = make(chan func())
+		for range workers {
+			running.Add(1)
+			goTracked(func() {
+				defer running.Done()
+				for fn := range handlers {
+					fn()
+				}
+			})
+		}
+		// A free worker takes each in turn, so while all are busy the
+		// queue backs up and drops as it would for one slow subscriber.
+		handle = func(fn func()) { handlers <- fn }
+	}
+	goTracked(func() {
+		defer close(s.done)
+		for n := range s.queue {
+			if fn := deliver(n); fn != nil {
+				handle(fn)
+			}
+		}
+		if handlers != nil {
+			close(handlers)
+			running.Wait()
+		}
+	})
+	return s
+}
+
+// push queues n, dropping the oldest queued notification if the queue is
+// full. It reports whether one was dropped.
+func (s *subscription) push(n notification) (dropped bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return false
+	}
+	s.seq++
+	n.seq = s.seq
+	for {
+		select {
+		case s.queue <- n:
+			return dropped
+		default:
+		}
+		select {
+		case <-s.queue:
+			dropped = true
+		default:
+		}
+	}
+}
+
+// stop disables notifications and waits for queued ones to be delivered
+// and handled.
+// It must not be called from the subscriber.
+func (s *subscription) stop() error {
+	err := s.char.EnableNotifications(nil)
+	s.mu.Lock()
+	if !s.closed {
+		s.closed = true
+		close(s.queue)
+	}
+	s.mu.Unlock()
+	<-s.done
+	return err
+}
+
+// limiter is a token bucket capping a client's notification rate.
+type limiter struct {
+	rate   float64 // tokens per second; 0 means unlimited
+	tokens float64
+	last   time.Time
+}
+
+func (l *limiter) allow(now time.Time) bool {
+	if l.rate <= 0 {
+		return true
+	}
+	burst := max(l.rate, 1)
+	l.tokens = min(burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	l.last = now
+	if l.tokens < 1 {
+		return false
+	}
+	l.tokens--
+	return true
+}
+
+// NotifyStats returns the client's notification counters.
+func (c *Client) NotifyStats() NotifyStats {
+	c.statsMu.Lock()
+	defer c.statsMu.Unlock()
+	return c.stats
+}
+
+// LimitNotifications caps how many notifications per second, across all
+// of the client's subscriptions, are passed to subscribers; the rest are
+// counted as throttled. A rate of zero or less removes the cap. With many
+// boards sharing a host, this keeps one chatty board from crowding out
+// the others' processing.
+func (c *Client) LimitNotifications(perSecond float64) {
+	c.statsMu.Lock()
+	defer c.statsMu.Unlock()
+	c.limit = limiter{rate: perSecond, tokens: max(perSecond, 1), last: time.Now()}
+}
+
+// received accounts for a notification and queues it on sub unless the
+// client's limit discards it.
+func (c *Client) received(sub *subscription, buf []byte) {
+	now := time.Now()
+	c.statsMu.Lock()
+	c.stats.Notifications++
+	c.stats.Bytes += uint64(len(buf))
+	allowed := c.limit.allow(now)
+	if !allowed {
+		c.stats.Throttled++
+	}
+	c.statsMu.Unlock()
+	if !allowed {
+		return
+	}
+
+	// The stack may reuse buf once the callback returns.
+	n := notification{buf: append([]byte(nil), buf...), at: now}
+	if sub.push(n) {
+		c.statsMu.Lock()
+		c.stats.Dropped++
+		c.statsMu.Unlock()
+	}
+}
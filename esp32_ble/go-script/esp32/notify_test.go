@@ -0,0 +1,155 @@
+package esp32_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"bluetooth/esp32"
+	"bluetooth/esp32/mock"
+)
+
+// connectBoard connects to board through a fresh emulated adapter.
+func connectBoard(t *testing.T, board *mock.Board) *esp32.Client {
+	t.Helper()
+	adapter := mock.NewAdapter(board)
+	result, err := esp32.FindDevice(context.Background(), adapter, board.Name, time.Second, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := esp32.Connect(context.Background(), adapter, result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+func TestSlowSubscriberDropsOldest(t *testing.T) {
+	defer verifyNoLeaks(t)
+
+	chattyBoard := mock.NewBoard("esp32-chatty", "AA:BB:CC:DD:EE:01")
+	chatty := connectBoard(t, chattyBoard)
+	quietBoard := mock.NewBoard("esp32-quiet", "AA:BB:CC:DD:EE:02")
+	quiet := connectBoard(t, quietBoard)
+
+	release := make(chan struct{})
+	if err := chatty.SubscribeADC(func([]esp32.Reading) { <-release }); err != nil {
+		t.Fatal(err)
+	}
+	got := make(chan struct{}, 1)
+	if err := quiet.SubscribeADC(func([]esp32.Reading) { got <- struct{}{} }); err != nil {
+		t.Fatal(err)
+	}
+
+	// The chatty board's subscriber is stuck; its notifications pile up
+	// without holding up the other board.
+	for range 100 {
+		chattyBoard.Notify()
+	}
+	quietBoard.Notify()
+	select {
+	case <-got:
+	case <-time.After(time.Second):
+		t.Fatal("quiet board's notification was not delivered")
+	}
+
+	stats := chatty.NotifyStats()
+	if stats.Notifications != 100 || stats.Dropped == 0 {
+		t.Errorf("chatty stats = %+v, want 100 notifications and some dropped", stats)
+	}
+	close(release)
+	chatty.Disconnect()
+	quiet.Disconnect()
+}
+
+func TestLimitNotifications(t *testing.T) {
+	defer verifyNoLeaks(t)
+
+	board := mock.NewBoard("esp32-test", "AA:BB:CC:DD:EE:01")
+	client := connectBoard(t, board)
+	client.LimitNotifications(2)
+	if err := client.SubscribeADC(func([]esp32.Reading) {}); err != nil {
+		t.Fatal(err)
+	}
+	for range 10 {
+		board.Notify()
+	}
+	client.Disconnect()
+
+	stats := client.NotifyStats()
+	if stats.Throttled != 8 || stats.Delivered != 2 {
+		t.Errorf("stats = %+v, want 2 delivered and 8 throttled", stats)
+	}
+}
+
+func TestOrderedSubscription(t *testing.T) {
+	defer verifyNoLeaks(t)
+
+	board := mock.NewBoard("esp32-test", "AA:BB:CC:DD:EE:01")
+	client := connectBoard(t, board)
+	var got []esp32.Reading
+	err := client.Subscribe(client.Profile().ADCOutputUUID, esp32.SubscribeOptions{}, func(r []esp32.Reading) {
+		// A slow subscriber must still see every sample in turn.
+		time.Sleep(time.Millisecond)
+		got = append(got, r[0])
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for v := range uint16(20) {
+		board.SetADC(35, v)
+		board.Notify()
+	}
+	client.Disconnect()
+
+	if len(got) != 20 {
+		t.Fatalf("got %d readings, want 20", len(got))
+	}
+	for i, r := range got {
+		if r.Seq != uint64(i+1) || r.Value != i {
+			t.Errorf("reading %d = seq %d value %d, want seq %d value %d", i, r.Seq, r.Value, i+1, i)
+		}
+	}
+}
+
+func TestParallelSubscription(t *testing.T) {
+	defer verifyNoLeaks(t)
+
+	board := mock.NewBoard("esp32-test", "AA:BB:CC:DD:EE:01")
+	client := connectBoard(t, board)
+	started := make(chan esp32.Reading, 4)
+	release := make(chan struct{})
+	opts := esp32.SubscribeOptions{Ordering: esp32.Parallel, Workers: 4}
+	err := client.Subscribe(client.Profile().ADCOutputUUID, opts, func(r []esp32.Reading) {
+		started <- r[0]
+		<-release
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for v := range uint16(4) {
+		board.SetADC(35, v)
+		board.Notify()
+	}
+
+	// All four are handled at once, each with the value of its frame.
+	seen := map[uint64]int{}
+	for range 4 {
+		select {
+		case r := <-started:
+			seen[r.Seq] = r.Value
+		case <-time.After(time.Second):
+			t.Fatalf("only %d handled at once, want 4", len(seen))
+		}
+	}
+	close(release)
+	client.Disconnect()
+	for seq := range uint64(4) {
+		if v, ok := seen[seq+1]; !ok || v != int(seq) {
+			t.Errorf("seq %d = %d (handled %t), want %d", seq+1, v, ok, seq)
+		}
+	}
+	if stats := client.NotifyStats(); stats.Delivered != 4 {
+		t.Errorf("Delivered = %d, want 4", stats.Delivered)
+	}
+}
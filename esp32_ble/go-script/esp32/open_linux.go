@@ -0,0 +1,13 @@
+//go:build linux
+
+package esp32
+
+import (
+	"tinygo.org/x/bluetooth"
+)
+
+// OpenBLEAdapter returns an Adapter for the BlueZ adapter named id (e.g.
+// "hci1").
+func OpenBLEAdapter(id string) (Adapter, error) {
+	return &bleAdapter{adapter: bluetooth.NewAdapter(id), id: id, seen: map[string]bluetooth.Address{}}, nil
+}
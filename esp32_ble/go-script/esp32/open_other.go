@@ -0,0 +1,18 @@
+//go:build !linux
+
+package esp32
+
+import (
+	"fmt"
+
+	"tinygo.org/x/bluetooth"
+)
+
+// OpenBLEAdapter returns the default adapter; choosing another by name
+// is only possible with BlueZ.
+func OpenBLEAdapter(id string) (Adapter, error) {
+	if id != "" {
+		return nil, fmt.Errorf("selecting adapter %q: only supported on Linux", id)
+	}
+	return NewBLEAdapter(bluetooth.DefaultAdapter), nil
+}
@@ -0,0 +1,144 @@
+package esp32
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+)
+
+// OTA upload wire format. The image is written to the data characteristic
+// in chunks, each prefixed with a 16-bit little-endian sequence number
+// counting from zero (wrapping at 65536) so the board can detect lost or
+// reordered writes. Then the control characteristic is written with the
+// image's IEEE CRC32 and length, both 32-bit little-endian, and the board
+// checks them before switching to the new image.
+//
+// Boards that can resume an interrupted upload keep the partial image
+// across disconnects, appending chunks whose sequence number continues
+// it (chunk 0 starts over), and make the control characteristic readable:
+// the partial image's length and CRC32, both 32-bit little-endian.
+const (
+	otaSeqSize = 2
+	// attWriteOverhead is the ATT opcode and handle in front of a write.
+	attWriteOverhead = 3
+)
+
+// OTAOptions configures UploadFirmware.
+type OTAOptions struct {
+	// DataUUID is the characteristic image chunks are written to.
+	DataUUID string
+	// ControlUUID is the characteristic the final CRC32 is written to.
+	ControlUUID string
+	// ChunkSize is the image bytes per write. By default it is as large
+	// as the negotiated MTU allows.
+	ChunkSize int
+	// Offset resumes an interrupted upload made with the same ChunkSize:
+	// the image before it isn't sent and chunks are numbered as if it had
+	// been. See ResumableOTAOffset.
+	Offset int
+	// Progress, if set, is called after each chunk with the bytes sent.
+	Progress func(sent, total int)
+}
+
+// OTAChunkSize returns the most image bytes a single write can carry at
+// the given ATT MTU.
+func OTAChunkSize(mtu int) int {
+	return mtu - attWriteOverhead - otaSeqSize
+}
+
+// UploadFirmware streams image to the board's OTA characteristics. It
+// stops at the first failed write or when ctx is done; the board either
+// discards the partial upload or keeps it for a resume from Offset.
+func (c *Client) UploadFirmware(ctx context.Context, image []byte, opts OTAOptions) error {
+	if len(image) == 0 {
+		return errors.New("empty firmware image")
+	}
+	if opts.Offset != 0 && (opts.ChunkSize <= 0 || opts.Offset%opts.ChunkSize != 0 || opts.Offset > len(image)) {
+		return fmt.Errorf("OTA offset %d is not a chunk boundary of the image", opts.Offset)
+	}
+	data, err := c.Characteristic(opts.DataUUID)
+	if err != nil {
+		return err
+	}
+	control, err := c.Characteristic(opts.ControlUUID)
+	if err != nil {
+		return err
+	}
+
+	size := opts.ChunkSize
+	if size <= 0 {
+		mtu, err := await(ctx, func() (uint16, error) {
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			return data.MTU()
+		}, nil)
+		if err != nil {
+			return fmt.Errorf("reading MTU: %w", err)
+		}
+		if size = OTAChunkSize(int(mtu)); size <= 0 {
+			return fmt.Errorf("MTU %d is too small for OTA", mtu)
+		}
+	}
+
+	frame := make([]byte, otaSeqSize+size)
+	for sent, seq := opts.Offset, uint16(opts.Offset/size); sent < len(image); seq++ {
+		n := copy(frame[otaSeqSize:], image[sent:])
+		binary.LittleEndian.PutUint16(frame, seq)
+		if err := c.write(ctx, data, frame[:otaSeqSize+n]); err != nil {
+			return fmt.Errorf("writing chunk %d: %w", seq, err)
+		}
+		sent += n
+		if opts.Progress != nil {
+			opts.Progress(sent, len(image))
+		}
+	}
+
+	trailer := binary.LittleEndian.AppendUint32(nil, crc32.ChecksumIEEE(image))
+	trailer = binary.LittleEndian.AppendUint32(trailer, uint32(len(image)))
+	if err := c.write(ctx, control, trailer); err != nil {
+		return fmt.Errorf("writing CRC32: %w", err)
+	}
+	return nil
+}
+
+// ResumableOTAOffset returns how much of image the board already has
+// from an interrupted upload in opts.ChunkSize chunks, as it reports on
+// the control characteristic: the partial image's length if it ends on a
+// chunk boundary and its CRC32 matches image's, else 0. It fails if the
+// board doesn't report its progress.
+func (c *Client) ResumableOTAOffset(ctx context.Context, image []byte, opts OTAOptions) (int, error) {
+	control, err := c.Characteristic(opts.ControlUUID)
+	if err != nil {
+		return 0, err
+	}
+	buf := make([]byte, 8)
+	n, err := await(ctx, func() (int, error) {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return control.Read(buf)
+	}, nil)
+	if err != nil {
+		return 0, fmt.Errorf("reading OTA progress: %w", err)
+	}
+	if n != len(buf) {
+		return 0, fmt.Errorf("OTA progress of %d bytes, want %d", n, len(buf))
+	}
+	received := int(binary.LittleEndian.Uint32(buf))
+	if opts.ChunkSize <= 0 || received > len(image) || received%opts.ChunkSize != 0 ||
+		crc32.ChecksumIEEE(image[:received]) != binary.LittleEndian.Uint32(buf[4:]) {
+		return 0, nil
+	}
+	return received, nil
+}
+
+// write writes p to char, serialized with the client's other operations.
+func (c *Client) write(ctx context.Context, char Characteristic, p []byte) error {
+	_, err := await(ctx, func() (int, error) {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return char.Write(p)
+	}, nil)
+	return err
+}
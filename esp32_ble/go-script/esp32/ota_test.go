@@ -0,0 +1,131 @@
+package esp32_test
+
+import (
+	"bytes"
+	"context"
+	"math/rand"
+	"testing"
+
+	"bluetooth/esp32"
+	"bluetooth/esp32/mock"
+)
+
+const (
+	otaDataUUID    = "5a1d0000-0000-4000-8000-000000000001"
+	otaControlUUID = "5a1d0000-0000-4000-8000-000000000002"
+)
+
+func TestUploadFirmware(t *testing.T) {
+	defer verifyNoLeaks(t)
+
+	image := make([]byte, 1000)
+	rand.New(rand.NewSource(1)).Read(image)
+
+	for _, tc := range []struct {
+		name      string
+		chunkSize int
+		writes    int
+	}{
+		{"mtu", 0, 5}, // 242 bytes per chunk at the mock's MTU of 247
+		{"chunk-size", 64, 16},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			board := mock.NewBoard("esp32-test", "AA:BB:CC:DD:EE:01")
+			board.EnableOTA(otaDataUUID, otaControlUUID)
+			client := connectBoard(t, board)
+			defer client.Disconnect()
+
+			var progress []int
+			err := client.UploadFirmware(context.Background(), image, esp32.OTAOptions{
+				DataUUID:    otaDataUUID,
+				ControlUUID: otaControlUUID,
+				ChunkSize:   tc.chunkSize,
+				Progress: func(sent, total int) {
+					if total != len(image) {
+						t.Errorf("progress total = %d, want %d", total, len(image))
+					}
+					progress = append(progress, sent)
+				},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(progress) != tc.writes || progress[len(progress)-1] != len(image) {
+				t.Errorf("progress = %v, want %d steps ending at %d", progress, tc.writes, len(image))
+			}
+			got, err := board.OTAImage()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, image) {
+				t.Error("board received a different image")
+			}
+		})
+	}
+}
+
+func TestUploadFirmwareWithoutOTA(t *testing.T) {
+	defer verifyNoLeaks(t)
+
+	client := connectBoard(t, mock.NewBoard("esp32-test", "AA:BB:CC:DD:EE:01"))
+	defer client.Disconnect()
+	err := client.UploadFirmware(context.Background(), []byte{1, 2, 3}, esp32.OTAOptions{
+		DataUUID:    otaDataUUID,
+		ControlUUID: otaControlUUID,
+	})
+	if err == nil {
+		t.Fatal("UploadFirmware succeeded on a board without OTA characteristics")
+	}
+}
+
+func TestResumeFirmwareUpload(t *testing.T) {
+	defer verifyNoLeaks(t)
+
+	image := make([]byte, 1000)
+	rand.New(rand.NewSource(1)).Read(image)
+	board := mock.NewBoard("esp32-test", "AA:BB:CC:DD:EE:01")
+	board.EnableOTA(otaDataUUID, otaControlUUID)
+	opts := esp32.OTAOptions{DataUUID: otaDataUUID, ControlUUID: otaControlUUID, ChunkSize: 100}
+
+	// The link drops after 4 chunks; the fifth write fails.
+	board.DisconnectDuringOTA(4)
+	client := connectBoard(t, board)
+	if err := client.UploadFirmware(context.Background(), image, opts); err == nil {
+		t.Fatal("UploadFirmware succeeded over a dropped link")
+	}
+	client.Disconnect()
+
+	client = connectBoard(t, board)
+	defer client.Disconnect()
+	offset, err := client.ResumableOTAOffset(context.Background(), image, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if offset != 400 {
+		t.Fatalf("resumable offset = %d, want the 400 bytes received", offset)
+	}
+	if other, _ := client.ResumableOTAOffset(context.Background(), make([]byte, 1000), opts); other != 0 {
+		t.Errorf("resumable offset for another image = %d, want 0", other)
+	}
+
+	var first int
+	opts.Offset = offset
+	opts.Progress = func(sent, total int) {
+		if first == 0 {
+			first = sent
+		}
+	}
+	if err := client.UploadFirmware(context.Background(), image, opts); err != nil {
+		t.Fatal(err)
+	}
+	if first != 500 {
+		t.Errorf("first progress after resuming = %d, want 500", first)
+	}
+	got, err := board.OTAImage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, image) {
+		t.Error("board received a different image")
+	}
+}
@@ -0,0 +1,65 @@
+package esp32
+
+import (
+	"context"
+	"errors"
+)
+
+// PairingAgent answers the prompts raised while pairing with a board
+// whose characteristics need an encrypted, authenticated link. Which one
+// is asked depends on the board's I/O capabilities.
+type PairingAgent interface {
+	// Passkey returns the 6-digit passkey the board at address displays
+	// (passkey entry).
+	Passkey(address string) (uint32, error)
+	// Confirm reports whether passkey matches the one the board at
+	// address displays (numeric comparison).
+	Confirm(address string, passkey uint32) (bool, error)
+}
+
+// Pairer is implemented by devices that can pair and bond. The BLE
+// device implements it on Linux, where BlueZ stores the bond so later
+// connections are encrypted without pairing again.
+type Pairer interface {
+	Paired() (bool, error)
+	Pair(agent PairingAgent) error
+}
+
+// ErrPairingUnsupported is returned by Client.Pair when the platform
+// can't pair from this program.
+var ErrPairingUnsupported = errors.New("pairing is not available on this platform")
+
+// ErrPairingRejected is returned by an agent, and so by Client.Pair, when
+// the user declines or a passkey doesn't match.
+var ErrPairingRejected = errors.New("pairing rejected")
+
+// Paired reports whether the client's board is paired with the host.
+func (c *Client) Paired() (bool, error) {
+	p, ok := c.device.(Pairer)
+	if !ok {
+		return false, ErrPairingUnsupported
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return p.Paired()
+}
+
+// Pair pairs and bonds with the board, asking agent for whatever the
+// board's security requires. It does nothing if they are already paired.
+// If ctx is done first Pair returns ctx.Err(); the stack may still finish
+// or time out the pairing afterwards.
+func (c *Client) Pair(ctx context.Context, agent PairingAgent) error {
+	p, ok := c.device.(Pairer)
+	if !ok {
+		return ErrPairingUnsupported
+	}
+	_, err := await(ctx, func() (struct{}, error) {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if paired, err := p.Paired(); err != nil || paired {
+			return struct{}{}, err
+		}
+		return struct{}{}, p.Pair(agent)
+	}, nil)
+	return err
+}
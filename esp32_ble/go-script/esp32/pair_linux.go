@@ -0,0 +1,114 @@
+//go:build linux
+
+package esp32
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/godbus/dbus/v5"
+)
+
+// agentPath is where the pairing agent is exported on its connection.
+const agentPath = dbus.ObjectPath("/esp32_interfaces/agent")
+
+// objectPath is the device's BlueZ object.
+func (d bleDevice) objectPath() dbus.ObjectPath {
+	return dbus.ObjectPath("/org/bluez/" + d.adapterID + "/dev_" + strings.ReplaceAll(d.address, ":", "_"))
+}
+
+// Paired reads the device's BlueZ Paired property.
+func (d bleDevice) Paired() (bool, error) {
+	bus, err := dbus.SystemBus()
+	if err != nil {
+		return false, err
+	}
+	v, err := bus.Object("org.bluez", d.objectPath()).GetProperty("org.bluez.Device1.Paired")
+	if err != nil {
+		return false, err
+	}
+	paired, _ := v.Value().(bool)
+	return paired, nil
+}
+
+// Pair registers agent with BlueZ as an org.bluez.Agent1 and pairs. BlueZ
+// keeps the bond; the device is also marked trusted so it can reconnect
+// without prompting. The agent gets a connection of its own because BlueZ
+// allows one agent per connection and boards may pair concurrently.
+func (d bleDevice) Pair(agent PairingAgent) error {
+	bus, err := dbus.ConnectSystemBus()
+	if err != nil {
+		return err
+	}
+	defer bus.Close()
+	if err := bus.Export(bluezAgent{agent: agent, address: d.address}, agentPath, "org.bluez.Agent1"); err != nil {
+		return err
+	}
+	manager := bus.Object("org.bluez", "/org/bluez")
+	// KeyboardDisplay lets BlueZ pick passkey entry or numeric comparison
+	// to suit the board.
+	if err := manager.Call("org.bluez.AgentManager1.RegisterAgent", 0, agentPath, "KeyboardDisplay").Err; err != nil {
+		return fmt.Errorf("registering pairing agent: %w", err)
+	}
+	defer manager.Call("org.bluez.AgentManager1.UnregisterAgent", 0, agentPath)
+
+	device := bus.Object("org.bluez", d.objectPath())
+	if err := device.Call("org.bluez.Device1.Pair", 0).Err; err != nil {
+		var dbusErr dbus.Error
+		if errors.As(err, &dbusErr) && dbusErr.Name == "org.bluez.Error.AuthenticationRejected" {
+			return ErrPairingRejected
+		}
+		return fmt.Errorf("pairing: %w", err)
+	}
+	if err := device.SetProperty("org.bluez.Device1.Trusted", dbus.MakeVariant(true)); err != nil {
+		return fmt.Errorf("marking device trusted: %w", err)
+	}
+	return nil
+}
+
+// bluezAgent adapts a PairingAgent to BlueZ's org.bluez.Agent1 methods.
+type bluezAgent struct {
+	agent   PairingAgent
+	address string
+}
+
+var errRejected = dbus.NewError("org.bluez.Error.Rejected", nil)
+
+func agentError(err error) *dbus.Error {
+	if errors.Is(err, ErrPairingRejected) {
+		return errRejected
+	}
+	return dbus.MakeFailedError(err)
+}
+
+func (a bluezAgent) RequestPasskey(dbus.ObjectPath) (uint32, *dbus.Error) {
+	passkey, err := a.agent.Passkey(a.address)
+	if err != nil {
+		return 0, agentError(err)
+	}
+	return passkey, nil
+}
+
+func (a bluezAgent) RequestConfirmation(_ dbus.ObjectPath, passkey uint32) *dbus.Error {
+	ok, err := a.agent.Confirm(a.address, passkey)
+	if err != nil {
+		return agentError(err)
+	}
+	if !ok {
+		return errRejected
+	}
+	return nil
+}
+
+// RequestAuthorization is "just works" pairing, which needs no input.
+func (a bluezAgent) RequestAuthorization(dbus.ObjectPath) *dbus.Error { return nil }
+
+// The firmware never uses legacy PIN codes, so they are refused.
+func (a bluezAgent) RequestPinCode(dbus.ObjectPath) (string, *dbus.Error) { return "", errRejected }
+
+func (a bluezAgent) DisplayPinCode(dbus.ObjectPath, string) *dbus.Error         { return nil }
+func (a bluezAgent) DisplayPasskey(dbus.ObjectPath, uint32, uint16) *dbus.Error { return nil }
+func (a bluezAgent) AuthorizeService(dbus.ObjectPath, string) *dbus.Error       { return nil }
+func (a bluezAgent) Cancel() *dbus.Error                                        { return nil }
+func (a bluezAgent) Release() *dbus.Error                                       { return nil }
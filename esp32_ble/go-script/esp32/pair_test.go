@@ -0,0 +1,77 @@
+package esp32_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"bluetooth/esp32"
+	"bluetooth/esp32/mock"
+)
+
+// fixedAgent answers pairing prompts with a fixed passkey.
+type fixedAgent struct {
+	passkey uint32
+	asked   []string
+}
+
+func (a *fixedAgent) Passkey(address string) (uint32, error) {
+	a.asked = append(a.asked, "passkey")
+	return a.passkey, nil
+}
+
+func (a *fixedAgent) Confirm(address string, passkey uint32) (bool, error) {
+	a.asked = append(a.asked, "confirm")
+	return passkey == a.passkey, nil
+}
+
+func TestPair(t *testing.T) {
+	defer verifyNoLeaks(t)
+
+	for _, tc := range []struct {
+		name    string
+		compare bool
+		want    string
+	}{
+		{"passkey entry", false, "passkey"},
+		{"numeric comparison", true, "confirm"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			board := mock.NewBoard("esp32-secure", "AA:BB:CC:DD:EE:09")
+			board.RequirePairing(mock.Security{Passkey: 123456, Compare: tc.compare})
+			client := connectBoard(t, board)
+
+			if _, err := client.ReadADC(context.Background()); !errors.Is(err, mock.ErrInsufficientAuthentication) {
+				t.Fatalf("ReadADC before pairing: err = %v, want insufficient authentication", err)
+			}
+			if err := client.Pair(context.Background(), &fixedAgent{passkey: 654321}); !errors.Is(err, esp32.ErrPairingRejected) {
+				t.Fatalf("Pair with the wrong passkey: err = %v, want ErrPairingRejected", err)
+			}
+			agent := &fixedAgent{passkey: 123456}
+			if err := client.Pair(context.Background(), agent); err != nil {
+				t.Fatal(err)
+			}
+			if len(agent.asked) != 1 || agent.asked[0] != tc.want {
+				t.Errorf("agent was asked %v, want [%s]", agent.asked, tc.want)
+			}
+			if _, err := client.ReadADC(context.Background()); err != nil {
+				t.Fatalf("ReadADC after pairing: %v", err)
+			}
+			client.Disconnect()
+
+			// The bond survives reconnecting, so pairing again asks nothing.
+			client = connectBoard(t, board)
+			defer client.Disconnect()
+			agent.asked = nil
+			if err := client.Pair(context.Background(), agent); err != nil {
+				t.Fatal(err)
+			}
+			if len(agent.asked) != 0 {
+				t.Errorf("re-pairing a bonded board asked %v", agent.asked)
+			}
+			if _, err := client.ReadADC(context.Background()); err != nil {
+				t.Fatalf("ReadADC after reconnecting: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,82 @@
+package esp32
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// PHY is a Bluetooth LE physical layer. 2M doubles throughput at short
+// range (useful for burst captures); coded trades throughput for range.
+// Both need Bluetooth 5 on the adapter and the board.
+type PHY uint8
+
+const (
+	PHY1M    PHY = 1
+	PHY2M    PHY = 2
+	PHYCoded PHY = 3
+)
+
+func (p PHY) String() string {
+	switch p {
+	case PHY1M:
+		return "1M"
+	case PHY2M:
+		return "2M"
+	case PHYCoded:
+		return "coded"
+	}
+	return fmt.Sprintf("PHY(%d)", uint8(p))
+}
+
+// ParsePHY parses "1m", "2m" or "coded".
+func ParsePHY(s string) (PHY, error) {
+	switch strings.ToLower(s) {
+	case "1m":
+		return PHY1M, nil
+	case "2m":
+		return PHY2M, nil
+	case "coded":
+		return PHYCoded, nil
+	}
+	return 0, fmt.Errorf("unknown PHY %q (want 1m, 2m or coded)", s)
+}
+
+// PHYController is implemented by devices whose connection PHY can be
+// read and changed. The BLE device implements it on Linux.
+type PHYController interface {
+	PHY() (tx, rx PHY, err error)
+	// SetPHY asks for p in both directions and returns the PHYs in use
+	// once the board and adapter have negotiated.
+	SetPHY(p PHY) (tx, rx PHY, err error)
+}
+
+// ErrPHYUnsupported is returned by Client.PHY and SetPHY when the platform
+// can't control the connection PHY.
+var ErrPHYUnsupported = errors.New("PHY selection is not available on this platform")
+
+// PHY returns the connection's transmit and receive PHYs.
+func (c *Client) PHY(ctx context.Context) (tx, rx PHY, err error) {
+	return c.phy(ctx, func(pc PHYController) (PHY, PHY, error) { return pc.PHY() })
+}
+
+// SetPHY requests p for the connection and returns the PHYs in use
+// afterwards.
+func (c *Client) SetPHY(ctx context.Context, p PHY) (tx, rx PHY, err error) {
+	return c.phy(ctx, func(pc PHYController) (PHY, PHY, error) { return pc.SetPHY(p) })
+}
+
+func (c *Client) phy(ctx context.Context, op func(PHYController) (PHY, PHY, error)) (PHY, PHY, error) {
+	pc, ok := c.device.(PHYController)
+	if !ok {
+		return 0, 0, ErrPHYUnsupported
+	}
+	phys, err := await(ctx, func() ([2]PHY, error) {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		tx, rx, err := op(pc)
+		return [2]PHY{tx, rx}, err
+	}, nil)
+	return phys[0], phys[1], err
+}
@@ -0,0 +1,95 @@
+//go:build linux
+
+package esp32
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// LE controller commands (OGF 0x08) and events used for PHY control.
+const (
+	hciOpLEReadLocalFeatures = 0x08<<10 | 0x0003
+	hciOpLEReadPHY           = 0x08<<10 | 0x0030
+	hciOpLESetPHY            = 0x08<<10 | 0x0032
+	hciLEPHYUpdateComplete   = 0x0c
+
+	leFeature2MPHY    = 1 << 8
+	leFeatureCodedPHY = 1 << 11
+)
+
+// PHY reads the connection's PHYs with HCI LE Read PHY.
+func (d bleDevice) PHY() (PHY, PHY, error) {
+	s, err := openHCI(d.adapterID)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer s.Close()
+	handle, err := s.connHandle(d.address)
+	if err != nil {
+		return 0, 0, err
+	}
+	ret, err := s.command(hciOpLEReadPHY, binary.LittleEndian.AppendUint16(nil, handle))
+	if err != nil {
+		return 0, 0, err
+	}
+	// handle, TX PHY, RX PHY
+	if len(ret) < 4 {
+		return 0, 0, errShortReply
+	}
+	return PHY(ret[2]), PHY(ret[3]), nil
+}
+
+// SetPHY requests p with HCI LE Set PHY and waits for the PHY update to
+// complete, after checking the adapter supports p at all.
+func (d bleDevice) SetPHY(p PHY) (PHY, PHY, error) {
+	s, err := openHCI(d.adapterID)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer s.Close()
+
+	ret, err := s.command(hciOpLEReadLocalFeatures, nil)
+	if err != nil {
+		return 0, 0, err
+	}
+	if len(ret) < 8 {
+		return 0, 0, errShortReply
+	}
+	features := binary.LittleEndian.Uint64(ret)
+	if (p == PHY2M && features&leFeature2MPHY == 0) || (p == PHYCoded && features&leFeatureCodedPHY == 0) {
+		return 0, 0, fmt.Errorf("%w: adapter %s has no %s PHY", ErrPHYUnsupported, d.adapterID, p)
+	}
+
+	handle, err := s.connHandle(d.address)
+	if err != nil {
+		return 0, 0, err
+	}
+	// handle, all PHYs (no preference cleared), TX PHYs, RX PHYs, options
+	mask := byte(1) << (p - 1)
+	params := binary.LittleEndian.AppendUint16(nil, handle)
+	params = append(params, 0, mask, mask, 0, 0)
+	if err := s.send(hciOpLESetPHY, params, hciEvtCmdStatus, hciEvtLEMeta); err != nil {
+		return 0, 0, err
+	}
+	for {
+		code, ev, err := s.event()
+		if err != nil {
+			return 0, 0, err
+		}
+		switch {
+		case code == hciEvtCmdStatus && len(ev) >= 4 && binary.LittleEndian.Uint16(ev[2:]) == hciOpLESetPHY:
+			// status, num commands, opcode
+			if ev[0] != 0 {
+				return 0, 0, fmt.Errorf("LE Set PHY failed with status %#x", ev[0])
+			}
+		case code == hciEvtLEMeta && len(ev) >= 6 && ev[0] == hciLEPHYUpdateComplete &&
+			binary.LittleEndian.Uint16(ev[2:]) == handle:
+			// subevent, status, handle, TX PHY, RX PHY
+			if ev[1] != 0 {
+				return 0, 0, fmt.Errorf("PHY update to %s refused with status %#x", p, ev[1])
+			}
+			return PHY(ev[4]), PHY(ev[5]), nil
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package esp32
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// PinWrite sets the state of an output pin. Digital pins treat 100 as high
+// and anything else as low; PWM pins use the state as a duty cycle.
+type PinWrite struct {
+	PinNum uint8 `json:"pin_num"`
+	State  uint8 `json:"state"`
+}
+
+// PinRequest is the JSON document accepted by the pin data input
+// characteristic.
+type PinRequest struct {
+	PinWrites []PinWrite `json:"pin_writes"`
+}
+
+// EncodePinWrites encodes writes in the firmware's JSON format, e.g.
+// {"pin_writes":[{"pin_num":14,"state":100}]}.
+func EncodePinWrites(writes []PinWrite) ([]byte, error) {
+	return json.Marshal(PinRequest{PinWrites: writes})
+}
+
+// ParsePinRequest parses a pin_writes document, checking it against the
+// firmware's schema: one object holding a pin_writes list of at least one
+// write, each with a pin_num and a state from 0 to 255, and nothing else.
+// The firmware drops documents it can't parse without a word, so they are
+// best caught before sending.
+func ParsePinRequest(data []byte) ([]PinWrite, error) {
+	var doc struct {
+		PinWrites []struct {
+			PinNum *uint8 `json:"pin_num"`
+			State  *uint8 `json:"state"`
+		} `json:"pin_writes"`
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&doc); err != nil {
+		return nil, err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, errors.New("more than one JSON document")
+	}
+	if len(doc.PinWrites) == 0 {
+		return nil, errors.New("no pin_writes given")
+	}
+	writes := make([]PinWrite, len(doc.PinWrites))
+	for i, w := range doc.PinWrites {
+		switch {
+		case w.PinNum == nil:
+			return nil, fmt.Errorf("pin_writes[%d] has no pin_num", i)
+		case w.State == nil:
+			return nil, fmt.Errorf("pin_writes[%d] has no state", i)
+		}
+		writes[i] = PinWrite{PinNum: *w.PinNum, State: *w.State}
+	}
+	return writes, nil
+}
@@ -0,0 +1,32 @@
+package esp32_test
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"bluetooth/esp32"
+)
+
+func TestParsePinRequest(t *testing.T) {
+	writes, err := esp32.ParsePinRequest([]byte(`{"pin_writes":[{"pin_num":14,"state":100},{"pin_num":25,"state":0}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []esp32.PinWrite{{PinNum: 14, State: 100}, {PinNum: 25}}; !slices.Equal(writes, want) {
+		t.Errorf("ParsePinRequest() = %v, want %v", writes, want)
+	}
+
+	for doc, want := range map[string]string{
+		`{"pin_writes":[]}`:                                     "no pin_writes given",
+		`{"pin_writes":[{"pin_num":14}]}`:                       "pin_writes[0] has no state",
+		`{"pin_writes":[{"state":1}]}`:                          "pin_writes[0] has no pin_num",
+		`{"pin_writes":[{"pin_num":14,"state":256}]}`:           "cannot unmarshal number 256",
+		`{"pin_writes":[{"pin_num":14,"state":1,"ramp":true}]}`: `unknown field "ramp"`,
+		`{"pin_writes":[{"pin_num":14,"state":1}]} {}`:          "more than one JSON document",
+	} {
+		if _, err := esp32.ParsePinRequest([]byte(doc)); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("ParsePinRequest(%s) = %v, want an error containing %q", doc, err, want)
+		}
+	}
+}
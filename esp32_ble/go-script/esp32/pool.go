@@ -0,0 +1,105 @@
+package esp32
+
+import (
+	"errors"
+	"sync"
+)
+
+// ErrNoPoweredAdapter is returned by Pool.Acquire when none of the pool's
+// adapters is powered.
+var ErrNoPoweredAdapter = errors.New("no powered adapter")
+
+// ErrNoCapacity is returned by Pool.Acquire when every powered adapter is
+// at its connection limit.
+var ErrNoCapacity = errors.New("every adapter is at its connection limit")
+
+// Pool spreads connections over several adapters, each with its own
+// Manager, keeping each below a connection limit. Controllers only manage
+// a handful of concurrent links (often 5 to 10), so hosts with many boards
+// need more than one.
+type Pool struct {
+	limit    int
+	managers []*Manager
+
+	mu      sync.Mutex
+	pending map[*Manager]int // connection attempts in progress
+}
+
+// NewPool returns a pool over adapters placing at most limit connections
+// on each. A limit of zero or less means no limit.
+func NewPool(limit int, adapters ...Adapter) *Pool {
+	p := &Pool{limit: limit, pending: map[*Manager]int{}}
+	for _, a := range adapters {
+		p.managers = append(p.managers, NewManager(a))
+	}
+	return p
+}
+
+// Managers returns a manager per adapter, in the order given to NewPool.
+func (p *Pool) Managers() []*Manager {
+	return p.managers
+}
+
+// Acquire reserves a connection slot on the powered adapter with the
+// fewest connections, counting attempts in progress, and returns its
+// manager. release must be called once the attempt has finished, whether
+// or not it connected. Adapters found unpowered have their clients
+// dropped, so sessions using them reconnect through the others.
+func (p *Pool) Acquire() (*Manager, func(), error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var best *Manager
+	bestLoad, powered := 0, false
+	for _, m := range p.managers {
+		if !m.Powered() {
+			m.AdapterLost()
+			continue
+		}
+		powered = true
+		load := len(m.Clients()) + p.pending[m]
+		if p.limit > 0 && load >= p.limit {
+			continue
+		}
+		if best == nil || load < bestLoad {
+			best, bestLoad = m, load
+		}
+	}
+	if best == nil {
+		if !powered {
+			return nil, nil, ErrNoPoweredAdapter
+		}
+		return nil, nil, ErrNoCapacity
+	}
+
+	p.pending[best]++
+	var once sync.Once
+	release := func() {
+		once.Do(func() {
+			p.mu.Lock()
+			p.pending[best]--
+			p.mu.Unlock()
+		})
+	}
+	return best, release, nil
+}
+
+// Clients returns every connected client across the pool's adapters.
+func (p *Pool) Clients() []*Client {
+	var clients []*Client
+	for _, m := range p.managers {
+		clients = append(clients, m.Clients()...)
+	}
+	return clients
+}
+
+// Close disconnects every client on every adapter.
+func (p *Pool) Close() error {
+	var errs []error
+	for _, m := range p.managers {
+		if err := m.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
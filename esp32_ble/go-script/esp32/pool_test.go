@@ -0,0 +1,75 @@
+package esp32_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"bluetooth/esp32"
+	"bluetooth/esp32/mock"
+)
+
+func TestPoolAcquireSpreadsAndLimits(t *testing.T) {
+	board := mock.NewBoard("esp32-test", "AA:BB:CC:DD:EE:01")
+	pool := esp32.NewPool(1, mock.NewAdapter(board), mock.NewAdapter(board))
+
+	first, releaseFirst, err := pool.Acquire()
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, releaseSecond, err := pool.Acquire()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first == second {
+		t.Error("both slots were placed on the same adapter")
+	}
+	if _, _, err := pool.Acquire(); !errors.Is(err, esp32.ErrNoCapacity) {
+		t.Errorf("third Acquire: err = %v, want ErrNoCapacity", err)
+	}
+	releaseFirst()
+	releaseSecond()
+	if _, release, err := pool.Acquire(); err != nil {
+		t.Errorf("Acquire after release: %v", err)
+	} else {
+		release()
+	}
+}
+
+func TestSessionMovesToAnotherAdapter(t *testing.T) {
+	defer verifyNoLeaks(t)
+
+	board := mock.NewBoard("esp32-test", "AA:BB:CC:DD:EE:01")
+	primary, secondary := mock.NewAdapter(board), mock.NewAdapter(board)
+	pool := esp32.NewPool(0, primary, secondary)
+	session := &esp32.Session{
+		Pool:        pool,
+		Name:        board.Name,
+		ScanTimeout: time.Second,
+		RetryDelay:  time.Millisecond,
+	}
+
+	connects := 0
+	err := session.Run(context.Background(), func(client *esp32.Client) error {
+		connects++
+		if connects == 1 {
+			if len(pool.Managers()[0].Clients()) != 1 {
+				t.Error("first connection not made through the first adapter")
+			}
+			primary.SetPowered(false)
+			_, err := client.ReadADC(context.Background())
+			return err
+		}
+		if len(pool.Managers()[1].Clients()) != 1 {
+			t.Error("reconnection not made through the remaining adapter")
+		}
+		return esp32.ErrStopSession
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if connects != 2 {
+		t.Errorf("connected %d time(s), want 2", connects)
+	}
+}
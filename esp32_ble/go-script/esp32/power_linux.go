@@ -0,0 +1,24 @@
+//go:build linux
+
+package esp32
+
+import (
+	"github.com/godbus/dbus/v5"
+)
+
+// Powered reports whether the BlueZ adapter exists and is powered. An
+// adapter that has disappeared (rfkill, suspend, unplugged dongle) reports
+// false rather than an error.
+func (a *bleAdapter) Powered() (bool, error) {
+	bus, err := dbus.SystemBus()
+	if err != nil {
+		return false, err
+	}
+	obj := bus.Object("org.bluez", dbus.ObjectPath("/org/bluez/"+a.id))
+	v, err := obj.GetProperty("org.bluez.Adapter1.Powered")
+	if err != nil {
+		return false, nil
+	}
+	powered, _ := v.Value().(bool)
+	return powered, nil
+}
@@ -0,0 +1,155 @@
+package esp32
+
+import (
+	"cmp"
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Profile describes the GATT layout of a board's firmware, so builds with
+// their own UUIDs or frame formats can be used without changing the code.
+// Empty fields take the stock firmware's values.
+type Profile struct {
+	ServiceUUID   string
+	PinOutputUUID string
+	PinInputUUID  string
+	ADCOutputUUID string
+	// SerialNumberUUID, if set, is the characteristic the firmware
+	// reports its serial number or UUID in, which then identifies the
+	// board instead of its address (see Identifier).
+	SerialNumberUUID string
+	// PinValueBytes is the width of each value in a pin data frame: 1, as
+	// the stock firmware sends, or 2 for big-endian 16-bit values laid out
+	// like the ADC frame.
+	PinValueBytes int
+	// Decoders name the decoder (see RegisterDecoder) for characteristics
+	// whose frames aren't in the stock format, by UUID. They override
+	// PinValueBytes.
+	Decoders map[string]string
+	// ValueFormat is the byte order, width and signedness of the values
+	// in pin16 frames (the ADC frame, 16-bit pin frames and
+	// characteristics whose decoder is pin16), batch16 and delta16
+	// frames.
+	ValueFormat ValueFormat
+	// DecodeMode is how frames the decoders find inconsistent are
+	// treated; the default is DecodeStrict.
+	DecodeMode DecodeMode
+	// ReadMaxAge is how old a reading of each characteristic, by UUID,
+	// callers of ReadShared may settle for when they don't say.
+	ReadMaxAge map[string]time.Duration
+	// WritePolicies are how writes to each characteristic, by UUID, are
+	// made and retried, overriding the client's WritePolicy: a relay or
+	// stepper characteristic can be marked NonIdempotent so a lost
+	// response never repeats its command, while one setting an LED's
+	// color is retried freely.
+	WritePolicies map[string]WritePolicy
+	// Channels name pins, so dashboards and rules can refer to what is
+	// wired to a pin rather than where: moving a sensor to another GPIO
+	// only changes its channel's pin. Readings of a named pin carry its
+	// name.
+	Channels map[string]uint8
+}
+
+// DefaultProfile returns the stock firmware's profile.
+func DefaultProfile() Profile {
+	return Profile{
+		ServiceUUID:   PinServiceUUID,
+		PinOutputUUID: PinDataOutputUUID,
+		PinInputUUID:  PinDataInputUUID,
+		ADCOutputUUID: ADCDataOutputUUID,
+		PinValueBytes: 1,
+	}
+}
+
+// WithDefaults returns p with its empty fields taken from DefaultProfile.
+func (p Profile) WithDefaults() Profile {
+	d := DefaultProfile()
+	return Profile{
+		ServiceUUID:      cmp.Or(p.ServiceUUID, d.ServiceUUID),
+		PinOutputUUID:    cmp.Or(p.PinOutputUUID, d.PinOutputUUID),
+		PinInputUUID:     cmp.Or(p.PinInputUUID, d.PinInputUUID),
+		ADCOutputUUID:    cmp.Or(p.ADCOutputUUID, d.ADCOutputUUID),
+		SerialNumberUUID: p.SerialNumberUUID,
+		PinValueBytes:    cmp.Or(p.PinValueBytes, d.PinValueBytes),
+		Decoders:         p.Decoders,
+		ValueFormat:      p.ValueFormat,
+		DecodeMode:       p.DecodeMode,
+		ReadMaxAge:       p.ReadMaxAge,
+		WritePolicies:    p.WritePolicies,
+		Channels:         p.Channels,
+	}
+}
+
+// Identifier returns how boards with the profile are identified: by
+// serial number if it has a SerialNumberUUID, otherwise by address.
+func (p Profile) Identifier() Identifier {
+	if p.SerialNumberUUID != "" {
+		return SerialNumberIdentifier(p.SerialNumberUUID)
+	}
+	return AddressIdentifier
+}
+
+// Validate reports a profile the client can't use.
+func (p Profile) Validate() error {
+	if p.PinValueBytes != 0 && p.PinValueBytes != 1 && p.PinValueBytes != 2 {
+		return fmt.Errorf("pin values must be 1 or 2 bytes, not %d", p.PinValueBytes)
+	}
+	if err := p.ValueFormat.Validate(); err != nil {
+		return err
+	}
+	for uuid, maxAge := range p.ReadMaxAge {
+		if maxAge < 0 {
+			return fmt.Errorf("negative read max age %s for %s", maxAge, uuid)
+		}
+	}
+	for _, uuid := range slices.Sorted(maps.Keys(p.WritePolicies)) {
+		if err := p.WritePolicies[uuid].Validate(); err != nil {
+			return fmt.Errorf("write policy for %s: %w", uuid, err)
+		}
+	}
+	named := map[uint8]string{}
+	for _, name := range slices.Sorted(maps.Keys(p.Channels)) {
+		pin := p.Channels[name]
+		if !channelName.MatchString(name) || pinName.MatchString(name) {
+			return fmt.Errorf("invalid channel name %q (want letters, digits, - and _, starting with a letter, and not like pin25)", name)
+		}
+		if other, ok := named[pin]; ok {
+			return fmt.Errorf("pin %d is both channel %q and channel %q", pin, other, name)
+		}
+		named[pin] = name
+	}
+	for uuid, name := range p.Decoders {
+		if _, ok := LookupDecoder(name); !ok {
+			return fmt.Errorf("unknown decoder %q for %s (have %s)", name, uuid, strings.Join(DecoderNames(), ", "))
+		}
+	}
+	return nil
+}
+
+// channelName matches a valid channel name, and pinName the names pins
+// go by in rules, which channels can't take.
+var (
+	channelName = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_-]*$`)
+	pinName     = regexp.MustCompile(`^pin\d+$`)
+)
+
+// ChannelPin returns the pin of the channel name.
+func (p Profile) ChannelPin(name string) (uint8, bool) {
+	pin, ok := p.Channels[name]
+	return pin, ok
+}
+
+// ChannelName returns the name of the channel on pin, or "" if it has
+// none.
+func (p Profile) ChannelName(pin uint8) string {
+	for name, named := range p.Channels {
+		if named == pin {
+			return name
+		}
+	}
+	return ""
+}
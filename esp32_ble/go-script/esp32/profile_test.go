@@ -0,0 +1,73 @@
+package esp32_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"bluetooth/esp32"
+	"bluetooth/esp32/mock"
+)
+
+func TestProfileReadPins(t *testing.T) {
+	defer verifyNoLeaks(t)
+
+	board := mock.NewBoard("esp32-custom", "AA:BB:CC:DD:EE:07")
+	board.SetADC(35, 1234)
+	adapter := mock.NewAdapter(board)
+	// Boards can be found by address as well as name.
+	result, err := esp32.FindDevice(context.Background(), adapter, "aa:bb:cc:dd:ee:07", time.Second, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := esp32.Connect(context.Background(), adapter, result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Disconnect()
+
+	// A firmware whose pin frames carry 16-bit values, here stood in for
+	// by the ADC characteristic.
+	client.SetProfile(esp32.Profile{PinOutputUUID: esp32.ADCDataOutputUUID, PinValueBytes: 2})
+	if got := client.Profile().PinInputUUID; got != esp32.PinDataInputUUID {
+		t.Errorf("PinInputUUID = %q, want the stock UUID", got)
+	}
+	readings, err := client.ReadPins(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(readings) != 2 || readings[0].Pin != 35 || readings[0].Value != 1234 {
+		t.Errorf("readings = %+v, want pin 35 first with value 1234", readings)
+	}
+}
+
+func TestProfileChannels(t *testing.T) {
+	board := mock.NewBoard("esp32-test", "AA:BB:CC:DD:EE:01")
+	board.SetADC(35, 1234)
+	client := connectMock(t, board)
+	client.SetProfile(esp32.Profile{Channels: map[string]uint8{"light-level": 35}})
+
+	readings, err := client.ReadADC(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range readings {
+		want := ""
+		if r.Pin == 35 {
+			want = "light-level"
+		}
+		if r.Channel != want {
+			t.Errorf("pin %d has channel %q, want %q", r.Pin, r.Channel, want)
+		}
+	}
+
+	for _, channels := range []map[string]uint8{
+		{"fan": 25, "vent": 25},
+		{"pin25": 25},
+		{"2fast": 25},
+	} {
+		if err := (esp32.Profile{Channels: channels}).Validate(); err == nil {
+			t.Errorf("Validate accepted channels %v", channels)
+		}
+	}
+}
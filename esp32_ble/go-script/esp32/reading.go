@@ -0,0 +1,32 @@
+package esp32
+
+import "time"
+
+// Reading is a single decoded pin value reported by a board.
+type Reading struct {
+	Time time.Time `json:"time"`
+	// Device and Address identify the board, and ID is its identity (see
+	// Client.ID); they are empty for readings decoded outside a Client.
+	Device  string `json:"device,omitempty"`
+	Address string `json:"address,omitempty"`
+	ID      string `json:"id,omitempty"`
+	// Kind is empty for pin values. Other kinds (KindRSSI) carry a
+	// measurement about the board in Value, with Pin unused.
+	Kind string `json:"kind,omitempty"`
+	Pin  uint8  `json:"pin"`
+	// Channel is the pin's name in the profile's Channels, if any.
+	Channel string `json:"channel,omitempty"`
+	Value   int    `json:"value"`
+	// Seq is the position of the notification the reading came from
+	// among its characteristic's, from 1, for readings from a
+	// subscription. Subscribers handling readings in parallel can use it
+	// to spot ones handled out of order.
+	Seq uint64 `json:"seq,omitempty"`
+}
+
+// KindRSSI marks a reading of the connection's signal strength, in dBm.
+const KindRSSI = "rssi"
+
+// KindAnomaly marks how unusual a reading of Pin is, in whole standard
+// deviations from the pin's baseline, as scored by the anomaly package.
+const KindAnomaly = "anomaly"
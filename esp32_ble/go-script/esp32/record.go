@@ -0,0 +1,284 @@
+package esp32
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+// Operations in a recording.
+const (
+	RecordScan           = "scan"
+	RecordConnect        = "connect"
+	RecordService        = "service"
+	RecordCharacteristic = "characteristic"
+	RecordRead           = "read"
+	RecordWrite          = "write"
+	RecordSubscribe      = "subscribe"
+	RecordNotify         = "notify"
+	RecordMTU            = "mtu"
+	RecordDescribe       = "describe"
+	RecordRSSI           = "rssi"
+	RecordDisconnect     = "disconnect"
+)
+
+// RecordedEvent is one line of a recording: a JSON object per GATT
+// operation, in the order they happened.
+type RecordedEvent struct {
+	At time.Time `json:"at"`
+	Op string    `json:"op"`
+	// Address is the board's; Name and Services are an advertisement's.
+	Address  string   `json:"address"`
+	Name     string   `json:"name,omitempty"`
+	Services []string `json:"services,omitempty"`
+	// Service and UUID identify a discovered service or characteristic.
+	Service string `json:"service,omitempty"`
+	UUID    string `json:"uuid,omitempty"`
+	// Value is what was read, written or notified.
+	Value HexBytes `json:"value,omitempty"`
+	// Number is the MTU, or the RSSI of a connection or advertisement.
+	Number   int                  `json:"number,omitempty"`
+	Describe []CharacteristicInfo `json:"describe,omitempty"`
+	// Error is the operation's error, if it failed.
+	Error string `json:"error,omitempty"`
+}
+
+// HexBytes is a byte slice that encodes as a hex string in JSON, so
+// recordings can be read and edited by hand.
+type HexBytes []byte
+
+func (b HexBytes) MarshalText() ([]byte, error) {
+	return []byte(hex.EncodeToString(b)), nil
+}
+
+func (b *HexBytes) UnmarshalText(text []byte) error {
+	decoded, err := hex.DecodeString(string(text))
+	*b = decoded
+	return err
+}
+
+// Recorder writes everything done through its adapters to a recording,
+// which the replay package serves back as an Adapter, so the layers above
+// can be tested without a board. A Recorder may be shared by several
+// adapters.
+type Recorder struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+	err error
+}
+
+// NewRecorder returns a recorder writing JSON lines to w.
+func NewRecorder(w io.Writer) *Recorder {
+	return &Recorder{enc: json.NewEncoder(w)}
+}
+
+// Err returns the first error writing the recording; recording stops
+// after it.
+func (r *Recorder) Err() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.err
+}
+
+func (r *Recorder) record(e RecordedEvent, err error) {
+	e.At = time.Now()
+	if err != nil {
+		e.Error = err.Error()
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err == nil {
+		r.err = r.enc.Encode(e)
+	}
+}
+
+// Adapter returns a wrapper of a that records its scans and everything
+// done through the devices it connects.
+func (r *Recorder) Adapter(a Adapter) Adapter {
+	return recordedAdapter{Adapter: a, r: r}
+}
+
+type recordedAdapter struct {
+	Adapter
+	r *Recorder
+}
+
+// Scan records each device once per scan, with its first advertisement.
+func (a recordedAdapter) Scan(callback func(ScanResult)) error {
+	seen := map[string]bool{}
+	return a.Adapter.Scan(func(result ScanResult) {
+		if !seen[result.Address] {
+			seen[result.Address] = true
+			a.r.record(RecordedEvent{
+				Op: RecordScan, Address: result.Address, Name: result.Name,
+				Services: result.Services, Number: int(result.RSSI),
+			}, nil)
+		}
+		callback(result)
+	})
+}
+
+func (a recordedAdapter) Connect(address string) (Device, error) {
+	device, err := a.Adapter.Connect(address)
+	a.r.record(RecordedEvent{Op: RecordConnect, Address: address}, err)
+	if err != nil {
+		return nil, err
+	}
+	return &recordedDevice{Device: device, r: a.r, address: address}, nil
+}
+
+// Powered forwards to the wrapped adapter, which embedding alone would
+// hide from Manager.
+func (a recordedAdapter) Powered() (bool, error) {
+	if pr, ok := a.Adapter.(PowerReporter); ok {
+		return pr.Powered()
+	}
+	return true, nil
+}
+
+// recordedDevice records a device's operations. It implements every
+// optional device interface, returning the client's unsupported errors
+// where the wrapped device doesn't.
+type recordedDevice struct {
+	Device
+	r       *Recorder
+	address string
+}
+
+func (d *recordedDevice) DiscoverServices() ([]Service, error) {
+	services, err := d.Device.DiscoverServices()
+	if err != nil {
+		return nil, err
+	}
+	out := make([]Service, len(services))
+	for i, s := range services {
+		d.r.record(RecordedEvent{Op: RecordService, Address: d.address, Service: s.UUID()}, nil)
+		out[i] = &recordedService{Service: s, d: d}
+	}
+	return out, nil
+}
+
+func (d *recordedDevice) Disconnect() error {
+	err := d.Device.Disconnect()
+	d.r.record(RecordedEvent{Op: RecordDisconnect, Address: d.address}, err)
+	return err
+}
+
+func (d *recordedDevice) Describe() ([]CharacteristicInfo, error) {
+	desc, ok := d.Device.(Describer)
+	if !ok {
+		return nil, ErrDescribeUnsupported
+	}
+	info, err := desc.Describe()
+	d.r.record(RecordedEvent{Op: RecordDescribe, Address: d.address, Describe: info}, err)
+	return info, err
+}
+
+func (d *recordedDevice) RSSI() (int16, error) {
+	rr, ok := d.Device.(RSSIReporter)
+	if !ok {
+		return 0, ErrRSSIUnsupported
+	}
+	rssi, err := rr.RSSI()
+	d.r.record(RecordedEvent{Op: RecordRSSI, Address: d.address, Number: int(rssi)}, err)
+	return rssi, err
+}
+
+func (d *recordedDevice) PHY() (tx, rx PHY, err error) {
+	pc, ok := d.Device.(PHYController)
+	if !ok {
+		return 0, 0, ErrPHYUnsupported
+	}
+	return pc.PHY()
+}
+
+func (d *recordedDevice) SetPHY(p PHY) (tx, rx PHY, err error) {
+	pc, ok := d.Device.(PHYController)
+	if !ok {
+		return 0, 0, ErrPHYUnsupported
+	}
+	return pc.SetPHY(p)
+}
+
+func (d *recordedDevice) Paired() (bool, error) {
+	p, ok := d.Device.(Pairer)
+	if !ok {
+		return false, ErrPairingUnsupported
+	}
+	return p.Paired()
+}
+
+func (d *recordedDevice) Pair(agent PairingAgent) error {
+	p, ok := d.Device.(Pairer)
+	if !ok {
+		return ErrPairingUnsupported
+	}
+	return p.Pair(agent)
+}
+
+type recordedService struct {
+	Service
+	d *recordedDevice
+}
+
+func (s *recordedService) DiscoverCharacteristics() ([]Characteristic, error) {
+	chars, err := s.Service.DiscoverCharacteristics()
+	if err != nil {
+		return nil, err
+	}
+	out := make([]Characteristic, len(chars))
+	for i, c := range chars {
+		s.d.r.record(RecordedEvent{Op: RecordCharacteristic, Address: s.d.address, Service: s.UUID(), UUID: c.UUID()}, nil)
+		out[i] = &recordedCharacteristic{Characteristic: c, d: s.d}
+	}
+	return out, nil
+}
+
+type recordedCharacteristic struct {
+	Characteristic
+	d *recordedDevice
+}
+
+func (c *recordedCharacteristic) event(op string, value []byte) RecordedEvent {
+	return RecordedEvent{Op: op, Address: c.d.address, UUID: c.UUID(), Value: append(HexBytes(nil), value...)}
+}
+
+func (c *recordedCharacteristic) Read(buf []byte) (int, error) {
+	n, err := c.Characteristic.Read(buf)
+	c.d.r.record(c.event(RecordRead, buf[:max(n, 0)]), err)
+	return n, err
+}
+
+func (c *recordedCharacteristic) Write(p []byte) (int, error) {
+	n, err := c.Characteristic.Write(p)
+	c.d.r.record(c.event(RecordWrite, p), err)
+	return n, err
+}
+
+func (c *recordedCharacteristic) WriteWithResponse(p []byte) (int, error) {
+	n, err := writeWithResponse(c.Characteristic, p)
+	c.d.r.record(c.event(RecordWrite, p), err)
+	return n, err
+}
+
+func (c *recordedCharacteristic) EnableNotifications(callback func(buf []byte)) error {
+	if callback == nil {
+		return c.Characteristic.EnableNotifications(nil)
+	}
+	err := c.Characteristic.EnableNotifications(func(buf []byte) {
+		c.d.r.record(c.event(RecordNotify, buf), nil)
+		callback(buf)
+	})
+	c.d.r.record(c.event(RecordSubscribe, nil), err)
+	return err
+}
+
+func (c *recordedCharacteristic) MTU() (uint16, error) {
+	mtu, err := c.Characteristic.MTU()
+	e := c.event(RecordMTU, nil)
+	e.Number = int(mtu)
+	c.d.r.record(e, err)
+	return mtu, err
+}
@@ -0,0 +1,213 @@
+package esp32
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// AckWriter is implemented by characteristics that can write with
+// response, the board acknowledging each write at the ATT layer. The BLE
+// characteristic implements it on every platform.
+type AckWriter interface {
+	WriteWithResponse(p []byte) (int, error)
+}
+
+// writeWithResponse writes p to char with response if it can, and as a
+// plain write otherwise.
+func writeWithResponse(char Characteristic, p []byte) (int, error) {
+	if w, ok := char.(AckWriter); ok {
+		return w.WriteWithResponse(p)
+	}
+	return char.Write(p)
+}
+
+// WritePolicy makes pin writes reliable: writes are made with response,
+// retried when they fail or time out and optionally read back from the
+// pin output characteristic, so a write lost on the air is noticed.
+type WritePolicy struct {
+	// Attempts is how many times a write is tried; below 1 means 1.
+	Attempts int
+	// Timeout bounds each attempt, read-back included; 0 for no limit.
+	Timeout time.Duration
+	// Backoff is how long to wait before each retry.
+	Backoff time.Duration
+	// Verify reads the pins back after each attempt, retrying the writes
+	// whose pin doesn't report the commanded state.
+	Verify bool
+	// NonIdempotent marks writes that act each time they land, like a
+	// relay toggle or a stepper move, rather than setting a state. A
+	// failed write may have landed all the same, with only its response
+	// lost, so one is only retried once Verify's read-back shows the
+	// board didn't act on it; without Verify it is tried once.
+	NonIdempotent bool
+}
+
+// Validate reports a policy that can't be followed.
+func (p WritePolicy) Validate() error {
+	switch {
+	case p.Attempts < 0:
+		return fmt.Errorf("negative attempts %d", p.Attempts)
+	case p.Timeout < 0 || p.Backoff < 0:
+		return errors.New("negative timeout or backoff")
+	case p.NonIdempotent && p.Attempts > 1 && !p.Verify:
+		return errors.New("writes that aren't idempotent can only be retried with verify")
+	}
+	return nil
+}
+
+// DefaultWritePolicy is what --reliable uses unless told otherwise.
+func DefaultWritePolicy() WritePolicy {
+	return WritePolicy{Attempts: 3, Timeout: 2 * time.Second, Backoff: 100 * time.Millisecond}
+}
+
+// PinWriteFailure is one pin write that didn't take.
+type PinWriteFailure struct {
+	Write PinWrite
+	// State is what the pin reported on read-back, or -1 if it wasn't
+	// reported or wasn't read.
+	State int
+	// Err is the error of the last attempt, if it failed outright.
+	Err error
+}
+
+// PinWriteError is returned by WritePins when some writes still failed
+// after every attempt of the client's WritePolicy.
+type PinWriteError struct {
+	Attempts int
+	Failed   []PinWriteFailure
+}
+
+func (e *PinWriteError) Error() string {
+	var pins []string
+	for _, f := range e.Failed {
+		switch {
+		case f.Err != nil:
+			pins = append(pins, fmt.Sprintf("pin %d: %v", f.Write.PinNum, f.Err))
+		case f.State < 0:
+			pins = append(pins, fmt.Sprintf("pin %d (wrote %d, not reported)", f.Write.PinNum, f.Write.State))
+		default:
+			pins = append(pins, fmt.Sprintf("pin %d (wrote %d, reads %d)", f.Write.PinNum, f.Write.State, f.State))
+		}
+	}
+	return fmt.Sprintf("pin writes failed after %d attempt(s): %s", e.Attempts, strings.Join(pins, ", "))
+}
+
+// Unwrap returns the errors of writes that failed outright.
+func (e *PinWriteError) Unwrap() []error {
+	var errs []error
+	for _, f := range e.Failed {
+		if f.Err != nil {
+			errs = append(errs, f.Err)
+		}
+	}
+	return errs
+}
+
+// SetWritePolicy makes WritePins follow p; nil, the default, writes once
+// without response as the firmware's examples do. The profile's
+// WritePolicies take precedence for their characteristics.
+func (c *Client) SetWritePolicy(p *WritePolicy) {
+	c.writePolicy = p
+}
+
+// policy returns the write policy for uuid: the profile's if it has one,
+// otherwise the client's, nil if neither is set.
+func (c *Client) policy(uuid string) *WritePolicy {
+	if p, ok := c.profile.WritePolicies[uuid]; ok {
+		return &p
+	}
+	return c.writePolicy
+}
+
+// writePinsReliably is WritePins under policy p.
+func (c *Client) writePinsReliably(ctx context.Context, char Characteristic, writes []PinWrite, p WritePolicy) error {
+	pending := writes
+	var failed []PinWriteFailure
+	attempts := max(p.Attempts, 1)
+	for attempt := 1; attempt <= attempts; attempt++ {
+		if attempt > 1 && p.Backoff > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(p.Backoff):
+			}
+		}
+		failed = c.attemptPinWrites(ctx, char, pending, p)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if len(failed) == 0 {
+			return nil
+		}
+		// The writes that took are left alone: retrying them could undo
+		// a change the board made since.
+		pending = pending[:0:0]
+		for _, f := range failed {
+			if p.NonIdempotent && f.State < 0 {
+				// Nothing says it didn't land, so it mustn't be repeated.
+				return &PinWriteError{Attempts: attempt, Failed: failed}
+			}
+			pending = append(pending, f.Write)
+		}
+	}
+	return &PinWriteError{Attempts: attempts, Failed: failed}
+}
+
+// attemptPinWrites makes one attempt at writes, returning those that
+// failed.
+func (c *Client) attemptPinWrites(ctx context.Context, char Characteristic, writes []PinWrite, p WritePolicy) []PinWriteFailure {
+	fail := func(err error) []PinWriteFailure {
+		out := make([]PinWriteFailure, len(writes))
+		for i, w := range writes {
+			out[i] = PinWriteFailure{Write: w, State: -1, Err: err}
+		}
+		return out
+	}
+	if p.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.Timeout)
+		defer cancel()
+	}
+	message, err := EncodePinWrites(writes)
+	if err != nil {
+		return fail(err)
+	}
+	_, writeErr := await(ctx, func() (struct{}, error) {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if _, err := writeWithResponse(char, message); err != nil {
+			return struct{}{}, fmt.Errorf("failed to write: %w", err)
+		}
+		return struct{}{}, nil
+	}, nil)
+	// A failed write that isn't idempotent is read back too, to tell
+	// whether it landed and may be retried.
+	if writeErr != nil && !(p.NonIdempotent && p.Verify && ctx.Err() == nil) {
+		return fail(writeErr)
+	}
+	if !p.Verify {
+		return nil
+	}
+
+	readings, err := c.ReadPins(ctx)
+	if err != nil {
+		return fail(errors.Join(writeErr, fmt.Errorf("failed to read back: %w", err)))
+	}
+	states := map[uint8]int{}
+	for _, r := range readings {
+		states[r.Pin] = r.Value
+	}
+	var failed []PinWriteFailure
+	for _, w := range writes {
+		state, ok := states[w.PinNum]
+		if !ok {
+			failed = append(failed, PinWriteFailure{Write: w, State: -1, Err: writeErr})
+		} else if state != int(w.State) {
+			failed = append(failed, PinWriteFailure{Write: w, State: state, Err: writeErr})
+		}
+	}
+	return failed
+}
@@ -0,0 +1,140 @@
+package esp32_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"bluetooth/esp32"
+	"bluetooth/esp32/mock"
+)
+
+func TestUnreliableWriteIsLost(t *testing.T) {
+	defer verifyNoLeaks(t)
+
+	board := mock.NewBoard("esp32-test", "AA:BB:CC:DD:EE:01")
+	client := connectBoard(t, board)
+	defer client.Disconnect()
+
+	board.LoseWrites(1)
+	if err := client.WritePins(context.Background(), []esp32.PinWrite{{PinNum: 14, State: 1}}); err != nil {
+		t.Fatal(err)
+	}
+	if got := board.Pin(14); got != 0 {
+		t.Errorf("pin 14 = %d, want the lost write not applied", got)
+	}
+}
+
+func TestReliableWriteRetries(t *testing.T) {
+	defer verifyNoLeaks(t)
+
+	board := mock.NewBoard("esp32-test", "AA:BB:CC:DD:EE:01")
+	client := connectBoard(t, board)
+	defer client.Disconnect()
+	client.SetWritePolicy(&esp32.WritePolicy{Attempts: 3, Timeout: time.Second, Verify: true})
+
+	board.LoseWrites(2)
+	writes := []esp32.PinWrite{{PinNum: 14, State: 1}, {PinNum: 26, State: 1}}
+	if err := client.WritePins(context.Background(), writes); err != nil {
+		t.Fatal(err)
+	}
+	for _, w := range writes {
+		if got := board.Pin(w.PinNum); got != w.State {
+			t.Errorf("pin %d = %d, want %d", w.PinNum, got, w.State)
+		}
+	}
+}
+
+func TestReliableWriteError(t *testing.T) {
+	defer verifyNoLeaks(t)
+
+	board := mock.NewBoard("esp32-test", "AA:BB:CC:DD:EE:01")
+	client := connectBoard(t, board)
+	defer client.Disconnect()
+	client.SetWritePolicy(&esp32.WritePolicy{Attempts: 2, Verify: true})
+
+	// The firmware drops writes to pins it doesn't drive, so pin 99
+	// never reports the state; pin 14 takes.
+	err := client.WritePins(context.Background(), []esp32.PinWrite{{PinNum: 14, State: 1}, {PinNum: 99, State: 1}})
+	var writeErr *esp32.PinWriteError
+	if !errors.As(err, &writeErr) {
+		t.Fatalf("WritePins() = %v, want a *PinWriteError", err)
+	}
+	if writeErr.Attempts != 2 || len(writeErr.Failed) != 1 || writeErr.Failed[0].Write.PinNum != 99 || writeErr.Failed[0].State != -1 {
+		t.Errorf("error = %+v, want pin 99 unreported after 2 attempts", writeErr)
+	}
+	if want := "pin writes failed after 2 attempt(s): pin 99 (wrote 1, not reported)"; err.Error() != want {
+		t.Errorf("Error() = %q, want %q", err, want)
+	}
+
+	// Without read-back, a write that is never acknowledged fails.
+	client.SetWritePolicy(&esp32.WritePolicy{Attempts: 2})
+	board.LoseWrites(2)
+	err = client.WritePins(context.Background(), []esp32.PinWrite{{PinNum: 26, State: 1}})
+	if !errors.Is(err, mock.ErrWriteLost) {
+		t.Errorf("WritePins() = %v, want it to wrap mock.ErrWriteLost", err)
+	}
+}
+
+func TestProfileWritePolicy(t *testing.T) {
+	defer verifyNoLeaks(t)
+
+	board := mock.NewBoard("esp32-test", "AA:BB:CC:DD:EE:01")
+	client := connectBoard(t, board)
+	defer client.Disconnect()
+	client.SetWritePolicy(&esp32.WritePolicy{Attempts: 3})
+
+	// The profile's policy for the pin input characteristic wins over
+	// the client's, so the lost write isn't retried.
+	client.SetProfile(esp32.Profile{WritePolicies: map[string]esp32.WritePolicy{
+		esp32.PinDataInputUUID: {NonIdempotent: true},
+	}})
+	board.LoseWrites(1)
+	err := client.WritePins(context.Background(), []esp32.PinWrite{{PinNum: 14, State: 1}})
+	if !errors.Is(err, mock.ErrWriteLost) {
+		t.Errorf("WritePins() = %v, want it to wrap mock.ErrWriteLost", err)
+	}
+	if got := board.Pin(14); got != 0 {
+		t.Errorf("pin 14 = %d, want the write not retried", got)
+	}
+}
+
+func TestNonIdempotentWriteRetriesOnlyVerified(t *testing.T) {
+	defer verifyNoLeaks(t)
+
+	board := mock.NewBoard("esp32-test", "AA:BB:CC:DD:EE:01")
+	client := connectBoard(t, board)
+	defer client.Disconnect()
+	client.SetWritePolicy(&esp32.WritePolicy{Attempts: 3, Verify: true, NonIdempotent: true})
+
+	// The read-back shows the lost write didn't land, so it is retried.
+	board.LoseWrites(1)
+	if err := client.WritePins(context.Background(), []esp32.PinWrite{{PinNum: 14, State: 1}}); err != nil {
+		t.Fatal(err)
+	}
+	if got := board.Pin(14); got != 1 {
+		t.Errorf("pin 14 = %d, want 1", got)
+	}
+
+	// Pin 99 is never reported, so whether it landed is unknown and it
+	// isn't retried.
+	board.LoseWrites(1)
+	err := client.WritePins(context.Background(), []esp32.PinWrite{{PinNum: 99, State: 1}})
+	var writeErr *esp32.PinWriteError
+	if !errors.As(err, &writeErr) || writeErr.Attempts != 1 {
+		t.Errorf("WritePins() = %v, want a *PinWriteError after 1 attempt", err)
+	}
+}
+
+func TestWritePolicyValidate(t *testing.T) {
+	for _, p := range []esp32.WritePolicy{
+		{Attempts: -1},
+		{Timeout: -time.Second},
+		{Attempts: 2, NonIdempotent: true},
+	} {
+		if err := (esp32.Profile{WritePolicies: map[string]esp32.WritePolicy{esp32.PinDataInputUUID: p}}).Validate(); err == nil {
+			t.Errorf("Validate accepted write policy %+v", p)
+		}
+	}
+}
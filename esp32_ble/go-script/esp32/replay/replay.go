@@ -0,0 +1,389 @@
+// Package replay serves a recording made with esp32.Recorder as an
+// esp32.Adapter, so the decoding, bridging and export layers can be run
+// against real board traffic with no adapter present.
+package replay
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"bluetooth/esp32"
+)
+
+// Adapter replays a recording. Scans report the recorded advertisements,
+// connections offer the recorded services and characteristics, reads
+// return the recorded values in order (repeating the last once they run
+// out), and each subscription replays the notifications of the next
+// recorded subscription with their original timing. Writes succeed and
+// are kept for inspection.
+type Adapter struct {
+	// ScanInterval is how often each device re-advertises while scanning.
+	ScanInterval time.Duration
+	// Speed scales notification timing, e.g. 10 replays ten times as
+	// fast. Values below or equal to 0 mean 1.
+	Speed float64
+
+	mu      sync.Mutex
+	adverts []esp32.ScanResult
+	boards  map[string]*board
+	stop    chan struct{}
+	writes  []Write
+}
+
+// Write is a write made to a replayed characteristic.
+type Write struct {
+	Address string
+	UUID    string
+	Value   []byte
+}
+
+// board is what was recorded of one address.
+type board struct {
+	services []*service
+	reads    map[string][]esp32.RecordedEvent
+	readPos  map[string]int
+	notifies map[string][][]notification // per subscription
+	subPos   map[string]int
+	mtu      uint16
+	describe []esp32.CharacteristicInfo
+	rssi     *int16
+}
+
+type service struct {
+	uuid  string
+	chars []string
+}
+
+// notification is a recorded notification and how long after its
+// subscription it arrived.
+type notification struct {
+	after time.Duration
+	value []byte
+}
+
+// ErrNotRecorded is returned for operations the recording has no data
+// for.
+var ErrNotRecorded = errors.New("replay: not in the recording")
+
+// Open loads the recording at path.
+func Open(path string) (*Adapter, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Load(f)
+}
+
+// Load reads a recording written by esp32.Recorder.
+func Load(r io.Reader) (*Adapter, error) {
+	a := &Adapter{ScanInterval: 10 * time.Millisecond, boards: map[string]*board{}}
+	// subscribed is when each characteristic was last subscribed to, for
+	// subscriptions still open.
+	subscribed := map[[2]string]time.Time{}
+	// pending holds notifications recorded before their subscription was:
+	// the board can notify before the subscribe call returns.
+	pending := map[[2]string][]notification{}
+	// discovered marks addresses whose discovery is complete: later
+	// connections repeat it.
+	discovered := map[string]bool{}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<20)
+	for line := 1; scanner.Scan(); line++ {
+		var e esp32.RecordedEvent
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		b := a.board(e.Address)
+		switch e.Op {
+		case esp32.RecordScan:
+			if !slices.ContainsFunc(a.adverts, func(r esp32.ScanResult) bool { return r.Address == e.Address }) {
+				a.adverts = append(a.adverts, esp32.ScanResult{Name: e.Name, Address: e.Address, RSSI: int16(e.Number), Services: e.Services})
+			}
+		case esp32.RecordService:
+			if !discovered[e.Address] {
+				b.services = append(b.services, &service{uuid: e.Service})
+			}
+		case esp32.RecordCharacteristic:
+			if !discovered[e.Address] && len(b.services) > 0 {
+				s := b.services[len(b.services)-1]
+				s.chars = append(s.chars, e.UUID)
+			}
+		case esp32.RecordRead:
+			b.reads[e.UUID] = append(b.reads[e.UUID], e)
+		case esp32.RecordSubscribe:
+			key := [2]string{e.Address, e.UUID}
+			if e.Error == "" {
+				subscribed[key] = e.At
+				b.notifies[e.UUID] = append(b.notifies[e.UUID], pending[key])
+			}
+			delete(pending, key)
+		case esp32.RecordNotify:
+			key := [2]string{e.Address, e.UUID}
+			at, ok := subscribed[key]
+			if !ok {
+				pending[key] = append(pending[key], notification{value: e.Value})
+				continue
+			}
+			subs := b.notifies[e.UUID]
+			subs[len(subs)-1] = append(subs[len(subs)-1], notification{after: max(e.At.Sub(at), 0), value: e.Value})
+		case esp32.RecordMTU:
+			if e.Error == "" {
+				b.mtu = uint16(e.Number)
+			}
+		case esp32.RecordDescribe:
+			if e.Error == "" {
+				b.describe = e.Describe
+			}
+		case esp32.RecordRSSI:
+			if e.Error == "" {
+				rssi := int16(e.Number)
+				b.rssi = &rssi
+			}
+		case esp32.RecordDisconnect:
+			for key := range subscribed {
+				if key[0] == e.Address {
+					delete(subscribed, key)
+				}
+			}
+			if len(b.services) > 0 {
+				discovered[e.Address] = true
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// board returns the recorded state of address, creating it if needed.
+func (a *Adapter) board(address string) *board {
+	b, ok := a.boards[address]
+	if !ok {
+		b = &board{
+			reads:    map[string][]esp32.RecordedEvent{},
+			readPos:  map[string]int{},
+			notifies: map[string][][]notification{},
+			subPos:   map[string]int{},
+		}
+		a.boards[address] = b
+	}
+	return b
+}
+
+// Writes returns the writes made so far, oldest first.
+func (a *Adapter) Writes() []Write {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return slices.Clone(a.writes)
+}
+
+func (a *Adapter) Enable() error {
+	return nil
+}
+
+func (a *Adapter) Scan(callback func(esp32.ScanResult)) error {
+	a.mu.Lock()
+	if a.stop != nil {
+		a.mu.Unlock()
+		return errors.New("replay: already scanning")
+	}
+	stop := make(chan struct{})
+	a.stop = stop
+	a.mu.Unlock()
+
+	ticker := time.NewTicker(a.ScanInterval)
+	defer ticker.Stop()
+	for {
+		for _, result := range a.adverts {
+			select {
+			case <-stop:
+				return nil
+			default:
+			}
+			callback(result)
+		}
+		select {
+		case <-stop:
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+func (a *Adapter) StopScan() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.stop == nil {
+		return errors.New("replay: not scanning")
+	}
+	close(a.stop)
+	a.stop = nil
+	return nil
+}
+
+func (a *Adapter) Connect(address string) (esp32.Device, error) {
+	b, ok := a.boards[address]
+	if !ok || len(b.services) == 0 {
+		return nil, fmt.Errorf("no connection to %s: %w", address, ErrNotRecorded)
+	}
+	return &device{a: a, b: b, address: address, stop: make(chan struct{})}, nil
+}
+
+// device is a replayed connection.
+type device struct {
+	a       *Adapter
+	b       *board
+	address string
+
+	// stop ends the device's notification replays on Disconnect.
+	stop     chan struct{}
+	stopOnce sync.Once
+	replays  sync.WaitGroup
+}
+
+func (d *device) DiscoverServices() ([]esp32.Service, error) {
+	out := make([]esp32.Service, len(d.b.services))
+	for i, s := range d.b.services {
+		out[i] = &replayService{d: d, s: s}
+	}
+	return out, nil
+}
+
+func (d *device) Disconnect() error {
+	d.stopOnce.Do(func() { close(d.stop) })
+	d.replays.Wait()
+	return nil
+}
+
+func (d *device) Describe() ([]esp32.CharacteristicInfo, error) {
+	if d.b.describe == nil {
+		return nil, esp32.ErrDescribeUnsupported
+	}
+	return d.b.describe, nil
+}
+
+func (d *device) RSSI() (int16, error) {
+	if d.b.rssi == nil {
+		return 0, esp32.ErrRSSIUnsupported
+	}
+	return *d.b.rssi, nil
+}
+
+type replayService struct {
+	d *device
+	s *service
+}
+
+func (s *replayService) UUID() string {
+	return s.s.uuid
+}
+
+func (s *replayService) DiscoverCharacteristics() ([]esp32.Characteristic, error) {
+	out := make([]esp32.Characteristic, len(s.s.chars))
+	for i, uuid := range s.s.chars {
+		out[i] = &characteristic{d: s.d, uuid: uuid}
+	}
+	return out, nil
+}
+
+type characteristic struct {
+	d    *device
+	uuid string
+
+	mu   sync.Mutex
+	stop chan struct{}
+}
+
+func (c *characteristic) UUID() string {
+	return c.uuid
+}
+
+func (c *characteristic) Read(buf []byte) (int, error) {
+	c.d.a.mu.Lock()
+	defer c.d.a.mu.Unlock()
+	reads := c.d.b.reads[c.uuid]
+	if len(reads) == 0 {
+		return 0, fmt.Errorf("no reads of %s: %w", c.uuid, ErrNotRecorded)
+	}
+	pos := c.d.b.readPos[c.uuid]
+	if pos < len(reads)-1 {
+		c.d.b.readPos[c.uuid] = pos + 1
+	}
+	if reads[pos].Error != "" {
+		return 0, errors.New(reads[pos].Error)
+	}
+	return copy(buf, reads[pos].Value), nil
+}
+
+func (c *characteristic) Write(p []byte) (int, error) {
+	c.d.a.mu.Lock()
+	defer c.d.a.mu.Unlock()
+	c.d.a.writes = append(c.d.a.writes, Write{Address: c.d.address, UUID: c.uuid, Value: slices.Clone(p)})
+	return len(p), nil
+}
+
+// EnableNotifications replays the next recorded subscription's
+// notifications to callback; nil stops the replay.
+func (c *characteristic) EnableNotifications(callback func(buf []byte)) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.stop != nil {
+		close(c.stop)
+		c.stop = nil
+	}
+	if callback == nil {
+		return nil
+	}
+
+	c.d.a.mu.Lock()
+	var notifications []notification
+	subs := c.d.b.notifies[c.uuid]
+	if pos := c.d.b.subPos[c.uuid]; pos < len(subs) {
+		notifications = subs[pos]
+		c.d.b.subPos[c.uuid] = pos + 1
+	}
+	speed := c.d.a.Speed
+	c.d.a.mu.Unlock()
+	if speed <= 0 {
+		speed = 1
+	}
+
+	stop := make(chan struct{})
+	c.stop = stop
+	start := time.Now()
+	c.d.replays.Add(1)
+	go func() {
+		defer c.d.replays.Done()
+		for _, n := range notifications {
+			timer := time.NewTimer(time.Until(start.Add(time.Duration(float64(n.after) / speed))))
+			select {
+			case <-stop:
+				timer.Stop()
+				return
+			case <-c.d.stop:
+				timer.Stop()
+				return
+			case <-timer.C:
+			}
+			callback(slices.Clone(n.value))
+		}
+	}()
+	return nil
+}
+
+func (c *characteristic) MTU() (uint16, error) {
+	if c.d.b.mtu == 0 {
+		return 23, nil
+	}
+	return c.d.b.mtu, nil
+}
@@ -0,0 +1,129 @@
+package esp32_test
+
+import (
+	"bytes"
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"bluetooth/esp32"
+	"bluetooth/esp32/mock"
+	"bluetooth/esp32/replay"
+)
+
+func values(readings []esp32.Reading) []int {
+	out := make([]int, len(readings))
+	for i, r := range readings {
+		out[i] = r.Value
+	}
+	return out
+}
+
+func TestRecordReplay(t *testing.T) {
+	defer verifyNoLeaks(t)
+
+	// Record a session against the mock board.
+	board := mock.NewBoard("esp32-test", "AA:BB:CC:DD:EE:01")
+	board.SetADC(35, 1234)
+	var recording bytes.Buffer
+	recorder := esp32.NewRecorder(&recording)
+	adapter := recorder.Adapter(mock.NewAdapter(board))
+	result, err := esp32.FindDevice(context.Background(), adapter, board.Name, time.Second, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := esp32.Connect(context.Background(), adapter, result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, err := client.ReadADC(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	board.SetADC(35, 2000)
+	second, err := client.ReadADC(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	notified := make(chan []esp32.Reading, 3)
+	if err := client.SubscribeADC(func(r []esp32.Reading) { notified <- r }); err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range []uint16{1, 2, 3} {
+		board.SetADC(35, v)
+		board.Notify()
+		<-notified
+		time.Sleep(5 * time.Millisecond)
+	}
+	if err := client.Disconnect(); err != nil {
+		t.Fatal(err)
+	}
+	if err := recorder.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Replay it with no board.
+	replayed, err := replay.Load(&recording)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err = esp32.FindDevice(context.Background(), replayed, board.Name, time.Second, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Address != board.Address {
+		t.Errorf("Address = %s, want %s", result.Address, board.Address)
+	}
+	client, err = esp32.Connect(context.Background(), replayed, result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Disconnect()
+	for i, want := range [][]esp32.Reading{first, second, second} {
+		got, err := client.ReadADC(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(values(got), values(want)) {
+			t.Errorf("read %d = %v, want %v (the last read repeats)", i, values(got), values(want))
+		}
+	}
+	notified = make(chan []esp32.Reading, 3)
+	if err := client.SubscribeADC(func(r []esp32.Reading) { notified <- r }); err != nil {
+		t.Fatal(err)
+	}
+	var got []int
+	for range 3 {
+		select {
+		case r := <-notified:
+			for _, reading := range r {
+				if reading.Pin == 35 {
+					got = append(got, reading.Value)
+				}
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("notifications = %v, want 3", got)
+		}
+	}
+	if want := []int{1, 2, 3}; !slices.Equal(got, want) {
+		t.Errorf("pin 35 notifications = %v, want %v", got, want)
+	}
+
+	if err := client.WritePins(context.Background(), []esp32.PinWrite{{PinNum: 2, State: 1}}); err != nil {
+		t.Fatal(err)
+	}
+	if writes := replayed.Writes(); len(writes) != 1 || writes[0].UUID != esp32.PinDataInputUUID {
+		t.Errorf("Writes() = %+v, want one pin write", writes)
+	}
+}
+
+func TestReplayUnknownDevice(t *testing.T) {
+	replayed, err := replay.Load(bytes.NewReader(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := replayed.Connect("AA:BB:CC:DD:EE:01"); err == nil {
+		t.Error("Connect to an unrecorded address succeeded, want an error")
+	}
+}
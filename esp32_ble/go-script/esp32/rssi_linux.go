@@ -0,0 +1,32 @@
+//go:build linux
+
+package esp32
+
+import "encoding/binary"
+
+// hciOpReadRSSI is Read RSSI (OGF status parameters, OCF 0x0005).
+const hciOpReadRSSI = 0x05<<10 | 0x0005
+
+// RSSI reads the signal strength of the live connection with the HCI Read
+// RSSI command: BlueZ only reports RSSI over D-Bus for advertisements, and
+// the firmware stops advertising once connected.
+func (d bleDevice) RSSI() (int16, error) {
+	s, err := openHCI(d.adapterID)
+	if err != nil {
+		return 0, err
+	}
+	defer s.Close()
+	handle, err := s.connHandle(d.address)
+	if err != nil {
+		return 0, err
+	}
+	ret, err := s.command(hciOpReadRSSI, binary.LittleEndian.AppendUint16(nil, handle))
+	if err != nil {
+		return 0, err
+	}
+	// handle, RSSI
+	if len(ret) < 3 {
+		return 0, errShortReply
+	}
+	return int16(int8(ret[2])), nil
+}
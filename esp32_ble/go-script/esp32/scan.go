@@ -0,0 +1,157 @@
+package esp32
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ErrDeviceNotFound is returned by FindDevice when no matching device
+// advertised before the timeout.
+var ErrDeviceNotFound = errors.New("device not found")
+
+// NotFoundError lists the names FindDevices did not see before the
+// timeout. It matches ErrDeviceNotFound with errors.Is.
+type NotFoundError struct {
+	Names []string
+}
+
+func (e *NotFoundError) Error() string {
+	return fmt.Sprintf("device(s) not found: %s", strings.Join(e.Names, ", "))
+}
+
+func (e *NotFoundError) Is(target error) bool {
+	return target == ErrDeviceNotFound
+}
+
+// FindDevice scans until a device matching name, a target as taken by
+// CompileTarget, advertises, timeout passes or ctx is done. If seen
+// is non-nil it is called for every advertisement, for visibility.
+func FindDevice(ctx context.Context, a Adapter, name string, timeout time.Duration, seen func(ScanResult)) (ScanResult, error) {
+	results, err := FindDevices(ctx, a, []string{name}, timeout, seen)
+	if err != nil {
+		return ScanResult{}, err
+	}
+	return results[0], nil
+}
+
+// FindDevices scans until every name has been seen or timeout passes,
+// returning results in the order of names. Names are targets as taken by
+// CompileTarget, by default matching device names or addresses
+// case-insensitively, and each is matched by the first device advertising
+// it. A malformed target fails FindDevices before scanning. On timeout
+// it returns the results it did find, with zero values for the rest, and a
+// *NotFoundError. If ctx is done first it returns ctx.Err(). The scan is
+// always stopped before FindDevices returns.
+func FindDevices(ctx context.Context, a Adapter, names []string, timeout time.Duration, seen func(ScanResult)) ([]ScanResult, error) {
+	matches := make([]func(ScanResult) bool, len(names))
+	for i, name := range names {
+		match, err := CompileTarget(name)
+		if err != nil {
+			return nil, err
+		}
+		matches[i] = match
+	}
+	results := make([]ScanResult, len(names))
+	matched := make([]bool, len(names))
+	remaining := len(names)
+	done := make(chan struct{})
+	scanErr := make(chan error, 1)
+
+	goTracked(func() {
+		scanErr <- a.Scan(func(result ScanResult) {
+			if seen != nil {
+				seen(result)
+			}
+			if remaining == 0 {
+				return
+			}
+			for i, match := range matches {
+				if !matched[i] && match(result) {
+					matched[i] = true
+					results[i] = result
+					remaining--
+				}
+			}
+			if remaining == 0 {
+				a.StopScan()
+				close(done)
+			}
+		})
+	})
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-done:
+		<-scanErr
+		return results, nil
+	case err := <-scanErr:
+		select {
+		case <-done:
+			// The last match stopped the scan.
+			return results, nil
+		default:
+		}
+		if err == nil {
+			err = errors.New("scan stopped")
+		}
+		return nil, fmt.Errorf("scan error: %w", err)
+	case <-ctx.Done():
+		a.StopScan()
+		<-scanErr
+		select {
+		case <-done:
+			return results, nil
+		default:
+		}
+		return nil, ctx.Err()
+	case <-timer.C:
+		a.StopScan()
+		// The scan callback may still be running until Scan returns.
+		<-scanErr
+		select {
+		case <-done:
+			return results, nil
+		default:
+		}
+		var missing []string
+		for i, name := range names {
+			if !matched[i] {
+				missing = append(missing, name)
+			}
+		}
+		return results, &NotFoundError{Names: missing}
+	}
+}
+
+// FilterRSSI returns an adapter whose scans only report devices heard at
+// min dBm or stronger, so a far-away board advertising the same name as a
+// nearby one is ignored.
+func FilterRSSI(a Adapter, min int16) Adapter {
+	return rssiFilter{Adapter: a, min: min}
+}
+
+type rssiFilter struct {
+	Adapter
+	min int16
+}
+
+func (f rssiFilter) Scan(callback func(ScanResult)) error {
+	return f.Adapter.Scan(func(result ScanResult) {
+		if result.RSSI >= f.min {
+			callback(result)
+		}
+	})
+}
+
+// Powered forwards to the wrapped adapter, which embedding alone would
+// hide from Manager.
+func (f rssiFilter) Powered() (bool, error) {
+	if pr, ok := f.Adapter.(PowerReporter); ok {
+		return pr.Powered()
+	}
+	return true, nil
+}
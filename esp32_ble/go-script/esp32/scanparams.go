@@ -0,0 +1,114 @@
+package esp32
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+)
+
+// ScanParams tune how the adapter's radio scans. The defaults suit boards
+// advertising every 100ms or so; battery-saving firmware that advertises
+// once every few seconds is easily missed by a radio that only listens
+// for a fraction of each interval.
+type ScanParams struct {
+	// Passive only listens for advertisements instead of asking each
+	// device for a scan response, which saves the board's power but
+	// misses anything only in the response.
+	Passive bool
+	// Interval is how often the radio starts listening and Window how
+	// long it listens each time; a window equal to the interval listens
+	// continuously. Both are between 2.5ms and 10.24s in steps of
+	// 0.625ms; zero leaves the platform's default.
+	Interval time.Duration
+	Window   time.Duration
+}
+
+// Scan interval and window bounds and step from the Bluetooth spec.
+const (
+	scanTimeUnit = 625 * time.Microsecond
+	minScanTime  = 4 * scanTimeUnit
+	maxScanTime  = 0x4000 * scanTimeUnit
+)
+
+// Validate checks the interval and window are in range and the window
+// fits in the interval.
+func (p ScanParams) Validate() error {
+	for _, d := range []struct {
+		name  string
+		value time.Duration
+	}{{"interval", p.Interval}, {"window", p.Window}} {
+		if d.value != 0 && (d.value < minScanTime || d.value > maxScanTime) {
+			return fmt.Errorf("scan %s %v out of range (%v to %v)", d.name, d.value, minScanTime, maxScanTime)
+		}
+	}
+	if p.Interval != 0 && p.Window > p.Interval {
+		return fmt.Errorf("scan window %v is longer than the interval %v", p.Window, p.Interval)
+	}
+	return nil
+}
+
+// ScanConfigurer is implemented by adapters whose scan parameters can be
+// changed. The BLE adapter implements it on Linux, for the interval and
+// window only: BlueZ always scans actively.
+type ScanConfigurer interface {
+	SetScanParams(p ScanParams) error
+}
+
+// ErrScanParamsUnsupported is returned by ConfigureScan when the platform
+// can't change the scan parameters asked for.
+var ErrScanParamsUnsupported = errors.New("scan parameters can't be changed on this platform")
+
+// ConfigureScan sets a's scan parameters for the scans that follow. The
+// zero ScanParams leaves the platform's defaults and always succeeds.
+func ConfigureScan(a Adapter, p ScanParams) error {
+	if err := p.Validate(); err != nil {
+		return err
+	}
+	if p == (ScanParams{}) {
+		return nil
+	}
+	sc, ok := a.(ScanConfigurer)
+	if !ok {
+		return ErrScanParamsUnsupported
+	}
+	return sc.SetScanParams(p)
+}
+
+// FilterDuplicates returns an adapter whose scans report each device once,
+// and again only when its name or services change, rather than for every
+// advertisement. Stacks differ in how often they repeat advertisements;
+// this makes a scan's output the same everywhere, at the cost of RSSI
+// updates.
+func FilterDuplicates(a Adapter) Adapter {
+	return dupFilter{Adapter: a}
+}
+
+type dupFilter struct {
+	Adapter
+}
+
+func (f dupFilter) Scan(callback func(ScanResult)) error {
+	var mu sync.Mutex
+	seen := map[string]ScanResult{}
+	return f.Adapter.Scan(func(result ScanResult) {
+		mu.Lock()
+		last, ok := seen[result.Address]
+		seen[result.Address] = result
+		mu.Unlock()
+		if ok && last.Name == result.Name && slices.Equal(last.Services, result.Services) {
+			return
+		}
+		callback(result)
+	})
+}
+
+// Powered forwards to the wrapped adapter, which embedding alone would
+// hide from Manager.
+func (f dupFilter) Powered() (bool, error) {
+	if pr, ok := f.Adapter.(PowerReporter); ok {
+		return pr.Powered()
+	}
+	return true, nil
+}
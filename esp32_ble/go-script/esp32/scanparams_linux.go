@@ -0,0 +1,103 @@
+//go:build linux
+
+package esp32
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// Management API command and events used to set the discovery scan
+// parameters, from BlueZ's doc/mgmt-api.txt.
+const (
+	mgmtOpSetDefSystemConfig = 0x004c
+	mgmtEvCmdComplete        = 0x0001
+	mgmtEvCmdStatus          = 0x0002
+
+	mgmtConfigScanIntervalDiscovery = 0x000e
+	mgmtConfigScanWindowDiscovery   = 0x000f
+)
+
+// SetScanParams sets the interval and window the kernel uses for
+// discovery on the adapter, through the management socket since BlueZ
+// has no D-Bus call for them. This needs CAP_NET_ADMIN, and the setting
+// outlasts the process until the adapter is reset. BlueZ discovery
+// always scans actively, so Passive fails with ErrScanParamsUnsupported.
+func (a *bleAdapter) SetScanParams(p ScanParams) error {
+	if p.Passive {
+		return fmt.Errorf("%w: BlueZ discovery always scans actively", ErrScanParamsUnsupported)
+	}
+	// type, length, value
+	var params []byte
+	for _, c := range []struct {
+		typ   uint16
+		value uint16
+	}{
+		{mgmtConfigScanIntervalDiscovery, uint16(p.Interval / scanTimeUnit)},
+		{mgmtConfigScanWindowDiscovery, uint16(p.Window / scanTimeUnit)},
+	} {
+		if c.value != 0 {
+			params = binary.LittleEndian.AppendUint16(params, c.typ)
+			params = append(params, 2)
+			params = binary.LittleEndian.AppendUint16(params, c.value)
+		}
+	}
+	if len(params) == 0 {
+		return nil
+	}
+	return mgmtCommand(a.id, mgmtOpSetDefSystemConfig, params)
+}
+
+// mgmtCommand issues a management command for the adapter named id and
+// waits for it to complete.
+func mgmtCommand(id string, opcode uint16, params []byte) error {
+	index, err := strconv.Atoi(strings.TrimPrefix(id, "hci"))
+	if err != nil {
+		return fmt.Errorf("adapter %q: %w", id, err)
+	}
+	fd, err := unix.Socket(unix.AF_BLUETOOTH, unix.SOCK_RAW|unix.SOCK_CLOEXEC, unix.BTPROTO_HCI)
+	if err != nil {
+		return fmt.Errorf("opening management socket: %w", err)
+	}
+	defer unix.Close(fd)
+	// The control channel isn't bound to one adapter: commands name it.
+	if err := unix.Bind(fd, &unix.SockaddrHCI{Dev: 0xffff, Channel: unix.HCI_CHANNEL_CONTROL}); err != nil {
+		return fmt.Errorf("binding management socket: %w", err)
+	}
+	tv := unix.NsecToTimeval(int64(hciEventTimeout))
+	if err := unix.SetsockoptTimeval(fd, unix.SOL_SOCKET, unix.SO_RCVTIMEO, &tv); err != nil {
+		return err
+	}
+
+	// opcode, index, parameter length, parameters
+	cmd := binary.LittleEndian.AppendUint16(nil, opcode)
+	cmd = binary.LittleEndian.AppendUint16(cmd, uint16(index))
+	cmd = binary.LittleEndian.AppendUint16(cmd, uint16(len(params)))
+	if _, err := unix.Write(fd, append(cmd, params...)); err != nil {
+		return fmt.Errorf("sending management command %#04x: %w", opcode, err)
+	}
+	buf := make([]byte, 6+hciMaxEventPayload)
+	for {
+		n, err := unix.Read(fd, buf)
+		if err != nil {
+			return fmt.Errorf("reading management event: %w", err)
+		}
+		// event, index, parameter length, then the command's opcode and
+		// status for command complete and status events
+		if n < 9 || int(binary.LittleEndian.Uint16(buf[2:])) != index {
+			continue
+		}
+		ev := binary.LittleEndian.Uint16(buf)
+		if (ev != mgmtEvCmdComplete && ev != mgmtEvCmdStatus) || binary.LittleEndian.Uint16(buf[6:]) != opcode {
+			continue
+		}
+		if status := buf[8]; status != 0 {
+			return fmt.Errorf("management command %#04x failed with status %#x", opcode, status)
+		}
+		return nil
+	}
+}
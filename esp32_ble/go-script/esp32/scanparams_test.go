@@ -0,0 +1,70 @@
+package esp32_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"bluetooth/esp32"
+	"bluetooth/esp32/mock"
+)
+
+func TestScanParamsValidate(t *testing.T) {
+	for _, tc := range []struct {
+		params esp32.ScanParams
+		ok     bool
+	}{
+		{esp32.ScanParams{}, true},
+		{esp32.ScanParams{Passive: true}, true},
+		{esp32.ScanParams{Interval: time.Second, Window: time.Second}, true},
+		{esp32.ScanParams{Interval: 2500 * time.Microsecond}, true},
+		{esp32.ScanParams{Window: 10240 * time.Millisecond}, true},
+		{esp32.ScanParams{Interval: time.Millisecond}, false},
+		{esp32.ScanParams{Window: 11 * time.Second}, false},
+		{esp32.ScanParams{Interval: 100 * time.Millisecond, Window: 200 * time.Millisecond}, false},
+	} {
+		if err := tc.params.Validate(); (err == nil) != tc.ok {
+			t.Errorf("%+v.Validate() = %v, want ok %v", tc.params, err, tc.ok)
+		}
+	}
+}
+
+func TestConfigureScan(t *testing.T) {
+	adapter := mock.NewAdapter()
+	p := esp32.ScanParams{Passive: true, Interval: time.Second, Window: 30 * time.Millisecond}
+	if err := esp32.ConfigureScan(adapter, p); err != nil {
+		t.Fatal(err)
+	}
+	if got := adapter.ScanParams(); got != p {
+		t.Errorf("adapter scan params = %+v, want %+v", got, p)
+	}
+	if err := esp32.ConfigureScan(adapter, esp32.ScanParams{Window: time.Minute}); err == nil {
+		t.Error("ConfigureScan accepted a window out of range")
+	}
+
+	// Wrappers don't pass the parameters on: configure the adapter first.
+	wrapped := esp32.FilterRSSI(adapter, -70)
+	if err := esp32.ConfigureScan(wrapped, p); !errors.Is(err, esp32.ErrScanParamsUnsupported) {
+		t.Errorf("ConfigureScan on a wrapped adapter = %v, want ErrScanParamsUnsupported", err)
+	}
+	if err := esp32.ConfigureScan(wrapped, esp32.ScanParams{}); err != nil {
+		t.Errorf("ConfigureScan of the defaults = %v", err)
+	}
+}
+
+func TestFilterDuplicates(t *testing.T) {
+	adapter := mock.NewAdapter(mock.NewBoard("esp32-test", "AA:BB:CC:DD:EE:01"), mock.NewBoard("esp32-two", "AA:BB:CC:DD:EE:02"))
+	seen := map[string]int{}
+	// The emulated boards advertise every 10ms, so a scan for the
+	// missing board hears each many times.
+	_, err := esp32.FindDevice(context.Background(), esp32.FilterDuplicates(adapter), "esp32-three", 100*time.Millisecond, func(r esp32.ScanResult) {
+		seen[r.Name]++
+	})
+	if !errors.Is(err, esp32.ErrDeviceNotFound) {
+		t.Fatalf("FindDevice = %v, want ErrDeviceNotFound", err)
+	}
+	if seen["esp32-test"] != 1 || seen["esp32-two"] != 1 {
+		t.Errorf("advertisements seen = %v, want each board once", seen)
+	}
+}
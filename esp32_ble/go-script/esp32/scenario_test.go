@@ -0,0 +1,182 @@
+package esp32_test
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"bluetooth/esp32"
+	"bluetooth/esp32/mock"
+)
+
+// scenarioSpeed plays scenarios a hundred times as fast as real time:
+// fast, but with a board's absences long enough for several scans of
+// scenarioScanTimeout to fail, so every run sees the same events.
+const (
+	scenarioSpeed       = 100
+	scenarioScanTimeout = 20 * time.Millisecond
+)
+
+// runScenario keeps a session for target connected through adapter while
+// sc plays, reading the ADC every millisecond as serve's heartbeat does
+// so a dropped link is noticed. It returns the session's events once sc
+// is over.
+func runScenario(t *testing.T, adapter *mock.Adapter, target string, sc *mock.Scenario) []esp32.SessionEvent {
+	t.Helper()
+	var (
+		mu     sync.Mutex
+		events []esp32.SessionEvent
+	)
+	session := &esp32.Session{
+		Manager:     esp32.NewManager(adapter),
+		Name:        target,
+		ScanTimeout: scenarioScanTimeout,
+		RetryDelay:  time.Millisecond,
+		PowerPoll:   time.Millisecond,
+		OnEvent: func(e esp32.SessionEvent) {
+			mu.Lock()
+			defer mu.Unlock()
+			events = append(events, e)
+		},
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		done <- session.Run(ctx, func(client *esp32.Client) error {
+			ticker := time.NewTicker(time.Millisecond)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ctx.Done():
+					return nil
+				case <-ticker.C:
+					if _, err := client.ReadADC(ctx); err != nil {
+						return err
+					}
+				}
+			}
+		})
+	}()
+
+	sc.Speed = scenarioSpeed
+	err := sc.Run(ctx)
+	cancel()
+	if runErr := <-done; runErr != nil {
+		t.Errorf("Run = %v", runErr)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	return events
+}
+
+// kinds returns the kinds of events, runs of the same kind collapsed to
+// one, since how many scans fail while a board is away depends on the
+// scheduler.
+func kinds(events []esp32.SessionEvent) []esp32.SessionEventKind {
+	var kinds []esp32.SessionEventKind
+	for _, e := range events {
+		if len(kinds) == 0 || kinds[len(kinds)-1] != e.Kind {
+			kinds = append(kinds, e.Kind)
+		}
+	}
+	return kinds
+}
+
+func wantKinds(t *testing.T, events []esp32.SessionEvent, want ...esp32.SessionEventKind) {
+	t.Helper()
+	if got := kinds(events); !slices.Equal(got, want) {
+		t.Errorf("events = %v, want %v", got, want)
+	}
+}
+
+func TestScenarioLinkDrop(t *testing.T) {
+	defer verifyNoLeaks(t)
+
+	board := mock.NewBoard("esp32-test", "AA:BB:CC:DD:EE:01")
+	events := runScenario(t, mock.NewAdapter(board), board.Name, &mock.Scenario{Steps: []mock.Step{
+		mock.Connected(board),
+		mock.Drop(board, 3*time.Second),
+		mock.Connected(board),
+	}})
+	// The heartbeat notices the drop and the board is found again at
+	// once.
+	wantKinds(t, events, esp32.SessionConnected, esp32.SessionDisconnected, esp32.SessionConnected)
+}
+
+func TestScenarioOutOfRange(t *testing.T) {
+	defer verifyNoLeaks(t)
+
+	board := mock.NewBoard("esp32-test", "AA:BB:CC:DD:EE:01")
+	events := runScenario(t, mock.NewAdapter(board), board.Name, &mock.Scenario{Steps: []mock.Step{
+		mock.Connected(board),
+		mock.Leave(board, 3*time.Second),
+		mock.Return(board, 10*time.Second),
+		mock.Connected(board),
+	}})
+	wantKinds(t, events, esp32.SessionConnected, esp32.SessionDisconnected, esp32.SessionRetry, esp32.SessionConnected)
+	for _, e := range events {
+		if e.Kind == esp32.SessionRetry && !errors.Is(e.Err, esp32.ErrDeviceNotFound) {
+			t.Errorf("retry for %v, want only for the board not being found", e.Err)
+		}
+	}
+}
+
+func TestScenarioNewAddress(t *testing.T) {
+	defer verifyNoLeaks(t)
+
+	board := mock.NewBoard("esp32-test", "AA:BB:CC:DD:EE:01")
+	events := runScenario(t, mock.NewAdapter(board), board.Name, &mock.Scenario{Steps: []mock.Step{
+		mock.Connected(board),
+		mock.Leave(board, 3*time.Second),
+		mock.Readdress(board, "AA:BB:CC:DD:EE:09", time.Second),
+		mock.Return(board, 10*time.Second),
+		mock.Connected(board),
+	}})
+	// A session for a name follows the board to its new address.
+	wantKinds(t, events, esp32.SessionConnected, esp32.SessionDisconnected, esp32.SessionRetry, esp32.SessionConnected)
+	if last := events[len(events)-1]; last.Client == nil || last.Client.Address != "AA:BB:CC:DD:EE:09" {
+		t.Errorf("reconnected to %+v, want the new address", last.Client)
+	}
+}
+
+func TestScenarioAddressTarget(t *testing.T) {
+	defer verifyNoLeaks(t)
+
+	board := mock.NewBoard("esp32-test", "AA:BB:CC:DD:EE:01")
+	events := runScenario(t, mock.NewAdapter(board), board.Address, &mock.Scenario{Steps: []mock.Step{
+		mock.Connected(board),
+		mock.Readdress(board, "AA:BB:CC:DD:EE:09", 3*time.Second),
+		mock.Wait(10 * time.Second),
+	}})
+	// A session for an address doesn't follow the board: it is another
+	// device as far as the session can tell.
+	wantKinds(t, events, esp32.SessionConnected, esp32.SessionDisconnected, esp32.SessionRetry)
+	if board.Connected() {
+		t.Error("board connected at its new address")
+	}
+}
+
+func TestScenarioAdapterPowerCycle(t *testing.T) {
+	defer verifyNoLeaks(t)
+
+	board := mock.NewBoard("esp32-test", "AA:BB:CC:DD:EE:01")
+	adapter := mock.NewAdapter(board)
+	steps := []mock.Step{mock.Connected(board)}
+	steps = append(steps, mock.PowerCycle(adapter, 3*time.Second, 10*time.Second)...)
+	steps = append(steps, mock.Connected(board))
+	events := runScenario(t, adapter, board.Name, &mock.Scenario{Steps: steps})
+	wantKinds(t, events,
+		esp32.SessionConnected,
+		esp32.SessionDisconnected,
+		esp32.SessionAdapterOff,
+		esp32.SessionAdapterOn,
+		esp32.SessionConnected,
+	)
+}
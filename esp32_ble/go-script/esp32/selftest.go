@@ -0,0 +1,218 @@
+package esp32
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Self-test wire format. The client subscribes to the self-test
+// characteristic and writes to it:
+//
+//	selfTestStart  u8
+//	tests          u8  bit 1<<t set for each SelfTest t to run
+//
+// The board runs them one at a time, notifying each result as it
+// finishes:
+//
+//	test    u8  the SelfTest
+//	status  u8  the SelfTestStatus
+//	length  u8
+//	detail  length bytes of UTF-8, what was measured or went wrong
+//
+// and then a final notification, test 0, whose status byte is how many
+// tests it ran, so a lost result is noticed.
+const (
+	selfTestStart = 0x01
+	selfTestDone  = 0
+	// selfTestTimeout is the default SelfTestOptions.Timeout.
+	selfTestTimeout = 10 * time.Second
+)
+
+// SelfTest is one of the firmware's built-in diagnostics.
+type SelfTest uint8
+
+const (
+	// SelfTestGPIOLoopback drives each output pin and reads it back
+	// through a loopback jumper.
+	SelfTestGPIOLoopback SelfTest = iota + 1
+	// SelfTestADCReference samples the internal reference voltage and
+	// checks it against the eFuse calibration.
+	SelfTestADCReference
+	// SelfTestMemory writes and verifies patterns across free heap.
+	SelfTestMemory
+)
+
+// SelfTests lists every self-test, in the order boards run them.
+var SelfTests = []SelfTest{SelfTestGPIOLoopback, SelfTestADCReference, SelfTestMemory}
+
+func (t SelfTest) String() string {
+	switch t {
+	case SelfTestGPIOLoopback:
+		return "gpio-loopback"
+	case SelfTestADCReference:
+		return "adc-reference"
+	case SelfTestMemory:
+		return "memory"
+	}
+	return fmt.Sprintf("SelfTest(%d)", uint8(t))
+}
+
+// ParseSelfTest parses a self-test's name.
+func ParseSelfTest(name string) (SelfTest, error) {
+	for _, t := range SelfTests {
+		if t.String() == name {
+			return t, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown self-test %q (want gpio-loopback, adc-reference or memory)", name)
+}
+
+// SelfTestStatus is how a self-test went.
+type SelfTestStatus uint8
+
+const (
+	SelfTestPassed SelfTestStatus = iota
+	SelfTestFailed
+	// SelfTestSkipped is reported by a board that can't run the test,
+	// such as a loopback test with no jumper fitted.
+	SelfTestSkipped
+)
+
+func (s SelfTestStatus) String() string {
+	switch s {
+	case SelfTestPassed:
+		return "passed"
+	case SelfTestFailed:
+		return "failed"
+	case SelfTestSkipped:
+		return "skipped"
+	}
+	return fmt.Sprintf("SelfTestStatus(%d)", uint8(s))
+}
+
+// SelfTestResult is a board's report on one self-test.
+type SelfTestResult struct {
+	Test   SelfTest
+	Status SelfTestStatus
+	Detail string
+}
+
+// SelfTestReport is every result of a self-test run.
+type SelfTestReport struct {
+	Results []SelfTestResult
+}
+
+// Passed reports whether any test ran and none failed.
+func (r SelfTestReport) Passed() bool {
+	ran := false
+	for _, result := range r.Results {
+		switch result.Status {
+		case SelfTestPassed:
+			ran = true
+		case SelfTestSkipped:
+		default:
+			return false
+		}
+	}
+	return ran
+}
+
+// SelfTestOptions configures SelfTest.
+type SelfTestOptions struct {
+	// UUID is the self-test characteristic.
+	UUID string
+	// Tests are the self-tests to run, all of SelfTests by default.
+	Tests []SelfTest
+	// Timeout is how long to wait for each result, 10 seconds by
+	// default; a memory test over a large heap takes a few.
+	Timeout time.Duration
+	// Result, if set, is called with each result as it arrives.
+	Result func(SelfTestResult)
+}
+
+// SelfTest asks the board to run its built-in diagnostics and collects
+// their results. It fails if the board stops reporting or a result is
+// lost; a failed test is in the report rather than an error.
+func (c *Client) SelfTest(ctx context.Context, opts SelfTestOptions) (SelfTestReport, error) {
+	char, err := c.Characteristic(opts.UUID)
+	if err != nil {
+		return SelfTestReport{}, err
+	}
+	tests := opts.Tests
+	if tests == nil {
+		tests = SelfTests
+	}
+	var mask byte
+	for _, t := range tests {
+		if t < 1 || t > 7 {
+			return SelfTestReport{}, fmt.Errorf("self-test %d can't be requested", uint8(t))
+		}
+		mask |= 1 << t
+	}
+	timeout := opts.Timeout
+	if timeout <= 0 {
+		timeout = selfTestTimeout
+	}
+
+	// A run is a handful of notifications, so a small buffer never drops
+	// one.
+	frames := make(chan []byte, 2*len(SelfTests)+2)
+	c.mu.Lock()
+	err = char.EnableNotifications(func(buf []byte) {
+		select {
+		case frames <- append([]byte(nil), buf...):
+		default:
+		}
+	})
+	c.mu.Unlock()
+	if err != nil {
+		return SelfTestReport{}, err
+	}
+	defer func() {
+		c.mu.Lock()
+		char.EnableNotifications(nil)
+		c.mu.Unlock()
+	}()
+	if err := c.write(ctx, char, []byte{selfTestStart, mask}); err != nil {
+		return SelfTestReport{}, fmt.Errorf("starting self-test: %w", err)
+	}
+
+	var report SelfTestReport
+	wait := time.NewTimer(timeout)
+	defer wait.Stop()
+	for {
+		var frame []byte
+		select {
+		case <-ctx.Done():
+			return report, ctx.Err()
+		case <-wait.C:
+			return report, fmt.Errorf("board reported no self-test result for %s", timeout)
+		case frame = <-frames:
+		}
+		wait.Reset(timeout)
+		if len(frame) < 2 {
+			continue
+		}
+		if frame[0] == selfTestDone {
+			if ran := int(frame[1]); ran != len(report.Results) {
+				return report, fmt.Errorf("board ran %d self-tests but %d results arrived", ran, len(report.Results))
+			}
+			if len(report.Results) == 0 {
+				return report, errors.New("board ran none of the self-tests")
+			}
+			return report, nil
+		}
+		result := SelfTestResult{Test: SelfTest(frame[0]), Status: SelfTestStatus(frame[1])}
+		if len(frame) >= 3 {
+			if n := int(frame[2]); len(frame) >= 3+n {
+				result.Detail = string(frame[3 : 3+n])
+			}
+		}
+		report.Results = append(report.Results, result)
+		if opts.Result != nil {
+			opts.Result(result)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package esp32_test
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"bluetooth/esp32"
+	"bluetooth/esp32/mock"
+)
+
+const selfTestUUID = "5a1d0000-0000-4000-8000-000000000021"
+
+func TestSelfTest(t *testing.T) {
+	defer verifyNoLeaks(t)
+
+	for _, tc := range []struct {
+		name   string
+		tests  []esp32.SelfTest
+		set    *esp32.SelfTestResult
+		want   []esp32.SelfTestStatus
+		passed bool
+	}{
+		{"all pass", nil, nil, []esp32.SelfTestStatus{esp32.SelfTestPassed, esp32.SelfTestPassed, esp32.SelfTestPassed}, true},
+		{"adc fails", nil,
+			&esp32.SelfTestResult{Test: esp32.SelfTestADCReference, Status: esp32.SelfTestFailed, Detail: "980 mV, calibrated 1100 mV"},
+			[]esp32.SelfTestStatus{esp32.SelfTestPassed, esp32.SelfTestFailed, esp32.SelfTestPassed}, false},
+		{"loopback skipped", nil,
+			&esp32.SelfTestResult{Test: esp32.SelfTestGPIOLoopback, Status: esp32.SelfTestSkipped, Detail: "no jumper"},
+			[]esp32.SelfTestStatus{esp32.SelfTestSkipped, esp32.SelfTestPassed, esp32.SelfTestPassed}, true},
+		{"only memory", []esp32.SelfTest{esp32.SelfTestMemory}, nil, []esp32.SelfTestStatus{esp32.SelfTestPassed}, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			board := mock.NewBoard("esp32-test", "AA:BB:CC:DD:EE:01")
+			board.EnableSelfTest(selfTestUUID)
+			if tc.set != nil {
+				board.SetSelfTestResult(tc.set.Test, tc.set.Status, tc.set.Detail)
+			}
+			client := connectBoard(t, board)
+			defer client.Disconnect()
+
+			var streamed int
+			report, err := client.SelfTest(context.Background(), esp32.SelfTestOptions{
+				UUID:   selfTestUUID,
+				Tests:  tc.tests,
+				Result: func(esp32.SelfTestResult) { streamed++ },
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []esp32.SelfTestStatus
+			for _, r := range report.Results {
+				got = append(got, r.Status)
+				if tc.set != nil && r.Test == tc.set.Test && r.Detail != tc.set.Detail {
+					t.Errorf("%s detail = %q, want %q", r.Test, r.Detail, tc.set.Detail)
+				}
+			}
+			if !slices.Equal(got, tc.want) {
+				t.Errorf("statuses = %v, want %v", got, tc.want)
+			}
+			if report.Passed() != tc.passed {
+				t.Errorf("Passed() = %v, want %v", report.Passed(), tc.passed)
+			}
+			if streamed != len(report.Results) {
+				t.Errorf("%d results streamed, want %d", streamed, len(report.Results))
+			}
+		})
+	}
+}
+
+func TestSelfTestWithoutCharacteristic(t *testing.T) {
+	defer verifyNoLeaks(t)
+
+	client := connectBoard(t, mock.NewBoard("esp32-test", "AA:BB:CC:DD:EE:01"))
+	defer client.Disconnect()
+	if _, err := client.SelfTest(context.Background(), esp32.SelfTestOptions{UUID: selfTestUUID}); err == nil {
+		t.Fatal("SelfTest succeeded on a board without the characteristic")
+	}
+}
@@ -0,0 +1,374 @@
+package esp32
+
+import (
+	"bufio"
+	"cmp"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The serial protocol carries the pin service over a UART, for boards
+// plugged in over USB when BLE is unreliable. It is line based: each
+// line is a command letter and space-separated arguments, with attribute
+// values hex-encoded but otherwise the same bytes as over GATT (binary
+// pin and ADC frames, JSON pin writes). The host sends:
+//
+//	I                  identify: the board answers "I <name>"
+//	L                  list: one "C <service> <characteristic>..." line
+//	                   per service, then "K"
+//	R <uuid>           read: answered "D <uuid> <hex>"
+//	W <uuid> <hex>     write: answered "K"
+//	S <uuid> 1|0       enable or disable notifications: answered "K"
+//
+// and the board may send "N <uuid> <hex>" notifications at any time. A
+// request that fails is answered "E <message>" instead.
+
+// SerialMTU is the MTU reported for serial links, which have none; it is
+// the longest attribute value GATT allows, so code sizing writes by the
+// MTU behaves as over BLE.
+const SerialMTU = 512
+
+// serialTimeout bounds how long the board may take to answer a request.
+const serialTimeout = 2 * time.Second
+
+// ErrSerialClosed is returned by operations on a serial link that has
+// been closed or has failed.
+var ErrSerialClosed = errors.New("serial link closed")
+
+// SerialAdapter is an Adapter for one board on a serial port. Scanning
+// identifies the board, reporting it with the port as its address.
+type SerialAdapter struct {
+	port string
+	open func() (io.ReadWriteCloser, error)
+
+	mu   sync.Mutex
+	link *serialLink
+	stop chan struct{}
+}
+
+// NewSerialAdapter returns an adapter for the board on port, opening the
+// link with open (OpenSerial, say) when it is first needed.
+func NewSerialAdapter(port string, open func() (io.ReadWriteCloser, error)) *SerialAdapter {
+	return &SerialAdapter{port: port, open: open}
+}
+
+func (a *SerialAdapter) Enable() error {
+	return nil
+}
+
+// connect returns the open link, opening it if there is none.
+func (a *SerialAdapter) connect() (*serialLink, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.link != nil && !a.link.closed() {
+		return a.link, nil
+	}
+	rw, err := a.open()
+	if err != nil {
+		return nil, err
+	}
+	a.link = newSerialLink(rw)
+	return a.link, nil
+}
+
+// Scan identifies the board and reports it once, then waits for
+// StopScan.
+func (a *SerialAdapter) Scan(callback func(ScanResult)) error {
+	a.mu.Lock()
+	if a.stop != nil {
+		a.mu.Unlock()
+		return errors.New("already scanning")
+	}
+	stop := make(chan struct{})
+	a.stop = stop
+	a.mu.Unlock()
+
+	link, err := a.connect()
+	if err != nil {
+		a.StopScan()
+		return err
+	}
+	reply, err := link.request("I")
+	if err != nil {
+		a.StopScan()
+		return fmt.Errorf("identifying the board on %s: %w", a.port, err)
+	}
+	callback(ScanResult{Name: strings.TrimPrefix(strings.TrimPrefix(reply, "I"), " "), Address: a.port})
+	<-stop
+	return nil
+}
+
+func (a *SerialAdapter) StopScan() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.stop == nil {
+		return errors.New("not scanning")
+	}
+	close(a.stop)
+	a.stop = nil
+	return nil
+}
+
+func (a *SerialAdapter) Connect(address string) (Device, error) {
+	if address != a.port {
+		return nil, fmt.Errorf("no board at %s on serial port %s", address, a.port)
+	}
+	link, err := a.connect()
+	if err != nil {
+		return nil, err
+	}
+	return serialDevice{link}, nil
+}
+
+// serialLink is an open serial connection. One goroutine reads it,
+// delivering notifications and handing replies to the request waiting
+// for them.
+type serialLink struct {
+	rw      io.ReadWriteCloser
+	replies chan string
+	done    chan struct{}
+
+	reqMu sync.Mutex // one request at a time
+
+	mu        sync.Mutex
+	callbacks map[string]func([]byte)
+	closeOnce sync.Once
+	err       error
+}
+
+func newSerialLink(rw io.ReadWriteCloser) *serialLink {
+	l := &serialLink{
+		rw:        rw,
+		replies:   make(chan string, 1),
+		done:      make(chan struct{}),
+		callbacks: map[string]func([]byte){},
+	}
+	goTracked(l.read)
+	return l
+}
+
+func (l *serialLink) read() {
+	scanner := bufio.NewScanner(l.rw)
+	scanner.Buffer(nil, 64*1024)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if notification, ok := strings.CutPrefix(line, "N "); ok {
+			l.notified(notification)
+			continue
+		}
+		select {
+		case l.replies <- line:
+		case <-l.done:
+			return
+		}
+	}
+	l.close(cmp.Or(scanner.Err(), ErrSerialClosed))
+}
+
+// notified delivers an "N <uuid> <hex>" notification; malformed ones are
+// dropped, as a corrupt frame over BLE would be.
+func (l *serialLink) notified(notification string) {
+	uuid, value, _ := strings.Cut(notification, " ")
+	data, err := hex.DecodeString(value)
+	if err != nil {
+		return
+	}
+	l.mu.Lock()
+	callback := l.callbacks[strings.ToLower(uuid)]
+	l.mu.Unlock()
+	if callback != nil {
+		callback(data)
+	}
+}
+
+// close closes the link, failing requests with err.
+func (l *serialLink) close(err error) {
+	l.closeOnce.Do(func() {
+		l.mu.Lock()
+		l.err = err
+		l.mu.Unlock()
+		close(l.done)
+		l.rw.Close()
+	})
+}
+
+func (l *serialLink) closed() bool {
+	select {
+	case <-l.done:
+		return true
+	default:
+		return false
+	}
+}
+
+func (l *serialLink) linkErr() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.err
+}
+
+// exchange sends a command line and passes the board's reply lines to
+// handle until it reports the reply complete. An "E <message>" line fails
+// the request with the message.
+func (l *serialLink) exchange(line string, handle func(reply string) (done bool, err error)) error {
+	l.reqMu.Lock()
+	defer l.reqMu.Unlock()
+	if l.closed() {
+		return l.linkErr()
+	}
+	// A reply to an earlier request that timed out is stale.
+	select {
+	case <-l.replies:
+	default:
+	}
+	if _, err := io.WriteString(l.rw, line+"\n"); err != nil {
+		l.close(err)
+		return err
+	}
+	timer := time.NewTimer(serialTimeout)
+	defer timer.Stop()
+	for {
+		select {
+		case reply := <-l.replies:
+			if msg, ok := strings.CutPrefix(reply, "E "); ok {
+				return errors.New(msg)
+			}
+			if done, err := handle(reply); done || err != nil {
+				return err
+			}
+		case <-l.done:
+			return l.linkErr()
+		case <-timer.C:
+			return fmt.Errorf("no reply to %q within %v", strings.Fields(line)[0], serialTimeout)
+		}
+	}
+}
+
+// request is exchange for commands answered with a single line.
+func (l *serialLink) request(line string) (string, error) {
+	var reply string
+	err := l.exchange(line, func(r string) (bool, error) {
+		reply = r
+		return true, nil
+	})
+	return reply, err
+}
+
+// expectOK checks a reply is the plain acknowledgement "K".
+func expectOK(reply string, err error) error {
+	if err != nil {
+		return err
+	}
+	if reply != "K" {
+		return fmt.Errorf("unexpected reply %q", reply)
+	}
+	return nil
+}
+
+type serialDevice struct {
+	link *serialLink
+}
+
+func (d serialDevice) DiscoverServices() ([]Service, error) {
+	var services []Service
+	err := d.link.exchange("L", func(reply string) (bool, error) {
+		if reply == "K" {
+			return true, nil
+		}
+		fields := strings.Fields(reply)
+		if len(fields) < 2 || fields[0] != "C" {
+			return false, fmt.Errorf("unexpected reply %q", reply)
+		}
+		s := serialService{uuid: fields[1]}
+		for _, uuid := range fields[2:] {
+			s.chars = append(s.chars, &serialCharacteristic{link: d.link, uuid: uuid})
+		}
+		services = append(services, s)
+		return false, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return services, nil
+}
+
+func (d serialDevice) Disconnect() error {
+	d.link.close(ErrSerialClosed)
+	return nil
+}
+
+type serialService struct {
+	uuid  string
+	chars []Characteristic
+}
+
+func (s serialService) UUID() string {
+	return s.uuid
+}
+
+func (s serialService) DiscoverCharacteristics() ([]Characteristic, error) {
+	return s.chars, nil
+}
+
+type serialCharacteristic struct {
+	link *serialLink
+	uuid string
+}
+
+func (c *serialCharacteristic) UUID() string {
+	return c.uuid
+}
+
+func (c *serialCharacteristic) Read(buf []byte) (int, error) {
+	reply, err := c.link.request("R " + c.uuid)
+	if err != nil {
+		return 0, err
+	}
+	fields := strings.Fields(reply)
+	if len(fields) < 2 || fields[0] != "D" || !strings.EqualFold(fields[1], c.uuid) {
+		return 0, fmt.Errorf("unexpected reply %q", reply)
+	}
+	var value []byte
+	if len(fields) > 2 {
+		if value, err = hex.DecodeString(fields[2]); err != nil {
+			return 0, fmt.Errorf("bad value in reply: %w", err)
+		}
+	}
+	return copy(buf, value), nil
+}
+
+// Write waits for the board's acknowledgement, so over serial every
+// write is a write with response.
+func (c *serialCharacteristic) Write(p []byte) (int, error) {
+	if err := expectOK(c.link.request("W " + c.uuid + " " + hex.EncodeToString(p))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (c *serialCharacteristic) EnableNotifications(callback func(buf []byte)) error {
+	on := "1"
+	if callback == nil {
+		on = "0"
+	}
+	if err := expectOK(c.link.request("S " + c.uuid + " " + on)); err != nil {
+		return err
+	}
+	c.link.mu.Lock()
+	defer c.link.mu.Unlock()
+	if callback == nil {
+		delete(c.link.callbacks, strings.ToLower(c.uuid))
+	} else {
+		c.link.callbacks[strings.ToLower(c.uuid)] = callback
+	}
+	return nil
+}
+
+func (c *serialCharacteristic) MTU() (uint16, error) {
+	return SerialMTU, nil
+}
@@ -0,0 +1,15 @@
+//go:build !linux
+
+package esp32
+
+import (
+	"io"
+	"os"
+)
+
+// OpenSerial opens a serial port. The port is used as the system has it
+// configured; setting the baud rate is only supported on Linux, so baud
+// is ignored.
+func OpenSerial(path string, baud int) (io.ReadWriteCloser, error) {
+	return os.OpenFile(path, os.O_RDWR, 0)
+}
@@ -0,0 +1,92 @@
+package esp32_test
+
+import (
+	"context"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"bluetooth/esp32"
+	"bluetooth/esp32/mock"
+)
+
+func TestSerialTransport(t *testing.T) {
+	defer verifyNoLeaks(t)
+
+	board := mock.NewBoard("esp32-test", "AA:BB:CC:DD:EE:01")
+	board.SetADC(35, 1234)
+	served := make(chan struct{})
+	adapter := esp32.NewSerialAdapter("/dev/ttyUSB0", func() (io.ReadWriteCloser, error) {
+		host, dev := net.Pipe()
+		go func() {
+			defer close(served)
+			board.ServeSerial(dev)
+		}()
+		return host, nil
+	})
+
+	result, err := esp32.FindDevice(context.Background(), adapter, "esp32-test", time.Second, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Address != "/dev/ttyUSB0" {
+		t.Errorf("Address = %s, want the port", result.Address)
+	}
+	client, err := esp32.Connect(context.Background(), adapter, result)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	readings, err := client.ReadADC(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(readings) != 2 || readings[0].Pin != 35 || readings[0].Value != 1234 {
+		t.Errorf("ReadADC() = %+v, want pin 35 = 1234 first", readings)
+	}
+
+	if err := client.WritePins(context.Background(), []esp32.PinWrite{{PinNum: 14, State: 1}}); err != nil {
+		t.Fatal(err)
+	}
+	if got := board.Pin(14); got != 1 {
+		t.Errorf("pin 14 = %d, want 1", got)
+	}
+
+	notified := make(chan []esp32.Reading, 1)
+	if err := client.SubscribeADC(func(r []esp32.Reading) {
+		select {
+		case notified <- r:
+		default:
+		}
+	}); err != nil {
+		t.Fatal(err)
+	}
+	board.SetADC(35, 42)
+	board.Notify()
+	select {
+	case r := <-notified:
+		if r[0].Value != 42 {
+			t.Errorf("notified %+v, want pin 35 = 42", r)
+		}
+	case <-time.After(time.Second):
+		t.Error("no notification over serial")
+	}
+
+	// Errors come back from the board as E replies.
+	adc, err := client.Characteristic(esp32.ADCDataOutputUUID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := adc.Write([]byte{1}); err == nil || !strings.Contains(err.Error(), "not writable") {
+		t.Errorf("writing the ADC characteristic = %v, want the board's error", err)
+	}
+	if err := client.Disconnect(); err != nil {
+		t.Fatal(err)
+	}
+	<-served
+	if board.Connected() {
+		t.Error("board still connected after Disconnect")
+	}
+}
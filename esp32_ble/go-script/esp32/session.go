@@ -0,0 +1,335 @@
+package esp32
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// SessionEventKind identifies what happened to a Session.
+type SessionEventKind int
+
+const (
+	// SessionConnected means the board was found and connected.
+	SessionConnected SessionEventKind = iota
+	// SessionDisconnected means the connection ended with Err.
+	SessionDisconnected
+	// SessionRetry means finding, connecting or writing the Failsafe
+	// states failed with Err and will be retried.
+	SessionRetry
+	// SessionAdapterOff means the adapter lost power; the session waits
+	// for it to return.
+	SessionAdapterOff
+	// SessionAdapterOn means the adapter is powered again; it is
+	// re-enabled before the next connection attempt.
+	SessionAdapterOn
+	// SessionSuspending means the host is about to sleep; the connection
+	// is torn down.
+	SessionSuspending
+	// SessionResumed means the host woke after sleeping for Gap; the
+	// connection is torn down and rebuilt, and no data exists for the gap.
+	SessionResumed
+	// SessionUnknownPayload means a notification carried a frame its
+	// decoder didn't recognize; Err is the *UnknownPayloadError.
+	SessionUnknownPayload
+	// SessionIdle means fn returned ErrSessionIdle; the board is
+	// disconnected until Wake fires.
+	SessionIdle
+	// SessionWoken means Wake fired for an idle board, which is connected
+	// again.
+	SessionWoken
+	// SessionUnidentified means the profile's Identifier failed with Err;
+	// until the next connection the board's readings carry its address
+	// as its ID.
+	SessionUnidentified
+)
+
+// SessionEvent reports a change in a Session's state.
+type SessionEvent struct {
+	Kind   SessionEventKind
+	Name   string
+	Client *Client
+	Err    error
+	Gap    time.Duration
+	// Scan is how long the board took to find, for SessionConnected.
+	Scan time.Duration
+}
+
+// Session keeps a named board connected, reconnecting whenever the link
+// drops and waiting out adapter power loss.
+type Session struct {
+	Manager *Manager
+	// Pool, if set, is used instead of Manager: every connection attempt
+	// goes through the least loaded powered adapter, so when an adapter
+	// fails its boards move to the others.
+	Pool *Pool
+	// Name is the board's advertised name or its address.
+	Name string
+	// Profile is applied to every client the session connects.
+	Profile Profile
+	// Capture, if set, records every client the session connects.
+	Capture *Capture
+	// WritePolicy, if set, is applied to every client the session
+	// connects.
+	WritePolicy *WritePolicy
+	// Failsafe, if set, is written to every client the session connects,
+	// under its write policy, before fn is called, so outputs left on
+	// when the link dropped are put back in a known state. If the writes
+	// fail the board is disconnected and the attempt retried.
+	Failsafe []PinWrite
+	// ScanTimeout bounds each attempt to find the board (default 30s).
+	ScanTimeout time.Duration
+	// RetryDelay is the pause between attempts (default 2s).
+	RetryDelay time.Duration
+	// PowerPoll is how often a powered-off adapter is checked (default 1s).
+	PowerPoll time.Duration
+	// Seen, if set, is called for every advertisement while scanning.
+	Seen func(ScanResult)
+	// Sleep, if set, lets the session tear down its connection when the
+	// host suspends and rebuild it on wake, instead of reading through a
+	// stale link.
+	Sleep *SleepMonitor
+	// Wake is received from when the board is wanted again after fn
+	// returned ErrSessionIdle. If nil, an idle session stays disconnected
+	// until ctx is done.
+	Wake <-chan struct{}
+	// StartIdle, if set, makes Run wait for Wake before its first
+	// connection, as though fn had already returned ErrSessionIdle.
+	StartIdle bool
+	// OnEvent, if set, is called as the session changes state. Sleep and
+	// unknown payload events are reported from other goroutines while fn
+	// is running.
+	OnEvent func(SessionEvent)
+}
+
+func (s *Session) event(e SessionEvent) {
+	e.Name = s.Name
+	if s.OnEvent != nil {
+		s.OnEvent(e)
+	}
+}
+
+// Run connects to the board and calls fn with the client, until ctx is
+// done. When fn returns (typically because a read or write failed) the
+// client is disconnected and the session reconnects and calls fn again.
+// Run returns nil once ctx is done, and only returns early if fn returns
+// ErrStopSession. If fn returns ErrSessionIdle the session waits for Wake
+// before reconnecting, leaving the board free for other centrals.
+func (s *Session) Run(ctx context.Context, fn func(*Client) error) error {
+	timeout := s.ScanTimeout
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+	if s.StartIdle {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-s.Wake:
+		}
+		s.event(SessionEvent{Kind: SessionWoken})
+	}
+
+	for ctx.Err() == nil {
+		m, release := s.acquire(ctx)
+		if m == nil {
+			return nil
+		}
+		if err := m.Enable(); err != nil {
+			release()
+			s.event(SessionEvent{Kind: SessionRetry, Err: err})
+			s.pause(ctx)
+			continue
+		}
+
+		scanStart := time.Now()
+		results, err := m.FindDevices(ctx, []string{s.Name}, timeout, s.Seen)
+		scan := time.Since(scanStart)
+		if ctx.Err() != nil {
+			release()
+			return nil
+		}
+		if err != nil {
+			release()
+			s.event(SessionEvent{Kind: SessionRetry, Err: err})
+			s.pause(ctx)
+			continue
+		}
+		client, err := m.Connect(ctx, results[0])
+		release()
+		if ctx.Err() != nil {
+			if err == nil {
+				m.Disconnect(client)
+			}
+			return nil
+		}
+		if err != nil {
+			s.event(SessionEvent{Kind: SessionRetry, Err: err})
+			s.pause(ctx)
+			continue
+		}
+
+		client.SetProfile(s.Profile)
+		if err := client.Identify(ctx, s.Profile.Identifier()); err != nil {
+			s.event(SessionEvent{Kind: SessionUnidentified, Client: client, Err: err})
+		}
+		client.SetWritePolicy(s.WritePolicy)
+		client.SetUnknownPayloadHandler(func(err *UnknownPayloadError) {
+			s.event(SessionEvent{Kind: SessionUnknownPayload, Client: client, Err: err})
+		})
+		if s.Capture != nil {
+			client.Capture(s.Capture)
+		}
+		if len(s.Failsafe) > 0 {
+			if err := client.WritePins(ctx, s.Failsafe); err != nil {
+				m.Disconnect(client)
+				if ctx.Err() != nil {
+					return nil
+				}
+				s.event(SessionEvent{Kind: SessionRetry, Err: fmt.Errorf("applying failsafe states: %w", err)})
+				s.pause(ctx)
+				continue
+			}
+		}
+		s.event(SessionEvent{Kind: SessionConnected, Client: client, Scan: scan})
+		stopWatching := s.watchSleep(m, client)
+		err = fn(client)
+		stopWatching()
+		m.Disconnect(client)
+		if errors.Is(err, ErrStopSession) {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return nil
+		}
+		if errors.Is(err, ErrSessionIdle) {
+			s.event(SessionEvent{Kind: SessionIdle, Client: client})
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-s.Wake:
+			}
+			s.event(SessionEvent{Kind: SessionWoken})
+			continue
+		}
+		s.event(SessionEvent{Kind: SessionDisconnected, Client: client, Err: err})
+		s.pause(ctx)
+	}
+	return nil
+}
+
+// acquire returns the manager to make the next connection attempt
+// through, waiting for a powered adapter (and, with a Pool, one with spare
+// capacity). release must be called once the attempt has finished. It
+// returns a nil manager if ctx is done first.
+func (s *Session) acquire(ctx context.Context) (*Manager, func()) {
+	if s.Pool == nil {
+		if !s.waitPowered(ctx) {
+			return nil, nil
+		}
+		return s.Manager, func() {}
+	}
+
+	off := false
+	for {
+		m, release, err := s.Pool.Acquire()
+		switch {
+		case err == nil:
+			if off {
+				s.event(SessionEvent{Kind: SessionAdapterOn})
+			}
+			return m, release
+		case errors.Is(err, ErrNoPoweredAdapter):
+			if !off {
+				off = true
+				s.event(SessionEvent{Kind: SessionAdapterOff})
+			}
+			s.wait(ctx, s.PowerPoll, time.Second)
+		default:
+			s.event(SessionEvent{Kind: SessionRetry, Err: err})
+			s.pause(ctx)
+		}
+		if ctx.Err() != nil {
+			return nil, nil
+		}
+	}
+}
+
+// ErrStopSession can be returned by a Session's fn to end Run.
+var ErrStopSession = errors.New("session stopped")
+
+// ErrSessionIdle can be returned by a Session's fn to disconnect the
+// board until the session's Wake fires.
+var ErrSessionIdle = errors.New("session idle")
+
+// watchSleep disconnects client, connected through m, if the host
+// suspends or resumes while it is in use, so fn's next operation fails and
+// the session reconnects. The returned function stops watching.
+func (s *Session) watchSleep(m *Manager, client *Client) func() {
+	if s.Sleep == nil {
+		return func() {}
+	}
+	events, unsubscribe := s.Sleep.Subscribe()
+	done := make(chan struct{})
+	goTracked(func() {
+		defer close(done)
+		for e := range events {
+			if e.Suspending {
+				s.event(SessionEvent{Kind: SessionSuspending, Client: client})
+			} else {
+				s.event(SessionEvent{Kind: SessionResumed, Client: client, Gap: e.Gap})
+			}
+			m.Disconnect(client)
+		}
+	})
+	return func() {
+		unsubscribe()
+		<-done
+	}
+}
+
+// waitPowered blocks until the adapter is powered, reporting the outage.
+// It returns false if ctx is done first.
+func (s *Session) waitPowered(ctx context.Context) bool {
+	if s.Manager.Powered() {
+		return true
+	}
+	s.Manager.AdapterLost()
+	s.event(SessionEvent{Kind: SessionAdapterOff})
+
+	poll := s.PowerPoll
+	if poll <= 0 {
+		poll = time.Second
+	}
+	ticker := time.NewTicker(poll)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-ticker.C:
+		}
+		if s.Manager.Powered() {
+			s.event(SessionEvent{Kind: SessionAdapterOn})
+			return true
+		}
+	}
+}
+
+// pause waits RetryDelay or until ctx is done.
+func (s *Session) pause(ctx context.Context) {
+	s.wait(ctx, s.RetryDelay, 2*time.Second)
+}
+
+// wait waits d, or fallback if d isn't positive, or until ctx is done.
+func (s *Session) wait(ctx context.Context, d, fallback time.Duration) {
+	if d <= 0 {
+		d = fallback
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+	case <-timer.C:
+	}
+}
@@ -0,0 +1,262 @@
+package esp32_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"testing"
+	"time"
+
+	"bluetooth/esp32"
+	"bluetooth/esp32/mock"
+)
+
+func TestSessionSurvivesAdapterPowerCycle(t *testing.T) {
+	defer verifyNoLeaks(t)
+
+	board := mock.NewBoard("esp32-test", "AA:BB:CC:DD:EE:01")
+	adapter := mock.NewAdapter(board)
+
+	var kinds []esp32.SessionEventKind
+	session := &esp32.Session{
+		Manager:     esp32.NewManager(adapter),
+		Name:        board.Name,
+		ScanTimeout: time.Second,
+		RetryDelay:  time.Millisecond,
+		PowerPoll:   time.Millisecond,
+		OnEvent: func(e esp32.SessionEvent) {
+			kinds = append(kinds, e.Kind)
+		},
+	}
+
+	connects := 0
+	err := session.Run(context.Background(), func(client *esp32.Client) error {
+		connects++
+		if connects == 2 {
+			return esp32.ErrStopSession
+		}
+		if _, err := client.ReadADC(context.Background()); err != nil {
+			t.Fatalf("first read: %v", err)
+		}
+
+		adapter.SetPowered(false)
+		go func() {
+			time.Sleep(20 * time.Millisecond)
+			adapter.SetPowered(true)
+		}()
+		_, err := client.ReadADC(context.Background())
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []esp32.SessionEventKind{
+		esp32.SessionConnected,
+		esp32.SessionDisconnected,
+		esp32.SessionAdapterOff,
+		esp32.SessionAdapterOn,
+		esp32.SessionConnected,
+	}
+	if len(kinds) != len(want) {
+		t.Fatalf("events = %v, want %v", kinds, want)
+	}
+	for i := range want {
+		if kinds[i] != want[i] {
+			t.Fatalf("events = %v, want %v", kinds, want)
+		}
+	}
+}
+
+func TestSessionIdle(t *testing.T) {
+	defer verifyNoLeaks(t)
+
+	board := mock.NewBoard("esp32-test", "AA:BB:CC:DD:EE:01")
+	wake := make(chan struct{})
+	var kinds []esp32.SessionEventKind
+	session := &esp32.Session{
+		Manager:     esp32.NewManager(mock.NewAdapter(board)),
+		Name:        board.Name,
+		ScanTimeout: time.Second,
+		Wake:        wake,
+		OnEvent: func(e esp32.SessionEvent) {
+			kinds = append(kinds, e.Kind)
+			if e.Kind == esp32.SessionIdle {
+				if board.Connected() {
+					t.Error("board still connected while idle")
+				}
+				go func() { wake <- struct{}{} }()
+			}
+		},
+	}
+
+	connects := 0
+	err := session.Run(context.Background(), func(client *esp32.Client) error {
+		connects++
+		if connects == 2 {
+			return esp32.ErrStopSession
+		}
+		return esp32.ErrSessionIdle
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []esp32.SessionEventKind{esp32.SessionConnected, esp32.SessionIdle, esp32.SessionWoken, esp32.SessionConnected}
+	if fmt.Sprint(kinds) != fmt.Sprint(want) {
+		t.Errorf("events = %v, want %v", kinds, want)
+	}
+}
+
+func TestSessionStartIdle(t *testing.T) {
+	defer verifyNoLeaks(t)
+
+	board := mock.NewBoard("esp32-test", "AA:BB:CC:DD:EE:01")
+	wake := make(chan struct{}, 1)
+	var kinds []esp32.SessionEventKind
+	session := &esp32.Session{
+		Manager:     esp32.NewManager(mock.NewAdapter(board)),
+		Name:        board.Name,
+		ScanTimeout: time.Second,
+		Wake:        wake,
+		StartIdle:   true,
+		OnEvent:     func(e esp32.SessionEvent) { kinds = append(kinds, e.Kind) },
+	}
+
+	// Nothing is connected until Wake fires.
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	session.Run(ctx, func(*esp32.Client) error {
+		t.Error("connected before Wake")
+		return esp32.ErrStopSession
+	})
+
+	wake <- struct{}{}
+	session.Run(context.Background(), func(*esp32.Client) error { return esp32.ErrStopSession })
+	want := []esp32.SessionEventKind{esp32.SessionWoken, esp32.SessionConnected}
+	if fmt.Sprint(kinds) != fmt.Sprint(want) {
+		t.Errorf("events = %v, want %v", kinds, want)
+	}
+}
+
+func TestSessionFailsafe(t *testing.T) {
+	defer verifyNoLeaks(t)
+
+	board := mock.NewBoard("esp32-test", "AA:BB:CC:DD:EE:01")
+	var kinds []esp32.SessionEventKind
+	session := &esp32.Session{
+		Manager:     esp32.NewManager(mock.NewAdapter(board)),
+		Name:        board.Name,
+		ScanTimeout: time.Second,
+		RetryDelay:  time.Millisecond,
+		WritePolicy: &esp32.WritePolicy{Attempts: 1},
+		Failsafe:    []esp32.PinWrite{{PinNum: 26, State: 0}},
+		OnEvent:     func(e esp32.SessionEvent) { kinds = append(kinds, e.Kind) },
+	}
+
+	connects := 0
+	err := session.Run(context.Background(), func(client *esp32.Client) error {
+		connects++
+		if got := board.Pin(26); got != 0 {
+			t.Errorf("connection %d: pin 26 is %d, want its failsafe state 0", connects, got)
+		}
+		if connects == 2 {
+			return esp32.ErrStopSession
+		}
+		if err := client.WritePins(context.Background(), []esp32.PinWrite{{PinNum: 26, State: 1}}); err != nil {
+			t.Fatal(err)
+		}
+		// The link drops with the relay on, and the first failsafe write
+		// after reconnecting is lost too.
+		board.LoseWrites(1)
+		board.Drop()
+		_, err := client.ReadADC(context.Background())
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []esp32.SessionEventKind{esp32.SessionConnected, esp32.SessionDisconnected, esp32.SessionRetry, esp32.SessionConnected}
+	if fmt.Sprint(kinds) != fmt.Sprint(want) {
+		t.Errorf("events = %v, want %v", kinds, want)
+	}
+}
+
+func TestSessionResume(t *testing.T) {
+	defer verifyNoLeaks(t)
+
+	board := mock.NewBoard("esp32-test", "AA:BB:CC:DD:EE:01")
+	var log bytes.Buffer
+	logWriter, err := esp32.NewCSVWriter(&log, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sleep := make(chan esp32.SleepEvent)
+	defer close(sleep)
+	var kinds []esp32.SessionEventKind
+	session := &esp32.Session{
+		Manager:     esp32.NewManager(mock.NewAdapter(board)),
+		Name:        board.Name,
+		ScanTimeout: time.Second,
+		RetryDelay:  time.Millisecond,
+		Sleep:       esp32.NewSleepMonitorFrom(sleep),
+		OnEvent: func(e esp32.SessionEvent) {
+			kinds = append(kinds, e.Kind)
+			// As the commands log it, so the gap shows in the log.
+			if e.Kind == esp32.SessionResumed {
+				logWriter.WriteGap(time.Now().Add(-e.Gap), e.Client.Name, e.Client.Address, e.Client.ID)
+			}
+		},
+	}
+
+	connects := 0
+	err = session.Run(context.Background(), func(client *esp32.Client) error {
+		connects++
+		if connects == 2 {
+			return esp32.ErrStopSession
+		}
+		if _, err := client.ReadADC(context.Background()); err != nil {
+			t.Fatalf("read before sleeping: %v", err)
+		}
+		sleep <- esp32.SleepEvent{Gap: time.Hour}
+		// The session drops the stale link itself, so the next
+		// operation fails rather than reading through it.
+		deadline := time.Now().Add(time.Second)
+		for board.Connected() {
+			if time.Now().After(deadline) {
+				t.Fatal("still connected after resuming")
+			}
+			time.Sleep(time.Millisecond)
+		}
+		_, err := client.ReadADC(context.Background())
+		if err == nil {
+			t.Error("read after resuming succeeded")
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []esp32.SessionEventKind{esp32.SessionConnected, esp32.SessionResumed, esp32.SessionDisconnected, esp32.SessionConnected}
+	if fmt.Sprint(kinds) != fmt.Sprint(want) {
+		t.Errorf("events = %v, want %v", kinds, want)
+	}
+	records, err := csv.NewReader(&log).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("log = %q, want a header and a gap marker", records)
+	}
+	start, err := time.Parse(time.RFC3339Nano, records[1][0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gap := time.Since(start); gap < time.Hour || gap > time.Hour+time.Minute {
+		t.Errorf("gap marker starts %v ago, want an hour", gap)
+	}
+	if got := fmt.Sprint(records[1][1:]); got != fmt.Sprint([]string{board.Name, board.Address, board.Address, "", ""}) {
+		t.Errorf("gap marker = %q, want the board with empty pin and value", records[1])
+	}
+}
@@ -0,0 +1,74 @@
+package esp32
+
+import (
+	"context"
+	"slices"
+	"time"
+)
+
+// sharedRead is one read of a characteristic that concurrent ReadShared
+// calls wait on together. Once done it is kept as the characteristic's
+// latest readings.
+type sharedRead struct {
+	done     chan struct{}
+	readings []Reading
+	at       time.Time
+	err      error
+}
+
+// ReadShared reads and decodes uuid like ReadADC, but joins a read of
+// uuid another goroutine already has in flight instead of starting one,
+// and, if maxAge is positive, returns the readings of a read finished
+// within maxAge without reading at all. It is for servers fronting a
+// board for many clients, where the BLE link is the bottleneck. Each
+// frame is decoded once, so stateful decoders see it once. Failed reads
+// aren't kept, and WritePins forgets every kept read, so a read after a
+// write sees its effect. If ctx is done first ReadShared returns
+// ctx.Err(); the read still finishes for the others waiting on it.
+func (c *Client) ReadShared(ctx context.Context, uuid string, maxAge time.Duration) ([]Reading, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	c.readMu.Lock()
+	r := c.reads[uuid]
+	if r == nil || r.finished() && (r.err != nil || maxAge <= 0 || time.Since(r.at) > maxAge) {
+		r = &sharedRead{done: make(chan struct{})}
+		if c.reads == nil {
+			c.reads = map[string]*sharedRead{}
+		}
+		c.reads[uuid] = r
+		goTracked(func() {
+			frame, err := c.ReadRaw(context.Background(), uuid)
+			if err == nil {
+				r.readings, err = c.Decode(uuid, frame, time.Now())
+			}
+			r.at, r.err = time.Now(), err
+			close(r.done)
+		})
+	}
+	c.readMu.Unlock()
+
+	select {
+	case <-r.done:
+		return slices.Clone(r.readings), r.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (r *sharedRead) finished() bool {
+	select {
+	case <-r.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// forgetReads drops the kept reads, once the board's state has changed.
+// Reads in flight are still shared with those already waiting on them.
+func (c *Client) forgetReads() {
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+	clear(c.reads)
+}
@@ -0,0 +1,83 @@
+package esp32_test
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+
+	"bluetooth/esp32"
+	"bluetooth/esp32/mock"
+)
+
+// reads returns how many times the client has read uuid.
+func reads(client *esp32.Client, uuid string) uint64 {
+	for _, s := range client.CharacteristicStats() {
+		if s.UUID == uuid {
+			return s.Reads
+		}
+	}
+	return 0
+}
+
+func TestReadSharedCoalesces(t *testing.T) {
+	defer verifyNoLeaks(t)
+
+	board := mock.NewBoard("esp32-test", "AA:BB:CC:DD:EE:01")
+	board.SetADC(35, 1234)
+	// Every read stalls, so the readers overlap.
+	board.InjectFaults(mock.Faults{Stall: 1, StallFor: 50 * time.Millisecond}, rand.New(rand.NewSource(1)))
+	client := connectMock(t, board)
+	uuid := client.Profile().ADCOutputUUID
+
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			readings, err := client.ReadShared(context.Background(), uuid, 0)
+			if err != nil || len(readings) == 0 || readings[0].Value != 1234 {
+				t.Errorf("ReadShared = %v, %v", readings, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := reads(client, uuid); n != 1 {
+		t.Errorf("%d reads for 10 concurrent ReadShared calls, want 1", n)
+	}
+	client.Disconnect()
+}
+
+func TestReadSharedMaxAge(t *testing.T) {
+	board := mock.NewBoard("esp32-test", "AA:BB:CC:DD:EE:01")
+	board.SetADC(35, 1234)
+	client := connectMock(t, board)
+	uuid := client.Profile().ADCOutputUUID
+
+	read := func(maxAge time.Duration) int {
+		t.Helper()
+		readings, err := client.ReadShared(context.Background(), uuid, maxAge)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return readings[0].Value
+	}
+	read(time.Minute)
+	board.SetADC(35, 99)
+	if v := read(time.Minute); v != 1234 || reads(client, uuid) != 1 {
+		t.Errorf("fresh read = %d after %d reads, want the kept 1234 after 1", v, reads(client, uuid))
+	}
+	if v := read(0); v != 99 {
+		t.Errorf("read with no max age = %d, want 99", v)
+	}
+
+	// A write forgets the kept readings.
+	board.SetADC(35, 7)
+	if err := client.WritePins(context.Background(), []esp32.PinWrite{{PinNum: 14, State: 1}}); err != nil {
+		t.Fatal(err)
+	}
+	if v := read(time.Minute); v != 7 {
+		t.Errorf("read after a write = %d, want 7", v)
+	}
+}
@@ -0,0 +1,99 @@
+package esp32
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// SleepEvent reports the host going to sleep or waking up.
+type SleepEvent struct {
+	// Suspending is true when the host is about to sleep, where the
+	// platform announces it, and false on wake.
+	Suspending bool
+	// Gap is how long the host was asleep, on wake.
+	Gap time.Duration
+}
+
+// SleepMonitor detects host suspend and resume and fans the events out to
+// subscribers. Wake-ups are detected by the wall clock jumping ahead of the
+// monotonic clock, which doesn't advance while suspended on Linux and
+// macOS; on Linux, logind's PrepareForSleep signal also announces suspend
+// in advance.
+type SleepMonitor struct {
+	mu   sync.Mutex
+	subs map[chan SleepEvent]struct{}
+}
+
+// NewSleepMonitor starts watching for suspend and resume until ctx is
+// done. Clock jumps larger than threshold count as a sleep.
+func NewSleepMonitor(ctx context.Context, threshold time.Duration) *SleepMonitor {
+	m := &SleepMonitor{subs: map[chan SleepEvent]struct{}{}}
+	goTracked(func() { m.watchClock(ctx, threshold) })
+	watchPlatformSleep(ctx, m.publish)
+	return m
+}
+
+// NewSleepMonitorFrom returns a monitor reporting the events received
+// from events, until it is closed, instead of watching the host: for
+// hosts that announce sleep some other way, and for tests.
+func NewSleepMonitorFrom(events <-chan SleepEvent) *SleepMonitor {
+	m := &SleepMonitor{subs: map[chan SleepEvent]struct{}{}}
+	goTracked(func() {
+		for e := range events {
+			m.publish(e)
+		}
+	})
+	return m
+}
+
+// Subscribe returns a channel receiving sleep events and a function that
+// unsubscribes and closes it. Events are dropped for slow subscribers.
+func (m *SleepMonitor) Subscribe() (<-chan SleepEvent, func()) {
+	ch := make(chan SleepEvent, 4)
+	m.mu.Lock()
+	m.subs[ch] = struct{}{}
+	m.mu.Unlock()
+	return ch, func() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		if _, ok := m.subs[ch]; ok {
+			delete(m.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+func (m *SleepMonitor) publish(e SleepEvent) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for ch := range m.subs {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
+
+// watchClock ticks every second and reports a wake whenever more wall
+// time than monotonic time has passed since the last tick.
+func (m *SleepMonitor) watchClock(ctx context.Context, threshold time.Duration) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	last := time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		now := time.Now()
+		// Round(0) strips the monotonic reading, comparing wall clocks.
+		wall := now.Round(0).Sub(last.Round(0))
+		mono := now.Sub(last)
+		if gap := wall - mono; gap > threshold {
+			m.publish(SleepEvent{Gap: gap})
+		}
+		last = now
+	}
+}
@@ -0,0 +1,46 @@
+//go:build linux
+
+package esp32
+
+import (
+	"context"
+
+	"github.com/godbus/dbus/v5"
+)
+
+// watchPlatformSleep forwards logind's PrepareForSleep(true) signal so
+// sessions can disconnect cleanly before the host suspends. Wake-ups are
+// left to the clock watcher, which also measures the gap. Without logind
+// this does nothing.
+func watchPlatformSleep(ctx context.Context, publish func(SleepEvent)) {
+	bus, err := dbus.SystemBus()
+	if err != nil {
+		return
+	}
+	err = bus.AddMatchSignal(
+		dbus.WithMatchInterface("org.freedesktop.login1.Manager"),
+		dbus.WithMatchMember("PrepareForSleep"),
+	)
+	if err != nil {
+		return
+	}
+	signals := make(chan *dbus.Signal, 4)
+	bus.Signal(signals)
+
+	goTracked(func() {
+		defer bus.RemoveSignal(signals)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case sig := <-signals:
+				if sig.Name != "org.freedesktop.login1.Manager.PrepareForSleep" || len(sig.Body) == 0 {
+					continue
+				}
+				if suspending, _ := sig.Body[0].(bool); suspending {
+					publish(SleepEvent{Suspending: true})
+				}
+			}
+		}
+	})
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+
+package esp32
+
+import "context"
+
+// watchPlatformSleep does nothing where there's no suspend announcement;
+// the clock watcher still detects wake-ups.
+func watchPlatformSleep(context.Context, func(SleepEvent)) {}
@@ -0,0 +1,32 @@
+package esp32
+
+import (
+	"context"
+	"time"
+)
+
+// Snapshot is the board's pin and ADC readings taken together.
+type Snapshot struct {
+	Time    time.Time `json:"time"`
+	Device  string    `json:"device"`
+	Address string    `json:"address"`
+	ID      string    `json:"id"`
+	Pins    []Reading `json:"pins"`
+	ADC     []Reading `json:"adc"`
+}
+
+// Snapshot reads the pin and then the ADC characteristic, the readings
+// marked as coming from this client's board.
+func (c *Client) Snapshot(ctx context.Context) (Snapshot, error) {
+	s := Snapshot{Time: time.Now(), Device: c.Name, Address: c.Address, ID: c.ID}
+	var err error
+	if s.Pins, err = c.ReadPins(ctx); err != nil {
+		return Snapshot{}, err
+	}
+	if s.ADC, err = c.ReadADC(ctx); err != nil {
+		return Snapshot{}, err
+	}
+	c.Tag(s.Pins)
+	c.Tag(s.ADC)
+	return s, nil
+}
@@ -0,0 +1,230 @@
+package esp32
+
+import (
+	"cmp"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Schedule wire format. Reading the schedule characteristic returns the
+// board's clock:
+//
+//	now  u64  microseconds since the board booted, little-endian
+//
+// and writing it schedules pin writes, or cancels those pending:
+//
+//	scheduleWrites  u8
+//	at              u64  board clock to apply them at, little-endian
+//	request         the pin_writes JSON document
+//
+//	scheduleCancel  u8
+//
+// The board applies a document whose time has passed at once.
+const (
+	scheduleWrites = 0x01
+	scheduleCancel = 0x02
+	// clockSamples is the default SyncOptions.Samples.
+	clockSamples = 8
+	// syncLead is the default SyncOptions.Lead.
+	syncLead = 250 * time.Millisecond
+)
+
+// Clock is a board's clock as estimated by SyncClock: the board's clock
+// reads Offset more than the host's, give or take Uncertainty.
+type Clock struct {
+	Offset      time.Duration
+	Uncertainty time.Duration
+	// RTT is the shortest round trip of the samples.
+	RTT time.Duration
+}
+
+// Board returns what the board's clock reads, in microseconds, at host
+// time t.
+func (c Clock) Board(t time.Time) uint64 {
+	return uint64(t.UnixMicro() + c.Offset.Microseconds())
+}
+
+// SyncClock estimates the board's clock from samples reads of the
+// schedule characteristic uuid, taking the board's reading to be from
+// halfway through the read with the shortest round trip, as NTP does.
+func (c *Client) SyncClock(ctx context.Context, uuid string, samples int) (Clock, error) {
+	if samples < 1 {
+		samples = clockSamples
+	}
+	var best Clock
+	for i := range samples {
+		start := time.Now()
+		frame, err := c.ReadRaw(ctx, uuid)
+		end := time.Now()
+		if err != nil {
+			return Clock{}, err
+		}
+		if len(frame) != 8 {
+			return Clock{}, fmt.Errorf("clock frame of %d bytes, want 8", len(frame))
+		}
+		rtt := end.Sub(start)
+		if i > 0 && rtt >= best.RTT {
+			continue
+		}
+		mid := start.Add(rtt / 2)
+		board := int64(binary.LittleEndian.Uint64(frame))
+		best = Clock{
+			Offset:      time.Duration(board-mid.UnixMicro()) * time.Microsecond,
+			Uncertainty: rtt / 2,
+			RTT:         rtt,
+		}
+	}
+	return best, nil
+}
+
+// ScheduleWrites has the board apply writes when its clock, as estimated
+// by clock, reaches host time at. The write is made with response, so the
+// board has it once ScheduleWrites returns.
+func (c *Client) ScheduleWrites(ctx context.Context, uuid string, clock Clock, at time.Time, writes []PinWrite) error {
+	char, err := c.Characteristic(uuid)
+	if err != nil {
+		return err
+	}
+	request, err := EncodePinWrites(writes)
+	if err != nil {
+		return err
+	}
+	frame := binary.LittleEndian.AppendUint64([]byte{scheduleWrites}, clock.Board(at))
+	defer c.forgetReads()
+	return c.writeAcked(ctx, char, append(frame, request...))
+}
+
+// CancelScheduled drops the board's pending scheduled writes.
+func (c *Client) CancelScheduled(ctx context.Context, uuid string) error {
+	char, err := c.Characteristic(uuid)
+	if err != nil {
+		return err
+	}
+	return c.writeAcked(ctx, char, []byte{scheduleCancel})
+}
+
+// writeAcked writes p to char with response, serialized with the client's
+// other operations.
+func (c *Client) writeAcked(ctx context.Context, char Characteristic, p []byte) error {
+	_, err := await(ctx, func() (struct{}, error) {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if _, err := writeWithResponse(char, p); err != nil {
+			return struct{}{}, fmt.Errorf("failed to write: %w", err)
+		}
+		return struct{}{}, nil
+	}, nil)
+	return err
+}
+
+// SyncTarget is one board's part of a synchronized write.
+type SyncTarget struct {
+	Client *Client
+	Writes []PinWrite
+}
+
+// SyncOptions configures SyncWrite.
+type SyncOptions struct {
+	// UUID is the schedule characteristic.
+	UUID string
+	// Samples is how many clock reads to estimate each board's clock
+	// from, 8 by default.
+	Samples int
+	// Lead is how far ahead of scheduling the writes are applied, 250ms
+	// by default. It is stretched to four of the slowest board's round
+	// trips, so every board has its writes in time.
+	Lead time.Duration
+}
+
+// SyncResult is what SyncWrite scheduled.
+type SyncResult struct {
+	// At is the host time the writes were scheduled for.
+	At time.Time
+	// Clocks are the boards' clocks, in the targets' order.
+	Clocks []Clock
+	// Spread is how far apart the boards may apply the writes, going by
+	// the clocks' uncertainties.
+	Spread time.Duration
+}
+
+// ErrScheduledLate is wrapped by SyncWrite's error when a board
+// acknowledged its writes only after they were due: it applies them late,
+// while the others may have kept time.
+var ErrScheduledLate = errors.New("scheduled after the writes were due")
+
+// SyncWrite has several boards apply their writes together, as for paired
+// relays. Best-effort writes made one after another land a connection
+// interval or more apart; instead each board's clock is estimated and the
+// writes are scheduled on every board for the same moment, a Lead ahead,
+// so they land within the clocks' uncertainty of each other. If a board
+// can't be scheduled, those already scheduled are cancelled, so either
+// every board acts or none does.
+func SyncWrite(ctx context.Context, targets []SyncTarget, opts SyncOptions) (SyncResult, error) {
+	lead := cmp.Or(opts.Lead, syncLead)
+	result := SyncResult{Clocks: make([]Clock, len(targets))}
+	err := forEachTarget(targets, func(i int, t SyncTarget) error {
+		clock, err := t.Client.SyncClock(ctx, opts.UUID, opts.Samples)
+		result.Clocks[i] = clock
+		return err
+	})
+	if err != nil {
+		return result, err
+	}
+
+	var uncertainties []time.Duration
+	for _, clock := range result.Clocks {
+		lead = max(lead, 4*clock.RTT)
+		uncertainties = append(uncertainties, clock.Uncertainty)
+	}
+	// Two boards are furthest apart when their estimates are off in
+	// opposite directions.
+	slices.Sort(uncertainties)
+	for _, u := range uncertainties[max(len(uncertainties)-2, 0):] {
+		result.Spread += u
+	}
+	result.At = time.Now().Add(lead)
+
+	scheduled := make([]bool, len(targets))
+	err = forEachTarget(targets, func(i int, t SyncTarget) error {
+		if err := t.Client.ScheduleWrites(ctx, opts.UUID, result.Clocks[i], result.At, t.Writes); err != nil {
+			return err
+		}
+		scheduled[i] = true
+		if time.Now().After(result.At) {
+			return ErrScheduledLate
+		}
+		return nil
+	})
+	if slices.Contains(scheduled, false) {
+		// Nothing has been applied yet, unless the lead was too short.
+		for i, ok := range scheduled {
+			if ok {
+				targets[i].Client.CancelScheduled(context.WithoutCancel(ctx), opts.UUID)
+			}
+		}
+	}
+	return result, err
+}
+
+// forEachTarget calls fn for each target concurrently, returning the
+// errors prefixed with their boards' names.
+func forEachTarget(targets []SyncTarget, fn func(int, SyncTarget) error) error {
+	errs := make([]error, len(targets))
+	var wg sync.WaitGroup
+	for i, t := range targets {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := fn(i, t); err != nil {
+				errs[i] = fmt.Errorf("%s: %w", t.Client.Name, err)
+			}
+		}()
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
@@ -0,0 +1,98 @@
+package esp32_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"bluetooth/esp32"
+	"bluetooth/esp32/mock"
+)
+
+const scheduleUUID = "5a1d0000-0000-4000-8000-000000000031"
+
+func TestSyncWrite(t *testing.T) {
+	defer verifyNoLeaks(t)
+
+	// The boards booted hours apart, so their clocks differ by as much.
+	first := mock.NewBoard("esp32-test", "AA:BB:CC:DD:EE:01")
+	first.EnableSchedule(scheduleUUID, 10*time.Second)
+	second := mock.NewBoard("esp32-two", "AA:BB:CC:DD:EE:02")
+	second.EnableSchedule(scheduleUUID, 3*time.Hour)
+	a, b := connectBoard(t, first), connectBoard(t, second)
+	defer a.Disconnect()
+	defer b.Disconnect()
+
+	result, err := esp32.SyncWrite(context.Background(), []esp32.SyncTarget{
+		{Client: a, Writes: []esp32.PinWrite{{PinNum: 14, State: 100}}},
+		{Client: b, Writes: []esp32.PinWrite{{PinNum: 25, State: 100}}},
+	}, esp32.SyncOptions{UUID: scheduleUUID, Lead: 50 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Pin(14) != 0 {
+		t.Error("pin 14 written before it was due")
+	}
+	time.Sleep(time.Until(result.At) + 50*time.Millisecond)
+
+	if first.Pin(14) != 100 || second.Pin(25) != 100 {
+		t.Fatalf("pins 14 and 25 = %d and %d, want both written", first.Pin(14), second.Pin(25))
+	}
+	applied := append(first.Applied(), second.Applied()...)
+	for _, at := range applied {
+		if d := at.Sub(result.At).Abs(); d > 10*time.Millisecond {
+			t.Errorf("applied %v from the scheduled time", d)
+		}
+	}
+	if len(result.Clocks) != 2 || result.Clocks[1].Offset-result.Clocks[0].Offset < 3*time.Hour-10*time.Second-time.Second {
+		t.Errorf("clocks = %+v, want the second ahead by about 3h", result.Clocks)
+	}
+}
+
+func TestSyncWriteUnsupported(t *testing.T) {
+	defer verifyNoLeaks(t)
+
+	first := mock.NewBoard("esp32-test", "AA:BB:CC:DD:EE:01")
+	first.EnableSchedule(scheduleUUID, time.Minute)
+	second := mock.NewBoard("esp32-two", "AA:BB:CC:DD:EE:02")
+	a, b := connectBoard(t, first), connectBoard(t, second)
+	defer a.Disconnect()
+	defer b.Disconnect()
+
+	_, err := esp32.SyncWrite(context.Background(), []esp32.SyncTarget{
+		{Client: a, Writes: []esp32.PinWrite{{PinNum: 14, State: 100}}},
+		{Client: b, Writes: []esp32.PinWrite{{PinNum: 14, State: 100}}},
+	}, esp32.SyncOptions{UUID: scheduleUUID, Lead: 20 * time.Millisecond})
+	if err == nil {
+		t.Fatal("SyncWrite succeeded with a board lacking the schedule characteristic")
+	}
+	time.Sleep(50 * time.Millisecond)
+	if first.Pin(14) != 0 {
+		t.Error("one board acted without the other")
+	}
+}
+
+func TestCancelScheduled(t *testing.T) {
+	defer verifyNoLeaks(t)
+
+	board := mock.NewBoard("esp32-test", "AA:BB:CC:DD:EE:01")
+	board.EnableSchedule(scheduleUUID, time.Minute)
+	client := connectBoard(t, board)
+	defer client.Disconnect()
+
+	ctx := context.Background()
+	clock, err := client.SyncClock(ctx, scheduleUUID, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.ScheduleWrites(ctx, scheduleUUID, clock, time.Now().Add(30*time.Millisecond), []esp32.PinWrite{{PinNum: 14, State: 100}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.CancelScheduled(ctx, scheduleUUID); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(60 * time.Millisecond)
+	if board.Pin(14) != 0 || len(board.Applied()) != 0 {
+		t.Error("cancelled write applied")
+	}
+}
@@ -0,0 +1,82 @@
+package esp32
+
+// Adapter is the host-side radio used to find and connect to boards. The
+// BLE implementation wraps tinygo.org/x/bluetooth; tests use the emulator
+// in the mock package.
+type Adapter interface {
+	Enable() error
+	// Scan blocks, calling callback for each advertisement, until StopScan
+	// is called or an error occurs.
+	Scan(callback func(ScanResult)) error
+	StopScan() error
+	// Connect connects to an address previously reported by Scan.
+	Connect(address string) (Device, error)
+}
+
+// PowerReporter is implemented by adapters that can tell whether the radio
+// is currently powered, so sessions can wait out rfkill or suspend instead
+// of failing. The BLE adapter implements it on Linux.
+type PowerReporter interface {
+	Powered() (bool, error)
+}
+
+// ScanResult is a single advertisement seen while scanning.
+type ScanResult struct {
+	Name    string
+	Address string
+	RSSI    int16
+	// Services are the service UUIDs in the advertisement, if any; the
+	// stock firmware advertises only its name.
+	Services []string
+}
+
+// Device is a connected board.
+type Device interface {
+	DiscoverServices() ([]Service, error)
+	Disconnect() error
+}
+
+// CCCDUUID is the Client Characteristic Configuration Descriptor, present
+// on characteristics that support notify or indicate.
+const CCCDUUID = "00002902-0000-1000-8000-00805f9b34fb"
+
+// CharacteristicInfo describes a characteristic's properties and
+// descriptors, as reported by a Describer.
+type CharacteristicInfo struct {
+	ServiceUUID string
+	UUID        string
+	// Properties are flags such as "read", "write",
+	// "write-without-response" and "notify".
+	Properties  []string
+	Descriptors []string
+}
+
+// Describer is implemented by devices that can report characteristic
+// properties and descriptors, which plain discovery doesn't expose. The BLE
+// device implements it on Linux.
+type Describer interface {
+	Describe() ([]CharacteristicInfo, error)
+}
+
+// RSSIReporter is implemented by devices that can report the signal
+// strength of their connection, in dBm. The BLE device implements it on
+// Linux.
+type RSSIReporter interface {
+	RSSI() (int16, error)
+}
+
+// Service is a GATT service on a connected board.
+type Service interface {
+	UUID() string
+	DiscoverCharacteristics() ([]Characteristic, error)
+}
+
+// Characteristic is a GATT characteristic on a connected board.
+type Characteristic interface {
+	UUID() string
+	Read(buf []byte) (int, error)
+	Write(p []byte) (int, error)
+	EnableNotifications(callback func(buf []byte)) error
+	// MTU returns the negotiated ATT MTU for the connection.
+	MTU() (uint16, error)
+}
@@ -0,0 +1,13 @@
+package esp32
+
+// GATT UUIDs exposed by the esp32_ble firmware's pin service.
+const (
+	PinServiceUUID = "a9c81b72-0f7a-4c59-b0a8-425e3bcf0a0e"
+
+	// PinDataOutputUUID is the regular pin data output (read/notify).
+	PinDataOutputUUID = "13c0ef83-09bd-4767-97cb-ee46224ae6db"
+	// PinDataInputUUID is the pin data input (write), taking JSON pin writes.
+	PinDataInputUUID = "c79b2ca7-f39d-4060-8168-816fa26737b7"
+	// ADCDataOutputUUID is the ADC data output (read/notify).
+	ADCDataOutputUUID = "01037594-1bbb-4490-aa4d-f6d333b42e16"
+)
@@ -0,0 +1,62 @@
+//go:build linux
+
+package esp32
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/godbus/dbus/v5"
+	"tinygo.org/x/bluetooth"
+)
+
+func writeCharacteristic(char bluetooth.DeviceCharacteristic, data []byte) (int, error) {
+	return char.WriteWithoutResponse(data)
+}
+
+// WriteWithResponse writes with an ATT Write Request through BlueZ, since
+// tinygo only makes the write BlueZ picks by the characteristic's flags.
+func (c *bleCharacteristic) WriteWithResponse(p []byte) (int, error) {
+	c.pathOnce.Do(func() { c.path, c.pathErr = c.objectPath() })
+	if c.pathErr != nil {
+		return 0, c.pathErr
+	}
+	bus, err := dbus.SystemBus()
+	if err != nil {
+		return 0, err
+	}
+	options := map[string]dbus.Variant{"type": dbus.MakeVariant("request")}
+	err = bus.Object("org.bluez", dbus.ObjectPath(c.path)).
+		Call("org.bluez.GattCharacteristic1.WriteValue", 0, p, options).Err
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// objectPath finds the characteristic's BlueZ object under its device.
+func (c *bleCharacteristic) objectPath() (string, error) {
+	bus, err := dbus.SystemBus()
+	if err != nil {
+		return "", err
+	}
+	var objects map[dbus.ObjectPath]map[string]map[string]dbus.Variant
+	err = bus.Object("org.bluez", "/").
+		Call("org.freedesktop.DBus.ObjectManager.GetManagedObjects", 0).
+		Store(&objects)
+	if err != nil {
+		return "", err
+	}
+	devicePath := string(c.device.objectPath()) + "/"
+	uuid := c.UUID()
+	for path, ifaces := range objects {
+		props, ok := ifaces["org.bluez.GattCharacteristic1"]
+		if !ok || !strings.HasPrefix(string(path), devicePath) {
+			continue
+		}
+		if u, _ := props["UUID"].Value().(string); strings.EqualFold(u, uuid) {
+			return string(path), nil
+		}
+	}
+	return "", fmt.Errorf("characteristic %s not found in BlueZ", uuid)
+}
@@ -0,0 +1,15 @@
+//go:build !linux
+
+package esp32
+
+import "tinygo.org/x/bluetooth"
+
+func writeCharacteristic(char bluetooth.DeviceCharacteristic, data []byte) (int, error) {
+	return char.Write(data)
+}
+
+// WriteWithResponse is a plain write: on these platforms tinygo's Write
+// already waits for the board's response.
+func (c *bleCharacteristic) WriteWithResponse(p []byte) (int, error) {
+	return c.char.Write(p)
+}
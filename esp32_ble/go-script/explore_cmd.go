@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"os"
+	"strings"
+	"time"
+
+	"bluetooth/esp32"
+)
+
+// runExplore walks a board's whole GATT database with Client.Inspect,
+// printing each characteristic's properties, whether it has a CCCD, and
+// its value if it is readable.
+func runExplore(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("explore", flag.ExitOnError)
+	namePtr := fs.String("name", "", "Name of the Bluetooth device to explore (required)")
+	timeoutPtr := fs.Int("timeout", 30, "Scan timeout in seconds")
+	jsonPtr := fs.Bool("json", false, "Print the result as JSON on stdout (progress goes to stderr)")
+	noReadPtr := fs.Bool("no-read", false, "Don't read characteristic values")
+	fs.Parse(args)
+
+	if *namePtr == "" {
+		msg.Println("Error: --name flag is required")
+		msg.Println("\nUsage:")
+		fs.PrintDefaults()
+		os.Exit(1)
+	}
+
+	stdout := os.Stdout
+	if *jsonPtr {
+		os.Stdout = os.Stderr
+	}
+	client := connectDevice(ctx, *namePtr, time.Duration(*timeoutPtr)*time.Second)
+	defer client.Disconnect()
+	os.Stdout = stdout
+
+	result, err := client.Inspect(ctx, !*noReadPtr)
+	if err != nil {
+		msg.Println("\n🛑 Interrupted")
+		return
+	}
+	if errors.Is(result.PropertiesErr, esp32.ErrDescribeUnsupported) {
+		msg.Fprintf(os.Stderr, "⚠️  %v; properties will be shown as unknown\n", result.PropertiesErr)
+	} else if result.PropertiesErr != nil {
+		msg.Fprintf(os.Stderr, "⚠️  Failed to read characteristic properties: %v\n", result.PropertiesErr)
+	}
+
+	if *jsonPtr {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(result)
+		return
+	}
+	printExplored(result)
+}
+
+func printExplored(d esp32.Inspection) {
+	msg.Printf("\n🗂️  GATT database of %s (%s)\n", d.Name, d.Address)
+	for _, s := range d.Services {
+		msg.Printf("\n📦 Service %s\n", s.UUID)
+		if s.Error != "" {
+			msg.Printf("   ⚠️  %s\n", s.Error)
+		}
+		for _, c := range s.Characteristics {
+			props := "unknown"
+			if c.Properties != nil {
+				props = strings.Join(c.Properties, ", ")
+			}
+			msg.Printf("   🔹 %s\n", c.UUID)
+			msg.Printf("      Properties: %s\n", props)
+			if c.Properties != nil {
+				msg.Printf("      CCCD:       %v\n", c.CCCD)
+			}
+			for _, desc := range c.Descriptors {
+				msg.Printf("      Descriptor: %s\n", desc)
+			}
+			switch {
+			case c.ReadError != "":
+				msg.Printf("      Read:       ❌ %s\n", c.ReadError)
+			case c.Value != nil:
+				msg.Printf("      Value:      %s %q\n", hex.EncodeToString(c.Value), printable(c.Value))
+			}
+		}
+	}
+}
+
+// printable replaces non-printable bytes with '.' for display.
+func printable(b []byte) string {
+	out := make([]byte, len(b))
+	for i, c := range b {
+		if c >= 0x20 && c < 0x7f {
+			out[i] = c
+		} else {
+			out[i] = '.'
+		}
+	}
+	return string(out)
+}
@@ -0,0 +1,180 @@
+// Package exporter serves board readings and connection state as
+// Prometheus metrics, in the text exposition format.
+package exporter
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"bluetooth/esp32"
+)
+
+// metric is one family of samples, keyed by their rendered labels.
+type metric struct {
+	help, kind string
+	samples    map[string]float64
+}
+
+// Exporter holds the latest value of every metric. It is safe for
+// concurrent use and serves /metrics as an http.Handler.
+type Exporter struct {
+	mu      sync.Mutex
+	metrics map[string]*metric
+}
+
+// New returns an Exporter with no samples.
+func New() *Exporter {
+	e := &Exporter{metrics: map[string]*metric{}}
+	for _, m := range []struct{ name, kind, help string }{
+		{"esp32_adc_value", "gauge", "Latest raw value of an ADC channel."},
+		{"esp32_pin_value", "gauge", "Latest state of a basic pin."},
+		{"esp32_readings_total", "counter", "Readings received from a board."},
+		{"esp32_last_reading_timestamp_seconds", "gauge", "Unix time of a board's latest reading."},
+		{"esp32_connected", "gauge", "Whether a board is connected (1) or not (0)."},
+		{"esp32_disconnects_total", "counter", "Times a board's connection was lost."},
+		{"esp32_scan_duration_seconds", "gauge", "How long the latest scan for a board took before it was found."},
+		{"esp32_characteristic_operations_total", "counter", "Reads, writes and notifications on a characteristic since the board connected."},
+		{"esp32_characteristic_errors_total", "counter", "Failed reads and writes on a characteristic since the board connected."},
+		{"esp32_characteristic_bytes_total", "counter", "Payload bytes read, written or notified on a characteristic since the board connected."},
+	} {
+		e.metrics[m.name] = &metric{help: m.help, kind: m.kind, samples: map[string]float64{}}
+	}
+	return e
+}
+
+// labels renders label pairs, escaped as the format requires.
+func labels(pairs ...string) string {
+	escape := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	parts := make([]string, 0, len(pairs)/2)
+	for i := 0; i+1 < len(pairs); i += 2 {
+		parts = append(parts, fmt.Sprintf(`%s="%s"`, pairs[i], escape.Replace(pairs[i+1])))
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+// deviceLabels renders a board's labels followed by more pairs. A board
+// identified other than by its address is labeled with its ID instead,
+// so its series carry on when the address changes.
+func deviceLabels(device, address, id string, more ...string) string {
+	if id != "" && id != address {
+		return labels(append([]string{"device", device, "id", id}, more...)...)
+	}
+	return labels(append([]string{"device", device, "address", address}, more...)...)
+}
+
+// ObserveADC records ADC readings.
+func (e *Exporter) ObserveADC(readings []esp32.Reading) {
+	e.observe("esp32_adc_value", readings)
+}
+
+// ObservePins records basic pin readings.
+func (e *Exporter) ObservePins(readings []esp32.Reading) {
+	e.observe("esp32_pin_value", readings)
+}
+
+func (e *Exporter) observe(name string, readings []esp32.Reading) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, r := range readings {
+		if r.Kind != "" {
+			continue
+		}
+		pin := strconv.Itoa(int(r.Pin))
+		e.metrics[name].samples[deviceLabels(r.Device, r.Address, r.ID, "pin", pin)] = float64(r.Value)
+		device := deviceLabels(r.Device, r.Address, r.ID)
+		e.metrics["esp32_readings_total"].samples[device]++
+		e.metrics["esp32_last_reading_timestamp_seconds"].samples[device] = float64(r.Time.UnixMilli()) / 1000
+	}
+}
+
+// ObserveSession records a session's connection state changes and scan
+// times.
+func (e *Exporter) ObserveSession(ev esp32.SessionEvent) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	switch ev.Kind {
+	case esp32.SessionConnected:
+		device := deviceLabels(ev.Client.Name, ev.Client.Address, ev.Client.ID)
+		e.metrics["esp32_connected"].samples[device] = 1
+		e.metrics["esp32_scan_duration_seconds"].samples[device] = ev.Scan.Seconds()
+	case esp32.SessionDisconnected, esp32.SessionSuspending, esp32.SessionResumed:
+		if ev.Client == nil {
+			return
+		}
+		device := deviceLabels(ev.Client.Name, ev.Client.Address, ev.Client.ID)
+		e.metrics["esp32_connected"].samples[device] = 0
+		if ev.Kind == esp32.SessionDisconnected {
+			e.metrics["esp32_disconnects_total"].samples[device]++
+		}
+	}
+}
+
+// ObserveCharacteristics records the client's per-characteristic access
+// counters. They restart from zero when the board reconnects, which
+// Prometheus treats as a counter reset.
+func (e *Exporter) ObserveCharacteristics(client *esp32.Client) {
+	stats := client.CharacteristicStats()
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, s := range stats {
+		for _, op := range []struct {
+			name              string
+			count, err, bytes uint64
+		}{
+			{"read", s.Reads, s.ReadErrors, s.BytesRead},
+			{"write", s.Writes, s.WriteErrors, s.BytesWritten},
+			{"notify", s.Notifications, 0, s.BytesNotified},
+		} {
+			if op.count == 0 {
+				continue
+			}
+			key := deviceLabels(client.Name, client.Address, client.ID, "uuid", s.UUID, "op", op.name)
+			e.metrics["esp32_characteristic_operations_total"].samples[key] = float64(op.count)
+			e.metrics["esp32_characteristic_bytes_total"].samples[key] = float64(op.bytes)
+			if op.name != "notify" {
+				e.metrics["esp32_characteristic_errors_total"].samples[key] = float64(op.err)
+			}
+		}
+	}
+}
+
+// WriteTo writes every metric in the text exposition format, sorted so
+// scrapes are stable.
+func (e *Exporter) WriteTo(w io.Writer) (int64, error) {
+	e.mu.Lock()
+	var b strings.Builder
+	names := make([]string, 0, len(e.metrics))
+	for name := range e.metrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		m := e.metrics[name]
+		if len(m.samples) == 0 {
+			continue
+		}
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n", name, m.help, name, m.kind)
+		keys := make([]string, 0, len(m.samples))
+		for k := range m.samples {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			fmt.Fprintf(&b, "%s%s %s\n", name, k, strconv.FormatFloat(m.samples[k], 'g', -1, 64))
+		}
+	}
+	e.mu.Unlock()
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
+
+// ServeHTTP serves the metrics at any path; mount it at /metrics.
+func (e *Exporter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	e.WriteTo(w)
+}
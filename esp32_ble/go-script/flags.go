@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"bluetooth/esp32"
+)
+
+// stringList is a repeatable string flag.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(s string) error {
+	*l = append(*l, s)
+	return nil
+}
+
+// readListFile reads one entry per line from a file, skipping blank lines
+// and lines starting with '#'.
+func readListFile(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var lines []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return lines, scanner.Err()
+}
+
+// scanFlags adds the flags tuning how adapters scan to fs, for firmware
+// that advertises too rarely for the platform's defaults, returning a
+// function that applies them to an adapter once fs is parsed, exiting if
+// the platform can't, and gives the adapter to scan with.
+func scanFlags(fs *flag.FlagSet) func(esp32.Adapter) esp32.Adapter {
+	mode := fs.String("scan-mode", "", "Scan active (asking devices for scan responses) or passive (only listening); default is the platform's")
+	interval := fs.Duration("scan-interval", 0, "How often the radio starts listening while scanning, e.g. 1s (default: the platform's)")
+	window := fs.Duration("scan-window", 0, "How long the radio listens each --scan-interval; equal to it to listen continuously, for boards advertising rarely (default: the platform's)")
+	dedupe := fs.Bool("scan-dedupe", false, "Report each device once per scan rather than for every advertisement")
+	announced := false
+	return func(a esp32.Adapter) esp32.Adapter {
+		p := esp32.ScanParams{Interval: *interval, Window: *window}
+		switch *mode {
+		case "", "active":
+		case "passive":
+			p.Passive = true
+		default:
+			msg.Printf("❌ Unknown scan mode %q (want active or passive)\n", *mode)
+			os.Exit(1)
+		}
+		if err := esp32.ConfigureScan(a, p); err != nil {
+			msg.Printf("❌ Failed to set scan parameters: %v\n", err)
+			os.Exit(1)
+		}
+		if p != (esp32.ScanParams{}) && !announced {
+			msg.Printf("📡 Scanning %s\n", describeScan(p))
+			announced = true
+		}
+		if *dedupe {
+			a = esp32.FilterDuplicates(a)
+		}
+		return a
+	}
+}
+
+// describeScan describes p for scanFlags' message, e.g. "passively,
+// listening 30ms every 1s".
+func describeScan(p esp32.ScanParams) string {
+	s := "actively"
+	if p.Passive {
+		s = "passively"
+	}
+	switch {
+	case p.Window != 0 && p.Interval != 0:
+		s += fmt.Sprintf(", listening %v every %v", p.Window, p.Interval)
+	case p.Window != 0:
+		s += fmt.Sprintf(", listening %v at a time", p.Window)
+	case p.Interval != 0:
+		s += fmt.Sprintf(", listening every %v", p.Interval)
+	}
+	return s
+}
+
+// reliableFlags adds --reliable and the flags tuning it to fs, returning
+// a function giving the write policy they ask for once fs is parsed, or
+// nil without --reliable.
+func reliableFlags(fs *flag.FlagSet) func() *esp32.WritePolicy {
+	defaults := esp32.DefaultWritePolicy()
+	reliable := fs.Bool("reliable", false, "Write pins with response, retrying failed writes")
+	attempts := fs.Int("write-attempts", defaults.Attempts, "With --reliable, how many times to try each pin write")
+	timeout := fs.Duration("write-timeout", defaults.Timeout, "With --reliable, how long each attempt may take")
+	verify := fs.Bool("verify-writes", false, "With --reliable, read the pins back after writing and retry those not in the commanded state")
+	return func() *esp32.WritePolicy {
+		if !*reliable {
+			return nil
+		}
+		p := defaults
+		p.Attempts, p.Timeout, p.Verify = *attempts, *timeout, *verify
+		return &p
+	}
+}
+
+// transportFlags adds --transport and the serial port flags to fs,
+// returning a function that, once fs is parsed, switches adapter to the
+// serial port if asked and returns the port, or "" for Bluetooth.
+func transportFlags(fs *flag.FlagSet) func() string {
+	transport := fs.String("transport", "ble", "How to reach the board: ble, or serial for one plugged in over USB")
+	port := fs.String("port", "", "With --transport serial, the serial port, e.g. /dev/ttyUSB0 or COM3")
+	baud := fs.Int("baud", 115200, "With --transport serial, the baud rate")
+	return func() string {
+		switch *transport {
+		case "ble":
+			if *port != "" {
+				msg.Println("❌ --port needs --transport serial")
+				os.Exit(1)
+			}
+			return ""
+		case "serial":
+		default:
+			msg.Printf("❌ Unknown transport %q (want ble or serial)\n", *transport)
+			os.Exit(1)
+		}
+		if *port == "" {
+			msg.Println("Error: --port flag is required with --transport serial")
+			msg.Println("\nUsage:")
+			fs.PrintDefaults()
+			os.Exit(1)
+		}
+		adapter = esp32.NewSerialAdapter(*port, func() (io.ReadWriteCloser, error) {
+			return openSerial(*port, *baud)
+		})
+		msg.Printf("🔌 Using serial port %s at %d baud instead of Bluetooth\n", *port, *baud)
+		return *port
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"os"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+// runHashPassword prints the hash of a password for the users of the
+// config file's auth: basic: section. The password is asked for twice
+// on a terminal, without echoing it, or read from the first line of
+// stdin otherwise.
+func runHashPassword(_ context.Context, args []string) {
+	fs := flag.NewFlagSet("hash-password", flag.ExitOnError)
+	fs.Parse(args)
+
+	var password string
+	if fd := int(os.Stdin.Fd()); term.IsTerminal(fd) {
+		read := func() string {
+			b, err := term.ReadPassword(fd)
+			msg.Fprintln(os.Stderr)
+			if err != nil {
+				msg.Printf("❌ Reading the password: %v\n", err)
+				os.Exit(1)
+			}
+			return string(b)
+		}
+		msg.Fprintf(os.Stderr, "Password: ")
+		password = read()
+		msg.Fprintf(os.Stderr, "Again: ")
+		if read() != password {
+			msg.Println("❌ The passwords don't match")
+			os.Exit(1)
+		}
+	} else {
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			msg.Printf("❌ Reading the password: %v\n", err)
+			os.Exit(1)
+		}
+		password = strings.TrimRight(line, "\r\n")
+	}
+	if password == "" {
+		msg.Println("❌ The password is empty")
+		os.Exit(1)
+	}
+	msg.Printf("%s\n", hashPassword(password))
+}
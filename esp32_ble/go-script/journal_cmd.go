@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"bluetooth/esp32"
+)
+
+// defaultJournalName is the journal file, in the home directory, used by
+// history when --journal isn't given.
+const defaultJournalName = ".esp32_interfaces_journal.csv"
+
+// journalPath returns path, or the default journal file if it is empty.
+func journalPath(path string) string {
+	if path != "" {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		msg.Printf("❌ Failed to find journal file: %v\n", err)
+		os.Exit(1)
+	}
+	return filepath.Join(home, defaultJournalName)
+}
+
+// readJournal reads the changes recorded in a journal file. A missing
+// file holds none.
+func readJournal(path string) ([]esp32.Reading, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if info, err := f.Stat(); err == nil && info.Size() == 0 {
+		return nil, nil
+	}
+	return esp32.ReadCSV(f)
+}
+
+// openJournal opens the journal file at path for appending value changes
+// of pins (all of them if empty) that move by more than deadband.
+func openJournal(path string, pins []uint8, deadband int) *esp32.Journal {
+	history, err := readJournal(path)
+	if err != nil {
+		msg.Printf("❌ Failed to read journal file: %v\n", err)
+		os.Exit(1)
+	}
+	w := appendCSV(path, nil)
+	msg.Printf("📜 Journalling value changes to %s\n", path)
+	return esp32.NewJournal(w, history, esp32.JournalOptions{Pins: pins, Deadband: deadband})
+}
+
+// parsePinList parses a comma-separated list of pin numbers.
+func parsePinList(s string) ([]uint8, error) {
+	var pins []uint8
+	for _, field := range strings.Split(s, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		pin, err := strconv.ParseUint(field, 10, 8)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pin %q", field)
+		}
+		pins = append(pins, uint8(pin))
+	}
+	return pins, nil
+}
+
+// runHistory prints the value changes journalled for one pin.
+func runHistory(_ context.Context, args []string) {
+	if len(args) < 2 || args[0] != "pin" {
+		msg.Println("Usage: history pin N [--since 24h] [--device NAME] [--journal FILE]")
+		os.Exit(1)
+	}
+	pin, err := strconv.ParseUint(args[1], 10, 8)
+	if err != nil {
+		msg.Printf("❌ Invalid pin %q\n", args[1])
+		os.Exit(1)
+	}
+
+	fs := flag.NewFlagSet("history", flag.ExitOnError)
+	sincePtr := fs.Duration("since", 24*time.Hour, "Show changes from this long ago (0 for all)")
+	devicePtr := fs.String("device", "", "Only show changes from this board")
+	journalPtr := fs.String("journal", "", "Journal file written by --journal (default ~/"+defaultJournalName+")")
+	fs.Parse(args[2:])
+
+	path := journalPath(*journalPtr)
+	readings, err := readJournal(path)
+	if err != nil {
+		msg.Printf("❌ Failed to read journal file: %v\n", err)
+		os.Exit(1)
+	}
+
+	now := time.Now()
+	var changes []esp32.Reading
+	for _, r := range readings {
+		if r.Pin != uint8(pin) || *devicePtr != "" && r.Device != *devicePtr {
+			continue
+		}
+		if *sincePtr > 0 && r.Time.Before(now.Add(-*sincePtr)) {
+			continue
+		}
+		changes = append(changes, r)
+	}
+
+	if *sincePtr > 0 {
+		msg.Printf("📜 %d change(s) of pin %d in the last %v\n", len(changes), pin, *sincePtr)
+	} else {
+		msg.Printf("📜 %d change(s) of pin %d\n", len(changes), pin)
+	}
+	for i, r := range changes {
+		// A value holds until the board's next change, or until now for
+		// the latest one.
+		until := now
+		for _, next := range changes[i+1:] {
+			if next.Device == r.Device && next.ID == r.ID {
+				until = next.Time
+				break
+			}
+		}
+		msg.Printf("   %s  %-16s %5d  (for %v)\n",
+			r.Time.Local().Format("2006-01-02 15:04:05"), r.Device, r.Value, until.Sub(r.Time).Round(time.Second))
+	}
+}
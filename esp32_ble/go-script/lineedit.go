@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"golang.org/x/term"
+)
+
+// lineEditor reads command lines from stdin. On a terminal it runs in raw
+// mode with tab completion and keeps asynchronous output (notifications)
+// from trampling the line being typed; otherwise it reads plain lines so
+// commands can be piped in.
+type lineEditor struct {
+	prompt   string
+	complete func(line string) []string
+
+	mu      sync.Mutex
+	raw     bool
+	buf     []rune
+	reader  *bufio.Reader
+	restore func()
+}
+
+func newLineEditor(prompt string, complete func(line string) []string) *lineEditor {
+	e := &lineEditor{prompt: prompt, complete: complete, reader: bufio.NewReader(os.Stdin)}
+	fd := int(os.Stdin.Fd())
+	if term.IsTerminal(fd) {
+		if state, err := term.MakeRaw(fd); err == nil {
+			e.raw = true
+			e.restore = func() { term.Restore(fd, state) }
+		}
+	}
+	return e
+}
+
+// Close restores the terminal.
+func (e *lineEditor) Close() {
+	if e.restore != nil {
+		e.restore()
+	}
+}
+
+// Printf prints output above the line being edited, translated as
+// msg.Printf does. It is safe to call from any goroutine.
+func (e *lineEditor) Printf(format string, args ...any) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	text := msg.Sprintf(format, args...)
+	if !e.raw {
+		fmt.Print(text)
+		return
+	}
+	fmt.Print("\r\x1b[K" + strings.ReplaceAll(text, "\n", "\r\n"))
+	e.redraw()
+}
+
+// redraw prints the prompt and current buffer. e.mu must be held.
+func (e *lineEditor) redraw() {
+	fmt.Print("\r\x1b[K" + e.prompt + string(e.buf))
+}
+
+// ReadLine reads one line, returning io.EOF at end of input or Ctrl-D on an
+// empty line.
+func (e *lineEditor) ReadLine() (string, error) {
+	if !e.raw {
+		fmt.Print(e.prompt)
+		line, err := e.reader.ReadString('\n')
+		if err == io.EOF && line != "" {
+			return line, nil
+		}
+		return strings.TrimRight(line, "\r\n"), err
+	}
+
+	e.mu.Lock()
+	e.buf = e.buf[:0]
+	e.redraw()
+	e.mu.Unlock()
+
+	for {
+		r, _, err := e.reader.ReadRune()
+		if err != nil {
+			return "", err
+		}
+		e.mu.Lock()
+		switch r {
+		case '\r', '\n':
+			line := string(e.buf)
+			e.buf = e.buf[:0]
+			fmt.Print("\r\n")
+			e.mu.Unlock()
+			return line, nil
+		case 3: // Ctrl-C
+			e.buf = e.buf[:0]
+			fmt.Print("^C\r\n")
+			e.redraw()
+		case 4: // Ctrl-D
+			if len(e.buf) == 0 {
+				fmt.Print("\r\n")
+				e.mu.Unlock()
+				return "", io.EOF
+			}
+		case 127, 8: // Backspace
+			if len(e.buf) > 0 {
+				e.buf = e.buf[:len(e.buf)-1]
+				e.redraw()
+			}
+		case '\t':
+			e.tab()
+		case 27: // Escape sequence (arrow keys etc.): ignored
+			e.reader.ReadRune()
+			e.reader.ReadRune()
+		default:
+			if r >= ' ' {
+				e.buf = append(e.buf, r)
+				fmt.Print(string(r))
+			}
+		}
+		e.mu.Unlock()
+	}
+}
+
+// tab completes the last word of the buffer, or lists the candidates if
+// there are several. e.mu must be held.
+func (e *lineEditor) tab() {
+	if e.complete == nil {
+		return
+	}
+	line := string(e.buf)
+	candidates := e.complete(line)
+	start := strings.LastIndex(line, " ") + 1
+	word := line[start:]
+	switch len(candidates) {
+	case 0:
+		return
+	case 1:
+		e.buf = []rune(line[:start] + candidates[0] + " ")
+	default:
+		prefix := commonPrefix(candidates)
+		if len(prefix) > len(word) {
+			e.buf = []rune(line[:start] + prefix)
+		} else {
+			fmt.Print("\r\n" + strings.Join(candidates, "  ") + "\r\n")
+		}
+	}
+	e.redraw()
+}
+
+func commonPrefix(words []string) string {
+	prefix := words[0]
+	for _, w := range words[1:] {
+		for !strings.HasPrefix(w, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+	return prefix
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"bluetooth/esp32"
+)
+
+// scanCacheName is the file, in the home directory, that list --cache
+// writes and --scan-cache reads.
+const scanCacheName = ".esp32_interfaces_scan.json"
+
+// scanCacheAge, if set by --scan-cache, is how recently a board must
+// have been seen by list --cache for connectDevice to skip the scan.
+var scanCacheAge time.Duration
+
+// cachedDevice is one entry of the scan cache file.
+type cachedDevice struct {
+	Name     string    `json:"name"`
+	Address  string    `json:"address"`
+	RSSI     int16     `json:"rssi"`
+	Services []string  `json:"services,omitempty"`
+	LastSeen time.Time `json:"last_seen"`
+}
+
+// runList scans for a fixed window and prints every device heard as a
+// table, instead of the interleaved stream printed while connecting.
+func runList(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("list", flag.ExitOnError)
+	windowPtr := fs.Duration("window", 5*time.Second, "How long to scan")
+	cachePtr := fs.Bool("cache", false, "Save the devices to ~/"+scanCacheName+" so --scan-cache can connect without scanning")
+	scan := scanFlags(fs)
+	fs.Parse(args)
+
+	if err := adapter.Enable(); err != nil {
+		msg.Printf("❌ Failed to enable Bluetooth adapter: %v\n", err)
+		os.Exit(1)
+	}
+	a := scan(adapter)
+	msg.Printf("🔍 Scanning for %v...\n\n", *windowPtr)
+	devices, err := esp32.ListDevices(ctx, a, *windowPtr)
+	if err != nil && ctx.Err() == nil {
+		msg.Printf("❌ %v\n", err)
+		os.Exit(1)
+	}
+
+	msg.Printf("📋 %d device(s)\n", len(devices))
+	now := time.Now()
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	msg.Fprintln(tw, "NAME\tADDRESS\tRSSI\tSERVICES\tLAST SEEN")
+	for _, d := range devices {
+		services := strings.Join(d.Services, ",")
+		msg.Fprintf(tw, "%s\t%s\t%d dBm\t%s\t%v ago\n",
+			cmp.Or(d.Name, "-"), d.Address, d.RSSI, cmp.Or(services, "-"), now.Sub(d.LastSeen).Round(time.Second))
+	}
+	tw.Flush()
+
+	if *cachePtr {
+		path := scanCachePath()
+		if err := saveScanCache(path, devices); err != nil {
+			msg.Printf("❌ Failed to write scan cache: %v\n", err)
+			os.Exit(1)
+		}
+		msg.Printf("\n💾 Cached to %s\n", path)
+	}
+}
+
+func scanCachePath() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return scanCacheName
+	}
+	return filepath.Join(home, scanCacheName)
+}
+
+// readScanCache reads the scan cache. A missing file is an empty cache.
+func readScanCache(path string) ([]cachedDevice, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var devices []cachedDevice
+	if err := json.Unmarshal(data, &devices); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return devices, nil
+}
+
+// saveScanCache merges devices into the scan cache, replacing earlier
+// entries for the same addresses.
+func saveScanCache(path string, devices []esp32.SeenDevice) error {
+	cached, err := readScanCache(path)
+	if err != nil {
+		return err
+	}
+	for _, d := range devices {
+		entry := cachedDevice{Name: d.Name, Address: d.Address, RSSI: d.RSSI, Services: d.Services, LastSeen: d.LastSeen}
+		replaced := false
+		for i := range cached {
+			if strings.EqualFold(cached[i].Address, d.Address) {
+				cached[i], replaced = entry, true
+			}
+		}
+		if !replaced {
+			cached = append(cached, entry)
+		}
+	}
+	data, err := json.MarshalIndent(cached, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
+
+// cachedScanResult returns the most recent scan cache entry matching name
+// (a target, like FindDevice's) seen within scanCacheAge.
+func cachedScanResult(name string) (esp32.ScanResult, time.Time, bool) {
+	if scanCacheAge <= 0 {
+		return esp32.ScanResult{}, time.Time{}, false
+	}
+	cached, err := readScanCache(scanCachePath())
+	if err != nil {
+		msg.Printf("⚠️  Ignoring scan cache: %v\n", err)
+		return esp32.ScanResult{}, time.Time{}, false
+	}
+	match, err := esp32.CompileTarget(name)
+	if err != nil {
+		return esp32.ScanResult{}, time.Time{}, false
+	}
+	var best *cachedDevice
+	for i, d := range cached {
+		if !match(esp32.ScanResult{Name: d.Name, Address: d.Address, RSSI: d.RSSI, Services: d.Services}) {
+			continue
+		}
+		if time.Since(d.LastSeen) > scanCacheAge {
+			continue
+		}
+		if best == nil || d.LastSeen.After(best.LastSeen) {
+			best = &cached[i]
+		}
+	}
+	if best == nil {
+		return esp32.ScanResult{}, time.Time{}, false
+	}
+	return esp32.ScanResult{Name: best.Name, Address: best.Address, RSSI: best.RSSI, Services: best.Services}, best.LastSeen, true
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"strings"
+)
+
+// listenSpec is one of serve's --listen addresses: host:port, with IPv6
+// hosts in brackets as in [::1]:8080, or unix:PATH for a unix socket,
+// optionally followed by TLS settings of its own.
+//
+//	127.0.0.1:8080
+//	[fd00:10::5]:8443,cert=/etc/esp32/mgmt.crt,key=/etc/esp32/mgmt.key
+//	10.0.0.5:8443,cert=gw.crt,key=gw.key,client_ca=ops-ca.pem
+//	unix:/run/esp32/serve.sock
+//
+// With client_ca, clients must present a certificate it signed.
+type listenSpec struct {
+	network, addr     string
+	certFile, keyFile string
+	clientCA          string
+}
+
+// parseListen parses a --listen address.
+func parseListen(s string) (listenSpec, error) {
+	fields := strings.Split(s, ",")
+	l := listenSpec{network: "tcp", addr: fields[0]}
+	if path, ok := strings.CutPrefix(l.addr, "unix:"); ok {
+		l.network, l.addr = "unix", path
+	}
+	if l.addr == "" {
+		return listenSpec{}, fmt.Errorf("invalid listen address %q", s)
+	}
+	for _, field := range fields[1:] {
+		key, value, _ := strings.Cut(field, "=")
+		if value == "" {
+			return listenSpec{}, fmt.Errorf("listen address %q: %q needs a value", s, key)
+		}
+		switch key {
+		case "cert":
+			l.certFile = value
+		case "key":
+			l.keyFile = value
+		case "client_ca":
+			l.clientCA = value
+		default:
+			return listenSpec{}, fmt.Errorf("listen address %q: unknown setting %q (want cert, key or client_ca)", s, key)
+		}
+	}
+	if (l.certFile == "") != (l.keyFile == "") {
+		return listenSpec{}, fmt.Errorf("listen address %q: TLS needs both cert and key", s)
+	}
+	if l.clientCA != "" && l.certFile == "" {
+		return listenSpec{}, fmt.Errorf("listen address %q: client_ca needs TLS", s)
+	}
+	return l, nil
+}
+
+// listen opens the listener, serving TLS if the spec has a certificate.
+// A socket file left by an earlier run is removed first; the listener
+// removes its own once closed.
+func (l listenSpec) listen() (net.Listener, error) {
+	if l.network == "unix" {
+		if info, err := os.Lstat(l.addr); err == nil && info.Mode()&fs.ModeSocket != 0 {
+			os.Remove(l.addr)
+		}
+	}
+	var config *tls.Config
+	if l.certFile != "" {
+		cert, err := tls.LoadX509KeyPair(l.certFile, l.keyFile)
+		if err != nil {
+			return nil, err
+		}
+		config = &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+		if l.clientCA != "" {
+			pem, err := os.ReadFile(l.clientCA)
+			if err != nil {
+				return nil, err
+			}
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(pem) {
+				return nil, errors.New("no certificates in " + l.clientCA)
+			}
+			config.ClientCAs, config.ClientAuth = pool, tls.RequireAndVerifyClientCert
+		}
+	}
+	ln, err := net.Listen(l.network, l.addr)
+	if err != nil {
+		return nil, err
+	}
+	if config != nil {
+		ln = tls.NewListener(ln, config)
+	}
+	return ln, nil
+}
+
+// url returns the base URL clients reach ln, opened from the spec, at.
+func (l listenSpec) url(ln net.Listener) string {
+	if l.network == "unix" {
+		return "unix:" + l.addr
+	}
+	if l.certFile != "" {
+		return "https://" + ln.Addr().String()
+	}
+	return "http://" + ln.Addr().String()
+}
@@ -0,0 +1,267 @@
+// Package locale translates the tool's user-facing messages. Messages are
+// looked up by their English text, format verbs and all, in a catalog of
+// translations loaded for the operator's language; anything a catalog
+// lacks is shown in English, so catalogs can be partial and output never
+// depends on one being complete.
+//
+// A catalog is a YAML map from English message to translation:
+//
+//	"❌ Failed to connect: %v\n": "❌ Verbindung fehlgeschlagen: %v\n"
+//	"Save": "Speichern"
+//
+// Translations keep the English message's verbs, in the same order, and
+// its leading and trailing newlines. The embedded English catalog lists
+// every message and is the template to translate.
+package locale
+
+import (
+	"bytes"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+//go:embed catalogs/*.yaml
+var catalogs embed.FS
+
+// Default is the language messages are written in.
+const Default = "en"
+
+// Catalog maps English messages to their translations.
+type Catalog map[string]string
+
+// English returns the embedded English catalog, which maps every message
+// to itself.
+func English() Catalog {
+	c, err := FS(catalogs, "catalogs").Load(Default)
+	if err != nil {
+		panic(err)
+	}
+	return c
+}
+
+// Loader loads the catalog of a language, given as a POSIX locale name
+// without its encoding such as de_AT or de. A language it has no catalog
+// for is an error wrapping fs.ErrNotExist.
+type Loader interface {
+	Load(lang string) (Catalog, error)
+}
+
+// LoaderFunc adapts a function to a Loader.
+type LoaderFunc func(lang string) (Catalog, error)
+
+func (f LoaderFunc) Load(lang string) (Catalog, error) {
+	return f(lang)
+}
+
+// Dir loads catalogs from files named LANG.yaml in dir.
+func Dir(dir string) Loader {
+	return FS(os.DirFS(dir), ".")
+}
+
+// FS loads catalogs from files named LANG.yaml in dir of fsys, such as
+// an embed.FS compiled into a build.
+func FS(fsys fs.FS, dir string) Loader {
+	return LoaderFunc(func(lang string) (Catalog, error) {
+		if lang == "" || strings.ContainsAny(lang, `/\.`) {
+			return nil, fmt.Errorf("language %q: %w", lang, fs.ErrNotExist)
+		}
+		data, err := fs.ReadFile(fsys, path.Join(dir, lang+".yaml"))
+		if err != nil {
+			return nil, err
+		}
+		return Parse(data)
+	})
+}
+
+// Parse parses a catalog file.
+func Parse(data []byte) (Catalog, error) {
+	var c Catalog
+	err := yaml.NewDecoder(bytes.NewReader(data)).Decode(&c)
+	if errors.Is(err, io.EOF) {
+		return Catalog{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid catalog: %w", err)
+	}
+	for message, translation := range c {
+		if verbs(message) != verbs(translation) {
+			return nil, fmt.Errorf("translation of %q has verbs %q, want %q", message, verbs(translation), verbs(message))
+		}
+	}
+	return c, nil
+}
+
+// verbs returns the format verbs of s, in order, so a translation can't
+// misformat its arguments.
+func verbs(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '%' {
+			continue
+		}
+		j := i + 1
+		for j < len(s) && strings.IndexByte("+-# 0123456789.*[]", s[j]) >= 0 {
+			j++
+		}
+		if j < len(s) {
+			b.WriteString(s[i : j+1])
+		}
+		i = j
+	}
+	return b.String()
+}
+
+// Language returns the language the environment asks messages in, the
+// first set of LC_ALL, LC_MESSAGES and LANG as POSIX has it, without its
+// encoding or modifier: de_AT for de_AT.UTF-8. The C and POSIX locales
+// are English.
+func Language() string {
+	for _, name := range []string{"LC_ALL", "LC_MESSAGES", "LANG"} {
+		value := os.Getenv(name)
+		if value == "" {
+			continue
+		}
+		value, _, _ = strings.Cut(value, ".")
+		value, _, _ = strings.Cut(value, "@")
+		if value == "C" || value == "POSIX" {
+			return Default
+		}
+		return value
+	}
+	return Default
+}
+
+// Load loads lang's catalog with loader, falling back from a regional
+// variant such as de_AT to its language, de. English, or a language the
+// loader has no catalog for, is the empty catalog.
+func Load(loader Loader, lang string) (Catalog, error) {
+	candidates := []string{lang}
+	if base, _, ok := strings.Cut(lang, "_"); ok {
+		candidates = append(candidates, base)
+	}
+	for _, candidate := range candidates {
+		if candidate == Default {
+			break
+		}
+		c, err := loader.Load(candidate)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("catalog %s: %w", candidate, err)
+		}
+		return c, nil
+	}
+	return Catalog{}, nil
+}
+
+// DefaultDir is where a user's catalogs are looked for: a directory named
+// name in their home directory, or in the working directory if they have
+// none.
+func DefaultDir(name string) string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return name
+	}
+	return filepath.Join(home, name)
+}
+
+// Printer writes messages translated by its catalog, like the fmt
+// functions of the same names.
+type Printer struct {
+	catalog Catalog
+}
+
+// NewPrinter returns a printer translating with c; a nil catalog leaves
+// messages in English.
+func NewPrinter(c Catalog) *Printer {
+	return &Printer{catalog: c}
+}
+
+// T returns message's translation, or message itself if the catalog has
+// none.
+func (p *Printer) T(message string) string {
+	if t, ok := p.catalog[message]; ok && t != "" {
+		return t
+	}
+	return message
+}
+
+// Sprintf formats the translation of format.
+func (p *Printer) Sprintf(format string, a ...any) string {
+	return fmt.Sprintf(p.T(format), a...)
+}
+
+// Printf writes the translation of format, formatted, to stdout.
+func (p *Printer) Printf(format string, a ...any) (int, error) {
+	return fmt.Printf(p.T(format), a...)
+}
+
+// Fprintf writes the translation of format, formatted, to w.
+func (p *Printer) Fprintf(w io.Writer, format string, a ...any) (int, error) {
+	return fmt.Fprintf(w, p.T(format), a...)
+}
+
+// Println writes its operands to stdout as fmt.Println does, translating
+// those that are strings.
+func (p *Printer) Println(a ...any) (int, error) {
+	return fmt.Println(p.translate(a)...)
+}
+
+// Fprintln writes its operands to w as fmt.Fprintln does, translating
+// those that are strings.
+func (p *Printer) Fprintln(w io.Writer, a ...any) (int, error) {
+	return fmt.Fprintln(w, p.translate(a)...)
+}
+
+// Print writes its operands to stdout as fmt.Print does, translating
+// those that are strings.
+func (p *Printer) Print(a ...any) (int, error) {
+	return fmt.Print(p.translate(a)...)
+}
+
+// pageMessages is the script declaration a web page's translations are
+// filled into.
+const pageMessages = "const messages = {};"
+
+// Page returns a web page with the translations of the messages it has
+// filled into its script's `const messages = {};`, which the page looks
+// its text up in.
+func (p *Printer) Page(page []byte) []byte {
+	used := Catalog{}
+	for message, translation := range p.catalog {
+		if translation != "" && bytes.Contains(page, []byte(message)) {
+			used[message] = translation
+		}
+	}
+	if len(used) == 0 {
+		return page
+	}
+	// Marshal escapes <, > and &, so translations can't end the script.
+	data, err := json.Marshal(used)
+	if err != nil {
+		return page
+	}
+	return bytes.Replace(page, []byte(pageMessages), []byte("const messages = "+string(data)+";"), 1)
+}
+
+func (p *Printer) translate(a []any) []any {
+	out := make([]any, len(a))
+	for i, v := range a {
+		if s, ok := v.(string); ok {
+			v = p.T(s)
+		}
+		out[i] = v
+	}
+	return out
+}
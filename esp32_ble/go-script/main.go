@@ -1,197 +1,798 @@
 package main
 
 import (
+	"context"
+	"encoding/csv"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
+	"net"
+	"net/http"
 	"os"
+	"os/signal"
+	"slices"
+	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
+	"bluetooth/climate"
+	"bluetooth/contact"
+	"bluetooth/esp32"
+	"bluetooth/esp32/replay"
+	"bluetooth/exporter"
+	"bluetooth/locale"
+	"bluetooth/occupancy"
+	"bluetooth/pinmodel"
+
 	"tinygo.org/x/bluetooth"
 )
 
-var adapter = bluetooth.DefaultAdapter
+var adapter = esp32.NewBLEAdapter(bluetooth.DefaultAdapter)
 
-func main() {
-	namePtr := flag.String("name", "", "Name of the Bluetooth device to connect to (required)")
-	timeoutPtr := flag.Int("timeout", 30, "Scan timeout in seconds")
-	flag.Parse()
+// openSerial opens the port for --transport serial.
+var openSerial = esp32.OpenSerial
 
-	if *namePtr == "" {
-		fmt.Println("Error: --name flag is required")
-		fmt.Println("\nUsage:")
-		flag.PrintDefaults()
-		os.Exit(1)
-	}
+// capture, if set by --capture, records every client's GATT traffic.
+var capture *esp32.Capture
 
-	fmt.Printf("🔍 Scanning for Bluetooth device: \"%s\"\n", *namePtr)
-	fmt.Printf("⏱️  Timeout: %d seconds\n\n", *timeoutPtr)
+// recorder, if set by --record, records every adapter's scans and GATT
+// operations for --replay.
+var recorder *esp32.Recorder
 
-	// Enable the Bluetooth adapter
-	err := adapter.Enable()
-	if err != nil {
-		fmt.Printf("❌ Failed to enable Bluetooth adapter: %v\n", err)
-		os.Exit(1)
-	}
+// metrics, if set by --exporter, is served to Prometheus and fed every
+// polled reading and connection change.
+var metrics *exporter.Exporter
 
-	// Channel to signal when device is found
-	deviceFound := make(chan bluetooth.ScanResult, 1)
-	timeout := time.After(time.Duration(*timeoutPtr) * time.Second)
+// journal, if set by --journal, records the value changes of polled
+// readings.
+var journal *esp32.Journal
 
-	// Start scanning
-	go func() {
-		err := adapter.Scan(func(adapter *bluetooth.Adapter, result bluetooth.ScanResult) {
-			deviceName := result.LocalName()
+// contacts, if the profile configures contact sensors, follows them
+// through polled pin readings.
+var contacts *contact.Tracker
 
-			// Print all discovered devices for visibility
-			if deviceName != "" {
-				fmt.Printf("📱 Found: %s (Address: %s, RSSI: %d dBm)\n",
-					deviceName, result.Address.String(), result.RSSI)
-			}
+// motion, if the profile configures motion sensors, follows them and
+// their light levels through polled readings, switching their lights.
+var motion *occupancy.Tracker
 
-			// Check if this is the device we're looking for (case-insensitive)
-			if strings.EqualFold(deviceName, *namePtr) {
-				select {
-				case deviceFound <- result:
-					adapter.StopScan()
-				default:
-				}
-			}
-		})
+// protection, if the profile configures climate presets, runs their
+// heaters and fans from polled ADC readings.
+var protection *climate.Tracker
 
-		if err != nil {
-			fmt.Printf("❌ Scan error: %v\n", err)
+// writePolicy, if set by --reliable, makes every client's pin writes
+// acknowledged and retried.
+var writePolicy *esp32.WritePolicy
+
+// profile is the GATT layout clients are set up with: the stock
+// firmware's unless --profile picks one from the config file.
+var profile = esp32.DefaultProfile()
+
+// msg prints the tool's messages in the operator's language, as set by
+// LC_ALL, LC_MESSAGES or LANG, from the catalogs localeLoader finds.
+var msg = locale.NewPrinter(nil)
+
+// localeLoader loads message catalogs: by default de.yaml and the like
+// from ~/.esp32_interfaces_locale, which deployments can fill with their
+// own translations.
+var localeLoader locale.Loader = locale.Dir(locale.DefaultDir(localeDirName))
+
+// localeDirName is the directory, in the home directory, catalogs are
+// loaded from.
+const localeDirName = ".esp32_interfaces_locale"
+
+// pinModel is the chip pin maps and writes are checked against, warning
+// of pins that can't do what they are used for.
+var pinModel = pinmodel.Default()
+
+// commands are the subcommands selectable as the first argument. With no
+// subcommand the tool scans, connects and reads the ADC characteristic.
+// Each is passed a context cancelled by SIGINT or SIGTERM, and any can be
+// run against a simulated board by putting demo before it.
+var commands = map[string]func(ctx context.Context, args []string){
+	"bench":         runBench,
+	"boards":        runBoards,
+	"bridge":        runBridge,
+	"conformance":   runConformance,
+	"download":      runDownload,
+	"explore":       runExplore,
+	"export":        runExport,
+	"hash-password": runHashPassword,
+	"history":       runHistory,
+	"import":        runImport,
+	"list":          runList,
+	"monitor-rssi":  runMonitorRSSI,
+	"new-project":   runNewProject,
+	"ota":           runOTA,
+	"preset":        runPreset,
+	"rules":         runRules,
+	"selftest":      runSelfTest,
+	"serve":         runServe,
+	"snapshot":      runSnapshot,
+	"soak":          runSoak,
+	"sync-write":    runSyncWrite,
+	"walk-test":     runWalkTest,
+	"wiring":        runWiring,
+	"write":         runWrite,
+}
+
+func main() {
+	// Interrupting cancels ctx so scans are stopped and boards disconnected
+	// on the way out rather than left to the OS.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	catalog, err := locale.Load(localeLoader, locale.Language())
+	if err != nil {
+		fmt.Printf("⚠️  %v; messages are in English\n", err)
+	}
+	msg = locale.NewPrinter(catalog)
+
+	if len(os.Args) > 1 && os.Args[1] == "demo" {
+		os.Args = startDemo(ctx, os.Args)
+	}
+	if len(os.Args) > 1 {
+		if cmd, ok := commands[os.Args[1]]; ok {
+			cmd(ctx, os.Args[2:])
+			return
+		}
+	}
+
+	var names stringList
+	flag.Var(&names, "name", "Name or address of the Bluetooth device to connect to, or prefix:<name start>, re:<regexp>, exact:<name> or service:<uuid> (required, repeatable)")
+	devicesPtr := flag.String("devices", "", "File listing device names to connect to, one per line")
+	timeoutPtr := flag.Int("timeout", 30, "Scan timeout in seconds")
+	pollPtr := flag.Duration("poll", 0, "Re-read the ADC characteristic at this interval (e.g. 500ms) until interrupted")
+	logFilePtr := flag.String("log-file", "", "Append decoded readings to this CSV file (timestamp,pin,value)")
+	replPtr := flag.Bool("repl", false, "Keep the connection open and read commands from stdin")
+	scriptPtr := flag.String("script", "", "Run the REPL commands in this file (- for stdin) over one connection, e.g. for bring-up tests, and report which failed")
+	var adapterIDs stringList
+	flag.Var(&adapterIDs, "adapter", "Bluetooth adapter to use, e.g. hci1 (repeatable; several spread the boards between them)")
+	maxPerAdapterPtr := flag.Int("max-per-adapter", 7, "Most boards to connect through one adapter (0 for no limit)")
+	minRSSIPtr := flag.Int("min-rssi", 0, "Ignore devices advertising weaker than this many dBm, e.g. -70 (0 for no limit)")
+	phyPtr := flag.String("phy", "", "PHY to request after connecting: 1m, 2m (throughput) or coded (range); needs Bluetooth 5 on adapter and board")
+	configPtr := flag.String("config", "", "Config file with device profiles (default ~/"+defaultConfigName+")")
+	profilePtr := flag.String("profile", "", "Device profile from the config file, giving the board and its firmware's UUIDs")
+	pairPtr := flag.Bool("pair", false, "Pair and bond with boards whose characteristics need encryption, prompting for passkeys")
+	passkeyPtr := flag.String("passkey", "", "Answer pairing prompts with this 6-digit passkey instead of asking (implies --pair)")
+	capturePtr := flag.String("capture", "", "Write all GATT operations to this btsnoop file for Wireshark")
+	recordPtr := flag.String("record", "", "Record scans and GATT operations to this file, to run against later with --replay")
+	replayPtr := flag.String("replay", "", "Run against a file written by --record instead of Bluetooth")
+	exporterPtr := flag.String("exporter", "", "Serve Prometheus metrics on this address, e.g. :9100, polling the boards (every 5s unless --poll is given)")
+	flag.DurationVar(&scanCacheAge, "scan-cache", 0, "Connect to an address saved by list --cache if it was seen within this long (e.g. 10m), skipping the scan")
+	journalPtr := flag.String("journal", "", "Append only the value changes of readings to this CSV file, shown by the history command")
+	journalPinsPtr := flag.String("journal-pins", "", "Comma-separated pins to journal (default: all)")
+	reliable := reliableFlags(flag.CommandLine)
+	transport := transportFlags(flag.CommandLine)
+	scan := scanFlags(flag.CommandLine)
+	var alertExprs stringList
+	flag.Var(&alertExprs, "alert", "Rule checked against every reading, e.g. \"pin34>3000 for 10s -> write 25=1\"; actions are print, exec CMD, write PIN=STATE and mqtt TOPIC (repeatable)")
+	alertBrokerPtr := flag.String("alert-broker", "tcp://localhost:1883", "MQTT broker for alert rules' mqtt actions")
+	journalDeadbandPtr := flag.Int("journal-deadband", 0, "Least change of a value to journal, to ignore ADC noise")
+	flag.Parse()
+
+	if *capturePtr != "" {
+		capture = openCapture(*capturePtr)
+	}
+	if *recordPtr != "" {
+		recorder = openRecording(*recordPtr)
+	}
+	if *replayPtr != "" {
+		if len(adapterIDs) > 0 {
+			msg.Println("❌ --replay cannot be combined with --adapter")
 			os.Exit(1)
 		}
-	}()
+		adapter = openReplay(*replayPtr)
+	}
+	port := transport()
+	if port != "" && (len(adapterIDs) > 0 || *replayPtr != "") {
+		msg.Println("❌ --transport serial cannot be combined with --adapter or --replay")
+		os.Exit(1)
+	}
 
-	// Wait for device to be found or timeout
-	select {
-	case result := <-deviceFound:
-		fmt.Printf("\n✅ Found target device: %s\n", result.LocalName())
-		fmt.Printf("📍 Address: %s\n", result.Address.String())
-		fmt.Printf("📶 Signal strength: %d dBm\n\n", result.RSSI)
+	if *passkeyPtr != "" {
+		if _, err := parsePasskey(*passkeyPtr); err != nil {
+			msg.Printf("❌ %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if *pairPtr || *passkeyPtr != "" {
+		pairAgent = &terminalAgent{passkey: *passkeyPtr}
+	}
 
-		// Connect to the device
-		fmt.Println("🔌 Connecting...")
+	if *profilePtr != "" {
+		p := loadProfile(*configPtr, *profilePtr)
+		profile = p.esp32Profile().WithDefaults()
+		if sensors := p.sensors(); len(sensors) > 0 {
+			contacts = contact.NewTracker(sensors)
+		}
+		if sensors := p.motionSensors(); len(sensors) > 0 {
+			motion = occupancy.NewTracker(sensors)
+		}
+		if protectors := p.protectors(); len(protectors) > 0 {
+			protection = climate.NewTracker(protectors)
+		}
+		if p.Anomaly != nil {
+			// Already validated with the config file.
+			d, _ := p.detector()
+			startAnomalies(d, p.Anomaly.BaselineFile)
+		}
+		alertExprs = append(alertExprs, p.Alerts...)
+		if len(names) == 0 && *devicesPtr == "" {
+			names = append(names, p.target())
+		}
+		if *pollPtr == 0 {
+			*pollPtr = p.PollInterval
+		}
+	}
 
-		device, err := adapter.Connect(result.Address, bluetooth.ConnectionParams{})
+	if *devicesPtr != "" {
+		lines, err := readListFile(*devicesPtr)
 		if err != nil {
-			fmt.Printf("❌ Failed to connect: %v\n", err)
+			msg.Printf("❌ Failed to read devices file: %v\n", err)
 			os.Exit(1)
 		}
+		names = append(names, lines...)
+	}
+	if len(names) == 0 && port != "" {
+		names = append(names, port)
+	}
+	if len(names) == 0 {
+		msg.Println("Error: --name flag is required")
+		msg.Println("\nUsage:")
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+
+	if *exporterPtr != "" {
+		metrics = serveMetrics(ctx, *exporterPtr)
+		if *pollPtr == 0 {
+			*pollPtr = 5 * time.Second
+		}
+	}
 
-		fmt.Printf("✅ Successfully connected to %s!\n", result.LocalName())
-		fmt.Printf("🔗 Connection handle: %v\n\n", device)
+	writePolicy = reliable()
+	phy := parsePHYFlag(*phyPtr)
+	pool := openPool(adapterIDs, *maxPerAdapterPtr, *minRSSIPtr, scan)
 
-		// Discover services
-		fmt.Println("🔍 Discovering services...")
-		services, err := device.DiscoverServices(nil)
+	var logWriter *esp32.CSVWriter
+	if *logFilePtr != "" {
+		logWriter = openLogFile(*logFilePtr, nil)
+	}
+	if *journalPtr != "" {
+		pins, err := parsePinList(*journalPinsPtr)
 		if err != nil {
-			fmt.Printf("❌ Failed to discover services: %v\n", err)
+			msg.Printf("❌ --journal-pins: %v\n", err)
 			os.Exit(1)
 		}
+		journal = openJournal(*journalPtr, pins, *journalDeadbandPtr)
+	}
+	if len(alertExprs) > 0 {
+		startAlerts(parseAlerts(alertExprs), *alertBrokerPtr)
+	}
 
-		fmt.Printf("📋 Found %d service(s)\n\n", len(services))
+	if len(names) > 1 {
+		runMultiDevice(ctx, pool, names, time.Duration(*timeoutPtr)*time.Second, *pollPtr, phy, logWriter)
+		return
+	}
 
-		// Target characteristic UUID (ADC data output)
-		// Pin data output: 13c0ef83-09bd-4767-97cb-ee46224ae6db
-		// Pin data input (write): c79b2ca7-f39d-4060-8168-816fa26737b7
-		// ADC data output: 01037594-1bbb-4490-aa4d-f6d333b42e16
-		targetUUID, err := bluetooth.ParseUUID("01037594-1bbb-4490-aa4d-f6d333b42e16")
-		if err != nil {
-			fmt.Printf("❌ Invalid UUID: %v\n", err)
+	if *pollPtr > 0 {
+		runPolling(ctx, pool, names[0], time.Duration(*timeoutPtr)*time.Second, *pollPtr, phy, logWriter)
+		return
+	}
+
+	client := connectDevice(ctx, names[0], time.Duration(*timeoutPtr)*time.Second)
+	requestPHY(ctx, "", client, phy)
+
+	if *replPtr {
+		runREPL(ctx, client)
+		client.Disconnect()
+		return
+	}
+	if *scriptPtr != "" {
+		ok := runScript(ctx, client, *scriptPtr)
+		client.Disconnect()
+		if !ok {
 			os.Exit(1)
 		}
+		return
+	}
+
+	// Target characteristic UUID (ADC data output)
+	targetUUID := profile.ADCOutputUUID
+	if _, err := client.Characteristic(targetUUID); err != nil {
+		msg.Printf("\n❌ Characteristic %s not found (see list above for what the device exposes)\n", targetUUID)
+		client.Disconnect()
+		os.Exit(1)
+	}
+	msg.Printf("\n✅ Found target characteristic: %s\n\n", targetUUID)
+
+	// ADC DATA OUTPUT
+	err = readADC(ctx, client, logWriter)
+	client.Disconnect()
+	var unknown *esp32.UnknownPayloadError
+	if errors.As(err, &unknown) {
+		// Already reported by the client's handler.
+		os.Exit(1)
+	}
+	if err != nil {
+		msg.Printf("❌ %v\n", err)
+		os.Exit(1)
+	}
+
+	// REGULAR PIN DATA OUTPUT
+	// buffer := make([]byte, 1024)
+	// readValue, err := targetChar.Read(buffer)
+	// if err != nil {
+	// 	fmt.Printf("❌ Failed to read: %v\n", err)
+	// 	os.Exit(1)
+	// }
+	// fmt.Printf("✅ Read value: %v\n", readValue)
+	// fmt.Printf("✅ Read value: %v\n", buffer[:readValue])
+	// numPins := buffer[0]
+	// for i := 0; i < int(numPins); i++ {
+	// 	pin := buffer[i*2+1]
+	// 	value := buffer[i*2+2]
+	// 	fmt.Printf("✅ Pin: %d, Value: %d\n", pin, value)
+	// }
+
+	// WRIITNG
+	// Write "hello" to the characteristic
+	// fmt.Println("✍️  Writing \"hello\" to characteristic...\n")
+
+	// message := []byte("{\"pin_writes\": [{\"pin_num\": 14, \"state\": 100}]}")
+	// fmt.Println(len(message))
+	// _, err = targetChar.Write(message)
+	// if err != nil {
+	// 	fmt.Printf("❌ Failed to write: %v\n", err)
+	// 	device.Disconnect()
+	// 	os.Exit(1)
+	// }
+	// fmt.Printf("✅ Wrote: \"hello\" (%v)\n", message)
+	// fmt.Println("🔌 Disconnecting...")
+
+	// err = device.Disconnect()
+	// if err != nil {
+	// 	fmt.Printf("⚠️  Disconnect warning: %v\n", err)
+	// }
+
+	// fmt.Println("👋 Done!")
+}
 
-		// Discover ALL characteristics (nil = no filter); some stacks don't return
-		// all characteristics when filtering by UUID, so we discover all and find by UUID.
-		var targetChar bluetooth.DeviceCharacteristic
-		found := false
+// readADC reads the ADC characteristic once, printing the raw frame and
+// decoded readings and appending them to logWriter if set.
+func readADC(ctx context.Context, client *esp32.Client, logWriter *esp32.CSVWriter) error {
+	if metrics != nil {
+		// Deferred so failed reads show up in the error counters too.
+		defer metrics.ObserveCharacteristics(client)
+	}
+	frame, err := client.ReadRaw(ctx, client.Profile().ADCOutputUUID)
+	if err != nil {
+		return err
+	}
+	msg.Printf("✅ Read value: %v\n", len(frame))
+	msg.Printf("✅ Read value: %v\n", frame)
+	readings, err := client.Decode(client.Profile().ADCOutputUUID, frame, time.Now())
+	if err != nil {
+		return err
+	}
+	for _, reading := range readings {
+		msg.Printf("✅ Pin: %s, Value: %d\n", pinLabel(reading), reading.Value)
+	}
+	if metrics != nil {
+		metrics.ObserveADC(readings)
+	}
+	if logWriter != nil {
+		if err := logWriter.Write(readings); err != nil {
+			return fmt.Errorf("failed to write log file: %w", err)
+		}
+	}
+	return observePins(ctx, "", client, readings)
+}
 
-		fmt.Println("🔍 Discovering all characteristics...")
-		for _, service := range services {
-			chars, err := service.DiscoverCharacteristics(nil)
-			if err != nil {
-				fmt.Printf("⚠️  DiscoverCharacteristics error for service %s: %v\n", service.UUID().String(), err)
-				continue
+// observePins reads the client's pins for the journal, contact and
+// motion sensors, anomaly detection and alert rules, if any are set up,
+// feeding them with the ADC readings just taken and printing events
+// prefixed with prefix. Motion lights, climate outputs and alert writes
+// are switched here. The firmware's digital inputs are only on the pin
+// characteristic, so this is a second read.
+func observePins(ctx context.Context, prefix string, client *esp32.Client, adc []esp32.Reading) error {
+	if protection != nil {
+		for _, reading := range adc {
+			for _, e := range protection.Update(reading) {
+				if err := handleClimateEvent(ctx, prefix, client, e); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	if journal == nil && contacts == nil && motion == nil && anomalies == nil && alerts == nil {
+		return nil
+	}
+	pins, err := client.ReadPins(ctx)
+	if err != nil {
+		return err
+	}
+	var scores []esp32.Reading
+	if anomalies != nil {
+		scores = scoreAnomalies(prefix, slices.Concat(adc, pins))
+	}
+	if alerts != nil {
+		evaluateAlerts(ctx, prefix, client, slices.Concat(adc, pins, scores))
+	}
+	if journal != nil {
+		if _, err := journal.Record(append(pins, adc...)); err != nil {
+			return fmt.Errorf("failed to write journal file: %w", err)
+		}
+	}
+	if contacts != nil {
+		for _, reading := range pins {
+			for _, e := range contacts.Update(reading) {
+				printContactEvent(prefix, e)
 			}
-			fmt.Printf("   Service %s: %d characteristic(s)\n", service.UUID().String(), len(chars))
-			for _, c := range chars {
-				cu := c.UUID()
-				fmt.Printf("      - %s\n", cu.String())
-				if cu.String() == targetUUID.String() {
-					targetChar = c
-					found = true
+		}
+	}
+	if motion != nil {
+		// Light levels first, so motion in this poll sees the current one.
+		for _, reading := range slices.Concat(adc, pins) {
+			for _, e := range motion.Update(reading) {
+				if err := handleMotionEvent(ctx, prefix, client, e); err != nil {
+					return err
 				}
 			}
 		}
+	}
+	return nil
+}
 
-		if !found {
-			fmt.Printf("\n❌ Characteristic %s not found (see list above for what the device exposes)\n", targetUUID.String())
-			os.Exit(1)
+// handleMotionEvent reports a motion sensor event, writing the pin of
+// light changes to the board.
+func handleMotionEvent(ctx context.Context, prefix string, client *esp32.Client, e occupancy.Event) error {
+	switch e.Kind {
+	case occupancy.Motion:
+		msg.Printf("🏃 %sMotion at %s\n", prefix, e.Sensor.Name)
+	case occupancy.Occupied:
+		msg.Printf("🏠 %s%s occupied\n", prefix, e.Sensor.Name)
+	case occupancy.Vacant:
+		msg.Printf("🏠 %s%s vacant after %v\n", prefix, e.Sensor.Name, e.Duration.Round(time.Second))
+	case occupancy.LightOn, occupancy.LightOff:
+		msg.Printf("💡 %s%s %s (pin %d = %d)\n", prefix, e.Sensor.Name, e.Kind, e.Write.PinNum, e.Write.State)
+		if err := client.WritePins(ctx, []esp32.PinWrite{e.Write}); err != nil {
+			return fmt.Errorf("switching %s light: %w", e.Sensor.Name, err)
 		}
-		fmt.Printf("\n✅ Found target characteristic: %s\n\n", targetUUID.String())
+	}
+	return nil
+}
+
+// handleClimateEvent reports a climate preset switching its output and
+// writes the pin to the board.
+func handleClimateEvent(ctx context.Context, prefix string, client *esp32.Client, e climate.Event) error {
+	p := e.Protector
+	measure := fmt.Sprintf("%.1f°C", e.Value)
+	if p.Preset == climate.Condensation {
+		measure = fmt.Sprintf("%.1f°C above the dew point", e.Value)
+	}
+	switch e.Kind {
+	case climate.On:
+		msg.Printf("🌡️  %s%s %s on at %s (pin %d = %d)\n", prefix, p.Name, p.Preset.Output(), measure, e.Write.PinNum, e.Write.State)
+	case climate.Off:
+		msg.Printf("🌡️  %s%s %s off at %s after %v (pin %d = %d)\n", prefix, p.Name, p.Preset.Output(), measure, e.Duration.Round(time.Second), e.Write.PinNum, e.Write.State)
+	}
+	if err := client.WritePins(ctx, []esp32.PinWrite{e.Write}); err != nil {
+		return fmt.Errorf("switching %s %s: %w", p.Name, p.Preset.Output(), err)
+	}
+	return nil
+}
+
+// printContactEvent reports a contact sensor opening, closing or being
+// left open.
+func printContactEvent(prefix string, e contact.Event) {
+	switch e.Kind {
+	case contact.Opened:
+		msg.Printf("🚪 %s%s opened\n", prefix, e.Sensor.Name)
+	case contact.Closed:
+		msg.Printf("🚪 %s%s closed after %v\n", prefix, e.Sensor.Name, e.Duration.Round(time.Second))
+	case contact.LeftOpen:
+		msg.Printf("🚨 %s%s left open for %v\n", prefix, e.Sensor.Name, e.Duration.Round(time.Second))
+	}
+}
 
-		// ADC DATA OUTPUT
-		buffer := make([]byte, 1024)
-		readValue, err := targetChar.Read(buffer)
+// openPool returns a pool over the named adapters, or over the default
+// adapter if none are named, scanning as scan sets them up and only for
+// devices at minRSSI dBm or stronger unless it is zero. The first adapter
+// also becomes the one single-board commands use.
+func openPool(ids []string, limit, minRSSI int, scan func(esp32.Adapter) esp32.Adapter) *esp32.Pool {
+	adapters := []esp32.Adapter{adapter}
+	if len(ids) > 0 {
+		adapters = nil
+	}
+	for _, id := range ids {
+		a, err := esp32.OpenBLEAdapter(id)
 		if err != nil {
-			fmt.Printf("❌ Failed to read: %v\n", err)
+			msg.Printf("❌ %v\n", err)
+			os.Exit(1)
+		}
+		adapters = append(adapters, a)
+	}
+	for i, a := range adapters {
+		adapters[i] = scan(a)
+	}
+	if recorder != nil {
+		for i, a := range adapters {
+			adapters[i] = recorder.Adapter(a)
+		}
+	}
+	if minRSSI != 0 {
+		for i, a := range adapters {
+			adapters[i] = esp32.FilterRSSI(a, int16(minRSSI))
+		}
+	}
+	adapter = adapters[0]
+	return esp32.NewPool(limit, adapters...)
+}
+
+// serveMetrics starts serving Prometheus metrics on addr until ctx is
+// done, exiting if addr can't be listened on.
+func serveMetrics(ctx context.Context, addr string) *exporter.Exporter {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		msg.Printf("❌ Failed to start metrics exporter: %v\n", err)
+		os.Exit(1)
+	}
+	e := exporter.New()
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", e)
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go server.Serve(listener)
+	context.AfterFunc(ctx, func() { server.Close() })
+	msg.Printf("📈 Serving Prometheus metrics on http://%s/metrics\n", listener.Addr())
+	return e
+}
+
+// openCapture creates a btsnoop capture file at path, exiting on error.
+func openCapture(path string) *esp32.Capture {
+	f, err := os.Create(path)
+	if err != nil {
+		msg.Printf("❌ Failed to create capture file: %v\n", err)
+		os.Exit(1)
+	}
+	c, err := esp32.NewCapture(f)
+	if err != nil {
+		msg.Printf("❌ Failed to write capture file: %v\n", err)
+		os.Exit(1)
+	}
+	msg.Printf("📼 Capturing GATT operations to %s (open it with Wireshark)\n", path)
+	return c
+}
+
+// openRecording creates a recording file at path, exiting on error.
+func openRecording(path string) *esp32.Recorder {
+	f, err := os.Create(path)
+	if err != nil {
+		msg.Printf("❌ Failed to create recording: %v\n", err)
+		os.Exit(1)
+	}
+	msg.Printf("⏺️  Recording GATT operations to %s (replay them with --replay)\n", path)
+	return esp32.NewRecorder(f)
+}
+
+// openReplay loads the recording at path as the adapter, exiting on
+// error.
+func openReplay(path string) *replay.Adapter {
+	a, err := replay.Open(path)
+	if err != nil {
+		msg.Printf("❌ Failed to load recording: %v\n", err)
+		os.Exit(1)
+	}
+	msg.Printf("⏯️  Replaying %s instead of using Bluetooth\n", path)
+	return a
+}
+
+// openLogFile opens path for appending readings, writing a header of
+// columns (esp32.CSVColumns if nil) if the file is new or empty.
+func openLogFile(path string, columns []string) *esp32.CSVWriter {
+	w := appendCSV(path, columns)
+	msg.Printf("📝 Logging readings to %s\n", path)
+	return w
+}
+
+// pinLabel returns a reading's pin for printing, with its channel name
+// if the profile gives it one.
+func pinLabel(r esp32.Reading) string {
+	if r.Channel != "" {
+		return fmt.Sprintf("%d (%s)", r.Pin, r.Channel)
+	}
+	return strconv.Itoa(int(r.Pin))
+}
+
+// appendCSV is openLogFile without the announcement.
+func appendCSV(path string, columns []string) *esp32.CSVWriter {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_RDWR, 0o644)
+	if err != nil {
+		msg.Printf("❌ Failed to open log file: %v\n", err)
+		os.Exit(1)
+	}
+	// Keep the column order of an existing file.
+	if header, err := csv.NewReader(f).Read(); err == nil {
+		columns = nil
+		for _, name := range header {
+			columns = append(columns, strings.ToLower(strings.TrimSpace(name)))
+		}
+	} else if err != io.EOF {
+		msg.Printf("❌ Failed to read log file header: %v\n", err)
+		os.Exit(1)
+	} else if columns != nil {
+		w := csv.NewWriter(f)
+		w.Write(columns)
+		w.Flush()
+		if err := w.Error(); err != nil {
+			msg.Printf("❌ Failed to write log file: %v\n", err)
 			os.Exit(1)
 		}
-		fmt.Printf("✅ Read value: %v\n", readValue)
-		fmt.Printf("✅ Read value: %v\n", buffer[:readValue])
-		numPins := buffer[0]
-		for i := 0; i < int(numPins); i++ {
-			pin := buffer[i*3+1]
-			hsb := buffer[i*3+2]
-			lsb := buffer[i*3+3]
-			value := (int(hsb) << 8) | int(lsb)
-			fmt.Printf("✅ Pin: %d, Value: %d\n", pin, value)
-		}
-
-		// REGULAR PIN DATA OUTPUT
-		// buffer := make([]byte, 1024)
-		// readValue, err := targetChar.Read(buffer)
-		// if err != nil {
-		// 	fmt.Printf("❌ Failed to read: %v\n", err)
-		// 	os.Exit(1)
-		// }
-		// fmt.Printf("✅ Read value: %v\n", readValue)
-		// fmt.Printf("✅ Read value: %v\n", buffer[:readValue])
-		// numPins := buffer[0]
-		// for i := 0; i < int(numPins); i++ {
-		// 	pin := buffer[i*2+1]
-		// 	value := buffer[i*2+2]
-		// 	fmt.Printf("✅ Pin: %d, Value: %d\n", pin, value)
-		// }
-
-		// WRIITNG
-		// Write "hello" to the characteristic
-		// fmt.Println("✍️  Writing \"hello\" to characteristic...\n")
-
-		// message := []byte("{\"pin_writes\": [{\"pin_num\": 14, \"state\": 100}]}")
-		// fmt.Println(len(message))
-		// _, err = writeCharacteristic(targetChar, message)
-		// if err != nil {
-		// 	fmt.Printf("❌ Failed to write: %v\n", err)
-		// 	device.Disconnect()
-		// 	os.Exit(1)
-		// }
-		// fmt.Printf("✅ Wrote: \"hello\" (%v)\n", message)
-		// fmt.Println("🔌 Disconnecting...")
-
-		// err = device.Disconnect()
-		// if err != nil {
-		// 	fmt.Printf("⚠️  Disconnect warning: %v\n", err)
-		// }
-
-		// fmt.Println("👋 Done!")
-
-	case <-timeout:
-		adapter.StopScan()
-		fmt.Printf("\n⏱️  Timeout: Device \"%s\" not found after %d seconds\n", *namePtr, *timeoutPtr)
+	}
+	w, err := esp32.NewCSVWriter(f, columns)
+	if err != nil {
+		msg.Printf("❌ Failed to write log file: %v\n", err)
 		os.Exit(1)
 	}
+	return w
+}
+
+// connectDevice scans for a device by name, connects and discovers its
+// services, printing progress. With --scan-cache, a recently listed
+// address is connected to without scanning, falling back to a scan if
+// that fails. It exits the process on failure or if ctx is cancelled
+// first; nothing is left scanning or connected in that case.
+func connectDevice(ctx context.Context, name string, timeout time.Duration) *esp32.Client {
+	// Enable the Bluetooth adapter
+	err := adapter.Enable()
+	if err != nil {
+		msg.Printf("❌ Failed to enable Bluetooth adapter: %v\n", err)
+		os.Exit(1)
+	}
+
+	result, seen, cached := cachedScanResult(name)
+	if cached {
+		msg.Printf("⚡ Using cached address %s for \"%s\" (seen %v ago), skipping the scan\n\n",
+			result.Address, name, time.Since(seen).Round(time.Second))
+	} else {
+		result = scanDevice(ctx, name, timeout)
+	}
+
+	// Connect to the device and discover services
+	msg.Println("🔌 Connecting...")
+	client, err := esp32.Connect(ctx, adapter, result)
+	if err != nil && cached && ctx.Err() == nil {
+		msg.Printf("⚠️  Could not connect to the cached address: %v\n\n", err)
+		result = scanDevice(ctx, name, timeout)
+		msg.Println("🔌 Connecting...")
+		client, err = esp32.Connect(ctx, adapter, result)
+	}
+	if ctx.Err() != nil {
+		if err == nil {
+			client.Disconnect()
+		}
+		msg.Println("\n🛑 Interrupted")
+		os.Exit(1)
+	}
+	if err != nil {
+		msg.Printf("❌ %v\n", err)
+		os.Exit(1)
+	}
+	client.SetProfile(profile)
+	client.SetWritePolicy(writePolicy)
+	client.SetUnknownPayloadHandler(func(err *esp32.UnknownPayloadError) {
+		printUnknownPayload("", err)
+	})
+	if capture != nil {
+		client.Capture(capture)
+	}
+	msg.Printf("✅ Successfully connected to %s!\n\n", result.Name)
+
+	printServices(client)
+	if err := pairClient(ctx, "", client); err != nil {
+		client.Disconnect()
+		msg.Printf("❌ %v\n", err)
+		os.Exit(1)
+	}
+	if !slices.ContainsFunc(client.Services, func(s esp32.ServiceInfo) bool {
+		return strings.EqualFold(s.UUID, profile.ServiceUUID)
+	}) {
+		msg.Printf("⚠️  Service %s not found; is the profile right for this firmware?\n", profile.ServiceUUID)
+	}
+	identifyClient(ctx, "", client)
+	return client
+}
+
+// identifyClient sets a connected board's ID with the profile's
+// Identifier, warning and leaving it the board's address if that fails.
+func identifyClient(ctx context.Context, prefix string, client *esp32.Client) {
+	if err := client.Identify(ctx, profile.Identifier()); err != nil {
+		msg.Printf("⚠️  %sCould not identify %s: %v; its readings are kept under its address\n", prefix, client.Name, err)
+		return
+	}
+	if client.ID != client.Address {
+		msg.Printf("🪪 %s%s is %s\n", prefix, client.Name, client.ID)
+	}
+}
+
+// scanDevice scans for a device by name, printing what is seen. It exits
+// the process if the device isn't found or ctx is cancelled first.
+func scanDevice(ctx context.Context, name string, timeout time.Duration) esp32.ScanResult {
+	msg.Printf("🔍 Scanning for Bluetooth device: \"%s\"\n", name)
+	msg.Printf("⏱️  Timeout: %d seconds\n\n", int(timeout.Seconds()))
+
+	result, err := esp32.FindDevice(ctx, adapter, name, timeout, printScanResult)
+	if ctx.Err() != nil {
+		msg.Println("\n🛑 Interrupted")
+		os.Exit(1)
+	}
+	if errors.Is(err, esp32.ErrDeviceNotFound) {
+		msg.Printf("\n⏱️  Timeout: Device \"%s\" not found after %d seconds\n", name, int(timeout.Seconds()))
+		os.Exit(1)
+	}
+	if err != nil {
+		msg.Printf("❌ %v\n", err)
+		os.Exit(1)
+	}
+
+	msg.Printf("\n✅ Found target device: %s\n", result.Name)
+	msg.Printf("📍 Address: %s\n", result.Address)
+	msg.Printf("📶 Signal strength: %d dBm\n\n", result.RSSI)
+	return result
+}
+
+// parsePHYFlag parses a --phy value, exiting on error. Empty means no PHY
+// was requested.
+func parsePHYFlag(s string) esp32.PHY {
+	if s == "" {
+		return 0
+	}
+	phy, err := esp32.ParsePHY(s)
+	if err != nil {
+		msg.Printf("❌ %v\n", err)
+		os.Exit(1)
+	}
+	return phy
+}
+
+// requestPHY asks for phy on a new connection, if one was requested, and
+// reports the outcome. Failing isn't fatal: the link stays on the PHY it
+// has.
+func requestPHY(ctx context.Context, prefix string, client *esp32.Client, phy esp32.PHY) {
+	if phy == 0 {
+		return
+	}
+	tx, rx, err := client.SetPHY(ctx, phy)
+	if err != nil {
+		msg.Printf("⚠️  %sCould not switch to the %s PHY: %v\n", prefix, phy, err)
+		return
+	}
+	msg.Printf("📡 %sPHY: tx %s, rx %s\n", prefix, tx, rx)
+}
+
+// printScanResult prints a discovered device, for visibility while
+// scanning.
+func printScanResult(result esp32.ScanResult) {
+	if result.Name != "" {
+		msg.Printf("📱 Found: %s (Address: %s, RSSI: %d dBm)\n",
+			result.Name, result.Address, result.RSSI)
+	}
+}
+
+// printServices prints what service discovery found on a client.
+func printServices(client *esp32.Client) {
+	msg.Printf("📋 Found %d service(s)\n\n", len(client.Services))
+	msg.Println("🔍 Discovering all characteristics...")
+	for _, service := range client.Services {
+		if service.Err != nil {
+			msg.Printf("⚠️  DiscoverCharacteristics error for service %s: %v\n", service.UUID, service.Err)
+			continue
+		}
+		msg.Printf("   Service %s: %d characteristic(s)\n", service.UUID, len(service.Characteristics))
+		for _, uuid := range service.Characteristics {
+			msg.Printf("      - %s\n", uuid)
+		}
+	}
 }
@@ -0,0 +1,3195 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	crand "crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"html"
+	"io"
+	"maps"
+	"math/big"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+	"unicode"
+
+	"github.com/gorilla/websocket"
+
+	"bluetooth/api"
+	"bluetooth/bridge"
+	"bluetooth/esp32"
+	"bluetooth/esp32/mock"
+	"bluetooth/locale"
+)
+
+// TestMain re-executes the test binary as the CLI when ESP32_TEST_MAIN is
+// set, with the adapter swapped for the emulator. This lets tests run the
+// real flows, including their os.Exit paths, as a subprocess. A board left
+// connected when main returns fails the run.
+func TestMain(m *testing.M) {
+	if os.Getenv("ESP32_TEST_MAIN") == "1" {
+		board := mock.NewBoard("esp32-test", "AA:BB:CC:DD:EE:01")
+		board.SetADC(35, 1234)
+		board.SetADC(32, 4095)
+		second := mock.NewBoard("esp32-two", "AA:BB:CC:DD:EE:02")
+		second.SetADC(35, 42)
+		adapter = mock.NewAdapter(board, second)
+		// --transport serial reaches the first board over a pipe.
+		var serial sync.WaitGroup
+		openSerial = func(port string, baud int) (io.ReadWriteCloser, error) {
+			host, dev := net.Pipe()
+			serial.Add(1)
+			go func() {
+				defer serial.Done()
+				board.ServeSerial(dev)
+			}()
+			return host, nil
+		}
+		if os.Getenv("ESP32_TEST_OTA") == "1" {
+			board.EnableOTA(otaDataUUID, otaControlUUID)
+		}
+		if c := os.Getenv("ESP32_TEST_DOWNLOAD"); c != "" {
+			compression, _ := esp32.ParseCompression(c)
+			board.EnableDownload(downloadDataUUID, downloadControlUUID)
+			board.SetDownload(bytes.Repeat([]byte("0,35,1234\n"), 400), compression)
+			if os.Getenv("ESP32_TEST_DOWNLOAD_DROP") == "1" {
+				board.DropDownloadFrames(3)
+			}
+		}
+		if os.Getenv("ESP32_TEST_SCHEDULE") == "1" {
+			board.EnableSchedule(scheduleUUID, time.Minute)
+			second.EnableSchedule(scheduleUUID, time.Hour)
+		}
+		if serial := os.Getenv("ESP32_TEST_SERIAL"); serial != "" {
+			board.EnableSerialNumber(esp32.SerialNumberUUID, []byte(serial))
+		}
+		if os.Getenv("ESP32_TEST_SELFTEST") == "1" {
+			board.EnableSelfTest(selfTestUUID)
+			if test, err := esp32.ParseSelfTest(os.Getenv("ESP32_TEST_SELFTEST_FAIL")); err == nil {
+				board.SetSelfTestResult(test, esp32.SelfTestFailed, "stuck low")
+			}
+		}
+		if n, err := strconv.Atoi(os.Getenv("ESP32_TEST_DISCONNECT")); err == nil {
+			if os.Getenv("ESP32_TEST_OTA") == "1" {
+				board.DisconnectDuringOTA(n)
+			}
+			if os.Getenv("ESP32_TEST_DOWNLOAD") != "" {
+				board.DisconnectDuringDownload(n)
+			}
+		}
+		if passkey := os.Getenv("ESP32_TEST_PASSKEY"); passkey != "" {
+			n, _ := strconv.ParseUint(passkey, 10, 32)
+			board.RequirePairing(mock.Security{Passkey: uint32(n), Compare: os.Getenv("ESP32_TEST_COMPARE") == "1"})
+		}
+		if rate, err := strconv.ParseFloat(os.Getenv("ESP32_TEST_DROP_RATE"), 64); err == nil {
+			board.InjectFaults(mock.Faults{Disconnect: rate}, rand.New(rand.NewSource(1)))
+		}
+		if n, err := strconv.Atoi(os.Getenv("ESP32_TEST_LOSE_WRITES")); err == nil {
+			board.LoseWrites(n)
+		}
+		if os.Getenv("ESP32_TEST_NOTIFY") == "1" {
+			go func() {
+				for range time.Tick(10 * time.Millisecond) {
+					board.Notify()
+				}
+			}()
+		}
+		if os.Getenv("ESP32_TEST_DOOR") == "1" {
+			// Pin 14 opens for 200ms, like a door being walked through.
+			go func() {
+				time.Sleep(100 * time.Millisecond)
+				board.SetPin(14, 1)
+				time.Sleep(200 * time.Millisecond)
+				board.SetPin(14, 0)
+			}()
+		}
+		if os.Getenv("ESP32_TEST_MOTION") == "1" {
+			// PIRs on pins 26 and 33 see someone walk past.
+			go func() {
+				time.Sleep(100 * time.Millisecond)
+				board.SetPin(26, 1)
+				board.SetPin(33, 1)
+				time.Sleep(50 * time.Millisecond)
+				board.SetPin(26, 0)
+				board.SetPin(33, 0)
+			}()
+		}
+		if os.Getenv("ESP32_TEST_CLIMATE") == "1" {
+			// ADC 35 falls, like a thermistor warming or the air drying.
+			go func() {
+				time.Sleep(100 * time.Millisecond)
+				board.SetADC(35, 600)
+			}()
+		}
+		if os.Getenv("ESP32_TEST_BENCH") == "1" {
+			board.EnableBench(benchUUID)
+			go streamBench(board)
+		}
+		main()
+		serial.Wait()
+		if os.Getenv("ESP32_TEST_OTA") == "1" {
+			image, err := board.OTAImage()
+			fmt.Printf("mock: %d-byte image, err %v\n", len(image), err)
+		}
+		for _, b := range []*mock.Board{board, second} {
+			if b.Connected() {
+				fmt.Printf("❌ %s left connected\n", b.Name)
+				os.Exit(2)
+			}
+		}
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// Characteristic UUIDs the emulated board exposes for OTA when
+// ESP32_TEST_OTA is set.
+const (
+	otaDataUUID    = "5a1d0000-0000-4000-8000-000000000001"
+	otaControlUUID = "5a1d0000-0000-4000-8000-000000000002"
+)
+
+// Characteristic UUIDs the emulated board exposes for bulk transfers
+// when ESP32_TEST_DOWNLOAD is set to a compression.
+const (
+	downloadDataUUID    = "5a1d0000-0000-4000-8000-000000000011"
+	downloadControlUUID = "5a1d0000-0000-4000-8000-000000000012"
+)
+
+// selfTestUUID is the self-test characteristic the emulated board
+// exposes when ESP32_TEST_SELFTEST is set.
+const selfTestUUID = "5a1d0000-0000-4000-8000-000000000021"
+
+// scheduleUUID is the schedule characteristic both emulated boards
+// expose when ESP32_TEST_SCHEDULE is set.
+const scheduleUUID = "5a1d0000-0000-4000-8000-000000000031"
+
+// benchUUID is the test characteristic the emulated board exposes when
+// ESP32_TEST_BENCH is set.
+const benchUUID = "5a1d0000-0000-4000-8000-000000000003"
+
+// streamBench sends bench frames every millisecond while anyone is
+// subscribed, losing frame 5.
+func streamBench(board *mock.Board) {
+	var seq uint32
+	for range time.Tick(time.Millisecond) {
+		if board.Subscribers() == 0 {
+			continue
+		}
+		if seq != 5 {
+			board.SendBench(seq)
+		}
+		seq++
+	}
+}
+
+// runCLI runs the CLI against the emulator and returns its combined output
+// and whether it exited successfully.
+func runCLI(t *testing.T, args ...string) (string, bool) {
+	t.Helper()
+	return runCLIInput(t, "", args...)
+}
+
+// runCLIInput is runCLI with stdin fed from input.
+func runCLIInput(t *testing.T, input string, args ...string) (string, bool) {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], args...)
+	cmd.Stdin = strings.NewReader(input)
+	cmd.Env = append(os.Environ(), "ESP32_TEST_MAIN=1")
+	out, err := cmd.CombinedOutput()
+	if _, ok := err.(*exec.ExitError); err != nil && !ok {
+		t.Fatalf("running CLI: %v", err)
+	}
+	return string(out), err == nil
+}
+
+func wantOutput(t *testing.T, out string, want ...string) {
+	t.Helper()
+	for _, w := range want {
+		if !strings.Contains(out, w) {
+			t.Errorf("output missing %q\n%s", w, out)
+		}
+	}
+}
+
+func TestReadADC(t *testing.T) {
+	out, ok := runCLI(t, "--name", "ESP32-TEST", "--timeout", "5")
+	if !ok {
+		t.Fatalf("CLI failed:\n%s", out)
+	}
+	wantOutput(t, out,
+		"✅ Found target device: esp32-test",
+		"✅ Successfully connected to esp32-test!",
+		"- 01037594-1bbb-4490-aa4d-f6d333b42e16",
+		"✅ Pin: 35, Value: 1234",
+		"✅ Pin: 32, Value: 4095",
+	)
+}
+
+func TestReadADCLogFile(t *testing.T) {
+	logFile := filepath.Join(t.TempDir(), "readings.csv")
+	for i := 0; i < 2; i++ {
+		if out, ok := runCLI(t, "--name", "esp32-test", "--log-file", logFile); !ok {
+			t.Fatalf("CLI failed:\n%s", out)
+		}
+	}
+
+	data, err := os.ReadFile(logFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 5 {
+		t.Fatalf("got %d lines, want header plus 4 readings:\n%s", len(lines), data)
+	}
+	if lines[0] != "timestamp,device,address,id,pin,value" {
+		t.Errorf("header = %q", lines[0])
+	}
+	if !strings.HasSuffix(lines[1], ",esp32-test,AA:BB:CC:DD:EE:01,AA:BB:CC:DD:EE:01,35,1234") || !strings.HasSuffix(lines[4], ",32,4095") {
+		t.Errorf("unexpected rows:\n%s", data)
+	}
+}
+
+func TestReadADCMultiDevice(t *testing.T) {
+	logFile := filepath.Join(t.TempDir(), "readings.csv")
+	out, ok := runCLI(t, "--name", "esp32-test", "--name", "esp32-two", "--log-file", logFile)
+	if !ok {
+		t.Fatalf("CLI failed:\n%s", out)
+	}
+	wantOutput(t, out,
+		"✅ [esp32-test] Connected (Address: AA:BB:CC:DD:EE:01",
+		"✅ [esp32-two] Connected (Address: AA:BB:CC:DD:EE:02",
+		"✅ [esp32-test] Pin: 35, Value: 1234",
+		"✅ [esp32-two] Pin: 35, Value: 42",
+	)
+
+	data, err := os.ReadFile(logFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantOutput(t, string(data), ",esp32-test,AA:BB:CC:DD:EE:01,AA:BB:CC:DD:EE:01,35,1234\n", ",esp32-two,AA:BB:CC:DD:EE:02,AA:BB:CC:DD:EE:02,35,42\n")
+}
+
+func TestMultiDeviceMissing(t *testing.T) {
+	out, ok := runCLI(t, "--name", "esp32-test", "--name", "missing", "--timeout", "1")
+	if ok {
+		t.Fatalf("CLI succeeded, want failure:\n%s", out)
+	}
+	wantOutput(t, out, `Device(s) ["missing"] not found after 1 seconds`)
+}
+
+func TestREPL(t *testing.T) {
+	input := "read adc\nwrite 14 100\nread pins\nmtu\nstats\nbogus\nquit\n"
+	out, ok := runCLIInput(t, input, "--name", "esp32-test", "--repl")
+	if !ok {
+		t.Fatalf("CLI failed:\n%s", out)
+	}
+	wantOutput(t, out,
+		"✅ Pin: 35, Value: 1234",
+		"✅ Wrote 1 pin(s)",
+		"✅ Pin: 14, Value: 100",
+		"📏 MTU: 247 bytes",
+		"📊 0 notification(s)",
+		"01037594-1bbb-4490-aa4d-f6d333b42e16: 1 read(s) avg 32.0 B, 0 write(s)",
+		`❌ unknown command "bogus"`,
+		"👋 Done!",
+	)
+}
+
+func TestREPLReadMaxAge(t *testing.T) {
+	config := writeConfig(t, `
+profiles:
+  lab:
+    name: esp32-test
+    read_max_age:
+      01037594-1bbb-4490-aa4d-f6d333b42e16: 1m
+`)
+	// Only the first and the --no-cache reads reach the board.
+	input := "read adc\nread adc\nread adc --no-cache\nread adc --max-age 1m\nread pins --max-age soon\nstats\nquit\n"
+	out, ok := runCLIInput(t, input, "--config", config, "--profile", "lab", "--repl")
+	if !ok {
+		t.Fatalf("CLI failed:\n%s", out)
+	}
+	wantOutput(t, out,
+		"✅ Pin: 35, Value: 1234",
+		`❌ invalid max age "soon"`,
+		"01037594-1bbb-4490-aa4d-f6d333b42e16: 2 read(s)",
+	)
+}
+
+func TestREPLChannels(t *testing.T) {
+	config := writeConfig(t, `
+profiles:
+  lab:
+    name: esp32-test
+    channels:
+      light-level: 35
+      vent-fan: 14
+`)
+	input := "read adc\nwrite vent-fan 100\nexpect pin vent-fan == 100\nwrite heater 1\nquit\n"
+	out, ok := runCLIInput(t, input, "--config", config, "--profile", "lab", "--repl")
+	if !ok {
+		t.Fatalf("CLI failed:\n%s", out)
+	}
+	wantOutput(t, out,
+		"✅ Pin: 35 (light-level), Value: 1234",
+		"✅ Wrote 1 pin(s)",
+		"✅ pin 14 = 100",
+		`❌ invalid pin "heater"`,
+	)
+}
+
+func TestSerialTransport(t *testing.T) {
+	input := "read adc\nwrite 14 100\nread pins\nmtu\nquit\n"
+	out, ok := runCLIInput(t, input, "--transport", "serial", "--port", "/dev/ttyUSB0", "--repl")
+	if !ok {
+		t.Fatalf("CLI failed:\n%s", out)
+	}
+	wantOutput(t, out,
+		"🔌 Using serial port /dev/ttyUSB0 at 115200 baud instead of Bluetooth",
+		"✅ Found target device: esp32-test",
+		"✅ Pin: 35, Value: 1234",
+		"✅ Wrote 1 pin(s)",
+		"✅ Pin: 14, Value: 100",
+		"📏 MTU: 512 bytes",
+	)
+
+	out, ok = runCLI(t, "--name", "esp32-test", "--port", "/dev/ttyUSB0")
+	if ok {
+		t.Fatalf("CLI succeeded with --port but no --transport:\n%s", out)
+	}
+	wantOutput(t, out, "❌ --port needs --transport serial")
+}
+
+func TestScript(t *testing.T) {
+	script := "# bring-up\nwrite 14 100\nsleep 10ms\nexpect pin 14 == 100\nexpect adc 35 > 1000\nexpect pin 14 == 0\nexpect adc 99 > 0\n"
+	out, ok := runCLIInput(t, script, "--name", "esp32-test", "--script", "-")
+	if ok {
+		t.Fatalf("CLI succeeded with failing expectations:\n%s", out)
+	}
+	wantOutput(t, out,
+		"▶️  [2] write 14 100\n✅ Wrote 1 pin(s)",
+		"✅ pin 14 = 100",
+		"✅ adc 35 = 1234",
+		"📋 Script: 4 passed, 2 failed",
+		"   line 6: expect pin 14 == 0: expected pin 14 == 0, got 100",
+		"   line 7: expect adc 99 > 0: expected adc 99 > 0, but the board doesn't report it",
+	)
+
+	path := filepath.Join(t.TempDir(), "script.txt")
+	if err := os.WriteFile(path, []byte("write 14 100\nexpect pin 14 == 100\nquit\nbogus\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	out, ok = runCLI(t, "--name", "esp32-test", "--script", path)
+	if !ok {
+		t.Fatalf("CLI failed:\n%s", out)
+	}
+	wantOutput(t, out, "📋 Script: 3 passed, 0 failed")
+}
+
+func TestExplore(t *testing.T) {
+	out, ok := runCLI(t, "explore", "--name", "esp32-test")
+	if !ok {
+		t.Fatalf("CLI failed:\n%s", out)
+	}
+	wantOutput(t, out,
+		"📦 Service a9c81b72-0f7a-4c59-b0a8-425e3bcf0a0e",
+		"Properties: read, write, write-without-response",
+		"CCCD:       true",
+		"Value:      0223",
+	)
+}
+
+func TestExploreJSON(t *testing.T) {
+	cmd := exec.Command(os.Args[0], "explore", "--name", "esp32-test", "--json")
+	cmd.Env = append(os.Environ(), "ESP32_TEST_MAIN=1")
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("CLI failed: %v", err)
+	}
+	var device esp32.Inspection
+	if err := json.Unmarshal(out, &device); err != nil {
+		t.Fatalf("invalid JSON: %v\n%s", err, out)
+	}
+	if len(device.Services) != 1 || len(device.Services[0].Characteristics) != 3 {
+		t.Fatalf("unexpected tree: %+v", device)
+	}
+	if c := device.Services[0].Characteristics[1]; c.UUID != "01037594-1bbb-4490-aa4d-f6d333b42e16" || !c.CCCD {
+		t.Errorf("ADC characteristic = %+v", c)
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	out, ok := runCLI(t, "snapshot", "--name", "esp32-test")
+	if !ok {
+		t.Fatalf("CLI failed:\n%s", out)
+	}
+	wantOutput(t, out, "📸 esp32-test (AA:BB:CC:DD:EE:01)", "ADC 35 = 1234")
+
+	cmd := exec.Command(os.Args[0], "snapshot", "--name", "esp32-test", "--json")
+	cmd.Env = append(os.Environ(), "ESP32_TEST_MAIN=1")
+	data, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("CLI failed: %v", err)
+	}
+	var snapshot esp32.Snapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		t.Fatalf("invalid JSON: %v\n%s", err, data)
+	}
+	if len(snapshot.ADC) != 2 || snapshot.ADC[0].Pin != 35 || snapshot.ADC[0].Value != 1234 || snapshot.Address != "AA:BB:CC:DD:EE:01" {
+		t.Errorf("snapshot = %+v", snapshot)
+	}
+}
+
+func TestWrite(t *testing.T) {
+	out, ok := runCLI(t, "write", "--name", "esp32-test", "--pin", "14=100", "--pin", "25=1")
+	if !ok {
+		t.Fatalf("CLI failed:\n%s", out)
+	}
+	wantOutput(t, out, "✅ Wrote 2 pin(s)")
+
+	file := filepath.Join(t.TempDir(), "writes.json")
+	if err := os.WriteFile(file, []byte(`{"pin_writes":[{"pin_num":14,"state":100}]}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	out, ok = runCLI(t, "write", "--name", "esp32-test", "--json", "@"+file)
+	if !ok {
+		t.Fatalf("CLI failed:\n%s", out)
+	}
+	wantOutput(t, out, "✅ Wrote 1 pin(s)")
+	out, ok = runCLIInput(t, `{"pin_writes":[{"pin_num":26,"state":1}]}`, "write", "--name", "esp32-test", "--json", "-")
+	if !ok {
+		t.Fatalf("CLI failed:\n%s", out)
+	}
+	wantOutput(t, out, "✅ Wrote 1 pin(s)")
+
+	// A document the firmware would drop is refused before scanning.
+	out, ok = runCLI(t, "write", "--name", "esp32-test", "--json", `{"pin_writes":[{"pin":14,"state":100}]}`)
+	if ok {
+		t.Fatalf("CLI succeeded, want failure:\n%s", out)
+	}
+	wantOutput(t, out, `❌ invalid pin_writes JSON: json: unknown field "pin"`)
+	if strings.Contains(out, "Scanning") {
+		t.Errorf("scanned before checking the JSON:\n%s", out)
+	}
+}
+
+func TestScanTimeout(t *testing.T) {
+	out, ok := runCLI(t, "--name", "missing", "--timeout", "1")
+	if ok {
+		t.Fatalf("CLI succeeded, want failure:\n%s", out)
+	}
+	wantOutput(t, out, "📱 Found: esp32-test", `Device "missing" not found after 1 seconds`)
+}
+
+func TestMissingName(t *testing.T) {
+	out, ok := runCLI(t)
+	if ok {
+		t.Fatalf("CLI succeeded, want failure:\n%s", out)
+	}
+	wantOutput(t, out, "Error: --name flag is required")
+}
+
+func TestRulesSimulate(t *testing.T) {
+	capture := filepath.Join(t.TempDir(), "capture.csv")
+	err := os.WriteFile(capture, []byte(`timestamp,pin,value
+2026-01-01T00:00:00Z,34,100
+2026-01-01T00:00:05Z,34,3500
+2026-01-01T00:00:10Z,34,3600
+2026-01-01T00:00:20Z,34,3700
+2026-01-01T00:00:25Z,34,10
+2026-01-01T00:00:30Z,34,3100
+`), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out, ok := runCLI(t, "rules", "simulate", "--input", capture,
+		"--rule", "pin34>3000", "--rule", "pin34>3000 for 10s")
+	if !ok {
+		t.Fatalf("CLI failed:\n%s", out)
+	}
+	wantOutput(t, out,
+		"Replaying 6 reading(s) through 2 rule(s)",
+		"00:00:20.000  pin34>3000 for 10s",
+		"pin34>3000                     fired 2 time(s)",
+		"pin34>3000 for 10s             fired 1 time(s)",
+	)
+}
+
+func TestSoak(t *testing.T) {
+	out, ok := runCLI(t, "soak", "--duration", "1s", "--seed", "1",
+		"--stall", "10ms", "--disconnect-rate", "0.05", "--malformed-rate", "0.2")
+	if !ok {
+		t.Fatalf("soak failed:\n%s", out)
+	}
+	wantOutput(t, out, "Deadlocks:          0", "Leaks:              0", "Failsafe missed:    0", "Unverified writes:  0", "✅ Soak test passed")
+}
+
+func TestPollInterrupt(t *testing.T) {
+	cmd := exec.Command(os.Args[0], "--name", "esp32-test", "--poll", "50ms")
+	cmd.Env = append(os.Environ(), "ESP32_TEST_MAIN=1")
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Interrupt once a reading has been printed.
+	var out bytes.Buffer
+	scanner := bufio.NewScanner(stdout)
+	for scanner.Scan() {
+		fmt.Fprintln(&out, scanner.Text())
+		if strings.Contains(scanner.Text(), "Pin: 35") {
+			break
+		}
+	}
+	cmd.Process.Signal(syscall.SIGINT)
+	done := make(chan error, 1)
+	go func() {
+		for scanner.Scan() {
+			fmt.Fprintln(&out, scanner.Text())
+		}
+		done <- cmd.Wait()
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("CLI failed after interrupt: %v\n%s", err, out.String())
+		}
+	case <-time.After(5 * time.Second):
+		cmd.Process.Kill()
+		t.Fatalf("CLI did not exit after interrupt\n%s", out.String())
+	}
+	wantOutput(t, out.String(), "🔌 Disconnected")
+}
+
+func TestMinRSSI(t *testing.T) {
+	// The emulated boards advertise at -50 dBm.
+	out, ok := runCLI(t, "--name", "esp32-test", "--timeout", "1", "--min-rssi", "-40")
+	if ok {
+		t.Fatalf("CLI connected to a board below --min-rssi:\n%s", out)
+	}
+	wantOutput(t, out, `Device "esp32-test" not found`)
+}
+
+func TestScanFlags(t *testing.T) {
+	out, ok := runCLI(t, "list", "--window", "100ms", "--scan-mode", "passive", "--scan-interval", "1s", "--scan-window", "1s", "--scan-dedupe")
+	if !ok {
+		t.Fatalf("CLI failed:\n%s", out)
+	}
+	wantOutput(t, out, "📡 Scanning passively, listening 1s every 1s", "📋 2 device(s)")
+
+	for _, tc := range []struct {
+		args []string
+		want string
+	}{
+		{[]string{"--scan-mode", "sideways"}, `❌ Unknown scan mode "sideways" (want active or passive)`},
+		{[]string{"--scan-interval", "100ms", "--scan-window", "200ms"}, "❌ Failed to set scan parameters: scan window 200ms is longer than the interval 100ms"},
+		{[]string{"--scan-interval", "1ms"}, "❌ Failed to set scan parameters: scan interval 1ms out of range (2.5ms to 10.24s)"},
+	} {
+		out, ok := runCLI(t, append([]string{"list", "--window", "100ms"}, tc.args...)...)
+		if ok {
+			t.Errorf("list %v succeeded:\n%s", tc.args, out)
+		}
+		wantOutput(t, out, tc.want)
+	}
+}
+
+func TestMonitorRSSILogFile(t *testing.T) {
+	logFile := filepath.Join(t.TempDir(), "rssi.csv")
+	cmd := exec.Command(os.Args[0], "monitor-rssi", "--name", "esp32-test", "--interval", "10ms", "--log-file", logFile)
+	cmd.Env = append(os.Environ(), "ESP32_TEST_MAIN=1")
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(500 * time.Millisecond)
+	cmd.Process.Signal(syscall.SIGINT)
+	if err := cmd.Wait(); err != nil {
+		t.Fatalf("CLI failed: %v", err)
+	}
+
+	data, err := os.ReadFile(logFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if lines[0] != "timestamp,device,address,id,kind,pin,value" || len(lines) < 2 {
+		t.Fatalf("unexpected log:\n%s", data)
+	}
+	if !strings.HasSuffix(lines[1], ",esp32-test,AA:BB:CC:DD:EE:01,AA:BB:CC:DD:EE:01,rssi,0,-50") {
+		t.Errorf("unexpected row %q", lines[1])
+	}
+}
+
+func TestPHYUnsupported(t *testing.T) {
+	// The emulated board, like the original ESP32, only has the 1M PHY.
+	out, ok := runCLI(t, "--name", "esp32-test", "--phy", "2m")
+	if !ok {
+		t.Fatalf("CLI failed:\n%s", out)
+	}
+	wantOutput(t, out,
+		"⚠️  Could not switch to the 2M PHY",
+		"✅ Pin: 35, Value: 1234",
+	)
+
+	if out, _ := runCLI(t, "--name", "esp32-test", "--phy", "1m"); !strings.Contains(out, "📡 PHY: tx 1M, rx 1M") {
+		t.Errorf("output missing PHY report:\n%s", out)
+	}
+}
+
+func TestOTA(t *testing.T) {
+	image := filepath.Join(t.TempDir(), "firmware.bin")
+	if err := os.WriteFile(image, bytes.Repeat([]byte{0xE9, 0x01}, 2000), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command(os.Args[0], "ota", "--name", "esp32-test", "--file", image,
+		"--data-uuid", otaDataUUID, "--control-uuid", otaControlUUID)
+	cmd.Env = append(os.Environ(), "ESP32_TEST_MAIN=1", "ESP32_TEST_OTA=1")
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("CLI failed: %v\n%s", err, out)
+	}
+	wantOutput(t, string(out),
+		"📦 Uploading",
+		"100% (4000/4000 bytes)",
+		"✅ Uploaded 4000 bytes",
+		"mock: 4000-byte image, err <nil>",
+	)
+}
+
+func TestDownload(t *testing.T) {
+	for _, tc := range []struct {
+		compression, accept, drop, want string
+	}{
+		{"heatshrink", "zlib,heatshrink", "", "-compressed"},
+		{"zlib", "none", "1", "(uncompressed, 1 lost frame(s) resent)"},
+	} {
+		t.Run(tc.compression, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "capture.csv")
+			cmd := exec.Command(os.Args[0], "download", "--name", "esp32-test", "--out", path,
+				"--data-uuid", downloadDataUUID, "--control-uuid", downloadControlUUID, "--compression", tc.accept)
+			cmd.Env = append(os.Environ(), "ESP32_TEST_MAIN=1", "ESP32_TEST_DOWNLOAD="+tc.compression, "ESP32_TEST_DOWNLOAD_DROP="+tc.drop)
+			out, err := cmd.CombinedOutput()
+			if err != nil {
+				t.Fatalf("CLI failed: %v\n%s", err, out)
+			}
+			wantOutput(t, string(out), "📥 Downloading", "✅ Downloaded 4000 bytes", tc.want, "length and CRC32 verified")
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(data, bytes.Repeat([]byte("0,35,1234\n"), 400)) {
+				t.Error("downloaded file differs from the board's data")
+			}
+		})
+	}
+
+	if out, ok := runCLI(t, "download", "--name", "esp32-test", "--out", "x", "--data-uuid", downloadDataUUID,
+		"--control-uuid", downloadControlUUID, "--compression", "lz4"); ok || !strings.Contains(out, `unknown compression "lz4"`) {
+		t.Errorf("CLI accepted an unknown compression:\n%s", out)
+	}
+}
+
+func TestOTAProgress(t *testing.T) {
+	image := filepath.Join(t.TempDir(), "firmware.bin")
+	if err := os.WriteFile(image, bytes.Repeat([]byte{0xE9, 0x01}, 2000), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	args := []string{"ota", "--name", "esp32-test", "--file", image, "--data-uuid", otaDataUUID, "--control-uuid", otaControlUUID}
+	cmd := exec.Command(os.Args[0], args...)
+	cmd.Env = append(os.Environ(), "ESP32_TEST_MAIN=1", "ESP32_TEST_OTA=1", "ESP32_TEST_DISCONNECT=3")
+	if out, err := cmd.CombinedOutput(); err == nil {
+		t.Fatalf("CLI succeeded over a dropped link:\n%s", out)
+	}
+	var saved struct {
+		ChunkSize int `json:"chunk_size"`
+		Sent      int `json:"sent"`
+	}
+	b, err := os.ReadFile(image + ".progress")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(b, &saved); err != nil || saved.ChunkSize == 0 || saved.Sent != 3*saved.ChunkSize {
+		t.Fatalf("saved progress %s, want 3 chunks sent", b)
+	}
+
+	// This mock board doesn't outlive the process, so its partial image is
+	// gone and the upload starts over.
+	cmd = exec.Command(os.Args[0], args...)
+	cmd.Env = append(os.Environ(), "ESP32_TEST_MAIN=1", "ESP32_TEST_OTA=1")
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("CLI failed: %v\n%s", err, out)
+	}
+	wantOutput(t, string(out), "✅ Uploaded 4000 bytes", "mock: 4000-byte image, err <nil>")
+	if strings.Contains(string(out), "Resuming") {
+		t.Errorf("CLI resumed an upload the board doesn't have:\n%s", out)
+	}
+	if _, err := os.Stat(image + ".progress"); !os.IsNotExist(err) {
+		t.Errorf("progress file left after the upload: %v", err)
+	}
+}
+
+func TestProgressJSON(t *testing.T) {
+	image := filepath.Join(t.TempDir(), "firmware.bin")
+	if err := os.WriteFile(image, bytes.Repeat([]byte{0xE9, 0x01}, 2000), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command(os.Args[0], "ota", "--name", "esp32-test", "--file", image,
+		"--data-uuid", otaDataUUID, "--control-uuid", otaControlUUID, "--progress", "json")
+	cmd.Env = append(os.Environ(), "ESP32_TEST_MAIN=1", "ESP32_TEST_OTA=1")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("CLI failed: %v\n%s%s", err, out, stderr.Bytes())
+	}
+	if strings.Contains(string(out), "█") {
+		t.Errorf("CLI drew a bar as well:\n%s", out)
+	}
+
+	var events []progressEvent
+	dec := json.NewDecoder(&stderr)
+	for dec.More() {
+		var e progressEvent
+		if err := dec.Decode(&e); err != nil {
+			t.Fatalf("invalid event: %v", err)
+		}
+		events = append(events, e)
+	}
+	if len(events) < 3 {
+		t.Fatalf("got %d event(s), want progress and done: %+v", len(events), events)
+	}
+	for i, e := range events[:len(events)-1] {
+		if e.Op != "ota" || e.Event != "progress" || e.Total != 4000 || (i > 0 && e.Bytes <= events[i-1].Bytes) {
+			t.Errorf("event %d = %+v", i, e)
+		}
+	}
+	if last := events[len(events)-1]; last.Event != "done" || last.Bytes != 4000 || last.Percent != 100 {
+		t.Errorf("last event = %+v, want done at 100%%", last)
+	}
+	if events[len(events)-2].ETA == nil {
+		t.Error("progress events have no ETA")
+	}
+}
+
+func TestDownloadResume(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "capture.csv")
+	args := []string{"download", "--name", "esp32-test", "--out", path, "--data-uuid", downloadDataUUID,
+		"--control-uuid", downloadControlUUID, "--compression", "none"}
+	cmd := exec.Command(os.Args[0], args...)
+	cmd.Env = append(os.Environ(), "ESP32_TEST_MAIN=1", "ESP32_TEST_DOWNLOAD=none", "ESP32_TEST_DISCONNECT=10")
+	if out, err := cmd.CombinedOutput(); err == nil {
+		t.Fatalf("CLI succeeded over a dropped link:\n%s", out)
+	}
+
+	cmd = exec.Command(os.Args[0], args...)
+	cmd.Env = append(os.Environ(), "ESP32_TEST_MAIN=1", "ESP32_TEST_DOWNLOAD=none")
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("CLI failed: %v\n%s", err, out)
+	}
+	wantOutput(t, string(out), "⏯️  Resuming download after", "✅ Downloaded 4000 bytes", "bytes resumed")
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, bytes.Repeat([]byte("0,35,1234\n"), 400)) {
+		t.Error("resumed download differs from the board's data")
+	}
+	for _, leftover := range []string{path + ".progress", path + ".part"} {
+		if _, err := os.Stat(leftover); !os.IsNotExist(err) {
+			t.Errorf("%s left after the download: %v", leftover, err)
+		}
+	}
+}
+
+func TestSelfTest(t *testing.T) {
+	for _, tc := range []struct {
+		name, fail string
+		ok         bool
+		want       []string
+	}{
+		{"pass", "", true, []string{"🧪 Running self-test", "✅ gpio-loopback  passed: 4 pins read back", "✅ Self-test passed (3 passed, 0 failed, 0 skipped"}},
+		{"fail", "gpio-loopback", false, []string{"❌ gpio-loopback  failed: stuck low", "✅ memory", "❌ Self-test FAILED (2 passed, 1 failed, 0 skipped"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cmd := exec.Command(os.Args[0], "selftest", "--name", "esp32-test", "--uuid", selfTestUUID)
+			cmd.Env = append(os.Environ(), "ESP32_TEST_MAIN=1", "ESP32_TEST_SELFTEST=1", "ESP32_TEST_SELFTEST_FAIL="+tc.fail)
+			out, err := cmd.CombinedOutput()
+			if (err == nil) != tc.ok {
+				t.Fatalf("CLI error %v, want success %v:\n%s", err, tc.ok, out)
+			}
+			wantOutput(t, string(out), tc.want...)
+		})
+	}
+
+	if out, ok := runCLI(t, "selftest", "--name", "esp32-test", "--uuid", selfTestUUID, "--tests", "flash"); ok || !strings.Contains(out, `unknown self-test "flash"`) {
+		t.Errorf("CLI accepted an unknown self-test:\n%s", out)
+	}
+}
+
+func TestConformance(t *testing.T) {
+	cmd := exec.Command(os.Args[0], "conformance", "--name", "esp32-test", "--write-pins", "14", "--selftest-uuid", selfTestUUID, "--wait", "500ms")
+	cmd.Env = append(os.Environ(), "ESP32_TEST_MAIN=1", "ESP32_TEST_NOTIFY=1", "ESP32_TEST_SELFTEST=1", "ESP32_TEST_SELFTEST_FAIL=memory")
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("CLI failed: %v\n%s", err, out)
+	}
+	wantOutput(t, string(out), "📋 Checking protocol conformance", "✅ adc-output notify", "✅ pin-input write 14     passed: wrote 100, restored 0",
+		"✅ self-test", "✅ Conformance passed (9 passed, 0 failed, 0 skipped")
+
+	// Without notifications the board doesn't do what it claims.
+	cmd = exec.Command(os.Args[0], "conformance", "--name", "esp32-test", "--wait", "100ms", "--json")
+	cmd.Env = append(os.Environ(), "ESP32_TEST_MAIN=1")
+	data, err := cmd.Output()
+	if err == nil {
+		t.Fatalf("CLI succeeded, want failure:\n%s", data)
+	}
+	var report esp32.ConformanceReport
+	if err := json.Unmarshal(data, &report); err != nil {
+		t.Fatalf("invalid JSON: %v\n%s", err, data)
+	}
+	if i := slices.IndexFunc(report.Checks, func(c esp32.ConformanceCheck) bool { return c.Name == "pin-output notify" }); i < 0 ||
+		report.Checks[i].Status != esp32.ConformanceFailed || report.Checks[i].Detail != "no notification within 100ms" {
+		t.Errorf("report = %+v, want pin-output notify failed", report)
+	}
+}
+
+func TestSyncWrite(t *testing.T) {
+	cmd := exec.Command(os.Args[0], "sync-write", "--name", "esp32-test", "--name", "esp32-two", "--uuid", scheduleUUID, "--pin", "14=100", "--pin", "esp32-two:25=1")
+	cmd.Env = append(os.Environ(), "ESP32_TEST_MAIN=1", "ESP32_TEST_SCHEDULE=1")
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("CLI failed: %v\n%s", err, out)
+	}
+	wantOutput(t, string(out), "⏱️  Synchronizing the clocks of 2 boards", "   esp32-two: round trip", "✅ Scheduled 2 boards for")
+
+	// Without the schedule characteristic nothing is written.
+	out2, ok := runCLI(t, "sync-write", "--name", "esp32-test", "--name", "esp32-two", "--uuid", scheduleUUID, "--pin", "14=100")
+	if ok {
+		t.Fatalf("CLI succeeded, want failure:\n%s", out2)
+	}
+	wantOutput(t, out2, "❌ Synchronized write failed: esp32-test: ")
+
+	if out, ok := runCLI(t, "sync-write", "--name", "esp32-test", "--name", "esp32-two", "--uuid", scheduleUUID, "--pin", "porch:14=100"); ok || !strings.Contains(out, `--pin for "porch", which isn't a --name`) {
+		t.Errorf("CLI accepted a pin write for an unknown board:\n%s", out)
+	}
+}
+
+func TestOTARequiresUUIDs(t *testing.T) {
+	out, ok := runCLI(t, "ota", "--name", "esp32-test", "--file", "firmware.bin")
+	if ok {
+		t.Fatalf("CLI succeeded without OTA UUIDs:\n%s", out)
+	}
+	wantOutput(t, out, "Error: --data-uuid flag is required")
+}
+
+// writeConfig writes a config file into a temporary directory.
+func writeConfig(t *testing.T, config string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(config), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestProfile(t *testing.T) {
+	config := writeConfig(t, `
+profiles:
+  second:
+    name: esp32-two
+    address: AA:BB:CC:DD:EE:02
+  custom:
+    name: esp32-test
+    service_uuid: 0000fff0-0000-1000-8000-00805f9b34fb
+    characteristics:
+      adc_output: 0000fff1-0000-1000-8000-00805f9b34fb
+`)
+
+	out, ok := runCLI(t, "--config", config, "--profile", "second")
+	if !ok {
+		t.Fatalf("CLI failed:\n%s", out)
+	}
+	wantOutput(t, out, `📋 Using profile "second"`, "✅ Found target device: esp32-two", "✅ Pin: 35, Value: 42")
+
+	// The profile's UUID is looked for instead of the stock one.
+	out, ok = runCLI(t, "--config", config, "--profile", "custom")
+	if ok {
+		t.Fatalf("CLI succeeded with a missing characteristic:\n%s", out)
+	}
+	wantOutput(t, out,
+		"⚠️  Service 0000fff0-0000-1000-8000-00805f9b34fb not found",
+		"❌ Characteristic 0000fff1-0000-1000-8000-00805f9b34fb not found",
+	)
+}
+
+func TestSerialNumberIdentity(t *testing.T) {
+	config := writeConfig(t, `
+profiles:
+  lab:
+    name: esp32-test
+    characteristics:
+      serial_number: 00002a25-0000-1000-8000-00805f9b34fb
+`)
+	logFile := filepath.Join(t.TempDir(), "readings.csv")
+	cmd := exec.Command(os.Args[0], "--config", config, "--profile", "lab", "--log-file", logFile)
+	cmd.Env = append(os.Environ(), "ESP32_TEST_MAIN=1", "ESP32_TEST_SERIAL=SN-0042")
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("CLI failed: %v\n%s", err, out)
+	}
+	wantOutput(t, string(out), "🪪 esp32-test is SN-0042")
+
+	// A board without a serial number is kept under its address.
+	out2, ok := runCLI(t, "--config", config, "--profile", "lab", "--log-file", logFile)
+	if !ok {
+		t.Fatalf("CLI failed:\n%s", out2)
+	}
+	wantOutput(t, out2, "⚠️  Could not identify esp32-test", "its readings are kept under its address")
+
+	data, err := os.ReadFile(logFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantOutput(t, string(data), ",esp32-test,AA:BB:CC:DD:EE:01,SN-0042,35,1234\n", ",esp32-test,AA:BB:CC:DD:EE:01,AA:BB:CC:DD:EE:01,35,1234\n")
+}
+
+func TestProfileErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name, config, profile, want string
+	}{
+		{"unknown profile", "profiles: {}\n", "lab", `❌ Profile "lab" not found`},
+		{"unknown key", "profiles:\n  lab:\n    name: esp32-test\n    poll: 1s\n", "lab", "field poll not found"},
+		{"no target", "profiles:\n  lab:\n    poll_interval: 1s\n", "lab", `profile "lab" has neither a name nor an address`},
+		{"value width", "profiles:\n  lab:\n    name: esp32-test\n    pin_value_bytes: 3\n", "lab", "pin values must be 1 or 2 bytes"},
+		{"unknown decoder", "profiles:\n  lab:\n    name: esp32-test\n    decoders:\n      01037594-1bbb-4490-aa4d-f6d333b42e16: int24\n", "lab", `unknown decoder "int24"`},
+		{"duplicate contact", "profiles:\n  lab:\n    name: esp32-test\n    contacts:\n      - pin: 14\n      - pin: 14\n", "lab", "pin 14 has more than one contact"},
+		{"unknown preset", "profiles:\n  lab:\n    name: esp32-test\n    climate:\n      - preset: drought\n", "lab", `unknown preset "drought"`},
+		{"unknown byte order", "profiles:\n  lab:\n    name: esp32-test\n    value_format: {byte_order: middle}\n", "lab", `unknown byte order "middle"`},
+		{"value bits", "profiles:\n  lab:\n    name: esp32-test\n    value_format: {bits: 24}\n", "lab", "values must be 1 to 16 bits, not 24"},
+		{"unknown decode mode", "profiles:\n  lab:\n    name: esp32-test\n    decode_mode: trusting\n", "lab", `unknown decode mode "trusting"`},
+		{"no humidity", "profiles:\n  lab:\n    name: esp32-test\n    climate:\n      - preset: condensation\n        output: 26\n", "lab", `climate "pin 26": condensation needs a humidity channel`},
+		{"virtual channel kind", "virtual_devices:\n  station:\n    channels:\n      - {pin: 1, kind: dac, device: esp32-test}\n", "lab", `virtual device "station": pin 1: unknown kind "dac"`},
+		{"virtual device outside space", "virtual_devices:\n  station:\n    channels:\n      - {pin: 1, device: esp32-test}\nspaces:\n  lab-a:\n    token: a\n    devices: [station]\n", "lab", `space "lab-a": virtual device "station" needs device "esp32-test" in the space`},
+		{"channel on two pins", "profiles:\n  lab:\n    name: esp32-test\n    channels: {fan: 25, vent: 25}\n", "lab", `pin 25 is both channel "fan" and channel "vent"`},
+		{"unknown channel in alert", "profiles:\n  lab:\n    name: esp32-test\n    channels: {fan: 25}\n    alerts:\n      - heat>3000 -> write fan=1\n", "lab", `unknown channel "heat"`},
+		{"curve and scale", "profiles:\n  lab:\n    name: esp32-test\n    calibrations:\n      35: {scale: 2, curve: probe.csv}\n", "lab", "pin 35: a calibration has a curve or a scale and offset, not both"},
+		{"missing curve", "profiles:\n  lab:\n    name: esp32-test\n    climate:\n      - preset: frost\n        temperature: {pin: 35, curve: /nonexistent/ntc.csv}\n        output: 27\n", "lab", `climate "pin 27": pin 35: curve file /nonexistent/ntc.csv not found`},
+		{"thermistor and curve", "profiles:\n  lab:\n    name: esp32-test\n    calibrations:\n      35: {curve: ntc.csv, thermistor: {model: beta, beta: 3950, r0: 10000, series_resistor: 10000}}\n", "lab", "pin 35: a calibration has one of a thermistor, a curve or a scale and offset"},
+		{"thermistor without divider", "profiles:\n  lab:\n    name: esp32-test\n    climate:\n      - preset: frost\n        temperature: {pin: 35, thermistor: {model: beta, beta: 3950, r0: 10000}}\n        output: 27\n", "lab", `climate "pin 27": pin 35: a thermistor needs its divider's series_resistor`},
+		{"unknown thermistor model", "profiles:\n  lab:\n    name: esp32-test\n    calibrations:\n      35: {thermistor: {model: ptc, series_resistor: 10000}}\n", "lab", `pin 35: unknown thermistor model "ptc" (want beta or steinhart-hart)`},
+		{"blind retry", "profiles:\n  lab:\n    name: esp32-test\n    write_policy:\n      c79b2ca7-f39d-4060-8168-816fa26737b7: {idempotent: false, attempts: 3}\n", "lab", "write policy for c79b2ca7-f39d-4060-8168-816fa26737b7: writes that aren't idempotent can only be retried with verify"},
+		{"anomaly channel", "profiles:\n  lab:\n    name: esp32-test\n    anomaly:\n      channels: [soil]\n", "lab", `profile "lab": anomaly: unknown channel "soil"`},
+		{"anomaly threshold", "profiles:\n  lab:\n    name: esp32-test\n    anomaly:\n      channels: [35]\n      threshold: -1\n", "lab", "anomaly: threshold must be positive"},
+		{"auth password", "auth:\n  basic:\n    users: {alice: secret}\n", "lab", `auth: basic: user "alice": want a password hash printed by hash-password`},
+		{"unsalted password", "auth:\n  basic:\n    users: {alice: 2bb80d537b1da3e38bd30361aa855686bde0eacd7162fef6a25fe97bf527a25b}\n", "lab", `auth: basic: user "alice": want a password hash printed by hash-password`},
+		{"oidc over http", "auth:\n  oidc:\n    issuer: http://sso.example.com/realms/lab\n    client_id: gateway\n", "lab", `auth: oidc: issuer "http://sso.example.com/realms/lab" must be https`},
+		{"space without token", "spaces:\n  lab-a:\n    devices: [esp32-test]\n", "lab", `space "lab-a" has no token`},
+		{"device in two spaces", "spaces:\n  lab-a:\n    token: a\n    devices: [esp32-test]\n  lab-b:\n    token: b\n    devices: [esp32-test]\n", "lab", `device "esp32-test" is in both space "lab-a" and space "lab-b"`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			out, ok := runCLI(t, "--config", writeConfig(t, tc.config), "--profile", tc.profile)
+			if ok {
+				t.Fatalf("CLI succeeded, want failure:\n%s", out)
+			}
+			wantOutput(t, out, tc.want)
+		})
+	}
+}
+
+func TestPinConflicts(t *testing.T) {
+	config := writeConfig(t, `
+profiles:
+  lab:
+    name: esp32-test
+    motion:
+      - name: hall
+        pin: 26
+        light: {sensor_pin: 14, dark_below: 800, output: 34}
+    climate:
+      - name: seed trays
+        preset: frost
+        temperature: {pin: 35}
+        output: 12
+    alerts:
+      - pin35>3000 -> write 6=1
+`)
+	out, ok := runCLI(t, "--config", config, "--profile", "lab")
+	if !ok {
+		t.Fatalf("CLI failed:\n%s", out)
+	}
+	wantOutput(t, out,
+		`⚠️  Profile "lab": motion "hall" light sensor: GPIO14 is on ADC2, which can't be read while Wi-Fi is on`,
+		`⚠️  Profile "lab": motion "hall" light: GPIO34 is input-only on the ESP32 and can't be driven`,
+		`⚠️  Profile "lab": climate "seed trays" output: GPIO12 is a strapping pin (flash voltage)`,
+		`⚠️  Alert "pin35>3000": GPIO6 is wired to the ESP32's SPI flash`,
+	)
+	if strings.Contains(out, "motion \"hall\":") || strings.Contains(out, "temperature:") {
+		t.Errorf("CLI warned of a usable pin:\n%s", out)
+	}
+
+	out, ok = runCLIInput(t, "write 39 100\nwrite 25 100\nquit\n", "--name", "esp32-test", "--repl")
+	if !ok {
+		t.Fatalf("CLI failed:\n%s", out)
+	}
+	wantOutput(t, out, "⚠️  GPIO39 is input-only on the ESP32", "✅ Wrote 1 pin(s)")
+	if strings.Count(out, "⚠️") != 1 {
+		t.Errorf("CLI warned of a write to GPIO25:\n%s", out)
+	}
+}
+
+func TestBoardVariant(t *testing.T) {
+	// GPIO34 drives fine on an S3, but GPIO46 is a strapping pin there
+	// and GPIO14 is on ADC2.
+	config := writeConfig(t, `
+profiles:
+  lab:
+    name: esp32-test
+    board: esp32s3
+    climate:
+      - name: seed trays
+        preset: frost
+        temperature: {pin: 14}
+        output: 34
+      - name: cold frame
+        preset: frost
+        temperature: {pin: 4}
+        output: 46
+`)
+	out, ok := runCLI(t, "--config", config, "--profile", "lab")
+	if !ok {
+		t.Fatalf("CLI failed:\n%s", out)
+	}
+	wantOutput(t, out,
+		`climate "seed trays" temperature: GPIO14 is on ADC2`,
+		`climate "cold frame" output: GPIO46 is a strapping pin (boot mode)`,
+	)
+	if strings.Contains(out, "GPIO34") || strings.Contains(out, "GPIO4 ") {
+		t.Errorf("CLI warned of a pin the S3 can use:\n%s", out)
+	}
+
+	out, ok = runCLI(t, "--config", writeConfig(t, "profiles:\n  lab:\n    name: esp32-test\n    board: esp8266\n"), "--profile", "lab")
+	if ok || !strings.Contains(out, `unknown board model "esp8266" (want esp32, esp32c3, esp32s2, esp32s3)`) {
+		t.Errorf("CLI accepted an unknown board:\n%s", out)
+	}
+}
+
+func TestBoards(t *testing.T) {
+	out, ok := runCLI(t, "boards")
+	if !ok {
+		t.Fatalf("CLI failed:\n%s", out)
+	}
+	wantOutput(t, out, "esp32    ESP32     34 GPIOs, 18 ADC, 10 touch, 2 DAC", "esp32c3  ESP32-C3  22 GPIOs, 6 ADC, 0 touch, no DAC")
+
+	out, ok = runCLI(t, "boards", "esp32")
+	if !ok {
+		t.Fatalf("CLI failed:\n%s", out)
+	}
+	wantOutput(t, out, "📟 ESP32", "25    ADC2_CH8, DAC1\n", "34    ADC1_CH6, input-only\n", "6     SPI flash\n")
+}
+
+func TestBench(t *testing.T) {
+	cmd := exec.Command(os.Args[0], "bench", "--name", "esp32-test", "--char-uuid", benchUUID, "--duration", "200ms")
+	cmd.Env = append(os.Environ(), "ESP32_TEST_MAIN=1", "ESP32_TEST_BENCH=1")
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("CLI failed: %v\n%s", err, out)
+	}
+	wantOutput(t, string(out),
+		"📶 MTU: 247",
+		"🚀 Throughput:",
+		"📉 Loss: 1 frame(s)",
+		"jitter",
+	)
+}
+
+// runSecureCLI runs the CLI against a board requiring pairing with
+// passkey 123456, compared rather than entered if compare is set.
+func runSecureCLI(t *testing.T, compare bool, input string, args ...string) (string, bool) {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], args...)
+	cmd.Stdin = strings.NewReader(input)
+	cmd.Env = append(os.Environ(), "ESP32_TEST_MAIN=1", "ESP32_TEST_PASSKEY=123456")
+	if compare {
+		cmd.Env = append(cmd.Env, "ESP32_TEST_COMPARE=1")
+	}
+	out, err := cmd.CombinedOutput()
+	if _, ok := err.(*exec.ExitError); err != nil && !ok {
+		t.Fatalf("running CLI: %v", err)
+	}
+	return string(out), err == nil
+}
+
+func TestPair(t *testing.T) {
+	out, ok := runSecureCLI(t, false, "", "--name", "esp32-test")
+	if ok {
+		t.Fatalf("CLI read an encrypted characteristic without pairing:\n%s", out)
+	}
+	wantOutput(t, out, "insufficient authentication")
+
+	for _, tc := range []struct {
+		name    string
+		compare bool
+		input   string
+		args    []string
+		want    string
+	}{
+		{"passkey prompt", false, "123456\n", []string{"--pair"}, "🔑 Enter the passkey AA:BB:CC:DD:EE:01 displays:"},
+		{"comparison prompt", true, "y\n", []string{"--pair"}, "🔢 Does AA:BB:CC:DD:EE:01 display 123456? [y/N]"},
+		{"fixed passkey", false, "", []string{"--passkey", "123456"}, "🔐 Pairing with esp32-test..."},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			out, ok := runSecureCLI(t, tc.compare, tc.input, append([]string{"--name", "esp32-test"}, tc.args...)...)
+			if !ok {
+				t.Fatalf("CLI failed:\n%s", out)
+			}
+			wantOutput(t, out, tc.want, "✅ Paired and bonded with esp32-test", "✅ Pin: 35, Value: 1234")
+		})
+	}
+
+	out, ok = runSecureCLI(t, true, "n\n", "--name", "esp32-test", "--pair")
+	if ok {
+		t.Fatalf("CLI succeeded after declining the comparison:\n%s", out)
+	}
+	wantOutput(t, out, "❌ pairing with esp32-test failed", "pairing rejected")
+}
+
+func TestWalkTest(t *testing.T) {
+	cmd := exec.Command(os.Args[0], "walk-test", "--name", "esp32-test", "--interval", "10ms", "--window", "5")
+	cmd.Env = append(os.Environ(), "ESP32_TEST_MAIN=1")
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(300 * time.Millisecond)
+	cmd.Process.Signal(syscall.SIGINT)
+	if err := cmd.Wait(); err != nil {
+		t.Fatalf("CLI failed: %v\n%s", err, out.String())
+	}
+	wantOutput(t, out.String(),
+		"📶  -50 dBm ████░ excellent",
+		"✅ 100% of last 5",
+		" 0 failed (0.0%); latency min",
+		"🔌 Disconnected",
+	)
+}
+
+func TestWalkTestDrops(t *testing.T) {
+	cmd := exec.Command(os.Args[0], "walk-test", "--name", "esp32-test", "--interval", "10ms", "--beep")
+	cmd.Env = append(os.Environ(), "ESP32_TEST_MAIN=1", "ESP32_TEST_DROP_RATE=0.2")
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Second)
+	cmd.Process.Signal(syscall.SIGINT)
+	if err := cmd.Wait(); err != nil {
+		t.Fatalf("CLI failed: %v\n%s", err, out.String())
+	}
+	wantOutput(t, out.String(), "❌ no reply", "\a", "⚠️  Connection to esp32-test lost")
+	if strings.Contains(out.String(), " 0 failed") {
+		t.Errorf("summary reports no failures:\n%s", out.String())
+	}
+}
+
+func TestCapture(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "gatt.btsnoop")
+	out, ok := runCLI(t, "--name", "esp32-test", "--capture", path)
+	if !ok {
+		t.Fatalf("CLI failed:\n%s", out)
+	}
+	wantOutput(t, out, "📼 Capturing GATT operations to "+path)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(data, []byte("btsnoop\x00")) {
+		t.Fatalf("capture has no btsnoop header: % x", data[:min(len(data), 16)])
+	}
+	// The ADC read's response: ATT Read Response, 2 pins, 35 = 1234.
+	if !bytes.Contains(data, []byte{0x0b, 2, 35, 0x04, 0xd2}) {
+		t.Error("capture is missing the ADC read response")
+	}
+}
+
+func TestExporter(t *testing.T) {
+	cmd := exec.Command(os.Args[0], "--name", "esp32-test", "--exporter", "127.0.0.1:0", "--poll", "20ms")
+	cmd.Env = append(os.Environ(), "ESP32_TEST_MAIN=1")
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Wait()
+	defer cmd.Process.Signal(syscall.SIGINT)
+
+	var url string
+	scanner := bufio.NewScanner(stdout)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if _, after, ok := strings.Cut(line, "📈 Serving Prometheus metrics on "); ok {
+			url = after
+		}
+		if strings.Contains(line, "Pin: 35") {
+			break
+		}
+	}
+	go io.Copy(io.Discard, stdout)
+	if url == "" {
+		t.Fatal("exporter address not printed")
+	}
+
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantOutput(t, string(body),
+		"# TYPE esp32_adc_value gauge",
+		`esp32_adc_value{device="esp32-test",address="AA:BB:CC:DD:EE:01",pin="35"} 1234`,
+		`esp32_adc_value{device="esp32-test",address="AA:BB:CC:DD:EE:01",pin="32"} 4095`,
+		`esp32_connected{device="esp32-test",address="AA:BB:CC:DD:EE:01"} 1`,
+		"esp32_scan_duration_seconds{",
+		"esp32_readings_total{",
+		`esp32_characteristic_operations_total{device="esp32-test",address="AA:BB:CC:DD:EE:01",uuid="01037594-1bbb-4490-aa4d-f6d333b42e16",op="read"}`,
+		`esp32_characteristic_errors_total{device="esp32-test",address="AA:BB:CC:DD:EE:01",uuid="01037594-1bbb-4490-aa4d-f6d333b42e16",op="read"} 0`,
+	)
+}
+
+// startServe runs the serve command with args until the test ends,
+// returning the API's base URL once the board is served.
+func startServe(t *testing.T, args ...string) string {
+	t.Helper()
+	base, lines := startServeOutput(t, args...)
+	go func() {
+		for range lines {
+		}
+	}()
+	return base
+}
+
+// startServeOutput is startServe, also returning the lines it prints
+// once serving. With --lazy it returns once a board waits for a request.
+func startServeOutput(t *testing.T, args ...string) (string, <-chan string) {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], append([]string{"serve", "--listen", "127.0.0.1:0"}, args...)...)
+	cmd.Env = append(os.Environ(), "ESP32_TEST_MAIN=1", "ESP32_TEST_NOTIFY=1")
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		cmd.Process.Signal(syscall.SIGINT)
+		cmd.Wait()
+	})
+
+	var base string
+	scanner := bufio.NewScanner(stdout)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if _, after, ok := strings.Cut(line, " on http://"); ok && (strings.HasPrefix(line, "🌐 ") || strings.HasPrefix(line, "🏢 ")) {
+			base, _, _ = strings.Cut("http://"+after, " ")
+			base, _, _ = strings.Cut(base, "/spaces/")
+		}
+		if strings.Contains(line, "Serving esp32-test") || strings.Contains(line, "Waiting for a request") {
+			break
+		}
+	}
+	if base == "" {
+		t.Fatal("API address not printed")
+	}
+	lines := make(chan string)
+	go func() {
+		defer close(lines)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+	}()
+	return base, lines
+}
+
+// waitLine waits for a line of lines starting with prefix.
+func waitLine(t *testing.T, lines <-chan string, prefix string) {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				t.Fatalf("output ended before %q", prefix)
+			}
+			if strings.HasPrefix(line, prefix) {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("timed out waiting for %q", prefix)
+		}
+	}
+}
+
+func TestBridgeWill(t *testing.T) {
+	var online atomic.Bool
+	opts := mqttClientOptions(mqttOptions{broker: "tcp://localhost:1883", prefix: "home", device: "greenhouse", qos: 1}, &online)
+	if !opts.WillEnabled || opts.WillTopic != "home/greenhouse/availability" || string(opts.WillPayload) != bridge.Offline || !opts.WillRetained || opts.WillQos != 1 {
+		t.Errorf("will: enabled %v, %q %q, retained %v, QoS %d; want %s on the availability topic, retained, QoS 1",
+			opts.WillEnabled, opts.WillTopic, opts.WillPayload, opts.WillRetained, opts.WillQos, bridge.Offline)
+	}
+}
+
+func TestServe(t *testing.T) {
+	base := startServe(t, "--name", "esp32-test")
+
+	get := func(path string) string {
+		t.Helper()
+		resp, err := http.Get(base + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("GET %s: %s: %s", path, resp.Status, body)
+		}
+		return string(body)
+	}
+	wantOutput(t, get("/adc"), `"device":"esp32-test"`, `"pin":35,"value":1234`)
+
+	resp, err := http.Post(base+"/pins", "application/json", strings.NewReader(`{"pin_writes":[{"pin_num":14,"state":100}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("POST /pins: %s, want 204", resp.Status)
+	}
+	wantOutput(t, get("/pins"), `"pin":14,"value":100`)
+
+	resp, err = http.Post(base+"/pins", "application/json", strings.NewReader("bogus"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("POST /pins with bad JSON: %s, want 400", resp.Status)
+	}
+	resp, err = http.Post(base+"/pins", "text/plain", strings.NewReader(`{"pin_writes":[{"pin_num":14,"state":0}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnsupportedMediaType {
+		t.Errorf("POST /pins as text/plain: %s, want 415", resp.Status)
+	}
+
+	resp, err = http.Get(base + "/stream?kind=adc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	events := bufio.NewScanner(resp.Body)
+	var lines []string
+	for len(lines) < 2 && events.Scan() {
+		lines = append(lines, events.Text())
+	}
+	wantOutput(t, strings.Join(lines, "\n"), "event: adc", `"pin":35,"value":1234`)
+}
+
+func TestServeIdleDisconnect(t *testing.T) {
+	base, lines := startServeOutput(t, "--name", "esp32-test", "--heartbeat", "20ms", "--idle-disconnect", "100ms")
+	waitLine(t, lines, "💤 No activity on esp32-test")
+
+	// The request wakes the board and waits for it.
+	resp, err := http.Get(base + "/adc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET /adc: %s: %s", resp.Status, body)
+	}
+	wantOutput(t, string(body), `"pin":35,"value":1234`)
+	waitLine(t, lines, "🔔 esp32-test is needed again")
+	waitLine(t, lines, "💤 No activity on esp32-test")
+	go func() {
+		for range lines {
+		}
+	}()
+}
+
+func TestServeGateway(t *testing.T) {
+	base, lines := startServeOutput(t, "--name", "esp32-test", "--name", "esp32-two", "--lazy", "--heartbeat", "20ms", "--idle-disconnect", "200ms")
+	go func() {
+		for range lines {
+		}
+	}()
+
+	get := func(path string) string {
+		t.Helper()
+		resp, err := http.Get(base + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("GET %s: %s: %s", path, resp.Status, body)
+		}
+		return string(body)
+	}
+	wantOutput(t, get("/devices"), `{"name":"esp32-test","status":"idle"}`, `{"name":"esp32-two","status":"idle"}`)
+
+	// Each board is connected by its first request, which waits for it.
+	wantOutput(t, get("/devices/esp32-two/adc"), `"pin":35,"value":42`)
+	wantOutput(t, get("/devices/esp32-test/adc"), `"pin":35,"value":1234`)
+	wantOutput(t, get("/devices"), `{"name":"esp32-two","status":"connected"}`)
+
+	resp, err := http.Get(base + "/devices/esp32-three/adc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("GET for an unknown device: %s, want 404", resp.Status)
+	}
+}
+
+func TestServeSpaces(t *testing.T) {
+	dir := t.TempDir()
+	config := writeConfig(t, `
+spaces:
+  lab-a:
+    token: secret-a
+    journal: `+filepath.Join(dir, "lab-a.csv")+`
+    devices: [esp32-test]
+  lab-b:
+    token: secret-b
+    devices: [esp32-two]
+`)
+	base, lines := startServeOutput(t, "--spaces", "--config", config, "--lazy")
+	go func() {
+		for range lines {
+		}
+	}()
+
+	get := func(path, token string) (int, string) {
+		t.Helper()
+		req, _ := http.NewRequest("GET", base+path, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+	for _, token := range []string{"", "secret-b"} {
+		if code, _ := get("/spaces/lab-a/devices", token); code != http.StatusUnauthorized {
+			t.Errorf("GET lab-a's devices with token %q: %d, want 401", token, code)
+		}
+	}
+	code, body := get("/spaces/lab-a/devices", "secret-a")
+	if code != http.StatusOK || strings.Contains(body, "esp32-two") {
+		t.Errorf("GET lab-a's devices: %d %s, want only esp32-test", code, body)
+	}
+	wantOutput(t, body, `{"name":"esp32-test","status":"idle"}`)
+	if code, _ := get("/spaces/lab-a/devices/esp32-two/adc", "secret-a"); code != http.StatusNotFound {
+		t.Errorf("GET another space's device: %d, want 404", code)
+	}
+
+	_, body = get("/spaces/lab-a/devices/esp32-test/adc", "secret-a")
+	wantOutput(t, body, `"pin":35,"value":1234`)
+	_, body = get("/spaces/lab-b/devices/esp32-two/adc?access_token=secret-b", "")
+	wantOutput(t, body, `"pin":35,"value":42`)
+
+	// lab-a's journal records its board's notifications, and only its.
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		data, _ := os.ReadFile(filepath.Join(dir, "lab-a.csv"))
+		if strings.Contains(string(data), "AA:BB:CC:DD:EE:01") {
+			if strings.Contains(string(data), "AA:BB:CC:DD:EE:02") {
+				t.Errorf("lab-a's journal has lab-b's board:\n%s", data)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("lab-a's journal has no readings of its board:\n%s", data)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestServeAuth(t *testing.T) {
+	get := func(t *testing.T, client *http.Client, url string, header ...string) (int, string) {
+		t.Helper()
+		req, _ := http.NewRequest("GET", url, nil)
+		for i := 0; i+1 < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		data, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(data)
+	}
+
+	t.Run("basic", func(t *testing.T) {
+		hash, ok := runCLIInput(t, "secret\n", "hash-password")
+		if !ok || !strings.HasPrefix(hash, "pbkdf2-sha256$") {
+			t.Fatalf("hash-password: %s", hash)
+		}
+		if again, _ := runCLIInput(t, "secret\n", "hash-password"); again == hash {
+			t.Errorf("hash-password gave %q twice, want a new salt each time", hash)
+		}
+		config := writeConfig(t, "auth:\n  basic:\n    users: {alice: "+strings.TrimSpace(hash)+"}\n")
+		base := startServe(t, "--name", "esp32-test", "--config", config, "--auth", "basic", "--share")
+		if code, _ := get(t, http.DefaultClient, base+"/adc"); code != http.StatusUnauthorized {
+			t.Errorf("GET /adc without a password: %d, want 401", code)
+		}
+		req, _ := http.NewRequest("GET", base+"/adc", nil)
+		req.SetBasicAuth("alice", "wrong")
+		if resp, err := http.DefaultClient.Do(req); err != nil || resp.StatusCode != http.StatusUnauthorized {
+			t.Errorf("GET /adc with the wrong password: %v %v, want 401", resp.Status, err)
+		}
+
+		req, _ = http.NewRequest("POST", base+"/shares", strings.NewReader(`{"channels":["35"]}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.SetBasicAuth("alice", "secret")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var share struct{ URL string }
+		json.NewDecoder(resp.Body).Decode(&share)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusCreated {
+			t.Fatalf("POST /shares as alice: %d, want 201", resp.StatusCode)
+		}
+		// Share links are for whoever holds them.
+		code, body := get(t, http.DefaultClient, share.URL+"adc")
+		if code != http.StatusOK {
+			t.Errorf("GET a share link without a password: %d, want 200", code)
+		}
+		wantOutput(t, body, `"pin":35,"value":1234`)
+	})
+
+	t.Run("proxy", func(t *testing.T) {
+		base := startServe(t, "--name", "esp32-test", "--config", writeConfig(t, "{}\n"), "--auth", "proxy")
+		if code, _ := get(t, http.DefaultClient, base+"/adc"); code != http.StatusUnauthorized {
+			t.Errorf("GET /adc without a user: %d, want 401", code)
+		}
+		code, body := get(t, http.DefaultClient, base+"/adc", "Remote-User", "alice")
+		if code != http.StatusOK {
+			t.Errorf("GET /adc from the proxy: %d %s, want 200", code, body)
+		}
+
+		// Clients can't name themselves when the proxy is elsewhere.
+		config := writeConfig(t, "auth:\n  proxy:\n    header: X-Forwarded-User\n    trusted_proxies: [10.0.0.2]\n")
+		base = startServe(t, "--name", "esp32-test", "--config", config, "--auth", "proxy")
+		if code, _ := get(t, http.DefaultClient, base+"/adc", "X-Forwarded-User", "alice"); code != http.StatusForbidden {
+			t.Errorf("GET /adc from an untrusted address: %d, want 403", code)
+		}
+	})
+
+	t.Run("oidc", func(t *testing.T) {
+		// A provider signing everyone in as alice, without asking.
+		var issuer, nonce string
+		provider := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/.well-known/openid-configuration":
+				json.NewEncoder(w).Encode(map[string]string{"issuer": issuer, "authorization_endpoint": issuer + "/authorize", "token_endpoint": issuer + "/token"})
+			case "/authorize":
+				q := r.URL.Query()
+				nonce = q.Get("nonce")
+				http.Redirect(w, r, q.Get("redirect_uri")+"?code=c0de&state="+q.Get("state"), http.StatusFound)
+			case "/token":
+				if id, secret, _ := r.BasicAuth(); id != "gateway" || secret != "s3cret" || r.FormValue("code") != "c0de" {
+					w.WriteHeader(http.StatusBadRequest)
+					json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+					return
+				}
+				claims, _ := json.Marshal(map[string]any{"iss": issuer, "aud": "gateway", "exp": time.Now().Add(time.Hour).Unix(), "nonce": nonce, "preferred_username": "alice"})
+				json.NewEncoder(w).Encode(map[string]string{"id_token": "e30." + base64.RawURLEncoding.EncodeToString(claims) + ".c2ln"})
+			}
+		}))
+		defer provider.Close()
+		issuer = provider.URL
+
+		config := writeConfig(t, "auth:\n  oidc:\n    issuer: "+issuer+"\n    client_id: gateway\n    client_secret: s3cret\n    users: [alice]\n")
+		base, lines := startServeOutput(t, "--name", "esp32-test", "--config", config, "--auth", "oidc")
+		if code, _ := get(t, http.DefaultClient, base+"/adc"); code != http.StatusUnauthorized {
+			t.Errorf("GET /adc from an API client without a session: %d, want 401", code)
+		}
+		// Anyone can start signing in, but the login cookie that gets
+		// them isn't a session.
+		noRedirect := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+		resp, err := noRedirect.Get(base + "/auth/login")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		var login string
+		for _, c := range resp.Cookies() {
+			if c.Name == "esp32_login" {
+				login = c.Value
+			}
+		}
+		if login == "" {
+			t.Fatalf("GET /auth/login set no login cookie: %v", resp.Cookies())
+		}
+		if code, _ := get(t, http.DefaultClient, base+"/adc", "Cookie", "esp32_session="+login); code != http.StatusUnauthorized {
+			t.Errorf("GET /adc with the login cookie as a session: %d, want 401", code)
+		}
+		// A browser goes by the provider and comes back signed in.
+		jar, _ := cookiejar.New(nil)
+		browser := &http.Client{Jar: jar}
+		code, body := get(t, browser, base+"/adc", "Accept", "text/html")
+		if code != http.StatusOK {
+			t.Fatalf("GET /adc from a browser: %d %s, want 200 after signing in", code, body)
+		}
+		wantOutput(t, body, `"pin":35,"value":1234`)
+		waitLine(t, lines, "🔐 alice signed in")
+		if code, _ := get(t, browser, base+"/pins"); code != http.StatusOK {
+			t.Errorf("GET /pins with the session: %d, want 200", code)
+		}
+
+		// Signing out takes a POST, so a link or image can't do it.
+		if code, _ := get(t, browser, base+"/auth/logout"); code == http.StatusOK {
+			t.Errorf("GET /auth/logout: %d, want it refused", code)
+		}
+		if code, _ := get(t, browser, base+"/pins"); code != http.StatusOK {
+			t.Errorf("GET /pins after GET /auth/logout: %d, want 200", code)
+		}
+		resp, err = browser.Post(base+"/auth/logout", "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("POST /auth/logout: %d, want 200", resp.StatusCode)
+		}
+		if code, _ := get(t, browser, base+"/pins"); code != http.StatusUnauthorized {
+			t.Errorf("GET /pins after signing out: %d, want 401", code)
+		}
+	})
+
+	t.Run("oidc endpoints over http", func(t *testing.T) {
+		// The ID token's signature isn't checked, so it mustn't come over
+		// the network in the clear.
+		var issuer string
+		provider := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			json.NewEncoder(w).Encode(map[string]string{"issuer": issuer, "authorization_endpoint": issuer + "/authorize", "token_endpoint": "http://sso.example.com/token"})
+		}))
+		defer provider.Close()
+		issuer = provider.URL
+
+		config := writeConfig(t, "auth:\n  oidc:\n    issuer: "+issuer+"\n    client_id: gateway\n")
+		out, ok := runCLI(t, "serve", "--name", "esp32-test", "--config", config, "--auth", "oidc")
+		if ok {
+			t.Fatalf("serve succeeded, want failure:\n%s", out)
+		}
+		wantOutput(t, out, `❌ --auth: OIDC provider endpoint "http://sso.example.com/token" isn't https`)
+	})
+}
+
+func TestServeListeners(t *testing.T) {
+	dir := t.TempDir()
+	cert, key, pool := writeTestCert(t, dir)
+	socket := filepath.Join(dir, "serve.sock")
+	args := []string{"serve", "--name", "esp32-test",
+		"--listen", "127.0.0.1:0",
+		"--listen", "unix:" + socket,
+		"--listen", "127.0.0.1:0,cert=" + cert + ",key=" + key,
+		"--listen", "127.0.0.1:0,cert=" + cert + ",key=" + key + ",client_ca=" + cert,
+	}
+	if ln, err := net.Listen("tcp", "[::1]:0"); err == nil {
+		ln.Close()
+		args = append(args, "--listen", "[::1]:0")
+	}
+	cmd := exec.Command(os.Args[0], args...)
+	cmd.Env = append(os.Environ(), "ESP32_TEST_MAIN=1")
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		cmd.Process.Signal(syscall.SIGINT)
+		cmd.Wait()
+	})
+	var urls []string
+	scanner := bufio.NewScanner(stdout)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if after, ok := strings.CutPrefix(line, "🌐 Serving the API on "); ok {
+			url, _, _ := strings.Cut(after, " ")
+			urls = append(urls, url)
+		}
+		if after, ok := strings.CutPrefix(line, "🌐 Also serving on "); ok {
+			urls = append(urls, after)
+		}
+		if strings.Contains(line, "Serving esp32-test") {
+			break
+		}
+	}
+	go io.Copy(io.Discard, stdout)
+	if len(urls) != len(args)/2-1 || urls[1] != "unix:"+socket {
+		t.Fatalf("serving on %q, want each --listen in turn", urls)
+	}
+
+	get := func(client *http.Client, url string) error {
+		resp, err := client.Get(url + "/adc")
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		if !strings.Contains(string(body), `"pin":35,"value":1234`) {
+			return fmt.Errorf("GET %s/adc: %s", url, body)
+		}
+		return nil
+	}
+	unix := &http.Client{Transport: &http.Transport{DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+		return (&net.Dialer{}).DialContext(ctx, "unix", socket)
+	}}}
+	tlsClient := func(certs ...tls.Certificate) *http.Client {
+		return &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool, Certificates: certs}}}
+	}
+	pair, err := tls.LoadX509KeyPair(cert, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, tc := range []struct {
+		client  *http.Client
+		url     string
+		wantErr bool
+	}{
+		{http.DefaultClient, urls[0], false},
+		{unix, "http://esp32", false},
+		{tlsClient(), urls[2], false},
+		{http.DefaultClient, "http" + strings.TrimPrefix(urls[2], "https"), true},
+		{tlsClient(), urls[3], true},
+		{tlsClient(pair), urls[3], false},
+	} {
+		if err := get(tc.client, tc.url); (err != nil) != tc.wantErr {
+			t.Errorf("listener %d, %s: error %v, want error %v", i, tc.url, err, tc.wantErr)
+		}
+	}
+	if len(urls) > 4 && !strings.HasPrefix(urls[4], "http://[::1]:") {
+		t.Errorf("IPv6 listener at %s", urls[4])
+	} else if len(urls) > 4 {
+		if err := get(http.DefaultClient, urls[4]); err != nil {
+			t.Error(err)
+		}
+	}
+
+	out, ok := runCLI(t, "serve", "--name", "esp32-test", "--listen", "127.0.0.1:0,cert="+cert)
+	if ok {
+		t.Fatalf("CLI succeeded, want failure:\n%s", out)
+	}
+	wantOutput(t, out, "TLS needs both cert and key")
+}
+
+// writeTestCert writes a self-signed certificate for 127.0.0.1, usable
+// by servers and clients, and its key to dir, returning their paths and a
+// pool trusting it.
+func writeTestCert(t *testing.T, dir string) (string, string, *x509.CertPool) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), crand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "esp32 test"},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(crand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPath, keyPath := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	if err := os.WriteFile(certPath, certPEM, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(certPEM)
+	return certPath, keyPath, pool
+}
+
+func TestServeShare(t *testing.T) {
+	base := startServe(t, "--name", "esp32-test", "--share")
+
+	do := func(method, url, body string) (int, string) {
+		t.Helper()
+		req, _ := http.NewRequest(method, url, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		data, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(data)
+	}
+	create := func(body string) string {
+		t.Helper()
+		code, data := do("POST", base+"/shares", body)
+		var created struct{ Token, URL string }
+		if err := json.Unmarshal([]byte(data), &created); code != http.StatusCreated || err != nil {
+			t.Fatalf("POST /shares: %d %s", code, data)
+		}
+		if created.URL != base+"/share/"+created.Token+"/" {
+			t.Errorf("share URL %q, want one under %s/share/", created.URL, base)
+		}
+		return created.URL
+	}
+
+	url := create(`{"channels":["35"],"ttl":"1h"}`)
+	code, page := do("GET", url, "")
+	if code != http.StatusOK || !strings.Contains(page, "<title>ESP32 live view</title>") {
+		t.Errorf("GET the share page: %d", code)
+	}
+	_, body := do("GET", url+"adc", "")
+	wantOutput(t, body, `"pin":35,"value":1234`)
+	if _, body := do("GET", url+"pins", ""); strings.TrimSpace(body) != "[]" {
+		t.Errorf("GET a share's pins: %s, want none but pin 35's", body)
+	}
+	// Nothing that writes is shared.
+	if code, _ := do("POST", url+"pins", `{"pin_writes":[{"pin_num":14,"state":1}]}`); code < 400 {
+		t.Errorf("POST a share's pins: %d, want it refused", code)
+	}
+	if code, _ := do("GET", url+"ws", ""); code != http.StatusNotFound {
+		t.Errorf("GET a share's WebSocket: %d, want 404", code)
+	}
+	_, body = do("GET", base+"/shares", "")
+	wantOutput(t, body, `"device":"esp32-test","channels":["35"],"expires":"`)
+
+	// Revoking a share ends its open streams.
+	resp, err := http.Get(url + "stream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ended := make(chan struct{})
+	go func() {
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		close(ended)
+	}()
+	token := strings.TrimSuffix(strings.TrimPrefix(url, base+"/share/"), "/")
+	if code, _ := do("DELETE", base+"/shares/"+token, ""); code != http.StatusNoContent {
+		t.Fatalf("DELETE the share: %d, want 204", code)
+	}
+	select {
+	case <-ended:
+	case <-time.After(5 * time.Second):
+		t.Fatal("a revoked share's stream stayed open")
+	}
+	if code, _ := do("GET", url+"adc", ""); code != http.StatusNotFound {
+		t.Errorf("GET a revoked share: %d, want 404", code)
+	}
+
+	url = create(`{"ttl":"50ms"}`)
+	time.Sleep(100 * time.Millisecond)
+	if code, _ := do("GET", url, ""); code != http.StatusNotFound {
+		t.Errorf("GET an expired share: %d, want 404", code)
+	}
+
+	for _, tc := range []struct{ body, want string }{
+		{`{"channels":["heater"]}`, `unknown channel \"heater\"`},
+		{`{"ttl":"soon"}`, `invalid ttl \"soon\"`},
+		{`{"device":"esp32-two"}`, `unknown device \"esp32-two\"`},
+	} {
+		if code, body := do("POST", base+"/shares", tc.body); code != http.StatusBadRequest || !strings.Contains(body, tc.want) {
+			t.Errorf("POST /shares %s: %d %s, want 400 with %s", tc.body, code, body, tc.want)
+		}
+	}
+}
+
+func TestServeVirtual(t *testing.T) {
+	config := writeConfig(t, `
+virtual_devices:
+  station:
+    channels:
+      - {pin: 1, kind: adc, device: esp32-test, device_pin: 35}
+      - {pin: 2, kind: adc, device: esp32-two, device_pin: 35}
+      - {pin: 14, device: esp32-test}
+`)
+	base := startServe(t, "--config", config, "--virtual", "station", "--lazy")
+
+	do := func(method, path, body string) (int, string) {
+		t.Helper()
+		req, _ := http.NewRequest(method, base+path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		data, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(data)
+	}
+	_, body := do("GET", "/devices", "")
+	wantOutput(t, body, `{"name":"esp32-test","status":"`, `{"name":"esp32-two","status":"`, `{"name":"station","status":"`)
+
+	// The channels are read from both boards and presented as the
+	// virtual device's.
+	code, body := do("GET", "/devices/station/adc", "")
+	if code != http.StatusOK {
+		t.Fatalf("GET station's ADC: %d %s", code, body)
+	}
+	wantOutput(t, body, `"device":"station","address":"","pin":1,"value":1234`, `"device":"station","address":"","pin":2,"value":42`)
+
+	if code, body := do("POST", "/devices/station/pins", `{"pin_writes":[{"pin_num":14,"state":1}]}`); code != http.StatusNoContent {
+		t.Fatalf("POST station's pins: %d %s", code, body)
+	}
+	_, body = do("GET", "/devices/esp32-test/pins", "")
+	wantOutput(t, body, `"pin":14,"value":1`)
+	_, body = do("GET", "/devices/station/pins", "")
+	wantOutput(t, body, `"device":"station","address":"","pin":14,"value":1`)
+	if code, body := do("POST", "/devices/station/pins", `{"pin_writes":[{"pin_num":15,"state":1}]}`); code != http.StatusBadRequest {
+		t.Errorf("POST to a pin station lacks: %d %s, want 400", code, body)
+	}
+
+	// Notifications are streamed as the virtual device's too.
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(base, "http")+"/devices/station/ws?kind=adc", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	var m struct {
+		Kind string          `json:"kind"`
+		Data json.RawMessage `json:"data"`
+	}
+	if err := conn.ReadJSON(&m); err != nil {
+		t.Fatal(err)
+	}
+	wantOutput(t, string(m.Data), `"device":"station"`)
+}
+
+func TestServeFrontEnds(t *testing.T) {
+	base := startServe(t, "--name", "esp32-test", "--metrics")
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(base, "http")+"/ws?kind=adc", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	var m struct {
+		Kind string          `json:"kind"`
+		Data json.RawMessage `json:"data"`
+	}
+	if err := conn.ReadJSON(&m); err != nil {
+		t.Fatal(err)
+	}
+	if m.Kind != api.KindADC || !strings.Contains(string(m.Data), `"pin":35,"value":1234`) {
+		t.Errorf("WebSocket message = %s %s, want ADC readings", m.Kind, m.Data)
+	}
+
+	// Writes sent on the WebSocket reach the board; bad ones are
+	// reported on it.
+	if err := conn.WriteMessage(websocket.TextMessage, []byte(`{"pin_writes":[{"pin_num":14,"state":100}]}`)); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.WriteMessage(websocket.TextMessage, []byte(`{`)); err != nil {
+		t.Fatal(err)
+	}
+	for m.Kind != api.KindError {
+		if err := conn.ReadJSON(&m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wantOutput(t, string(m.Data), "invalid pin_writes JSON")
+	resp, err := http.Get(base + "/pins")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	wantOutput(t, string(body), `"pin":14,"value":100`)
+
+	// Other sites' pages can't open it; this server's own can.
+	ws := "ws" + strings.TrimPrefix(base, "http") + "/ws?kind=adc"
+	if _, resp, err := websocket.DefaultDialer.Dial(ws, http.Header{"Origin": {"https://example.com"}}); err == nil || resp == nil || resp.StatusCode != http.StatusForbidden {
+		t.Errorf("WebSocket from another origin: %v, want 403", err)
+	}
+	same, _, err := websocket.DefaultDialer.Dial(ws, http.Header{"Origin": {base}})
+	if err != nil {
+		t.Fatalf("WebSocket from the server's own origin: %v", err)
+	}
+	same.Close()
+
+	// The metrics see the same notifications.
+	resp, err = http.Get(base + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	wantOutput(t, string(body),
+		`esp32_adc_value{device="esp32-test",address="AA:BB:CC:DD:EE:01",pin="35"} 1234`,
+		`esp32_connected{device="esp32-test",address="AA:BB:CC:DD:EE:01"} 1`,
+	)
+}
+
+func TestServeEditor(t *testing.T) {
+	config := writeConfig(t, `
+profiles:
+  bench:
+    name: esp32-test
+    # Trip the relay when the pot is turned up.
+    alerts:
+      - pin35>3000 -> write 14=1
+`)
+	if err := os.Chmod(config, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	base := startServe(t, "--config", config, "--profile", "bench", "--editor")
+
+	put := func(body string) (int, string) {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodPut, base+"/editor/profile", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		data, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(data)
+	}
+	status, _ := put(`{"labels":{"14":"relay","35":"pot"},"calibrations":{"35":{"scale":0.1,"offset":-40,"unit":"°C"}},"alerts":["pin35>3000 -> write 14=1","pin35<100 -> print"]}`)
+	if status != http.StatusNoContent {
+		t.Fatalf("PUT /editor/profile: %d, want 204", status)
+	}
+	data, err := os.ReadFile(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantOutput(t, string(data), "35: pot", "unit: °C", "- pin35<100 -> print", "# Trip the relay", "name: esp32-test")
+	// The config is replaced whole, keeping its permissions, with no
+	// temporary file left beside it.
+	if fi, err := os.Stat(config); err != nil {
+		t.Error(err)
+	} else if fi.Mode().Perm() != 0o600 {
+		t.Errorf("config has mode %v after saving, want 0600", fi.Mode().Perm())
+	}
+	if entries, _ := os.ReadDir(filepath.Dir(config)); len(entries) != 1 {
+		t.Errorf("config directory holds %v after saving, want only the config", entries)
+	}
+
+	resp, err := http.Get(base + "/editor/profile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	wantOutput(t, string(body), `"profile":"bench"`, `"14":"relay"`, `"offset":-40`)
+
+	for _, tc := range []struct{ body, want string }{
+		{`{"alerts":["pin35>"]}`, "rule 1:"},
+		{`{"labels":{"14":" "}}`, "pin 14 has an empty label"},
+		{`{"bogus":1}`, "invalid profile JSON"},
+	} {
+		status, msg := put(tc.body)
+		if status != http.StatusBadRequest || !strings.Contains(msg, tc.want) {
+			t.Errorf("PUT %s: %d %s, want 400 with %q", tc.body, status, msg, tc.want)
+		}
+	}
+	if after, _ := os.ReadFile(config); string(after) != string(data) {
+		t.Errorf("config changed by rejected edits:\n%s", after)
+	}
+
+	// A curve is found next to the config file, and its points served
+	// for the page to apply.
+	if err := os.WriteFile(filepath.Join(filepath.Dir(config), "soil.csv"), []byte("raw,moisture\n3000,0\n1200,100\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if status, msg := put(`{"calibrations":{"35":{"unit":"%","curve":"soil.csv"}}}`); status != http.StatusNoContent {
+		t.Fatalf("PUT a curve: %d %s, want 204", status, msg)
+	}
+	if data, _ := os.ReadFile(config); strings.Contains(string(data), "points") {
+		t.Errorf("curve points written to the config file:\n%s", data)
+	}
+	resp, err = http.Get(base + "/editor/profile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	wantOutput(t, string(body), `"curve":"soil.csv","points":[{"raw":1200,"value":100},{"raw":3000,"value":0}]`)
+
+	// A thermistor is kept in the config file as its model, served with
+	// points sampled from it, in °C.
+	if status, msg := put(`{"calibrations":{"35":{"unit":"","thermistor":{"model":"beta","beta":3950,"r0":10000,"series_resistor":10000}}}}`); status != http.StatusNoContent {
+		t.Fatalf("PUT a thermistor: %d %s, want 204", status, msg)
+	}
+	data, _ = os.ReadFile(config)
+	wantOutput(t, string(data), "model: beta", "series_resistor: 10000")
+	if strings.Contains(string(data), "points") || strings.Contains(string(data), "adc_max") {
+		t.Errorf("thermistor points or defaults written to the config file:\n%s", data)
+	}
+	resp, err = http.Get(base + "/editor/profile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	wantOutput(t, string(body), `"unit":"°C"`, `"thermistor":{"model":"beta","beta":3950,"r0":10000,"series_resistor":10000},"points":[{"raw":1,"value":`)
+}
+
+func TestJournalHistory(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal.csv")
+	for range 2 {
+		out, ok := runCLI(t, "--name", "esp32-test", "--journal", path, "--journal-pins", "14,35")
+		if !ok {
+			t.Fatalf("CLI failed:\n%s", out)
+		}
+		wantOutput(t, out, "📜 Journalling value changes to "+path)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The second run saw the same values, so only the first is journalled.
+	if rows := strings.Count(string(data), "\n"); rows != 3 {
+		t.Errorf("journal has %d line(s), want a header and one change each of pins 14 and 35:\n%s", rows, data)
+	}
+
+	out, ok := runCLI(t, "history", "pin", "35", "--journal", path, "--since", "1h")
+	if !ok {
+		t.Fatalf("CLI failed:\n%s", out)
+	}
+	wantOutput(t, out, "📜 1 change(s) of pin 35 in the last 1h0m0s", "esp32-test        1234")
+
+	if out, ok := runCLI(t, "history", "pin", "x"); ok {
+		t.Fatalf("CLI succeeded with a bad pin:\n%s", out)
+	}
+}
+
+func TestListAndScanCache(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	out, ok := runCLI(t, "list", "--window", "100ms", "--cache")
+	if !ok {
+		t.Fatalf("CLI failed:\n%s", out)
+	}
+	wantOutput(t, out,
+		"📋 2 device(s)",
+		"NAME        ADDRESS            RSSI     SERVICES  LAST SEEN",
+		"esp32-test  AA:BB:CC:DD:EE:01  -50 dBm  -",
+		"esp32-two   AA:BB:CC:DD:EE:02  -50 dBm  -",
+		"💾 Cached to ",
+	)
+
+	out, ok = runCLI(t, "--name", "esp32-two", "--scan-cache", "1m")
+	if !ok {
+		t.Fatalf("CLI failed:\n%s", out)
+	}
+	wantOutput(t, out, "⚡ Using cached address AA:BB:CC:DD:EE:02", "✅ Pin: 35, Value: 42")
+	if strings.Contains(out, "🔍 Scanning") {
+		t.Errorf("scanned despite a fresh cache entry:\n%s", out)
+	}
+
+	// A stale address falls back to scanning.
+	stale := `[{"name":"esp32-test","address":"AA:BB:CC:DD:EE:99","last_seen":"` + time.Now().Format(time.RFC3339) + `"}]`
+	if err := os.WriteFile(filepath.Join(os.Getenv("HOME"), ".esp32_interfaces_scan.json"), []byte(stale), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	out, ok = runCLI(t, "--name", "esp32-test", "--scan-cache", "1m")
+	if !ok {
+		t.Fatalf("CLI failed:\n%s", out)
+	}
+	wantOutput(t, out, "⚠️  Could not connect to the cached address", "🔍 Scanning", "✅ Pin: 35, Value: 1234")
+}
+
+func TestContacts(t *testing.T) {
+	config := writeConfig(t, `
+profiles:
+  porch:
+    name: esp32-test
+    poll_interval: 20ms
+    contacts:
+      - name: front door
+        pin: 14
+        alert_after: 100ms
+      - pin: 26
+        open_low: true
+`)
+	cmd := exec.Command(os.Args[0], "--config", config, "--profile", "porch")
+	cmd.Env = append(os.Environ(), "ESP32_TEST_MAIN=1", "ESP32_TEST_DOOR=1")
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Wait()
+	defer cmd.Process.Signal(syscall.SIGINT)
+
+	var lines []string
+	scanner := bufio.NewScanner(stdout)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "🚪") || strings.HasPrefix(line, "🚨") {
+			lines = append(lines, line)
+		}
+		if strings.Contains(line, "front door closed") {
+			break
+		}
+	}
+	go io.Copy(io.Discard, stdout)
+
+	// Pin 26 reads 0, which is open for an open_low contact.
+	want := []string{"🚪 pin 26 opened", "🚪 front door opened", "🚨 front door left open for", "🚪 front door closed after"}
+	if len(lines) != len(want) {
+		t.Fatalf("events = %q, want %q", lines, want)
+	}
+	for i := range want {
+		if !strings.HasPrefix(lines[i], want[i]) {
+			t.Errorf("event %d = %q, want %q", i, lines[i], want[i])
+		}
+	}
+}
+
+func TestMotion(t *testing.T) {
+	// ADC 35 reads 1234: dark for the hallway, bright for the porch.
+	config := writeConfig(t, `
+profiles:
+  house:
+    name: esp32-test
+    poll_interval: 20ms
+    motion:
+      - name: hallway
+        pin: 26
+        timeout: 100ms
+        light: {sensor_pin: 35, dark_below: 2000, output: 25}
+      - name: porch
+        pin: 33
+        timeout: 100ms
+        light: {sensor_pin: 35, dark_below: 1000, output: 14}
+`)
+	cmd := exec.Command(os.Args[0], "--config", config, "--profile", "house")
+	cmd.Env = append(os.Environ(), "ESP32_TEST_MAIN=1", "ESP32_TEST_MOTION=1")
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Wait()
+	defer cmd.Process.Signal(syscall.SIGINT)
+
+	var lines []string
+	vacant := 0
+	scanner := bufio.NewScanner(stdout)
+	for scanner.Scan() && vacant < 2 {
+		line := scanner.Text()
+		for _, prefix := range []string{"🏃", "🏠", "💡"} {
+			if strings.HasPrefix(line, prefix) {
+				lines = append(lines, line)
+			}
+		}
+		if strings.Contains(line, "vacant after") {
+			vacant++
+		}
+	}
+	go io.Copy(io.Discard, stdout)
+
+	out := strings.Join(lines, "\n")
+	wantOutput(t, out,
+		"🏃 Motion at hallway\n🏠 hallway occupied\n💡 hallway light on (pin 25 = 1)",
+		"🏃 Motion at porch\n🏠 porch occupied",
+		"🏠 hallway vacant after 0s\n💡 hallway light off (pin 25 = 0)",
+		"🏠 porch vacant after 0s",
+	)
+	if strings.Contains(out, "porch light") {
+		t.Errorf("porch light switched although it was bright:\n%s", out)
+	}
+}
+
+func TestRecordReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "session.jsonl")
+	out, ok := runCLI(t, "--name", "esp32-test", "--record", path)
+	if !ok {
+		t.Fatalf("CLI failed:\n%s", out)
+	}
+	wantOutput(t, out, "⏺️  Recording GATT operations to "+path, "✅ Pin: 35, Value: 1234")
+
+	// Edit pin 35's recorded ADC value from 1234 (0x04d2) to 7.
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(data, []byte("2304d2")) {
+		t.Fatalf("recording has no read of pin 35 = 1234:\n%s", data)
+	}
+	if err := os.WriteFile(path, bytes.ReplaceAll(data, []byte("2304d2"), []byte("230007")), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	out, ok = runCLI(t, "--name", "esp32-test", "--replay", path)
+	if !ok {
+		t.Fatalf("CLI failed:\n%s", out)
+	}
+	wantOutput(t, out,
+		"⏯️  Replaying "+path,
+		"✅ Successfully connected to esp32-test!",
+		"✅ Pin: 35, Value: 7",
+		"✅ Pin: 32, Value: 4095",
+	)
+
+	if out, ok := runCLI(t, "--name", "esp32-two", "--replay", path, "--timeout", "1"); ok {
+		t.Fatalf("CLI found a board missing from the recording:\n%s", out)
+	}
+}
+
+func TestUnknownPayload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "session.jsonl")
+	if out, ok := runCLI(t, "--name", "esp32-test", "--record", path); !ok {
+		t.Fatalf("CLI failed:\n%s", out)
+	}
+	// Claim five ADC pins, as firmware with a longer pin list would.
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, bytes.ReplaceAll(data, []byte(`"022304d2`), []byte(`"052304d2`)), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	out, ok := runCLI(t, "--name", "esp32-test", "--replay", path)
+	if ok {
+		t.Fatalf("CLI succeeded decoding a frame the decoder rejects:\n%s", out)
+	}
+	wantOutput(t, out,
+		"❓ Unknown payload from 01037594-1bbb-4490-aa4d-f6d333b42e16: pin16 frame lists pin 0 twice",
+		"   00000000  05 23 04 d2 20 0f ff 00",
+	)
+	if strings.Contains(out, "✅ Pin:") {
+		t.Errorf("readings printed from the rejected frame:\n%s", out)
+	}
+
+	// A lenient profile keeps the pins that are intact.
+	config := writeConfig(t, `
+profiles:
+  test:
+    name: esp32-test
+    decode_mode: lenient
+`)
+	out, ok = runCLI(t, "--profile", "test", "--config", config, "--replay", path)
+	if !ok {
+		t.Fatalf("CLI failed:\n%s", out)
+	}
+	wantOutput(t, out,
+		"⚠️  Salvaged 3 reading(s) from an inconsistent payload from 01037594-1bbb-4490-aa4d-f6d333b42e16: pin16 frame lists pin 0 twice",
+		"✅ Pin: 35, Value: 1234",
+		"✅ Pin: 32, Value: 4095",
+	)
+}
+
+func TestClimate(t *testing.T) {
+	// ADC 35 falls from 1234 to 600: 7.7°C to 14°C on the NTC thermistor,
+	// and 92.6% to 45% relative humidity at the 20.5°C of ADC 32.
+	config := writeConfig(t, `
+profiles:
+  greenhouse:
+    name: esp32-test
+    poll_interval: 20ms
+    climate:
+      - name: seed trays
+        preset: frost
+        temperature: {pin: 35, scale: -0.01, offset: 20}
+        output: 27
+        threshold: 10
+        min_run: 50ms
+      - name: glazing
+        preset: condensation
+        temperature: {pin: 32, scale: 0.005}
+        humidity: {pin: 35, scale: 0.075}
+        output: 26
+        min_run: 50ms
+`)
+	cmd := exec.Command(os.Args[0], "--config", config, "--profile", "greenhouse")
+	cmd.Env = append(os.Environ(), "ESP32_TEST_MAIN=1", "ESP32_TEST_CLIMATE=1")
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Wait()
+	defer cmd.Process.Signal(syscall.SIGINT)
+
+	var lines []string
+	off := 0
+	scanner := bufio.NewScanner(stdout)
+	for scanner.Scan() && off < 2 {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "🌡️") {
+			lines = append(lines, line)
+			if strings.Contains(line, " off at ") {
+				off++
+			}
+		}
+	}
+	go io.Copy(io.Discard, stdout)
+
+	out := strings.Join(lines, "\n")
+	wantOutput(t, out,
+		"🌡️  seed trays heater on at 7.7°C (pin 27 = 1)",
+		"🌡️  glazing fan on at 1.2°C above the dew point (pin 26 = 1)",
+		"🌡️  seed trays heater off at 14.0°C after 0s (pin 27 = 0)",
+		"🌡️  glazing fan off at 12.3°C above the dew point after 0s (pin 26 = 0)",
+	)
+}
+
+func TestClimateCurve(t *testing.T) {
+	// An NTC thermistor's curve, from its datasheet: ADC 35 falling from
+	// 1234 to 600 is 7.7°C to 14°C, far from a straight line.
+	dir := t.TempDir()
+	curve := filepath.Join(dir, "ntc.csv")
+	if err := os.WriteFile(curve, []byte("# 10k NTC on a 10k divider\nraw,celsius\n400,20\n600,14\n1234,7.7\n2000,2\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	config := writeConfig(t, `
+profiles:
+  greenhouse:
+    name: esp32-test
+    poll_interval: 20ms
+    climate:
+      - name: seed trays
+        preset: frost
+        temperature: {pin: 35, curve: `+curve+`}
+        output: 27
+        threshold: 10
+        min_run: 50ms
+`)
+	wantOutput(t, pollLines(t, config, " off at "),
+		"🌡️  seed trays heater on at 7.7°C (pin 27 = 1)",
+		"🌡️  seed trays heater off at 14.0°C after 0s (pin 27 = 0)",
+	)
+}
+
+func TestClimateThermistor(t *testing.T) {
+	// A 10k NTC under a 100k resistor: ADC 35 falling from 1234 to 600 is
+	// -4.6°C to 13.3°C by its beta.
+	config := writeConfig(t, `
+profiles:
+  greenhouse:
+    name: esp32-test
+    poll_interval: 20ms
+    climate:
+      - name: seed trays
+        preset: frost
+        temperature:
+          pin: 35
+          thermistor: {model: beta, beta: 3950, r0: 10000, series_resistor: 100000}
+        output: 27
+        threshold: 10
+        min_run: 50ms
+`)
+	wantOutput(t, pollLines(t, config, " off at "),
+		"🌡️  seed trays heater on at -4.6°C (pin 27 = 1)",
+		"🌡️  seed trays heater off at 13.3°C after 0s (pin 27 = 0)",
+	)
+}
+
+// pollLines runs the greenhouse profile in config against the
+// emulator's ADC 35 falling from 1234 to 600 and returns its output up to
+// the first line containing stop.
+func pollLines(t *testing.T, config, stop string) string {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], "--config", config, "--profile", "greenhouse")
+	cmd.Env = append(os.Environ(), "ESP32_TEST_MAIN=1", "ESP32_TEST_CLIMATE=1")
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Wait()
+	defer cmd.Process.Signal(syscall.SIGINT)
+
+	var lines []string
+	scanner := bufio.NewScanner(stdout)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+		if strings.Contains(scanner.Text(), stop) {
+			break
+		}
+	}
+	go io.Copy(io.Discard, stdout)
+	return strings.Join(lines, "\n")
+}
+
+func TestAnomaly(t *testing.T) {
+	config := writeConfig(t, `
+profiles:
+  greenhouse:
+    name: esp32-test
+    poll_interval: 20ms
+    anomaly:
+      channels: [soil]
+      slots: 1
+      window: 10
+      warmup: 2
+      baseline_file: baselines.json
+    channels:
+      soil: 35
+    alerts:
+      - anomaly:soil>=4 -> print
+`)
+	// ADC 35 holds at 1234 and then steps to 600, far outside its
+	// baseline, which then learns the new level.
+	out := pollLines(t, config, "back to normal")
+	wantOutput(t, out,
+		"📈 Learning the baselines of 1 channel(s)",
+		"📈 Pin 35 (soil) anomalous: 600 is 634.0σ from its usual 1234 ± 1",
+		"🚨 anomaly:soil>=4 (pin 35 scored 634σ)",
+		"📈 Pin 35 (soil) back to normal after 0s",
+	)
+	if strings.Contains(out, "(pin 35 = ") {
+		t.Errorf("anomaly rule fired on a pin value:\n%s", out)
+	}
+
+	// The baselines are saved next to the config file for the next run.
+	data, err := os.ReadFile(filepath.Join(filepath.Dir(config), "baselines.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantOutput(t, string(data), `"device": "esp32-test"`, `"pin": 35`, `"mean": 1234`)
+	wantOutput(t, pollLines(t, config, "Loaded"), "📈 Loaded 1 baseline(s) from "+filepath.Join(filepath.Dir(config), "baselines.json"))
+}
+
+func TestAlerts(t *testing.T) {
+	out, ok := runCLI(t, "--name", "esp32-test",
+		"--alert", "pin35>1000 -> exec echo fired $ESP32_PIN=$ESP32_VALUE -> write 25=1",
+		"--alert", "pin32<100 -> write 26=1")
+	if !ok {
+		t.Fatalf("CLI failed:\n%s", out)
+	}
+	wantOutput(t, out,
+		"🚨 Watching 2 alert rule(s)",
+		"🚨 pin35>1000 (pin 35 = 1234)\nfired 35=1234\n✍️  Writing pin 25 = 1",
+	)
+	if strings.Contains(out, "pin32<100 (") {
+		t.Errorf("pin32<100 fired for 4095:\n%s", out)
+	}
+
+	out, ok = runCLI(t, "--name", "esp32-test", "--alert", "pin35>1000 -> beep")
+	if ok {
+		t.Fatalf("CLI succeeded with an unknown action:\n%s", out)
+	}
+	wantOutput(t, out, `unknown action "beep"`)
+}
+
+func TestPresetInstall(t *testing.T) {
+	config := writeConfig(t, "# my boards\nprofiles:\n  lab:\n    name: esp32-lab # bench board\n")
+	out, ok := runCLI(t, "preset", "install", "greenhouse", "--config", config, "--name", "esp32-test")
+	if !ok {
+		t.Fatalf("CLI failed:\n%s", out)
+	}
+	wantOutput(t, out, `✅ Installed the greenhouse preset as profile "greenhouse" in `+config)
+	data, err := os.ReadFile(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"# my boards", "name: esp32-lab # bench board", "  greenhouse:\n", "name: esp32-test", "pin35>2600 for 1m -> write 14=1"} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("config missing %q:\n%s", want, data)
+		}
+	}
+
+	if out, ok := runCLI(t, "preset", "install", "greenhouse", "--config", config); ok {
+		t.Fatalf("CLI replaced a profile without --force:\n%s", out)
+	}
+	if out, ok := runCLI(t, "preset", "install", "greenhouse", "--config", config, "--as", "lab", "--force"); !ok {
+		t.Fatalf("CLI failed:\n%s", out)
+	}
+	if out, ok := runCLI(t, "preset", "list"); !ok || !strings.Contains(out, "greenhouse") {
+		t.Errorf("preset list = %q, want greenhouse listed", out)
+	}
+}
+
+func TestReliableWrites(t *testing.T) {
+	// The firmware ignores pins it doesn't drive, so the read-back never
+	// shows pin 99 set.
+	out, ok := runCLI(t, "--name", "esp32-test", "--reliable", "--verify-writes", "--write-attempts", "2", "--alert", "pin35>1000 -> write 99=1")
+	if !ok {
+		t.Fatalf("CLI failed:\n%s", out)
+	}
+	wantOutput(t, out, "⚠️  pin35>1000: write failed: pin writes failed after 2 attempt(s): pin 99 (wrote 1, not reported)")
+
+	t.Setenv("ESP32_TEST_LOSE_WRITES", "2")
+	out, ok = runCLI(t, "--name", "esp32-test", "--reliable", "--verify-writes", "--alert", "pin35>1000 -> write 14=1")
+	if !ok {
+		t.Fatalf("CLI failed:\n%s", out)
+	}
+	wantOutput(t, out, "✍️  Writing pin 14 = 1")
+	if strings.Contains(out, "failed") {
+		t.Errorf("write failed although the third attempt gets through:\n%s", out)
+	}
+}
+
+func TestPresetExport(t *testing.T) {
+	config := writeConfig(t, `
+profiles:
+  bench:
+    name: esp32-bench
+    address: AA:BB:CC:DD:EE:09
+    alerts:
+      # Trip the relay when the pot is turned up.
+      - pin35>3000 -> write 14=1
+`)
+	template := filepath.Join(t.TempDir(), "bench.yaml")
+	out, ok := runCLI(t, "preset", "export", "bench", "--config", config, "--out", template, "--description", "Pot and relay")
+	if !ok {
+		t.Fatalf("CLI failed:\n%s", out)
+	}
+	data, err := os.ReadFile(template)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"description: Pot and relay", "default: esp32-bench", "name: ${name}", "# Trip the relay"} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("template missing %q:\n%s", want, data)
+		}
+	}
+	if strings.Contains(string(data), "AA:BB:CC:DD:EE:09") {
+		t.Errorf("template kept the board's address:\n%s", data)
+	}
+
+	out, ok = runCLI(t, "preset", "install", template, "--config", config, "--as", "copy", "--set", "name=esp32-test")
+	if !ok {
+		t.Fatalf("CLI failed:\n%s", out)
+	}
+	out, ok = runCLI(t, "--config", config, "--profile", "copy")
+	if !ok {
+		t.Fatalf("CLI failed:\n%s", out)
+	}
+	wantOutput(t, out, "✅ Found target device: esp32-test", "🚨 Watching 1 alert rule(s)")
+
+	for _, tc := range []struct{ args, want string }{
+		{"--set pin=3", `template has no parameter "pin"`},
+		{"--as copy", `already has a profile "copy"`},
+	} {
+		args := append([]string{"preset", "install", template, "--config", config}, strings.Fields(tc.args)...)
+		out, ok := runCLI(t, args...)
+		if ok {
+			t.Fatalf("%s: CLI succeeded, want failure:\n%s", tc.args, out)
+		}
+		wantOutput(t, out, tc.want)
+	}
+}
+
+func TestWiring(t *testing.T) {
+	config := writeConfig(t, `
+profiles:
+  greenhouse:
+    name: esp32-test
+    # Relabelled by the wiring command.
+    labels:
+      25: fan
+    alerts:
+      - pin34>3000 -> write 25=1
+`)
+	netlist := filepath.Join(t.TempDir(), "greenhouse.net")
+	err := os.WriteFile(netlist, []byte(`(export (version "E")
+  (components
+    (comp (ref "U1") (value "ESP32-WROOM-32E")
+      (libsource (lib "RF_Module") (part "ESP32-WROOM-32") (description "RF Module, ESP32-D0WD-V3 SoC")))
+    (comp (ref "R1") (value "10k")))
+  (nets
+    (net (code "1") (name "/VENT_FAN")
+      (node (ref "U1") (pin "10") (pinfunction "IO25") (pintype "bidirectional"))
+      (node (ref "R1") (pin "1") (pintype "passive")))
+    (net (code "2") (name "/sensors/AIR_TEMP")
+      (node (ref "U1") (pin "6") (pinfunction "IO34") (pintype "input")))
+    (net (code "3") (name "/SOIL")
+      (node (ref "U1") (pin "4") (pinfunction "SENSOR_VP") (pintype "input")))
+    (net (code "4") (name "Net-(U1-IO26)")
+      (node (ref "U1") (pin "11") (pinfunction "IO26") (pintype "bidirectional")))
+    (net (code "5") (name "GND")
+      (node (ref "U1") (pin "1") (pinfunction "GND") (pintype "power_in")))))
+`), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, ok := runCLI(t, "wiring", netlist, "--config", config, "--profile", "greenhouse")
+	if !ok {
+		t.Fatalf("CLI failed:\n%s", out)
+	}
+	wantOutput(t, out, "25    VENT_FAN\n", "34    AIR_TEMP\n", "36    SOIL\n", `✅ Set 3 pin label(s) of profile "greenhouse"`)
+	if strings.Contains(out, "26 ") {
+		t.Errorf("CLI labelled a net KiCad named:\n%s", out)
+	}
+	data, err := os.ReadFile(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"# Relabelled by the wiring command.", "25: VENT_FAN", "36: SOIL", "pin34>3000 -> write 25=1"} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("config missing %q:\n%s", want, data)
+		}
+	}
+	if strings.Contains(string(data), "25: fan") {
+		t.Errorf("config kept the old label:\n%s", data)
+	}
+
+	file := filepath.Join(t.TempDir(), "wiring.txt")
+	if err := os.WriteFile(file, []byte("# bench\nGPIO4  vent fan\n34: air temperature\nIO48 = status LED\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	out, ok = runCLI(t, "wiring", file)
+	if !ok {
+		t.Fatalf("CLI failed:\n%s", out)
+	}
+	wantOutput(t, out, "4     vent fan\n", "34    air temperature\n", "⚠️  status LED: the ESP32 has no GPIO48")
+	if out, ok := runCLI(t, "wiring", file, "--board", "esp32s3"); !ok || strings.Contains(out, "⚠️") {
+		t.Errorf("CLI warned of a pin the S3 has:\n%s", out)
+	}
+
+	if err := os.WriteFile(file, []byte("25 fan\nGPIO25 pump\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if out, ok := runCLI(t, "wiring", file); ok || !strings.Contains(out, "line 2: GPIO25 is wired more than once") {
+		t.Errorf("CLI accepted a pin wired twice:\n%s", out)
+	}
+}
+
+func TestNewProject(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "mydash")
+	out, ok := runCLI(t, "new-project", dir, "--module", "example.com/mydash")
+	if !ok {
+		t.Fatalf("CLI failed:\n%s", out)
+	}
+	library, err := filepath.Abs(".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantOutput(t, out, "✅ Created "+dir+" using the client library in "+library)
+	for name, want := range map[string][]string{
+		"go.mod":  {"module example.com/mydash", "replace bluetooth => " + library, "tinygo.org/x/bluetooth v"},
+		"main.go": {"client.SubscribeADC(", "func handleSample(r esp32.Reading)"},
+		"go.sum":  {"tinygo.org/x/bluetooth"},
+	} {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, w := range want {
+			if !strings.Contains(string(data), w) {
+				t.Errorf("%s missing %q:\n%s", name, w, data)
+			}
+		}
+	}
+
+	if out, ok := runCLI(t, "new-project", dir); ok || !strings.Contains(out, "already exists") {
+		t.Errorf("CLI wrote over a project:\n%s", out)
+	}
+	if out, ok := runCLI(t, "new-project", filepath.Join(t.TempDir(), "x"), "--library", t.TempDir()); ok || !strings.Contains(out, "failed to read the client library") {
+		t.Errorf("CLI accepted a library without a go.mod:\n%s", out)
+	}
+}
+
+func TestDemo(t *testing.T) {
+	out, ok := runCLI(t, "demo", "--timeout", "5")
+	if !ok {
+		t.Fatalf("CLI failed:\n%s", out)
+	}
+	// The demo board replaces the test's emulated ones.
+	wantOutput(t, out, "🎭 Demo mode", "connected to "+demoName, "Pin: 35", "Pin: 32")
+	if strings.Contains(out, "esp32-test") {
+		t.Errorf("demo reached the test's boards:\n%s", out)
+	}
+
+	out, ok = runCLI(t, "demo", "selftest", "--uuid", demoSelfTestUUID)
+	if !ok {
+		t.Fatalf("demo selftest failed:\n%s", out)
+	}
+	wantOutput(t, out, "Self-test passed")
+
+	out, ok = runCLI(t, "demo", "write", "--name", demoAddress, "--pin", "25=1")
+	if !ok {
+		t.Fatalf("demo write failed:\n%s", out)
+	}
+	wantOutput(t, out, "Wrote 1 pin(s)")
+}
+
+// pageMessagePatterns find the text web pages look up in their messages:
+// the title, elements marked data-msg, placeholders marked
+// data-msg-placeholder and the strings the script passes to t.
+var pageMessagePatterns = []*regexp.Regexp{
+	regexp.MustCompile(`<title>([^<]*)<`),
+	regexp.MustCompile(`<\w+[^>]*\bdata-msg\b[^>]*>([^<]*)<`),
+	regexp.MustCompile(`placeholder="([^"]*)"[^>]*\bdata-msg-placeholder\b`),
+	regexp.MustCompile(`\bt\("((?:[^"\\]|\\.)*)"`),
+}
+
+// catalogMessages returns the messages the tool prints through msg and
+// its web pages look up, which the English catalog must list.
+func catalogMessages(t *testing.T) []string {
+	t.Helper()
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseDir(fset, ".", func(fi os.FileInfo) bool {
+		return !strings.HasSuffix(fi.Name(), "_test.go")
+	}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Output with no words, like table rows, isn't translated.
+	words := regexp.MustCompile(`%[-+# 0-9.*\[\]]*[a-zA-Z%]`)
+	messages := map[string]bool{}
+	add := func(s string) {
+		if strings.ContainsFunc(words.ReplaceAllString(s, ""), unicode.IsLetter) {
+			messages[s] = true
+		}
+	}
+	for _, file := range pkgs["main"].Files {
+		ast.Inspect(file, func(n ast.Node) bool {
+			call, ok := n.(*ast.CallExpr)
+			if !ok {
+				return true
+			}
+			sel, ok := call.Fun.(*ast.SelectorExpr)
+			if !ok {
+				return true
+			}
+			// The REPL prints through its line editor.
+			switch recv := sel.X.(type) {
+			case *ast.Ident:
+				if recv.Name != "msg" {
+					return true
+				}
+			case *ast.SelectorExpr:
+				if recv.Sel.Name != "editor" {
+					return true
+				}
+			default:
+				return true
+			}
+			args := call.Args
+			switch sel.Sel.Name {
+			case "Printf", "Sprintf", "T":
+				args = args[:1]
+			case "Fprintf":
+				args = args[1:2]
+			case "Fprintln":
+				args = args[1:]
+			case "Println", "Print":
+			default:
+				return true
+			}
+			for _, arg := range args {
+				if s, ok := stringConstant(arg); ok {
+					add(s)
+				}
+			}
+			return true
+		})
+	}
+	for _, page := range [][]byte{editorPage, sharePage} {
+		for _, re := range pageMessagePatterns[:3] {
+			for _, m := range re.FindAllSubmatch(page, -1) {
+				add(html.UnescapeString(string(m[1])))
+			}
+		}
+		for _, m := range pageMessagePatterns[3].FindAllSubmatch(page, -1) {
+			s, err := strconv.Unquote(`"` + string(m[1]) + `"`)
+			if err != nil {
+				t.Fatal(err)
+			}
+			add(s)
+		}
+	}
+	return slices.Sorted(maps.Keys(messages))
+}
+
+// stringConstant returns the value of a string literal, or of literals
+// joined with +.
+func stringConstant(expr ast.Expr) (string, bool) {
+	switch e := expr.(type) {
+	case *ast.BasicLit:
+		if e.Kind != token.STRING {
+			return "", false
+		}
+		s, err := strconv.Unquote(e.Value)
+		return s, err == nil
+	case *ast.BinaryExpr:
+		x, ok := stringConstant(e.X)
+		if !ok || e.Op != token.ADD {
+			return "", false
+		}
+		y, ok := stringConstant(e.Y)
+		return x + y, ok
+	case *ast.ParenExpr:
+		return stringConstant(e.X)
+	}
+	return "", false
+}
+
+func TestEnglishCatalog(t *testing.T) {
+	english := locale.English()
+	want := catalogMessages(t)
+	for _, m := range want {
+		if english[m] != m {
+			t.Errorf("locale/catalogs/en.yaml is missing %q", m)
+		}
+	}
+	for m := range english {
+		if !slices.Contains(want, m) {
+			t.Errorf("locale/catalogs/en.yaml has %q, which is no longer printed", m)
+		}
+	}
+}
+
+func TestLocale(t *testing.T) {
+	home := t.TempDir()
+	dir := filepath.Join(home, ".esp32_interfaces_locale")
+	if err := os.Mkdir(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	catalog := `"Error: --name flag is required": "Fehler: --name fehlt"` + "\n" +
+		`"❌ %v\n": "❌ Fehler: %v\n"` + "\n"
+	if err := os.WriteFile(filepath.Join(dir, "de.yaml"), []byte(catalog), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	run := func(lang string, args ...string) string {
+		cmd := exec.Command(os.Args[0], args...)
+		cmd.Env = append(os.Environ(), "ESP32_TEST_MAIN=1", "HOME="+home, "LC_ALL=", "LC_MESSAGES=", "LANG="+lang)
+		out, _ := cmd.CombinedOutput()
+		return string(out)
+	}
+
+	// de_AT falls back to de; messages the catalog lacks stay English.
+	out := run("de_AT.UTF-8", "write")
+	wantOutput(t, out, "Fehler: --name fehlt", "Usage:")
+	out = run("de_DE.UTF-8", "write", "--name", "esp32-test")
+	wantOutput(t, out, "❌ Fehler: nothing to write")
+	if out := run("fr_FR.UTF-8", "write"); !strings.Contains(out, "Error: --name flag is required") {
+		t.Errorf("without a catalog, output isn't English:\n%s", out)
+	}
+
+	// A translation that would misformat its arguments is refused.
+	bad := `"✅ Wrote %d pin(s)\n": "✅ %s Pins geschrieben\n"` + "\n"
+	if err := os.WriteFile(filepath.Join(dir, "nl.yaml"), []byte(bad), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	wantOutput(t, run("nl_NL", "write"), "messages are in English", "Error: --name flag is required")
+
+	// Web pages get the translations of their text.
+	page := string(locale.NewPrinter(locale.Catalog{"Save": "Speichern", "Created %s": "%s erstellt"}).Page(editorPage))
+	wantOutput(t, page, `const messages = {"Created %s":"%s erstellt","Save":"Speichern"};`)
+}
+
+func TestExportImport(t *testing.T) {
+	oldHome, newHome, spaceDir := t.TempDir(), t.TempDir(), t.TempDir()
+	spaceJournal := filepath.Join(spaceDir, "lab-a.csv")
+	files := map[string]string{
+		filepath.Join(oldHome, ".esp32_interfaces.yaml"): `
+profiles:
+  lab:
+    name: esp32-test
+    calibrations:
+      35: {curve: soil.csv, unit: "%"}
+    anomaly:
+      channels: [35]
+      baseline_file: baselines.json
+spaces:
+  lab-a:
+    token: a
+    journal: ` + spaceJournal + `
+    devices: [esp32-test]
+`,
+		filepath.Join(oldHome, "soil.csv"):                            "raw,moisture\n3000,0\n1200,100\n",
+		filepath.Join(oldHome, ".esp32_interfaces_journal.csv"):       "timestamp,device,address,id,pin,value\n",
+		filepath.Join(oldHome, ".esp32_interfaces_scan.json"):         "[]\n",
+		filepath.Join(oldHome, ".esp32_interfaces_locale", "de.yaml"): `"Save": "Speichern"` + "\n",
+		spaceJournal: "timestamp,device,address,id,pin,value\n",
+	}
+	for path, data := range files {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	run := func(home string, args ...string) (string, bool) {
+		cmd := exec.Command(os.Args[0], args...)
+		cmd.Env = append(os.Environ(), "ESP32_TEST_MAIN=1", "HOME="+home)
+		out, err := cmd.CombinedOutput()
+		return string(out), err == nil
+	}
+
+	archive := filepath.Join(t.TempDir(), "lab.tar.gz")
+	out, ok := run(oldHome, "export", "--all", "--out", archive)
+	if !ok {
+		t.Fatalf("export failed:\n%s", out)
+	}
+	wantOutput(t, out, "– no baselines at "+filepath.Join(oldHome, "baselines.json"), "📦 Exported 6 file(s) to "+archive)
+	if out, ok := run(oldHome, "export", "--all", "--out", archive); ok || !strings.Contains(out, "file exists") {
+		t.Errorf("export replaced an archive:\n%s", out)
+	}
+
+	// On the new host everything lands where it was, relative to the
+	// home directory; the space journal, from elsewhere, only with --root.
+	os.Remove(spaceJournal)
+	out, ok = run(newHome, "import", archive)
+	if ok {
+		t.Fatalf("import wrote a file outside the home directory without --root:\n%s", out)
+	}
+	wantOutput(t, out, "❌ 1 file(s) were outside the home and config directories", "   "+filepath.ToSlash(spaceJournal))
+	out, ok = run(newHome, "import", archive, "--root", "/")
+	if !ok {
+		t.Fatalf("import failed:\n%s", out)
+	}
+	wantOutput(t, out, "📦 6 file(s) exported from", "✅ Imported 6 file(s)")
+	for path, want := range files {
+		path = strings.Replace(path, oldHome, newHome, 1)
+		if got, err := os.ReadFile(path); err != nil || string(got) != want {
+			t.Errorf("%s = %q, %v; want %q", path, got, err, want)
+		}
+	}
+
+	// Files already there are only replaced with --force.
+	out, ok = run(newHome, "import", archive, "--root", "/")
+	if ok {
+		t.Fatalf("import replaced files without --force:\n%s", out)
+	}
+	wantOutput(t, out, "❌ 6 file(s) already exist; give --force to replace them")
+	if out, ok := run(newHome, "import", archive, "--root", "/", "--force"); !ok {
+		t.Errorf("import --force failed:\n%s", out)
+	}
+}
+
+func TestImportRefusesHostileArchives(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "payload")
+	if err := os.WriteFile(src, []byte("ssh-ed25519 AAAA attacker\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	home, root := t.TempDir(), t.TempDir()
+	importArchive := func(files []localFile, args ...string) (string, bool) {
+		t.Helper()
+		archive := filepath.Join(t.TempDir(), "hostile.tar.gz")
+		f, err := os.Create(archive)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := writeArchive(f, files); err != nil {
+			t.Fatal(err)
+		}
+		f.Close()
+		cmd := exec.Command(os.Args[0], append([]string{"import", archive}, args...)...)
+		cmd.Env = append(os.Environ(), "ESP32_TEST_MAIN=1", "HOME="+home)
+		out, err := cmd.CombinedOutput()
+		return string(out), err == nil
+	}
+
+	for _, tc := range []struct {
+		name  string
+		files []localFile
+		want  string
+	}{
+		{"root without --root", []localFile{{"included", src, "root/etc/cron.d/x"}}, "❌ 1 file(s) were outside the home and config directories"},
+		{"ssh keys in home", []localFile{{"journal", src, "home/.ssh/authorized_keys"}}, `"home/.ssh/authorized_keys" can't be imported as a "journal" file`},
+		{"ssh keys next to the config", []localFile{{"curve", src, "config/.ssh/authorized_keys"}}, `"config/.ssh/authorized_keys" can't be imported as a "curve" file`},
+		{"config elsewhere", []localFile{{"config", src, "root/etc/passwd"}}, `"root/etc/passwd" can't be imported as a "config" file`},
+		{"unknown kind", []localFile{{"", src, "home/.bashrc"}}, `"home/.bashrc" can't be imported as a "" file`},
+		{"two configs", []localFile{{"config", src, "config/a.yaml"}, {"config", src, "config/b.yaml"}}, "2 config files, want one"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			out, ok := importArchive(tc.files, "--force")
+			if ok {
+				t.Fatalf("import succeeded:\n%s", out)
+			}
+			wantOutput(t, out, tc.want)
+		})
+	}
+	for _, name := range []string{".ssh", "a.yaml", "b.yaml", ".bashrc"} {
+		if _, err := os.Lstat(filepath.Join(home, name)); err == nil {
+			t.Errorf("a hostile archive wrote %s", name)
+		}
+	}
+
+	// With --root, files from elsewhere go under it, not executable.
+	out, ok := importArchive([]localFile{{"included", src, "root/etc/cron.d/x"}}, "--root", root)
+	if !ok {
+		t.Fatalf("import --root failed:\n%s", out)
+	}
+	info, err := os.Stat(filepath.Join(root, "etc", "cron.d", "x"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm()&0o111 != 0 {
+		t.Errorf("imported file mode = %v, want it not executable", info.Mode())
+	}
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"bluetooth/esp32"
+)
+
+// runMultiDevice scans for several boards at once, connects to each and
+// reads their ADC characteristics concurrently, one goroutine per board,
+// tagging every line with the board it came from. Boards are spread over
+// the pool's adapters. Every board is disconnected before it returns,
+// including when ctx is cancelled.
+func runMultiDevice(ctx context.Context, pool *esp32.Pool, names []string, timeout, poll time.Duration, phy esp32.PHY, logWriter *esp32.CSVWriter) {
+	msg.Printf("🔍 Scanning for %d Bluetooth devices: %v\n", len(names), names)
+	msg.Printf("⏱️  Timeout: %d seconds\n\n", int(timeout.Seconds()))
+
+	// Nothing is connected yet on the exit paths before the reads, so
+	// skipping this deferred Close there leaves nothing behind.
+	defer pool.Close()
+
+	if poll > 0 {
+		pollDevices(ctx, pool, names, timeout, poll, phy, logWriter)
+		return
+	}
+
+	// Share the boards out between the adapters up front; each adapter
+	// then scans for its own share.
+	groups := map[*esp32.Manager][]string{}
+	var releases []func()
+	for _, name := range names {
+		m, release, err := pool.Acquire()
+		if err != nil {
+			msg.Printf("❌ Cannot connect to %s: %v\n", name, err)
+			os.Exit(1)
+		}
+		groups[m] = append(groups[m], name)
+		releases = append(releases, release)
+	}
+
+	type found struct {
+		manager *esp32.Manager
+		result  esp32.ScanResult
+	}
+	var (
+		mu      sync.Mutex
+		results []found
+		missing []string
+		scanErr error
+		wg      sync.WaitGroup
+	)
+	for m, group := range groups {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res, err := m.FindDevices(ctx, group, timeout, printScanResult)
+			mu.Lock()
+			defer mu.Unlock()
+			var notFound *esp32.NotFoundError
+			switch {
+			case errors.As(err, &notFound):
+				missing = append(missing, notFound.Names...)
+			case err != nil:
+				scanErr = err
+			default:
+				for _, r := range res {
+					results = append(results, found{m, r})
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if ctx.Err() != nil {
+		msg.Println("\n🛑 Interrupted")
+		os.Exit(1)
+	}
+	if len(missing) > 0 {
+		msg.Printf("\n⏱️  Timeout: Device(s) %q not found after %d seconds\n", missing, int(timeout.Seconds()))
+		os.Exit(1)
+	}
+	if scanErr != nil {
+		msg.Printf("❌ %v\n", scanErr)
+		os.Exit(1)
+	}
+	msg.Println()
+
+	var failed sync.Map
+	for _, f := range results {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := readDevice(ctx, f.manager, f.result, phy, logWriter); err != nil {
+				msg.Printf("❌ [%s] %v\n", f.result.Name, err)
+				failed.Store(f.result.Address, true)
+			}
+		}()
+	}
+	wg.Wait()
+	for _, release := range releases {
+		release()
+	}
+	pool.Close()
+
+	failures := 0
+	failed.Range(func(any, any) bool {
+		failures++
+		return true
+	})
+	if failures > 0 {
+		os.Exit(1)
+	}
+}
+
+// pollDevices keeps a session per board, each reading the ADC
+// characteristic every poll interval and reconnecting when its link or the
+// adapter drops or the host wakes from sleep, until interrupted. A board
+// whose adapter fails reconnects through another one in the pool.
+func pollDevices(ctx context.Context, pool *esp32.Pool, names []string, timeout, poll time.Duration, phy esp32.PHY, logWriter *esp32.CSVWriter) {
+	sleep := esp32.NewSleepMonitor(ctx, sleepThreshold)
+	var wg sync.WaitGroup
+	for _, name := range names {
+		prefix := fmt.Sprintf("[%s] ", name)
+		session := &esp32.Session{
+			Pool:        pool,
+			Name:        name,
+			Profile:     profile,
+			Capture:     capture,
+			WritePolicy: writePolicy,
+			ScanTimeout: timeout,
+			Sleep:       sleep,
+			OnEvent: func(e esp32.SessionEvent) {
+				printSessionEvent(prefix, e)
+				logSessionGap(logWriter, e)
+				if metrics != nil {
+					metrics.ObserveSession(e)
+				}
+			},
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			session.Run(ctx, func(client *esp32.Client) error {
+				if err := pairClient(ctx, prefix, client); err != nil {
+					// Retrying would prompt again; leave this board out.
+					msg.Printf("❌ %s%v\n", prefix, err)
+					return esp32.ErrStopSession
+				}
+				requestPHY(ctx, prefix, client, phy)
+				for {
+					if err := readDeviceADC(ctx, client, logWriter); err != nil {
+						return err
+					}
+					if !sleepCtx(ctx, poll) {
+						return nil
+					}
+				}
+			})
+		}()
+	}
+	wg.Wait()
+	msg.Println("\n🔌 Disconnected")
+}
+
+// readDevice connects to one board and reads its ADC characteristic once.
+func readDevice(ctx context.Context, manager *esp32.Manager, result esp32.ScanResult, phy esp32.PHY, logWriter *esp32.CSVWriter) error {
+	client, err := manager.Connect(ctx, result)
+	if err != nil {
+		return err
+	}
+	client.SetProfile(profile)
+	client.SetWritePolicy(writePolicy)
+	if capture != nil {
+		client.Capture(capture)
+	}
+	msg.Printf("✅ [%s] Connected (Address: %s, RSSI: %d dBm)\n", result.Name, result.Address, result.RSSI)
+	prefix := fmt.Sprintf("[%s] ", result.Name)
+	if err := pairClient(ctx, prefix, client); err != nil {
+		return err
+	}
+	identifyClient(ctx, prefix, client)
+	requestPHY(ctx, prefix, client, phy)
+	return readDeviceADC(ctx, client, logWriter)
+}
+
+// readDeviceADC reads a board's ADC characteristic, printing readings
+// tagged with the board name and appending them to logWriter if set.
+func readDeviceADC(ctx context.Context, client *esp32.Client, logWriter *esp32.CSVWriter) error {
+	if metrics != nil {
+		// Deferred so failed reads show up in the error counters too.
+		defer metrics.ObserveCharacteristics(client)
+	}
+	readings, err := client.ReadADC(ctx)
+	if err != nil {
+		return err
+	}
+	for _, reading := range readings {
+		msg.Printf("✅ [%s] Pin: %s, Value: %d\n", reading.Device, pinLabel(reading), reading.Value)
+	}
+	if metrics != nil {
+		metrics.ObserveADC(readings)
+	}
+	if logWriter != nil {
+		if err := logWriter.Write(readings); err != nil {
+			return fmt.Errorf("failed to write log file: %w", err)
+		}
+	}
+	return observePins(ctx, fmt.Sprintf("[%s] ", client.Name), client, readings)
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"embed"
+	"errors"
+	"flag"
+	"fmt"
+	"go/format"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+// scaffold is the program new-project writes, as templates of the files
+// named before their .tmpl suffix.
+//
+//go:embed scaffold/*.tmpl
+var scaffold embed.FS
+
+// scaffoldData fills the scaffold's templates.
+type scaffoldData struct {
+	// Name is the program's name, the base of its directory.
+	Name   string
+	Module string
+	// Library is the directory of this module, which the program's
+	// go.mod replaces the client library with.
+	Library          string
+	GoVersion        string
+	BluetoothVersion string
+}
+
+// runNewProject writes a small Go program using the client library to
+// connect to a board, subscribe to its ADC readings and handle them, for
+// those who would rather embed the library than drive the CLI. The
+// library isn't published, so the program's go.mod points at its source
+// with a replace directive.
+func runNewProject(_ context.Context, args []string) {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		msg.Println("Usage: new-project DIR [flags]")
+		os.Exit(1)
+	}
+	dir := args[0]
+	fs := flag.NewFlagSet("new-project", flag.ExitOnError)
+	modulePtr := fs.String("module", filepath.Base(dir), "Module path of the new program")
+	libraryPtr := fs.String("library", "", "Directory of the client library's source, this tool's go.mod (default: found above the current directory)")
+	fs.Parse(args[1:])
+
+	library := *libraryPtr
+	if library == "" {
+		var err error
+		if library, err = findLibrary(); err != nil {
+			msg.Printf("❌ %v; give its directory with --library\n", err)
+			os.Exit(1)
+		}
+	}
+	files, err := renderScaffold(dir, *modulePtr, library)
+	if err != nil {
+		msg.Printf("❌ %v\n", err)
+		os.Exit(1)
+	}
+	if entries, err := os.ReadDir(dir); err == nil && len(entries) > 0 {
+		msg.Printf("❌ %s already exists and isn't empty\n", dir)
+		os.Exit(1)
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		msg.Printf("❌ Failed to create project: %v\n", err)
+		os.Exit(1)
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0o644); err != nil {
+			msg.Printf("❌ Failed to create project: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	msg.Printf("✅ Created %s using the client library in %s\n", dir, library)
+	msg.Printf("🚀 Run it with: cd %s && go run . --name esp32-ble\n", dir)
+}
+
+// renderScaffold returns the scaffold's files for a program in dir with
+// the given module path, using the library in the directory library. The
+// library's go.sum is copied, so the program builds straight away.
+func renderScaffold(dir, module, library string) (map[string][]byte, error) {
+	library, err := filepath.Abs(library)
+	if err != nil {
+		return nil, err
+	}
+	modFile, err := os.ReadFile(filepath.Join(library, "go.mod"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the client library: %w", err)
+	}
+	if modulePath(modFile) != "bluetooth" {
+		return nil, fmt.Errorf("%s is not the client library's directory", library)
+	}
+	data := scaffoldData{Name: filepath.Base(dir), Module: module, Library: library}
+	data.GoVersion, data.BluetoothVersion = goModVersions(modFile)
+
+	tmpl, err := template.ParseFS(scaffold, "scaffold/*.tmpl")
+	if err != nil {
+		return nil, err
+	}
+	files := map[string][]byte{}
+	for _, t := range tmpl.Templates() {
+		var buf bytes.Buffer
+		if err := t.Execute(&buf, data); err != nil {
+			return nil, err
+		}
+		name := strings.TrimSuffix(t.Name(), ".tmpl")
+		files[name] = buf.Bytes()
+		if filepath.Ext(name) == ".go" {
+			if files[name], err = format.Source(buf.Bytes()); err != nil {
+				return nil, fmt.Errorf("scaffold %s: %w", name, err)
+			}
+		}
+	}
+	if sum, err := os.ReadFile(filepath.Join(library, "go.sum")); err == nil {
+		files["go.sum"] = sum
+	}
+	return files, nil
+}
+
+// findLibrary returns the client library's directory if the current
+// directory is in it, as when working from a checkout.
+func findLibrary() (string, error) {
+	dir, err := os.Getwd()
+	if err != nil {
+		return "", err
+	}
+	for {
+		for _, candidate := range []string{dir, filepath.Join(dir, "esp32_ble", "go-script")} {
+			data, err := os.ReadFile(filepath.Join(candidate, "go.mod"))
+			if err == nil && modulePath(data) == "bluetooth" {
+				return candidate, nil
+			} else if err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return "", err
+			}
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", errors.New("the client library's source isn't in or above the current directory")
+		}
+		dir = parent
+	}
+}
+
+// modulePath returns the module path a go.mod declares.
+func modulePath(modFile []byte) string {
+	scanner := bufio.NewScanner(bytes.NewReader(modFile))
+	for scanner.Scan() {
+		if path, ok := strings.CutPrefix(strings.TrimSpace(scanner.Text()), "module "); ok {
+			return strings.Trim(strings.TrimSpace(path), `"`)
+		}
+	}
+	return ""
+}
+
+// goModVersions returns the Go version and tinygo.org/x/bluetooth version
+// a go.mod asks for.
+func goModVersions(modFile []byte) (goVersion, bluetooth string) {
+	scanner := bufio.NewScanner(bytes.NewReader(modFile))
+	for scanner.Scan() {
+		fields := strings.Fields(strings.TrimPrefix(strings.TrimSpace(scanner.Text()), "require "))
+		switch {
+		case len(fields) == 2 && fields[0] == "go":
+			goVersion = fields[1]
+		case len(fields) >= 2 && fields[0] == "tinygo.org/x/bluetooth":
+			bluetooth = fields[1]
+		}
+	}
+	return goVersion, bluetooth
+}
@@ -0,0 +1,196 @@
+// Package occupancy turns PIR motion sensor readings into occupancy, and
+// drives the usual "motion in the dark turns the light on until the room
+// is empty" automation from them and an ADC light level.
+package occupancy
+
+import (
+	"fmt"
+	"time"
+
+	"bluetooth/esp32"
+)
+
+// Sensor is a PIR motion sensor on a digital pin, reading nonzero while
+// it detects motion unless ActiveLow is set.
+type Sensor struct {
+	Name      string
+	Pin       uint8
+	ActiveLow bool
+	// Retrigger is how soon after one detection another counts as new
+	// motion rather than the same movement. PIR modules hold their output
+	// for a few seconds, so this keeps one walk past from being several.
+	Retrigger time.Duration
+	// Timeout is how long after the last motion the area counts as
+	// vacant.
+	Timeout time.Duration
+
+	// Light, if set, turns an output on while the area is occupied, but
+	// only if it was dark when motion started it.
+	Light *Light
+}
+
+// Light is a motion-activated output gated by an ADC light level.
+type Light struct {
+	// SensorPin is the ADC pin of the light sensor; the area is dark
+	// while it reads below DarkBelow. With no reading yet it counts as
+	// dark, so the light works before the sensor reports.
+	SensorPin uint8
+	DarkBelow int
+	// Output is the pin written OnState when the light turns on and 0
+	// when it turns off.
+	Output  uint8
+	OnState uint8
+}
+
+// Active reports whether value means the sensor detects motion.
+func (s Sensor) Active(value int) bool {
+	return (value != 0) != s.ActiveLow
+}
+
+// Kind is the kind of an Event.
+type Kind int
+
+const (
+	// Motion is a new detection, outside the Retrigger window of the
+	// last.
+	Motion Kind = iota + 1
+	// Occupied is the first motion after the area was vacant.
+	Occupied
+	// Vacant is Timeout passing without motion.
+	Vacant
+	// LightOn and LightOff are the Light output being switched.
+	LightOn
+	LightOff
+)
+
+func (k Kind) String() string {
+	switch k {
+	case Motion:
+		return "motion"
+	case Occupied:
+		return "occupied"
+	case Vacant:
+		return "vacant"
+	case LightOn:
+		return "light on"
+	case LightOff:
+		return "light off"
+	}
+	return fmt.Sprintf("Kind(%d)", int(k))
+}
+
+// Event is a change in a sensor's area. For LightOn and LightOff, Write
+// is what to send the board; for Vacant, Duration is how long the area
+// was occupied.
+type Event struct {
+	Sensor   Sensor
+	Kind     Kind
+	Time     time.Time
+	Device   string
+	Address  string
+	Write    esp32.PinWrite
+	Duration time.Duration
+}
+
+// board identifies one board's readings.
+type board struct {
+	device, address string
+}
+
+// state tracks one sensor on one board.
+type state struct {
+	active     bool      // output currently high
+	lastMotion time.Time // last reading with motion
+	lastNew    time.Time // last detection reported as Motion
+	occupied   time.Time // when the area became occupied; zero if vacant
+	lightOn    bool
+}
+
+// Tracker follows motion sensors and their light levels through readings.
+// Time is taken from the readings, so recorded data replays with its
+// original timing. Boards are tracked separately.
+type Tracker struct {
+	sensors []Sensor
+	states  map[board][]*state
+	light   map[board]map[uint8]int
+}
+
+// NewTracker returns a tracker for sensors.
+func NewTracker(sensors []Sensor) *Tracker {
+	return &Tracker{sensors: sensors, states: map[board][]*state{}, light: map[board]map[uint8]int{}}
+}
+
+// Update feeds a reading through the tracker and returns the events it
+// causes. Any reading from a board advances its sensors' timeouts, so
+// vacancy is noticed as long as the board is polled. Readings other than
+// pin values are ignored.
+func (t *Tracker) Update(r esp32.Reading) []Event {
+	if r.Kind != "" {
+		return nil
+	}
+	b := board{r.Device, r.Address}
+	states, ok := t.states[b]
+	if !ok {
+		for range t.sensors {
+			states = append(states, &state{})
+		}
+		t.states[b] = states
+		t.light[b] = map[uint8]int{}
+	}
+	for _, s := range t.sensors {
+		if s.Light != nil && s.Light.SensorPin == r.Pin {
+			t.light[b][r.Pin] = r.Value
+		}
+	}
+
+	var events []Event
+	emit := func(s Sensor, kind Kind) *Event {
+		events = append(events, Event{Sensor: s, Kind: kind, Time: r.Time, Device: r.Device, Address: r.Address})
+		return &events[len(events)-1]
+	}
+	for i, sensor := range t.sensors {
+		st := states[i]
+		if sensor.Pin == r.Pin {
+			active := sensor.Active(r.Value)
+			if active {
+				if !st.active && (st.lastNew.IsZero() || r.Time.Sub(st.lastNew) >= sensor.Retrigger) {
+					st.lastNew = r.Time
+					emit(sensor, Motion)
+					if !st.occupied.IsZero() {
+						t.lightOn(b, sensor, st, emit)
+					}
+				}
+				st.lastMotion = r.Time
+				if st.occupied.IsZero() {
+					st.occupied = r.Time
+					emit(sensor, Occupied)
+					t.lightOn(b, sensor, st, emit)
+				}
+			}
+			st.active = active
+		}
+		if !st.occupied.IsZero() && !st.active && r.Time.Sub(st.lastMotion) >= sensor.Timeout {
+			emit(sensor, Vacant).Duration = st.lastMotion.Sub(st.occupied)
+			st.occupied = time.Time{}
+			if st.lightOn {
+				st.lightOn = false
+				emit(sensor, LightOff).Write = esp32.PinWrite{PinNum: sensor.Light.Output, State: 0}
+			}
+		}
+	}
+	return events
+}
+
+// lightOn switches sensor's light on if it has one, it is off and the
+// area is dark.
+func (t *Tracker) lightOn(b board, sensor Sensor, st *state, emit func(Sensor, Kind) *Event) {
+	l := sensor.Light
+	if l == nil || st.lightOn {
+		return
+	}
+	if level, ok := t.light[b][l.SensorPin]; ok && level >= l.DarkBelow {
+		return
+	}
+	st.lightOn = true
+	emit(sensor, LightOn).Write = esp32.PinWrite{PinNum: l.Output, State: l.OnState}
+}
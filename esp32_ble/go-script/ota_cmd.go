@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"hash/crc32"
+	"os"
+	"time"
+
+	"bluetooth/esp32"
+)
+
+// otaProgress is what ota saves to <file>.progress as chunks are written.
+// Boards that keep a partial image report how much they have, but only
+// the chunk size it was sent in lets ota carry on numbering its chunks.
+type otaProgress struct {
+	// ID is the board's, so an upload resumes once its address has
+	// changed.
+	ID        string `json:"id"`
+	Size      int    `json:"size"`
+	CRC32     uint32 `json:"crc32"`
+	ChunkSize int    `json:"chunk_size"`
+	Sent      int    `json:"sent"`
+}
+
+// runOTA uploads a firmware image to a board over its OTA characteristics.
+// The stock firmware has no OTA service, so their UUIDs are given on the
+// command line to match whatever the board's OTA-capable build exposes.
+func runOTA(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("ota", flag.ExitOnError)
+	namePtr := fs.String("name", "", "Name of the Bluetooth device to update (required)")
+	timeoutPtr := fs.Int("timeout", 30, "Scan timeout in seconds")
+	filePtr := fs.String("file", "", "Firmware image (.bin) to upload (required)")
+	dataPtr := fs.String("data-uuid", "", "UUID of the OTA data characteristic (required)")
+	controlPtr := fs.String("control-uuid", "", "UUID of the OTA control characteristic (required)")
+	chunkPtr := fs.Int("chunk-size", 0, "Image bytes per write (0 to fit the negotiated MTU)")
+	progressFormat := progressFlags(fs)
+	fs.Parse(args)
+
+	for _, required := range []struct{ name, value string }{
+		{"name", *namePtr}, {"file", *filePtr}, {"data-uuid", *dataPtr}, {"control-uuid", *controlPtr},
+	} {
+		if required.value == "" {
+			msg.Printf("Error: --%s flag is required\n", required.name)
+			msg.Println("\nUsage:")
+			fs.PrintDefaults()
+			os.Exit(1)
+		}
+	}
+
+	image, err := os.ReadFile(*filePtr)
+	if err != nil {
+		msg.Printf("❌ Failed to read firmware image: %v\n", err)
+		os.Exit(1)
+	}
+
+	client := connectDevice(ctx, *namePtr, time.Duration(*timeoutPtr)*time.Second)
+	progress := otaProgress{ID: client.ID, Size: len(image), CRC32: crc32.ChecksumIEEE(image), ChunkSize: *chunkPtr}
+	progressPath := *filePtr + ".progress"
+	var saved otaProgress
+	resuming := loadJSON(progressPath, &saved) && saved.ID == progress.ID &&
+		saved.Size == progress.Size && saved.CRC32 == progress.CRC32
+	if resuming {
+		progress.ChunkSize = saved.ChunkSize
+	}
+	if progress.ChunkSize <= 0 {
+		mtu, err := client.MTU(ctx)
+		if err != nil {
+			msg.Printf("❌ Failed to read MTU: %v\n", err)
+			client.Disconnect()
+			os.Exit(1)
+		}
+		progress.ChunkSize = esp32.OTAChunkSize(mtu)
+	}
+	opts := esp32.OTAOptions{
+		DataUUID:    *dataPtr,
+		ControlUUID: *controlPtr,
+		ChunkSize:   progress.ChunkSize,
+	}
+	if resuming {
+		// Only the board knows which chunks really arrived.
+		offset, err := client.ResumableOTAOffset(ctx, image, opts)
+		if err != nil {
+			msg.Printf("⚠️  Can't resume, starting over: %v\n", err)
+		} else if offset > 0 {
+			opts.Offset = offset
+			msg.Printf("⏯️  Resuming upload at %d of %d bytes\n", offset, len(image))
+		}
+	}
+
+	msg.Printf("📦 Uploading %s (%d bytes)\n", *filePtr, len(image))
+	start := time.Now()
+	bar := progressFormat("ota")
+	opts.Progress = func(sent, total int) {
+		progress.Sent = sent
+		saveJSON(progressPath, progress)
+		bar.update(sent, total)
+	}
+	err = client.UploadFirmware(ctx, image, opts)
+	bar.end(err)
+	client.Disconnect()
+	if ctx.Err() != nil {
+		msg.Println("🛑 Interrupted; run again to resume if the board keeps partial images")
+		os.Exit(1)
+	}
+	if err != nil {
+		msg.Printf("❌ Upload failed: %v\n", err)
+		os.Exit(1)
+	}
+	os.Remove(progressPath)
+	msg.Printf("✅ Uploaded %d bytes in %s; the board will verify the CRC32 and restart\n",
+		len(image), time.Since(start).Round(time.Millisecond))
+}
@@ -0,0 +1,161 @@
+// Package pinmodel describes the GPIOs of the ESP32 family's variants,
+// which can't drive an output, which are latched at reset and which ADC,
+// touch and DAC channels they have, so pin maps and writes can be checked
+// before they reach a board.
+package pinmodel
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"maps"
+	"path"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+//go:embed models/*.yaml
+var models embed.FS
+
+// Model is a chip's pins.
+type Model struct {
+	// Name is what the model is looked up by, e.g. esp32.
+	Name string `yaml:"name"`
+	// Title is the chip's name for people, e.g. ESP32.
+	Title string `yaml:"title"`
+	// Modules are common modules and boards built on the chip.
+	Modules string  `yaml:"modules"`
+	GPIOs   []uint8 `yaml:"gpios"`
+	// InputOnly pins have no output driver.
+	InputOnly []uint8 `yaml:"input_only"`
+	// Strapping pins are latched at reset, by what they select.
+	Strapping map[uint8]string `yaml:"strapping"`
+	// Flash pins are wired to the module's SPI flash.
+	Flash []uint8 `yaml:"flash"`
+	// ADC1 is the channel of each pin ADC1 samples.
+	ADC1 map[uint8]int `yaml:"adc1"`
+	// ADC2 is the channel of each pin ADC2 samples. ADC2 is shared with
+	// the Wi-Fi radio and can't be read while it is on.
+	ADC2 map[uint8]int `yaml:"adc2"`
+	// Touch is the channel of each capacitive touch pin.
+	Touch map[uint8]int `yaml:"touch"`
+	// DAC is the channel of each DAC output pin; most variants have none.
+	DAC map[uint8]int `yaml:"dac"`
+}
+
+// Use is what a pin map or write does with a pin.
+type Use int
+
+const (
+	// Output drives the pin.
+	Output Use = iota
+	// DigitalInput reads the pin's level.
+	DigitalInput
+	// AnalogInput samples the pin with an ADC.
+	AnalogInput
+)
+
+// Names returns the names of the embedded models.
+func Names() []string {
+	entries, _ := models.ReadDir("models")
+	var names []string
+	for _, e := range entries {
+		names = append(names, strings.TrimSuffix(e.Name(), ".yaml"))
+	}
+	return names
+}
+
+// Lookup returns the embedded model called name.
+func Lookup(name string) (*Model, error) {
+	data, err := models.ReadFile(path.Join("models", name+".yaml"))
+	if err != nil {
+		return nil, fmt.Errorf("unknown board model %q (want %s)", name, strings.Join(Names(), ", "))
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	var m Model
+	if err := dec.Decode(&m); err != nil {
+		return nil, fmt.Errorf("board model %q: %w", name, err)
+	}
+	return &m, nil
+}
+
+// Default returns the model of the chip the stock firmware is built for.
+func Default() *Model {
+	m, err := Lookup("esp32")
+	if err != nil {
+		panic(err)
+	}
+	return m
+}
+
+// Check returns what is wrong with using pin as use on the chip, if
+// anything. Pin numbers the chip doesn't have are only a problem for
+// outputs: firmware may report other sensors' values as pins of its own
+// numbering.
+func (m *Model) Check(pin uint8, use Use) []string {
+	var problems []string
+	if !slices.Contains(m.GPIOs, pin) {
+		if use == Output {
+			problems = append(problems, fmt.Sprintf("the %s has no GPIO%d", m.Title, pin))
+		}
+		return problems
+	}
+	if slices.Contains(m.Flash, pin) {
+		problems = append(problems, fmt.Sprintf("GPIO%d is wired to the %s's SPI flash; using it crashes the board", pin, m.Title))
+	}
+	switch use {
+	case Output:
+		if slices.Contains(m.InputOnly, pin) {
+			problems = append(problems, fmt.Sprintf("GPIO%d is input-only on the %s and can't be driven", pin, m.Title))
+		}
+		if what, ok := m.Strapping[pin]; ok {
+			problems = append(problems, fmt.Sprintf("GPIO%d is a strapping pin (%s); driving it during reset can stop the board booting", pin, what))
+		}
+	case AnalogInput:
+		if _, ok := m.ADC2[pin]; ok {
+			problems = append(problems, fmt.Sprintf("GPIO%d is on ADC2, which can't be read while Wi-Fi is on", pin))
+		} else if _, ok := m.ADC1[pin]; !ok {
+			problems = append(problems, fmt.Sprintf("GPIO%d has no ADC channel on the %s", pin, m.Title))
+		}
+	}
+	return problems
+}
+
+// ADCPins returns the pins either ADC samples, in order.
+func (m *Model) ADCPins() []uint8 {
+	pins := slices.Collect(maps.Keys(m.ADC1))
+	pins = slices.AppendSeq(pins, maps.Keys(m.ADC2))
+	slices.Sort(pins)
+	return pins
+}
+
+// Features returns what pin can do and what to watch out for, e.g.
+// "ADC2_CH8" and "DAC1" for the ESP32's GPIO25.
+func (m *Model) Features(pin uint8) []string {
+	var features []string
+	if ch, ok := m.ADC1[pin]; ok {
+		features = append(features, fmt.Sprintf("ADC1_CH%d", ch))
+	}
+	if ch, ok := m.ADC2[pin]; ok {
+		features = append(features, fmt.Sprintf("ADC2_CH%d", ch))
+	}
+	if ch, ok := m.Touch[pin]; ok {
+		features = append(features, fmt.Sprintf("TOUCH%d", ch))
+	}
+	if ch, ok := m.DAC[pin]; ok {
+		features = append(features, fmt.Sprintf("DAC%d", ch))
+	}
+	if slices.Contains(m.InputOnly, pin) {
+		features = append(features, "input-only")
+	}
+	if what, ok := m.Strapping[pin]; ok {
+		features = append(features, fmt.Sprintf("strapping (%s)", what))
+	}
+	if slices.Contains(m.Flash, pin) {
+		features = append(features, "SPI flash")
+	}
+	return features
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"time"
+
+	"bluetooth/esp32"
+)
+
+// runPolling reads a board's ADC characteristic every poll interval until
+// ctx is cancelled. Dropped connections and adapter power loss (rfkill,
+// suspend) are waited out and the board reconnected rather than exiting,
+// through another of the pool's adapters if there is one.
+func runPolling(ctx context.Context, pool *esp32.Pool, name string, timeout, poll time.Duration, phy esp32.PHY, logWriter *esp32.CSVWriter) {
+	msg.Printf("🔍 Scanning for Bluetooth device: \"%s\"\n", name)
+	msg.Printf("⏱️  Timeout: %d seconds\n\n", int(timeout.Seconds()))
+
+	first := true
+	session := &esp32.Session{
+		Pool:        pool,
+		Name:        name,
+		Profile:     profile,
+		Capture:     capture,
+		WritePolicy: writePolicy,
+		ScanTimeout: timeout,
+		Seen:        printScanResult,
+		Sleep:       esp32.NewSleepMonitor(ctx, sleepThreshold),
+		OnEvent: func(e esp32.SessionEvent) {
+			printSessionEvent("", e)
+			logSessionGap(logWriter, e)
+			if metrics != nil {
+				metrics.ObserveSession(e)
+			}
+			if e.Kind == esp32.SessionConnected && first {
+				first = false
+				printServices(e.Client)
+			}
+		},
+	}
+	missing := false
+	var pairErr error
+	session.Run(ctx, func(client *esp32.Client) error {
+		if _, err := client.Characteristic(profile.ADCOutputUUID); err != nil {
+			missing = true
+			return esp32.ErrStopSession
+		}
+		if pairErr = pairClient(ctx, "", client); pairErr != nil {
+			return esp32.ErrStopSession
+		}
+		requestPHY(ctx, "", client, phy)
+		for {
+			// A frame the decoder rejects is reported by the session
+			// and doesn't drop the connection.
+			var unknown *esp32.UnknownPayloadError
+			if err := readADC(ctx, client, logWriter); err != nil && !errors.As(err, &unknown) {
+				return err
+			}
+			if !sleepCtx(ctx, poll) {
+				return nil
+			}
+		}
+	})
+	if pairErr != nil && ctx.Err() == nil {
+		msg.Printf("❌ %v\n", pairErr)
+		os.Exit(1)
+	}
+	if missing {
+		msg.Printf("\n❌ Characteristic %s not found (see list above for what the device exposes)\n", profile.ADCOutputUUID)
+		os.Exit(1)
+	}
+	msg.Println("\n🔌 Disconnected")
+}
+
+// sleepCtx waits for d, returning false if ctx is done first.
+func sleepCtx(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// printSessionEvent reports a session's connection and adapter state
+// changes, prefixing lines with prefix (e.g. "[name] ").
+func printSessionEvent(prefix string, e esp32.SessionEvent) {
+	switch e.Kind {
+	case esp32.SessionConnected:
+		msg.Printf("\n✅ %sConnected to %s (Address: %s)\n", prefix, e.Client.Name, e.Client.Address)
+		if e.Client.ID != e.Client.Address {
+			msg.Printf("🪪 %s%s is %s\n", prefix, e.Client.Name, e.Client.ID)
+		}
+	case esp32.SessionDisconnected:
+		msg.Printf("⚠️  %sConnection to %s lost: %v, reconnecting...\n", prefix, e.Name, e.Err)
+	case esp32.SessionRetry:
+		msg.Printf("⚠️  %sCould not connect to %s: %v, retrying...\n", prefix, e.Name, e.Err)
+	case esp32.SessionAdapterOff:
+		msg.Printf("⚠️  %sBluetooth adapter powered off, waiting for it to return...\n", prefix)
+	case esp32.SessionAdapterOn:
+		msg.Printf("✅ %sBluetooth adapter is back, re-enabling\n", prefix)
+	case esp32.SessionSuspending:
+		msg.Printf("💤 %sHost is going to sleep, disconnecting\n", prefix)
+	case esp32.SessionResumed:
+		msg.Printf("⏰ %sHost woke after %v, reconnecting\n", prefix, e.Gap.Round(time.Second))
+	case esp32.SessionIdle:
+		msg.Printf("💤 %sNo activity on %s, disconnecting until it is needed\n", prefix, e.Name)
+	case esp32.SessionWoken:
+		msg.Printf("🔔 %s%s is needed again, reconnecting\n", prefix, e.Name)
+	case esp32.SessionUnidentified:
+		msg.Printf("⚠️  %sCould not identify %s: %v; its readings are kept under its address\n", prefix, e.Client.Name, e.Err)
+	case esp32.SessionUnknownPayload:
+		var unknown *esp32.UnknownPayloadError
+		if errors.As(e.Err, &unknown) {
+			printUnknownPayload(prefix, unknown)
+		}
+	}
+}
+
+// printUnknownPayload reports a frame the profile's decoder rejected or
+// salvaged, with a hex dump so the format can be worked out.
+func printUnknownPayload(prefix string, err *esp32.UnknownPayloadError) {
+	if err.Salvaged > 0 {
+		msg.Printf("⚠️  %sSalvaged %d reading(s) from an inconsistent payload from %s: %v\n", prefix, err.Salvaged, err.UUID, err.Err)
+	} else {
+		msg.Printf("❓ %sUnknown payload from %s: %v; is the profile's decoder right for this firmware?\n", prefix, err.UUID, err.Err)
+	}
+	for _, line := range strings.Split(strings.TrimSuffix(err.Hexdump(), "\n"), "\n") {
+		msg.Printf("   %s\n", line)
+	}
+}
+
+// sleepThreshold is how far the wall clock must jump past the monotonic
+// clock to count as the host having slept.
+const sleepThreshold = 5 * time.Second
+
+// logSessionGap records a resume in the CSV log so the missing data is
+// visible rather than looking like a quiet period.
+func logSessionGap(logWriter *esp32.CSVWriter, e esp32.SessionEvent) {
+	if logWriter == nil || e.Kind != esp32.SessionResumed {
+		return
+	}
+	start := time.Now().Add(-e.Gap)
+	if err := logWriter.WriteGap(start, e.Client.Name, e.Client.Address, e.Client.ID); err != nil {
+		msg.Printf("⚠️  Failed to write log file: %v\n", err)
+	}
+}
@@ -0,0 +1,358 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"embed"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// presets are ready-made device profiles for common builds, installed
+// into the config file by the preset command to be customised there.
+//
+//go:embed presets/*.yaml
+var presets embed.FS
+
+// presetTemplate is the shareable profile format of presets and of
+// preset export:
+//
+//	description: Soil moisture probe and pump relay
+//	parameters:
+//	  soil_pin:
+//	    description: ADC pin of the soil moisture probe
+//	    default: 35
+//	profile:
+//	  name: ${name}
+//	  alerts:
+//	    - pin${soil_pin}>2600 for 1m -> write 14=1
+//
+// ${parameter} anywhere in the file is replaced by the parameter's value
+// before the profile is read, so parameters can stand for any part of a
+// value. Parameters without a default must be given on install.
+type presetTemplate struct {
+	Description string                     `yaml:"description"`
+	Parameters  map[string]presetParameter `yaml:"parameters"`
+	Profile     yaml.Node                  `yaml:"profile"`
+}
+
+type presetParameter struct {
+	Description string  `yaml:"description"`
+	Default     *string `yaml:"default"`
+}
+
+// presetReference matches a ${parameter} in a template.
+var presetReference = regexp.MustCompile(`\$\{(\w+)\}`)
+
+// presetNames returns the names of the built-in presets.
+func presetNames() []string {
+	entries, _ := presets.ReadDir("presets")
+	var names []string
+	for _, e := range entries {
+		names = append(names, strings.TrimSuffix(e.Name(), ".yaml"))
+	}
+	return names
+}
+
+func runPreset(_ context.Context, args []string) {
+	if len(args) == 0 {
+		msg.Println("Usage: preset list | preset show NAME|FILE | preset install NAME|FILE [flags] | preset export PROFILE [flags]")
+		os.Exit(1)
+	}
+	if len(args) < 2 && args[0] != "list" {
+		msg.Printf("Usage: preset %s NAME\n", args[0])
+		os.Exit(1)
+	}
+	switch args[0] {
+	case "list":
+		for _, name := range presetNames() {
+			t, err := parsePresetTemplate(readPreset(name))
+			if err != nil {
+				msg.Printf("❌ Preset %s: %v\n", name, err)
+				os.Exit(1)
+			}
+			msg.Printf("%-12s %s\n", name, t.Description)
+		}
+	case "show":
+		os.Stdout.Write(readPreset(args[1]))
+	case "install":
+		runPresetInstall(args[1], args[2:])
+	case "export":
+		runPresetExport(args[1], args[2:])
+	default:
+		msg.Printf("❌ Unknown preset command %q (want list, show, install or export)\n", args[0])
+		os.Exit(1)
+	}
+}
+
+// readPreset returns the named built-in preset, or the template file at
+// source if it names a file, exiting if there is neither.
+func readPreset(source string) []byte {
+	if data, err := os.ReadFile(source); err == nil {
+		return data
+	} else if strings.ContainsRune(source, filepath.Separator) || filepath.Ext(source) != "" {
+		msg.Printf("❌ Failed to read template: %v\n", err)
+		os.Exit(1)
+	}
+	data, err := presets.ReadFile(path.Join("presets", source+".yaml"))
+	if err != nil {
+		msg.Printf("❌ No preset %q (have %s)\n", source, strings.Join(presetNames(), ", "))
+		os.Exit(1)
+	}
+	return data
+}
+
+func parsePresetTemplate(data []byte) (*presetTemplate, error) {
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	var t presetTemplate
+	if err := dec.Decode(&t); err != nil {
+		return nil, err
+	}
+	if t.Profile.Kind != yaml.MappingNode {
+		return nil, errors.New("template has no profile")
+	}
+	return &t, nil
+}
+
+// renderPreset fills a template's parameters from values, falling back
+// to their defaults, and returns its profile.
+func renderPreset(data []byte, values map[string]string) (*yaml.Node, error) {
+	t, err := parsePresetTemplate(data)
+	if err != nil {
+		return nil, err
+	}
+	for name := range values {
+		if _, ok := t.Parameters[name]; !ok {
+			return nil, fmt.Errorf("template has no parameter %q", name)
+		}
+	}
+	resolved := map[string]string{}
+	for name, p := range t.Parameters {
+		if v, ok := values[name]; ok {
+			resolved[name] = v
+		} else if p.Default != nil {
+			resolved[name] = *p.Default
+		} else {
+			return nil, fmt.Errorf("parameter %q is required (--set %s=...)", name, name)
+		}
+	}
+	var undefined []string
+	expanded := presetReference.ReplaceAllFunc(data, func(ref []byte) []byte {
+		name := string(ref[2 : len(ref)-1])
+		v, ok := resolved[name]
+		if !ok {
+			undefined = append(undefined, name)
+		}
+		return []byte(v)
+	})
+	if len(undefined) > 0 {
+		return nil, fmt.Errorf("template uses undefined parameter(s) %s", strings.Join(undefined, ", "))
+	}
+	t, err = parsePresetTemplate(expanded)
+	if err != nil {
+		return nil, err
+	}
+	return &t.Profile, nil
+}
+
+// runPresetInstall adds a preset to the config file as a profile, keeping
+// what is already there, comments included.
+func runPresetInstall(source string, args []string) {
+	fs := flag.NewFlagSet("preset install", flag.ExitOnError)
+	configPtr := fs.String("config", "", "Config file to install into (default ~/"+defaultConfigName+")")
+	asPtr := fs.String("as", strings.TrimSuffix(filepath.Base(source), filepath.Ext(source)), "Name of the profile to install the preset as")
+	devicePtr := fs.String("name", "", "Bluetooth name of your board, if not the preset's")
+	forcePtr := fs.Bool("force", false, "Replace a profile of the same name")
+	values := map[string]string{}
+	fs.Func("set", "Template parameter as name=value (repeatable)", func(s string) error {
+		name, value, ok := strings.Cut(s, "=")
+		if !ok {
+			return errors.New("want name=value")
+		}
+		values[name] = value
+		return nil
+	})
+	fs.Parse(args)
+	path := configPath(*configPtr)
+
+	profile, err := renderPreset(readPreset(source), values)
+	if err != nil {
+		msg.Printf("❌ %s: %v\n", source, err)
+		os.Exit(1)
+	}
+	data, err := installPreset(path, profile, *asPtr, *devicePtr, *forcePtr)
+	if err != nil {
+		msg.Printf("❌ %v\n", err)
+		os.Exit(1)
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		msg.Printf("❌ Failed to write config file: %v\n", err)
+		os.Exit(1)
+	}
+	msg.Printf("✅ Installed the %s preset as profile %q in %s\n", source, *asPtr, path)
+	msg.Printf("📝 Edit it there to match your build, then run with --profile %s\n", *asPtr)
+}
+
+// readConfigNode returns the config file at path as a YAML document,
+// keeping its comments, or an empty document if there is no file.
+func readConfigNode(path string) (*yaml.Node, error) {
+	var doc yaml.Node
+	existing, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+	if err := yaml.Unmarshal(existing, &doc); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if doc.Kind == 0 {
+		doc = yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode}}}
+	}
+	if doc.Content[0].Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("%s: not a mapping", path)
+	}
+	return &doc, nil
+}
+
+// installPreset returns the config file at path with profile added as
+// as, its name set to device if given. The result is checked like any
+// config file before being returned.
+func installPreset(path string, profile *yaml.Node, as, device string, force bool) ([]byte, error) {
+	doc, err := readConfigNode(path)
+	if err != nil {
+		return nil, err
+	}
+	root := doc.Content[0]
+	profiles := mappingValue(root, "profiles")
+	if profiles == nil {
+		profiles = &yaml.Node{Kind: yaml.MappingNode}
+		root.Content = append(root.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: "profiles"}, profiles)
+	}
+
+	key := &yaml.Node{Kind: yaml.ScalarNode, Value: as}
+	if device != "" {
+		if name := mappingValue(profile, "name"); name != nil {
+			name.Value = device
+		} else {
+			profile.Content = append(profile.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: "name"}, &yaml.Node{Kind: yaml.ScalarNode, Value: device})
+		}
+	}
+	if i := mappingIndex(profiles, as); i >= 0 {
+		if !force {
+			return nil, fmt.Errorf("%s already has a profile %q (use --force to replace it or --as to pick another name)", path, as)
+		}
+		profiles.Content[i], profiles.Content[i+1] = key, profile
+	} else {
+		profiles.Content = append(profiles.Content, key, profile)
+	}
+
+	data, err := encodeYAML(doc)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := parseConfig(path, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// runPresetExport writes a profile from the config file as a template
+// others can install, its board's name made a parameter and its address,
+// which only fits the one board, left out.
+func runPresetExport(name string, args []string) {
+	fs := flag.NewFlagSet("preset export", flag.ExitOnError)
+	configPtr := fs.String("config", "", "Config file to export from (default ~/"+defaultConfigName+")")
+	outPtr := fs.String("out", "", "File to write the template to (default stdout)")
+	descriptionPtr := fs.String("description", "", "One-line description of the build")
+	fs.Parse(args)
+	path := configPath(*configPtr)
+
+	data, err := exportPreset(path, name, *descriptionPtr)
+	if err != nil {
+		msg.Printf("❌ %v\n", err)
+		os.Exit(1)
+	}
+	if *outPtr == "" {
+		os.Stdout.Write(data)
+		return
+	}
+	if err := os.WriteFile(*outPtr, data, 0o644); err != nil {
+		msg.Printf("❌ Failed to write template: %v\n", err)
+		os.Exit(1)
+	}
+	msg.Printf("✅ Exported profile %q to %s (install it with: preset install %s)\n", name, *outPtr, *outPtr)
+}
+
+// exportPreset returns the named profile of the config file at path as a
+// template.
+func exportPreset(path, name, description string) ([]byte, error) {
+	if _, err := readConfig(path); err != nil {
+		return nil, err
+	}
+	doc, err := readConfigNode(path)
+	if err != nil {
+		return nil, err
+	}
+	var profile *yaml.Node
+	if profiles := mappingValue(doc.Content[0], "profiles"); profiles != nil {
+		profile = mappingValue(profiles, name)
+	}
+	if profile == nil {
+		return nil, fmt.Errorf("profile %q not found in %s", name, path)
+	}
+
+	t := presetTemplate{
+		Description: description,
+		Parameters:  map[string]presetParameter{},
+		Profile:     *profile,
+	}
+	t.Profile.Content = slices.Clone(profile.Content)
+	if i := mappingIndex(&t.Profile, "address"); i >= 0 {
+		t.Profile.Content = slices.Delete(t.Profile.Content, i, i+2)
+	}
+	if i := mappingIndex(&t.Profile, "name"); i >= 0 {
+		current := t.Profile.Content[i+1].Value
+		t.Parameters["name"] = presetParameter{Description: "Bluetooth name of the board", Default: &current}
+		t.Profile.Content[i+1] = &yaml.Node{Kind: yaml.ScalarNode, Value: "${name}"}
+	}
+	return encodeYAML(&t)
+}
+
+// encodeYAML encodes v with the two-space indent of the config file.
+func encodeYAML(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// mappingIndex returns the index in m.Content of key's key node, or -1.
+func mappingIndex(m *yaml.Node, key string) int {
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		if m.Content[i].Value == key {
+			return i
+		}
+	}
+	return -1
+}
+
+// mappingValue returns the value of key in m, or nil.
+func mappingValue(m *yaml.Node, key string) *yaml.Node {
+	if i := mappingIndex(m, key); i >= 0 {
+		return m.Content[i+1]
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// progressEvent is one line of --progress json output, for UIs wrapping
+// the CLI to draw their own progress bars. Event is progress while the
+// operation runs, then done or failed, with Error set for failed. ETA is
+// left out until there is a rate to estimate it from.
+type progressEvent struct {
+	Op      string   `json:"op"`
+	Event   string   `json:"event"`
+	Bytes   int      `json:"bytes"`
+	Total   int      `json:"total"`
+	Percent int      `json:"percent"`
+	Elapsed float64  `json:"elapsed_seconds"`
+	ETA     *float64 `json:"eta_seconds,omitempty"`
+	Error   string   `json:"error,omitempty"`
+}
+
+// progressReporter shows a long operation's progress, as a bar on stdout
+// or as progressEvents on stderr.
+type progressReporter struct {
+	op    string
+	json  *json.Encoder // nil for the bar
+	start time.Time
+
+	first       int // bytes done before this run, e.g. resumed
+	started     bool
+	bytes       int
+	total       int
+	lastPercent int
+}
+
+// progressFlags adds --progress to fs, returning a function that, once
+// fs is parsed, starts a reporter for the named operation.
+func progressFlags(fs *flag.FlagSet) func(op string) *progressReporter {
+	format := fs.String("progress", "bar", "How to show progress: bar, or json for one JSON event per line on stderr")
+	return func(op string) *progressReporter {
+		p := &progressReporter{op: op, start: time.Now(), lastPercent: -1}
+		switch *format {
+		case "bar":
+		case "json":
+			p.json = json.NewEncoder(os.Stderr)
+		default:
+			msg.Printf("❌ Unknown progress format %q (want bar or json)\n", *format)
+			os.Exit(1)
+		}
+		return p
+	}
+}
+
+// update reports done out of total bytes. Output is only written when
+// the percentage moves, so large transfers don't flood a log with
+// thousands of identical lines.
+func (p *progressReporter) update(done, total int) {
+	if !p.started {
+		p.first, p.started = done, true
+	}
+	p.bytes, p.total = done, total
+	percent := done * 100 / total
+	if percent == p.lastPercent {
+		return
+	}
+	p.lastPercent = percent
+	if p.json == nil {
+		msg.Printf("\r%s", progressBar(done, total))
+		return
+	}
+	p.emit("progress", nil)
+}
+
+// end finishes the display once the operation returns err.
+func (p *progressReporter) end(err error) {
+	if p.json == nil {
+		msg.Println()
+		return
+	}
+	if err != nil {
+		p.emit("failed", err)
+		return
+	}
+	p.emit("done", nil)
+}
+
+func (p *progressReporter) emit(event string, err error) {
+	elapsed := time.Since(p.start).Seconds()
+	e := progressEvent{Op: p.op, Event: event, Bytes: p.bytes, Total: p.total, Elapsed: elapsed}
+	if p.total > 0 {
+		e.Percent = p.bytes * 100 / p.total
+	}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	if sent := p.bytes - p.first; event == "progress" && sent > 0 && elapsed > 0 {
+		eta := float64(p.total-p.bytes) / (float64(sent) / elapsed)
+		e.ETA = &eta
+	}
+	p.json.Encode(e)
+}
+
+// progressBarWidth is the progress bar's width in characters.
+const progressBarWidth = 30
+
+// progressBar renders sent out of total bytes as a fixed-width bar.
+func progressBar(sent, total int) string {
+	filled := sent * progressBarWidth / total
+	return fmt.Sprintf("[%s%s] %3d%% (%d/%d bytes)",
+		strings.Repeat("█", filled), strings.Repeat("░", progressBarWidth-filled),
+		sent*100/total, sent, total)
+}
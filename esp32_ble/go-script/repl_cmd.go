@@ -0,0 +1,376 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"bluetooth/esp32"
+	"bluetooth/pinmodel"
+	"bluetooth/rules"
+)
+
+// replCommands are the REPL's command words, for help and completion.
+var replCommands = []string{"help", "read", "write", "expect", "sleep", "subscribe", "unsubscribe", "mtu", "stats", "quit"}
+
+// repl is an interactive session over a single open connection.
+type repl struct {
+	client *esp32.Client
+	editor *lineEditor
+
+	mu   sync.Mutex
+	pins []uint8 // pins seen in pin data frames, for completion
+}
+
+// runREPL keeps the connection open and executes commands read from stdin
+// until quit, end of input or ctx is cancelled.
+func runREPL(ctx context.Context, client *esp32.Client) {
+	r := &repl{client: client}
+	r.editor = newLineEditor("esp32> ", r.complete)
+	defer r.editor.Close()
+
+	// Learn the board's pins for completion.
+	if readings, err := client.ReadPins(ctx); err == nil {
+		r.learn(readings)
+	}
+
+	// Lines are read on another goroutine, one per request, so a signal
+	// can end the REPL while it is waiting for input.
+	type input struct {
+		line string
+		err  error
+	}
+	next := make(chan struct{})
+	lines := make(chan input, 1)
+	go func() {
+		for range next {
+			line, err := r.editor.ReadLine()
+			lines <- input{line, err}
+		}
+	}()
+	defer close(next)
+
+	r.editor.Printf("\n💬 Interactive mode. Type \"help\" for commands.\n")
+	for {
+		next <- struct{}{}
+		var in input
+		select {
+		case <-ctx.Done():
+			r.editor.Printf("\n🛑 Interrupted\n")
+			r.editor.Printf("👋 Done!\n")
+			return
+		case in = <-lines:
+		}
+		if errors.Is(in.err, io.EOF) {
+			break
+		}
+		if in.err != nil {
+			r.editor.Printf("❌ %v\n", in.err)
+			break
+		}
+		if quit := r.exec(ctx, strings.Fields(in.line)); quit {
+			break
+		}
+	}
+	r.editor.Printf("👋 Done!\n")
+}
+
+// exec runs one command, reporting whether the REPL should exit.
+func (r *repl) exec(ctx context.Context, args []string) bool {
+	quit, err := r.run(ctx, args)
+	if err != nil {
+		r.editor.Printf("❌ %v\n", err)
+	}
+	return quit
+}
+
+// run runs one command, returning whether it was quit and its error.
+func (r *repl) run(ctx context.Context, args []string) (quit bool, err error) {
+	if len(args) == 0 {
+		return false, nil
+	}
+	switch args[0] {
+	case "help":
+		r.editor.Printf("Commands:\n" +
+			"  read adc|pins [--max-age <duration>|--no-cache]\n" +
+			"                             read a characteristic once, or reuse a read that\n" +
+			"                             recent (default: the profile's read_max_age)\n" +
+			"  write <pin> <state> ...    write pin states (digital: 100 = high)\n" +
+			"  expect adc|pin <pin> <op> <value>\n" +
+			"                             read and check a pin, e.g. expect pin 14 == 100\n" +
+			"                             (pins may be given by the profile's channel names)\n" +
+			"  sleep <duration>           wait, e.g. sleep 500ms\n" +
+			"  subscribe adc|pins         print notifications as they arrive\n" +
+			"  unsubscribe adc|pins       stop printing notifications\n" +
+			"  mtu                        show the negotiated MTU\n" +
+			"  stats                      show notification and characteristic stats\n" +
+			"  quit                       disconnect and exit\n")
+	case "read":
+		err = r.read(ctx, args[1:])
+	case "write":
+		err = r.write(ctx, args[1:])
+	case "expect":
+		err = r.expect(ctx, args[1:])
+	case "sleep":
+		err = sleep(ctx, args[1:])
+	case "subscribe":
+		err = r.subscribe(args[1:])
+	case "unsubscribe":
+		var uuid string
+		if uuid, err = r.characteristicArg(args[1:]); err == nil {
+			err = r.client.Unsubscribe(uuid)
+		}
+	case "mtu":
+		var mtu int
+		if mtu, err = r.client.MTU(ctx); err == nil {
+			r.editor.Printf("📏 MTU: %d bytes\n", mtu)
+		}
+	case "stats":
+		s := r.client.NotifyStats()
+		r.editor.Printf("📊 %d notification(s), %d byte(s), %.2f/s; %d delivered, %d dropped, %d throttled, %d undecodable\n",
+			s.Notifications, s.Bytes, s.Rate(), s.Delivered, s.Dropped, s.Throttled, s.Undecodable)
+		for _, c := range r.client.CharacteristicStats() {
+			r.editor.Printf("   %s: %d read(s) avg %.1f B, %d write(s) avg %.1f B, %d notification(s) avg %.1f B, %.1f%% errors\n",
+				c.UUID, c.Reads, c.AvgRead(), c.Writes, c.AvgWrite(), c.Notifications, c.AvgNotification(), c.ErrorRate()*100)
+		}
+	case "quit", "exit":
+		return true, nil
+	default:
+		err = fmt.Errorf("unknown command %q (try \"help\")", args[0])
+	}
+	return false, err
+}
+
+// characteristicArg maps "adc" or "pins" to its characteristic UUID in
+// the client's profile.
+func (r *repl) characteristicArg(args []string) (string, error) {
+	if len(args) == 1 {
+		switch args[0] {
+		case "adc":
+			return r.client.Profile().ADCOutputUUID, nil
+		case "pins":
+			return r.client.Profile().PinOutputUUID, nil
+		}
+	}
+	return "", errors.New("expected adc or pins")
+}
+
+// read reads adc or pins, settling for a reading as old as --max-age,
+// or the profile's read max age for the characteristic. --no-cache
+// always reads the board, for a scripting loop's checks that must see
+// the pins as they are.
+func (r *repl) read(ctx context.Context, args []string) error {
+	usage := errors.New("usage: read adc|pins [--max-age <duration>|--no-cache]")
+	var which []string
+	var maxAge time.Duration
+	given := false
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "--no-cache":
+			maxAge, given = 0, true
+		case "--max-age":
+			if i++; i == len(args) {
+				return usage
+			}
+			d, err := time.ParseDuration(args[i])
+			if err != nil || d < 0 {
+				return fmt.Errorf("invalid max age %q", args[i])
+			}
+			maxAge, given = d, true
+		default:
+			which = append(which, args[i])
+		}
+	}
+	uuid, err := r.characteristicArg(which)
+	if err != nil {
+		return usage
+	}
+	if !given {
+		maxAge = r.client.Profile().ReadMaxAge[uuid]
+	}
+	readings, err := r.client.ReadShared(ctx, uuid, maxAge)
+	if err != nil {
+		return err
+	}
+	if uuid == r.client.Profile().PinOutputUUID {
+		r.learn(readings)
+	}
+	r.print(readings)
+	return nil
+}
+
+func (r *repl) write(ctx context.Context, args []string) error {
+	if len(args) == 0 || len(args)%2 != 0 {
+		return errors.New("usage: write <pin> <state> [<pin> <state> ...]")
+	}
+	var writes []esp32.PinWrite
+	for i := 0; i < len(args); i += 2 {
+		pin, err := r.pin(args[i])
+		if err != nil {
+			return err
+		}
+		state, err := strconv.ParseUint(args[i+1], 10, 8)
+		if err != nil {
+			return fmt.Errorf("invalid state %q", args[i+1])
+		}
+		for _, problem := range pinModel.Check(pin, pinmodel.Output) {
+			r.editor.Printf("⚠️  %s\n", problem)
+		}
+		writes = append(writes, esp32.PinWrite{PinNum: pin, State: uint8(state)})
+	}
+	if err := r.client.WritePins(ctx, writes); err != nil {
+		return err
+	}
+	r.editor.Printf("✅ Wrote %d pin(s)\n", len(writes))
+	return nil
+}
+
+// pin parses a pin given by number or by the name of one of the
+// profile's channels.
+func (r *repl) pin(s string) (uint8, error) {
+	if pin, ok := r.client.Profile().ChannelPin(s); ok {
+		return pin, nil
+	}
+	pin, err := strconv.ParseUint(s, 10, 8)
+	if err != nil {
+		return 0, fmt.Errorf("invalid pin %q", s)
+	}
+	return uint8(pin), nil
+}
+
+// expect reads a pin and checks its value, e.g. "pin 14 == 100" or
+// "adc 35 > 1000".
+func (r *repl) expect(ctx context.Context, args []string) error {
+	if len(args) != 4 || (args[0] != "adc" && args[0] != "pin") {
+		return errors.New("usage: expect adc|pin <pin> <op> <value>")
+	}
+	pin, err := r.pin(args[1])
+	if err != nil {
+		return err
+	}
+	rule, err := rules.Parse(fmt.Sprintf("pin%d%s%s", pin, args[2], args[3]))
+	if err != nil {
+		return err
+	}
+	var readings []esp32.Reading
+	if args[0] == "adc" {
+		readings, err = r.client.ReadADC(ctx)
+	} else {
+		readings, err = r.client.ReadPins(ctx)
+	}
+	if err != nil {
+		return err
+	}
+	i := slices.IndexFunc(readings, func(reading esp32.Reading) bool { return reading.Pin == rule.Pin })
+	if i < 0 {
+		return fmt.Errorf("expected %s %d %s %d, but the board doesn't report it", args[0], rule.Pin, rule.Op, rule.Threshold)
+	}
+	if value := readings[i].Value; !rule.Match(value) {
+		return fmt.Errorf("expected %s %d %s %d, got %d", args[0], rule.Pin, rule.Op, rule.Threshold, value)
+	}
+	r.editor.Printf("✅ %s %d = %d\n", args[0], rule.Pin, readings[i].Value)
+	return nil
+}
+
+// sleep waits for the duration in args, or until ctx is done.
+func sleep(ctx context.Context, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: sleep <duration>")
+	}
+	d, err := time.ParseDuration(args[0])
+	if err != nil {
+		return err
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(d):
+		return nil
+	}
+}
+
+func (r *repl) subscribe(args []string) error {
+	uuid, err := r.characteristicArg(args)
+	if err != nil {
+		return err
+	}
+	if uuid == r.client.Profile().ADCOutputUUID {
+		err = r.client.SubscribeADC(r.print)
+	} else {
+		err = r.client.SubscribePins(func(readings []esp32.Reading) {
+			r.learn(readings)
+			r.print(readings)
+		})
+	}
+	if err != nil {
+		return err
+	}
+	r.editor.Printf("🔔 Subscribed to %s\n", args[0])
+	return nil
+}
+
+func (r *repl) print(readings []esp32.Reading) {
+	for _, reading := range readings {
+		r.editor.Printf("✅ Pin: %s, Value: %d\n", pinLabel(reading), reading.Value)
+	}
+}
+
+// learn records the pins present in a pin data frame.
+func (r *repl) learn(readings []esp32.Reading) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, reading := range readings {
+		if !slices.Contains(r.pins, reading.Pin) {
+			r.pins = append(r.pins, reading.Pin)
+		}
+	}
+	slices.Sort(r.pins)
+}
+
+// complete returns candidates for the last word of line.
+func (r *repl) complete(line string) []string {
+	words := strings.Fields(line)
+	if strings.HasSuffix(line, " ") || len(words) == 0 {
+		words = append(words, "")
+	}
+	word := words[len(words)-1]
+
+	var options []string
+	switch {
+	case len(words) == 1:
+		options = replCommands
+	case words[0] == "read" && len(words) == 3:
+		options = []string{"--max-age", "--no-cache"}
+	case words[0] == "read" || words[0] == "subscribe" || words[0] == "unsubscribe":
+		if len(words) == 2 {
+			options = []string{"adc", "pins"}
+		}
+	case words[0] == "expect":
+		if len(words) == 2 {
+			options = []string{"adc", "pin"}
+		}
+	case words[0] == "write" && len(words)%2 == 0:
+		// Pin positions; states are free-form.
+		r.mu.Lock()
+		for _, pin := range r.pins {
+			options = append(options, strconv.Itoa(int(pin)))
+		}
+		r.mu.Unlock()
+		options = append(options, slices.Sorted(maps.Keys(r.client.Profile().Channels))...)
+	}
+
+	var matches []string
+	for _, option := range options {
+		if strings.HasPrefix(option, word) {
+			matches = append(matches, option)
+		}
+	}
+	return matches
+}
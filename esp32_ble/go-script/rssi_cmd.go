@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"os"
+	"time"
+
+	"bluetooth/esp32"
+)
+
+// runMonitorRSSI connects to a board and reports the connection's signal
+// strength every interval until interrupted, to check where boards can be
+// placed. Readings go to the same CSV log as pin data, with kind "rssi".
+func runMonitorRSSI(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("monitor-rssi", flag.ExitOnError)
+	namePtr := fs.String("name", "", "Name of the Bluetooth device to monitor (required)")
+	timeoutPtr := fs.Int("timeout", 30, "Scan timeout in seconds")
+	intervalPtr := fs.Duration("interval", 2*time.Second, "How often to read the RSSI")
+	minRSSIPtr := fs.Int("min-rssi", 0, "Ignore devices advertising weaker than this many dBm (0 for no limit)")
+	logFilePtr := fs.String("log-file", "", "Append RSSI readings to this CSV file")
+	scan := scanFlags(fs)
+	fs.Parse(args)
+
+	if *namePtr == "" {
+		msg.Println("Error: --name flag is required")
+		msg.Println("\nUsage:")
+		fs.PrintDefaults()
+		os.Exit(1)
+	}
+
+	var logWriter *esp32.CSVWriter
+	if *logFilePtr != "" {
+		logWriter = openLogFile(*logFilePtr, esp32.CSVKindColumns)
+	}
+
+	a := scan(adapter)
+	if *minRSSIPtr != 0 {
+		a = esp32.FilterRSSI(a, int16(*minRSSIPtr))
+	}
+	msg.Printf("🔍 Scanning for Bluetooth device: \"%s\"\n", *namePtr)
+	session := &esp32.Session{
+		Manager:     esp32.NewManager(a),
+		Name:        *namePtr,
+		ScanTimeout: time.Duration(*timeoutPtr) * time.Second,
+		OnEvent: func(e esp32.SessionEvent) {
+			printSessionEvent("", e)
+		},
+	}
+	var unsupported error
+	session.Run(ctx, func(client *esp32.Client) error {
+		for {
+			reading, err := client.ReadRSSI(ctx)
+			if errors.Is(err, esp32.ErrRSSIUnsupported) {
+				unsupported = err
+				return esp32.ErrStopSession
+			}
+			if err != nil {
+				return err
+			}
+			msg.Printf("📶 [%s] RSSI: %d dBm (%s)\n", reading.Device, reading.Value, signalQuality(reading.Value))
+			if logWriter != nil {
+				if err := logWriter.Write([]esp32.Reading{reading}); err != nil {
+					msg.Printf("⚠️  Failed to write log file: %v\n", err)
+				}
+			}
+			if !sleepCtx(ctx, *intervalPtr) {
+				return nil
+			}
+		}
+	})
+	if unsupported != nil {
+		msg.Printf("❌ %v\n", unsupported)
+		os.Exit(1)
+	}
+	msg.Println("\n🔌 Disconnected")
+}
+
+// signalQuality describes an RSSI in rough placement terms.
+func signalQuality(rssi int) string {
+	switch {
+	case rssi >= -60:
+		return "excellent"
+	case rssi >= -70:
+		return "good"
+	case rssi >= -80:
+		return "fair"
+	default:
+		return "weak"
+	}
+}
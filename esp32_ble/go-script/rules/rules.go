@@ -0,0 +1,257 @@
+// Package rules evaluates threshold rules against pin readings and
+// describes the actions to take when they fire.
+package rules
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"bluetooth/esp32"
+)
+
+// Rule fires when a pin's value satisfies a comparison, optionally only
+// after the condition has held continuously for a duration. Expr is the
+// condition without the actions. Kind is the kind of the pin's readings
+// compared, empty for its values and esp32.KindAnomaly for its anomaly
+// scores.
+type Rule struct {
+	Expr      string
+	Kind      string
+	Pin       uint8
+	Op        string
+	Threshold int
+	For       time.Duration
+	Actions   []Action
+}
+
+// ActionKind is what an Action does.
+type ActionKind string
+
+const (
+	// Print reports the alert; alerts are always reported, so this is
+	// only for spelling it out.
+	Print ActionKind = "print"
+	// Exec runs Command with the shell.
+	Exec ActionKind = "exec"
+	// Write sends Write to the board the reading came from.
+	Write ActionKind = "write"
+	// MQTT publishes the alert to Topic.
+	MQTT ActionKind = "mqtt"
+)
+
+// Action is something to do when a rule fires.
+type Action struct {
+	Kind    ActionKind
+	Command string
+	Write   esp32.PinWrite
+	Topic   string
+}
+
+// writeExpr matches a write action's argument, e.g. "25=1" or
+// "vent-fan=1".
+var writeExpr = regexp.MustCompile(`^(\d+|[A-Za-z][\w-]*)\s*=\s*(\d+)$`)
+
+// Channels name pins, as esp32.Profile's Channels do, for rules to use
+// in place of pin numbers.
+type Channels map[string]uint8
+
+// pin returns the pin s names: a number or one of the channels.
+func (c Channels) pin(s string) (uint8, error) {
+	if pin, ok := c[s]; ok {
+		return pin, nil
+	}
+	if s == "" || !unicode.IsDigit(rune(s[0])) {
+		return 0, fmt.Errorf("unknown channel %q", s)
+	}
+	pin, err := strconv.ParseUint(s, 10, 8)
+	return uint8(pin), err
+}
+
+// ParseAction parses an action: print, exec CMD, write PIN=STATE or mqtt
+// TOPIC.
+func ParseAction(s string) (Action, error) {
+	return ParseActionWith(s, nil)
+}
+
+// ParseActionWith is ParseAction with the pin of a write given by number
+// or channel name, as in "write vent-fan=1".
+func ParseActionWith(s string, channels Channels) (Action, error) {
+	kind, arg, _ := strings.Cut(strings.TrimSpace(s), " ")
+	arg = strings.TrimSpace(arg)
+	a := Action{Kind: ActionKind(kind)}
+	switch a.Kind {
+	case Print:
+		if arg != "" {
+			return Action{}, fmt.Errorf("print takes no argument, got %q", arg)
+		}
+	case Exec:
+		a.Command = arg
+		if arg == "" {
+			return Action{}, errors.New("exec needs a command")
+		}
+	case Write:
+		m := writeExpr.FindStringSubmatch(arg)
+		if m == nil {
+			return Action{}, fmt.Errorf("invalid write %q (want e.g. write 25=1)", arg)
+		}
+		pin, err := channels.pin(m[1])
+		if err != nil {
+			return Action{}, fmt.Errorf("invalid pin in write %q: %w", arg, err)
+		}
+		state, err := strconv.ParseUint(m[2], 10, 8)
+		if err != nil {
+			return Action{}, fmt.Errorf("invalid state in write %q: %w", arg, err)
+		}
+		a.Write = esp32.PinWrite{PinNum: pin, State: uint8(state)}
+	case MQTT:
+		a.Topic = arg
+		if arg == "" || strings.ContainsAny(arg, "+#") {
+			return Action{}, fmt.Errorf("invalid mqtt topic %q", arg)
+		}
+	default:
+		return Action{}, fmt.Errorf("unknown action %q (want print, exec, write or mqtt)", kind)
+	}
+	return a, nil
+}
+
+// ruleExpr matches e.g. "pin34>3000", "pin14 == 100 for 5s", with a
+// channel name, "air-temp>3000" or, on anomaly scores, "anomaly:pin34>=4".
+var ruleExpr = regexp.MustCompile(`^(?:(anomaly):)?(?:pin(\d+)|([A-Za-z][\w-]*))\s*(>=|<=|==|!=|>|<)\s*(-?\d+)(?:\s+for\s+(\S+))?$`)
+
+// Parse parses a rule expression such as "pin34>3000 for 10s", optionally
+// followed by actions each introduced by "->", as in
+// "pin34>3000 -> write 25=1 -> mqtt greenhouse/alerts". An exec command
+// therefore can't contain "->". A condition prefixed with "anomaly:", as
+// in "anomaly:pin34>=4 for 10m", compares the pin's anomaly scores rather
+// than its values.
+func Parse(expr string) (Rule, error) {
+	return ParseWith(expr, nil)
+}
+
+// ParseWith is Parse with pins also given by channel name, in the
+// condition and in write actions, as in
+// "air-temp>3000 -> write vent-fan=1". Names are resolved to pins here,
+// so a rule follows its channel to whatever pin the profile gives it.
+func ParseWith(expr string, channels Channels) (Rule, error) {
+	expr, actions, hasActions := strings.Cut(expr, "->")
+	expr = strings.TrimSpace(expr)
+	m := ruleExpr.FindStringSubmatch(expr)
+	if m == nil {
+		return Rule{}, fmt.Errorf("invalid rule %q (want e.g. pin34>3000 or pin14==100 for 5s)", expr)
+	}
+	pin, err := channels.pin(cmp.Or(m[2], m[3]))
+	if err != nil {
+		return Rule{}, fmt.Errorf("invalid pin in rule %q: %w", expr, err)
+	}
+	threshold, err := strconv.Atoi(m[5])
+	if err != nil {
+		return Rule{}, fmt.Errorf("invalid threshold in rule %q: %w", expr, err)
+	}
+	rule := Rule{Expr: expr, Kind: m[1], Pin: pin, Op: m[4], Threshold: threshold}
+	if m[6] != "" {
+		rule.For, err = time.ParseDuration(m[6])
+		if err != nil {
+			return Rule{}, fmt.Errorf("invalid duration in rule %q: %w", expr, err)
+		}
+	}
+	if hasActions {
+		for _, s := range strings.Split(actions, "->") {
+			action, err := ParseActionWith(s, channels)
+			if err != nil {
+				return Rule{}, fmt.Errorf("rule %q: %w", expr, err)
+			}
+			rule.Actions = append(rule.Actions, action)
+		}
+	}
+	return rule, nil
+}
+
+// Match reports whether value satisfies the rule's comparison.
+func (r Rule) Match(value int) bool {
+	switch r.Op {
+	case ">":
+		return value > r.Threshold
+	case ">=":
+		return value >= r.Threshold
+	case "<":
+		return value < r.Threshold
+	case "<=":
+		return value <= r.Threshold
+	case "==":
+		return value == r.Threshold
+	case "!=":
+		return value != r.Threshold
+	}
+	return false
+}
+
+// Alert records a rule firing.
+type Alert struct {
+	Rule    Rule
+	Reading esp32.Reading
+}
+
+// state tracks a rule between readings.
+type state struct {
+	since  time.Time // when the condition started holding; zero if not holding
+	active bool      // already fired for the current run of matches
+}
+
+// board identifies one board's readings.
+type board struct {
+	device, address string
+}
+
+// Engine evaluates a set of rules. Time is taken from the readings
+// themselves, so recorded data replays with its original timing. Boards
+// are tracked separately.
+type Engine struct {
+	rules  []Rule
+	states map[board][]*state
+}
+
+// NewEngine returns an engine evaluating rules.
+func NewEngine(rules []Rule) *Engine {
+	return &Engine{rules: rules, states: map[board][]*state{}}
+}
+
+// Evaluate feeds a reading through the engine and returns the alerts that
+// fire as a result. A rule fires once each time its condition becomes true
+// (and has held for its For duration), and re-arms when it becomes false.
+// Readings are only compared by rules of their kind.
+func (e *Engine) Evaluate(reading esp32.Reading) []Alert {
+	var alerts []Alert
+	b := board{reading.Device, reading.Address}
+	states, ok := e.states[b]
+	if !ok {
+		for range e.rules {
+			states = append(states, &state{})
+		}
+		e.states[b] = states
+	}
+	for i, rule := range e.rules {
+		s := states[i]
+		if rule.Kind != reading.Kind || rule.Pin != reading.Pin {
+			continue
+		}
+		if !rule.Match(reading.Value) {
+			s.since = time.Time{}
+			s.active = false
+			continue
+		}
+		if s.since.IsZero() {
+			s.since = reading.Time
+		}
+		if !s.active && reading.Time.Sub(s.since) >= rule.For {
+			s.active = true
+			alerts = append(alerts, Alert{Rule: rule, Reading: reading})
+		}
+	}
+	return alerts
+}
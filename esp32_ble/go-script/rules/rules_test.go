@@ -0,0 +1,238 @@
+package rules_test
+
+import (
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+
+	"bluetooth/esp32"
+	"bluetooth/rules"
+)
+
+func TestParse(t *testing.T) {
+	for _, tc := range []struct {
+		expr string
+		want rules.Rule
+		err  bool
+	}{
+		{expr: "pin34>3000", want: rules.Rule{Expr: "pin34>3000", Pin: 34, Op: ">", Threshold: 3000}},
+		{expr: " pin14 == 100 for 5s ", want: rules.Rule{Expr: "pin14 == 100 for 5s", Pin: 14, Op: "==", Threshold: 100, For: 5 * time.Second}},
+		{expr: "pin0<=-5", want: rules.Rule{Expr: "pin0<=-5", Pin: 0, Op: "<=", Threshold: -5}},
+		{expr: "pin255!=0 for 1m30s", want: rules.Rule{Expr: "pin255!=0 for 1m30s", Pin: 255, Op: "!=", Threshold: 0, For: 90 * time.Second}},
+		{expr: "anomaly:pin34>=4 for 10m", want: rules.Rule{Expr: "anomaly:pin34>=4 for 10m", Kind: esp32.KindAnomaly, Pin: 34, Op: ">=", Threshold: 4, For: 10 * time.Minute}},
+		{expr: "pin34>3000 for 10s -> write 25=1 -> mqtt greenhouse/alerts", want: rules.Rule{
+			Expr: "pin34>3000 for 10s", Pin: 34, Op: ">", Threshold: 3000, For: 10 * time.Second,
+			Actions: []rules.Action{
+				{Kind: rules.Write, Write: esp32.PinWrite{PinNum: 25, State: 1}},
+				{Kind: rules.MQTT, Topic: "greenhouse/alerts"},
+			},
+		}},
+		{expr: "pin34>3000->print", want: rules.Rule{Expr: "pin34>3000", Pin: 34, Op: ">", Threshold: 3000, Actions: []rules.Action{{Kind: rules.Print}}}},
+
+		// Malformed expressions.
+		{expr: "", err: true},
+		{expr: "pin34", err: true},
+		{expr: "pin34>", err: true},
+		{expr: "pin>3000", err: true},
+		{expr: "34>3000", err: true},
+		{expr: "pin34=>3000", err: true},
+		{expr: "pin34>3000.5", err: true},
+		{expr: "pin34>3000 for", err: true},
+		{expr: "pin34>3000 for ten", err: true},
+		{expr: "pin34>3000 after 5s", err: true},
+		{expr: "pin256>1", err: true},
+		{expr: "pin34>99999999999999999999", err: true},
+		{expr: "temp>3000", err: true},
+		{expr: "drift:pin34>4", err: true},
+		{expr: "anomaly:>4", err: true},
+		{expr: "-> print", err: true},
+
+		// Malformed actions.
+		{expr: "pin34>3000 ->", err: true},
+		{expr: "pin34>3000 -> beep", err: true},
+		{expr: "pin34>3000 -> print -> write", err: true},
+	} {
+		got, err := rules.Parse(tc.expr)
+		if tc.err {
+			if err == nil {
+				t.Errorf("Parse(%q) = %+v, want an error", tc.expr, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Parse(%q): %v", tc.expr, err)
+		} else if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("Parse(%q) = %+v, want %+v", tc.expr, got, tc.want)
+		}
+	}
+}
+
+func TestParseWith(t *testing.T) {
+	channels := rules.Channels{"air-temp": 34, "vent-fan": 25}
+	for _, tc := range []struct {
+		expr string
+		want rules.Rule
+		err  bool
+	}{
+		{expr: "air-temp>3000 -> write vent-fan=1", want: rules.Rule{
+			Expr: "air-temp>3000", Pin: 34, Op: ">", Threshold: 3000,
+			Actions: []rules.Action{{Kind: rules.Write, Write: esp32.PinWrite{PinNum: 25, State: 1}}},
+		}},
+		{expr: "anomaly:air-temp>=4", want: rules.Rule{Expr: "anomaly:air-temp>=4", Kind: esp32.KindAnomaly, Pin: 34, Op: ">=", Threshold: 4}},
+		{expr: "pin35<100 -> write 26=0", want: rules.Rule{
+			Expr: "pin35<100", Pin: 35, Op: "<", Threshold: 100,
+			Actions: []rules.Action{{Kind: rules.Write, Write: esp32.PinWrite{PinNum: 26, State: 0}}},
+		}},
+
+		// Unknown channels.
+		{expr: "soil>100", err: true},
+		{expr: "air-temp>3000 -> write pump=1", err: true},
+	} {
+		got, err := rules.ParseWith(tc.expr, channels)
+		if tc.err {
+			if err == nil {
+				t.Errorf("ParseWith(%q) = %+v, want an error", tc.expr, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseWith(%q): %v", tc.expr, err)
+		} else if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("ParseWith(%q) = %+v, want %+v", tc.expr, got, tc.want)
+		}
+	}
+}
+
+func TestParseAction(t *testing.T) {
+	for _, tc := range []struct {
+		action string
+		want   rules.Action
+		err    bool
+	}{
+		{action: "print", want: rules.Action{Kind: rules.Print}},
+		{action: " exec notify-send 'too hot' ", want: rules.Action{Kind: rules.Exec, Command: "notify-send 'too hot'"}},
+		{action: "write 25=1", want: rules.Action{Kind: rules.Write, Write: esp32.PinWrite{PinNum: 25, State: 1}}},
+		{action: "write 25 = 255", want: rules.Action{Kind: rules.Write, Write: esp32.PinWrite{PinNum: 25, State: 255}}},
+		{action: "mqtt greenhouse/alerts", want: rules.Action{Kind: rules.MQTT, Topic: "greenhouse/alerts"}},
+
+		{action: "", err: true},
+		{action: "print now", err: true},
+		{action: "exec", err: true},
+		{action: "write", err: true},
+		{action: "write 25", err: true},
+		{action: "write 25=on", err: true},
+		{action: "write 256=1", err: true},
+		{action: "write 25=256", err: true},
+		{action: "write 25=-1", err: true},
+		{action: "write vent-fan=1", err: true},
+		{action: "mqtt", err: true},
+		{action: "mqtt greenhouse/+/alerts", err: true},
+		{action: "mqtt greenhouse/#", err: true},
+		{action: "email me", err: true},
+	} {
+		got, err := rules.ParseAction(tc.action)
+		if tc.err {
+			if err == nil {
+				t.Errorf("ParseAction(%q) = %+v, want an error", tc.action, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseAction(%q): %v", tc.action, err)
+		} else if got != tc.want {
+			t.Errorf("ParseAction(%q) = %+v, want %+v", tc.action, got, tc.want)
+		}
+	}
+}
+
+func TestMatch(t *testing.T) {
+	for _, tc := range []struct {
+		op               string
+		below, at, above bool
+	}{
+		{">", false, false, true},
+		{">=", false, true, true},
+		{"<", true, false, false},
+		{"<=", true, true, false},
+		{"==", false, true, false},
+		{"!=", true, false, true},
+	} {
+		r := rules.Rule{Op: tc.op, Threshold: 10}
+		if got := [3]bool{r.Match(9), r.Match(10), r.Match(11)}; got != [3]bool{tc.below, tc.at, tc.above} {
+			t.Errorf("%s 10 matches 9, 10, 11: %v, want %v", tc.op, got, [3]bool{tc.below, tc.at, tc.above})
+		}
+	}
+}
+
+func TestEngine(t *testing.T) {
+	rule, err := rules.Parse("pin34>3000 for 10s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	for _, tc := range []struct {
+		name   string
+		values []int // one reading per second
+		fired  []int // indexes of the readings that fire
+	}{
+		{"never held", []int{2000, 2500, 2999}, nil},
+		{"held too briefly", []int{3001, 3001, 3001, 2000, 3001}, nil},
+		{"held", []int{3001, 3100, 3200, 3300, 3400, 3500, 3600, 3700, 3800, 3900, 4000, 4000}, []int{10}},
+		{"re-armed", []int{3001, 3001, 3001, 3001, 3001, 3001, 3001, 3001, 3001, 3001, 3001, 0,
+			3001, 3001, 3001, 3001, 3001, 3001, 3001, 3001, 3001, 3001, 3001}, []int{10, 22}},
+	} {
+		e := rules.NewEngine([]rules.Rule{rule})
+		var fired []int
+		for i, value := range tc.values {
+			at := start.Add(time.Duration(i) * time.Second)
+			if alerts := e.Evaluate(esp32.Reading{Time: at, Pin: 34, Value: value}); len(alerts) > 0 {
+				fired = append(fired, i)
+			}
+			if alerts := e.Evaluate(esp32.Reading{Time: at, Pin: 35, Value: value}); len(alerts) > 0 {
+				t.Errorf("%s: reading %d on pin 35 fired %+v", tc.name, i, alerts)
+			}
+		}
+		if !slices.Equal(fired, tc.fired) {
+			t.Errorf("%s: fired at readings %v, want %v", tc.name, fired, tc.fired)
+		}
+	}
+}
+
+func TestEngineBoardsAndKinds(t *testing.T) {
+	value, err := rules.Parse("pin34>3000 for 2s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	anomaly, err := rules.Parse("anomaly:pin34>=4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := rules.NewEngine([]rules.Rule{value, anomaly})
+	start := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	for _, tc := range []struct {
+		name    string
+		reading esp32.Reading
+		fired   []string
+	}{
+		// Each board's condition holds from its own first match.
+		{"a starts", esp32.Reading{Time: start, Address: "A", Pin: 34, Value: 3001}, nil},
+		{"b starts", esp32.Reading{Time: start.Add(time.Second), Address: "B", Pin: 34, Value: 3001}, nil},
+		{"a held", esp32.Reading{Time: start.Add(2 * time.Second), Address: "A", Pin: 34, Value: 3001}, []string{value.Expr}},
+		{"b not yet", esp32.Reading{Time: start.Add(2 * time.Second), Address: "B", Pin: 34, Value: 3001}, nil},
+		{"b held", esp32.Reading{Time: start.Add(3 * time.Second), Address: "B", Pin: 34, Value: 3001}, []string{value.Expr}},
+
+		// Anomaly scores are only compared by anomaly rules, and values
+		// only by value rules.
+		{"score", esp32.Reading{Time: start.Add(4 * time.Second), Address: "A", Kind: esp32.KindAnomaly, Pin: 34, Value: 5}, []string{anomaly.Expr}},
+		{"value below score", esp32.Reading{Time: start.Add(5 * time.Second), Address: "C", Pin: 34, Value: 5}, nil},
+	} {
+		var fired []string
+		for _, alert := range e.Evaluate(tc.reading) {
+			fired = append(fired, alert.Rule.Expr)
+		}
+		if !slices.Equal(fired, tc.fired) {
+			t.Errorf("%s: fired %q, want %q", tc.name, fired, tc.fired)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"os"
+	"strings"
+
+	"bluetooth/esp32"
+)
+
+// runScript runs the REPL commands in the file at path ("-" for stdin),
+// one per line, over the connection, stopping at quit or if ctx is
+// cancelled. Blank lines and lines starting with '#' are skipped. Every
+// command runs even after one fails; it reports whether all passed.
+func runScript(ctx context.Context, client *esp32.Client, path string) bool {
+	var in io.Reader = os.Stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			msg.Printf("❌ Failed to open script: %v\n", err)
+			return false
+		}
+		defer f.Close()
+		in = f
+	}
+
+	r := &repl{client: client, editor: &lineEditor{}}
+	type failure struct {
+		line    int
+		command string
+		err     error
+	}
+	var failures []failure
+	passed := 0
+	scanner := bufio.NewScanner(in)
+	for n := 1; scanner.Scan(); n++ {
+		command := strings.TrimSpace(scanner.Text())
+		if command == "" || strings.HasPrefix(command, "#") {
+			continue
+		}
+		msg.Printf("▶️  [%d] %s\n", n, command)
+		quit, err := r.run(ctx, strings.Fields(command))
+		if ctx.Err() != nil {
+			msg.Println("\n🛑 Interrupted")
+			return false
+		}
+		if err != nil {
+			msg.Printf("❌ %v\n", err)
+			failures = append(failures, failure{n, command, err})
+		} else {
+			passed++
+		}
+		if quit {
+			break
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		msg.Printf("❌ Failed to read script: %v\n", err)
+		return false
+	}
+
+	msg.Printf("\n📋 Script: %d passed, %d failed\n", passed, len(failures))
+	for _, f := range failures {
+		msg.Printf("   line %d: %s: %v\n", f.line, f.command, f.err)
+	}
+	return len(failures) == 0
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"bluetooth/esp32"
+)
+
+// runSelfTest has a board run its built-in diagnostics before it goes
+// into the field, printing each result as it arrives. The stock firmware
+// has no self-test characteristic, so its UUID is given on the command
+// line like ota's. It exits non-zero unless every test passed or was
+// skipped.
+func runSelfTest(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("selftest", flag.ExitOnError)
+	namePtr := fs.String("name", "", "Name of the Bluetooth device to test (required)")
+	timeoutPtr := fs.Int("timeout", 30, "Scan timeout in seconds")
+	uuidPtr := fs.String("uuid", "", "UUID of the self-test characteristic (required)")
+	testsPtr := fs.String("tests", "", "Comma-separated self-tests to run: gpio-loopback, adc-reference, memory (default all)")
+	waitPtr := fs.Duration("wait", 10*time.Second, "How long to wait for each result")
+	fs.Parse(args)
+
+	for _, required := range []struct{ name, value string }{
+		{"name", *namePtr}, {"uuid", *uuidPtr},
+	} {
+		if required.value == "" {
+			msg.Printf("Error: --%s flag is required\n", required.name)
+			msg.Println("\nUsage:")
+			fs.PrintDefaults()
+			os.Exit(1)
+		}
+	}
+	var tests []esp32.SelfTest
+	if *testsPtr != "" {
+		for _, name := range strings.Split(*testsPtr, ",") {
+			t, err := esp32.ParseSelfTest(strings.TrimSpace(name))
+			if err != nil {
+				msg.Printf("❌ Invalid --tests: %v\n", err)
+				os.Exit(1)
+			}
+			tests = append(tests, t)
+		}
+	}
+
+	client := connectDevice(ctx, *namePtr, time.Duration(*timeoutPtr)*time.Second)
+	msg.Println("🧪 Running self-test")
+	start := time.Now()
+	report, err := client.SelfTest(ctx, esp32.SelfTestOptions{
+		UUID:    *uuidPtr,
+		Tests:   tests,
+		Timeout: *waitPtr,
+		Result: func(r esp32.SelfTestResult) {
+			icon := map[esp32.SelfTestStatus]string{esp32.SelfTestPassed: "✅", esp32.SelfTestFailed: "❌", esp32.SelfTestSkipped: "⏭️ "}[r.Status]
+			if icon == "" {
+				icon = "❓"
+			}
+			msg.Printf("   %s %-14s %s", icon, r.Test, r.Status)
+			if r.Detail != "" {
+				msg.Printf(": %s", r.Detail)
+			}
+			msg.Println()
+		},
+	})
+	client.Disconnect()
+	if ctx.Err() != nil {
+		msg.Println("🛑 Interrupted")
+		os.Exit(1)
+	}
+	if err != nil {
+		msg.Printf("❌ Self-test failed: %v\n", err)
+		os.Exit(1)
+	}
+	counts := map[esp32.SelfTestStatus]int{}
+	for _, r := range report.Results {
+		counts[r.Status]++
+	}
+	summary := fmt.Sprintf("%d passed, %d failed, %d skipped in %s", counts[esp32.SelfTestPassed],
+		len(report.Results)-counts[esp32.SelfTestPassed]-counts[esp32.SelfTestSkipped],
+		counts[esp32.SelfTestSkipped], time.Since(start).Round(time.Millisecond))
+	if !report.Passed() {
+		msg.Printf("❌ Self-test FAILED (%s)\n", summary)
+		os.Exit(1)
+	}
+	msg.Printf("✅ Self-test passed (%s)\n", summary)
+}
@@ -0,0 +1,617 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"maps"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+
+	"bluetooth/api"
+	"bluetooth/bridge"
+	"bluetooth/esp32"
+	"bluetooth/exporter"
+)
+
+// runServe holds a board's BLE connection and serves it over HTTP, so
+// several local clients can read and write the board through this one
+// process. The board is reconnected if its link or the adapter drops.
+// With --editor it also serves a page for changing the profile in the
+// config file from a browser. With --idle-disconnect the board is let go
+// once nothing has used it for a while, freeing it for other centrals
+// and saving its battery, and connected again by the next request.
+//
+// Given several --name flags it is a gateway, serving each board's API
+// under /devices/<name>/ and listing them at GET /devices. With --lazy
+// a board isn't connected until its first request, which waits while it
+// is found, so one gateway can front dozens of rarely used boards.
+// --virtual adds a virtual device of the config file, made of channels
+// of several boards, served alongside them like one more board.
+//
+// --metrics and --mqtt add Prometheus and MQTT front-ends to the HTTP
+// and WebSocket ones, all fed from the one connection to each board, so
+// a single daemon can serve every protocol with the same view of it.
+//
+// --share lets clients create read-only links to a live view of some
+// channels of a board, optionally expiring, to send to someone who
+// should watch but not control it.
+//
+// --listen can be given several times, for hosts with segmented
+// networks: each address, IPv4, IPv6 or a unix socket, serves the same
+// API, with TLS and client certificates of its own if it has them.
+//
+// --auth has clients of the web pages and API sign in first, with basic
+// auth, an authenticating reverse proxy's header or an OpenID Connect
+// provider, as set up in the config file, so serve can sit behind the
+// single sign-on of a homelab. Share links stay open to their holders.
+//
+// --spaces serves the spaces of the config file instead, for gateways
+// shared by groups, such as two labs, that mustn't see each other's
+// boards: each space's boards are under /spaces/<space>/, as for several
+// --name flags, only for requests bearing its token, bridged under its
+// topic prefix and journalled to its own file.
+func runServe(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	var names stringList
+	fs.Var(&names, "name", "Name of the Bluetooth device to connect to (required; repeatable, serving each under /devices/<name>/)")
+	var virtuals stringList
+	fs.Var(&virtuals, "virtual", "Also serve this virtual device of the config file under /devices/<name>/, connecting to its boards (repeatable)")
+	timeoutPtr := fs.Int("timeout", 30, "Scan timeout in seconds")
+	var listens stringList
+	fs.Var(&listens, "listen", "Address to serve the API on: host:port, [ipv6]:port or unix:PATH, optionally followed by ,cert=FILE,key=FILE for TLS and ,client_ca=FILE to require client certificates (repeatable; default 127.0.0.1:8080)")
+	heartbeatPtr := fs.Duration("heartbeat", 5*time.Second, "How often to check the board is still connected")
+	idlePtr := fs.Duration("idle-disconnect", 0, "Disconnect the board after no reads, writes or streams for this long (e.g. 10m), reconnecting on the next request (checked every --heartbeat; 0 to stay connected)")
+	readMaxAgePtr := fs.Duration("read-max-age", 0, "Answer GET /pins and /adc with readings up to this old (e.g. 500ms) instead of reading the board again; concurrent reads always share one")
+	lazyPtr := fs.Bool("lazy", false, "Don't connect to a board until its first request")
+	configPtr := fs.String("config", "", "Config file with device profiles (default ~/"+defaultConfigName+")")
+	profilePtr := fs.String("profile", "", "Device profile from the config file, giving the board and its firmware's UUIDs")
+	spacesPtr := fs.Bool("spaces", false, "Serve the spaces of the config file instead of --name, each under /spaces/<space>/ to holders of its token, with its own topic prefix and journal")
+	metricsPtr := fs.Bool("metrics", false, "Also serve Prometheus metrics of the boards at /metrics")
+	brokerPtr := fs.String("mqtt", "", "Also bridge the boards to this MQTT broker, e.g. tcp://localhost:1883, as the bridge command does")
+	mqttUsernamePtr := fs.String("mqtt-username", "", "MQTT username")
+	mqttPasswordPtr := fs.String("mqtt-password", "", "MQTT password")
+	prefixPtr := fs.String("topic-prefix", "esp32", "First MQTT topic level")
+	sharePtr := fs.Bool("share", false, "Let clients create read-only live view links to channels of a board at POST /shares, viewed at /share/<token>/")
+	editorPtr := fs.Bool("editor", false, "Serve a web page at /editor for changing the profile's pin labels, calibrations and alert rules (needs --profile)")
+	authPtr := fs.String("auth", "", "Require clients of the web pages and API to sign in with this provider, set up in the config file's auth section: "+strings.Join(authProviders, ", "))
+	transport := transportFlags(fs)
+	fs.Parse(args)
+
+	port := transport()
+	if *spacesPtr && (len(names) > 0 || len(virtuals) > 0 || port != "") {
+		msg.Println("Error: --spaces takes its devices from the config file, not --name, --virtual or a port")
+		os.Exit(1)
+	}
+	if *spacesPtr && *editorPtr {
+		// The editor would be open to every space's clients.
+		msg.Println("Error: --editor can't be used with --spaces")
+		os.Exit(1)
+	}
+	if *spacesPtr && *authPtr == "basic" {
+		// Both would need the Authorization header.
+		msg.Println("Error: --auth basic can't be used with --spaces")
+		os.Exit(1)
+	}
+	if port != "" && len(names) == 0 {
+		names = append(names, port)
+	}
+	if *editorPtr && *profilePtr == "" {
+		msg.Println("Error: --editor needs --profile")
+		msg.Println("\nUsage:")
+		fs.PrintDefaults()
+		os.Exit(1)
+	}
+	if *profilePtr != "" {
+		p := loadProfile(*configPtr, *profilePtr)
+		profile = p.esp32Profile().WithDefaults()
+		if len(names) == 0 && len(virtuals) == 0 && !*spacesPtr {
+			names = append(names, p.target())
+		}
+	}
+	var spaces []*servedSpace
+	if *spacesPtr {
+		spaces = loadSpaces(*configPtr)
+	} else if len(names) > 0 || len(virtuals) > 0 {
+		sp := &servedSpace{names: names, topicPrefix: *prefixPtr}
+		if len(virtuals) > 0 {
+			c, path := loadConfig(*configPtr)
+			sp.virtuals = map[string]virtualConfig{}
+			for _, name := range virtuals {
+				v, ok := c.VirtualDevices[name]
+				if !ok {
+					msg.Printf("❌ Virtual device %q not found in %s\n", name, path)
+					os.Exit(1)
+				}
+				sp.virtuals[name] = v
+				for _, device := range v.devices() {
+					if !slices.Contains(sp.names, device) {
+						sp.names = append(sp.names, device)
+					}
+				}
+			}
+		}
+		spaces = []*servedSpace{sp}
+	} else {
+		msg.Println("Error: --name flag is required")
+		msg.Println("\nUsage:")
+		fs.PrintDefaults()
+		os.Exit(1)
+	}
+
+	if len(listens) == 0 {
+		listens = append(listens, "127.0.0.1:8080")
+	}
+	var listeners []net.Listener
+	var urls []string
+	for _, s := range listens {
+		spec, err := parseListen(s)
+		if err != nil {
+			msg.Printf("❌ --listen: %v\n", err)
+			os.Exit(1)
+		}
+		ln, err := spec.listen()
+		if err != nil {
+			msg.Printf("❌ Failed to start API server: %v\n", err)
+			os.Exit(1)
+		}
+		listeners = append(listeners, ln)
+		urls = append(urls, spec.url(ln))
+	}
+	// Messages give the first address; the others serve the same.
+	base := urls[0]
+	// The boards share the adapter, whose manager takes their scans in
+	// turn.
+	manager := esp32.NewManager(adapter)
+	var sh *shares
+	if *sharePtr {
+		sh = newShares()
+	}
+	var boards []*servedBoard
+	for _, sp := range spaces {
+		sp.servers = map[string]*api.Server{}
+		sp.shares = sh
+		if *metricsPtr {
+			sp.metrics = exporter.New()
+		}
+		for _, name := range sp.devices() {
+			b := &servedBoard{
+				name:        name,
+				device:      name,
+				manager:     manager,
+				timeout:     time.Duration(*timeoutPtr) * time.Second,
+				heartbeat:   *heartbeatPtr,
+				idle:        *idlePtr,
+				lazy:        *lazyPtr,
+				metrics:     sp.metrics,
+				topicPrefix: sp.topicPrefix,
+				journal:     sp.journal,
+			}
+			if sp.name != "" {
+				b.prefix = fmt.Sprintf("[%s/%s] ", sp.name, name)
+			} else if len(sp.devices()) > 1 {
+				b.prefix = fmt.Sprintf("[%s] ", name)
+			}
+			if name == port {
+				// A port path is no good as a topic level.
+				b.device = filepath.Base(port)
+			}
+			if v, ok := sp.virtuals[name]; ok {
+				// The boards were made first, so their servers are
+				// there to be observed.
+				b.srv = api.NewVirtual(name, v.channels(sp.servers))
+				b.virtual = true
+				b.online.Store(true)
+			} else {
+				b.srv = api.New()
+				b.srv.SetReadMaxAge(*readMaxAgePtr)
+				if *lazyPtr {
+					b.srv.Suspend()
+				}
+			}
+			sp.servers[name] = b.srv
+			boards = append(boards, b)
+		}
+	}
+	mux := http.NewServeMux()
+	if *spacesPtr {
+		for _, sp := range spaces {
+			root := "/spaces/" + sp.name
+			mux.Handle(root+"/", sp.authorize(http.StripPrefix(root, sp.handler())))
+		}
+	} else {
+		mux.Handle("/", spaces[0].handler())
+	}
+	if sh != nil {
+		mux.HandleFunc("GET /share/{token}/", sh.handleView)
+	}
+	if *editorPtr {
+		ed := &editor{path: configPath(*configPtr), profile: *profilePtr}
+		ed.register(mux)
+	}
+	var handler http.Handler = mux
+	if *authPtr != "" {
+		c, _ := loadConfig(*configPtr)
+		provider, err := newAuth(*authPtr, c.Auth, mux)
+		if err != nil {
+			msg.Printf("❌ --auth: %v\n", err)
+			os.Exit(1)
+		}
+		var open []string
+		if sh != nil {
+			open = append(open, "/share/")
+		}
+		if *authPtr == "oidc" {
+			open = append(open, "/auth/")
+		}
+		handler = requireAuth(provider, mux, open...)
+		msg.Printf("🔐 Requiring %s sign-in for the web pages and API\n", *authPtr)
+	}
+	server := &http.Server{Handler: handler, ReadHeaderTimeout: 10 * time.Second}
+	for _, ln := range listeners {
+		go server.Serve(ln)
+	}
+	defer server.Close()
+	switch {
+	case *spacesPtr:
+		for _, sp := range spaces {
+			msg.Printf("🏢 Serving space %q, %d devices, on %s/spaces/%s/ (GET devices, then devices/<name>/pins, /adc, /stream and /ws)\n", sp.name, len(sp.names), base, sp.name)
+		}
+	case len(spaces[0].devices()) == 1:
+		msg.Printf("🌐 Serving the API on %s (GET /pins, GET /adc, POST /pins, GET /stream, GET /ws)\n", base)
+	default:
+		msg.Printf("🌐 Serving %d boards on %s (GET /devices, then /devices/<name>/pins, /adc, /stream and /ws)\n", len(spaces[0].names), base)
+	}
+	for _, url := range urls[1:] {
+		msg.Printf("🌐 Also serving on %s\n", url)
+	}
+	for _, sp := range spaces {
+		for _, name := range slices.Sorted(maps.Keys(sp.virtuals)) {
+			msg.Printf("🧩 Serving virtual device %q, made of %s\n", name, strings.Join(sp.virtuals[name].devices(), ", "))
+		}
+	}
+	if *sharePtr && *spacesPtr {
+		msg.Printf("🔗 Creating share links at POST %s/spaces/<space>/shares, viewed at /share/<token>/\n", base)
+	} else if *sharePtr {
+		msg.Printf("🔗 Creating share links at POST %s/shares, viewed at /share/<token>/\n", base)
+	}
+	if *editorPtr {
+		msg.Printf("📝 Editing profile %q at %s/editor\n", *profilePtr, base)
+	}
+	if *metricsPtr && *spacesPtr {
+		msg.Printf("📈 Serving Prometheus metrics of each space on %s/spaces/<space>/metrics\n", base)
+	} else if *metricsPtr {
+		msg.Printf("📈 Serving Prometheus metrics on %s/metrics\n", base)
+	}
+	if *brokerPtr != "" {
+		// Each board has its own MQTT connection, so each can have a
+		// last will marking it offline.
+		msg.Printf("📡 Connecting to MQTT broker %s...\n", *brokerPtr)
+		for _, b := range boards {
+			b.mqtt = dialMQTT(mqttOptions{
+				broker:   *brokerPtr,
+				clientID: "esp32-serve-" + b.device,
+				username: *mqttUsernamePtr,
+				password: *mqttPasswordPtr,
+				prefix:   b.topicPrefix,
+				device:   b.device,
+			}, &b.online)
+			defer b.mqtt.Disconnect(250)
+		}
+		for _, sp := range spaces {
+			msg.Printf("✅ Connected to MQTT broker, bridging to %s/<device>/pin/<n>\n", sp.topicPrefix)
+		}
+	}
+
+	var wg sync.WaitGroup
+	for _, b := range boards {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			serveBoard(ctx, b)
+		}()
+	}
+	wg.Wait()
+	msg.Println("\n🔌 Disconnecting...")
+}
+
+// servedSpace is a group of serve's boards, kept apart from the others:
+// their API is only for holders of the token, their topics are under the
+// topic prefix and their value changes go to their own journal. Without
+// --spaces every board is in one unnamed space, open to all.
+type servedSpace struct {
+	name, token string
+	topicPrefix string
+	// journal, if set, records the boards' value changes.
+	journal *esp32.Journal
+	metrics *exporter.Exporter
+	// names are the boards, and virtuals the virtual devices made of
+	// them.
+	names    []string
+	virtuals map[string]virtualConfig
+	servers  map[string]*api.Server
+	// shares, if set, are the share links, of every space.
+	shares *shares
+}
+
+// devices returns the names the space serves, its boards and then its
+// virtual devices.
+func (sp *servedSpace) devices() []string {
+	return append(slices.Clone(sp.names), slices.Sorted(maps.Keys(sp.virtuals))...)
+}
+
+// loadSpaces reads the spaces from the config file at path, or the
+// default one if path is empty, opening their journals. It exits on
+// error.
+func loadSpaces(path string) []*servedSpace {
+	c, path := loadConfig(path)
+	if len(c.Spaces) == 0 {
+		msg.Printf("❌ No spaces in %s\n", path)
+		os.Exit(1)
+	}
+	var spaces []*servedSpace
+	for _, name := range slices.Sorted(maps.Keys(c.Spaces)) {
+		sc := c.Spaces[name]
+		sp := &servedSpace{
+			name:        name,
+			token:       sc.Token,
+			topicPrefix: cmp.Or(sc.TopicPrefix, name),
+			virtuals:    map[string]virtualConfig{},
+		}
+		for _, device := range sc.Devices {
+			if v, ok := c.VirtualDevices[device]; ok {
+				sp.virtuals[device] = v
+			} else {
+				sp.names = append(sp.names, device)
+			}
+		}
+		if sc.Journal != "" {
+			sp.journal = openJournal(sc.Journal, nil, 0)
+		}
+		spaces = append(spaces, sp)
+	}
+	return spaces
+}
+
+// handler serves the space's boards: a lone board of the unnamed space
+// at the root, otherwise each under /devices/<name>/, listed at GET
+// /devices, with the space's metrics at /metrics and its share links at
+// /shares.
+func (sp *servedSpace) handler() http.Handler {
+	mux := http.NewServeMux()
+	if sp.metrics != nil {
+		mux.Handle("GET /metrics", sp.metrics)
+	}
+	if sp.shares != nil {
+		sp.shares.manage(mux, sp)
+	}
+	if sp.name == "" && len(sp.devices()) == 1 {
+		mux.Handle("/", sp.servers[sp.names[0]])
+		return mux
+	}
+	mux.HandleFunc("GET /devices", func(w http.ResponseWriter, r *http.Request) {
+		devices := []map[string]string{}
+		for _, name := range sp.devices() {
+			devices = append(devices, map[string]string{"name": name, "status": sp.servers[name].Status()})
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(devices)
+	})
+	mux.HandleFunc("/devices/{device}/", func(w http.ResponseWriter, r *http.Request) {
+		device := r.PathValue("device")
+		srv, ok := sp.servers[device]
+		if !ok {
+			http.Error(w, fmt.Sprintf(`{"error":%q}`, "unknown device "+device), http.StatusNotFound)
+			return
+		}
+		http.StripPrefix("/devices/"+device, srv).ServeHTTP(w, r)
+	})
+	return mux
+}
+
+// authorize passes on requests bearing the space's token, in an
+// Authorization header or, for browsers' EventSource and WebSocket,
+// which can't set one, an access_token query parameter.
+func (sp *servedSpace) authorize(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok {
+			token = r.URL.Query().Get("access_token")
+		}
+		if subtle.ConstantTimeCompare([]byte(token), []byte(sp.token)) != 1 {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf("Bearer realm=%q", sp.name))
+			http.Error(w, fmt.Sprintf(`{"error":%q}`, "missing or wrong token for space "+sp.name), http.StatusUnauthorized)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// servedBoard is one board of serve, how to hold its connection and the
+// front-ends sharing it. The server holds the board's subscriptions and
+// hands its notifications to the others, so they all see the same
+// readings.
+type servedBoard struct {
+	name, prefix string
+	// device is the board's MQTT topic level.
+	device    string
+	srv       *api.Server
+	manager   *esp32.Manager
+	timeout   time.Duration
+	heartbeat time.Duration
+	// idle, if positive, is how long the board may go unused before it
+	// is disconnected until the next request.
+	idle time.Duration
+	// lazy waits for the first request before connecting; srv must
+	// already be suspended.
+	lazy bool
+	// virtual is set for a virtual device, whose srv is fed by the
+	// servers of its boards rather than a connection of its own.
+	virtual bool
+
+	// metrics, if set, is fed the board's readings and connection state.
+	metrics *exporter.Exporter
+	// mqtt, if set, is bridged to the board while it is connected, and
+	// online is whether it is.
+	mqtt        mqtt.Client
+	topicPrefix string
+	online      atomic.Bool
+	// journal, if set, records the board's value changes.
+	journal *esp32.Journal
+}
+
+// serveBoard keeps b connected and attached to its server until ctx is
+// done.
+func serveBoard(ctx context.Context, b *servedBoard) {
+	if b.virtual {
+		serveVirtual(ctx, b)
+		return
+	}
+	if b.lazy {
+		msg.Printf("💤 %sWaiting for a request before connecting to \"%s\"\n", b.prefix, b.name)
+	} else {
+		msg.Printf("🔍 %sScanning for Bluetooth device: \"%s\"\n", b.prefix, b.name)
+	}
+	// bridged is the MQTT bridge while the board is connected.
+	var bridged atomic.Pointer[bridge.Bridge]
+	b.observe(&bridged)
+	session := &esp32.Session{
+		Manager:     b.manager,
+		Name:        b.name,
+		ScanTimeout: b.timeout,
+		Sleep:       esp32.NewSleepMonitor(ctx, sleepThreshold),
+		Profile:     profile,
+		Capture:     capture,
+		Wake:        b.srv.Wake(),
+		StartIdle:   b.lazy,
+		OnEvent: func(e esp32.SessionEvent) {
+			printSessionEvent(b.prefix, e)
+			if b.metrics != nil {
+				b.metrics.ObserveSession(e)
+			}
+			var unknown *esp32.UnknownPayloadError
+			if e.Kind == esp32.SessionUnknownPayload && errors.As(e.Err, &unknown) {
+				b.srv.ReportUnknownPayload(e.Client, unknown)
+			}
+		},
+	}
+	session.Run(ctx, func(client *esp32.Client) error {
+		if err := pairClient(ctx, b.prefix, client); err != nil {
+			return err
+		}
+		if err := b.srv.Attach(client); err != nil {
+			return err
+		}
+		defer b.srv.Detach()
+		if b.mqtt != nil {
+			br := bridge.New(client, b.mqtt, bridge.Options{
+				TopicPrefix: b.topicPrefix,
+				Device:      b.device,
+				Shared:      true,
+				OnError: func(err error) {
+					msg.Printf("⚠️  %s%v\n", b.prefix, err)
+				},
+			})
+			if err := br.Start(ctx); err != nil {
+				return err
+			}
+			bridged.Store(br)
+			b.online.Store(true)
+			defer func() {
+				bridged.Store(nil)
+				b.online.Store(false)
+				if err := br.Stop(); err != nil {
+					msg.Printf("⚠️  %s%v\n", b.prefix, err)
+				}
+			}()
+		}
+		if b.metrics != nil {
+			defer b.metrics.ObserveCharacteristics(client)
+		}
+		msg.Printf("✅ %sServing %s\n", b.prefix, client.Name)
+
+		// Notifications don't report a dropped link, so read the pin
+		// characteristic periodically to notice it and reconnect. The
+		// frame isn't decoded: one the decoder rejects is no sign of a
+		// dead link.
+		ticker := time.NewTicker(b.heartbeat)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-ticker.C:
+				if b.idle > 0 && b.srv.IdleFor() >= b.idle {
+					b.srv.Suspend()
+					return esp32.ErrSessionIdle
+				}
+				if _, err := client.ReadRaw(ctx, client.Profile().PinOutputUUID); err != nil {
+					return err
+				}
+				if b.metrics != nil {
+					b.metrics.ObserveCharacteristics(client)
+				}
+				if br := bridged.Load(); br != nil {
+					br.PublishStats()
+				}
+			}
+		}
+	})
+}
+
+// observe passes the readings of b's server to its other front-ends: the
+// metrics, the journal and the MQTT bridge in bridged, if any.
+func (b *servedBoard) observe(bridged *atomic.Pointer[bridge.Bridge]) {
+	b.srv.Observe(func(kind string, readings []esp32.Reading) {
+		if b.metrics != nil && kind == api.KindADC {
+			b.metrics.ObserveADC(readings)
+		} else if b.metrics != nil {
+			b.metrics.ObservePins(readings)
+		}
+		if br := bridged.Load(); br != nil {
+			br.Publish(readings)
+		}
+		if b.journal != nil {
+			if _, err := b.journal.Record(readings); err != nil {
+				msg.Printf("⚠️  %sFailed to write journal file: %v\n", b.prefix, err)
+			}
+		}
+	})
+}
+
+// serveVirtual feeds virtual device b's readings to its front-ends until
+// ctx is done. Its MQTT bridge only publishes: writes to a virtual device
+// are taken over HTTP and WebSockets.
+func serveVirtual(ctx context.Context, b *servedBoard) {
+	var bridged atomic.Pointer[bridge.Bridge]
+	if b.mqtt != nil {
+		bridged.Store(bridge.New(nil, b.mqtt, bridge.Options{
+			TopicPrefix: b.topicPrefix,
+			Device:      b.device,
+			OnError: func(err error) {
+				msg.Printf("⚠️  %s%v\n", b.prefix, err)
+			},
+		}))
+	}
+	b.observe(&bridged)
+	<-ctx.Done()
+	if b.mqtt != nil {
+		b.online.Store(false)
+		if err := bridge.PublishAvailability(b.mqtt, b.topicPrefix, b.device, 0, false); err != nil {
+			msg.Printf("⚠️  %s%v\n", b.prefix, err)
+		}
+	}
+}
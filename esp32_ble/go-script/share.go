@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	_ "embed"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+//go:embed web/share.html
+var sharePage []byte
+
+// share is a read-only live view link to channels of one device.
+type share struct {
+	Token    string    `json:"token"`
+	Device   string    `json:"device"`
+	Channels []string  `json:"channels,omitempty"`
+	Expires  time.Time `json:"expires,omitzero"`
+
+	space string
+	view  http.Handler
+	// ctx is done once the share expires or is revoked, ending its
+	// streams.
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// shareRequest is the body of POST /shares. Channels are pin numbers or
+// names of the profile's channels, all of them if there are none; TTL is
+// a duration such as "24h", none for a link lasting until it is revoked
+// or serve stops.
+type shareRequest struct {
+	Device   string   `json:"device"`
+	Channels []string `json:"channels"`
+	TTL      string   `json:"ttl"`
+}
+
+// shares are serve's share links, letting whoever holds one watch some
+// channels of a device without being able to write to it or to see the
+// others. They are kept in memory: restarting serve revokes them all.
+//
+//	POST   /shares               create one from a shareRequest
+//	GET    /shares               list the space's
+//	DELETE /shares/{token}       revoke one
+//	GET    /share/{token}/       the live view page
+//	GET    /share/{token}/pins   and /adc and /stream, as the API's
+//
+// The first three are served in each space, for its holders; the rest
+// are open to anyone with the link.
+type shares struct {
+	mu      sync.Mutex
+	byToken map[string]*share
+}
+
+func newShares() *shares {
+	return &shares{byToken: map[string]*share{}}
+}
+
+// manage registers the endpoints managing sp's shares on mux.
+func (sh *shares) manage(mux *http.ServeMux, sp *servedSpace) {
+	mux.HandleFunc("POST /shares", func(w http.ResponseWriter, r *http.Request) {
+		sh.handleCreate(w, r, sp)
+	})
+	mux.HandleFunc("GET /shares", func(w http.ResponseWriter, r *http.Request) {
+		sh.mu.Lock()
+		list := []*share{}
+		for _, s := range sh.byToken {
+			if s.space == sp.name {
+				list = append(list, s)
+			}
+		}
+		sh.mu.Unlock()
+		slices.SortFunc(list, func(a, b *share) int { return strings.Compare(a.Device, b.Device) })
+		writeEditorJSON(w, http.StatusOK, list)
+	})
+	mux.HandleFunc("DELETE /shares/{token}", func(w http.ResponseWriter, r *http.Request) {
+		sh.mu.Lock()
+		s, ok := sh.byToken[r.PathValue("token")]
+		sh.mu.Unlock()
+		if !ok || s.space != sp.name {
+			writeEditorJSON(w, http.StatusNotFound, map[string]string{"error": "no such share"})
+			return
+		}
+		s.cancel()
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+func (sh *shares) handleCreate(w http.ResponseWriter, r *http.Request, sp *servedSpace) {
+	// Requiring JSON keeps other sites' pages from creating links, as in
+	// the editor.
+	if mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mt != "application/json" {
+		writeEditorJSON(w, http.StatusUnsupportedMediaType, map[string]string{"error": "want application/json"})
+		return
+	}
+	var req shareRequest
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		writeEditorJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("invalid share JSON: %v", err)})
+		return
+	}
+	if req.Device == "" && len(sp.devices()) == 1 {
+		req.Device = sp.devices()[0]
+	}
+	srv, ok := sp.servers[req.Device]
+	if !ok {
+		writeEditorJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("unknown device %q", req.Device)})
+		return
+	}
+	var pins []uint8
+	for _, c := range req.Channels {
+		pin, ok := profile.ChannelPin(c)
+		if !ok {
+			n, err := strconv.ParseUint(c, 10, 8)
+			if err != nil {
+				writeEditorJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("unknown channel %q", c)})
+				return
+			}
+			pin = uint8(n)
+		}
+		pins = append(pins, pin)
+	}
+
+	s := &share{Device: req.Device, Channels: req.Channels, space: sp.name, view: srv.View(pins)}
+	if req.TTL != "" {
+		ttl, err := time.ParseDuration(req.TTL)
+		if err != nil || ttl <= 0 {
+			writeEditorJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("invalid ttl %q", req.TTL)})
+			return
+		}
+		s.Expires = time.Now().Add(ttl)
+		s.ctx, s.cancel = context.WithDeadline(context.Background(), s.Expires)
+	} else {
+		s.ctx, s.cancel = context.WithCancel(context.Background())
+	}
+	token := make([]byte, 16)
+	rand.Read(token)
+	s.Token = hex.EncodeToString(token)
+
+	sh.mu.Lock()
+	sh.byToken[s.Token] = s
+	sh.mu.Unlock()
+	context.AfterFunc(s.ctx, func() {
+		sh.mu.Lock()
+		delete(sh.byToken, s.Token)
+		sh.mu.Unlock()
+	})
+
+	until := "revoked"
+	if !s.Expires.IsZero() {
+		until = s.Expires.Format(time.DateTime)
+	}
+	msg.Printf("🔗 Sharing a read-only view of %s until %s\n", s.Device, until)
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	writeEditorJSON(w, http.StatusCreated, struct {
+		*share
+		URL string `json:"url"`
+	}{s, scheme + "://" + r.Host + "/share/" + s.Token + "/"})
+}
+
+// handleView serves a share's page and its read-only API, ending
+// requests, streams included, once the share expires or is revoked.
+func (sh *shares) handleView(w http.ResponseWriter, r *http.Request) {
+	token := r.PathValue("token")
+	sh.mu.Lock()
+	s, ok := sh.byToken[token]
+	sh.mu.Unlock()
+	if !ok || s.ctx.Err() != nil {
+		writeEditorJSON(w, http.StatusNotFound, map[string]string{"error": "share link expired or revoked"})
+		return
+	}
+	if r.URL.Path == "/share/"+token+"/" {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(msg.Page(sharePage))
+		return
+	}
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	stop := context.AfterFunc(s.ctx, cancel)
+	defer stop()
+	http.StripPrefix("/share/"+token, s.view).ServeHTTP(w, r.WithContext(ctx))
+}
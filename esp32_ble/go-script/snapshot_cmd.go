@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"os"
+	"time"
+)
+
+// runSnapshot reads a board's pins and ADC channels once with
+// Client.Snapshot and prints them, as JSON for scripts with --json.
+func runSnapshot(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("snapshot", flag.ExitOnError)
+	namePtr := fs.String("name", "", "Name of the Bluetooth device to read (required)")
+	timeoutPtr := fs.Int("timeout", 30, "Scan timeout in seconds")
+	jsonPtr := fs.Bool("json", false, "Print the readings as JSON on stdout (progress goes to stderr)")
+	fs.Parse(args)
+
+	if *namePtr == "" {
+		msg.Println("Error: --name flag is required")
+		msg.Println("\nUsage:")
+		fs.PrintDefaults()
+		os.Exit(1)
+	}
+
+	stdout := os.Stdout
+	if *jsonPtr {
+		os.Stdout = os.Stderr
+	}
+	client := connectDevice(ctx, *namePtr, time.Duration(*timeoutPtr)*time.Second)
+	snapshot, err := client.Snapshot(ctx)
+	client.Disconnect()
+	os.Stdout = stdout
+	if err != nil {
+		msg.Printf("❌ %v\n", err)
+		os.Exit(1)
+	}
+
+	if *jsonPtr {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(snapshot)
+		return
+	}
+	msg.Printf("📸 %s (%s) at %s\n", snapshot.Device, snapshot.Address, snapshot.Time.Format(time.TimeOnly))
+	for _, r := range snapshot.Pins {
+		msg.Printf("   Pin %d = %d\n", r.Pin, r.Value)
+	}
+	for _, r := range snapshot.ADC {
+		msg.Printf("   ADC %d = %d\n", r.Pin, r.Value)
+	}
+}
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"maps"
+	"math/rand"
+	"os"
+	"runtime"
+	"slices"
+	"time"
+
+	"bluetooth/esp32"
+	"bluetooth/esp32/mock"
+)
+
+// soakStats accumulates what a soak run observed.
+type soakStats struct {
+	sessions    int
+	operations  int
+	failures    int
+	deadlocks   int
+	leaks       int
+	panics      int
+	unsafe      int
+	unverified  int
+	maxRoutines int
+}
+
+// soakPins are the outputs the soak writes, put back off by the session's
+// failsafe states on every reconnect.
+var soakPins = []uint8{14, 26, 25, 33}
+
+// runSoak drives the emulator with random disconnects, stalls and
+// malformed frames for a long period, through the same session, client
+// and subscription code as the other commands. It checks that every
+// operation returns, decoding never panics, goroutines don't accumulate
+// across reconnects, the failsafe states are in place whenever the
+// session reconnects and writes its write policy reports done really
+// landed.
+func runSoak(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("soak", flag.ExitOnError)
+	durationPtr := fs.Duration("duration", time.Minute, "How long to run")
+	seedPtr := fs.Int64("seed", time.Now().UnixNano(), "Random seed for fault injection")
+	disconnectPtr := fs.Float64("disconnect-rate", 0.01, "Probability an operation drops the connection")
+	stallPtr := fs.Float64("stall-rate", 0.01, "Probability an operation stalls")
+	stallForPtr := fs.Duration("stall", 2*time.Second, "How long a stalled operation blocks")
+	malformedPtr := fs.Float64("malformed-rate", 0.05, "Probability an operation returns a malformed frame")
+	opTimeoutPtr := fs.Duration("op-timeout", 10*time.Second, "Operations taking longer than this count as deadlocked")
+	fs.Parse(args)
+
+	msg.Printf("🌪️  Soak testing against the emulator for %v (seed %d)\n", *durationPtr, *seedPtr)
+	msg.Printf("   disconnect %.3f, stall %.3f (%v), malformed %.3f\n\n",
+		*disconnectPtr, *stallPtr, *stallForPtr, *malformedPtr)
+
+	// The board draws faults from its own source under its lock, from
+	// operations the soak may have given up on; the soak picks operations
+	// from another, so neither races the other.
+	faultRNG := rand.New(rand.NewSource(*seedPtr))
+	board := mock.NewBoard("esp32-soak", "AA:BB:CC:DD:EE:50")
+	board.InjectFaults(mock.Faults{
+		Disconnect: *disconnectPtr,
+		Stall:      *stallPtr,
+		StallFor:   *stallForPtr,
+		Malformed:  *malformedPtr,
+	}, faultRNG)
+
+	var failsafe []esp32.PinWrite
+	for _, pin := range soakPins {
+		failsafe = append(failsafe, esp32.PinWrite{PinNum: pin, State: 0})
+	}
+	policy := esp32.DefaultWritePolicy()
+	policy.Verify = true
+
+	baseline := runtime.NumGoroutine()
+	start := time.Now()
+	runCtx, cancel := context.WithDeadline(ctx, start.Add(*durationPtr))
+	defer cancel()
+	s := &soaker{
+		ctx:       runCtx,
+		board:     board,
+		failsafe:  failsafe,
+		rng:       rand.New(rand.NewSource(faultRNG.Int63())),
+		opTimeout: *opTimeoutPtr,
+		settle:    *stallForPtr + time.Second,
+		stats:     soakStats{maxRoutines: baseline},
+		start:     start,
+	}
+	session := &esp32.Session{
+		Manager:     esp32.NewManager(mock.NewAdapter(board)),
+		Name:        board.Name,
+		ScanTimeout: *opTimeoutPtr,
+		RetryDelay:  10 * time.Millisecond,
+		WritePolicy: &policy,
+		Failsafe:    failsafe,
+		OnEvent: func(e esp32.SessionEvent) {
+			// Retries are reported by Run itself, between calls of fn.
+			if e.Kind == esp32.SessionRetry {
+				s.stats.failures++
+			}
+		},
+	}
+	session.Run(runCtx, s.run)
+	stats := s.stats
+
+	if !waitForGoroutines(baseline, s.settle) {
+		stats.leaks++
+		msg.Printf("⚠️  Goroutines did not return to baseline after the soak (%d > %d)\n",
+			runtime.NumGoroutine(), baseline)
+	}
+
+	faults := board.FaultCounts()
+	msg.Println("\n📋 Soak report:")
+	msg.Printf("   Duration:           %v\n", time.Since(start).Round(time.Millisecond))
+	msg.Printf("   Sessions:           %d\n", stats.sessions)
+	msg.Printf("   Operations:         %d (%d failed)\n", stats.operations, stats.failures)
+	msg.Printf("   Injected faults:    %d disconnect(s), %d stall(s), %d malformed\n",
+		faults.Disconnects, faults.Stalls, faults.Malformed)
+	msg.Printf("   Goroutines:         baseline %d, max %d\n", baseline, stats.maxRoutines)
+	msg.Printf("   Deadlocks:          %d\n", stats.deadlocks)
+	msg.Printf("   Leaks:              %d\n", stats.leaks)
+	msg.Printf("   Decoder panics:     %d\n", stats.panics)
+	msg.Printf("   Failsafe missed:    %d\n", stats.unsafe)
+	msg.Printf("   Unverified writes:  %d\n", stats.unverified)
+
+	if stats.deadlocks > 0 || stats.leaks > 0 || stats.panics > 0 || stats.unsafe > 0 || stats.unverified > 0 {
+		msg.Println("\n❌ Soak test FAILED")
+		os.Exit(1)
+	}
+	msg.Println("\n✅ Soak test passed")
+}
+
+// soaker runs random operations on each connection of a soak's session.
+type soaker struct {
+	ctx       context.Context
+	board     *mock.Board
+	failsafe  []esp32.PinWrite
+	rng       *rand.Rand
+	opTimeout time.Duration
+	// settle is how long goroutines of the last connection, such as a
+	// stalled read's, are given to return.
+	settle     time.Duration
+	stats      soakStats
+	start      time.Time
+	nextReport time.Duration
+}
+
+// run is the session's fn: it checks what the last connection left
+// behind, subscribes to the ADC notifications and runs random reads,
+// writes and notifications until the link drops or an operation
+// deadlocks, returning the error so the session reconnects.
+func (s *soaker) run(client *esp32.Client) error {
+	s.stats.sessions++
+	if !waitFor(func() bool { return esp32.Goroutines() == 0 }, s.settle) {
+		s.stats.leaks++
+		msg.Printf("⚠️  %d goroutine(s) of the client were still running on reconnecting for session %d\n",
+			esp32.Goroutines(), s.stats.sessions)
+	}
+	for _, w := range s.failsafe {
+		if got := s.board.Pin(w.PinNum); got != w.State {
+			s.stats.unsafe++
+			msg.Printf("⚠️  Pin %d was %d on reconnecting, not its failsafe state %d\n", w.PinNum, got, w.State)
+		}
+	}
+	if n := runtime.NumGoroutine(); n > s.stats.maxRoutines {
+		s.stats.maxRoutines = n
+	}
+
+	if err := client.SubscribeADC(func([]esp32.Reading) {}); err != nil {
+		s.stats.failures++
+		return err
+	}
+	fuzz, err := soakDecoders(client.Profile())
+	if err != nil {
+		s.stats.failures++
+		return err
+	}
+	for s.ctx.Err() == nil {
+		if since := time.Since(s.start); since >= s.nextReport+time.Minute {
+			s.nextReport = since.Truncate(time.Minute)
+			msg.Printf("⏱️  %v: %d session(s), %d operation(s), %d failure(s)\n",
+				since.Round(time.Second), s.stats.sessions, s.stats.operations, s.stats.failures)
+		}
+
+		var op func(ctx context.Context) error
+		switch s.rng.Intn(4) {
+		case 0:
+			op = func(ctx context.Context) error { return soakRead(ctx, client, client.Profile().ADCOutputUUID, fuzz) }
+		case 1:
+			op = func(ctx context.Context) error { return soakRead(ctx, client, client.Profile().PinOutputUUID, fuzz) }
+		case 2:
+			w := esp32.PinWrite{PinNum: soakPins[s.rng.Intn(len(soakPins))], State: uint8(s.rng.Intn(2) * 100)}
+			op = func(ctx context.Context) error {
+				err := client.WritePins(ctx, []esp32.PinWrite{w})
+				if got := s.board.Pin(w.PinNum); err == nil && got != w.State {
+					s.stats.unverified++
+					msg.Printf("⚠️  Writing %d to pin %d was reported done, but it is %d\n", w.State, w.PinNum, got)
+				}
+				return err
+			}
+		default:
+			op = func(context.Context) error {
+				s.board.Notify()
+				return nil
+			}
+		}
+
+		s.stats.operations++
+		err := runWithTimeout(s.ctx, op, s.opTimeout)
+		var panicErr decodePanic
+		var unknown *esp32.UnknownPayloadError
+		switch {
+		case err == nil:
+			continue
+		case s.ctx.Err() != nil:
+			// The soak is over, cutting the operation short.
+			return nil
+		case errors.Is(err, errOpTimeout):
+			s.stats.deadlocks++
+			msg.Printf("⚠️  Operation did not return within %v\n", s.opTimeout)
+		case errors.As(err, &panicErr):
+			s.stats.panics++
+			msg.Printf("⚠️  %v\n", err)
+		}
+		s.stats.failures++
+		// A malformed frame leaves the link up; anything else is taken
+		// as the link going, as the other commands do.
+		if !errors.As(err, &panicErr) && !errors.As(err, &unknown) {
+			return err
+		}
+	}
+	return nil
+}
+
+// decodePanic is a panic recovered while decoding a frame.
+type decodePanic struct {
+	decoder string
+	frame   []byte
+	value   any
+}
+
+func (p decodePanic) Error() string {
+	return fmt.Sprintf("%s decoder panicked on frame %v: %v", p.decoder, p.frame, p.value)
+}
+
+// soakDecoders returns every registered decoder as a client with profile
+// p would use it for the ADC characteristic, in p's value format and with
+// fresh state, to fuzz with the frames the soak reads.
+func soakDecoders(p esp32.Profile) (map[string]esp32.Decoder, error) {
+	decoders := map[string]esp32.Decoder{}
+	for _, name := range esp32.DecoderNames() {
+		p.Decoders = map[string]string{p.ADCOutputUUID: name}
+		d, err := esp32.DecoderFor(p, p.ADCOutputUUID)
+		if err != nil {
+			return nil, err
+		}
+		if sd, ok := d.(esp32.StatefulDecoder); ok {
+			d = sd.NewState()
+		}
+		decoders[name] = d
+	}
+	return decoders, nil
+}
+
+// soakRead reads a characteristic and decodes the frame with the client's
+// decoder for it, as its reads and subscriptions do, then with each of
+// fuzz, converting a decoder panic into an error. Only the client's
+// decoder failing counts: the frame is rarely in the others' formats.
+func soakRead(ctx context.Context, client *esp32.Client, uuid string, fuzz map[string]esp32.Decoder) (err error) {
+	frame, err := client.ReadRaw(ctx, uuid)
+	if err != nil {
+		return err
+	}
+	decoder := "the client's"
+	defer func() {
+		if r := recover(); r != nil {
+			err = decodePanic{decoder: decoder, frame: append([]byte{}, frame...), value: r}
+		}
+	}()
+	_, err = client.Decode(uuid, frame, time.Now())
+	for _, name := range slices.Sorted(maps.Keys(fuzz)) {
+		decoder = name
+		fuzz[name].Decode(frame)
+	}
+	return err
+}
+
+var errOpTimeout = errors.New("operation timed out")
+
+// runWithTimeout runs op, returning errOpTimeout if it hasn't finished
+// within timeout. A timed-out op is left running.
+func runWithTimeout(ctx context.Context, op func(context.Context) error, timeout time.Duration) error {
+	done := make(chan error, 1)
+	go func() { done <- op(ctx) }()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case err := <-done:
+		return err
+	case <-timer.C:
+		return errOpTimeout
+	}
+}
+
+// waitForGoroutines waits up to wait for the goroutine count to fall back
+// to baseline.
+func waitForGoroutines(baseline int, wait time.Duration) bool {
+	return waitFor(func() bool { return runtime.NumGoroutine() <= baseline }, wait)
+}
+
+// waitFor waits up to wait for done to report true.
+func waitFor(done func() bool, wait time.Duration) bool {
+	deadline := time.Now().Add(wait)
+	for !done() {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return true
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"bluetooth/esp32"
+)
+
+// runSyncWrite connects to several boards and has them apply pin writes
+// at the same moment with esp32.SyncWrite, for boards that must act
+// together such as paired relays. The stock firmware has no schedule
+// characteristic, so its UUID is given on the command line like
+// selftest's.
+func runSyncWrite(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("sync-write", flag.ExitOnError)
+	var names, pins stringList
+	fs.Var(&names, "name", "Name or address of a board to write (repeatable, at least two)")
+	fs.Var(&pins, "pin", "Pin write as PIN=STATE for every board, or BOARD:PIN=STATE for the board of that --name (repeatable)")
+	timeoutPtr := fs.Int("timeout", 30, "Scan timeout in seconds")
+	uuidPtr := fs.String("uuid", "", "UUID of the schedule characteristic (required)")
+	leadPtr := fs.Duration("lead", 250*time.Millisecond, "How far ahead to schedule the writes; stretched for slow links")
+	samplesPtr := fs.Int("samples", 8, "Clock reads per board to estimate its clock from")
+	fs.Parse(args)
+
+	if len(names) < 2 || *uuidPtr == "" || len(pins) == 0 {
+		msg.Println("Error: two or more --name flags, --uuid and --pin are required")
+		msg.Println("\nUsage:")
+		fs.PrintDefaults()
+		os.Exit(1)
+	}
+	writes := map[string][]esp32.PinWrite{}
+	for _, spec := range pins {
+		board := ""
+		if i := strings.LastIndex(spec, ":"); i >= 0 {
+			board, spec = spec[:i], spec[i+1:]
+			if !slices.Contains(names, board) {
+				msg.Printf("❌ --pin for %q, which isn't a --name\n", board)
+				os.Exit(1)
+			}
+		}
+		parsed, err := parsePinWrites([]string{spec})
+		if err != nil {
+			msg.Printf("❌ %v\n", err)
+			os.Exit(1)
+		}
+		for _, name := range names {
+			if board == "" || board == name {
+				writes[name] = append(writes[name], parsed...)
+			}
+		}
+	}
+
+	var targets []esp32.SyncTarget
+	for _, name := range names {
+		client := connectDevice(ctx, name, time.Duration(*timeoutPtr)*time.Second)
+		targets = append(targets, esp32.SyncTarget{Client: client, Writes: writes[name]})
+	}
+
+	msg.Printf("⏱️  Synchronizing the clocks of %d boards\n", len(targets))
+	result, err := esp32.SyncWrite(ctx, targets, esp32.SyncOptions{UUID: *uuidPtr, Samples: *samplesPtr, Lead: *leadPtr})
+	// Boards keep their schedules once disconnected.
+	for _, t := range targets {
+		t.Client.Disconnect()
+	}
+	for i, clock := range result.Clocks {
+		if clock.RTT > 0 {
+			msg.Printf("   %s: round trip %v, ± %v\n", targets[i].Client.Name, clock.RTT.Round(time.Microsecond), clock.Uncertainty.Round(time.Microsecond))
+		}
+	}
+	if err != nil {
+		msg.Printf("❌ Synchronized write failed: %v\n", err)
+		os.Exit(1)
+	}
+	msg.Printf("✅ Scheduled %d boards for %s, within %v of each other\n", len(targets), result.At.Format("15:04:05.000"), result.Spread.Round(time.Microsecond))
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"bluetooth/esp32"
+)
+
+// runWalkTest pings a board continuously while it is carried around,
+// keeping a live line of RSSI, round-trip latency and recent success rate,
+// to find where it stops being reliable before installing it. A dropped
+// link is reconnected, so walking back into range resumes the test.
+func runWalkTest(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("walk-test", flag.ExitOnError)
+	namePtr := fs.String("name", "", "Name of the Bluetooth device to test (required)")
+	timeoutPtr := fs.Int("timeout", 30, "Scan timeout in seconds")
+	intervalPtr := fs.Duration("interval", 500*time.Millisecond, "How often to ping the board")
+	pingTimeoutPtr := fs.Duration("ping-timeout", 2*time.Second, "How long a ping may take before it counts as failed")
+	windowPtr := fs.Int("window", 20, "How many recent pings the success rate covers")
+	beepPtr := fs.Bool("beep", false, "Ring the terminal bell when a ping fails or the link drops")
+	fs.Parse(args)
+
+	if *namePtr == "" {
+		msg.Println("Error: --name flag is required")
+		msg.Println("\nUsage:")
+		fs.PrintDefaults()
+		os.Exit(1)
+	}
+
+	stats := newWalkStats(max(*windowPtr, 1))
+	msg.Printf("🔍 Scanning for Bluetooth device: \"%s\"\n", *namePtr)
+	session := &esp32.Session{
+		Manager:     esp32.NewManager(adapter),
+		Name:        *namePtr,
+		Profile:     profile,
+		Capture:     capture,
+		ScanTimeout: time.Duration(*timeoutPtr) * time.Second,
+		OnEvent: func(e esp32.SessionEvent) {
+			if e.Kind == esp32.SessionDisconnected && *beepPtr {
+				msg.Print("\a")
+			}
+			msg.Println()
+			printSessionEvent("", e)
+		},
+	}
+	session.Run(ctx, func(client *esp32.Client) error {
+		for {
+			rssi, latency, err := ping(ctx, client, *pingTimeoutPtr)
+			if ctx.Err() != nil {
+				return nil
+			}
+			stats.add(err == nil, latency)
+			if err != nil && *beepPtr {
+				msg.Print("\a")
+			}
+			msg.Printf("\r%s", stats.line(rssi, latency, err))
+			if err != nil {
+				// The link is probably gone; let the session find out.
+				return err
+			}
+			if !sleepCtx(ctx, *intervalPtr) {
+				return nil
+			}
+		}
+	})
+	msg.Println()
+	msg.Println(stats.summary())
+	msg.Println("🔌 Disconnected")
+}
+
+// ping reads the ADC characteristic, timing the round trip, and the
+// connection's RSSI if the platform reports it (0 otherwise).
+func ping(ctx context.Context, client *esp32.Client, timeout time.Duration) (rssi int, latency time.Duration, err error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	start := time.Now()
+	if _, err := client.ReadRaw(ctx, client.Profile().ADCOutputUUID); err != nil {
+		return 0, 0, err
+	}
+	latency = time.Since(start)
+	if reading, err := client.ReadRSSI(ctx); err == nil {
+		rssi = reading.Value
+	}
+	return rssi, latency, nil
+}
+
+// walkStats tracks pings overall and over a window of recent ones.
+type walkStats struct {
+	recent []bool
+	next   int
+
+	pings, failed      int
+	total, least, most time.Duration
+}
+
+func newWalkStats(window int) *walkStats {
+	return &walkStats{recent: make([]bool, 0, window)}
+}
+
+func (s *walkStats) add(ok bool, latency time.Duration) {
+	if len(s.recent) < cap(s.recent) {
+		s.recent = append(s.recent, ok)
+	} else {
+		s.recent[s.next] = ok
+		s.next = (s.next + 1) % len(s.recent)
+	}
+	s.pings++
+	if !ok {
+		s.failed++
+		return
+	}
+	if s.pings-s.failed == 1 || latency < s.least {
+		s.least = latency
+	}
+	s.most = max(s.most, latency)
+	s.total += latency
+}
+
+// successRate returns the percentage of recent pings that succeeded.
+func (s *walkStats) successRate() float64 {
+	ok := 0
+	for _, r := range s.recent {
+		if r {
+			ok++
+		}
+	}
+	return float64(ok) * 100 / float64(len(s.recent))
+}
+
+// line renders the live indicator for the latest ping.
+func (s *walkStats) line(rssi int, latency time.Duration, err error) string {
+	signal := "📶 n/a"
+	if rssi != 0 {
+		// Five bars spanning -100 dBm (none) to -40 dBm (full).
+		bars := min(max((rssi+100)/12, 0), 5)
+		signal = fmt.Sprintf("📶 %4d dBm %s%s %-9s", rssi,
+			strings.Repeat("█", bars), strings.Repeat("░", 5-bars), signalQuality(rssi))
+	}
+	result := fmt.Sprintf("⏱️  %6s", latency.Round(time.Millisecond))
+	if err != nil {
+		result = "❌ no reply"
+	}
+	return fmt.Sprintf("%s | %s | ✅ %3.0f%% of last %d ", signal, result, s.successRate(), len(s.recent))
+}
+
+func (s *walkStats) summary() string {
+	if s.pings == 0 {
+		return "📊 No pings sent"
+	}
+	out := fmt.Sprintf("📊 %d ping(s), %d failed (%.1f%%)", s.pings, s.failed, float64(s.failed)*100/float64(s.pings))
+	if ok := s.pings - s.failed; ok > 0 {
+		out += fmt.Sprintf("; latency min %s, avg %s, max %s", s.least.Round(time.Millisecond),
+			(s.total / time.Duration(ok)).Round(time.Millisecond), s.most.Round(time.Millisecond))
+	}
+	return out
+}
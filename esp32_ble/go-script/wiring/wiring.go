@@ -0,0 +1,285 @@
+// Package wiring reads which GPIO does what from a wiring file or a KiCad
+// netlist export, so a profile's pin labels can follow the schematic
+// instead of being kept up to date by hand.
+package wiring
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Parse returns the labels of the pins data wires up, by GPIO number. A
+// file starting with "(export" is read as a KiCad netlist, anything else
+// as a wiring file. ref picks the netlist's ESP32 component and may be
+// empty if there is only one.
+func Parse(data []byte, ref string) (map[uint8]string, error) {
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("(export")) {
+		return ParseNetlist(data, ref)
+	}
+	return ParseWiring(data)
+}
+
+// ParseWiring reads a wiring file: one pin per line, its number followed
+// by its label, with blank lines and lines starting with '#' skipped.
+//
+//	# greenhouse controller
+//	GPIO25  vent fan
+//	34: air temperature
+//	IO35 = soil moisture
+func ParseWiring(data []byte) (map[uint8]string, error) {
+	labels := map[uint8]string{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		i := strings.IndexAny(line, " \t:=")
+		if i < 0 {
+			i = len(line)
+		}
+		pin, ok := gpio(line[:i])
+		if !ok {
+			return nil, fmt.Errorf("line %d: %q is not a GPIO number", n, line[:i])
+		}
+		label := strings.TrimSpace(strings.TrimLeft(line[i:], " \t:="))
+		if label == "" {
+			return nil, fmt.Errorf("line %d: GPIO%d has no label", n, pin)
+		}
+		if _, ok := labels[pin]; ok {
+			return nil, fmt.Errorf("line %d: GPIO%d is wired more than once", n, pin)
+		}
+		labels[pin] = label
+	}
+	return labels, scanner.Err()
+}
+
+// ParseNetlist reads a KiCad (6 or later) netlist export, labelling each
+// GPIO of the ESP32 component with the name of the net it is on. Nets
+// KiCad named itself, like Net-(U1-IO25), are left out. ref is the
+// component's reference, e.g. U1; if empty, the only component whose
+// value or part names an ESP32 is used.
+func ParseNetlist(data []byte, ref string) (map[uint8]string, error) {
+	root, err := parseSExpr(data)
+	if err != nil {
+		return nil, err
+	}
+	if ref == "" {
+		var candidates []string
+		for _, comp := range root.find("components").all("comp") {
+			part := comp.find("libsource").value("part")
+			if strings.Contains(strings.ToUpper(comp.value("value")+" "+part), "ESP32") {
+				candidates = append(candidates, comp.value("ref"))
+			}
+		}
+		switch len(candidates) {
+		case 0:
+			return nil, errors.New("netlist has no ESP32 component (pick one with its reference)")
+		case 1:
+			ref = candidates[0]
+		default:
+			return nil, fmt.Errorf("netlist has several ESP32 components (%s); pick one with its reference", strings.Join(candidates, ", "))
+		}
+	}
+
+	labels := map[uint8]string{}
+	found := false
+	for _, net := range root.find("nets").all("net") {
+		name := net.value("name")
+		for _, node := range net.all("node") {
+			if node.value("ref") != ref {
+				continue
+			}
+			found = true
+			function := node.value("pinfunction")
+			if function == "" {
+				return nil, fmt.Errorf("netlist has no pin functions for %s; export it from KiCad 6 or later", ref)
+			}
+			pin, ok := functionGPIO(function)
+			if !ok || autoNamed(name) {
+				continue
+			}
+			labels[pin] = netLabel(name)
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("netlist has no pins of component %s", ref)
+	}
+	return labels, nil
+}
+
+// gpioName matches a pin written as 25, GPIO25 or IO25.
+var gpioName = regexp.MustCompile(`^(?i:gpio|io)?(\d+)$`)
+
+func gpio(s string) (uint8, bool) {
+	m := gpioName.FindStringSubmatch(strings.TrimSpace(s))
+	if m == nil {
+		return 0, false
+	}
+	n, err := strconv.ParseUint(m[1], 10, 8)
+	return uint8(n), err == nil
+}
+
+// functionGPIO returns the GPIO of a symbol's pin function, such as IO25,
+// TXD0/IO1 or SENSOR_VP, if it is one.
+func functionGPIO(function string) (uint8, bool) {
+	for _, part := range strings.Split(function, "/") {
+		switch strings.ToUpper(part) {
+		case "SENSOR_VP":
+			return 36, true
+		case "SENSOR_VN":
+			return 39, true
+		}
+		// A bare number is a package pin, not a GPIO.
+		if _, err := strconv.Atoi(part); err == nil {
+			continue
+		}
+		if pin, ok := gpio(part); ok {
+			return pin, true
+		}
+	}
+	return 0, false
+}
+
+// autoNamed reports whether KiCad made up a net's name because the
+// schematic doesn't give one.
+func autoNamed(name string) bool {
+	return name == "" || strings.HasPrefix(name, "Net-(") || strings.HasPrefix(name, "unconnected-(")
+}
+
+// netLabel drops the sheet path of a net's name: /sensors/SOIL becomes
+// SOIL.
+func netLabel(name string) string {
+	return name[strings.LastIndex(name, "/")+1:]
+}
+
+// sexpr is a parenthesised list or, with no items, an atom.
+type sexpr struct {
+	atom  string
+	items []*sexpr
+}
+
+// head is the list's first atom, its kind in a netlist.
+func (s *sexpr) head() string {
+	if s == nil || len(s.items) == 0 {
+		return ""
+	}
+	return s.items[0].atom
+}
+
+// all returns the lists in s headed by kind.
+func (s *sexpr) all(kind string) []*sexpr {
+	if s == nil {
+		return nil
+	}
+	var lists []*sexpr
+	for _, item := range s.items[1:] {
+		if item.head() == kind {
+			lists = append(lists, item)
+		}
+	}
+	return lists
+}
+
+// find returns the first list in s headed by kind, or nil.
+func (s *sexpr) find(kind string) *sexpr {
+	if lists := s.all(kind); len(lists) > 0 {
+		return lists[0]
+	}
+	return nil
+}
+
+// value returns the atom after kind in the first list headed by it, as
+// in (ref "U1").
+func (s *sexpr) value(kind string) string {
+	if l := s.find(kind); l != nil && len(l.items) > 1 {
+		return l.items[1].atom
+	}
+	return ""
+}
+
+// parseSExpr parses the one list in data, which must hold nothing else.
+func parseSExpr(data []byte) (*sexpr, error) {
+	p := &sexprParser{data: data}
+	s, err := p.parse()
+	if err != nil {
+		return nil, err
+	}
+	if s.items == nil {
+		return nil, errors.New("netlist is not a list")
+	}
+	if p.skipSpace(); p.pos < len(p.data) {
+		return nil, fmt.Errorf("netlist goes on after its end, at byte %d", p.pos)
+	}
+	return s, nil
+}
+
+type sexprParser struct {
+	data []byte
+	pos  int
+}
+
+func (p *sexprParser) skipSpace() {
+	for p.pos < len(p.data) && slices.Contains([]byte(" \t\r\n"), p.data[p.pos]) {
+		p.pos++
+	}
+}
+
+func (p *sexprParser) parse() (*sexpr, error) {
+	p.skipSpace()
+	if p.pos >= len(p.data) {
+		return nil, errors.New("netlist ends early")
+	}
+	switch p.data[p.pos] {
+	case '(':
+		p.pos++
+		list := &sexpr{items: []*sexpr{}}
+		for {
+			p.skipSpace()
+			if p.pos >= len(p.data) {
+				return nil, errors.New("netlist ends early")
+			}
+			if p.data[p.pos] == ')' {
+				p.pos++
+				return list, nil
+			}
+			item, err := p.parse()
+			if err != nil {
+				return nil, err
+			}
+			list.items = append(list.items, item)
+		}
+	case ')':
+		return nil, fmt.Errorf("netlist has an unexpected ) at byte %d", p.pos)
+	case '"':
+		start := p.pos
+		var b strings.Builder
+		for p.pos++; p.pos < len(p.data); p.pos++ {
+			switch c := p.data[p.pos]; c {
+			case '\\':
+				p.pos++
+				if p.pos < len(p.data) {
+					b.WriteByte(p.data[p.pos])
+				}
+			case '"':
+				p.pos++
+				return &sexpr{atom: b.String()}, nil
+			default:
+				b.WriteByte(c)
+			}
+		}
+		return nil, fmt.Errorf("netlist has an unterminated string at byte %d", start)
+	default:
+		start := p.pos
+		for p.pos < len(p.data) && !slices.Contains([]byte(" \t\r\n()\""), p.data[p.pos]) {
+			p.pos++
+		}
+		return &sexpr{atom: string(p.data[start:p.pos])}, nil
+	}
+}
@@ -0,0 +1,107 @@
+package wiring_test
+
+import (
+	"maps"
+	"testing"
+
+	"bluetooth/wiring"
+)
+
+func TestParseWiring(t *testing.T) {
+	for _, tc := range []struct {
+		name, file string
+		want       map[uint8]string
+		err        bool
+	}{
+		{name: "separators", file: "# greenhouse controller\n\nGPIO25  vent fan\n34: air temperature\nIO35 = soil moisture\n26\tpump\n",
+			want: map[uint8]string{25: "vent fan", 34: "air temperature", 35: "soil moisture", 26: "pump"}},
+		{name: "case and padding", file: "  gpio4 :  door  \r\nio5=light\n",
+			want: map[uint8]string{4: "door", 5: "light"}},
+		{name: "empty", file: "", want: map[uint8]string{}},
+
+		{name: "not a pin", file: "D4 door\n", err: true},
+		{name: "pin too big", file: "GPIO256 door\n", err: true},
+		{name: "no label", file: "GPIO4\n", err: true},
+		{name: "blank label", file: "GPIO4 : \n", err: true},
+		{name: "wired twice", file: "GPIO4 door\nIO4 window\n", err: true},
+	} {
+		got, err := wiring.ParseWiring([]byte(tc.file))
+		if tc.err {
+			if err == nil {
+				t.Errorf("%s: ParseWiring = %v, want an error", tc.name, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: ParseWiring: %v", tc.name, err)
+		} else if !maps.Equal(got, tc.want) {
+			t.Errorf("%s: ParseWiring = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+// netlist is a KiCad export of an ESP32 module, U1, and a relay, K1.
+const netlist = `(export (version "E")
+  (design (source "greenhouse.kicad_sch") (tool "Eeschema 7.0.10"))
+  (components
+    (comp (ref "U1") (value "ESP32-WROOM-32E")
+      (libsource (lib "RF_Module") (part "ESP32-WROOM-32E") (description "RF Module, ESP32")))
+    (comp (ref "K1") (value "G5LE-1")
+      (libsource (lib "Relay") (part "G5LE-1"))))
+  (nets
+    (net (code "1") (name "/sensors/AIR_TEMP")
+      (node (ref "U1") (pin "6") (pinfunction "IO34") (pintype "input")))
+    (net (code "2") (name "SOIL \"A\"")
+      (node (ref "U1") (pin "4") (pinfunction "SENSOR_VP") (pintype "input")))
+    (net (code "3") (name "VENT_FAN")
+      (node (ref "U1") (pin "10") (pinfunction "IO25") (pintype "bidirectional"))
+      (node (ref "K1") (pin "1") (pinfunction "COIL") (pintype "passive")))
+    (net (code "4") (name "Net-(U1-IO26)")
+      (node (ref "U1") (pin "11") (pinfunction "IO26") (pintype "bidirectional")))
+    (net (code "5") (name "unconnected-(U1-IO27-Pad12)")
+      (node (ref "U1") (pin "12") (pinfunction "IO27") (pintype "bidirectional+no_connect")))
+    (net (code "6") (name "TX")
+      (node (ref "U1") (pin "35") (pinfunction "TXD0/IO1") (pintype "bidirectional")))
+    (net (code "7") (name "GND")
+      (node (ref "U1") (pin "1") (pinfunction "GND") (pintype "power_in"))
+      (node (ref "K1") (pin "2") (pinfunction "COIL") (pintype "passive")))))
+`
+
+func TestParseNetlist(t *testing.T) {
+	want := map[uint8]string{34: "AIR_TEMP", 36: `SOIL "A"`, 25: "VENT_FAN", 1: "TX"}
+	for _, ref := range []string{"", "U1"} {
+		got, err := wiring.Parse([]byte(netlist), ref)
+		if err != nil {
+			t.Errorf("Parse with ref %q: %v", ref, err)
+		} else if !maps.Equal(got, want) {
+			t.Errorf("Parse with ref %q = %v, want %v", ref, got, want)
+		}
+	}
+}
+
+func TestParseNetlistErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name, netlist, ref string
+	}{
+		// Malformed netlists.
+		{name: "ends early", netlist: `(export (version "E") (nets (net (name "A")`},
+		{name: "only the opening", netlist: `(export`},
+		{name: "unterminated string", netlist: `(export (nets (net (name "VENT_FAN))))`},
+		{name: "stray close", netlist: netlist + ")"},
+		{name: "second list", netlist: netlist + `(export (version "E"))`},
+		{name: "not a list", netlist: `export`},
+
+		// Well-formed netlists that don't say what's wired to which GPIO.
+		{name: "no ESP32", netlist: `(export (components (comp (ref "K1") (value "G5LE-1"))) (nets))`},
+		{name: "several ESP32s", netlist: `(export (components
+			(comp (ref "U1") (value "ESP32-WROOM-32E"))
+			(comp (ref "U2") (libsource (part "ESP32-C3-MINI-1")))) (nets))`},
+		{name: "unknown ref", netlist: netlist, ref: "U9"},
+		{name: "KiCad 5", netlist: `(export (components (comp (ref "U1") (value "ESP32-WROOM-32E")))
+			(nets (net (name "VENT_FAN") (node (ref "U1") (pin "10")))))`},
+	} {
+		if got, err := wiring.ParseNetlist([]byte(tc.netlist), tc.ref); err == nil {
+			t.Errorf("%s: ParseNetlist = %v, want an error", tc.name, got)
+		}
+	}
+}
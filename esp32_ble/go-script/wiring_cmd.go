@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"maps"
+	"os"
+	"slices"
+	"text/tabwriter"
+
+	"bluetooth/pinmodel"
+	"bluetooth/wiring"
+)
+
+// runWiring reads pin labels from a wiring file or KiCad netlist and,
+// with --profile, makes them the profile's labels, so pins are renamed
+// in the schematic and nowhere else. Labels of pins the board doesn't
+// have are warned of but kept.
+func runWiring(_ context.Context, args []string) {
+	if len(args) == 0 || args[0] == "-h" || args[0] == "--help" {
+		msg.Println("Usage: wiring FILE [flags]")
+		msg.Println("\nFILE is a KiCad netlist export or a wiring file of \"GPIO25 vent fan\" lines.")
+		os.Exit(1)
+	}
+	source := args[0]
+	fs := flag.NewFlagSet("wiring", flag.ExitOnError)
+	refPtr := fs.String("ref", "", "Reference of the ESP32 in the netlist, e.g. U1 (default: the only ESP32 component)")
+	configPtr := fs.String("config", "", "Config file with device profiles (default ~/"+defaultConfigName+")")
+	profilePtr := fs.String("profile", "", "Profile whose pin labels to replace with the file's; without it they are only shown")
+	boardPtr := fs.String("board", "", "Board variant to check the pins against, if not the profile's (default esp32)")
+	fs.Parse(args[1:])
+
+	data, err := os.ReadFile(source)
+	if err != nil {
+		msg.Printf("❌ Failed to read wiring: %v\n", err)
+		os.Exit(1)
+	}
+	labels, err := wiring.Parse(data, *refPtr)
+	if err != nil {
+		msg.Printf("❌ %s: %v\n", source, err)
+		os.Exit(1)
+	}
+	if len(labels) == 0 {
+		msg.Printf("❌ %s labels no GPIOs\n", source)
+		os.Exit(1)
+	}
+
+	path := configPath(*configPtr)
+	board := *boardPtr
+	if *profilePtr != "" && board == "" {
+		c, err := readConfig(path)
+		if err != nil {
+			msg.Printf("❌ Failed to read config file: %v\n", err)
+			os.Exit(1)
+		}
+		board = c.Profiles[*profilePtr].Board
+	}
+	m := pinModel
+	if board != "" {
+		if m, err = pinmodel.Lookup(board); err != nil {
+			msg.Printf("❌ %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	msg.Fprintln(tw, "GPIO\tLABEL")
+	for _, pin := range slices.Sorted(maps.Keys(labels)) {
+		msg.Fprintf(tw, "%d\t%s\n", pin, labels[pin])
+	}
+	tw.Flush()
+	for _, pin := range slices.Sorted(maps.Keys(labels)) {
+		if !slices.Contains(m.GPIOs, pin) {
+			msg.Printf("⚠️  %s: the %s has no GPIO%d\n", labels[pin], m.Title, pin)
+		}
+	}
+	if *profilePtr == "" {
+		return
+	}
+
+	out, err := replaceProfileKeys(path, *profilePtr, struct {
+		Labels map[uint8]string `yaml:"labels"`
+	}{labels})
+	if err != nil {
+		msg.Printf("❌ %v\n", err)
+		os.Exit(1)
+	}
+	if err := os.WriteFile(path, out, 0o644); err != nil {
+		msg.Printf("❌ Failed to write config file: %v\n", err)
+		os.Exit(1)
+	}
+	msg.Printf("✅ Set %d pin label(s) of profile %q in %s from %s\n", len(labels), *profilePtr, path, source)
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"bluetooth/esp32"
+	"bluetooth/pinmodel"
+)
+
+// runWrite connects to a board and writes its pins once, given as
+// --pin PIN=STATE or, for what those don't cover, as the firmware's
+// pin_writes JSON with --json: inline, from a file as @FILE or from stdin
+// as -. The JSON is checked against the firmware's schema before the
+// board is scanned for, since the firmware ignores documents it can't
+// parse.
+func runWrite(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("write", flag.ExitOnError)
+	namePtr := fs.String("name", "", "Name of the Bluetooth device to write to (required)")
+	timeoutPtr := fs.Int("timeout", 30, "Scan timeout in seconds")
+	var pins stringList
+	fs.Var(&pins, "pin", "Pin write as PIN=STATE, e.g. 14=100 (repeatable)")
+	jsonPtr := fs.String("json", "", `pin_writes JSON to send, e.g. '{"pin_writes":[{"pin_num":14,"state":100}]}', @FILE to read it from a file or - from stdin`)
+	reliable := reliableFlags(fs)
+	fs.Parse(args)
+
+	if *namePtr == "" {
+		msg.Println("Error: --name flag is required")
+		msg.Println("\nUsage:")
+		fs.PrintDefaults()
+		os.Exit(1)
+	}
+	var writes []esp32.PinWrite
+	var err error
+	switch {
+	case len(pins) > 0 && *jsonPtr != "":
+		err = fmt.Errorf("--pin cannot be combined with --json")
+	case len(pins) > 0:
+		writes, err = parsePinWrites(pins)
+	case *jsonPtr != "":
+		writes, err = readPinRequest(*jsonPtr)
+	default:
+		err = fmt.Errorf("nothing to write; give --pin or --json")
+	}
+	if err != nil {
+		msg.Printf("❌ %v\n", err)
+		os.Exit(1)
+	}
+	for _, w := range writes {
+		for _, problem := range pinModel.Check(w.PinNum, pinmodel.Output) {
+			msg.Printf("⚠️  %s\n", problem)
+		}
+	}
+
+	writePolicy = reliable()
+	client := connectDevice(ctx, *namePtr, time.Duration(*timeoutPtr)*time.Second)
+	err = client.WritePins(ctx, writes)
+	client.Disconnect()
+	if err != nil {
+		msg.Printf("❌ %v\n", err)
+		os.Exit(1)
+	}
+	msg.Printf("✅ Wrote %d pin(s)\n", len(writes))
+}
+
+// parsePinWrites parses --pin flags given as PIN=STATE.
+func parsePinWrites(pins []string) ([]esp32.PinWrite, error) {
+	var writes []esp32.PinWrite
+	for _, s := range pins {
+		pinStr, stateStr, ok := strings.Cut(s, "=")
+		pin, err := strconv.ParseUint(pinStr, 10, 8)
+		if !ok || err != nil {
+			return nil, fmt.Errorf("invalid pin write %q (want PIN=STATE)", s)
+		}
+		state, err := strconv.ParseUint(stateStr, 10, 8)
+		if err != nil {
+			return nil, fmt.Errorf("invalid state %q for pin %d", stateStr, pin)
+		}
+		writes = append(writes, esp32.PinWrite{PinNum: uint8(pin), State: uint8(state)})
+	}
+	return writes, nil
+}
+
+// readPinRequest reads and checks the pin_writes JSON given to --json.
+func readPinRequest(arg string) ([]esp32.PinWrite, error) {
+	data := []byte(arg)
+	var err error
+	if arg == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else if file, ok := strings.CutPrefix(arg, "@"); ok {
+		data, err = os.ReadFile(file)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read --json: %w", err)
+	}
+	writes, err := esp32.ParsePinRequest(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pin_writes JSON: %w", err)
+	}
+	return writes, nil
+}